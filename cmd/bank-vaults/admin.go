@@ -0,0 +1,28 @@
+package main
+
+import (
+	"github.com/banzaicloud/bank-vaults/pkg/admin"
+	"github.com/banzaicloud/bank-vaults/pkg/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+const cfgAdminAddr = "admin-addr"
+const cfgAdminDebug = "admin-debug"
+
+// adminServer collects the status of the Vault instances managed by the unseal and configure commands
+var adminServer = admin.NewServer(version)
+
+// serveAdmin serves the health, readiness and status (and the metrics) on --admin-addr in the background, if it is set
+func serveAdmin() {
+	addr := appConfig.GetString(cfgAdminAddr)
+	if addr == "" {
+		return
+	}
+	adminServer.Handle("/metrics", metrics.Handler())
+	if appConfig.GetBool(cfgAdminDebug) {
+		adminServer.HandleDebug()
+	}
+	go func() {
+		logrus.Errorf("error serving admin endpoints: %s", adminServer.ListenAndServe(addr))
+	}()
+}
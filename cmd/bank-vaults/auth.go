@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/sirupsen/logrus"
+)
+
+const cfgAuthMethod = "auth-method"
+const cfgAuthRole = "auth-role"
+const cfgAuthPath = "auth-path"
+const cfgRenewToken = "renew-token"
+
+const (
+	authMethodToken      = "token"
+	authMethodKubernetes = "kubernetes"
+)
+
+// serviceAccountTokenFile is the token of the Pod's ServiceAccount used to log in with the Kubernetes auth method
+const serviceAccountTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// loginVault sets the token of the client for configuring an externally managed Vault,
+// which doesn't have its root token in any of the key stores
+func loginVault(cl *api.Client, method, role, path string) error {
+	switch method {
+	case authMethodToken:
+		// The client has already read VAULT_TOKEN, but it may have been cleared since
+		if cl.Token() == "" {
+			cl.SetToken(os.Getenv(api.EnvVaultToken))
+		}
+		if cl.Token() == "" {
+			return fmt.Errorf("the %s environment variable has to be set with the %s auth method", api.EnvVaultToken, method)
+		}
+		return nil
+
+	case authMethodKubernetes:
+		if role == "" {
+			return fmt.Errorf("a role has to be set with the %s auth method", method)
+		}
+		jwt, err := ioutil.ReadFile(serviceAccountTokenFile)
+		if err != nil {
+			return fmt.Errorf("error reading service account token: %s", err.Error())
+		}
+
+		// Log in without the previous, possibly expired token
+		cl.ClearToken()
+		secret, err := cl.Logical().Write(fmt.Sprintf("auth/%s/login", path), map[string]interface{}{
+			"role": role,
+			"jwt":  string(jwt),
+		})
+		if err != nil {
+			return fmt.Errorf("error logging in to vault: %s", err.Error())
+		}
+		if secret == nil || secret.Auth == nil {
+			return fmt.Errorf("error logging in to vault: no token in response")
+		}
+		cl.SetToken(secret.Auth.ClientToken)
+		return nil
+
+	default:
+		return fmt.Errorf("unsupported auth method: %s", method)
+	}
+}
+
+// renewToken keeps the token of the client renewed within its TTL, and returns when it can't be renewed
+// anymore (e.g. it has reached its max TTL) or renewing it fails
+func renewToken(cl *api.Client) error {
+	secret, err := cl.Auth().Token().RenewSelf(0)
+	if err != nil {
+		return fmt.Errorf("error renewing token: %s", err.Error())
+	}
+	if secret == nil || secret.Auth == nil || !secret.Auth.Renewable {
+		return fmt.Errorf("the token is not renewable")
+	}
+
+	renewer, err := cl.NewRenewer(&api.RenewerInput{Secret: secret})
+	if err != nil {
+		return fmt.Errorf("error creating token renewer: %s", err.Error())
+	}
+	go renewer.Renew()
+	defer renewer.Stop()
+
+	for {
+		select {
+		case err := <-renewer.DoneCh():
+			if err != nil {
+				return fmt.Errorf("error renewing token: %s", err.Error())
+			}
+			return fmt.Errorf("the token can't be renewed anymore")
+		case renewal := <-renewer.RenewCh():
+			logrus.Infof("token renewed, valid for %ds", renewal.Secret.Auth.LeaseDuration)
+		}
+	}
+}
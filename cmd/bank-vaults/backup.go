@@ -0,0 +1,127 @@
+package main
+
+import (
+	"io/ioutil"
+	"strings"
+
+	"github.com/banzaicloud/bank-vaults/pkg/securemem"
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+const cfgPassphraseFile = "passphrase-file"
+const cfgBackupPassphrase = "backup-passphrase"
+const cfgOverwrite = "overwrite"
+
+// restoreOutput is the machine-readable result of the restore command
+type restoreOutput struct {
+	Keys []string `json:"keys"`
+}
+
+var backupCmd = &cobra.Command{
+	Use:   "backup <file>",
+	Short: "Backs up the unseal keys and the root token into a passphrase encrypted file",
+	Long: `It reads the root token, the unseal keys and the recovery keys from the key store and writes
+them into a single file encrypted with a key derived from a passphrase (scrypt, AES-256-GCM),
+for offline escrow. The passphrase is read from --passphrase-file or from the
+BANK_VAULTS_BACKUP_PASSPHRASE environment variable.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		appConfig.BindPFlag(cfgPassphraseFile, cmd.PersistentFlags().Lookup(cfgPassphraseFile))
+
+		passphrase := backupPassphrase()
+		defer securemem.Wipe(passphrase)
+
+		store, err := kvStoreForConfig(appConfig)
+
+		if err != nil {
+			exitWithError(exitCodeKeyStoreError, "error creating kv store: %s", err.Error())
+		}
+
+		bundle, err := vault.BackupKeys(store, keyNamesForConfig(appConfig), passphrase)
+
+		if err != nil {
+			exitWithError(exitCodeKeyStoreError, "error backing up keys: %s", err.Error())
+		}
+
+		if err := ioutil.WriteFile(args[0], bundle, 0600); err != nil {
+			exitWithError(exitCodeError, "error writing backup: %s", err.Error())
+		}
+
+		logrus.Infof("keys of %s backed up to %s", keyStoreLocation(appConfig), args[0])
+	},
+}
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore <file>",
+	Short: "Restores the unseal keys and the root token from a backup file",
+	Long: `It decrypts a file written by the backup command with the passphrase (from --passphrase-file
+or the BANK_VAULTS_BACKUP_PASSPHRASE environment variable), writes its keys to the key store and
+verifies them by reading them back. Keys existing in the key store are only overwritten with
+--overwrite.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		appConfig.BindPFlag(cfgPassphraseFile, cmd.PersistentFlags().Lookup(cfgPassphraseFile))
+		appConfig.BindPFlag(cfgOverwrite, cmd.PersistentFlags().Lookup(cfgOverwrite))
+		appConfig.BindPFlag(cfgOutput, cmd.PersistentFlags().Lookup(cfgOutput))
+
+		output := appConfig.GetString(cfgOutput)
+		checkOutput(output, cfgOutputValueText, cfgOutputValueJSON, cfgOutputValueYAML)
+
+		passphrase := backupPassphrase()
+		defer securemem.Wipe(passphrase)
+
+		bundle, err := ioutil.ReadFile(args[0])
+		if err != nil {
+			exitWithError(exitCodeError, "error reading backup: %s", err.Error())
+		}
+
+		store, err := kvStoreForConfig(appConfig)
+
+		if err != nil {
+			exitWithError(exitCodeKeyStoreError, "error creating kv store: %s", err.Error())
+		}
+
+		ids, err := vault.RestoreKeys(store, bundle, passphrase, appConfig.GetBool(cfgOverwrite))
+
+		if err != nil {
+			exitWithError(exitCodeKeyStoreError, "error restoring keys: %s", err.Error())
+		}
+
+		logrus.Infof("%d keys restored and verified", len(ids))
+
+		if output != cfgOutputValueText {
+			writeOutput(output, restoreOutput{Keys: ids})
+		}
+	},
+}
+
+// backupPassphrase reads the passphrase of the backup file
+func backupPassphrase() []byte {
+	passphrase := appConfig.GetString(cfgBackupPassphrase)
+
+	if passphraseFile := appConfig.GetString(cfgPassphraseFile); passphraseFile != "" {
+		content, err := ioutil.ReadFile(passphraseFile)
+		if err != nil {
+			exitWithError(exitCodeError, "error reading passphrase file: %s", err.Error())
+		}
+		passphrase = strings.TrimRight(string(content), "\r\n")
+	}
+
+	if passphrase == "" {
+		exitWithError(exitCodeError, "a passphrase is required, use --%s or BANK_VAULTS_BACKUP_PASSPHRASE", cfgPassphraseFile)
+	}
+	return []byte(passphrase)
+}
+
+func init() {
+	backupCmd.PersistentFlags().String(cfgPassphraseFile, "", "The file holding the passphrase of the backup")
+
+	restoreCmd.PersistentFlags().String(cfgPassphraseFile, "", "The file holding the passphrase of the backup")
+	restoreCmd.PersistentFlags().Bool(cfgOverwrite, false, "Overwrite the keys existing in the key store")
+	restoreCmd.PersistentFlags().String(cfgOutput, cfgOutputValueText, outputHelp(cfgOutputValueText, cfgOutputValueJSON, cfgOutputValueYAML)+", only logs are written in the "+cfgOutputValueText+" format")
+
+	rootCmd.AddCommand(backupCmd)
+	rootCmd.AddCommand(restoreCmd)
+}
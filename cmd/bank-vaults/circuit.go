@@ -0,0 +1,41 @@
+package main
+
+import (
+	"github.com/banzaicloud/bank-vaults/pkg/circuit"
+	"github.com/sirupsen/logrus"
+)
+
+const cfgCircuitBreakerThreshold = "circuit-breaker-threshold"
+const cfgCircuitBreakerInterval = "circuit-breaker-interval"
+const cfgCircuitBreakerMaxInterval = "circuit-breaker-max-interval"
+
+// newCircuitBreaker returns the circuit breaker of the requests to a backend (vault or the mode of
+// the key store) at endpoint, exposing its state in the metrics, nil if it is disabled
+func newCircuitBreaker(backend, endpoint string) *circuit.Breaker {
+	name := backend
+	if endpoint != "" {
+		name = backend + " at " + endpoint
+	}
+
+	onStateChange := func(state circuit.State) {
+		circuitBreakerState.Set(float64(state), backend, endpoint)
+		log := logrus.WithFields(logrus.Fields{"backend": backend, "endpoint": endpoint})
+		if state == circuit.Open {
+			circuitBreakerOpenedTotal.Inc(backend, endpoint)
+			log.Warnf("circuit breaker %s, the requests fail without being sent until the next probe", state)
+		} else {
+			log.Infof("circuit breaker %s", state)
+		}
+	}
+
+	breaker := circuit.New(
+		name,
+		appConfig.GetInt(cfgCircuitBreakerThreshold),
+		appConfig.GetDuration(cfgCircuitBreakerInterval),
+		appConfig.GetDuration(cfgCircuitBreakerMaxInterval),
+		onStateChange)
+	if breaker != nil {
+		circuitBreakerState.Set(float64(circuit.Closed), backend, endpoint)
+	}
+	return breaker
+}
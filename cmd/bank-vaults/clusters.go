@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/banzaicloud/bank-vaults/pkg/logging"
+	"github.com/banzaicloud/bank-vaults/pkg/tracing"
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/hashicorp/vault/api"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// clusterConfig describes one of the Vault clusters unsealed by a single unsealer, Options holds
+// the same settings as the command line flags (mode, key store settings, secret-shares, init, etc.),
+// the flags of the command are the defaults of every cluster
+type clusterConfig struct {
+	Name          string                 `mapstructure:"name"`
+	Address       string                 `mapstructure:"address"`
+	CACert        string                 `mapstructure:"caCert"`
+	TLSServerName string                 `mapstructure:"tlsServerName"`
+	Options       map[string]interface{} `mapstructure:"options"`
+}
+
+// unsealClusters unseals every Vault cluster listed in the clusters config file concurrently,
+// each cluster has its own key store and state, so a failing cluster doesn't affect the others
+func unsealClusters(clustersConfigFile string) {
+	clustersConfig := viper.New()
+	clustersConfig.SetConfigFile(clustersConfigFile)
+	if err := clustersConfig.ReadInConfig(); err != nil {
+		logrus.Fatalf("error reading clusters config: %s", err.Error())
+	}
+
+	clusters := []clusterConfig{}
+	if err := clustersConfig.UnmarshalKey("clusters", &clusters); err != nil {
+		logrus.Fatalf("error unmarshalling clusters config: %s", err.Error())
+	}
+	if len(clusters) == 0 {
+		logrus.Fatalf("no clusters found in %s", clustersConfigFile)
+	}
+
+	var wg sync.WaitGroup
+	for _, cluster := range clusters {
+		u, err := unsealerForCluster(cluster)
+		if err != nil {
+			logrus.Fatalf("error setting up unsealer of cluster %s: %s", cluster.Name, err.Error())
+		}
+
+		wg.Add(1)
+		go func(u *unsealer) {
+			defer wg.Done()
+			for {
+				u.unseal()
+
+				// wait unsealPeriod before trying again
+				if !sleep(unsealConfig.unsealPeriod) {
+					return
+				}
+			}
+		}(u)
+	}
+
+	logrus.Infof("unsealing %d vault clusters", len(clusters))
+	wg.Wait()
+}
+
+func unsealerForCluster(cluster clusterConfig) (*unsealer, error) {
+	if cluster.Name == "" || cluster.Address == "" {
+		return nil, fmt.Errorf("name and address of the cluster are required")
+	}
+
+	cfg := viperWithOptions(cluster.Options)
+	// The keys of the clusters sharing a key store can be told apart by the cluster name
+	if !cfg.IsSet(cfgClusterName) || cfg.GetString(cfgClusterName) == "" {
+		cfg.Set(cfgClusterName, cluster.Name)
+	}
+
+	// The clusters are unsealed concurrently, each in its own traces
+	tracer := tracing.NewTracer()
+
+	store, err := tracedKVStoreForConfig(cfg, tracer)
+	if err != nil {
+		return nil, fmt.Errorf("error creating kv store: %s", err.Error())
+	}
+
+	vaultConfig, err := vaultConfigForConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error building vault config: %s", err.Error())
+	}
+	vaultConfig.Tracer = tracer
+	vaultConfig.Logger = logging.NewLogrus(logrus.WithField("cluster", cluster.Name))
+
+	endpoint := vault.Endpoint{
+		Address: cluster.Address,
+		// The requests of the cluster are traced by its own tracer
+		Configure: func(config *api.Config) error {
+			instrumentVaultClientConfig(config, tracer)
+			return nil
+		},
+	}
+	if cluster.CACert != "" || cluster.TLSServerName != "" {
+		if appConfig.GetString(cfgVaultTLSSecret) != "" {
+			return nil, fmt.Errorf("caCert and tlsServerName can't be used together with --%s", cfgVaultTLSSecret)
+		}
+		endpoint.TLS = &api.TLSConfig{
+			CACert:        cluster.CACert,
+			TLSServerName: cluster.TLSServerName,
+		}
+	}
+
+	cl, err := vaultClients.Client(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to vault: %s", err.Error())
+	}
+
+	v, err := vault.New(store, cl, vaultConfig)
+	if err != nil {
+		return nil, fmt.Errorf("error creating vault helper: %s", err.Error())
+	}
+
+	locker, err := newLocker(cfg, store, cluster.Name)
+	if err != nil {
+		return nil, fmt.Errorf("error creating lock: %s", err.Error())
+	}
+
+	return &unsealer{
+		vault:       v,
+		log:         logrus.WithField("cluster", cluster.Name),
+		target:      cluster.Name,
+		status:      adminServer.Target(cluster.Name),
+		lock:        locker,
+		proceedInit: cfg.GetBool(cfgInit),
+	}, nil
+}
+
+// viperWithOptions returns the given settings (e.g. of a cluster), falling back to the command line flags
+func viperWithOptions(options map[string]interface{}) *viper.Viper {
+	cfg := viper.New()
+	for _, key := range appConfig.AllKeys() {
+		cfg.SetDefault(key, appConfig.Get(key))
+	}
+	for key, value := range cast.ToStringMap(options) {
+		cfg.Set(key, value)
+	}
+	return cfg
+}
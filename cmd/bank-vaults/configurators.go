@@ -0,0 +1,27 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/sirupsen/logrus"
+)
+
+const cfgConfiguratorPlugins = "configurator-plugins"
+
+// loadConfiguratorPlugins registers the configurators of the Go plugins, so their sections of the
+// Vault configuration are verified and applied by every command
+func loadConfiguratorPlugins() {
+	plugins := appConfig.GetString(cfgConfiguratorPlugins)
+	if plugins == "" {
+		return
+	}
+	for _, path := range strings.Split(plugins, ",") {
+		if err := vault.LoadConfiguratorPlugin(strings.TrimSpace(path)); err != nil {
+			logrus.Fatalf("invalid --%s: %s", cfgConfiguratorPlugins, err.Error())
+		}
+	}
+	for _, configurator := range vault.Configurators() {
+		logrus.Debugf("configurator of the %s section is registered", configurator.Section())
+	}
+}
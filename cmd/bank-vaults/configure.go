@@ -2,157 +2,647 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
 	"path"
-	"path/filepath"
+	"strings"
+	"sync"
 	"text/template"
 	"time"
 
 	"github.com/Masterminds/sprig"
+	"github.com/banzaicloud/bank-vaults/pkg/backoff"
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/banzaicloud/bank-vaults/pkg/logging"
+	"github.com/banzaicloud/bank-vaults/pkg/notify"
 	"github.com/banzaicloud/bank-vaults/pkg/vault"
 	"github.com/fsnotify/fsnotify"
 	"github.com/hashicorp/vault/api"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"k8s.io/apimachinery/pkg/types"
 )
 
 const cfgVaultConfigFile = "vault-config-file"
+const cfgVaultConfigValues = "vault-config-values"
+
+// vaultConfigFileHelp is the description of the --vault-config-file flag of the commands
+const vaultConfigFileHelp = "The filename of the YAML/JSON/HCL Vault configuration (by its extension), or a comma separated list of files and directories of them, merged in order"
+const cfgConfigurePeriod = "configure-period"
+const cfgFatal = "fatal"
+const cfgStrictConfig = "strict-config"
+const cfgUnsealWaitTimeout = "unseal-wait-timeout"
+const cfgReadBack = "read-back"
+const cfgReadBackSample = "read-back-sample"
+const cfgPurgeUnmanaged = "purge-unmanaged"
+const cfgTrackConfigState = "track-config-state"
+const cfgPurgeProtected = "purge-protected"
+const cfgDryRun = "dry-run"
+const cfgConfigureConcurrency = "configure-concurrency"
+const cfgVaultRateLimit = "vault-rate-limit"
+const cfgVaultRateBurst = "vault-rate-burst"
+
+// configureFailed is true if the last configuration failed, so the recovery is notified
+var configureFailed bool
 
 var configureCmd = &cobra.Command{
 	Use:   "configure",
 	Short: "Configures a Vault based on a YAML/JSON configuration file",
 	Long: `This configuration is an extension to what is available through the Vault configuration:
 			https://www.vaultproject.io/docs/configuration/index.html. With this it is possible to
-			configure secret engines, auth methods, etc...`,
+			configure secret engines, auth methods, etc... It can configure Vault only once
+			(e.g. in a Job or a CI pipeline) or keep it configured continuously.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		appConfig.BindPFlag(cfgUnsealPeriod, cmd.PersistentFlags().Lookup(cfgUnsealPeriod))
 		appConfig.BindPFlag(cfgVaultConfigFile, cmd.PersistentFlags().Lookup(cfgVaultConfigFile))
+		appConfig.BindPFlag(cfgVaultConfigValues, cmd.PersistentFlags().Lookup(cfgVaultConfigValues))
+		appConfig.BindPFlag(cfgRunMode, cmd.PersistentFlags().Lookup(cfgRunMode))
+		appConfig.BindPFlag(cfgConfigurePeriod, cmd.PersistentFlags().Lookup(cfgConfigurePeriod))
+		appConfig.BindPFlag(cfgFatal, cmd.PersistentFlags().Lookup(cfgFatal))
+		appConfig.BindPFlag(cfgStrictConfig, cmd.PersistentFlags().Lookup(cfgStrictConfig))
+		appConfig.BindPFlag(cfgUnsealWaitTimeout, cmd.PersistentFlags().Lookup(cfgUnsealWaitTimeout))
+		appConfig.BindPFlag(cfgReadBack, cmd.PersistentFlags().Lookup(cfgReadBack))
+		appConfig.BindPFlag(cfgReadBackSample, cmd.PersistentFlags().Lookup(cfgReadBackSample))
+		appConfig.BindPFlag(cfgPurgeUnmanaged, cmd.PersistentFlags().Lookup(cfgPurgeUnmanaged))
+		appConfig.BindPFlag(cfgPurgeProtected, cmd.PersistentFlags().Lookup(cfgPurgeProtected))
+		appConfig.BindPFlag(cfgTrackConfigState, cmd.PersistentFlags().Lookup(cfgTrackConfigState))
+		appConfig.BindPFlag(cfgConfigureConcurrency, cmd.PersistentFlags().Lookup(cfgConfigureConcurrency))
+		appConfig.BindPFlag(cfgVaultRateLimit, cmd.PersistentFlags().Lookup(cfgVaultRateLimit))
+		appConfig.BindPFlag(cfgVaultRateBurst, cmd.PersistentFlags().Lookup(cfgVaultRateBurst))
+		appConfig.BindPFlag(cfgTokenReviewerServiceAccount, cmd.PersistentFlags().Lookup(cfgTokenReviewerServiceAccount))
+		appConfig.BindPFlag(cfgTokenReviewerAudience, cmd.PersistentFlags().Lookup(cfgTokenReviewerAudience))
+		appConfig.BindPFlag(cfgTokenReviewerExpiration, cmd.PersistentFlags().Lookup(cfgTokenReviewerExpiration))
+		appConfig.BindPFlag(cfgKubernetesAuthKubeconfig, cmd.PersistentFlags().Lookup(cfgKubernetesAuthKubeconfig))
+		appConfig.BindPFlag(cfgKubernetesAuthContext, cmd.PersistentFlags().Lookup(cfgKubernetesAuthContext))
+		appConfig.BindPFlag(cfgAuthMethod, cmd.PersistentFlags().Lookup(cfgAuthMethod))
+		appConfig.BindPFlag(cfgAuthRole, cmd.PersistentFlags().Lookup(cfgAuthRole))
+		appConfig.BindPFlag(cfgAuthPath, cmd.PersistentFlags().Lookup(cfgAuthPath))
+		appConfig.BindPFlag(cfgRenewToken, cmd.PersistentFlags().Lookup(cfgRenewToken))
+		appConfig.BindPFlag(cfgDryRun, cmd.PersistentFlags().Lookup(cfgDryRun))
+		appConfig.BindPFlag(cfgOutput, cmd.PersistentFlags().Lookup(cfgOutput))
+		bindConfigHistoryFlags(cmd)
 
 		unsealConfig.unsealPeriod = appConfig.GetDuration(cfgUnsealPeriod)
 		vaultConfigFile := appConfig.GetString(cfgVaultConfigFile)
+		configurePeriod := appConfig.GetDuration(cfgConfigurePeriod)
+		fatal := appConfig.GetBool(cfgFatal)
+		unsealWaitTimeout := appConfig.GetDuration(cfgUnsealWaitTimeout)
+		authMethod := appConfig.GetString(cfgAuthMethod)
+		authRole := appConfig.GetString(cfgAuthRole)
+		authPath := appConfig.GetString(cfgAuthPath)
+		dryRun := appConfig.GetBool(cfgDryRun)
 
-		store, err := kvStoreForConfig(appConfig)
+		if dryRun {
+			checkOutput(appConfig.GetString(cfgOutput), cfgOutputValueUnified, cfgOutputValueJSON, cfgOutputValueYAML)
+		} else {
+			serveMetrics()
+			adminServer.Target("").RequireConfiguration()
+			serveAdmin()
+		}
 
-		if err != nil {
-			logrus.Fatalf("error creating kv store: %s", err.Error())
+		// An externally managed Vault is configured with the token of the auth method,
+		// the root token is read from the key store otherwise
+		var store kv.Service
+		var err error
+		if authMethod == "" {
+			store, err = kvStoreForConfig(appConfig)
+
+			if err != nil {
+				exitWithError(exitCodeKeyStoreError, "error creating kv store: %s", err.Error())
+			}
 		}
 
-		cl, err := api.NewClient(nil)
+		cl, err := newVaultClient()
 
 		if err != nil {
 			logrus.Fatalf("error connecting to vault: %s", err.Error())
 		}
 
+		if appConfig.GetBool(cfgRenewToken) && !dryRun {
+			if authMethod != authMethodToken {
+				logrus.Fatalf("--%s can be used only with --%s %s", cfgRenewToken, cfgAuthMethod, authMethodToken)
+			}
+			if err := loginVault(cl, authMethod, authRole, authPath); err != nil {
+				logrus.Fatalf("error authenticating to vault: %s", err.Error())
+			}
+			// Vault may be sealed or unreachable for a while
+			go func() {
+				for {
+					logrus.Errorf("%s, trying again in %s...", renewToken(cl).Error(), unsealConfig.unsealPeriod)
+					time.Sleep(unsealConfig.unsealPeriod)
+				}
+			}()
+		}
+
 		vaultConfig, err := vaultConfigForConfig(appConfig)
 
 		if err != nil {
 			logrus.Fatalf("error building vault config: %s", err.Error())
 		}
 
-		v, err := vault.New(store, cl, vaultConfig)
+		if kubeconfig := appConfig.GetString(cfgKubernetesAuthKubeconfig); kubeconfig != "" {
+			vaultConfig.KubernetesAuth, err = kubernetesAuthForKubeconfig(kubeconfig, appConfig.GetString(cfgKubernetesAuthContext))
+			if err != nil {
+				logrus.Fatalf("error building the kubernetes auth config: %s", err.Error())
+			}
+		}
 
-		if err != nil {
-			logrus.Fatalf("error creating vault helper: %s", err.Error())
+		if dryRun {
+			dryRunConfigure(cl, store, vaultConfig, vaultConfigFile)
+			return
 		}
 
-		parseConfiguration := func() {
-			configTemplate := template.Must(
-				template.New(path.Base(vaultConfigFile)).
-					Funcs(sprig.TxtFuncMap()).
-					Delims("${", "}").
-					ParseFiles(vaultConfigFile))
+		vaultConfig.History, err = newConfigHistory(appConfig, cl, store)
 
-			buffer := bytes.NewBuffer(nil)
+		if err != nil {
+			logrus.Fatalf("error creating configuration history: %s", err.Error())
+		}
+		vaultConfig.HistoryActor = configActor()
 
-			err := configTemplate.Execute(buffer, nil)
-			if err != nil {
-				logrus.Fatalf("error executing vault config template: %s", err.Error())
-			}
+		c := make(chan fsnotify.Event, 1)
 
-			err = viper.ReadConfig(buffer)
+		if appConfig.GetString(cfgTokenReviewerServiceAccount) != "" {
+			vaultConfig.TokenReviewerJWTFile = tokenReviewerJWTFile()
+			// The Kubernetes auth method has to be reconfigured with every new token
+			err = refreshTokenReviewerJWT(vaultConfig.TokenReviewerJWTFile, func() {
+				c <- fsnotify.Event{Name: "TokenReviewerJWT", Op: fsnotify.Write}
+			})
 			if err != nil {
-				logrus.Fatalf("error reading vault config file: %s", err.Error())
+				logrus.Fatalf("error requesting token reviewer jwt: %s", err.Error())
 			}
 		}
 
-		c := make(chan fsnotify.Event, 1)
-		viper.SetConfigFile(vaultConfigFile)
-		go func() {
-			watcher, err := fsnotify.NewWatcher()
+		v, err := vault.New(store, cl, vaultConfig)
+
+		if err != nil {
+			logrus.Fatalf("error creating vault helper: %s", err.Error())
+		}
+
+		locker, err := newLocker(appConfig, store, "")
+
+		if err != nil {
+			logrus.Fatalf("error creating lock: %s", err.Error())
+		}
+
+		// The configuration is parsed again by the watcher when the file changes
+		var externalConfig *vault.ExternalConfig
+		var externalConfigMu sync.Mutex
+		parseConfiguration := func() {
+			config, err := parseVaultConfig(vaultConfigFile)
 			if err != nil {
-				logrus.Fatal(err)
+				logrus.Fatal(err.Error())
 			}
-			defer watcher.Close()
+			externalConfigMu.Lock()
+			externalConfig = config
+			externalConfigMu.Unlock()
+		}
 
-			// we have to watch the entire directory to pick up renames/atomic saves in a cross-platform way
-			configFile := filepath.Clean(vaultConfigFile)
-			configDir, _ := filepath.Split(configFile)
+		// configure waits until vault is unsealed and configures it with the current configuration
+		configure := func() error {
+			for {
+				if vault.ConfigurePaused() {
+					logrus.Infof("configuration is paused, waiting %s before trying again...", unsealConfig.unsealPeriod)
+					if !sleep(unsealConfig.unsealPeriod) {
+						return errShuttingDown
+					}
+					continue
+				}
 
-			done := make(chan bool)
-			go func() {
-				for {
-					select {
-					case event := <-watcher.Events:
-						// we only care about the config file or the ConfigMap directory (if in Kubernetes)
-						if filepath.Clean(event.Name) == configFile || filepath.Base(event.Name) == "..data" {
-							if event.Op&fsnotify.Write == fsnotify.Write || event.Op&fsnotify.Create == fsnotify.Create {
-								err := viper.ReadInConfig()
-								if err != nil {
-									logrus.Println("error:", err)
-								}
-								parseConfiguration()
-								c <- event
-							}
-						}
-					case err := <-watcher.Errors:
-						logrus.Println("error:", err)
+				logrus.Infof("checking if vault is sealed...")
+				err := backoff.Wait(shutdownContext, backoff.Exponential{InitialInterval: time.Second, MaxInterval: unsealConfig.unsealPeriod, MaxWait: unsealWaitTimeout}, func() (bool, error) {
+					sealed, err := v.Sealed(shutdownContext)
+					return !sealed, err
+				}, func(err error, next time.Duration) {
+					if err != nil {
+						logrus.Errorf("error checking if vault is sealed: %s, checking again in %s...", err.Error(), next)
+					} else {
+						logrus.Infof("vault is sealed, checking again in %s...", next)
 					}
+				})
+				if err == backoff.ErrTimeout {
+					err = fmt.Errorf("vault hasn't been unsealed within %s", unsealWaitTimeout)
+					reportConfigureResult(configFileHash(vaultConfigFile), nil, err)
+					return err
+				} else if err != nil {
+					return errShuttingDown
 				}
-			}()
+				logrus.Infof("vault is not sealed, configuring...")
+
+				configHash := configFileHash(vaultConfigFile)
+
+				if authMethod != "" {
+					if err = loginVault(cl, authMethod, authRole, authPath); err != nil {
+						err = fmt.Errorf("error authenticating to vault: %s", err.Error())
+						reportConfigureResult(configHash, nil, err)
+						return err
+					}
+				}
+
+				externalConfigMu.Lock()
+				config := externalConfig
+				externalConfigMu.Unlock()
+
+				var report *vault.ConfigureReport
+				err = withLock(locker, logrus.StandardLogger(), "configure", func() (err error) {
+					report, err = v.ConfigureWithReport(config)
+					return err
+				})
+				logConfigureReport(report)
+				if report != nil && report.ReadBack != nil {
+					configureDriftResources.Set(float64(len(report.ReadBack.Mismatches)))
+				}
+				if err != nil {
+					err = fmt.Errorf("error configuring vault: %s", err.Error())
+					reportConfigureResult(configHash, report, err)
+					return err
+				}
+
+				logrus.Infof("successfully configured vault")
+				reportConfigureResult(configHash, report, nil)
+				return nil
+			}
+		}
 
-			watcher.Add(configDir)
-			<-done
-		}()
 		parseConfiguration()
 
-		c <- fsnotify.Event{Name: "Initial", Op: fsnotify.Create}
+		if runOnce() {
+			if err := configure(); err != nil {
+				logrus.Fatal(err.Error())
+			}
+			return
+		}
 
-		for e := range c {
-			logrus.Infoln("New config file change", e.String())
-			func() {
-				for {
-					logrus.Infof("checking if vault is sealed...")
-					sealed, err := v.Sealed()
-					if err != nil {
-						logrus.Errorf("error checking if vault is sealed: %s, waiting %s before trying again...", err.Error(), unsealConfig.unsealPeriod)
-						time.Sleep(unsealConfig.unsealPeriod)
-						continue
-					}
+		// The configuration file changes and the periodic reconciliations reapply the configuration,
+		// the latter to revert the changes made by hand
+		changes, err := vault.NotifyConfigFilesChanges(shutdownContext, vaultConfigPaths(vaultConfigFile), configurePeriod)
+		if err != nil {
+			logrus.Fatalf("error watching vault config file: %s", err.Error())
+		}
+		go func() {
+			for event := range changes {
+				if event.Name != vault.ConfigChangePeriodic {
+					parseConfiguration()
+				}
+				c <- event
+			}
+		}()
 
-					// If vault is not sealed, we stop here and wait another unsealPeriod
-					if sealed {
-						logrus.Infof("vault is sealed, waiting %s before trying again...", unsealConfig.unsealPeriod)
-						time.Sleep(unsealConfig.unsealPeriod)
-						continue
-					}
-					logrus.Infof("vault is not sealed, configuring...")
+		// The raft snapshots are taken between the configurations, so the root token isn't cleared
+		// from the client while it is configured
+		var snapshots <-chan time.Time
+		raftConfig, err := externalConfig.Raft()
+		if err != nil {
+			logrus.Fatal(err.Error())
+		}
+		if raftConfig != nil && raftConfig.Snapshots != nil {
+			period, _ := raftConfig.Snapshots.PeriodDuration()
+			ticker := time.NewTicker(period)
+			defer ticker.Stop()
+			snapshots = ticker.C
+			logrus.Infof("taking raft snapshots every %s", period)
+		}
+
+		c <- fsnotify.Event{Name: "Initial", Op: fsnotify.Create}
 
-					if err = v.Configure(); err != nil {
-						logrus.Errorf("error configuring vault: %s", err.Error())
+		for {
+			select {
+			case <-shutdownContext.Done():
+				return
+			case now := <-snapshots:
+				saveScheduledSnapshot(v, raftConfig.Snapshots, now)
+			case e := <-c:
+				logrus.Infoln("New config file change", e.String())
+				if err := configure(); err != nil {
+					if shuttingDown() {
 						return
 					}
-
-					logrus.Infof("successfully configured vault")
-					return
+					if fatal {
+						logrus.Fatal(err.Error())
+					}
+					logrus.Error(err.Error())
 				}
-			}()
+			}
 		}
 	},
 }
 
+// dryRunConfigure prints the changes the configuration would apply to Vault without applying
+// anything, so they can be reviewed before they reach the cluster
+func dryRunConfigure(cl *api.Client, store kv.Service, vaultConfig vault.Config, vaultConfigFile string) {
+	if authMethod := appConfig.GetString(cfgAuthMethod); authMethod != "" {
+		if err := loginVault(cl, authMethod, appConfig.GetString(cfgAuthRole), appConfig.GetString(cfgAuthPath)); err != nil {
+			logrus.Fatalf("error authenticating to vault: %s", err.Error())
+		}
+	}
+
+	externalConfig, err := parseVaultConfig(vaultConfigFile)
+	if err != nil {
+		logrus.Fatal(err.Error())
+	}
+
+	v, err := vault.New(store, cl, vaultConfig)
+	if err != nil {
+		logrus.Fatalf("error creating vault helper: %s", err.Error())
+	}
+
+	changes, err := v.Plan(externalConfig)
+	if err != nil {
+		logrus.Fatalf("error planning vault configuration: %s", err.Error())
+	}
+
+	printConfigChanges(appConfig.GetString(cfgOutput), changes)
+	logrus.Infof("dry run, %d changes haven't been applied", len(changes))
+}
+
+// purgeProtectedForConfig returns the paths of Vault which are never purged: the ones of the flag,
+// the auth method the configurer logs in with, and the secret engine of the configuration history
+func purgeProtectedForConfig(cfg *viper.Viper) []string {
+	var protected []string
+	for _, path := range strings.Split(cfg.GetString(cfgPurgeProtected), ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			protected = append(protected, path)
+		}
+	}
+	if cfg.GetString(cfgAuthMethod) != "" {
+		protected = append(protected, "sys/auth/"+strings.Trim(cfg.GetString(cfgAuthPath), "/"))
+	}
+	if cfg.GetString(cfgConfigHistory) == cfgConfigHistoryValueVault {
+		mount := strings.SplitN(strings.Trim(cfg.GetString(cfgConfigHistoryVaultPath), "/"), "/", 2)[0]
+		protected = append(protected, "sys/mounts/"+mount)
+	}
+	return protected
+}
+
+// logConfigureReport logs the resources which couldn't be applied, one by one, so they can be found
+// easily in the logs of a large configuration, and the summary of the resources
+func logConfigureReport(report *vault.ConfigureReport) {
+	if report == nil {
+		return
+	}
+	for _, result := range report.Resources {
+		fields := logrus.Fields{"kind": result.Kind, "name": result.Name, "action": result.Action, "duration": result.Duration}
+		if result.Error != "" {
+			logrus.WithFields(fields).Error(result.Error)
+		} else {
+			logrus.WithFields(fields).Debug("resource applied")
+		}
+	}
+	logrus.Infof("configured %d resources: %s", len(report.Resources), report.Summary())
+}
+
+// vaultConfigTemplateData is the data of the Vault configuration file template, the values
+// file given with --vault-config-values and the environment variables
+type vaultConfigTemplateData struct {
+	Values map[string]interface{}
+	Env    map[string]string
+}
+
+// vaultConfigKeyStore is the key store read by the kv function of the Vault configuration template,
+// it is created when the function is first called, so the templates not using it need no key store
+var vaultConfigKeyStore struct {
+	once  sync.Once
+	store kv.Service
+	err   error
+}
+
+// vaultConfigKV returns the value of the key in the key store given by the flags (--mode, etc.), it
+// is the kv function of the Vault configuration template, e.g. ${ kv "github-token" | quote }, so the
+// secrets of the configuration don't have to be stored in plain text. The values are redacted from
+// the logs.
+func vaultConfigKV(key string) (string, error) {
+	vaultConfigKeyStore.once.Do(func() {
+		vaultConfigKeyStore.store, vaultConfigKeyStore.err = kvStoreForConfig(appConfig)
+	})
+	if vaultConfigKeyStore.err != nil {
+		return "", fmt.Errorf("error creating kv store: %s", vaultConfigKeyStore.err.Error())
+	}
+
+	value, err := vaultConfigKeyStore.store.Get(key)
+	if err != nil {
+		return "", fmt.Errorf("unable to get key '%s': %s", key, err.Error())
+	}
+	logging.RegisterSecret(string(value))
+	return string(value), nil
+}
+
+// renderVaultConfig executes the template of the Vault configuration file
+func renderVaultConfig(vaultConfigFile string) ([]byte, error) {
+	configTemplate, err := template.New(path.Base(vaultConfigFile)).
+		Funcs(sprig.TxtFuncMap()).
+		Funcs(template.FuncMap{"kv": vaultConfigKV}).
+		Delims("${", "}").
+		ParseFiles(vaultConfigFile)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing vault config template: %s", err.Error())
+	}
+
+	data := vaultConfigTemplateData{
+		Values: map[string]interface{}{},
+		Env:    map[string]string{},
+	}
+	for _, env := range os.Environ() {
+		pair := strings.SplitN(env, "=", 2)
+		data.Env[pair[0]] = pair[1]
+	}
+	if valuesFile := appConfig.GetString(cfgVaultConfigValues); valuesFile != "" {
+		values := viper.New()
+		values.SetConfigFile(valuesFile)
+		if err := values.ReadInConfig(); err != nil {
+			return nil, fmt.Errorf("error reading vault config values: %s", err.Error())
+		}
+		data.Values = values.AllSettings()
+	}
+
+	buffer := bytes.NewBuffer(nil)
+
+	err = configTemplate.Execute(buffer, data)
+	if err != nil {
+		return nil, fmt.Errorf("error executing vault config template: %s", err.Error())
+	}
+	return buffer.Bytes(), nil
+}
+
+// vaultConfigPaths returns the files and directories of the comma separated --vault-config-file
+func vaultConfigPaths(vaultConfigFile string) []string {
+	var paths []string
+	for _, path := range strings.Split(vaultConfigFile, ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// readVaultConfig executes the templates of the Vault configuration files (of the directories too),
+// merges them and reads the result into cfg, with the overrides of the environment
+func readVaultConfig(vaultConfigFile string, cfg *viper.Viper) error {
+	files, err := vault.ConfigFiles(vaultConfigPaths(vaultConfigFile))
+	if err != nil {
+		return err
+	}
+
+	var fragments []vault.ConfigFragment
+	for _, file := range files {
+		config, err := renderVaultConfig(file)
+		if err != nil {
+			return err
+		}
+		fileConfig := viper.New()
+		fileConfig.SetConfigFile(file)
+		err = fileConfig.ReadConfig(bytes.NewReader(config))
+		if err != nil {
+			return fmt.Errorf("error reading vault config file %s: %s", file, err.Error())
+		}
+		fragments = append(fragments, vault.ConfigFragment{Source: file, Settings: fileConfig.AllSettings()})
+	}
+
+	settings, err := vault.MergeConfigFragments(fragments)
+	if err != nil {
+		return fmt.Errorf("error merging vault config files: %s", err.Error())
+	}
+
+	// The VAULT_CONFIG_* environment variables override the fields of the files
+	settings, err = vault.OverrideConfigFromEnv(settings, os.Environ())
+	if err != nil {
+		return err
+	}
+	for section, value := range settings {
+		cfg.Set(section, value)
+	}
+	return nil
+}
+
+// parseVaultConfig reads the Vault configuration file (executing it as a template) and parses it
+func parseVaultConfig(vaultConfigFile string) (*vault.ExternalConfig, error) {
+	cfg := viper.New()
+	if err := readVaultConfig(vaultConfigFile, cfg); err != nil {
+		return nil, err
+	}
+	return vault.ParseExternalConfig(cfg.AllSettings())
+}
+
+// configFileHash returns the hash of the content of the Vault configuration files, in order
+func configFileHash(vaultConfigFile string) string {
+	files, err := vault.ConfigFiles(vaultConfigPaths(vaultConfigFile))
+	if err != nil {
+		logrus.Errorf("error reading vault config file: %s", err.Error())
+		return ""
+	}
+	hash := sha256.New()
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			logrus.Errorf("error reading vault config file: %s", err.Error())
+			return ""
+		}
+		hash.Write(content)
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil))
+}
+
+// reportConfigureResult counts the outcome of the last configuration in the metrics, reports it on the
+// admin server and annotates the configurer's Pod (if running in Kubernetes) with it, so the operator can pick it up
+func reportConfigureResult(configHash string, report *vault.ConfigureReport, configureErr error) {
+	lastConfigureTimestamp.Set(float64(time.Now().Unix()))
+	if report != nil {
+		for _, failed := range report.Failed() {
+			configureFailedResourcesTotal.Inc(failed.Kind)
+		}
+	}
+	if configureErr != nil {
+		configureRunsTotal.Inc(metricsResultFailure)
+		notifyEvent(notify.EventConfigureFailed, "", "vault configuration failed", configureErr)
+	} else {
+		configureRunsTotal.Inc(metricsResultSuccess)
+		lastConfigureSuccessTimestamp.Set(float64(time.Now().Unix()))
+		if configureFailed {
+			notifyEvent(notify.EventConfigured, "", "vault has been configured after a failure", nil)
+		}
+	}
+	configureFailed = configureErr != nil
+	adminServer.Target("").ReportConfigured(configureErr)
+
+	podName := os.Getenv("POD_NAME")
+	podNamespace := os.Getenv("POD_NAMESPACE")
+	if podName == "" || podNamespace == "" {
+		return
+	}
+
+	result := vault.ConfigureResultSuccess
+	if configureErr != nil {
+		result = configureErr.Error()
+	}
+
+	// The summary of a configuration which couldn't be started is removed
+	var summary interface{}
+	if report != nil {
+		summaryJSON, err := json.Marshal(report.Summary())
+		if err != nil {
+			logrus.Errorf("error marshaling configure summary: %s", err.Error())
+			return
+		}
+		summary = string(summaryJSON)
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{
+				vault.ConfigHashAnnotation:       configHash,
+				vault.ConfigureResultAnnotation:  result,
+				vault.ConfigureSummaryAnnotation: summary,
+			},
+		},
+	})
+	if err != nil {
+		logrus.Errorf("error creating pod annotation patch: %s", err.Error())
+		return
+	}
+
+	k8s, err := kubernetesClient()
+	if err != nil {
+		logrus.Errorf("error reporting configure result: %s", err.Error())
+		return
+	}
+
+	_, err = k8s.CoreV1().Pods(podNamespace).Patch(podName, types.MergePatchType, patch)
+	if err != nil {
+		logrus.Errorf("error reporting configure result: %s", err.Error())
+	}
+}
+
 func init() {
 	configureCmd.PersistentFlags().Duration(cfgUnsealPeriod, time.Second*30, "How often to attempt to unseal the Vault instance")
-	configureCmd.PersistentFlags().String(cfgVaultConfigFile, vault.DefaultConfigFile, "The filename of the YAML/JSON Vault configuration")
+	configureCmd.PersistentFlags().String(cfgVaultConfigFile, vault.DefaultConfigFile, vaultConfigFileHelp)
+	configureCmd.PersistentFlags().String(cfgVaultConfigValues, "", "A YAML/JSON file with the values of the Vault configuration template (.Values)")
+	configureCmd.PersistentFlags().String(cfgRunMode, cfgRunModeValueWatch, "Configure Vault only once and exit with the result ("+cfgRunModeValueOnce+"), or whenever the configuration file changes ("+cfgRunModeValueWatch+")")
+	configureCmd.PersistentFlags().Duration(cfgConfigurePeriod, 0, "How often to reapply the configuration in watch mode besides the configuration file changes, never if 0")
+	configureCmd.PersistentFlags().Duration(cfgUnsealWaitTimeout, 0, "How long to wait for Vault to be unsealed before a configuration fails, 0 means forever")
+	configureCmd.PersistentFlags().Bool(cfgFatal, false, "Exit on configuration errors in watch mode instead of waiting for the next change")
+	configureCmd.PersistentFlags().Bool(cfgStrictConfig, false, "Refuse to apply a configuration with unknown fields or wrong types instead of ignoring them")
+	configureCmd.PersistentFlags().Bool(cfgReadBack, true, "Read back the applied resources from Vault after every successful configuration, and log the ones which differ from the configuration")
+	configureCmd.PersistentFlags().Int(cfgReadBackSample, 0, "Read back only a random sample of this many resources after every configuration, all of them if 0")
+	configureCmd.PersistentFlags().Bool(cfgPurgeUnmanaged, false, "Delete the auth methods, secret engines and policies which aren't in the configuration from Vault after every successful configuration (except for the built-in ones)")
+	configureCmd.PersistentFlags().Bool(cfgTrackConfigState, false, "Keep the state of the generic configuration of the secret engines in the key store, so the unchanged entries are written only if they have drifted and the removed ones are deleted")
+	configureCmd.PersistentFlags().String(cfgPurgeProtected, "", "Comma-separated list of the auth methods, secret engines and policies never deleted by --"+cfgPurgeUnmanaged+", e.g. sys/auth/kubernetes,sys/mounts/secret,sys/policy/admin")
+	configureCmd.PersistentFlags().Int(cfgConfigureConcurrency, 1, "How many policies, auth roles, mappings and users are written to Vault at a time")
+	configureCmd.PersistentFlags().Float64(cfgVaultRateLimit, 0, "The maximum number of requests per second sent to Vault, unlimited if 0")
+	configureCmd.PersistentFlags().Int(cfgVaultRateBurst, 1, "The number of requests sent to Vault at once above --"+cfgVaultRateLimit)
+	configureCmd.PersistentFlags().String(cfgTokenReviewerServiceAccount, "", "The ServiceAccount (in POD_NAMESPACE) to request short-lived token reviewer JWTs for the Kubernetes auth method with the TokenRequest API, instead of using the Pod's own token")
+	configureCmd.PersistentFlags().String(cfgTokenReviewerAudience, "", "The audience of the requested token reviewer JWTs (the API server's default if empty)")
+	configureCmd.PersistentFlags().Duration(cfgTokenReviewerExpiration, time.Hour, "The lifetime of the requested token reviewer JWTs")
+	configureCmd.PersistentFlags().String(cfgKubernetesAuthKubeconfig, "", "A kubeconfig whose cluster the Kubernetes auth methods are configured with from outside of it (its API server, CA certificate and the token of the user as the token reviewer JWT), instead of the cluster of the Pod")
+	configureCmd.PersistentFlags().String(cfgKubernetesAuthContext, "", "The context of --"+cfgKubernetesAuthKubeconfig+", its current context if empty")
+	configureCmd.PersistentFlags().String(cfgAuthMethod, "", "How to authenticate to an externally managed Vault instead of using the root token from the key store ["+authMethodToken+", "+authMethodKubernetes+"]")
+	configureCmd.PersistentFlags().String(cfgAuthRole, "", "The role to log in with when using the kubernetes auth method")
+	configureCmd.PersistentFlags().String(cfgAuthPath, "kubernetes", "The mount path of the auth method to log in with")
+	addConfigHistoryFlags(configureCmd)
+	configureCmd.PersistentFlags().Bool(cfgRenewToken, false, "Keep the token of the "+authMethodToken+" auth method renewed within its TTL")
+	configureCmd.PersistentFlags().Bool(cfgDryRun, false, "Print the changes the configuration would apply to Vault (creates, updates and purges) and exit without applying anything")
+	configureCmd.PersistentFlags().String(cfgOutput, cfgOutputValueUnified, outputHelp(cfgOutputValueUnified, cfgOutputValueJSON, cfgOutputValueYAML)+" of --"+cfgDryRun)
 
 	rootCmd.AddCommand(configureCmd)
 }
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/ghodss/yaml"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+const cfgOutputValueUnified = "unified"
+
+var diffCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "Shows the differences between a YAML/JSON Vault configuration file and a running Vault",
+	Long: `It prints what the configure command would create or update in Vault, and what exists only
+in Vault, as a unified diff, JSON or YAML. It exits with 2 if there are differences, so it can
+be used to detect configuration drift. Only the fields present in the configuration file and
+returned by Vault are compared, write-only fields (passwords, secret keys) are skipped.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		appConfig.BindPFlag(cfgVaultConfigFile, cmd.PersistentFlags().Lookup(cfgVaultConfigFile))
+		appConfig.BindPFlag(cfgVaultConfigValues, cmd.PersistentFlags().Lookup(cfgVaultConfigValues))
+		appConfig.BindPFlag(cfgOutput, cmd.PersistentFlags().Lookup(cfgOutput))
+		appConfig.BindPFlag(cfgAuthMethod, cmd.PersistentFlags().Lookup(cfgAuthMethod))
+		appConfig.BindPFlag(cfgAuthRole, cmd.PersistentFlags().Lookup(cfgAuthRole))
+		appConfig.BindPFlag(cfgAuthPath, cmd.PersistentFlags().Lookup(cfgAuthPath))
+
+		output := appConfig.GetString(cfgOutput)
+		checkOutput(output, cfgOutputValueUnified, cfgOutputValueJSON, cfgOutputValueYAML)
+
+		authMethod := appConfig.GetString(cfgAuthMethod)
+
+		externalConfig, err := parseVaultConfig(appConfig.GetString(cfgVaultConfigFile))
+		if err != nil {
+			logrus.Fatal(err.Error())
+		}
+
+		cl, err := newVaultClient()
+
+		if err != nil {
+			logrus.Fatalf("error connecting to vault: %s", err.Error())
+		}
+
+		// The root token is read from the key store if no auth method is given
+		var store kv.Service
+		if authMethod == "" {
+			store, err = kvStoreForConfig(appConfig)
+
+			if err != nil {
+				exitWithError(exitCodeKeyStoreError, "error creating kv store: %s", err.Error())
+			}
+		} else {
+			err = loginVault(cl, authMethod, appConfig.GetString(cfgAuthRole), appConfig.GetString(cfgAuthPath))
+
+			if err != nil {
+				logrus.Fatalf("error authenticating to vault: %s", err.Error())
+			}
+		}
+
+		vaultConfig, err := vaultConfigForConfig(appConfig)
+
+		if err != nil {
+			logrus.Fatalf("error building vault config: %s", err.Error())
+		}
+
+		v, err := vault.New(store, cl, vaultConfig)
+
+		if err != nil {
+			logrus.Fatalf("error creating vault helper: %s", err.Error())
+		}
+
+		changes, err := v.Diff(externalConfig)
+
+		if err != nil {
+			logrus.Fatalf("error comparing vault configuration: %s", err.Error())
+		}
+
+		printConfigChanges(output, changes)
+
+		if len(changes) > 0 {
+			os.Exit(exitCodeDrift)
+		}
+	},
+}
+
+// printConfigChanges prints the changes as unified diffs, JSON or YAML
+func printConfigChanges(output string, changes []vault.ConfigChange) {
+	if output != cfgOutputValueUnified {
+		writeOutput(output, changes)
+		return
+	}
+	for _, change := range changes {
+		fmt.Print(unifiedDiff(change))
+	}
+}
+
+// unifiedDiff formats a change as a unified diff of the YAML of the object in Vault and in the configuration
+func unifiedDiff(change vault.ConfigChange) string {
+	lines := func(value interface{}) []string {
+		if value == nil {
+			return nil
+		}
+		out, err := yaml.Marshal(value)
+		if err != nil {
+			return []string{fmt.Sprint(value)}
+		}
+		return strings.Split(strings.TrimSuffix(string(out), "\n"), "\n")
+	}
+	live, desired := lines(change.Live), lines(change.Desired)
+
+	// Longest common subsequence of the lines
+	lcs := make([][]int, len(live)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(desired)+1)
+	}
+	for i := len(live) - 1; i >= 0; i-- {
+		for j := len(desired) - 1; j >= 0; j-- {
+			if live[i] == desired[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- vault/%s\n+++ config/%s\n", change.Path, change.Path)
+	// The hunk of an empty side starts at line 0
+	start := func(lines []string) int {
+		if len(lines) == 0 {
+			return 0
+		}
+		return 1
+	}
+	fmt.Fprintf(&b, "@@ -%d,%d +%d,%d @@ %s\n", start(live), len(live), start(desired), len(desired), change.Action)
+	i, j := 0, 0
+	for i < len(live) || j < len(desired) {
+		switch {
+		case i < len(live) && j < len(desired) && live[i] == desired[j]:
+			fmt.Fprintf(&b, " %s\n", live[i])
+			i++
+			j++
+		case j == len(desired) || (i < len(live) && lcs[i+1][j] >= lcs[i][j+1]):
+			fmt.Fprintf(&b, "-%s\n", live[i])
+			i++
+		default:
+			fmt.Fprintf(&b, "+%s\n", desired[j])
+			j++
+		}
+	}
+	return b.String()
+}
+
+func init() {
+	diffCmd.PersistentFlags().String(cfgVaultConfigFile, vault.DefaultConfigFile, vaultConfigFileHelp)
+	diffCmd.PersistentFlags().String(cfgVaultConfigValues, "", "A YAML/JSON file with the values of the Vault configuration template (.Values)")
+	diffCmd.PersistentFlags().String(cfgOutput, cfgOutputValueUnified, outputHelp(cfgOutputValueUnified, cfgOutputValueJSON, cfgOutputValueYAML))
+	diffCmd.PersistentFlags().String(cfgAuthMethod, "", "How to authenticate to Vault instead of using the root token from the key store ["+authMethodToken+", "+authMethodKubernetes+"]")
+	diffCmd.PersistentFlags().String(cfgAuthRole, "", "The role to log in with when using the kubernetes auth method")
+	diffCmd.PersistentFlags().String(cfgAuthPath, "kubernetes", "The mount path of the auth method to log in with")
+
+	rootCmd.AddCommand(diffCmd)
+}
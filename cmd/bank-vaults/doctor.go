@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/banzaicloud/bank-vaults/pkg/logging"
+	"github.com/hashicorp/vault/api"
+	"github.com/spf13/cobra"
+)
+
+// Statuses of a doctor check, in the order of their priority in the report
+const (
+	doctorStatusError   = "error"
+	doctorStatusWarning = "warning"
+	doctorStatusSkipped = "skipped"
+	doctorStatusOK      = "ok"
+)
+
+var doctorStatusPriority = map[string]int{
+	doctorStatusError:   0,
+	doctorStatusWarning: 1,
+	doctorStatusSkipped: 2,
+	doctorStatusOK:      3,
+}
+
+// doctorKeyStoreTestKey is the key written and deleted by the key store round-trip check
+const doctorKeyStoreTestKey = "bank-vaults-doctor"
+
+// doctorCertificateExpiryWarning is how long before its expiry the certificate of Vault is reported
+const doctorCertificateExpiryWarning = 30 * 24 * time.Hour
+
+// doctorConfigurePaths are the paths the token has to be able to write to configure Vault
+var doctorConfigurePaths = []string{"sys/policy/bank-vaults-doctor", "sys/auth/bank-vaults-doctor", "sys/mounts/bank-vaults-doctor"}
+
+// doctorCheck is the result of a check of the doctor command
+type doctorCheck struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	// Hint is how to fix the problem
+	Hint string `json:"hint,omitempty"`
+}
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Diagnoses the key store, the connection to Vault and the environment",
+	Long: `It runs end-to-end checks of the setup: a write, read and delete round-trip in the key store,
+the reachability of Vault and the validation of its TLS certificate, the capabilities of the
+token (the root token from the key store, or the one of --auth-method) on the paths written by
+the configure command, and the availability of the Kubernetes ServiceAccount when running in a
+Pod. The problems are reported first, with hints how to fix them. It exits with 1 if a check
+reports an error.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		appConfig.BindPFlag(cfgAuthMethod, cmd.PersistentFlags().Lookup(cfgAuthMethod))
+		appConfig.BindPFlag(cfgAuthRole, cmd.PersistentFlags().Lookup(cfgAuthRole))
+		appConfig.BindPFlag(cfgAuthPath, cmd.PersistentFlags().Lookup(cfgAuthPath))
+		appConfig.BindPFlag(cfgOutput, cmd.PersistentFlags().Lookup(cfgOutput))
+
+		output := appConfig.GetString(cfgOutput)
+		checkOutput(output, cfgOutputValueText, cfgOutputValueJSON, cfgOutputValueYAML)
+
+		checks := []doctorCheck{}
+
+		store, check := doctorKeyStore()
+		checks = append(checks, check)
+
+		checks = append(checks, doctorKubernetes())
+
+		config, err := vaultClientConfig()
+		if err != nil {
+			checks = append(checks, doctorCheck{
+				Name:    "vault-client",
+				Status:  doctorStatusError,
+				Message: err.Error(),
+				Hint:    "check VAULT_ADDR, the TLS flags and --" + cfgVaultTLSSecret,
+			})
+		} else {
+			cl, vaultChecks := doctorVault(config)
+			checks = append(checks, vaultChecks...)
+			if cl != nil {
+				checks = append(checks, doctorToken(cl, store))
+			} else {
+				checks = append(checks, doctorCheck{Name: "vault-token", Status: doctorStatusSkipped, Message: "vault is not reachable or sealed"})
+			}
+		}
+
+		sort.SliceStable(checks, func(i, j int) bool {
+			return doctorStatusPriority[checks[i].Status] < doctorStatusPriority[checks[j].Status]
+		})
+
+		if output != cfgOutputValueText {
+			writeOutput(output, checks)
+		} else {
+			for _, check := range checks {
+				fmt.Printf("[%s] %s: %s\n", check.Status, check.Name, check.Message)
+				if check.Hint != "" && check.Status != doctorStatusOK {
+					fmt.Printf("    hint: %s\n", check.Hint)
+				}
+			}
+		}
+
+		for _, check := range checks {
+			if check.Status == doctorStatusError {
+				os.Exit(exitCodeError)
+			}
+		}
+	},
+}
+
+// doctorKeyStore writes, reads back and deletes a random value in the key store
+func doctorKeyStore() (kv.Service, doctorCheck) {
+	check := doctorCheck{Name: "key-store", Status: doctorStatusError}
+	location := fmt.Sprintf("%s %s", appConfig.GetString(cfgMode), keyStoreLocation(appConfig))
+
+	store, err := kvStoreForConfig(appConfig)
+	if err != nil {
+		check.Message = fmt.Sprintf("error creating key store %s: %s", location, err.Error())
+		check.Hint = "check --mode and the flags of the key store"
+		return nil, check
+	}
+
+	value := make([]byte, 16)
+	rand.Read(value)
+	value = []byte(hex.EncodeToString(value))
+
+	if err := store.Set(doctorKeyStoreTestKey, value); err != nil {
+		check.Message = fmt.Sprintf("error writing to key store %s: %s", location, err.Error())
+		check.Hint = "check the write permissions of the credentials on the bucket, Secret or key vault, and on the encryption key"
+		return store, check
+	}
+	stored, err := store.Get(doctorKeyStoreTestKey)
+	if err != nil {
+		check.Message = fmt.Sprintf("error reading from key store %s: %s", location, err.Error())
+		check.Hint = "check the read permissions of the credentials, and the decrypt permission on the encryption key"
+		return store, check
+	}
+	if !bytes.Equal(stored, value) {
+		check.Message = fmt.Sprintf("the value read from key store %s doesn't match the written one", location)
+		check.Hint = "check that no other process writes the same key store"
+		return store, check
+	}
+	if err := kv.Delete(store, doctorKeyStoreTestKey); err != nil {
+		check.Status = doctorStatusWarning
+		check.Message = fmt.Sprintf("error deleting the test key %s from key store %s: %s", doctorKeyStoreTestKey, location, err.Error())
+		check.Hint = "the test key can be deleted by hand"
+		return store, check
+	}
+
+	check.Status = doctorStatusOK
+	check.Message = fmt.Sprintf("key store %s can be written, read and deleted", location)
+	return store, check
+}
+
+// doctorKubernetes checks the ServiceAccount of the Pod, if running in Kubernetes
+func doctorKubernetes() doctorCheck {
+	check := doctorCheck{Name: "kubernetes-serviceaccount"}
+
+	if os.Getenv("KUBERNETES_SERVICE_HOST") == "" {
+		check.Status = doctorStatusSkipped
+		check.Message = "not running in Kubernetes"
+		return check
+	}
+
+	if _, err := ioutil.ReadFile(serviceAccountTokenFile); err != nil {
+		check.Status = doctorStatusError
+		check.Message = fmt.Sprintf("error reading the ServiceAccount token: %s", err.Error())
+		check.Hint = "enable automountServiceAccountToken on the Pod or its ServiceAccount"
+		return check
+	}
+
+	check.Status = doctorStatusOK
+	check.Message = "the ServiceAccount token is mounted"
+	return check
+}
+
+// doctorVault checks the reachability of Vault and its certificate, the returned client is nil if Vault
+// can't be reached or is sealed
+func doctorVault(config *api.Config) (*api.Client, []doctorCheck) {
+	reachability := doctorCheck{Name: "vault-reachability", Status: doctorStatusError}
+	tlsCheck := doctorCheck{Name: "vault-tls"}
+
+	address, err := url.Parse(config.Address)
+	if err != nil {
+		reachability.Message = fmt.Sprintf("invalid vault address %s: %s", config.Address, err.Error())
+		reachability.Hint = "check VAULT_ADDR or --" + cfgVaultAddr
+		return nil, []doctorCheck{reachability}
+	}
+
+	if address.Scheme != "https" {
+		tlsCheck.Status = doctorStatusWarning
+		tlsCheck.Message = fmt.Sprintf("%s doesn't use TLS", config.Address)
+		tlsCheck.Hint = "the keys and tokens are sent unencrypted, enable TLS in the listener of Vault"
+	} else {
+		tlsCheck = doctorTLS(config)
+		if tlsCheck.Status == doctorStatusError {
+			return nil, []doctorCheck{tlsCheck}
+		}
+	}
+
+	// The client wraps the transport of the config, the TLS check needs the original one
+	cl, err := newVaultClientForConfig(config)
+	if err != nil {
+		reachability.Message = fmt.Sprintf("error creating vault client: %s", err.Error())
+		return nil, []doctorCheck{reachability}
+	}
+
+	health, err := cl.Sys().Health()
+	if err != nil {
+		reachability.Message = fmt.Sprintf("error connecting to %s: %s", config.Address, err.Error())
+		reachability.Hint = "check VAULT_ADDR, the Service of Vault and the network policies"
+		return nil, []doctorCheck{reachability, tlsCheck}
+	}
+
+	reachability.Status = doctorStatusWarning
+	switch {
+	case !health.Initialized:
+		reachability.Message = fmt.Sprintf("%s is reachable but not initialized", config.Address)
+		reachability.Hint = "run bank-vaults init"
+	case health.Sealed:
+		reachability.Message = fmt.Sprintf("%s is reachable but sealed", config.Address)
+		reachability.Hint = "run bank-vaults unseal"
+	default:
+		reachability.Status = doctorStatusOK
+		reachability.Message = fmt.Sprintf("%s is reachable, version %s", config.Address, health.Version)
+		return cl, []doctorCheck{reachability, tlsCheck}
+	}
+
+	// The token can't be checked while Vault is sealed
+	return nil, []doctorCheck{reachability, tlsCheck}
+}
+
+// doctorTLS validates the certificate of Vault and reports its upcoming expiry
+func doctorTLS(config *api.Config) doctorCheck {
+	check := doctorCheck{Name: "vault-tls", Status: doctorStatusError}
+
+	resp, err := config.HttpClient.Get(strings.TrimSuffix(config.Address, "/") + "/v1/sys/health")
+	if err != nil {
+		check.Message = fmt.Sprintf("error connecting to %s: %s", config.Address, err.Error())
+		switch {
+		case strings.Contains(err.Error(), "x509: certificate signed by unknown authority"):
+			check.Hint = "the certificate of Vault isn't signed by the CA, set --" + cfgVaultCACert + " or VAULT_CACERT"
+		case strings.Contains(err.Error(), "x509: certificate is valid for"):
+			check.Hint = "the certificate of Vault isn't valid for the host of the address, add it to the SANs of the certificate"
+		case strings.Contains(err.Error(), "x509: certificate has expired or is not yet valid"):
+			check.Hint = "the certificate of Vault is expired or not yet valid, renew it"
+		default:
+			// A connection error is reported by the reachability check
+			check.Status = doctorStatusSkipped
+			check.Message = "vault is not reachable"
+		}
+		return check
+	}
+	resp.Body.Close()
+
+	if transport, ok := config.HttpClient.Transport.(*http.Transport); ok && transport.TLSClientConfig != nil && transport.TLSClientConfig.InsecureSkipVerify {
+		check.Status = doctorStatusWarning
+		check.Message = "the certificate of Vault is not verified"
+		check.Hint = "remove --" + cfgVaultTLSSkipVerify + " and VAULT_SKIP_VERIFY, and set the CA with --" + cfgVaultCACert
+		return check
+	}
+
+	if resp.TLS != nil && len(resp.TLS.PeerCertificates) > 0 {
+		expiry := resp.TLS.PeerCertificates[0].NotAfter
+		if time.Until(expiry) < doctorCertificateExpiryWarning {
+			check.Status = doctorStatusWarning
+			check.Message = fmt.Sprintf("the certificate of Vault expires at %s", expiry.Format(time.RFC3339))
+			check.Hint = "renew the certificate of Vault"
+			return check
+		}
+		check.Message = fmt.Sprintf("the certificate of Vault is valid until %s", expiry.Format(time.RFC3339))
+	}
+
+	check.Status = doctorStatusOK
+	if check.Message == "" {
+		check.Message = "the certificate of Vault is valid"
+	}
+	return check
+}
+
+// doctorToken checks that the token can write the paths written by the configure command
+func doctorToken(cl *api.Client, store kv.Service) doctorCheck {
+	check := doctorCheck{Name: "vault-token", Status: doctorStatusError}
+
+	if authMethod := appConfig.GetString(cfgAuthMethod); authMethod != "" {
+		if err := loginVault(cl, authMethod, appConfig.GetString(cfgAuthRole), appConfig.GetString(cfgAuthPath)); err != nil {
+			check.Message = err.Error()
+			check.Hint = "check the role and the mount path of the auth method"
+			return check
+		}
+	} else {
+		if store == nil {
+			check.Status = doctorStatusSkipped
+			check.Message = "the root token can't be read from the key store"
+			return check
+		}
+		rootToken, err := store.Get(keyNamesForConfig(appConfig).RootTokenName())
+		if err != nil {
+			check.Status = doctorStatusWarning
+			check.Message = fmt.Sprintf("error reading the root token from the key store: %s", err.Error())
+			check.Hint = "the root token is not stored if Vault was initialized with --store-root-token=false, use --" + cfgAuthMethod
+			return check
+		}
+		logging.RegisterSecret(string(rootToken))
+		cl.SetToken(string(rootToken))
+	}
+
+	missing := []string{}
+	for _, path := range doctorConfigurePaths {
+		capabilities, err := cl.Sys().CapabilitiesSelf(path)
+		if err != nil {
+			check.Message = fmt.Sprintf("error checking the capabilities of the token: %s", err.Error())
+			check.Hint = "the token is invalid or expired"
+			return check
+		}
+		if !hasCapability(capabilities, "update") && !hasCapability(capabilities, "root") {
+			missing = append(missing, path)
+		}
+	}
+
+	if len(missing) > 0 {
+		check.Status = doctorStatusWarning
+		check.Message = fmt.Sprintf("the token can't write %s, configure will fail", strings.Join(missing, ", "))
+		check.Hint = "grant the policy of the token update and sudo on sys/policy/*, sys/auth/* and sys/mounts/*"
+		return check
+	}
+
+	check.Status = doctorStatusOK
+	check.Message = "the token can configure policies, auth methods and secret engines"
+	return check
+}
+
+func hasCapability(capabilities []string, capability string) bool {
+	for _, c := range capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	doctorCmd.PersistentFlags().String(cfgAuthMethod, "", "How to authenticate to Vault instead of using the root token from the key store ["+authMethodToken+", "+authMethodKubernetes+"]")
+	doctorCmd.PersistentFlags().String(cfgAuthRole, "", "The role to log in with when using the kubernetes auth method")
+	doctorCmd.PersistentFlags().String(cfgAuthPath, "kubernetes", "The mount path of the auth method to log in with")
+	doctorCmd.PersistentFlags().String(cfgOutput, cfgOutputValueText, outputHelp(cfgOutputValueText, cfgOutputValueJSON, cfgOutputValueYAML))
+
+	rootCmd.AddCommand(doctorCmd)
+}
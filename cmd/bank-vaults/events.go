@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+const cfgEvents = "events"
+const cfgEventsAnnotate = "events-annotate"
+
+// Reasons of the lifecycle events emitted about the Vault pods
+const (
+	eventReasonInitialized             = "Initialized"
+	eventReasonInitFailed              = "InitFailed"
+	eventReasonUnsealed                = "Unsealed"
+	eventReasonUnsealFailed            = "UnsealFailed"
+	eventReasonSealCheckFailed         = "SealCheckFailed"
+	eventReasonRekeyed                 = "Rekeyed"
+	eventReasonRekeyFailed             = "RekeyFailed"
+	eventReasonRootTokenRotated        = "RootTokenRotated"
+	eventReasonRootTokenRotationFailed = "RootTokenRotationFailed"
+	eventReasonRaftJoined              = "RaftJoined"
+	eventReasonRaftJoinFailed          = "RaftJoinFailed"
+)
+
+// podEventRecorder emits Kubernetes Events (and optionally annotations) about lifecycle
+// actions against a Vault pod, a nil recorder discards the events
+type podEventRecorder struct {
+	k8s       kubernetes.Interface
+	pod       v1.ObjectReference
+	annotate  bool
+	component string
+}
+
+// newPodEventRecorder returns a recorder for the given pod if events are enabled, nil otherwise
+func newPodEventRecorder(namespace, name string) *podEventRecorder {
+	if !appConfig.GetBool(cfgEvents) || namespace == "" || name == "" {
+		return nil
+	}
+
+	k8s, err := kubernetesClient()
+	if err != nil {
+		logrus.Errorf("error creating k8s client for events: %s", err.Error())
+		return nil
+	}
+
+	ref := v1.ObjectReference{APIVersion: "v1", Kind: "Pod", Namespace: namespace, Name: name}
+	if pod, err := k8s.CoreV1().Pods(namespace).Get(name, metav1.GetOptions{}); err == nil {
+		ref.UID = pod.UID
+	}
+
+	return &podEventRecorder{
+		k8s:       k8s,
+		pod:       ref,
+		annotate:  appConfig.GetBool(cfgEventsAnnotate),
+		component: "bank-vaults",
+	}
+}
+
+// newOwnPodEventRecorder returns a recorder for the pod set in POD_NAME and POD_NAMESPACE
+func newOwnPodEventRecorder() *podEventRecorder {
+	return newPodEventRecorder(os.Getenv("POD_NAMESPACE"), os.Getenv("POD_NAME"))
+}
+
+func (r *podEventRecorder) normal(reason, message string) {
+	r.record(v1.EventTypeNormal, reason, message)
+}
+
+func (r *podEventRecorder) warning(reason, message string) {
+	r.record(v1.EventTypeWarning, reason, message)
+}
+
+func (r *podEventRecorder) record(eventType, reason, message string) {
+	if r == nil {
+		return
+	}
+
+	now := metav1.Now()
+	event := &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: r.pod.Name + ".",
+			Namespace:    r.pod.Namespace,
+		},
+		InvolvedObject: r.pod,
+		Reason:         reason,
+		Message:        message,
+		Type:           eventType,
+		Source:         v1.EventSource{Component: r.component},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+
+	_, err := r.k8s.CoreV1().Events(r.pod.Namespace).Create(event)
+	if err != nil {
+		logrus.Errorf("error creating %s event for pod %s: %s", reason, r.pod.Name, err.Error())
+	}
+
+	if r.annotate {
+		r.annotatePod(reason, now.Time)
+	}
+}
+
+func (r *podEventRecorder) annotatePod(reason string, timestamp time.Time) {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{
+				vault.LastEventAnnotation: fmt.Sprintf("%s %s", reason, timestamp.UTC().Format(time.RFC3339)),
+			},
+		},
+	})
+	if err != nil {
+		logrus.Errorf("error creating pod annotation patch: %s", err.Error())
+		return
+	}
+
+	_, err = r.k8s.CoreV1().Pods(r.pod.Namespace).Patch(r.pod.Name, types.MergePatchType, patch)
+	if err != nil {
+		logrus.Errorf("error annotating pod %s: %s", r.pod.Name, err.Error())
+	}
+}
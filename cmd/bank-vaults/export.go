@@ -0,0 +1,80 @@
+package main
+
+import (
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Exports the configuration of a running Vault as a YAML configuration file",
+	Long: `It reads the policies, auth methods with their roles, the secret engines, the audit devices
+and the tuning of the mounts from Vault and prints them in the format of the configure command, to help adopting bank-vaults for Vault
+clusters configured by hand. Secret values (passwords, secret keys) are not returned by Vault,
+they have to be added to the result by hand.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		appConfig.BindPFlag(cfgAuthMethod, cmd.PersistentFlags().Lookup(cfgAuthMethod))
+		appConfig.BindPFlag(cfgAuthRole, cmd.PersistentFlags().Lookup(cfgAuthRole))
+		appConfig.BindPFlag(cfgAuthPath, cmd.PersistentFlags().Lookup(cfgAuthPath))
+		appConfig.BindPFlag(cfgOutput, cmd.PersistentFlags().Lookup(cfgOutput))
+
+		output := appConfig.GetString(cfgOutput)
+		checkOutput(output, cfgOutputValueYAML, cfgOutputValueJSON)
+
+		authMethod := appConfig.GetString(cfgAuthMethod)
+
+		cl, err := newVaultClient()
+
+		if err != nil {
+			logrus.Fatalf("error connecting to vault: %s", err.Error())
+		}
+
+		// The root token is read from the key store if no auth method is given
+		var store kv.Service
+		if authMethod == "" {
+			store, err = kvStoreForConfig(appConfig)
+
+			if err != nil {
+				exitWithError(exitCodeKeyStoreError, "error creating kv store: %s", err.Error())
+			}
+		} else {
+			err = loginVault(cl, authMethod, appConfig.GetString(cfgAuthRole), appConfig.GetString(cfgAuthPath))
+
+			if err != nil {
+				logrus.Fatalf("error authenticating to vault: %s", err.Error())
+			}
+		}
+
+		vaultConfig, err := vaultConfigForConfig(appConfig)
+
+		if err != nil {
+			logrus.Fatalf("error building vault config: %s", err.Error())
+		}
+
+		v, err := vault.New(store, cl, vaultConfig)
+
+		if err != nil {
+			logrus.Fatalf("error creating vault helper: %s", err.Error())
+		}
+
+		config, err := v.Export()
+
+		if err != nil {
+			logrus.Fatalf("error exporting vault configuration: %s", err.Error())
+		}
+
+		writeOutput(output, config)
+	},
+}
+
+func init() {
+	exportCmd.PersistentFlags().String(cfgAuthMethod, "", "How to authenticate to Vault instead of using the root token from the key store ["+authMethodToken+", "+authMethodKubernetes+"]")
+	exportCmd.PersistentFlags().String(cfgAuthRole, "", "The role to log in with when using the kubernetes auth method")
+	exportCmd.PersistentFlags().String(cfgAuthPath, "kubernetes", "The mount path of the auth method to log in with")
+
+	exportCmd.PersistentFlags().String(cfgOutput, cfgOutputValueYAML, outputHelp(cfgOutputValueYAML, cfgOutputValueJSON))
+
+	rootCmd.AddCommand(exportCmd)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/banzaicloud/bank-vaults/pkg/lock"
+	"github.com/banzaicloud/bank-vaults/pkg/logging"
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const cfgHASelector = "ha-selector"
+const cfgUnsealConcurrently = "unseal-concurrently"
+
+// unsealHAPods continuously unseals every Vault pod matching selector in POD_NAMESPACE, so the
+// standby nodes of an HA cluster are unsealed after their restarts too, not only the active one
+func unsealHAPods(ctx context.Context, store kv.Service, locker lock.Locker, vaultConfig vault.Config, selector string) {
+	unsealPods(ctx, store, locker, vaultConfig, metav1.ListOptions{LabelSelector: selector}, "matching "+selector, unsealConfig.raftJoin)
+}
+
+// unsealAddresses continuously unseals every Vault node of addresses, they are nodes of the same
+// HA cluster sharing the key store, the first one bootstraps the raft cluster if they are joined
+func unsealAddresses(ctx context.Context, store kv.Service, locker lock.Locker, vaultConfig vault.Config, addresses []string) {
+	var unsealers []*unsealer
+	for _, address := range addresses {
+		v, err := vaultForAddress(store, vaultConfig, address)
+		if err != nil {
+			logrus.Fatalf("error creating vault helper for %s: %s", address, err.Error())
+		}
+		unsealers = append(unsealers, &unsealer{
+			vault:       v,
+			log:         logrus.WithField("node", address),
+			target:      address,
+			address:     address,
+			status:      adminServer.Target(address),
+			lock:        locker,
+			proceedInit: unsealConfig.proceedInit,
+		})
+	}
+
+	for {
+		if unsealConfig.concurrent {
+			unsealConcurrently(ctx, store, vaultConfig, addresses)
+		}
+		for i, u := range unsealers {
+			if join := unsealConfig.raftJoin; join != nil {
+				others := append(append([]string{}, addresses[:i]...), addresses[i+1:]...)
+				if !u.raftJoin(join, i == 0, others) {
+					continue
+				}
+			}
+			u.unseal()
+		}
+
+		// wait unsealPeriod before trying again
+		if !sleepContext(ctx, unsealConfig.unsealPeriod) {
+			return
+		}
+	}
+}
+
+// unsealConcurrently unseals the sealed nodes of addresses concurrently, before the unsealers of the
+// nodes initialize, join, rekey (and unseal the rest of) them one by one, the state of every node is
+// reported in the log, the metrics and the status of the admin server
+func unsealConcurrently(ctx context.Context, store kv.Service, vaultConfig vault.Config, addresses []string) {
+	statuses, err := vault.UnsealAll(ctx, store, vaultClients, vaultConfig, addresses)
+	if err != nil {
+		logrus.Errorf("error unsealing the nodes: %s", err.Error())
+		return
+	}
+
+	for _, status := range statuses {
+		log := logrus.WithFields(logrus.Fields{
+			"node":        status.Address,
+			"initialized": status.Initialized,
+			"sealed":      status.Sealed,
+			"progress":    fmt.Sprintf("%d/%d", status.Progress, status.Threshold),
+			"version":     status.Version,
+			"haMode":      status.HAMode,
+		})
+		target := adminServer.Target(status.Address)
+
+		if status.Error != "" {
+			log.Errorf("error unsealing the node: %s", status.Error)
+			target.ReportSealed(status.Sealed, errors.New(status.Error))
+			continue
+		}
+		if !status.Initialized {
+			// The unsealer of the node initializes or joins it
+			log.Debugf("the node is not initialized")
+			continue
+		}
+
+		if status.Sealed {
+			vaultSealed.Set(1, status.Address)
+			log.Warnf("the node is still sealed")
+		} else {
+			vaultSealed.Set(0, status.Address)
+			log.Debugf("the node is unsealed")
+		}
+		target.ReportSealed(status.Sealed, nil)
+		target.ReportNode(status.Version, status.HAMode)
+	}
+}
+
+// vaultForAddress returns a Vault helper connecting to a node of the cluster directly, the rest
+// of the client settings are read from the environment like in vaultForPod
+func vaultForAddress(store kv.Service, vaultConfig vault.Config, address string) (vault.Vault, error) {
+	cl, err := vaultClients.Client(vault.Endpoint{Address: address})
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to vault: %s", err.Error())
+	}
+
+	vaultConfig.Logger = logging.NewLogrus(logrus.WithField("node", address))
+
+	return vault.New(store, cl, vaultConfig)
+}
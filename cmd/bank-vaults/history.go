@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/vaultkv"
+	"github.com/banzaicloud/bank-vaults/pkg/logging"
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/hashicorp/vault/api"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const cfgConfigHistory = "config-history"
+const cfgConfigHistoryValueNone = "none"
+const cfgConfigHistoryValueKeyStore = "keystore"
+const cfgConfigHistoryValueVault = "vault"
+const cfgConfigHistoryVaultPath = "config-history-vault-path"
+const cfgConfigHistoryKVVersion = "config-history-kv-version"
+const cfgConfigHistorySigningKeyFile = "config-history-signing-key-file"
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Shows and verifies the history of the configurations applied by the configure command",
+	Long: `It reads the records written by configure --config-history after every successful configuration
+(time, hash of the configuration, actor and the objects created or updated) and verifies their
+signatures and their chain with the signing key. It exits with 6 if a record has been changed,
+removed or inserted, after printing the valid records before it.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		bindConfigHistoryFlags(cmd)
+		appConfig.BindPFlag(cfgOutput, cmd.PersistentFlags().Lookup(cfgOutput))
+		appConfig.BindPFlag(cfgAuthMethod, cmd.PersistentFlags().Lookup(cfgAuthMethod))
+		appConfig.BindPFlag(cfgAuthRole, cmd.PersistentFlags().Lookup(cfgAuthRole))
+		appConfig.BindPFlag(cfgAuthPath, cmd.PersistentFlags().Lookup(cfgAuthPath))
+
+		output := appConfig.GetString(cfgOutput)
+		checkOutput(output, cfgOutputValueText, cfgOutputValueJSON, cfgOutputValueYAML)
+
+		authMethod := appConfig.GetString(cfgAuthMethod)
+
+		cl, err := newVaultClient()
+
+		if err != nil {
+			logrus.Fatalf("error connecting to vault: %s", err.Error())
+		}
+
+		// The root token is read from the key store if no auth method is given
+		var store kv.Service
+		if authMethod == "" {
+			store, err = kvStoreForConfig(appConfig)
+
+			if err != nil {
+				exitWithError(exitCodeKeyStoreError, "error creating kv store: %s", err.Error())
+			}
+		} else {
+			err = loginVault(cl, authMethod, appConfig.GetString(cfgAuthRole), appConfig.GetString(cfgAuthPath))
+
+			if err != nil {
+				logrus.Fatalf("error authenticating to vault: %s", err.Error())
+			}
+		}
+
+		if appConfig.GetString(cfgConfigHistory) == cfgConfigHistoryValueVault && authMethod == "" {
+			rootToken, err := store.Get(keyNamesForConfig(appConfig).RootTokenName())
+
+			if err != nil {
+				exitWithError(exitCodeKeyStoreError, "error reading the root token from the key store: %s", err.Error())
+			}
+			logging.RegisterSecret(string(rootToken))
+			cl.SetToken(string(rootToken))
+		}
+
+		history, err := newConfigHistory(appConfig, cl, store)
+
+		if err != nil {
+			logrus.Fatalf("error creating configuration history: %s", err.Error())
+		}
+		if history == nil {
+			logrus.Fatalf("--%s has to be %s or %s", cfgConfigHistory, cfgConfigHistoryValueKeyStore, cfgConfigHistoryValueVault)
+		}
+
+		records, err := history.Records()
+
+		if err != nil {
+			if _, ok := err.(*vault.ConfigHistoryError); !ok {
+				logrus.Fatalf("error reading configuration history: %s", err.Error())
+			}
+		}
+
+		if output == cfgOutputValueText {
+			for _, record := range records {
+				fmt.Println(formatConfigRecord(record))
+			}
+		} else {
+			writeOutput(output, records)
+		}
+
+		if err != nil {
+			exitWithError(exitCodeHistoryTampered, "%s", err.Error())
+		}
+	},
+}
+
+func bindConfigHistoryFlags(cmd *cobra.Command) {
+	appConfig.BindPFlag(cfgConfigHistory, cmd.PersistentFlags().Lookup(cfgConfigHistory))
+	appConfig.BindPFlag(cfgConfigHistoryVaultPath, cmd.PersistentFlags().Lookup(cfgConfigHistoryVaultPath))
+	appConfig.BindPFlag(cfgConfigHistoryKVVersion, cmd.PersistentFlags().Lookup(cfgConfigHistoryKVVersion))
+	appConfig.BindPFlag(cfgConfigHistorySigningKeyFile, cmd.PersistentFlags().Lookup(cfgConfigHistorySigningKeyFile))
+}
+
+func addConfigHistoryFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().String(cfgConfigHistory, cfgConfigHistoryValueNone, "Where the signed history of the successful configurations is kept ("+cfgConfigHistoryValueNone+", "+cfgConfigHistoryValueKeyStore+", "+cfgConfigHistoryValueVault+" in --"+cfgConfigHistoryVaultPath+")")
+	cmd.PersistentFlags().String(cfgConfigHistoryVaultPath, "secret/bank-vaults/config-history", "The path of a KV secret engine in Vault to keep the configuration history in")
+	cmd.PersistentFlags().Int(cfgConfigHistoryKVVersion, 1, "The version of the KV secret engine of --"+cfgConfigHistoryVaultPath+" (1 or 2, the path has to be the data path with 2, e.g. secret/data/...)")
+	cmd.PersistentFlags().String(cfgConfigHistorySigningKeyFile, "", "The file holding the key the records of the configuration history are signed with")
+}
+
+// newConfigHistory returns the configuration history set up with the flags, nil if it is disabled.
+// The client has to have a token with access to the path if the history is kept in Vault.
+func newConfigHistory(cfg *viper.Viper, cl *api.Client, store kv.Service) (*vault.ConfigHistory, error) {
+	var historyStore kv.Service
+	var err error
+
+	switch mode := cfg.GetString(cfgConfigHistory); mode {
+	case cfgConfigHistoryValueNone:
+		return nil, nil
+	case cfgConfigHistoryValueKeyStore:
+		if store == nil {
+			return nil, fmt.Errorf("there is no key store to keep the history in with --%s, use --%s=%s", cfgAuthMethod, cfgConfigHistory, cfgConfigHistoryValueVault)
+		}
+		historyStore = store
+	case cfgConfigHistoryValueVault:
+		version := cfg.GetInt(cfgConfigHistoryKVVersion)
+		if version != 1 && version != 2 {
+			return nil, fmt.Errorf("invalid --%s: %d, it has to be 1 or 2", cfgConfigHistoryKVVersion, version)
+		}
+		historyStore, err = vaultkv.New(cl, cfg.GetString(cfgConfigHistoryVaultPath), version == 2)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("invalid --%s: %s, it has to be %s, %s or %s", cfgConfigHistory, mode, cfgConfigHistoryValueNone, cfgConfigHistoryValueKeyStore, cfgConfigHistoryValueVault)
+	}
+
+	signingKeyFile := cfg.GetString(cfgConfigHistorySigningKeyFile)
+	if signingKeyFile == "" {
+		return nil, fmt.Errorf("--%s has to be set for the configuration history", cfgConfigHistorySigningKeyFile)
+	}
+	signingKey, err := ioutil.ReadFile(signingKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading signing key: %s", err.Error())
+	}
+
+	return vault.NewConfigHistory(historyStore, signingKey)
+}
+
+// configActor describes who applies the configuration in the records of the history: the auth
+// method (or the root token) and the Pod or host
+func configActor() string {
+	actor := "root-token"
+	if authMethod := appConfig.GetString(cfgAuthMethod); authMethod != "" {
+		actor = authMethod
+		if role := appConfig.GetString(cfgAuthRole); role != "" {
+			actor += "/" + role
+		}
+	}
+
+	host := os.Getenv("POD_NAME")
+	if host == "" {
+		host, _ = os.Hostname()
+	}
+	return actor + "@" + host
+}
+
+func formatConfigRecord(record vault.ConfigRecord) string {
+	var changes string
+	if record.Changes == nil {
+		changes = "changes unknown"
+	} else {
+		summaries := make([]string, len(record.Changes))
+		for i, change := range record.Changes {
+			summaries[i] = change.Action + " " + change.Path
+		}
+		changes = fmt.Sprintf("%d changes", len(record.Changes))
+		if len(summaries) > 0 {
+			changes += ": " + strings.Join(summaries, ", ")
+		}
+	}
+	return fmt.Sprintf("#%d %s by %s, config %s, %d resources, %s",
+		record.Sequence, record.Time.Format("2006-01-02T15:04:05Z07:00"), record.Actor, record.ConfigHash[:12], record.Resources, changes)
+}
+
+func init() {
+	addConfigHistoryFlags(historyCmd)
+	historyCmd.PersistentFlags().String(cfgOutput, cfgOutputValueText, outputHelp(cfgOutputValueText, cfgOutputValueJSON, cfgOutputValueYAML))
+	historyCmd.PersistentFlags().String(cfgAuthMethod, "", "How to authenticate to Vault instead of using the root token from the key store ["+authMethodToken+", "+authMethodKubernetes+"]")
+	historyCmd.PersistentFlags().String(cfgAuthRole, "", "The role to log in with when using the kubernetes auth method")
+	historyCmd.PersistentFlags().String(cfgAuthPath, "kubernetes", "The mount path of the auth method to log in with")
+
+	rootCmd.AddCommand(historyCmd)
+}
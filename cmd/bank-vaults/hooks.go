@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/banzaicloud/bank-vaults/pkg/hook"
+	"github.com/banzaicloud/bank-vaults/pkg/logging"
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/spf13/viper"
+)
+
+const cfgHookCommand = "hook-command"
+const cfgHookWebhookURL = "hook-webhook-url"
+const cfgHookEvents = "hook-events"
+const cfgHookTimeout = "hook-timeout"
+
+// hooksForConfig returns the hooks of the lifecycle phases set up with the flags
+func hooksForConfig(cfg *viper.Viper) ([]vault.Hook, error) {
+	var hooks []vault.Hook
+	timeout := cfg.GetDuration(cfgHookTimeout)
+
+	if command := cfg.GetString(cfgHookCommand); command != "" {
+		execHook, err := hook.NewExec(strings.Fields(command), timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --%s: %s", cfgHookCommand, err.Error())
+		}
+		hooks = append(hooks, execHook)
+	}
+	// The webhook url may hold a token, it may be part of the errors
+	if url := cfg.GetString(cfgHookWebhookURL); url != "" {
+		logging.RegisterSecret(url)
+		hooks = append(hooks, hook.NewWebhook(url, timeout))
+	}
+
+	if events := cfg.GetString(cfgHookEvents); events != "" && len(hooks) > 0 {
+		var names []string
+		for _, event := range strings.Split(events, ",") {
+			event = strings.TrimSpace(event)
+			if !validHookEvent(event) {
+				return nil, fmt.Errorf("invalid --%s: %s, the events are %s", cfgHookEvents, event, strings.Join(hook.Events(), ", "))
+			}
+			names = append(names, event)
+		}
+		for i := range hooks {
+			hooks[i] = hook.Filter(hooks[i], names...)
+		}
+	}
+	return hooks, nil
+}
+
+func validHookEvent(event string) bool {
+	for _, e := range hook.Events() {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
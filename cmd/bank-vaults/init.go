@@ -1,15 +1,28 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/banzaicloud/bank-vaults/pkg/notify"
 	"github.com/banzaicloud/bank-vaults/pkg/vault"
-	"github.com/hashicorp/vault/api"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 const cfgInitRootToken = "init-root-token"
 const cfgStoreRootToken = "store-root-token"
 
+// initOutput is the machine-readable result of the init command
+type initOutput struct {
+	*vault.InitResult
+	// Mode and Location describe the key store the keys have been stored in
+	Mode     string `json:"mode"`
+	Location string `json:"location"`
+}
+
 var initCmd = &cobra.Command{
 	Use:   "init",
 	Short: "Initialise the target Vault instance",
@@ -17,18 +30,23 @@ var initCmd = &cobra.Command{
 run "vault init" against the target Vault instance, before encrypting and
 storing the keys in the Cloud KMS keyring.
 
-It will not unseal the Vault instance after initialising.`,
+It will not unseal the Vault instance after initialising. With --output json or
+yaml it prints which keys have been stored where to the standard output.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		appConfig.BindPFlag(cfgInitRootToken, cmd.PersistentFlags().Lookup(cfgInitRootToken))
 		appConfig.BindPFlag(cfgStoreRootToken, cmd.PersistentFlags().Lookup(cfgStoreRootToken))
+		appConfig.BindPFlag(cfgOutput, cmd.PersistentFlags().Lookup(cfgOutput))
+
+		output := appConfig.GetString(cfgOutput)
+		checkOutput(output, cfgOutputValueText, cfgOutputValueJSON, cfgOutputValueYAML)
 
 		store, err := kvStoreForConfig(appConfig)
 
 		if err != nil {
-			logrus.Fatalf("error creating kv store: %s", err.Error())
+			exitWithError(exitCodeKeyStoreError, "error creating kv store: %s", err.Error())
 		}
 
-		cl, err := api.NewClient(nil)
+		cl, err := newVaultClient()
 
 		if err != nil {
 			logrus.Fatalf("error connecting to vault: %s", err.Error())
@@ -46,15 +64,89 @@ It will not unseal the Vault instance after initialising.`,
 			logrus.Fatalf("error creating vault helper: %s", err.Error())
 		}
 
-		if err = v.Init(); err != nil {
-			logrus.Fatalf("error initialising vault: %s", err.Error())
+		locker, err := newLocker(appConfig, store, "")
+
+		if err != nil {
+			logrus.Fatalf("error creating lock: %s", err.Error())
+		}
+
+		var result *vault.InitResult
+		err = withLock(locker, logrus.StandardLogger(), "initialize", func() error {
+			result, err = v.Init(shutdownContext)
+			return err
+		})
+
+		// Another replica may have initialized Vault at the same time
+		if errors.Is(err, vault.ErrAlreadyInitialized) {
+			logrus.Infof("vault has been initialized by someone else: %s", err.Error())
+			result, err = &vault.InitResult{AlreadyInitialized: true}, nil
+		}
+
+		if err != nil {
+			exitWithError(exitCodeForError(err, exitCodeError), "error initialising vault: %s", err.Error())
+		}
+
+		if !result.AlreadyInitialized {
+			notifyEvent(notify.EventInitialized, "", "vault has been initialized", nil)
+			handOffWrappedKeys("", result, output == cfgOutputValueText)
+			notifyRootTokenStored("", result)
+		}
+
+		if output == cfgOutputValueText {
+			// The root token is never logged, it is printed only if it hasn't been stored
+			if result.RootToken != "" {
+				fmt.Printf("Root token: %s\n", result.RootToken)
+			}
+		} else {
+			writeOutput(output, initOutput{
+				InitResult: result,
+				Mode:       appConfig.GetString(cfgMode),
+				Location:   keyStoreLocation(appConfig),
+			})
 		}
 	},
 }
 
+// keyStoreLocation describes where the key store of the given mode keeps the keys
+func keyStoreLocation(cfg *viper.Viper) string {
+	switch cfg.GetString(cfgMode) {
+	case cfgModeValueGoogleCloudKMSGCS:
+		return fmt.Sprintf("gs://%s/%s", cfg.GetString(cfgGoogleCloudStorageBucket), cfg.GetString(cfgGoogleCloudStoragePrefix))
+	case cfgModeValueAWSKMS3:
+		return fmt.Sprintf("s3://%s/%s", cfg.GetString(cfgAWSS3Bucket), cfg.GetString(cfgAWSS3Prefix))
+	case cfgModeValueAzureKeyVault:
+		return fmt.Sprintf("https://%s.vault.azure.net", cfg.GetString(cfgAzureKeyVaultName))
+	case cfgModeValueAlibabaKMSOSS:
+		return fmt.Sprintf("oss://%s/%s", cfg.GetString(cfgAlibabaOSSBucket), cfg.GetString(cfgAlibabaOSSPrefix))
+	case cfgModeValueK8S:
+		return strings.Join([]string{cfg.GetString(cfgK8SNamespace), cfg.GetString(cfgK8SSecret)}, "/")
+	case cfgModeValueFile:
+		return cfg.GetString(cfgFilePath)
+	case cfgModeValueEncrypted:
+		switch cfg.GetString(cfgEncryptedStorage) {
+		case cfgEncryptedStorageValueAWSS3:
+			return fmt.Sprintf("s3://%s/%s", cfg.GetString(cfgAWSS3Bucket), cfg.GetString(cfgAWSS3Prefix))
+		case cfgEncryptedStorageValueGoogleCloud:
+			return fmt.Sprintf("gs://%s/%s", cfg.GetString(cfgGoogleCloudStorageBucket), cfg.GetString(cfgGoogleCloudStoragePrefix))
+		case cfgEncryptedStorageValueAlibabaOSS:
+			return fmt.Sprintf("oss://%s/%s", cfg.GetString(cfgAlibabaOSSBucket), cfg.GetString(cfgAlibabaOSSPrefix))
+		case cfgEncryptedStorageValueK8S:
+			return strings.Join([]string{cfg.GetString(cfgK8SNamespace), cfg.GetString(cfgK8SSecret)}, "/")
+		case cfgEncryptedStorageValueConsul:
+			return fmt.Sprintf("consul://%s/%s", cfg.GetString(cfgConsulAddress), cfg.GetString(cfgConsulPrefix))
+		case cfgEncryptedStorageValueEtcd:
+			return fmt.Sprintf("etcd://%s/%s", cfg.GetString(cfgEtcdEndpoints), cfg.GetString(cfgEtcdPrefix))
+		}
+		return ""
+	default:
+		return ""
+	}
+}
+
 func init() {
 	initCmd.PersistentFlags().String(cfgInitRootToken, "", "root token for the new vault cluster")
 	initCmd.PersistentFlags().Bool(cfgStoreRootToken, true, "should the root token be stored in the key store")
+	initCmd.PersistentFlags().String(cfgOutput, cfgOutputValueText, outputHelp(cfgOutputValueText, cfgOutputValueJSON, cfgOutputValueYAML)+", only logs are written in the "+cfgOutputValueText+" format")
 
 	rootCmd.AddCommand(initCmd)
 }
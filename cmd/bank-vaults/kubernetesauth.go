@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const cfgKubernetesAuthKubeconfig = "kubernetes-auth-kubeconfig"
+const cfgKubernetesAuthContext = "kubernetes-auth-context"
+
+// kubernetesAuthForKubeconfig returns the cluster of the Kubernetes auth methods from the context of
+// a kubeconfig (its current context if empty), so the Vault of a remote cluster can be configured
+// from outside of it: the address and the CA certificate of its API server, and the token of the
+// user as the token reviewer JWT
+func kubernetesAuthForKubeconfig(kubeconfig, context string) (*vault.KubernetesAuthConfig, error) {
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		&clientcmd.ClientConfigLoadingRules{ExplicitPath: kubeconfig},
+		&clientcmd.ConfigOverrides{CurrentContext: context}).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("error loading kubeconfig %s: %s", kubeconfig, err.Error())
+	}
+
+	caCert := config.CAData
+	if len(caCert) == 0 && config.CAFile != "" {
+		caCert, err = ioutil.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading the CA certificate of the cluster: %s", err.Error())
+		}
+	}
+
+	return &vault.KubernetesAuthConfig{
+		Host:             config.Host,
+		CACert:           string(caCert),
+		TokenReviewerJWT: config.BearerToken,
+	}, nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/banzaicloud/bank-vaults/pkg/lock"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+const cfgLock = "lock"
+const cfgLockValueNone = "none"
+const cfgLockValueKeyStore = "key-store"
+const cfgLockValueConfigMap = "configmap"
+const cfgLockName = "lock-name"
+const cfgLockTTL = "lock-ttl"
+const cfgLockTimeout = "lock-timeout"
+const cfgLeaderElection = "leader-election"
+
+// lockRetryInterval is the time between the attempts to acquire a lock held by another replica
+const lockRetryInterval = 5 * time.Second
+
+// newLocker returns the lock shared by the replicas initializing or configuring the same Vault,
+// nil if --lock is none. The ConfigMap locks of the clusters in --clusters-config are told apart
+// by the name of the cluster, the key store locks by the key store of the cluster.
+func newLocker(cfg *viper.Viper, store kv.Service, cluster string) (lock.Locker, error) {
+	name := cfg.GetString(cfgLockName)
+	ttl := cfg.GetDuration(cfgLockTTL)
+
+	switch mode := cfg.GetString(cfgLock); mode {
+	case cfgLockValueNone:
+		return nil, nil
+
+	case cfgLockValueKeyStore:
+		if store == nil {
+			return nil, fmt.Errorf("--%s=%s can't be used without a key store", cfgLock, cfgLockValueKeyStore)
+		}
+		return lock.NewKV(store, name, lockHolder(), ttl), nil
+
+	case cfgLockValueConfigMap:
+		client, err := kubernetesClient()
+		if err != nil {
+			return nil, err
+		}
+		namespace := os.Getenv("POD_NAMESPACE")
+		if namespace == "" {
+			namespace = "default"
+		}
+		if cluster != "" {
+			name = name + "-" + cluster
+		}
+		return lock.NewConfigMap(client, namespace, name, lockHolder(), ttl), nil
+
+	default:
+		return nil, fmt.Errorf("invalid --%s: %s, it has to be %s, %s or %s", cfgLock, mode, cfgLockValueNone, cfgLockValueKeyStore, cfgLockValueConfigMap)
+	}
+}
+
+// lockHolder identifies this replica in the locks, the name of its Pod if it runs in Kubernetes
+func lockHolder() string {
+	if podName := os.Getenv("POD_NAME"); podName != "" {
+		return podName
+	}
+	hostname, _ := os.Hostname()
+	return hostname
+}
+
+// withLock calls f while holding locker, so another replica can't initialize or configure
+// Vault at the same time, f is called without locking if locker is nil
+func withLock(locker lock.Locker, log logrus.FieldLogger, action string, f func() error) error {
+	if locker == nil {
+		return f()
+	}
+
+	log.Infof("acquiring the lock to %s vault...", action)
+	if err := lock.Lock(locker, lockRetryInterval, appConfig.GetDuration(cfgLockTimeout)); err != nil {
+		return fmt.Errorf("error acquiring the lock to %s vault: %s", action, err.Error())
+	}
+	defer func() {
+		if err := locker.Unlock(); err != nil {
+			log.Warnf("error releasing the lock to %s vault: %s", action, err.Error())
+		}
+	}()
+
+	return f()
+}
+
+// runAsLeader runs f only while this replica holds locker, the other replicas wait as standbys
+// until they get it. It exits with an error if the lease of the lock is lost, so the replica is
+// restarted as a standby instead of running next to the new leader.
+func runAsLeader(locker lock.Locker, f func(ctx context.Context)) {
+	if locker == nil {
+		logrus.Fatalf("--%s requires a lock, set --%s to %s or %s", cfgLeaderElection, cfgLock, cfgLockValueKeyStore, cfgLockValueConfigMap)
+	}
+
+	logrus.Infof("waiting to become the leader as %s...", lockHolder())
+	err := lock.RunAsLeader(shutdownContext, locker, lockRetryInterval, func(ctx context.Context) error {
+		f(ctx)
+		return nil
+	})
+	if err == lock.ErrLeaseLost {
+		exitWithError(exitCodeError, "lost the leadership: %s", err.Error())
+	} else if err != nil && shutdownContext.Err() == nil {
+		exitWithError(exitCodeError, "error running as the leader: %s", err.Error())
+	}
+}
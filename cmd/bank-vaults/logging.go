@@ -0,0 +1,39 @@
+package main
+
+import (
+	"github.com/banzaicloud/bank-vaults/pkg/logging"
+	"github.com/sirupsen/logrus"
+)
+
+const cfgLogFormat = "log-format"
+const cfgLogFormatValueText = "text"
+const cfgLogFormatValueJSON = "json"
+const cfgLogLevel = "log-level"
+const cfgLogLevels = "log-levels"
+
+// initLogging sets the format and the levels of the logs, the library logs through logrus as well
+func initLogging() {
+	var formatter logrus.Formatter
+	switch logFormat := appConfig.GetString(cfgLogFormat); logFormat {
+	case cfgLogFormatValueText:
+		formatter = &logrus.TextFormatter{}
+	case cfgLogFormatValueJSON:
+		formatter = &logrus.JSONFormatter{}
+	default:
+		logrus.Fatalf("invalid --%s: %s, it has to be %s or %s", cfgLogFormat, logFormat, cfgLogFormatValueText, cfgLogFormatValueJSON)
+	}
+
+	level, err := logrus.ParseLevel(appConfig.GetString(cfgLogLevel))
+	if err != nil {
+		logrus.Fatalf("invalid --%s: %s", cfgLogLevel, err.Error())
+	}
+	levels, err := logging.ParseLevels(appConfig.GetString(cfgLogLevels), level)
+	if err != nil {
+		logrus.Fatalf("invalid --%s: %s", cfgLogLevels, err.Error())
+	}
+	// The entries below the level of their component are dropped by the formatter
+	logrus.SetLevel(levels.Max())
+
+	// The secrets known by the library are redacted from the entries logged with logrus directly as well
+	logrus.SetFormatter(logging.NewRedactingFormatter(logging.NewLeveledFormatter(formatter, levels)))
+}
@@ -5,7 +5,27 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
+	"github.com/banzaicloud/bank-vaults/pkg/hook"
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/aeskms"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/alibabakms"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/alibabaoss"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/awskms"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/azurekv"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/consul"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/dev"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/etcd"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/file"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/gckms"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/gcs"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/k8s"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/s3"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/vaulttransit"
+	"github.com/banzaicloud/bank-vaults/pkg/notify"
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -14,42 +34,114 @@ var appConfig *viper.Viper
 
 const cfgSecretShares = "secret-shares"
 const cfgSecretThreshold = "secret-threshold"
+const cfgShareKeyStores = "share-key-stores"
+const cfgEphemeralRootToken = "ephemeral-root-token"
+const cfgProvisionerToken = "provisioner-token"
+const cfgWrapKeysTTL = "wrap-keys-ttl"
 
-const cfgMode = "mode"
-const cfgModeValueAWSKMS3 = "aws-kms-s3"
-const cfgModeValueGoogleCloudKMSGCS = "google-cloud-kms-gcs"
-const cfgModeValueAzureKeyVault = "azure-key-vault"
-const cfgModeValueAlibabaKMSOSS = "alibaba-kms-oss"
-const cfgModeValueK8S = "k8s"
-const cfgModeValueDev = "dev"
+const cfgInitWaitTimeout = "init-wait-timeout"
+const cfgUnsealTimeout = "unseal-timeout"
+const cfgConfigureTimeout = "configure-timeout"
+const cfgKVTimeout = "kv-timeout"
 
-const cfgGoogleCloudKMSProject = "google-cloud-kms-project"
-const cfgGoogleCloudKMSLocation = "google-cloud-kms-location"
-const cfgGoogleCloudKMSKeyRing = "google-cloud-kms-key-ring"
-const cfgGoogleCloudKMSCryptoKey = "google-cloud-kms-crypto-key"
+const cfgRetries = "retries"
+const cfgRetryInterval = "retry-interval"
+const cfgRetryMaxInterval = "retry-max-interval"
+const cfgRetryJitter = "retry-jitter"
+const cfgRetryOverrides = "retry-overrides"
 
-const cfgGoogleCloudStorageBucket = "google-cloud-storage-bucket"
-const cfgGoogleCloudStoragePrefix = "google-cloud-storage-prefix"
+const cfgClusterName = "cluster-name"
+const cfgKeyPrefix = "key-prefix"
+const cfgUnsealKeyName = "unseal-key-name"
+const cfgRecoveryKeyName = "recovery-key-name"
+const cfgRootTokenKeyName = "root-token-key-name"
+const cfgTestKeyName = "test-key-name"
+const cfgKeysMetadataKeyName = "keys-metadata-key-name"
+const cfgProvisionerTokenKeyName = "provisioner-token-key-name"
 
-const cfgAWSKMSRegion = "aws-kms-region"
-const cfgAWSKMSKeyID = "aws-kms-key-id"
+// The key store backends register themselves and their settings in the init functions of their
+// packages, the flags have the names of the settings
+const cfgMode = kv.ModeKey
+const cfgModeValueAWSKMS3 = awskms.Mode
+const cfgModeValueGoogleCloudKMSGCS = gckms.Mode
+const cfgModeValueAzureKeyVault = azurekv.Mode
+const cfgModeValueAlibabaKMSOSS = alibabakms.Mode
+const cfgModeValueK8S = k8s.Mode
+const cfgModeValueDev = dev.Mode
+const cfgModeValueFile = file.Mode
+const cfgModeValueEncrypted = kv.EncryptedMode
 
-const cfgAWSS3Bucket = "aws-s3-bucket"
-const cfgAWSS3Prefix = "aws-s3-prefix"
-const cfgAWSS3Region = "aws-s3-region"
+const cfgEncryptedKMS = kv.EncryptedKMSKey
+const cfgEncryptedKMSValueAWS = awskms.KMSName
+const cfgEncryptedKMSValueGoogleCloud = gckms.KMSName
+const cfgEncryptedKMSValueAzure = azurekv.KMSName
+const cfgEncryptedKMSValueVaultTransit = vaulttransit.KMSName
+const cfgEncryptedKMSValueAES = aeskms.KMSName
 
-const cfgAzureKeyVaultName = "azure-key-vault-name"
+const cfgEncryptedStorage = kv.EncryptedStorageKey
+const cfgEncryptedStorageValueAWSS3 = s3.StorageName
+const cfgEncryptedStorageValueGoogleCloud = gcs.StorageName
+const cfgEncryptedStorageValueAlibabaOSS = alibabaoss.StorageName
+const cfgEncryptedStorageValueK8S = k8s.StorageName
+const cfgEncryptedStorageValueConsul = consul.StorageName
+const cfgEncryptedStorageValueEtcd = etcd.StorageName
 
-const cfgAlibabaOSSEndpoint = "alibaba-oss-endpoint"
-const cfgAlibabaOSSBucket = "alibaba-oss-bucket"
-const cfgAlibabaOSSPrefix = "alibaba-oss-prefix"
-const cfgAlibabaAccessKeyID = "alibaba-access-key-id"
-const cfgAlibabaAccessKeySecret = "alibaba-access-key-secret"
-const cfgAlibabaKMSRegion = "alibaba-kms-region"
-const cfgAlibabaKMSKeyID = "alibaba-kms-key-id"
+const cfgGoogleCloudKMSProject = gckms.ProjectKey
+const cfgGoogleCloudKMSLocation = gckms.LocationKey
+const cfgGoogleCloudKMSKeyRing = gckms.KeyRingKey
+const cfgGoogleCloudKMSCryptoKey = gckms.CryptoKeyKey
 
-const cfgK8SNamespace = "k8s-secret-namespace"
-const cfgK8SSecret = "k8s-secret-name"
+const cfgGoogleCloudStorageBucket = gcs.BucketKey
+const cfgGoogleCloudStoragePrefix = gcs.PrefixKey
+
+const cfgAWSKMSRegion = awskms.RegionKey
+const cfgAWSKMSKeyID = awskms.KeyIDKey
+
+const cfgAWSS3Bucket = s3.BucketKey
+const cfgAWSS3Prefix = s3.PrefixKey
+const cfgAWSS3Region = s3.RegionKey
+
+const cfgAzureKeyVaultName = azurekv.NameKey
+const cfgAzureKeyVaultKeyName = azurekv.KeyNameKey
+
+const cfgAlibabaOSSEndpoint = alibabaoss.EndpointKey
+const cfgAlibabaOSSBucket = alibabaoss.BucketKey
+const cfgAlibabaOSSPrefix = alibabaoss.PrefixKey
+const cfgAlibabaAccessKeyID = alibabaoss.AccessKeyIDKey
+const cfgAlibabaAccessKeySecret = alibabaoss.AccessKeySecretKey
+const cfgAlibabaRAMRole = alibabaoss.RAMRoleKey
+const cfgAlibabaKMSRegion = alibabakms.RegionKey
+const cfgAlibabaKMSKeyID = alibabakms.KeyIDKey
+
+const cfgTransitVaultAddr = vaulttransit.AddressKey
+const cfgTransitVaultToken = vaulttransit.TokenKey
+const cfgTransitVaultRoleID = vaulttransit.RoleIDKey
+const cfgTransitVaultSecretID = vaulttransit.SecretIDKey
+const cfgTransitVaultAppRolePath = vaulttransit.AppRolePathKey
+const cfgTransitMount = vaulttransit.MountKey
+const cfgTransitKeyName = vaulttransit.KeyNameKey
+
+const cfgAESKeyFile = aeskms.KeyFileKey
+const cfgAESPassphrase = aeskms.PassphraseKey
+
+const cfgConsulAddress = consul.AddressKey
+const cfgConsulToken = consul.TokenKey
+const cfgConsulPrefix = consul.PrefixKey
+
+const cfgEtcdEndpoints = etcd.EndpointsKey
+const cfgEtcdPrefix = etcd.PrefixKey
+const cfgEtcdCACert = etcd.CACertKey
+const cfgEtcdCert = etcd.CertKey
+const cfgEtcdKey = etcd.KeyKey
+const cfgEtcdUsername = etcd.UsernameKey
+const cfgEtcdPassword = etcd.PasswordKey
+
+const cfgFilePath = file.PathKey
+const cfgFileGPGKeys = file.GPGKeysKey
+const cfgFileGPGHome = file.GPGHomeKey
+
+const cfgK8SNamespace = k8s.NamespaceKey
+const cfgK8SSecret = k8s.SecretKey
 
 var rootCmd = &cobra.Command{
 	Use:   "bank-vaults",
@@ -60,7 +152,12 @@ var rootCmd = &cobra.Command{
 // Execute adds all child commands to the root command sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func execute() {
-	if err := rootCmd.Execute(); err != nil {
+	handleSignals()
+	logrus.RegisterExitHandler(cleanup)
+
+	err := rootCmd.Execute()
+	cleanup()
+	if err != nil {
 		fmt.Println(err)
 		os.Exit(-1)
 	}
@@ -71,11 +168,31 @@ func configIntVar(key string, defaultValue int, description string) {
 	appConfig.BindPFlag(key, rootCmd.PersistentFlags().Lookup(key))
 }
 
+func configDurationVar(key string, defaultValue time.Duration, description string) {
+	rootCmd.PersistentFlags().Duration(key, defaultValue, description)
+	appConfig.BindPFlag(key, rootCmd.PersistentFlags().Lookup(key))
+}
+
+func configFloat64Var(key string, defaultValue float64, description string) {
+	rootCmd.PersistentFlags().Float64(key, defaultValue, description)
+	appConfig.BindPFlag(key, rootCmd.PersistentFlags().Lookup(key))
+}
+
 func configStringVar(key, defaultValue, description string) {
 	rootCmd.PersistentFlags().String(key, defaultValue, description)
 	appConfig.BindPFlag(key, rootCmd.PersistentFlags().Lookup(key))
 }
 
+func configBoolVar(key string, defaultValue bool, description string) {
+	rootCmd.PersistentFlags().Bool(key, defaultValue, description)
+	appConfig.BindPFlag(key, rootCmd.PersistentFlags().Lookup(key))
+}
+
+func configStringSliceVar(key string, defaultValue []string, description string) {
+	rootCmd.PersistentFlags().StringSlice(key, defaultValue, description)
+	appConfig.BindPFlag(key, rootCmd.PersistentFlags().Lookup(key))
+}
+
 func init() {
 	appConfig = viper.New()
 	appConfig.SetEnvPrefix("bank_vaults")
@@ -93,18 +210,33 @@ func init() {
 						'%s' => Azure Key Vault secret;
 						'%s' => Alibaba OSS with KMS encryption;
 						'%s' => Kubernetes Secrets;
-						'%s' => Dev (local) mode`,
+						'%s' => Dev (local) mode;
+						'%s' => Files of a local directory, optionally encrypted with GPG;
+						'%s' => any --%s encrypting the values stored in any --%s%s`,
 			cfgModeValueGoogleCloudKMSGCS,
 			cfgModeValueAWSKMS3,
 			cfgModeValueAzureKeyVault,
 			cfgModeValueAlibabaKMSOSS,
 			cfgModeValueK8S,
-			cfgModeValueDev),
+			cfgModeValueDev,
+			cfgModeValueFile,
+			cfgModeValueEncrypted,
+			cfgEncryptedKMS,
+			cfgEncryptedStorage,
+			otherKVStoresHelp()),
 	)
 
 	// Secret config
 	configIntVar(cfgSecretShares, 5, "Total count of secret shares that exist")
 	configIntVar(cfgSecretThreshold, 3, "Minimum required secret shares to unseal")
+	configBoolVar(cfgEphemeralRootToken, false, "Never persist a long-lived root token: revoke the initial one instead of storing it, and generate a short-lived root token with the stored keys whenever it is needed")
+	configBoolVar(cfgProvisionerToken, false, "Least-privilege mode: configure, diff and export Vault with a periodic token of the "+vault.ProvisionerPolicy+" policy from the key store instead of the root token, created with the root token during init (or when it is missing or revoked)")
+	configDurationVar(cfgWrapKeysTTL, 0, "Key handoff mode: don't store the unseal or recovery keys during init, hand them off in response-wrapping tokens of this TTL (e.g. 24h) sent as "+notify.EventKeyWrapped+" notifications and printed to the standard output")
+	configStringSliceVar(cfgShareKeyStores, nil, "Comma separated list of YAML/JSON files holding the key store flags (mode, etc.) of independent key stores the secret shares are distributed across, share i is stored in the i % N-th one")
+
+	// Encrypted mode flags
+	configStringVar(cfgEncryptedKMS, "", "The KMS encrypting the values in the "+cfgModeValueEncrypted+" mode ("+cfgEncryptedKMSValueAWS+", "+cfgEncryptedKMSValueGoogleCloud+", "+cfgEncryptedKMSValueAzure+", "+cfgEncryptedKMSValueVaultTransit+", "+cfgEncryptedKMSValueAES+")")
+	configStringVar(cfgEncryptedStorage, "", "The storage of the encrypted values in the "+cfgModeValueEncrypted+" mode ("+cfgEncryptedStorageValueAWSS3+", "+cfgEncryptedStorageValueGoogleCloud+", "+cfgEncryptedStorageValueAlibabaOSS+", "+cfgEncryptedStorageValueK8S+", "+cfgEncryptedStorageValueConsul+", "+cfgEncryptedStorageValueEtcd+")")
 
 	// Google Cloud KMS flags
 	configStringVar(cfgGoogleCloudKMSProject, "", "The Google Cloud KMS project to use")
@@ -127,10 +259,21 @@ func init() {
 
 	// Azure Key Vault flags
 	configStringVar(cfgAzureKeyVaultName, "", "The name of the Azure Key Vault to encrypt and store values in")
+	configStringVar(cfgAzureKeyVaultKeyName, "", "The name of the RSA key of the Azure Key Vault to encrypt values with in the "+cfgModeValueEncrypted+" mode")
+
+	// Vault Transit flags
+	configStringVar(cfgTransitVaultAddr, "", "The address of the Vault cluster whose transit engine encrypts the values (VAULT_ADDR by default)")
+	configStringVar(cfgTransitVaultToken, "", "The token to use the transit engine with (VAULT_TOKEN by default)")
+	configStringVar(cfgTransitVaultRoleID, "", "The AppRole role_id to log in to the transit Vault with instead of the token")
+	configStringVar(cfgTransitVaultSecretID, "", "The AppRole secret_id to log in to the transit Vault with")
+	configStringVar(cfgTransitVaultAppRolePath, "approle", "The path of the AppRole auth method of the transit Vault")
+	configStringVar(cfgTransitMount, "transit", "The path of the transit engine")
+	configStringVar(cfgTransitKeyName, "", "The name of the transit key to encrypt values with")
 
 	// Alibaba Access Key flags
 	configStringVar(cfgAlibabaAccessKeyID, "", "The Alibaba AccessKeyID to use")
 	configStringVar(cfgAlibabaAccessKeySecret, "", "The Alibaba AccessKeySecret to use")
+	configStringVar(cfgAlibabaRAMRole, "", "The RAM role of the ECS instance to use instead of an AccessKey")
 
 	// Alibaba KMS flags
 	configStringVar(cfgAlibabaKMSRegion, "", "The region where the Alibaba KMS key relies")
@@ -141,9 +284,123 @@ func init() {
 	configStringVar(cfgAlibabaOSSBucket, "", "The name of the Alibaba OSS bucket to store values in")
 	configStringVar(cfgAlibabaOSSPrefix, "", "The prefix to use for values store in Alibaba OSS")
 
+	// Vault client flags
+	configStringVar(cfgVaultAddr, "", "The address of Vault (VAULT_ADDR by default)")
+	configStringVar(cfgVaultCACert, "", "The PEM encoded CA certificate file to verify the certificate of Vault with (VAULT_CACERT by default)")
+	configStringVar(cfgVaultClientCert, "", "The PEM encoded client certificate file for TLS authentication to Vault (VAULT_CLIENT_CERT by default)")
+	configStringVar(cfgVaultClientKey, "", "The PEM encoded private key file of the client certificate (VAULT_CLIENT_KEY by default)")
+	configBoolVar(cfgVaultTLSSkipVerify, false, "Don't verify the certificate of Vault, insecure (VAULT_SKIP_VERIFY by default)")
+	configStringVar(cfgVaultNamespace, "", "The Vault Enterprise namespace to send the requests to")
+	configStringVar(cfgVaultAgentAddr, "", "The address of a Vault agent to send the requests to instead of Vault (VAULT_AGENT_ADDR by default)")
+	configStringVar(cfgVaultTLSServerName, "", "The name to verify the certificate of Vault with, the host of the address by default (VAULT_TLS_SERVER_NAME by default)")
+	configStringVar(cfgVaultProxy, "", "The URL of the HTTP proxy to connect to Vault through (HTTPS_PROXY by default)")
+	configDurationVar(cfgVaultClientTimeout, 0, "The timeout of the requests to Vault (VAULT_CLIENT_TIMEOUT, 60s by default)")
+	configStringVar(cfgVaultConnectionFile, "", "A YAML/JSON file with the connection settings of Vault in its vault block (address, caCert, caCertPEM, clientCert, clientKey, clientCertPEM, clientKeyPEM, tlsServerName, tlsSkipVerify, ...), overridden by the flags")
+
+	// Vault client TLS flags
+	configStringVar(cfgVaultTLSSecret, "", "The name of the K8S Secret holding the CA bundle (ca.crt) and client certificate (tls.crt, tls.key) to connect to Vault with")
+	configStringVar(cfgVaultTLSSecretNamespace, "", "The namespace of the K8S Secret holding the Vault client TLS settings (defaults to POD_NAMESPACE)")
+
+	// Local AES KMS flags
+	configStringVar(cfgAESKeyFile, "", "The file of the 32 bytes AES key (hex or base64 encoded) to encrypt values with")
+	configStringVar(cfgAESPassphrase, "", "The passphrase to derive the AES keys from instead of a key file")
+
+	// Consul Storage flags
+	configStringVar(cfgConsulAddress, "", "The address of the Consul agent to store values in (CONSUL_HTTP_ADDR by default)")
+	configStringVar(cfgConsulToken, "", "The ACL token of Consul (CONSUL_HTTP_TOKEN by default)")
+	configStringVar(cfgConsulPrefix, "", "The prefix to use for storing values in Consul")
+
+	// etcd Storage flags
+	configStringVar(cfgEtcdEndpoints, "", "The comma separated endpoints of the etcd v3 cluster to store values in")
+	configStringVar(cfgEtcdPrefix, "", "The prefix to use for storing values in etcd")
+	configStringVar(cfgEtcdCACert, "", "The PEM encoded CA certificate file to verify the certificate of etcd with")
+	configStringVar(cfgEtcdCert, "", "The PEM encoded client certificate file for TLS authentication to etcd")
+	configStringVar(cfgEtcdKey, "", "The PEM encoded private key file of the etcd client certificate")
+	configStringVar(cfgEtcdUsername, "", "The user to authenticate to etcd with")
+	configStringVar(cfgEtcdPassword, "", "The password of the etcd user")
+
+	// File Storage flags
+	configStringVar(cfgFilePath, "", "The directory to store the values in with the "+cfgModeValueFile+" mode")
+	configStringVar(cfgFileGPGKeys, "", "Comma separated list of files holding binary, armored or base64 encoded GPG public keys to encrypt the values to with the "+cfgModeValueFile+" mode")
+	configStringVar(cfgFileGPGHome, "", "The GnuPG home directory holding the private key to decrypt the values with (GNUPGHOME by default)")
+
 	// K8S Secret Storage flags
-	configStringVar(cfgK8SNamespace, "", "The namespace of the K8S Secret to store values in")
+	configStringVar(cfgK8SNamespace, "", "The namespace of the K8S Secret to store values in (defaults to POD_NAMESPACE)")
 	configStringVar(cfgK8SSecret, "", "The name of the K8S Secret to store values in")
+
+	// Key name flags, templates with {{.Cluster}} and {{.Index}} (of the unseal and recovery keys)
+	configStringVar(cfgClusterName, "", "The name of the Vault cluster in the key name templates ({{.Cluster}}), the name of the cluster with --clusters-config")
+	configStringVar(cfgKeyPrefix, "", "The prefix of the names of every key in the key store (e.g. prod-eu/), so several Vault clusters can share a key store with the default names")
+	configStringVar(cfgUnsealKeyName, vault.DefaultUnsealKeyName, "The name template of the unseal keys in the key store")
+	configStringVar(cfgRecoveryKeyName, vault.DefaultRecoveryKeyName, "The name template of the recovery keys in the key store")
+	configStringVar(cfgRootTokenKeyName, vault.DefaultRootTokenKeyName, "The name template of the root token in the key store")
+	configStringVar(cfgTestKeyName, vault.DefaultTestKeyName, "The name template of the key written to test the key store before init")
+	configStringVar(cfgKeysMetadataKeyName, vault.DefaultKeysMetadataName, "The name template of the metadata of the keys in the key store")
+	configStringVar(cfgProvisionerTokenKeyName, vault.DefaultProvisionerTokenKeyName, "The name template of the provisioner token in the key store")
+
+	// Timeout flags, 0 means no timeout
+	configDurationVar(cfgInitWaitTimeout, 10*time.Minute, "How long to wait for Vault to be unsealed during init to set up the --init-root-token, 0 means forever")
+	configDurationVar(cfgUnsealTimeout, time.Minute, "The timeout of each unseal request to Vault, 0 means no timeout")
+	configDurationVar(cfgConfigureTimeout, 0, "The timeout of the whole configuration of Vault, 0 means no timeout")
+	configDurationVar(cfgKVTimeout, time.Minute, "The timeout of each request to the key store (including the KMS), 0 means no timeout")
+
+	// Retry flags
+	configIntVar(cfgRetries, 3, "How many times the requests to Vault and the key store are retried if they fail with a retryable error (e.g. connection refused, 429 or 5xx), 0 disables the retries")
+	configDurationVar(cfgRetryInterval, time.Second, "The time before the first retry of a failed request, doubled after every retry")
+	configDurationVar(cfgRetryMaxInterval, 30*time.Second, "The maximum time between the retries of a failed request")
+	configFloat64Var(cfgRetryJitter, 0.2, "The fraction of the time between the retries which is randomized, so the replicas don't retry in lockstep, 0 disables the jitter")
+	configStringVar(cfgRetryOverrides, "", "Comma separated list of operation=retries overriding --"+cfgRetries+" for a kind of operations ("+string(vault.RetryVault)+", "+string(vault.RetryKeyStore)+", "+string(vault.RetryUnseal)+"), e.g. key-store=10")
+
+	// Circuit breaker flags
+	configIntVar(cfgCircuitBreakerThreshold, 5, "The number of consecutive failed requests to Vault or the key store after which the requests fail without being sent until the next probe, disabled if 0")
+	configDurationVar(cfgCircuitBreakerInterval, 10*time.Second, "The time until the first probe request to an unavailable Vault or key store, doubled after every failed probe")
+	configDurationVar(cfgCircuitBreakerMaxInterval, 5*time.Minute, "The maximum time between the probe requests to an unavailable Vault or key store")
+
+	// Lock flags
+	configStringVar(cfgLock, cfgLockValueNone, "The lock held by the replicas while initializing or configuring Vault, so only one of them does it at a time ("+cfgLockValueNone+", "+cfgLockValueKeyStore+", "+cfgLockValueConfigMap+" in POD_NAMESPACE)")
+	configStringVar(cfgLockName, "bank-vaults-lock", "The name of the lock, the key in the key store or the name of the ConfigMap")
+	configDurationVar(cfgLockTTL, time.Minute, "The lease of the lock, it is renewed while held, and taken over by the other replicas after it expires")
+	configDurationVar(cfgLockTimeout, 0, "How long to wait for the lock held by another replica, 0 means forever")
+
+	// Shutdown flags
+	configDurationVar(cfgShutdownTimeout, 30*time.Second, "How long to wait for the running command to stop after SIGTERM or SIGINT before exiting")
+
+	// Metrics flags
+	configStringVar(cfgMetricsAddr, "", "The address to serve the Prometheus metrics of the unseal and configure commands on (e.g. :9091), disabled if empty")
+
+	// Admin flags
+	configStringVar(cfgAdminAddr, "", "The address to serve /healthz, /readyz, /status and /metrics of the unseal and configure commands on (e.g. :8080), disabled if empty")
+	configBoolVar(cfgAdminDebug, false, "Also serve the pprof profiles on /debug/pprof/ and the runtime statistics on /debug/runtime on --"+cfgAdminAddr)
+
+	// Logging flags
+	configStringVar(cfgLogFormat, cfgLogFormatValueText, "The format of the logs ("+cfgLogFormatValueText+", "+cfgLogFormatValueJSON+")")
+	configStringVar(cfgLogLevel, "info", "The minimum level of the logs (debug, info, warning, error)")
+	configStringVar(cfgLogLevels, "", "Comma separated list of component=level overriding --"+cfgLogLevel+" for the logs of a component (vault, vault-client, runner), e.g. vault=debug,runner=warning")
+	cobra.OnInitialize(initLogging)
+
+	// Notification flags
+	configStringVar(cfgNotifyWebhookURL, "", "The URL to post the lifecycle events (initialized, sealed, unsealed, ...) to as JSON, disabled if empty")
+	configStringVar(cfgNotifySlackWebhookURL, "", "The Slack incoming webhook URL to send the lifecycle events to, disabled if empty")
+	configStringVar(cfgNotifyPagerDutyRoutingKey, "", "The PagerDuty Events API v2 routing key to trigger and resolve incidents of seals and configuration failures with, disabled if empty")
+	configStringVar(cfgNotifyExecCommand, "", "The command to run for the lifecycle events with the event as JSON on its standard input, and its type and target in the BANK_VAULTS_EVENT_TYPE and BANK_VAULTS_EVENT_TARGET environment variables, disabled if empty")
+	configStringVar(cfgNotifyWebhookTemplate, "", "A text/template of the body posted to --"+cfgNotifyWebhookURL+" instead of the JSON of the event")
+	configStringVar(cfgNotifySlackTemplate, "", "A text/template of the Slack messages instead of the summary of the event")
+	configStringVar(cfgNotifyExecTemplate, "", "A text/template of the standard input of --"+cfgNotifyExecCommand+" instead of the JSON of the event")
+	configStringVar(cfgNotifyEvents, "", "Comma-separated list of the lifecycle events to notify about ("+strings.Join(notify.EventTypes, ", ")+"), all of them if empty")
+
+	// Hook flags
+	configStringVar(cfgHookCommand, "", "The command to run before and after the lifecycle phases (init, unseal, configure, ...) with the event as JSON on its standard input, a non-zero exit status aborts the phase in the pre stage, disabled if empty")
+	configStringVar(cfgHookWebhookURL, "", "The URL to post the events of the lifecycle phases to as JSON, a non-2xx response aborts the phase in the pre stage, disabled if empty")
+	configStringVar(cfgHookEvents, "", "Comma-separated list of the events to call the hooks for ("+strings.Join(hook.Events(), ", ")+"), all of them if empty")
+	configDurationVar(cfgHookTimeout, hook.DefaultTimeout, "The timeout of the hook command and webhook calls")
+
+	// Configurator flags
+	configStringVar(cfgConfiguratorPlugins, "", "Comma-separated list of Go plugins (.so files) registering configurators of the custom sections of the Vault configuration")
+	cobra.OnInitialize(loadConfiguratorPlugins)
+
+	// Tracing flags
+	configStringVar(cfgOTLPEndpoint, "", "The OTLP/HTTP endpoint to export the traces to (e.g. http://otel-collector:4318), OTEL_EXPORTER_OTLP_ENDPOINT by default, disabled if empty")
+	cobra.OnInitialize(initTracing)
 }
 
 func main() {
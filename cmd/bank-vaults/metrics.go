@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/banzaicloud/bank-vaults/pkg/circuit"
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/banzaicloud/bank-vaults/pkg/metrics"
+	"github.com/banzaicloud/bank-vaults/pkg/tracing"
+	"github.com/sirupsen/logrus"
+)
+
+const cfgMetricsAddr = "metrics-addr"
+
+// Results of the lifecycle actions in the metrics
+const (
+	metricsResultSuccess = "success"
+	metricsResultFailure = "failure"
+)
+
+// initDurationBuckets are the upper bounds of the buckets of the init duration in seconds, it includes
+// the requests to the KMS and the key store of every key
+var initDurationBuckets = []float64{0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+var (
+	unsealAttemptsTotal = metrics.NewCounter(
+		"bank_vaults_unseal_attempts_total",
+		"Number of attempts to unseal Vault.",
+		"target", "result")
+	vaultSealed = metrics.NewGauge(
+		"bank_vaults_vault_sealed",
+		"Whether Vault was sealed at the last check (1) or not (0).",
+		"target")
+	initTotal = metrics.NewCounter(
+		"bank_vaults_init_total",
+		"Number of initializations of Vault.",
+		"target", "result")
+	initDuration = metrics.NewHistogram(
+		"bank_vaults_init_duration_seconds",
+		"Duration of the initializations of Vault, including storing the keys.",
+		initDurationBuckets,
+		"target")
+	configureRunsTotal = metrics.NewCounter(
+		"bank_vaults_configure_runs_total",
+		"Number of runs of the configuration of Vault.",
+		"result")
+	configureFailedResourcesTotal = metrics.NewCounter(
+		"bank_vaults_configure_failed_resources_total",
+		"Number of resources which failed to be configured, by their kinds.",
+		"kind")
+	lastConfigureTimestamp = metrics.NewGauge(
+		"bank_vaults_last_configure_timestamp_seconds",
+		"Time of the last configuration of Vault (reconciliation), successful or not.")
+	lastConfigureSuccessTimestamp = metrics.NewGauge(
+		"bank_vaults_last_configure_success_timestamp_seconds",
+		"Time of the last successful configuration of Vault.")
+	configureDriftResources = metrics.NewGauge(
+		"bank_vaults_configure_drift_resources",
+		"Number of resources which didn't read back from Vault as configured after the last successful configuration.")
+	vaultRequestsTotal = metrics.NewCounter(
+		"bank_vaults_vault_requests_total",
+		"Number of requests to the Vault API by HTTP method and status code, the code is error if no response was received.",
+		"method", "code")
+	kvRequestDuration = metrics.NewHistogram(
+		"bank_vaults_kv_request_duration_seconds",
+		"Latency of the requests to the key store.",
+		metrics.DefaultBuckets,
+		"backend", "operation")
+	kvErrorsTotal = metrics.NewCounter(
+		"bank_vaults_kv_errors_total",
+		"Number of failed requests to the key store, missing keys are not counted.",
+		"backend", "operation")
+	circuitBreakerState = metrics.NewGauge(
+		"bank_vaults_circuit_breaker_state",
+		"State of the circuit breaker of a backend: closed (0), half-open (1) or open (2).",
+		"backend", "endpoint")
+	circuitBreakerOpenedTotal = metrics.NewCounter(
+		"bank_vaults_circuit_breaker_opened_total",
+		"Number of times the circuit breaker of a backend has been opened.",
+		"backend", "endpoint")
+)
+
+// serveMetrics serves the metrics on --metrics-addr in the background, if it is set
+func serveMetrics() {
+	addr := appConfig.GetString(cfgMetricsAddr)
+	if addr == "" {
+		return
+	}
+	go func() {
+		logrus.Errorf("error serving metrics: %s", metrics.Serve(addr))
+	}()
+}
+
+// instrumentedTransport counts the requests sent to Vault by their status codes and traces them,
+// and stops sending them with its circuit breaker while Vault is unavailable
+type instrumentedTransport struct {
+	next    http.RoundTripper
+	tracer  *tracing.Tracer
+	breaker *circuit.Breaker
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if shuttingDown() {
+		return nil, errShuttingDown
+	}
+	if err := t.breaker.Allow(); err != nil {
+		vaultRequestsTotal.Inc(req.Method, "circuit-open")
+		return nil, err
+	}
+
+	span := t.tracer.StartClientSpan(fmt.Sprintf("vault %s %s", req.Method, req.URL.Path),
+		tracing.Attribute{Key: "http.method", Value: req.Method},
+		tracing.Attribute{Key: "http.url", Value: req.URL.String()})
+
+	// The request is cancelled if the process shuts down before its response has been read
+	ctx, cancel := context.WithCancel(req.Context())
+	stop := context.AfterFunc(shutdownContext, cancel)
+	release := func() {
+		stop()
+		cancel()
+	}
+
+	resp, err := t.next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		release()
+		if !shuttingDown() {
+			t.breaker.Record(true)
+		}
+		vaultRequestsTotal.Inc(req.Method, "error")
+		span.End(err)
+		return resp, err
+	}
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: release}
+
+	// A sealed Vault answers most requests with 503
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		t.breaker.Record(true)
+	default:
+		t.breaker.Record(false)
+	}
+
+	vaultRequestsTotal.Inc(req.Method, strconv.Itoa(resp.StatusCode))
+	span.SetAttribute("http.status_code", strconv.Itoa(resp.StatusCode))
+	if resp.StatusCode >= 400 {
+		span.End(fmt.Errorf("%s", resp.Status))
+	} else {
+		span.End(nil)
+	}
+	return resp, nil
+}
+
+// instrumentedKVStore measures the latency, counts the errors and traces the requests to a key store,
+// and stops sending them with its circuit breaker while the key store is unavailable
+type instrumentedKVStore struct {
+	kv.Service
+	backend string
+	tracer  *tracing.Tracer
+	breaker *circuit.Breaker
+}
+
+func (s *instrumentedKVStore) observe(operation, key string, f func() error) error {
+	// The calls of the key store clients can't be cancelled, the ones in flight are bounded by --kv-timeout
+	if shuttingDown() {
+		return errShuttingDown
+	}
+	if err := s.breaker.Allow(); err != nil {
+		return err
+	}
+
+	span := s.tracer.StartClientSpan("kv "+operation,
+		tracing.Attribute{Key: "kv.backend", Value: s.backend},
+		tracing.Attribute{Key: "kv.key", Value: key})
+	start := time.Now()
+
+	err := f()
+
+	kvRequestDuration.Observe(time.Since(start).Seconds(), s.backend, operation)
+	if err != nil && !isKVResult(err) {
+		s.breaker.Record(true)
+		kvErrorsTotal.Inc(s.backend, operation)
+		span.End(err)
+	} else {
+		s.breaker.Record(false)
+		span.End(nil)
+	}
+	return err
+}
+
+// isKVResult tells whether the error is an answer of the key store rather than a failure of it
+func isKVResult(err error) bool {
+	switch err.(type) {
+	case *kv.NotFoundError, *kv.AlreadyExistsError:
+		return true
+	}
+	return false
+}
+
+func (s *instrumentedKVStore) Get(key string) (value []byte, err error) {
+	err = s.observe("get", key, func() error {
+		value, err = s.Service.Get(key)
+		return err
+	})
+	return value, err
+}
+
+func (s *instrumentedKVStore) Set(key string, value []byte) error {
+	return s.observe("set", key, func() error { return s.Service.Set(key, value) })
+}
+
+func (s *instrumentedKVStore) Create(key string, value []byte) error {
+	return s.observe("create", key, func() error { return kv.Create(s.Service, key, value) })
+}
+
+func (s *instrumentedKVStore) Test(key string) error {
+	return s.observe("test", key, func() error { return s.Service.Test(key) })
+}
+
+func (s *instrumentedKVStore) Delete(key string) error {
+	return s.observe("delete", key, func() error { return kv.Delete(s.Service, key) })
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const cfgSourceConfig = "source-config"
+const cfgDestinationConfig = "destination-config"
+const cfgDeleteSource = "delete-source"
+
+// migrateKeysOutput is the machine-readable result of the migrate-keys command
+type migrateKeysOutput struct {
+	Keys          []string `json:"keys"`
+	SourceDeleted bool     `json:"sourceDeleted"`
+}
+
+var migrateKeysCmd = &cobra.Command{
+	Use:   "migrate-keys",
+	Short: "Copies the unseal keys and the root token to another key store",
+	Long: `It copies the root token, the unseal keys and the recovery keys from the key store given by the
+flags of the command (--mode, etc.) to the key store described by the YAML/JSON file of
+--destination-config, which holds the same settings as the command line flags, for example:
+
+  mode: aws-kms-s3
+  aws-kms-key-id: 9f054126-2a98-470c-9f10-9b3b0cad94a1
+  aws-s3-bucket: bank-vaults
+
+The source key store can be described by such a file too with --source-config, the settings missing
+from the files are taken from the command line flags.
+
+The keys are re-encrypted with the encryption of the destination and verified by reading them
+back. With --delete-source the keys are deleted from the source key store after verification.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		appConfig.BindPFlag(cfgSourceConfig, cmd.PersistentFlags().Lookup(cfgSourceConfig))
+		appConfig.BindPFlag(cfgDestinationConfig, cmd.PersistentFlags().Lookup(cfgDestinationConfig))
+		appConfig.BindPFlag(cfgDeleteSource, cmd.PersistentFlags().Lookup(cfgDeleteSource))
+		appConfig.BindPFlag(cfgOutput, cmd.PersistentFlags().Lookup(cfgOutput))
+
+		output := appConfig.GetString(cfgOutput)
+		checkOutput(output, cfgOutputValueText, cfgOutputValueJSON, cfgOutputValueYAML)
+
+		destinationConfigFile := appConfig.GetString(cfgDestinationConfig)
+		if destinationConfigFile == "" {
+			logrus.Fatalf("--%s is required", cfgDestinationConfig)
+		}
+
+		var err error
+		sourceCfg := appConfig
+		if sourceConfigFile := appConfig.GetString(cfgSourceConfig); sourceConfigFile != "" {
+			sourceCfg, err = keyStoreConfigForFile(sourceConfigFile)
+			if err != nil {
+				logrus.Fatalf("error reading source config: %s", err.Error())
+			}
+		}
+
+		destinationCfg, err := keyStoreConfigForFile(destinationConfigFile)
+		if err != nil {
+			logrus.Fatalf("error reading destination config: %s", err.Error())
+		}
+
+		source, err := kvStoreForConfig(sourceCfg)
+
+		if err != nil {
+			exitWithError(exitCodeKeyStoreError, "error creating source kv store: %s", err.Error())
+		}
+
+		destination, err := kvStoreForConfig(destinationCfg)
+
+		if err != nil {
+			exitWithError(exitCodeKeyStoreError, "error creating destination kv store: %s", err.Error())
+		}
+
+		ids, err := vault.MigrateKeys(source, destination, keyNamesForConfig(sourceCfg), keyNamesForConfig(destinationCfg))
+
+		if err != nil {
+			exitWithError(exitCodeKeyStoreError, "error migrating keys: %s", err.Error())
+		}
+
+		logrus.Infof("%d keys migrated and verified", len(ids))
+
+		result := migrateKeysOutput{Keys: ids}
+
+		if appConfig.GetBool(cfgDeleteSource) {
+			for _, id := range ids {
+				if err := kv.Delete(source, id); err != nil {
+					exitWithError(exitCodeKeyStoreError, "error deleting key '%s' from the source key store: %s", id, err.Error())
+				}
+				logrus.WithField("key", id).Info("key deleted from the source key store")
+			}
+			result.SourceDeleted = true
+		}
+
+		if output != cfgOutputValueText {
+			writeOutput(output, result)
+		}
+	},
+}
+
+// keyStoreConfigForFile returns the settings of a key store read from the YAML/JSON file, on top of
+// the command line flags
+func keyStoreConfigForFile(file string) (*viper.Viper, error) {
+	cfg := viper.New()
+	cfg.SetConfigFile(file)
+	if err := cfg.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("error reading %s: %s", file, err.Error())
+	}
+	return viperWithOptions(cfg.AllSettings()), nil
+}
+
+func init() {
+	migrateKeysCmd.PersistentFlags().String(cfgSourceConfig, "", "The YAML/JSON file holding the key store flags of the source (mode, etc.), the command line flags if empty")
+	migrateKeysCmd.PersistentFlags().String(cfgDestinationConfig, "", "The YAML/JSON file holding the key store flags of the destination (mode, etc.)")
+	migrateKeysCmd.PersistentFlags().Bool(cfgDeleteSource, false, "Delete the keys from the source key store after they have been verified in the destination")
+
+	migrateKeysCmd.PersistentFlags().String(cfgOutput, cfgOutputValueText, outputHelp(cfgOutputValueText, cfgOutputValueJSON, cfgOutputValueYAML)+", only logs are written in the "+cfgOutputValueText+" format")
+
+	rootCmd.AddCommand(migrateKeysCmd)
+}
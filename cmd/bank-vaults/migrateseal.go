@@ -0,0 +1,86 @@
+package main
+
+import (
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// sealMigrationOutput is the machine-readable result of the migrate-seal command of a node
+type sealMigrationOutput struct {
+	Address string `json:"address"`
+	*vault.SealMigrationResult
+}
+
+var migrateSealCmd = &cobra.Command{
+	Use:   "migrate-seal",
+	Short: "Migrates Vault between a Shamir seal and an auto-unseal seal",
+	Long: `It completes the seal migration of the Vault nodes given with --addresses (VAULT_ADDR by
+default), one by one, after they have been restarted with the new seal in migration mode (with
+the -migrate flag, or with the old seal disabled in the config of Vault). Every node is unsealed
+with the keys in the key store and the migrate option, then the keys are stored as the keys of
+the new seal: the unseal keys of a Shamir seal become the recovery keys of an auto-unseal seal
+(e.g. awskms) and vice versa, and the metadata of the keys records the new seal type.
+
+The keys keep their values, so the standby nodes of an HA cluster can be migrated before the
+active one, and an interrupted migration can be completed by running it again.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		appConfig.BindPFlag(cfgAddresses, cmd.PersistentFlags().Lookup(cfgAddresses))
+		appConfig.BindPFlag(cfgOutput, cmd.PersistentFlags().Lookup(cfgOutput))
+
+		output := appConfig.GetString(cfgOutput)
+		checkOutput(output, cfgOutputValueJSON, cfgOutputValueYAML)
+
+		store, err := kvStoreForConfig(appConfig)
+
+		if err != nil {
+			exitWithError(exitCodeKeyStoreError, "error creating kv store: %s", err.Error())
+		}
+
+		vaultConfig, err := vaultConfigForConfig(appConfig)
+
+		if err != nil {
+			logrus.Fatalf("error building vault config: %s", err.Error())
+		}
+
+		config, err := vaultClientConfig()
+
+		if err != nil {
+			logrus.Fatalf("error building vault client config: %s", err.Error())
+		}
+
+		addresses := appConfig.GetStringSlice(cfgAddresses)
+		if len(addresses) == 0 {
+			addresses = []string{config.Address}
+		}
+
+		result := []sealMigrationOutput{}
+		for _, address := range addresses {
+			cl, err := vaultClients.Client(vault.Endpoint{Address: address})
+			if err != nil {
+				logrus.Fatalf("error connecting to vault: %s", err.Error())
+			}
+
+			v, err := vault.New(store, cl, vaultConfig)
+			if err != nil {
+				logrus.Fatalf("error creating vault helper: %s", err.Error())
+			}
+
+			migration, err := v.MigrateSeal(shutdownContext)
+			if err != nil {
+				logrus.Fatalf("error migrating the seal of %s: %s", address, err.Error())
+			}
+			logrus.Infof("the seal of %s has been migrated to %s", address, migration.SealType)
+			result = append(result, sealMigrationOutput{Address: address, SealMigrationResult: migration})
+		}
+
+		writeOutput(output, result)
+	},
+}
+
+func init() {
+	migrateSealCmd.PersistentFlags().StringSlice(cfgAddresses, nil, "Comma separated list of the Vault node addresses to migrate the seal of one by one, VAULT_ADDR by default")
+	migrateSealCmd.PersistentFlags().String(cfgOutput, cfgOutputValueJSON, outputHelp(cfgOutputValueJSON, cfgOutputValueYAML))
+
+	rootCmd.AddCommand(migrateSealCmd)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/banzaicloud/bank-vaults/pkg/lock"
+	"github.com/banzaicloud/bank-vaults/pkg/logging"
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// unsealNodeLocal continuously unseals the Vault pods matching selector which are scheduled to
+// the same node as this pod, this is used when bank-vaults runs as a DaemonSet
+func unsealNodeLocal(ctx context.Context, store kv.Service, locker lock.Locker, vaultConfig vault.Config, selector string) {
+	nodeName := os.Getenv("NODE_NAME")
+	if nodeName == "" {
+		logrus.Fatalf("NODE_NAME has to be set when unsealing node local vault pods")
+	}
+
+	unsealPods(ctx, store, locker, vaultConfig, metav1.ListOptions{
+		LabelSelector: selector,
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", nodeName).String(),
+	}, "on node "+nodeName, nil)
+}
+
+// unsealPods continuously unseals the running Vault pods listed with options in POD_NAMESPACE,
+// where describes them in the logs. If join is set, the pods are joined to the raft cluster of the
+// others before they are unsealed, the first one by name bootstraps it.
+func unsealPods(ctx context.Context, store kv.Service, locker lock.Locker, vaultConfig vault.Config, options metav1.ListOptions, where string, join *vault.RaftJoinOptions) {
+	namespace := os.Getenv("POD_NAMESPACE")
+
+	k8s, err := kubernetesClient()
+	if err != nil {
+		logrus.Fatalf("error creating k8s client: %s", err.Error())
+	}
+
+	unsealers := map[types.UID]*unsealer{}
+
+	for {
+		pods, err := k8s.CoreV1().Pods(namespace).List(options)
+		if err != nil {
+			logrus.Errorf("error listing vault pods %s: %s", where, err.Error())
+		} else {
+			// Keep the unsealers of the current pods only
+			current := map[types.UID]*unsealer{}
+
+			var running []v1.Pod
+			for _, pod := range pods.Items {
+				if pod.Status.Phase == v1.PodRunning && pod.Status.PodIP != "" {
+					running = append(running, pod)
+				}
+			}
+			sort.Slice(running, func(i, j int) bool { return running[i].Name < running[j].Name })
+
+			for i, pod := range running {
+
+				u, ok := unsealers[pod.UID]
+				if !ok {
+					v, err := vaultForPod(store, vaultConfig, &pod)
+					if err != nil {
+						logrus.Errorf("error creating vault helper for pod %s: %s", pod.Name, err.Error())
+						continue
+					}
+					u = &unsealer{
+						vault:       v,
+						events:      newPodEventRecorder(pod.Namespace, pod.Name),
+						log:         logrus.WithField("pod", pod.Name),
+						target:      pod.Name,
+						address:     podVaultAddress(&pod),
+						status:      adminServer.Target(pod.Name),
+						lock:        locker,
+						proceedInit: unsealConfig.proceedInit,
+					}
+				}
+				current[pod.UID] = u
+
+				if join != nil {
+					var others []string
+					for j := range running {
+						if j != i {
+							others = append(others, podVaultAddress(&running[j]))
+						}
+					}
+					if !u.raftJoin(join, i == 0, others) {
+						continue
+					}
+				}
+				u.unseal()
+			}
+
+			// Stop reporting the deleted pods, unless a new pod has the same name
+			targets := map[string]bool{}
+			for _, u := range current {
+				targets[u.target] = true
+			}
+			for uid, u := range unsealers {
+				if !targets[u.target] {
+					adminServer.RemoveTarget(u.target)
+				}
+				if _, ok := current[uid]; !ok {
+					vaultClients.Remove(u.address)
+				}
+			}
+			unsealers = current
+		}
+
+		// wait unsealPeriod before trying again
+		if !sleepContext(ctx, unsealConfig.unsealPeriod) {
+			return
+		}
+	}
+}
+
+// podVaultAddress is the address of the Vault API of the pod
+func podVaultAddress(pod *v1.Pod) string {
+	return fmt.Sprintf("https://%s:8200", pod.Status.PodIP)
+}
+
+// vaultForPod returns a Vault helper connecting to the pod directly, the rest of the client
+// settings (VAULT_CACERT, VAULT_TLS_SERVER_NAME, the TLS Secret, etc.) are read from the environment
+func vaultForPod(store kv.Service, vaultConfig vault.Config, pod *v1.Pod) (vault.Vault, error) {
+	cl, err := vaultClients.Client(vault.Endpoint{Address: podVaultAddress(pod)})
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to vault: %s", err.Error())
+	}
+
+	vaultConfig.Logger = logging.NewLogrus(logrus.WithField("pod", pod.Name))
+
+	return vault.New(store, cl, vaultConfig)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/banzaicloud/bank-vaults/pkg/logging"
+	"github.com/banzaicloud/bank-vaults/pkg/notify"
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/sirupsen/logrus"
+)
+
+const cfgNotifyWebhookURL = "notify-webhook-url"
+const cfgNotifySlackWebhookURL = "notify-slack-webhook-url"
+const cfgNotifyPagerDutyRoutingKey = "notify-pagerduty-routing-key"
+const cfgNotifyEvents = "notify-events"
+const cfgNotifyWebhookTemplate = "notify-webhook-template"
+const cfgNotifySlackTemplate = "notify-slack-template"
+const cfgNotifyExecCommand = "notify-exec-command"
+const cfgNotifyExecTemplate = "notify-exec-template"
+
+var (
+	notifierOnce      sync.Once
+	lifecycleNotifier notify.Notifier
+)
+
+// newNotifier returns the notifier of the lifecycle events set up with the flags, nil if there is none
+func newNotifier() notify.Notifier {
+	var notifiers []notify.Notifier
+
+	if url := appConfig.GetString(cfgNotifyWebhookURL); url != "" {
+		notifiers = append(notifiers, notify.NewTemplatedWebhook(url, notifyPayload(cfgNotifyWebhookTemplate)))
+	}
+	// The Slack webhook url and the routing key are secrets, they may be part of the errors
+	if url := appConfig.GetString(cfgNotifySlackWebhookURL); url != "" {
+		logging.RegisterSecret(url)
+		notifiers = append(notifiers, notify.NewTemplatedSlack(url, notifyPayload(cfgNotifySlackTemplate)))
+	}
+	if routingKey := appConfig.GetString(cfgNotifyPagerDutyRoutingKey); routingKey != "" {
+		logging.RegisterSecret(routingKey)
+		notifiers = append(notifiers, notify.NewPagerDuty(routingKey))
+	}
+
+	if command := appConfig.GetString(cfgNotifyExecCommand); command != "" {
+		execNotifier, err := notify.NewExec(strings.Fields(command), notifyPayload(cfgNotifyExecTemplate))
+		if err != nil {
+			logrus.Fatalf("invalid --%s: %s", cfgNotifyExecCommand, err.Error())
+		}
+		notifiers = append(notifiers, execNotifier)
+	}
+
+	if len(notifiers) == 0 {
+		return nil
+	}
+	notifier := notify.Multi(notifiers...)
+
+	if events := appConfig.GetString(cfgNotifyEvents); events != "" {
+		var types []string
+		for _, eventType := range strings.Split(events, ",") {
+			eventType = strings.TrimSpace(eventType)
+			if !validEventType(eventType) {
+				logrus.Fatalf("invalid --%s: %s, the event types are %s", cfgNotifyEvents, eventType, strings.Join(notify.EventTypes, ", "))
+			}
+			types = append(types, eventType)
+		}
+		notifier = notify.Filter(notifier, types...)
+	}
+	return notifier
+}
+
+// notifyPayload returns the payload template of the flag, nil if it isn't set
+func notifyPayload(flag string) *notify.Payload {
+	text := appConfig.GetString(flag)
+	if text == "" {
+		return nil
+	}
+	payload, err := notify.ParsePayload(text)
+	if err != nil {
+		logrus.Fatalf("invalid --%s: %s", flag, err.Error())
+	}
+	return payload
+}
+
+func validEventType(eventType string) bool {
+	for _, t := range notify.EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// notifyRootTokenStored sends the root-token-stored event of the target if the initialization has
+// stored the root token in the key store
+func notifyRootTokenStored(target string, result *vault.InitResult) {
+	if result.RootTokenKey != "" {
+		notifyEvent(notify.EventRootTokenStored, target, "the root token of vault has been stored as "+result.RootTokenKey, nil)
+	}
+}
+
+// handOffWrappedKeys sends the key-wrapped event of every key the initialization of the target has
+// wrapped instead of storing it, and prints the wrapping tokens to the standard output if print is
+// true, so they aren't lost if the notifications fail
+func handOffWrappedKeys(target string, result *vault.InitResult, print bool) {
+	for _, key := range result.WrappedKeys {
+		kind := "unseal"
+		if key.Recovery {
+			kind = "recovery"
+		}
+		message := fmt.Sprintf("%s key %d of vault has been wrapped until %s", kind, key.Index, key.Expiration.Format(time.RFC3339))
+		event := notify.NewEvent(notify.EventKeyWrapped, target, message, nil)
+		event.WrappingToken = key.Token
+		sendEvent(event)
+
+		if print {
+			fmt.Printf("Wrapped %s key %d: %s (accessor %s, expires %s)\n", kind, key.Index, key.Token, key.Accessor, key.Expiration.Format(time.RFC3339))
+		}
+	}
+}
+
+// notifyEvent sends a lifecycle event of the target (empty for VAULT_ADDR) to the notifiers, the
+// errors of sending it are only logged
+func notifyEvent(eventType, target, message string, err error) {
+	sendEvent(notify.NewEvent(eventType, target, message, err))
+}
+
+// sendEvent sends the event to the notifiers, the errors of sending it are only logged
+func sendEvent(event notify.Event) {
+	notifierOnce.Do(func() { lifecycleNotifier = newNotifier() })
+	if lifecycleNotifier == nil {
+		return
+	}
+
+	if err := lifecycleNotifier.Notify(event); err != nil {
+		logrus.Warnf("error sending %s notification: %s", event.Type, err.Error())
+	}
+}
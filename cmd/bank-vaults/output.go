@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/ghodss/yaml"
+	"github.com/sirupsen/logrus"
+)
+
+const cfgOutput = "output"
+const cfgOutputValueText = "text"
+const cfgOutputValueJSON = "json"
+const cfgOutputValueYAML = "yaml"
+
+// Exit codes of the commands, so scripts can branch on the result
+const (
+	exitCodeError         = 1
+	exitCodeDrift         = 2
+	exitCodeSealed        = 3
+	exitCodeKeyStoreError = 4
+	exitCodeInvalidConfig = 5
+	// exitCodeHistoryTampered means a record of the configuration history has been tampered with
+	exitCodeHistoryTampered = 6
+)
+
+// exitWithError logs the error and exits with the given code
+func exitWithError(code int, format string, args ...interface{}) {
+	logrus.Errorf(format, args...)
+	cleanup()
+	os.Exit(code)
+}
+
+// exitCodeForError returns the exit code of the kind of an error of the vault package, or code if its
+// kind has no exit code of its own
+func exitCodeForError(err error, code int) int {
+	switch {
+	case errors.Is(err, vault.ErrKeyStoreExists), errors.Is(err, vault.ErrKeyStoreUnavailable):
+		return exitCodeKeyStoreError
+	case errors.Is(err, vault.ErrUnsealFailed):
+		return exitCodeSealed
+	}
+	return code
+}
+
+// checkOutput exits if output is not one of the supported formats of the command
+func checkOutput(output string, formats ...string) {
+	for _, format := range formats {
+		if output == format {
+			return
+		}
+	}
+	logrus.Fatalf("invalid --%s: %s, it has to be one of %s", cfgOutput, output, strings.Join(formats, ", "))
+}
+
+// outputHelp describes the --output flag with the supported formats, the first one is the default
+func outputHelp(formats ...string) string {
+	return fmt.Sprintf("Output format of the result (%s)", strings.Join(formats, ", "))
+}
+
+// writeOutput prints value to the standard output in the JSON or YAML format
+func writeOutput(output string, value interface{}) {
+	var out []byte
+	var err error
+	if output == cfgOutputValueYAML {
+		out, err = yaml.Marshal(value)
+	} else {
+		out, err = json.MarshalIndent(value, "", "  ")
+		out = append(out, '\n')
+	}
+	if err != nil {
+		logrus.Fatalf("error writing output: %s", err.Error())
+	}
+	fmt.Print(string(out))
+}
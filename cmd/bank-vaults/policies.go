@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+const cfgPoliciesNamespace = "policies-namespace"
+const cfgPoliciesAuthPath = "policies-auth-path"
+const cfgPoliciesRoleTTL = "policies-role-ttl"
+const cfgPoliciesPeriod = "policies-period"
+
+// policyPathsAnnotation lists the Vault paths a ServiceAccount may access, separated by commas
+const policyPathsAnnotation = "vault.banzaicloud.com/paths"
+
+// policyCapabilitiesAnnotation lists the capabilities granted on the paths, separated by commas
+const policyCapabilitiesAnnotation = "vault.banzaicloud.com/capabilities"
+
+var policiesCmd = &cobra.Command{
+	Use:   "generate-policies",
+	Short: "Generates Vault policies and Kubernetes auth roles for annotated ServiceAccounts",
+	Long: `It will continuously watch Kubernetes ServiceAccounts and for each one annotated with
+'` + policyPathsAnnotation + `' it writes a Vault policy granting access to the listed paths
+and a Kubernetes auth role bound to the ServiceAccount with that policy, both named
+<namespace>-<name>. The capabilities default to read and list, and can be changed with the
+'` + policyCapabilitiesAnnotation + `' annotation.
+
+The policy and the role are removed when the ServiceAccount or its annotation is removed.
+
+The Vault token is read from the VAULT_TOKEN environment variable and it needs write access
+to sys/policy and the roles of the Kubernetes auth method.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		appConfig.BindPFlag(cfgPoliciesNamespace, cmd.PersistentFlags().Lookup(cfgPoliciesNamespace))
+		appConfig.BindPFlag(cfgPoliciesAuthPath, cmd.PersistentFlags().Lookup(cfgPoliciesAuthPath))
+		appConfig.BindPFlag(cfgPoliciesRoleTTL, cmd.PersistentFlags().Lookup(cfgPoliciesRoleTTL))
+		appConfig.BindPFlag(cfgPoliciesPeriod, cmd.PersistentFlags().Lookup(cfgPoliciesPeriod))
+
+		namespace := appConfig.GetString(cfgPoliciesNamespace)
+
+		cl, err := newVaultClient()
+		if err != nil {
+			logrus.Fatalf("error connecting to vault: %s", err.Error())
+		}
+
+		k8s, err := kubernetesClient()
+		if err != nil {
+			logrus.Fatalf("error creating k8s client: %s", err.Error())
+		}
+
+		generator := &policyGenerator{
+			cl:       cl,
+			authPath: strings.Trim(appConfig.GetString(cfgPoliciesAuthPath), "/"),
+			roleTTL:  appConfig.GetDuration(cfgPoliciesRoleTTL),
+		}
+
+		_, controller := cache.NewInformer(
+			serviceAccountListWatch(k8s, namespace),
+			&v1.ServiceAccount{},
+			appConfig.GetDuration(cfgPoliciesPeriod),
+			cache.ResourceEventHandlerFuncs{
+				AddFunc: func(obj interface{}) {
+					generator.sync(obj.(*v1.ServiceAccount))
+				},
+				UpdateFunc: func(oldObj, newObj interface{}) {
+					generator.sync(newObj.(*v1.ServiceAccount))
+				},
+				DeleteFunc: func(obj interface{}) {
+					if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+						obj = tombstone.Obj
+					}
+					if sa, ok := obj.(*v1.ServiceAccount); ok {
+						generator.remove(sa)
+					}
+				},
+			},
+		)
+
+		logrus.Infof("generating policies for service accounts annotated with '%s'", policyPathsAnnotation)
+
+		controller.Run(make(chan struct{}))
+	},
+}
+
+func serviceAccountListWatch(k8s *kubernetes.Clientset, namespace string) *cache.ListWatch {
+	return &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return k8s.CoreV1().ServiceAccounts(namespace).List(options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return k8s.CoreV1().ServiceAccounts(namespace).Watch(options)
+		},
+	}
+}
+
+type policyGenerator struct {
+	cl       *api.Client
+	authPath string
+	roleTTL  time.Duration
+}
+
+func (g *policyGenerator) sync(sa *v1.ServiceAccount) {
+	paths := splitAnnotation(sa.Annotations[policyPathsAnnotation])
+	if len(paths) == 0 {
+		// The annotation may have been removed, this is a no-op otherwise
+		g.remove(sa)
+		return
+	}
+
+	capabilities := splitAnnotation(sa.Annotations[policyCapabilitiesAnnotation])
+	if len(capabilities) == 0 {
+		capabilities = []string{"read", "list"}
+	}
+
+	name := policyNameForServiceAccount(sa)
+
+	err := g.cl.Sys().PutPolicy(name, policyRules(paths, capabilities))
+	if err != nil {
+		logrus.Errorf("error putting %s policy into vault: %s", name, err.Error())
+		return
+	}
+
+	role := map[string]interface{}{
+		"bound_service_account_names":      sa.Name,
+		"bound_service_account_namespaces": sa.Namespace,
+		"policies":                         name,
+		"ttl":                              g.roleTTL.String(),
+	}
+	_, err = g.cl.Logical().Write(fmt.Sprintf("auth/%s/role/%s", g.authPath, name), role)
+	if err != nil {
+		logrus.Errorf("error putting %s kubernetes role into vault: %s", name, err.Error())
+		return
+	}
+
+	logrus.Infof("generated policy and role %s for service account %s/%s", name, sa.Namespace, sa.Name)
+}
+
+func (g *policyGenerator) remove(sa *v1.ServiceAccount) {
+	name := policyNameForServiceAccount(sa)
+
+	policy, err := g.cl.Sys().GetPolicy(name)
+	if err != nil {
+		logrus.Errorf("error reading %s policy from vault: %s", name, err.Error())
+		return
+	}
+	if policy == "" {
+		return
+	}
+
+	_, err = g.cl.Logical().Delete(fmt.Sprintf("auth/%s/role/%s", g.authPath, name))
+	if err != nil {
+		logrus.Errorf("error deleting %s kubernetes role from vault: %s", name, err.Error())
+		return
+	}
+
+	err = g.cl.Sys().DeletePolicy(name)
+	if err != nil {
+		logrus.Errorf("error deleting %s policy from vault: %s", name, err.Error())
+		return
+	}
+
+	logrus.Infof("removed policy and role %s of service account %s/%s", name, sa.Namespace, sa.Name)
+}
+
+func policyNameForServiceAccount(sa *v1.ServiceAccount) string {
+	return sa.Namespace + "-" + sa.Name
+}
+
+func policyRules(paths, capabilities []string) string {
+	quoted := make([]string, len(capabilities))
+	for i, capability := range capabilities {
+		quoted[i] = fmt.Sprintf("%q", capability)
+	}
+
+	rules := ""
+	for _, path := range paths {
+		rules += fmt.Sprintf("path %q {\n  capabilities = [%s]\n}\n", path, strings.Join(quoted, ", "))
+	}
+	return rules
+}
+
+func splitAnnotation(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func init() {
+	policiesCmd.PersistentFlags().String(cfgPoliciesNamespace, "", "The namespace to watch for ServiceAccounts (all namespaces if empty)")
+	policiesCmd.PersistentFlags().String(cfgPoliciesAuthPath, "kubernetes", "The path of the Kubernetes auth method to create the roles in")
+	policiesCmd.PersistentFlags().Duration(cfgPoliciesRoleTTL, time.Hour, "The TTL of the tokens issued by the generated roles")
+	policiesCmd.PersistentFlags().Duration(cfgPoliciesPeriod, time.Minute*5, "How often to re-sync all the ServiceAccounts")
+
+	rootCmd.AddCommand(policiesCmd)
+}
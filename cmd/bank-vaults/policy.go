@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/spf13/cast"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const cfgPolicyWrite = "write"
+
+// policyProblem is a problem reported by policy lint
+type policyProblem struct {
+	// Policy is the HCL file or the name of the policy in the Vault configuration
+	Policy  string `json:"policy"`
+	Problem string `json:"problem"`
+}
+
+// namedPolicy is the HCL rules of a policy file or of a policy of the Vault configuration
+type namedPolicy struct {
+	name  string
+	rules string
+}
+
+var policyCmd = &cobra.Command{
+	Use:   "policy",
+	Short: "Lints and formats Vault policies",
+	Long: `The subcommands work on the HCL policy files given as arguments, or without arguments on the
+policies section of the Vault configuration file used by the configure command.`,
+}
+
+var policyLintCmd = &cobra.Command{
+	Use:   "lint [file...]",
+	Short: "Checks Vault policies for errors",
+	Long: `It reports invalid HCL, unknown capabilities, paths defined more than once and paths covered by
+the glob path of another rule, whose rules don't apply to them. It exits with 5 if problems are found.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		appConfig.BindPFlag(cfgOutput, cmd.PersistentFlags().Lookup(cfgOutput))
+
+		output := appConfig.GetString(cfgOutput)
+		checkOutput(output, cfgOutputValueText, cfgOutputValueJSON, cfgOutputValueYAML)
+
+		policies := readPolicies(cmd, args)
+
+		problems := []policyProblem{}
+		for _, policy := range policies {
+			for _, problem := range vault.LintPolicy(policy.rules) {
+				problems = append(problems, policyProblem{Policy: policy.name, Problem: problem})
+			}
+		}
+
+		if output != cfgOutputValueText {
+			writeOutput(output, problems)
+		} else {
+			for _, problem := range problems {
+				fmt.Printf("%s: %s\n", problem.Policy, problem.Problem)
+			}
+		}
+
+		if len(problems) > 0 {
+			os.Exit(exitCodeInvalidConfig)
+		}
+	},
+}
+
+var policyFmtCmd = &cobra.Command{
+	Use:   "fmt [file...]",
+	Short: "Formats Vault policies canonically",
+	Long: `It reformats the HCL of the policies and sorts and deduplicates their capabilities. The policy
+files given as arguments are printed, or rewritten with --write. Without arguments the policies
+section of the Vault configuration file is printed in YAML, ready to be pasted back.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		appConfig.BindPFlag(cfgPolicyWrite, cmd.PersistentFlags().Lookup(cfgPolicyWrite))
+
+		write := appConfig.GetBool(cfgPolicyWrite)
+		if write && len(args) == 0 {
+			exitWithError(exitCodeError, "--%s requires policy files", cfgPolicyWrite)
+		}
+
+		policies := readPolicies(cmd, args)
+
+		formatted := []interface{}{}
+		for _, policy := range policies {
+			rules, err := vault.FormatPolicy(policy.rules)
+			if err != nil {
+				exitWithError(exitCodeInvalidConfig, "%s: %s", policy.name, err.Error())
+			}
+
+			switch {
+			case len(args) == 0:
+				formatted = append(formatted, map[string]interface{}{"name": policy.name, "rules": rules})
+			case write:
+				if err := ioutil.WriteFile(policy.name, []byte(rules), 0644); err != nil {
+					exitWithError(exitCodeError, "error writing %s: %s", policy.name, err.Error())
+				}
+			default:
+				fmt.Print(rules)
+			}
+		}
+
+		if len(args) == 0 {
+			writeOutput(cfgOutputValueYAML, map[string]interface{}{"policies": formatted})
+		}
+	},
+}
+
+// readPolicies reads the policy files given as arguments, or the policies of the Vault configuration file
+func readPolicies(cmd *cobra.Command, files []string) []namedPolicy {
+	policies := []namedPolicy{}
+
+	if len(files) > 0 {
+		for _, file := range files {
+			rules, err := ioutil.ReadFile(file)
+			if err != nil {
+				exitWithError(exitCodeError, "error reading policy file: %s", err.Error())
+			}
+			policies = append(policies, namedPolicy{name: file, rules: string(rules)})
+		}
+		return policies
+	}
+
+	appConfig.BindPFlag(cfgVaultConfigFile, cmd.PersistentFlags().Lookup(cfgVaultConfigFile))
+	appConfig.BindPFlag(cfgVaultConfigValues, cmd.PersistentFlags().Lookup(cfgVaultConfigValues))
+
+	cfg := viper.New()
+	if err := readVaultConfig(appConfig.GetString(cfgVaultConfigFile), cfg); err != nil {
+		exitWithError(exitCodeInvalidConfig, "%s", err.Error())
+	}
+
+	for i, policy := range cast.ToSlice(cfg.Get("policies")) {
+		policyMap := cast.ToStringMap(policy)
+		name := cast.ToString(policyMap["name"])
+		if name == "" {
+			name = fmt.Sprintf("policies[%d]", i)
+		}
+		policies = append(policies, namedPolicy{name: name, rules: cast.ToString(policyMap["rules"])})
+	}
+	return policies
+}
+
+func init() {
+	for _, cmd := range []*cobra.Command{policyLintCmd, policyFmtCmd} {
+		cmd.PersistentFlags().String(cfgVaultConfigFile, vault.DefaultConfigFile, "The filename of the YAML/JSON/HCL Vault configuration (by its extension), used without policy files")
+		cmd.PersistentFlags().String(cfgVaultConfigValues, "", "A YAML/JSON file with the values of the Vault configuration template (.Values)")
+		policyCmd.AddCommand(cmd)
+	}
+	policyLintCmd.PersistentFlags().String(cfgOutput, cfgOutputValueText, outputHelp(cfgOutputValueText, cfgOutputValueJSON, cfgOutputValueYAML))
+	policyFmtCmd.PersistentFlags().BoolP(cfgPolicyWrite, "w", false, "Write the formatted policies back to the policy files")
+
+	rootCmd.AddCommand(policyCmd)
+}
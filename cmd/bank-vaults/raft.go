@@ -0,0 +1,56 @@
+package main
+
+import (
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/sirupsen/logrus"
+)
+
+// raftJoinOptions returns the join settings of the raft section of the file of --vault-config-file,
+// nil if the file isn't given or the nodes aren't joined
+func raftJoinOptions(vaultConfigFile string) *vault.RaftJoinOptions {
+	if vaultConfigFile == "" {
+		return nil
+	}
+	config, err := parseVaultConfig(vaultConfigFile)
+	if err != nil {
+		logrus.Fatal(err.Error())
+	}
+	raftConfig, err := config.Raft()
+	if err != nil {
+		logrus.Fatal(err.Error())
+	}
+	if raftConfig == nil {
+		return nil
+	}
+	return raftConfig.Join
+}
+
+// raftJoin joins the node of the unsealer to the raft cluster of the other nodes of an HA cluster
+// before it is initialized and unsealed, it returns false if the node has to wait for the next
+// round. The first node bootstraps the cluster if it can't join the others (e.g. none of them is
+// initialized yet), the others wait until they can join one of them.
+func (u *unsealer) raftJoin(options *vault.RaftJoinOptions, first bool, others []string) bool {
+	joiner, ok := u.vault.(vault.RaftJoiner)
+	if !ok {
+		return true
+	}
+	joinOptions := *options
+	if first {
+		// Vault would keep trying to join in the background instead of reporting the failure
+		joinOptions.Retry = false
+	}
+
+	joined, err := joiner.RaftJoin(shutdownContext, others, joinOptions)
+	switch {
+	case err != nil && first:
+		u.log.Infof("the first node couldn't join the raft cluster of the other nodes, it bootstraps it: %s", err.Error())
+	case err != nil:
+		u.log.Errorf("%s, trying again in the next round", err.Error())
+		u.events.warning(eventReasonRaftJoinFailed, err.Error())
+		return false
+	case joined:
+		u.log.Infof("joined the raft cluster")
+		u.events.normal(eventReasonRaftJoined, "joined the raft cluster")
+	}
+	return true
+}
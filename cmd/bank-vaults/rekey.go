@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/banzaicloud/bank-vaults/pkg/notify"
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+const cfgPGPKeys = "pgp-keys"
+
+var rekeyCmd = &cobra.Command{
+	Use:   "rekey",
+	Short: "Replaces the unseal keys of Vault with new ones",
+	Long: `It generates new unseal keys (or recovery keys with an auto-unseal seal) with --secret-shares
+and --secret-threshold using the keys in the key store. The new keys are stored in the key
+store with the next version of the keys, and Vault switches to them only after they have been
+verified with the values read back from the key store, the old keys are deleted afterwards.
+
+With --pgp-keys the new keys are encrypted with the given PGP public keys (one for each share)
+and printed instead of being stored, and the old keys are removed from the key store, so
+Vault can't be unsealed by bank-vaults anymore.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		appConfig.BindPFlag(cfgPGPKeys, cmd.PersistentFlags().Lookup(cfgPGPKeys))
+		appConfig.BindPFlag(cfgOutput, cmd.PersistentFlags().Lookup(cfgOutput))
+
+		output := appConfig.GetString(cfgOutput)
+		checkOutput(output, cfgOutputValueJSON, cfgOutputValueYAML)
+
+		pgpKeys := []string{}
+		for _, pgpKeyFile := range appConfig.GetStringSlice(cfgPGPKeys) {
+			pgpKey, err := readPGPKey(pgpKeyFile)
+			if err != nil {
+				logrus.Fatal(err.Error())
+			}
+			pgpKeys = append(pgpKeys, pgpKey)
+		}
+
+		store, err := kvStoreForConfig(appConfig)
+
+		if err != nil {
+			exitWithError(exitCodeKeyStoreError, "error creating kv store: %s", err.Error())
+		}
+
+		cl, err := newVaultClient()
+
+		if err != nil {
+			logrus.Fatalf("error connecting to vault: %s", err.Error())
+		}
+
+		vaultConfig, err := vaultConfigForConfig(appConfig)
+
+		if err != nil {
+			logrus.Fatalf("error building vault config: %s", err.Error())
+		}
+
+		v, err := vault.New(store, cl, vaultConfig)
+
+		if err != nil {
+			logrus.Fatalf("error creating vault helper: %s", err.Error())
+		}
+
+		result, err := v.Rekey(vault.RekeyOptions{PGPKeys: pgpKeys})
+
+		if err != nil {
+			logrus.Fatalf("error rekeying vault: %s", err.Error())
+		}
+
+		notifyEvent(notify.EventRekeyed, "", "vault has been rekeyed", nil)
+
+		writeOutput(output, result)
+	},
+}
+
+// readPGPKey reads a binary or base64 encoded PGP public key file and returns it base64 encoded
+func readPGPKey(file string) (string, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("error reading PGP key: %s", err.Error())
+	}
+	key := strings.TrimSpace(string(data))
+	if _, err := base64.StdEncoding.DecodeString(key); err == nil {
+		return key, nil
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+func init() {
+	rekeyCmd.PersistentFlags().StringSlice(cfgPGPKeys, nil, "Comma separated list of files holding binary or base64 encoded PGP public keys to encrypt the new keys with, one for each share")
+
+	rekeyCmd.PersistentFlags().String(cfgOutput, cfgOutputValueJSON, outputHelp(cfgOutputValueJSON, cfgOutputValueYAML))
+
+	rootCmd.AddCommand(rekeyCmd)
+}
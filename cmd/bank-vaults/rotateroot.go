@@ -0,0 +1,51 @@
+package main
+
+import (
+	"github.com/banzaicloud/bank-vaults/pkg/notify"
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var rotateRootTokenCmd = &cobra.Command{
+	Use:   "rotate-root-token",
+	Short: "Replaces the stored root token of Vault with a new one",
+	Long: `It generates a new root token with the keys in the key store, stores it in place of the old
+one and revokes the old root token. Tokens created with the old root token stay valid. It can
+be run periodically (e.g. as a Kubernetes CronJob) for credential hygiene.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		store, err := kvStoreForConfig(appConfig)
+
+		if err != nil {
+			exitWithError(exitCodeKeyStoreError, "error creating kv store: %s", err.Error())
+		}
+
+		cl, err := newVaultClient()
+
+		if err != nil {
+			logrus.Fatalf("error connecting to vault: %s", err.Error())
+		}
+
+		vaultConfig, err := vaultConfigForConfig(appConfig)
+
+		if err != nil {
+			logrus.Fatalf("error building vault config: %s", err.Error())
+		}
+
+		v, err := vault.New(store, cl, vaultConfig)
+
+		if err != nil {
+			logrus.Fatalf("error creating vault helper: %s", err.Error())
+		}
+
+		if err := v.RotateRootToken(); err != nil {
+			logrus.Fatalf("error rotating root token: %s", err.Error())
+		}
+
+		notifyEvent(notify.EventRootTokenRotated, "", "the root token of vault has been rotated", nil)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(rotateRootTokenCmd)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"time"
+
+	"github.com/banzaicloud/bank-vaults/pkg/backoff"
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/hashicorp/vault/api"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+const cfgSealAll = "all"
+const cfgSealTimeout = "timeout"
+
+// sealOutput is the machine-readable result of the seal command
+type sealOutput struct {
+	Sealed []string `json:"sealed"`
+}
+
+var sealCmd = &cobra.Command{
+	Use:   "seal",
+	Short: "Seals Vault nodes with the stored root token",
+	Long: `It seals the Vault nodes given with --addresses (VAULT_ADDR by default) using the root token
+from the key store, for incident response. Vault refuses to seal standby nodes, so with --all
+the active node is sealed repeatedly: after sealing it, one of the standbys takes over and is
+sealed in the next round, until every node is sealed or --timeout elapses.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		appConfig.BindPFlag(cfgAddresses, cmd.PersistentFlags().Lookup(cfgAddresses))
+		appConfig.BindPFlag(cfgSealAll, cmd.PersistentFlags().Lookup(cfgSealAll))
+		appConfig.BindPFlag(cfgSealTimeout, cmd.PersistentFlags().Lookup(cfgSealTimeout))
+		appConfig.BindPFlag(cfgOutput, cmd.PersistentFlags().Lookup(cfgOutput))
+
+		output := appConfig.GetString(cfgOutput)
+		checkOutput(output, cfgOutputValueText, cfgOutputValueJSON, cfgOutputValueYAML)
+
+		store, err := kvStoreForConfig(appConfig)
+
+		if err != nil {
+			exitWithError(exitCodeKeyStoreError, "error creating kv store: %s", err.Error())
+		}
+
+		vaultConfig, err := vaultConfigForConfig(appConfig)
+
+		if err != nil {
+			logrus.Fatalf("error building vault config: %s", err.Error())
+		}
+
+		config, err := vaultClientConfig()
+
+		if err != nil {
+			logrus.Fatalf("error building vault client config: %s", err.Error())
+		}
+
+		addresses := appConfig.GetStringSlice(cfgAddresses)
+		if len(addresses) == 0 {
+			addresses = []string{config.Address}
+		}
+
+		clients := map[string]*api.Client{}
+		for _, address := range addresses {
+			cl, err := vaultClients.Client(vault.Endpoint{Address: address})
+			if err != nil {
+				logrus.Fatalf("error connecting to vault: %s", err.Error())
+			}
+			clients[address] = cl
+		}
+
+		result := sealOutput{Sealed: []string{}}
+		done := func() {
+			if output != cfgOutputValueText {
+				writeOutput(output, result)
+			}
+		}
+
+		seal := func(address string) {
+			v, err := vault.New(store, clients[address], vaultConfig)
+			if err != nil {
+				logrus.Fatalf("error creating vault helper: %s", err.Error())
+			}
+			if err := v.Seal(); err != nil {
+				logrus.Fatalf("error sealing %s: %s", address, err.Error())
+			}
+			logrus.Infof("%s sealed", address)
+			result.Sealed = append(result.Sealed, address)
+		}
+
+		if !appConfig.GetBool(cfgSealAll) {
+			for _, address := range addresses {
+				seal(address)
+			}
+			done()
+			return
+		}
+
+		// The standbys become active one by one after the active node is sealed
+		unsealed := 0
+		err = backoff.Wait(shutdownContext, backoff.Exponential{InitialInterval: time.Second, MaxInterval: 10 * time.Second, MaxWait: appConfig.GetDuration(cfgSealTimeout)}, func() (bool, error) {
+			unsealed = 0
+			for _, address := range addresses {
+				health, err := clients[address].Sys().Health()
+				if err != nil {
+					logrus.Fatalf("error checking status of %s: %s", address, err.Error())
+				}
+				if health.Sealed {
+					continue
+				}
+				if health.Standby {
+					unsealed++
+					continue
+				}
+				seal(address)
+			}
+			return unsealed == 0, nil
+		}, func(_ error, next time.Duration) {
+			logrus.Infof("waiting for %d standby nodes to take over, checking again in %s", unsealed, next)
+		})
+
+		switch err {
+		case nil:
+			logrus.Info("every vault node is sealed")
+			done()
+		case backoff.ErrTimeout:
+			logrus.Fatalf("%d standby nodes haven't taken over to be sealed in time", unsealed)
+		default:
+			logrus.Fatalf("stopped waiting for %d standby nodes to take over: %s", unsealed, err.Error())
+		}
+	},
+}
+
+func init() {
+	sealCmd.PersistentFlags().StringSlice(cfgAddresses, nil, "Comma separated list of the Vault node addresses to seal, VAULT_ADDR by default")
+	sealCmd.PersistentFlags().Bool(cfgSealAll, false, "Seal every node, waiting for the standbys to become active")
+	sealCmd.PersistentFlags().Duration(cfgSealTimeout, time.Minute, "How long to wait for the standbys to become active with --"+cfgSealAll+", 0 means forever")
+
+	sealCmd.PersistentFlags().String(cfgOutput, cfgOutputValueText, outputHelp(cfgOutputValueText, cfgOutputValueJSON, cfgOutputValueYAML)+", only logs are written in the "+cfgOutputValueText+" format")
+
+	rootCmd.AddCommand(sealCmd)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+const cfgConfirm = "confirm"
+const cfgReason = "reason"
+
+var showKeysCmd = &cobra.Command{
+	Use:   "show-keys",
+	Short: "Prints the stored unseal keys and root token for disaster recovery",
+	Long: `It decrypts and prints the root token, the unseal keys and the recovery keys stored in the key
+store, to unseal or fix Vault by hand when the automation itself is broken. Everyone who can
+run it with the key store's credentials can read the keys anyway, so it is guarded only
+against accidental use: --confirm and a --reason are required, and an audit log entry with
+the reason, the user and the printed keys' IDs is written to the log before printing.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		appConfig.BindPFlag(cfgConfirm, cmd.PersistentFlags().Lookup(cfgConfirm))
+		appConfig.BindPFlag(cfgReason, cmd.PersistentFlags().Lookup(cfgReason))
+		appConfig.BindPFlag(cfgOutput, cmd.PersistentFlags().Lookup(cfgOutput))
+
+		output := appConfig.GetString(cfgOutput)
+		checkOutput(output, cfgOutputValueText, cfgOutputValueJSON, cfgOutputValueYAML)
+
+		reason := appConfig.GetString(cfgReason)
+		if !appConfig.GetBool(cfgConfirm) || reason == "" {
+			logrus.Fatalf("show-keys prints secrets which can unseal Vault, --%s and --%s are required", cfgConfirm, cfgReason)
+		}
+
+		store, err := kvStoreForConfig(appConfig)
+
+		if err != nil {
+			exitWithError(exitCodeKeyStoreError, "error creating kv store: %s", err.Error())
+		}
+
+		keys, err := vault.StoredKeys(store, keyNamesForConfig(appConfig))
+
+		if err != nil {
+			exitWithError(exitCodeKeyStoreError, "error reading keys: %s", err.Error())
+		}
+		defer vault.WipeKeys(keys)
+
+		ids := []string{}
+		for _, key := range keys {
+			ids = append(ids, key.ID)
+		}
+
+		username := "unknown"
+		if u, err := user.Current(); err == nil {
+			username = u.Username
+		}
+		hostname, _ := os.Hostname()
+		logrus.WithFields(logrus.Fields{
+			"audit":    "show-keys",
+			"user":     username,
+			"host":     hostname,
+			"reason":   reason,
+			"keyStore": keyStoreLocation(appConfig),
+			"keys":     ids,
+		}).Warn("stored vault keys are printed for disaster recovery")
+
+		if output != cfgOutputValueText {
+			values := map[string]string{}
+			for _, key := range keys {
+				values[key.ID] = string(key.Value)
+			}
+			writeOutput(output, values)
+			return
+		}
+
+		for _, key := range keys {
+			fmt.Printf("%s: %s\n", key.ID, key.Value)
+		}
+	},
+}
+
+func init() {
+	showKeysCmd.PersistentFlags().Bool(cfgConfirm, false, "Confirm printing the keys which can unseal Vault")
+	showKeysCmd.PersistentFlags().String(cfgReason, "", "Why the keys are needed, written to the audit log entry")
+	showKeysCmd.PersistentFlags().String(cfgOutput, cfgOutputValueText, outputHelp(cfgOutputValueText, cfgOutputValueJSON, cfgOutputValueYAML))
+
+	rootCmd.AddCommand(showKeysCmd)
+}
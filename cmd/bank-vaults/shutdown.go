@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/banzaicloud/bank-vaults/pkg/logging"
+	"github.com/banzaicloud/bank-vaults/pkg/securemem"
+	"github.com/banzaicloud/bank-vaults/pkg/tracing"
+	"github.com/sirupsen/logrus"
+)
+
+const cfgShutdownTimeout = "shutdown-timeout"
+
+// shutdownContext is cancelled when the process receives SIGTERM or SIGINT, the watch loops stop
+// and the requests to Vault in flight are cancelled then
+var shutdownContext, cancelShutdown = context.WithCancel(context.Background())
+
+var errShuttingDown = errors.New("shutting down")
+
+var cleanupOnce sync.Once
+
+// cleanup flushes the traces and wipes the secrets left in memory, it runs once before the process exits
+func cleanup() {
+	cleanupOnce.Do(func() {
+		tracing.Flush()
+		logging.ForgetSecrets()
+		securemem.DestroyRemaining()
+	})
+}
+
+// handleSignals starts shutting down on SIGTERM or SIGINT, the running command has --shutdown-timeout
+// to return, after that (or on a second signal) the process exits right away, after cleanup
+func handleSignals() {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+	go func() {
+		sig := <-signals
+		logrus.Infof("received %s, shutting down...", sig)
+		cancelShutdown()
+
+		timeout := appConfig.GetDuration(cfgShutdownTimeout)
+		select {
+		case <-time.After(timeout):
+			logrus.Warnf("the command hasn't stopped within %s, exiting", timeout)
+		case sig = <-signals:
+			logrus.Warnf("received %s again, exiting", sig)
+		}
+		cleanup()
+		os.Exit(exitCodeError)
+	}()
+}
+
+// shuttingDown tells whether the process has received SIGTERM or SIGINT
+func shuttingDown() bool {
+	select {
+	case <-shutdownContext.Done():
+		return true
+	default:
+		return false
+	}
+}
+
+// sleep waits for d, it returns false if the process has started shutting down in the meantime
+func sleep(d time.Duration) bool {
+	return sleepContext(shutdownContext, d)
+}
+
+// sleepContext is sleep returning false when ctx is done, e.g. when the leader lost its lease
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// releasingBody releases the resources of a request when its response body is closed
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
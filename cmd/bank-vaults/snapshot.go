@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+const cfgForce = "force"
+
+var snapshotCmd = &cobra.Command{
+	Use:   "snapshot",
+	Short: "Saves and restores raft snapshots of Vault",
+	Long: `The snapshot commands save and restore snapshots of Vault's integrated (raft) storage using the
+root token from the key store. The location of a snapshot is a local file, an s3://bucket/key
+(in the region of --aws-s3-region) or a gs://bucket/object URL. The checksums stored in the
+snapshot are verified after saving and before restoring it.`,
+}
+
+var snapshotSaveCmd = &cobra.Command{
+	Use:   "save <location>",
+	Short: "Saves a raft snapshot of Vault",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		location := args[0]
+		v := snapshotVault()
+
+		if err := saveSnapshot(v, location); err != nil {
+			logrus.Fatal(err.Error())
+		}
+
+		logrus.Infof("snapshot saved to %s", location)
+	},
+}
+
+var snapshotRestoreCmd = &cobra.Command{
+	Use:   "restore <location>",
+	Short: "Restores a raft snapshot of Vault",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		appConfig.BindPFlag(cfgForce, cmd.PersistentFlags().Lookup(cfgForce))
+
+		location := args[0]
+		v := snapshotVault()
+
+		file, err := ioutil.TempFile("", "vault-snapshot")
+		if err != nil {
+			logrus.Fatalf("error creating temporary file: %s", err.Error())
+		}
+		defer os.Remove(file.Name())
+		defer file.Close()
+
+		if err := downloadSnapshot(location, file); err != nil {
+			logrus.Fatalf("error reading snapshot: %s", err.Error())
+		}
+
+		if err := verifySnapshotFile(file); err != nil {
+			logrus.Fatal(err.Error())
+		}
+
+		if err := v.RestoreSnapshot(file, appConfig.GetBool(cfgForce)); err != nil {
+			logrus.Fatal(err.Error())
+		}
+
+		logrus.Infof("snapshot restored from %s", location)
+	},
+}
+
+func snapshotVault() vault.Vault {
+	store, err := kvStoreForConfig(appConfig)
+
+	if err != nil {
+		exitWithError(exitCodeKeyStoreError, "error creating kv store: %s", err.Error())
+	}
+
+	cl, err := newVaultClient()
+
+	if err != nil {
+		logrus.Fatalf("error connecting to vault: %s", err.Error())
+	}
+
+	vaultConfig, err := vaultConfigForConfig(appConfig)
+
+	if err != nil {
+		logrus.Fatalf("error building vault config: %s", err.Error())
+	}
+
+	v, err := vault.New(store, cl, vaultConfig)
+
+	if err != nil {
+		logrus.Fatalf("error creating vault helper: %s", err.Error())
+	}
+
+	return v
+}
+
+// saveSnapshot takes a snapshot of Vault, verifies it and stores it at the location
+func saveSnapshot(v vault.Vault, location string) error {
+	file, err := ioutil.TempFile("", "vault-snapshot")
+	if err != nil {
+		return fmt.Errorf("error creating temporary file: %s", err.Error())
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	if err := v.SaveSnapshot(file); err != nil {
+		return err
+	}
+
+	if err := verifySnapshotFile(file); err != nil {
+		return err
+	}
+
+	if err := uploadSnapshot(location, file); err != nil {
+		return fmt.Errorf("error storing snapshot: %s", err.Error())
+	}
+	return nil
+}
+
+// saveScheduledSnapshot takes a snapshot of the raft snapshots section of the configuration
+func saveScheduledSnapshot(v vault.Vault, options *vault.RaftSnapshotOptions, now time.Time) {
+	location, err := options.SnapshotLocation(now)
+	if err != nil {
+		logrus.Errorf("error taking raft snapshot: %s", err.Error())
+		return
+	}
+	if err := saveSnapshot(v, location); err != nil {
+		logrus.Errorf("error taking raft snapshot: %s", err.Error())
+		return
+	}
+	logrus.Infof("raft snapshot saved to %s", location)
+}
+
+// verifySnapshotFile verifies the snapshot in file and rewinds it
+func verifySnapshotFile(file *os.File) error {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := vault.VerifySnapshot(file); err != nil {
+		return err
+	}
+	_, err := file.Seek(0, io.SeekStart)
+	return err
+}
+
+// parseSnapshotLocation splits an s3:// or gs:// location into its scheme, bucket and object
+// name, the scheme is empty for local files
+func parseSnapshotLocation(location string) (string, string, string, error) {
+	if !strings.HasPrefix(location, "s3://") && !strings.HasPrefix(location, "gs://") {
+		return "", "", location, nil
+	}
+	u, err := url.Parse(location)
+	if err != nil {
+		return "", "", "", err
+	}
+	object := strings.TrimPrefix(u.Path, "/")
+	if u.Host == "" || object == "" {
+		return "", "", "", fmt.Errorf("invalid snapshot location: %s", location)
+	}
+	return u.Scheme, u.Host, object, nil
+}
+
+func uploadSnapshot(location string, file *os.File) error {
+	scheme, bucket, object, err := parseSnapshotLocation(location)
+	if err != nil {
+		return err
+	}
+
+	switch scheme {
+	case "s3":
+		sess := session.Must(session.NewSession(aws.NewConfig().WithRegion(appConfig.GetString(cfgAWSS3Region))))
+		_, err := awss3.New(sess).PutObject(&awss3.PutObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(object),
+			Body:   file,
+		})
+		return err
+	case "gs":
+		cl, err := storage.NewClient(context.Background())
+		if err != nil {
+			return err
+		}
+		w := cl.Bucket(bucket).Object(object).NewWriter(context.Background())
+		if _, err := io.Copy(w, file); err != nil {
+			w.Close()
+			return err
+		}
+		return w.Close()
+	default:
+		out, err := os.OpenFile(object, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, file); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	}
+}
+
+func downloadSnapshot(location string, file *os.File) error {
+	scheme, bucket, object, err := parseSnapshotLocation(location)
+	if err != nil {
+		return err
+	}
+
+	var r io.ReadCloser
+	switch scheme {
+	case "s3":
+		sess := session.Must(session.NewSession(aws.NewConfig().WithRegion(appConfig.GetString(cfgAWSS3Region))))
+		out, err := awss3.New(sess).GetObject(&awss3.GetObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(object),
+		})
+		if err != nil {
+			return err
+		}
+		r = out.Body
+	case "gs":
+		cl, err := storage.NewClient(context.Background())
+		if err != nil {
+			return err
+		}
+		r, err = cl.Bucket(bucket).Object(object).NewReader(context.Background())
+		if err != nil {
+			return err
+		}
+	default:
+		r, err = os.Open(object)
+		if err != nil {
+			return err
+		}
+	}
+	defer r.Close()
+
+	_, err = io.Copy(file, r)
+	return err
+}
+
+func init() {
+	snapshotRestoreCmd.PersistentFlags().Bool(cfgForce, false, "Restore a snapshot of another Vault cluster, which has different keys")
+
+	snapshotCmd.AddCommand(snapshotSaveCmd)
+	snapshotCmd.AddCommand(snapshotRestoreCmd)
+	rootCmd.AddCommand(snapshotCmd)
+}
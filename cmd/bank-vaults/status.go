@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+const cfgAddresses = "addresses"
+const cfgKeyStore = "key-store"
+
+// nodeStatus is the state of a Vault node, Error is set if it couldn't be queried
+type nodeStatus struct {
+	Address         string `json:"address"`
+	Error           string `json:"error,omitempty"`
+	Initialized     bool   `json:"initialized"`
+	Sealed          bool   `json:"sealed"`
+	SealType        string `json:"sealType,omitempty"`
+	UnsealProgress  int    `json:"unsealProgress"`
+	UnsealThreshold int    `json:"unsealThreshold"`
+	Standby         bool   `json:"standby"`
+	HAEnabled       bool   `json:"haEnabled"`
+	Leader          bool   `json:"leader"`
+	LeaderAddress   string `json:"leaderAddress,omitempty"`
+	Version         string `json:"version,omitempty"`
+	ClusterName     string `json:"clusterName,omitempty"`
+}
+
+// keyStoreStatus is the state of the key store, Keys are the IDs of the stored keys
+type keyStoreStatus struct {
+	Mode     string   `json:"mode"`
+	Location string   `json:"location"`
+	Healthy  bool     `json:"healthy"`
+	Error    string   `json:"error,omitempty"`
+	Keys     []string `json:"keys,omitempty"`
+}
+
+type statusOutput struct {
+	Nodes    []nodeStatus    `json:"nodes"`
+	KeyStore *keyStoreStatus `json:"keyStore,omitempty"`
+}
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Reports the state of Vault nodes and the key store",
+	Long: `It reports the initialization, seal and HA state of the Vault nodes given with --addresses
+(VAULT_ADDR by default), and whether the key store is accessible and which keys it holds.
+With --output json or yaml the report is printed in a machine-readable format. The exit
+code is 1 if a node is unreachable, 4 if the key store is not healthy and 3 if a node is
+sealed or not initialized.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		appConfig.BindPFlag(cfgAddresses, cmd.PersistentFlags().Lookup(cfgAddresses))
+		appConfig.BindPFlag(cfgKeyStore, cmd.PersistentFlags().Lookup(cfgKeyStore))
+		appConfig.BindPFlag(cfgOutput, cmd.PersistentFlags().Lookup(cfgOutput))
+
+		output := appConfig.GetString(cfgOutput)
+		checkOutput(output, cfgOutputValueText, cfgOutputValueJSON, cfgOutputValueYAML)
+
+		config, err := vaultClientConfig()
+
+		if err != nil {
+			logrus.Fatalf("error building vault client config: %s", err.Error())
+		}
+
+		addresses := appConfig.GetStringSlice(cfgAddresses)
+		if len(addresses) == 0 {
+			addresses = []string{config.Address}
+		}
+
+		status := statusOutput{}
+		for _, address := range addresses {
+			status.Nodes = append(status.Nodes, vaultNodeStatus(address))
+		}
+
+		if appConfig.GetBool(cfgKeyStore) {
+			status.KeyStore = vaultKeyStoreStatus()
+		}
+
+		if output != cfgOutputValueText {
+			writeOutput(output, status)
+		} else {
+			printStatus(status)
+		}
+
+		os.Exit(status.exitCode())
+	},
+}
+
+// exitCode returns the exit code of the status command: an error if a node is unreachable,
+// a key store error if the key store isn't healthy, or sealed if a node is sealed or not initialized
+func (status statusOutput) exitCode() int {
+	sealed := false
+	for _, node := range status.Nodes {
+		if node.Error != "" {
+			return exitCodeError
+		}
+		if node.Sealed || !node.Initialized {
+			sealed = true
+		}
+	}
+	if status.KeyStore != nil && !status.KeyStore.Healthy {
+		return exitCodeKeyStoreError
+	}
+	if sealed {
+		return exitCodeSealed
+	}
+	return 0
+}
+
+func printStatus(status statusOutput) {
+	for _, node := range status.Nodes {
+		if node.Error != "" {
+			fmt.Printf("%s: error: %s\n", node.Address, node.Error)
+			continue
+		}
+		role := "standalone"
+		if node.HAEnabled && node.Leader {
+			role = "leader"
+		} else if node.HAEnabled {
+			role = "standby, leader: " + node.LeaderAddress
+		}
+		fmt.Printf("%s: initialized: %t, sealed: %t (%d/%d), %s, version: %s\n",
+			node.Address, node.Initialized, node.Sealed, node.UnsealProgress, node.UnsealThreshold, role, node.Version)
+	}
+	if keyStore := status.KeyStore; keyStore != nil {
+		if keyStore.Healthy {
+			fmt.Printf("key store %s %s: healthy, keys: %s\n", keyStore.Mode, keyStore.Location, strings.Join(keyStore.Keys, ", "))
+		} else {
+			fmt.Printf("key store %s %s: error: %s\n", keyStore.Mode, keyStore.Location, keyStore.Error)
+		}
+	}
+}
+
+func vaultNodeStatus(address string) nodeStatus {
+	status := nodeStatus{Address: address}
+
+	cl, err := vaultClients.Client(vault.Endpoint{Address: address})
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+
+	health, err := cl.Sys().Health()
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	status.Initialized = health.Initialized
+	status.Sealed = health.Sealed
+	status.Standby = health.Standby
+	status.Version = health.Version
+	status.ClusterName = health.ClusterName
+
+	if !health.Initialized {
+		return status
+	}
+
+	sealStatus, err := cl.Sys().SealStatus()
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	status.SealType = sealStatus.Type
+	status.UnsealProgress = sealStatus.Progress
+	status.UnsealThreshold = sealStatus.T
+
+	if health.Sealed {
+		return status
+	}
+
+	leader, err := cl.Sys().Leader()
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	status.HAEnabled = leader.HAEnabled
+	status.Leader = leader.IsSelf
+	status.LeaderAddress = leader.LeaderAddress
+
+	return status
+}
+
+func vaultKeyStoreStatus() *keyStoreStatus {
+	status := &keyStoreStatus{
+		Mode:     appConfig.GetString(cfgMode),
+		Location: keyStoreLocation(appConfig),
+	}
+
+	store, err := kvStoreForConfig(appConfig)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+
+	keys, err := vault.StoredKeys(store, keyNamesForConfig(appConfig))
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	vault.WipeKeys(keys)
+
+	status.Healthy = true
+	for _, key := range keys {
+		status.Keys = append(status.Keys, key.ID)
+	}
+	return status
+}
+
+func init() {
+	statusCmd.PersistentFlags().StringSlice(cfgAddresses, nil, "Comma separated list of the Vault node addresses to query, VAULT_ADDR by default")
+	statusCmd.PersistentFlags().Bool(cfgKeyStore, true, "Check the key store given by --mode as well")
+	statusCmd.PersistentFlags().String(cfgOutput, cfgOutputValueText, outputHelp(cfgOutputValueText, cfgOutputValueJSON, cfgOutputValueYAML))
+
+	rootCmd.AddCommand(statusCmd)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+const cfgSyncLabelSelector = "sync-label-selector"
+const cfgSyncNamespace = "sync-namespace"
+const cfgSyncKVPath = "sync-kv-path"
+const cfgSyncPeriod = "sync-period"
+
+// syncPathAnnotation overrides the Vault path a Secret is written to
+const syncPathAnnotation = "vault.banzaicloud.com/sync-path"
+
+var syncCmd = &cobra.Command{
+	Use:   "sync-secrets",
+	Short: "Pushes labeled Kubernetes Secrets into a Vault KV secret engine",
+	Long: `It will continuously watch Kubernetes Secrets matching a label selector and write their
+contents into a Vault KV mount (both KV version 1 and 2 are supported), to help migrating
+existing Kubernetes Secrets into Vault.
+
+By default a Secret is written to <sync-kv-path>/<namespace>/<name>, this can be changed
+per Secret with the '` + syncPathAnnotation + `' annotation, which is relative to
+<sync-kv-path>/<namespace>, so a Secret can't overwrite the secrets of other namespaces.
+Deleting the Kubernetes Secret won't remove the secret from Vault.
+
+The Vault token is read from the VAULT_TOKEN environment variable and it needs write access
+to the KV mount.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		appConfig.BindPFlag(cfgSyncLabelSelector, cmd.PersistentFlags().Lookup(cfgSyncLabelSelector))
+		appConfig.BindPFlag(cfgSyncNamespace, cmd.PersistentFlags().Lookup(cfgSyncNamespace))
+		appConfig.BindPFlag(cfgSyncKVPath, cmd.PersistentFlags().Lookup(cfgSyncKVPath))
+		appConfig.BindPFlag(cfgSyncPeriod, cmd.PersistentFlags().Lookup(cfgSyncPeriod))
+
+		labelSelector := appConfig.GetString(cfgSyncLabelSelector)
+		namespace := appConfig.GetString(cfgSyncNamespace)
+		kvPath := strings.Trim(appConfig.GetString(cfgSyncKVPath), "/")
+		syncPeriod := appConfig.GetDuration(cfgSyncPeriod)
+
+		cl, err := newVaultClient()
+		if err != nil {
+			logrus.Fatalf("error connecting to vault: %s", err.Error())
+		}
+
+		k8s, err := kubernetesClient()
+		if err != nil {
+			logrus.Fatalf("error creating k8s client: %s", err.Error())
+		}
+
+		kvVersion2, err := kvMountVersion2(cl, kvPath)
+		if err != nil {
+			logrus.Fatalf("error syncing secrets into vault: %s", err.Error())
+		}
+
+		syncer := &secretSyncer{cl: cl, kvPath: kvPath, kvVersion2: kvVersion2}
+
+		_, controller := cache.NewInformer(
+			secretListWatch(k8s, namespace, labelSelector),
+			&v1.Secret{},
+			syncPeriod,
+			cache.ResourceEventHandlerFuncs{
+				AddFunc: func(obj interface{}) {
+					syncer.sync(obj.(*v1.Secret))
+				},
+				UpdateFunc: func(oldObj, newObj interface{}) {
+					// The periodic resyncs would write a new version of the unchanged secrets every time
+					if secretChanged(oldObj.(*v1.Secret), newObj.(*v1.Secret)) {
+						syncer.sync(newObj.(*v1.Secret))
+					}
+				},
+			},
+		)
+
+		logrus.Infof("syncing secrets matching '%s' into vault path '%s'", labelSelector, kvPath)
+
+		controller.Run(make(chan struct{}))
+	},
+}
+
+func secretListWatch(k8s *kubernetes.Clientset, namespace, labelSelector string) *cache.ListWatch {
+	return &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.LabelSelector = labelSelector
+			return k8s.CoreV1().Secrets(namespace).List(options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.LabelSelector = labelSelector
+			return k8s.CoreV1().Secrets(namespace).Watch(options)
+		},
+	}
+}
+
+// kvMountVersion2 returns whether the kv secret engine at kvPath is KV version 2, which has a
+// different API layout
+func kvMountVersion2(cl *api.Client, kvPath string) (bool, error) {
+	mounts, err := cl.Sys().ListMounts()
+	if err != nil {
+		return false, fmt.Errorf("error reading mounts from vault: %s", err.Error())
+	}
+
+	mount, ok := mounts[kvPath+"/"]
+	if !ok {
+		return false, fmt.Errorf("kv secret engine is not mounted at '%s'", kvPath)
+	}
+	return mount.Options["version"] == "2", nil
+}
+
+// secretChanged returns whether the Secret of an update event has been changed, the resyncs of the
+// informer are update events of the same version
+func secretChanged(oldSecret, newSecret *v1.Secret) bool {
+	return oldSecret.ResourceVersion != newSecret.ResourceVersion
+}
+
+type secretSyncer struct {
+	cl         *api.Client
+	kvPath     string
+	kvVersion2 bool
+}
+
+// secretPath returns the path of the Secret relative to the mount, the path of the annotation is
+// scoped to the namespace of the Secret
+func secretPath(secret *v1.Secret) (string, error) {
+	pathOverwrite, ok := secret.Annotations[syncPathAnnotation]
+	if !ok {
+		return secret.Namespace + "/" + secret.Name, nil
+	}
+	if strings.HasPrefix(pathOverwrite, "/") {
+		return "", fmt.Errorf("the %s annotation has to be a relative path: %s", syncPathAnnotation, pathOverwrite)
+	}
+	path := strings.Trim(pathOverwrite, "/")
+	if path == "" {
+		return "", fmt.Errorf("the %s annotation is empty", syncPathAnnotation)
+	}
+	for _, segment := range strings.Split(path, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return "", fmt.Errorf("invalid %s annotation: %s", syncPathAnnotation, pathOverwrite)
+		}
+	}
+	return secret.Namespace + "/" + path, nil
+}
+
+func (s *secretSyncer) sync(secret *v1.Secret) {
+	path, err := secretPath(secret)
+	if err != nil {
+		logrus.Errorf("error syncing secret %s/%s into vault: %s", secret.Namespace, secret.Name, err.Error())
+		return
+	}
+
+	data := map[string]interface{}{}
+	for key, value := range secret.Data {
+		data[key] = string(value)
+	}
+
+	if err := s.write(path, data); err != nil {
+		logrus.Errorf("error syncing secret %s/%s into vault: %s", secret.Namespace, secret.Name, err.Error())
+		return
+	}
+
+	logrus.Infof("synced secret %s/%s into vault", secret.Namespace, secret.Name)
+}
+
+// write writes the data under path, KV version 2 mounts have a different API layout
+func (s *secretSyncer) write(path string, data map[string]interface{}) (err error) {
+	if s.kvVersion2 {
+		_, err = s.cl.Logical().Write(fmt.Sprintf("%s/data/%s", s.kvPath, path), map[string]interface{}{"data": data})
+	} else {
+		_, err = s.cl.Logical().Write(fmt.Sprintf("%s/%s", s.kvPath, path), data)
+	}
+	return err
+}
+
+func init() {
+	syncCmd.PersistentFlags().String(cfgSyncLabelSelector, "vault.banzaicloud.com/sync=true", "Label selector of the Kubernetes Secrets to sync into Vault")
+	syncCmd.PersistentFlags().String(cfgSyncNamespace, "", "The namespace to watch for Kubernetes Secrets (all namespaces if empty)")
+	syncCmd.PersistentFlags().String(cfgSyncKVPath, "secret", "The path of the KV secret engine to write the secrets into")
+	syncCmd.PersistentFlags().Duration(cfgSyncPeriod, time.Minute*5, "How often to re-sync all the matching Kubernetes Secrets")
+
+	rootCmd.AddCommand(syncCmd)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSecretChanged(t *testing.T) {
+	secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "db", ResourceVersion: "1"}}
+
+	// A resync of the informer passes the same version as the old and the new object
+	if secretChanged(secret, secret.DeepCopy()) {
+		t.Errorf("a resync shouldn't be synced again")
+	}
+
+	updated := secret.DeepCopy()
+	updated.ResourceVersion = "2"
+	updated.Data = map[string][]byte{"password": []byte("changed")}
+	if !secretChanged(secret, updated) {
+		t.Errorf("an updated secret should be synced")
+	}
+}
+
+func TestSecretPath(t *testing.T) {
+	secret := func(annotations map[string]string) *v1.Secret {
+		return &v1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "db", Annotations: annotations}}
+	}
+
+	path, err := secretPath(secret(nil))
+	if err != nil || path != "team-a/db" {
+		t.Errorf("unexpected default path %q: %v", path, err)
+	}
+	path, err = secretPath(secret(map[string]string{syncPathAnnotation: "apps/db/"}))
+	if err != nil || path != "team-a/apps/db" {
+		t.Errorf("unexpected annotated path %q: %v", path, err)
+	}
+
+	for _, invalid := range []string{"/team-b/db", "../team-b/db", "apps/../../team-b/db", "apps//db", "", "/"} {
+		if path, err := secretPath(secret(map[string]string{syncPathAnnotation: invalid})); err == nil {
+			t.Errorf("the %q annotation should be rejected, got %q", invalid, path)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/spf13/cobra"
+)
+
+var templateCmd = &cobra.Command{
+	Use:   "template",
+	Short: "Renders the template of a Vault configuration file",
+	Long: `It executes the template of the configuration file used by the configure command and prints the
+result, to debug the template expansion. The template uses ${ } delimiters, the Sprig functions,
+the values of --vault-config-values as .Values and the environment variables as .Env (or the env
+function), and the kv function reads the keys of the key store given by --mode, etc. The files of a
+configuration split into several files are printed in order, each after a comment with its name.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		appConfig.BindPFlag(cfgVaultConfigFile, cmd.PersistentFlags().Lookup(cfgVaultConfigFile))
+		appConfig.BindPFlag(cfgVaultConfigValues, cmd.PersistentFlags().Lookup(cfgVaultConfigValues))
+
+		files, err := vault.ConfigFiles(vaultConfigPaths(appConfig.GetString(cfgVaultConfigFile)))
+
+		if err != nil {
+			exitWithError(exitCodeInvalidConfig, "%s", err.Error())
+		}
+
+		for _, file := range files {
+			config, err := renderVaultConfig(file)
+
+			if err != nil {
+				exitWithError(exitCodeInvalidConfig, "%s", err.Error())
+			}
+
+			if len(files) > 1 {
+				fmt.Printf("# %s\n", file)
+			}
+			fmt.Print(string(config))
+		}
+	},
+}
+
+func init() {
+	templateCmd.PersistentFlags().String(cfgVaultConfigFile, vault.DefaultConfigFile, vaultConfigFileHelp)
+	templateCmd.PersistentFlags().String(cfgVaultConfigValues, "", "A YAML/JSON file with the values of the Vault configuration template (.Values)")
+
+	rootCmd.AddCommand(templateCmd)
+}
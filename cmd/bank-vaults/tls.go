@@ -0,0 +1,278 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/banzaicloud/bank-vaults/pkg/tracing"
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/hashicorp/go-cleanhttp"
+	"github.com/hashicorp/vault/api"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/tools/cache"
+)
+
+const cfgVaultTLSSecret = "vault-tls-secret"
+const cfgVaultTLSSecretNamespace = "vault-tls-secret-namespace"
+const cfgVaultAddr = "vault-addr"
+const cfgVaultCACert = "ca-cert"
+const cfgVaultClientCert = "client-cert"
+const cfgVaultClientKey = "client-key"
+const cfgVaultTLSSkipVerify = "tls-skip-verify"
+const cfgVaultNamespace = "namespace"
+const cfgVaultAgentAddr = "vault-agent-addr"
+const cfgVaultTLSServerName = "tls-server-name"
+const cfgVaultProxy = "vault-proxy"
+const cfgVaultClientTimeout = "vault-client-timeout"
+const cfgVaultConnectionFile = "vault-connection-file"
+
+var (
+	tlsTransport     *secretTransport
+	tlsTransportErr  error
+	tlsTransportOnce sync.Once
+)
+
+// newVaultClient returns a Vault client configured from the environment and the flags, see vaultClientConfig
+func newVaultClient() (*api.Client, error) {
+	config, err := vaultClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	return newVaultClientForConfig(config)
+}
+
+// newVaultClientForConfig returns a Vault client with the given configuration, sending its
+// requests to the namespace given with --namespace, counting them in the metrics and tracing them,
+// behind a circuit breaker
+func newVaultClientForConfig(config *api.Config) (*api.Client, error) {
+	return newTracedVaultClientForConfig(config, tracing.DefaultTracer)
+}
+
+// newTracedVaultClientForConfig returns a Vault client like newVaultClientForConfig, with its requests traced by tracer
+func newTracedVaultClientForConfig(config *api.Config, tracer *tracing.Tracer) (*api.Client, error) {
+	instrumentVaultClientConfig(config, tracer)
+	cl, err := api.NewClient(config)
+	if err != nil {
+		return nil, err
+	}
+	vault.ClientOptions{Namespace: appConfig.GetString(cfgVaultNamespace)}.ConfigureClient(cl)
+	return cl, nil
+}
+
+// instrumentVaultClientConfig wraps the transport of the config, unless it has been wrapped already, so the
+// requests are counted in the metrics and traced by tracer behind the circuit breaker of the address
+func instrumentVaultClientConfig(config *api.Config, tracer *tracing.Tracer) {
+	if _, ok := config.HttpClient.Transport.(*instrumentedTransport); ok {
+		return
+	}
+	httpClient := *config.HttpClient
+	httpClient.Transport = &instrumentedTransport{
+		next:    config.HttpClient.Transport,
+		tracer:  tracer,
+		breaker: newCircuitBreaker("vault", config.Address),
+	}
+	config.HttpClient = &httpClient
+}
+
+// vaultClients are the clients of the Vault nodes and clusters managed at the same time (e.g. by
+// unseal --clusters-config or seal --all), each with its own configuration and token
+var vaultClients = vault.NewClientPool(vaultClientConfig, newVaultClientForConfig)
+
+// vaultClientConfig returns the Vault client configuration read from the environment (VAULT_ADDR, etc.),
+// overridden by the client flags. If a TLS Secret is set the CA bundle and the client certificate are
+// loaded from it and reloaded when it changes.
+func vaultClientConfig() (*api.Config, error) {
+	options, err := vaultClientOptions()
+	if err != nil {
+		return nil, err
+	}
+	secretName := appConfig.GetString(cfgVaultTLSSecret)
+
+	if options.CACert != "" || options.ClientCert != "" || options.ClientKey != "" || options.TLSSkipVerify ||
+		options.CACertPEM != nil || options.ClientCertPEM != nil || options.ClientKeyPEM != nil {
+		if secretName != "" {
+			return nil, fmt.Errorf("the TLS flags can't be used together with --%s", cfgVaultTLSSecret)
+		}
+	}
+
+	config, err := options.NewConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	if secretName == "" {
+		return config, nil
+	}
+
+	// The Secret is watched only once, all the clients share the transport
+	tlsTransportOnce.Do(func() {
+		namespace := appConfig.GetString(cfgVaultTLSSecretNamespace)
+		if namespace == "" {
+			namespace = os.Getenv("POD_NAMESPACE")
+		}
+		tlsTransport, tlsTransportErr = newSecretTransport(config.HttpClient.Transport.(*http.Transport), namespace, secretName)
+	})
+	if tlsTransportErr != nil {
+		return nil, tlsTransportErr
+	}
+
+	config.HttpClient.Transport = tlsTransport
+	return config, nil
+}
+
+// vaultClientOptions returns the settings of the Vault clients given by the vault block of the
+// --vault-connection-file, overridden by the client flags, the empty ones are read from the environment
+func vaultClientOptions() (vault.ClientOptions, error) {
+	options, err := vaultConnectionOptions(appConfig.GetString(cfgVaultConnectionFile))
+	if err != nil {
+		return vault.ClientOptions{}, err
+	}
+
+	overrideString := func(setting *string, key string) {
+		if value := appConfig.GetString(key); value != "" {
+			*setting = value
+		}
+	}
+	overrideString(&options.Address, cfgVaultAddr)
+	overrideString(&options.AgentAddress, cfgVaultAgentAddr)
+	overrideString(&options.CACert, cfgVaultCACert)
+	overrideString(&options.ClientCert, cfgVaultClientCert)
+	overrideString(&options.ClientKey, cfgVaultClientKey)
+	overrideString(&options.TLSServerName, cfgVaultTLSServerName)
+	overrideString(&options.Namespace, cfgVaultNamespace)
+	overrideString(&options.Proxy, cfgVaultProxy)
+	if appConfig.GetBool(cfgVaultTLSSkipVerify) {
+		options.TLSSkipVerify = true
+	}
+	if timeout := appConfig.GetDuration(cfgVaultClientTimeout); timeout > 0 {
+		options.Timeout = timeout
+	}
+	return options, nil
+}
+
+// vaultConnectionOptions reads the vault block of the YAML/JSON connection file, no settings if the
+// file isn't given
+func vaultConnectionOptions(file string) (vault.ClientOptions, error) {
+	var options vault.ClientOptions
+	if file == "" {
+		return options, nil
+	}
+	cfg := viper.New()
+	cfg.SetConfigFile(file)
+	if err := cfg.ReadInConfig(); err != nil {
+		return options, fmt.Errorf("error reading vault connection file: %s", err.Error())
+	}
+	if !cfg.IsSet("vault") {
+		return options, fmt.Errorf("no vault block found in %s", file)
+	}
+	if err := cfg.UnmarshalKey("vault", &options); err != nil {
+		return options, fmt.Errorf("error parsing vault connection file: %s", err.Error())
+	}
+	return options, nil
+}
+
+// secretTransport is an http.RoundTripper using the TLS settings stored in a Kubernetes Secret:
+// the CA bundle in ca.crt, and the client certificate in tls.crt and tls.key (all optional)
+type secretTransport struct {
+	baseTLSConfig *tls.Config
+	proxy         func(*http.Request) (*url.URL, error)
+
+	mu        sync.RWMutex
+	transport *http.Transport
+}
+
+func newSecretTransport(baseTransport *http.Transport, namespace, name string) (*secretTransport, error) {
+	k8s, err := kubernetesClient()
+	if err != nil {
+		return nil, err
+	}
+
+	t := &secretTransport{baseTLSConfig: baseTransport.TLSClientConfig, proxy: baseTransport.Proxy}
+
+	secret, err := k8s.CoreV1().Secrets(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error reading vault tls secret: %s", err.Error())
+	}
+	if err = t.update(secret); err != nil {
+		return nil, err
+	}
+
+	fieldSelector := fields.OneTermEqualSelector("metadata.name", name)
+	_, controller := cache.NewInformer(
+		cache.NewListWatchFromClient(k8s.CoreV1().RESTClient(), "secrets", namespace, fieldSelector),
+		&v1.Secret{},
+		time.Minute*5,
+		cache.ResourceEventHandlerFuncs{
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				oldSecret, newSecret := oldObj.(*v1.Secret), newObj.(*v1.Secret)
+				if oldSecret.ResourceVersion == newSecret.ResourceVersion {
+					return
+				}
+				if err := t.update(newSecret); err != nil {
+					logrus.Errorf("error reloading vault tls secret: %s", err.Error())
+					return
+				}
+				logrus.Infof("reloaded vault tls settings from secret %s/%s", namespace, name)
+			},
+		},
+	)
+	go controller.Run(make(chan struct{}))
+
+	return t, nil
+}
+
+// update replaces the transport with one using the TLS settings in the Secret
+func (t *secretTransport) update(secret *v1.Secret) error {
+	tlsConfig := t.baseTLSConfig.Clone()
+
+	if caCert, ok := secret.Data["ca.crt"]; ok {
+		certPool := x509.NewCertPool()
+		if !certPool.AppendCertsFromPEM(caCert) {
+			return fmt.Errorf("error parsing ca.crt of vault tls secret")
+		}
+		tlsConfig.RootCAs = certPool
+	}
+
+	cert, hasCert := secret.Data["tls.crt"]
+	key, hasKey := secret.Data["tls.key"]
+	if hasCert && hasKey {
+		clientCert, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			return fmt.Errorf("error parsing client certificate of vault tls secret: %s", err.Error())
+		}
+		tlsConfig.Certificates = []tls.Certificate{clientCert}
+	}
+
+	transport := cleanhttp.DefaultTransport()
+	transport.TLSHandshakeTimeout = 10 * time.Second
+	transport.TLSClientConfig = tlsConfig
+	transport.Proxy = t.proxy
+
+	t.mu.Lock()
+	oldTransport := t.transport
+	t.transport = transport
+	t.mu.Unlock()
+
+	if oldTransport != nil {
+		oldTransport.CloseIdleConnections()
+	}
+	return nil
+}
+
+// RoundTrip implements http.RoundTripper with the current transport
+func (t *secretTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.RLock()
+	transport := t.transport
+	t.mu.RUnlock()
+	return transport.RoundTrip(req)
+}
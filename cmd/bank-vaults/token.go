@@ -0,0 +1,37 @@
+package main
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var tokenCmd = &cobra.Command{
+	Use:   "token",
+	Short: "Manages the tokens used by bank-vaults",
+}
+
+var tokenRenewCmd = &cobra.Command{
+	Use:   "renew",
+	Short: "Keeps the token in VAULT_TOKEN renewed",
+	Long: `It keeps the (non-root) token in the VAULT_TOKEN environment variable renewed within its TTL,
+so configure can run with a renewable, scoped token instead of the root token, for example
+in a sidecar container. It exits with an error when the token can't be renewed anymore.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cl, err := newVaultClient()
+
+		if err != nil {
+			logrus.Fatalf("error connecting to vault: %s", err.Error())
+		}
+
+		if err := loginVault(cl, authMethodToken, "", ""); err != nil {
+			logrus.Fatal(err.Error())
+		}
+
+		logrus.Fatal(renewToken(cl).Error())
+	},
+}
+
+func init() {
+	tokenCmd.AddCommand(tokenRenewCmd)
+	rootCmd.AddCommand(tokenCmd)
+}
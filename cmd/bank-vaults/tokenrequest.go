@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const cfgTokenReviewerServiceAccount = "token-reviewer-service-account"
+const cfgTokenReviewerAudience = "token-reviewer-audience"
+const cfgTokenReviewerExpiration = "token-reviewer-expiration"
+
+// The authentication.k8s.io/v1 TokenRequest types are not vendored, these hold the fields needed
+
+type tokenRequest struct {
+	metav1.TypeMeta `json:",inline"`
+	Spec            tokenRequestSpec   `json:"spec"`
+	Status          tokenRequestStatus `json:"status,omitempty"`
+}
+
+type tokenRequestSpec struct {
+	Audiences         []string `json:"audiences,omitempty"`
+	ExpirationSeconds *int64   `json:"expirationSeconds,omitempty"`
+}
+
+type tokenRequestStatus struct {
+	Token               string      `json:"token"`
+	ExpirationTimestamp metav1.Time `json:"expirationTimestamp"`
+}
+
+// requestServiceAccountToken mints a short-lived token of a ServiceAccount with the TokenRequest API
+func requestServiceAccountToken(k8s *kubernetes.Clientset, namespace, name string, audiences []string, expiration time.Duration) (*tokenRequestStatus, error) {
+	expirationSeconds := int64(expiration.Seconds())
+	request := tokenRequest{
+		TypeMeta: metav1.TypeMeta{APIVersion: "authentication.k8s.io/v1", Kind: "TokenRequest"},
+		Spec: tokenRequestSpec{
+			Audiences:         audiences,
+			ExpirationSeconds: &expirationSeconds,
+		},
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := k8s.CoreV1().RESTClient().Post().
+		Namespace(namespace).
+		Resource("serviceaccounts").
+		Name(name).
+		SubResource("token").
+		Body(body).
+		Do().
+		Raw()
+	if err != nil {
+		return nil, fmt.Errorf("error requesting token for service account %s/%s: %s", namespace, name, err.Error())
+	}
+
+	response := tokenRequest{}
+	if err := json.Unmarshal(result, &response); err != nil {
+		return nil, fmt.Errorf("error decoding token request response: %s", err.Error())
+	}
+
+	return &response.Status, nil
+}
+
+// refreshTokenReviewerJWT keeps a short-lived token of the token reviewer ServiceAccount in tokenFile,
+// it requests a new token when 80% of the lifetime of the current one has passed and calls onRefresh
+func refreshTokenReviewerJWT(tokenFile string, onRefresh func()) error {
+	namespace := os.Getenv("POD_NAMESPACE")
+	serviceAccount := appConfig.GetString(cfgTokenReviewerServiceAccount)
+	expiration := appConfig.GetDuration(cfgTokenReviewerExpiration)
+
+	var audiences []string
+	if audience := appConfig.GetString(cfgTokenReviewerAudience); audience != "" {
+		audiences = []string{audience}
+	}
+
+	k8s, err := kubernetesClient()
+	if err != nil {
+		return err
+	}
+
+	refresh := func() (time.Duration, error) {
+		status, err := requestServiceAccountToken(k8s, namespace, serviceAccount, audiences, expiration)
+		if err != nil {
+			return 0, err
+		}
+		if err := ioutil.WriteFile(tokenFile, []byte(status.Token), 0600); err != nil {
+			return 0, fmt.Errorf("error writing token reviewer jwt: %s", err.Error())
+		}
+		lifetime := time.Until(status.ExpirationTimestamp.Time)
+		logrus.Infof("requested token reviewer jwt for service account %s/%s, expires in %s", namespace, serviceAccount, lifetime)
+		return lifetime * 8 / 10, nil
+	}
+
+	// The first token has to be there before the first configuration
+	wait, err := refresh()
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for {
+			time.Sleep(wait)
+
+			wait, err = refresh()
+			if err != nil {
+				logrus.Errorf("error refreshing token reviewer jwt: %s", err.Error())
+				wait = time.Minute
+				continue
+			}
+			onRefresh()
+		}
+	}()
+
+	return nil
+}
+
+// tokenReviewerJWTFile is where the minted token reviewer JWT is kept
+func tokenReviewerJWTFile() string {
+	return filepath.Join(os.TempDir(), "bank-vaults-token-reviewer-jwt")
+}
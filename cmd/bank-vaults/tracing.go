@@ -0,0 +1,28 @@
+package main
+
+import (
+	"os"
+
+	"github.com/banzaicloud/bank-vaults/pkg/tracing"
+)
+
+const cfgOTLPEndpoint = "otlp-endpoint"
+
+// initTracing starts exporting the traces of the lifecycle actions if an OTLP endpoint is set
+func initTracing() {
+	endpoint := appConfig.GetString(cfgOTLPEndpoint)
+	if endpoint == "" {
+		endpoint = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	}
+	if endpoint == "" {
+		return
+	}
+
+	serviceName := os.Getenv("OTEL_SERVICE_NAME")
+	if serviceName == "" {
+		serviceName = "bank-vaults"
+	}
+
+	// The spans of the failed action are exported by cleanup before exiting
+	tracing.Init(endpoint, serviceName)
+}
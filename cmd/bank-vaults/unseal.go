@@ -1,11 +1,17 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"os"
 	"time"
 
+	"github.com/banzaicloud/bank-vaults/pkg/admin"
+	"github.com/banzaicloud/bank-vaults/pkg/lock"
+	"github.com/banzaicloud/bank-vaults/pkg/logging"
+	"github.com/banzaicloud/bank-vaults/pkg/notify"
+	"github.com/banzaicloud/bank-vaults/pkg/runner"
 	"github.com/banzaicloud/bank-vaults/pkg/vault"
-	"github.com/hashicorp/vault/api"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
@@ -13,11 +19,26 @@ import (
 const cfgUnsealPeriod = "unseal-period"
 const cfgInit = "init"
 const cfgOnce = "once"
+const cfgRunMode = "run-mode"
+const cfgRunModeValueOnce = "once"
+const cfgRunModeValueWatch = "watch"
+const cfgNodeLocalSelector = "node-local-selector"
+const cfgClustersConfig = "clusters-config"
+const cfgRekeyPeriod = "rekey-period"
+const cfgRootTokenRotationPeriod = "root-token-rotation-period"
 
 type unsealCfg struct {
 	unsealPeriod time.Duration
-	proceedInit  bool
-	runOnce      bool
+	rekeyPeriod  time.Duration
+	// rootTokenRotationPeriod is the age after which the root token is rotated, never if 0
+	rootTokenRotationPeriod time.Duration
+	proceedInit             bool
+	runOnce                 bool
+	// raftJoin are the settings of joining the nodes of an HA cluster to its raft cluster, nil if
+	// they aren't joined
+	raftJoin *vault.RaftJoinOptions
+	// concurrent unseals the nodes of --addresses concurrently in every round
+	concurrent bool
 }
 
 var unsealConfig unsealCfg
@@ -25,32 +46,58 @@ var unsealConfig unsealCfg
 var unsealCmd = &cobra.Command{
 	Use:   "unseal",
 	Short: "Unseals Vault with with unseal keys stored in one of the supported Cloud Provider options.",
-	Long: `It will continuously attempt to unseal the target Vault instance, by retrieving unseal keys
-from one of the followings:
+	Long: `It will continuously (or only once with --run-mode=once) attempt to unseal the target Vault
+instance, by retrieving unseal keys from one of the followings:
 - Google Cloud KMS keyring (backed by GCS)
 - AWS KMS keyring (backed by S3)
 - Azure Key Vault
-- Kubernetes Secrets (should be used only for development purposes)`,
+- Kubernetes Secrets (should be used only for development purposes)
+
+With --ha-selector or --addresses every node of an HA cluster is unsealed, not only VAULT_ADDR.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		appConfig.BindPFlag(cfgUnsealPeriod, cmd.PersistentFlags().Lookup(cfgUnsealPeriod))
+		appConfig.BindPFlag(cfgRekeyPeriod, cmd.PersistentFlags().Lookup(cfgRekeyPeriod))
+		appConfig.BindPFlag(cfgRootTokenRotationPeriod, cmd.PersistentFlags().Lookup(cfgRootTokenRotationPeriod))
 		appConfig.BindPFlag(cfgInit, cmd.PersistentFlags().Lookup(cfgInit))
 		appConfig.BindPFlag(cfgOnce, cmd.PersistentFlags().Lookup(cfgOnce))
+		appConfig.BindPFlag(cfgRunMode, cmd.PersistentFlags().Lookup(cfgRunMode))
 		appConfig.BindPFlag(cfgInitRootToken, cmd.PersistentFlags().Lookup(cfgInitRootToken))
 		appConfig.BindPFlag(cfgStoreRootToken, cmd.PersistentFlags().Lookup(cfgStoreRootToken))
+		appConfig.BindPFlag(cfgNodeLocalSelector, cmd.PersistentFlags().Lookup(cfgNodeLocalSelector))
+		appConfig.BindPFlag(cfgClustersConfig, cmd.PersistentFlags().Lookup(cfgClustersConfig))
+		appConfig.BindPFlag(cfgEvents, cmd.PersistentFlags().Lookup(cfgEvents))
+		appConfig.BindPFlag(cfgEventsAnnotate, cmd.PersistentFlags().Lookup(cfgEventsAnnotate))
+		appConfig.BindPFlag(cfgLeaderElection, cmd.PersistentFlags().Lookup(cfgLeaderElection))
+		appConfig.BindPFlag(cfgHASelector, cmd.PersistentFlags().Lookup(cfgHASelector))
+		appConfig.BindPFlag(cfgAddresses, cmd.PersistentFlags().Lookup(cfgAddresses))
+		appConfig.BindPFlag(cfgUnsealConcurrently, cmd.PersistentFlags().Lookup(cfgUnsealConcurrently))
+		appConfig.BindPFlag(cfgVaultConfigFile, cmd.PersistentFlags().Lookup(cfgVaultConfigFile))
 		unsealConfig.unsealPeriod = appConfig.GetDuration(cfgUnsealPeriod)
+		unsealConfig.rekeyPeriod = appConfig.GetDuration(cfgRekeyPeriod)
+		unsealConfig.rootTokenRotationPeriod = appConfig.GetDuration(cfgRootTokenRotationPeriod)
 		unsealConfig.proceedInit = appConfig.GetBool(cfgInit)
-		unsealConfig.runOnce = appConfig.GetBool(cfgOnce)
+		unsealConfig.runOnce = runOnce()
+		unsealConfig.raftJoin = raftJoinOptions(appConfig.GetString(cfgVaultConfigFile))
+		unsealConfig.concurrent = appConfig.GetBool(cfgUnsealConcurrently)
 
-		store, err := kvStoreForConfig(appConfig)
+		serveMetrics()
+		serveAdmin()
 
-		if err != nil {
-			logrus.Fatalf("error creating kv store: %s", err.Error())
+		if clustersConfig := appConfig.GetString(cfgClustersConfig); clustersConfig != "" {
+			if unsealConfig.runOnce {
+				logrus.Fatalf("--%s=%s can't be used together with --%s", cfgRunMode, cfgRunModeValueOnce, cfgClustersConfig)
+			}
+			if appConfig.GetBool(cfgLeaderElection) {
+				logrus.Fatalf("--%s can't be used together with --%s", cfgLeaderElection, cfgClustersConfig)
+			}
+			unsealClusters(clustersConfig)
+			return
 		}
 
-		cl, err := api.NewClient(nil)
+		store, err := kvStoreForConfig(appConfig)
 
 		if err != nil {
-			logrus.Fatalf("error connecting to vault: %s", err.Error())
+			exitWithError(exitCodeKeyStoreError, "error creating kv store: %s", err.Error())
 		}
 
 		vaultConfig, err := vaultConfigForConfig(appConfig)
@@ -59,67 +106,270 @@ from one of the followings:
 			logrus.Fatalf("error building vault config: %s", err.Error())
 		}
 
-		v, err := vault.New(store, cl, vaultConfig)
+		locker, err := newLocker(appConfig, store, "")
 
 		if err != nil {
-			logrus.Fatalf("error creating vault helper: %s", err.Error())
+			logrus.Fatalf("error creating lock: %s", err.Error())
 		}
 
-		for {
-			func() {
-				if unsealConfig.proceedInit {
-					logrus.Infof("initializing vault...")
-					if err = v.Init(); err != nil {
-						logrus.Fatalf("error initializing vault: %s", err.Error())
-					} else {
-						unsealConfig.proceedInit = false
-					}
-				}
+		leaderElection := appConfig.GetBool(cfgLeaderElection)
+		if leaderElection && unsealConfig.runOnce {
+			logrus.Fatalf("--%s=%s can't be used together with --%s", cfgRunMode, cfgRunModeValueOnce, cfgLeaderElection)
+		}
+
+		if nodeLocalSelector := appConfig.GetString(cfgNodeLocalSelector); nodeLocalSelector != "" {
+			if unsealConfig.runOnce {
+				logrus.Fatalf("--%s=%s can't be used together with --%s", cfgRunMode, cfgRunModeValueOnce, cfgNodeLocalSelector)
+			}
+			if leaderElection {
+				logrus.Fatalf("--%s can't be used together with --%s", cfgLeaderElection, cfgNodeLocalSelector)
+			}
+			unsealNodeLocal(shutdownContext, store, locker, vaultConfig, nodeLocalSelector)
+			return
+		}
+
+		// The leader holds the lock the whole time, the unsealers must not release it after init
+		unsealLocker := locker
+		if leaderElection {
+			unsealLocker = nil
+		}
 
-				logrus.Infof("checking if vault is sealed...")
-				sealed, err := v.Sealed()
-				if err != nil {
-					logrus.Errorf("error checking if vault is sealed: %s", err.Error())
-					exitIfNecessary(1)
-					return
+		var run func(ctx context.Context)
+		haSelector := appConfig.GetString(cfgHASelector)
+		addresses := appConfig.GetStringSlice(cfgAddresses)
+
+		if unsealConfig.raftJoin != nil && haSelector == "" && len(addresses) == 0 {
+			logrus.Fatalf("joining the raft cluster in --%s requires --%s or --%s", cfgVaultConfigFile, cfgHASelector, cfgAddresses)
+		}
+		if unsealConfig.concurrent && len(addresses) == 0 {
+			logrus.Fatalf("--%s requires --%s", cfgUnsealConcurrently, cfgAddresses)
+		}
+
+		switch {
+		case haSelector != "" && len(addresses) > 0:
+			logrus.Fatalf("--%s can't be used together with --%s", cfgHASelector, cfgAddresses)
+
+		case haSelector != "" || len(addresses) > 0:
+			if unsealConfig.runOnce {
+				logrus.Fatalf("--%s=%s can't be used together with --%s or --%s", cfgRunMode, cfgRunModeValueOnce, cfgHASelector, cfgAddresses)
+			}
+			run = func(ctx context.Context) {
+				if haSelector != "" {
+					unsealHAPods(ctx, store, unsealLocker, vaultConfig, haSelector)
+				} else {
+					unsealAddresses(ctx, store, unsealLocker, vaultConfig, addresses)
 				}
+			}
+
+		default:
+			cl, err := newVaultClient()
 
-				logrus.Infof("vault sealed: %t", sealed)
+			if err != nil {
+				logrus.Fatalf("error connecting to vault: %s", err.Error())
+			}
 
-				// If vault is not sealed, we stop here and wait another unsealPeriod
-				if !sealed {
-					exitIfNecessary(0)
-					return
+			v, err := vault.New(store, cl, vaultConfig)
+
+			if err != nil {
+				logrus.Fatalf("error creating vault helper: %s", err.Error())
+			}
+
+			u := &unsealer{
+				vault:       v,
+				events:      newOwnPodEventRecorder(),
+				log:         logrus.StandardLogger(),
+				status:      adminServer.Target(""),
+				lock:        unsealLocker,
+				proceedInit: unsealConfig.proceedInit,
+				fatalInit:   true,
+			}
+
+			run = func(ctx context.Context) {
+				for {
+					u.unseal()
+
+					// wait unsealPeriod before trying again
+					if !sleepContext(ctx, unsealConfig.unsealPeriod) {
+						return
+					}
 				}
+			}
+		}
+
+		if leaderElection {
+			runAsLeader(locker, run)
+			return
+		}
+		run(shutdownContext)
+	},
+}
 
-				if err = v.Unseal(); err != nil {
-					logrus.Errorf("error unsealing vault: %s", err.Error())
-					exitIfNecessary(1)
-					return
+// unsealer holds the state of initializing and unsealing a single Vault cluster
+type unsealer struct {
+	vault  runner.Vault
+	events *podEventRecorder
+	log    logrus.FieldLogger
+	// target is the name of the cluster or Pod in the metrics, empty for VAULT_ADDR
+	target string
+	// address is the address of the client in vaultClients, empty if it isn't in the pool
+	address string
+	status  *admin.Target
+	// lock is held during initialization, so only one replica initializes Vault, nil if disabled
+	lock        lock.Locker
+	proceedInit bool
+	// fatalInit makes initialization errors fatal, otherwise they are retried in the next round
+	fatalInit bool
+	// runner runs the rounds, it is created by the first round
+	runner *runner.Runner
+}
+
+// newRunner returns the runner of the unsealer, reporting the lifecycle actions in the metrics,
+// the events and the notifications
+func (u *unsealer) newRunner() *runner.Runner {
+	return runner.New(u.vault, runner.Config{
+		Init:                    u.proceedInit,
+		RekeyPeriod:             unsealConfig.rekeyPeriod,
+		RootTokenRotationPeriod: unsealConfig.rootTokenRotationPeriod,
+		Lock:                    u.lock,
+		LockRetryInterval:       lockRetryInterval,
+		LockTimeout:             appConfig.GetDuration(cfgLockTimeout),
+		Status:                  u.status,
+		Logger:                  logging.NewLogrus(u.log),
+		Hooks: runner.Hooks{
+			Initialized: func(result *vault.InitResult) {
+				initTotal.Inc(u.target, metricsResultSuccess)
+				if !result.AlreadyInitialized {
+					initDuration.Observe(result.Duration.Seconds(), u.target)
+				}
+				u.events.normal(eventReasonInitialized, "vault is initialized")
+				if !result.AlreadyInitialized {
+					notifyEvent(notify.EventInitialized, u.target, "vault has been initialized", nil)
+					handOffWrappedKeys(u.target, result, true)
+					notifyRootTokenStored(u.target, result)
+				}
+			},
+			InitFailed: func(err error) {
+				initTotal.Inc(u.target, metricsResultFailure)
+				u.events.warning(eventReasonInitFailed, err.Error())
+			},
+			SealChecked: func(sealed bool) {
+				if sealed {
+					vaultSealed.Set(1, u.target)
+				} else {
+					vaultSealed.Set(0, u.target)
 				}
+			},
+			SealCheckFailed: func(err error) {
+				u.events.warning(eventReasonSealCheckFailed, err.Error())
+			},
+			Sealed: func() {
+				notifyEvent(notify.EventSealed, u.target, "vault is sealed", nil)
+			},
+			Unsealed: func() {
+				unsealAttemptsTotal.Inc(u.target, metricsResultSuccess)
+				vaultSealed.Set(0, u.target)
+				notifyEvent(notify.EventUnsealed, u.target, "vault has been unsealed", nil)
+				u.events.normal(eventReasonUnsealed, "successfully unsealed vault")
+			},
+			UnsealFailed: func(err error) {
+				unsealAttemptsTotal.Inc(u.target, metricsResultFailure)
+				u.events.warning(eventReasonUnsealFailed, err.Error())
+			},
+			Rekeyed: func(result *vault.RekeyResult) {
+				u.events.normal(eventReasonRekeyed, "vault has been rekeyed")
+				notifyEvent(notify.EventRekeyed, u.target, "vault has been rekeyed", nil)
+			},
+			RekeyFailed: func(err error) {
+				u.events.warning(eventReasonRekeyFailed, err.Error())
+			},
+			RootTokenRotated: func() {
+				u.events.normal(eventReasonRootTokenRotated, "the root token of vault has been rotated")
+				notifyEvent(notify.EventRootTokenRotated, u.target, "the root token of vault has been rotated", nil)
+			},
+			RootTokenRotationFailed: func(err error) {
+				u.events.warning(eventReasonRootTokenRotationFailed, err.Error())
+			},
+		},
+	})
+}
 
-				logrus.Infof("successfully unsealed vault")
-				exitIfNecessary(0)
-			}()
+func (u *unsealer) unseal() {
+	if u.runner == nil {
+		u.runner = u.newRunner()
+	}
 
-			// wait unsealPeriod before trying again
-			time.Sleep(unsealConfig.unsealPeriod)
+	err := u.runner.Step(shutdownContext)
+	if err == nil {
+		exitIfNecessary(0)
+		return
+	}
+
+	runnerErr, ok := err.(*runner.Error)
+	if !ok {
+		// The process is shutting down
+		return
+	}
+	switch runnerErr.Phase {
+	case runner.PhaseInit:
+		// It is unsealed in the next step
+		if errors.Is(err, vault.ErrAlreadyInitialized) {
+			u.log.Infof("vault has been initialized by someone else: %s", err.Error())
+			return
 		}
-	},
+		if u.fatalInit {
+			u.log.Fatal(err.Error())
+		}
+		u.log.Error(err.Error())
+	case runner.PhaseSealCheck, runner.PhaseRekey, runner.PhaseRotateRootToken:
+		u.log.Error(err.Error())
+		exitIfNecessary(exitCodeError)
+	default:
+		u.log.Error(err.Error())
+		exitIfNecessary(exitCodeForError(err, exitCodeSealed))
+	}
+}
+
+// runOnce returns true if the command has to do its job only once instead of watching Vault,
+// the deprecated --once flag is still honored
+func runOnce() bool {
+	switch runMode := appConfig.GetString(cfgRunMode); runMode {
+	case cfgRunModeValueOnce:
+		return true
+	case cfgRunModeValueWatch:
+		return appConfig.GetBool(cfgOnce)
+	default:
+		logrus.Fatalf("invalid --%s: %s, it has to be %s or %s", cfgRunMode, runMode, cfgRunModeValueOnce, cfgRunModeValueWatch)
+		return false
+	}
 }
 
 func exitIfNecessary(code int) {
 	if unsealConfig.runOnce {
+		cleanup()
 		os.Exit(code)
 	}
 }
 
 func init() {
 	unsealCmd.PersistentFlags().Duration(cfgUnsealPeriod, time.Second*30, "How often to attempt to unseal the vault instance")
+	unsealCmd.PersistentFlags().Duration(cfgRekeyPeriod, 0, "Rekey Vault when its keys get older than this (e.g. 2160h), 0 disables the periodic rekey")
+	unsealCmd.PersistentFlags().Duration(cfgRootTokenRotationPeriod, 0, "Rotate the stored root token of Vault when it gets older than this (e.g. 720h), 0 disables the periodic rotation")
 	unsealCmd.PersistentFlags().Bool(cfgInit, false, "Initialize vault instantce if not yet initialized")
+	unsealCmd.PersistentFlags().String(cfgRunMode, cfgRunModeValueWatch, "Unseal Vault only once and exit with the result ("+cfgRunModeValueOnce+"), or keep checking it every unseal-period ("+cfgRunModeValueWatch+")")
 	unsealCmd.PersistentFlags().Bool(cfgOnce, false, "Run unseal only once")
+	unsealCmd.PersistentFlags().MarkDeprecated(cfgOnce, "use --"+cfgRunMode+"="+cfgRunModeValueOnce+" instead")
 	unsealCmd.PersistentFlags().String(cfgInitRootToken, "", "root token for the new vault cluster (only if -init=true)")
 	unsealCmd.PersistentFlags().Bool(cfgStoreRootToken, true, "should the root token be stored in the key store (only if -init=true)")
+	unsealCmd.PersistentFlags().String(cfgNodeLocalSelector, "", "Label selector of the Vault pods to unseal on the node set in NODE_NAME (in the namespace set in POD_NAMESPACE), instead of VAULT_ADDR")
+	unsealCmd.PersistentFlags().String(cfgClustersConfig, "", "A YAML/JSON file listing several Vault clusters to unseal, each with its own address and key store settings")
+	unsealCmd.PersistentFlags().String(cfgHASelector, "", "Label selector of the Vault pods of an HA cluster to unseal every one of (in the namespace set in POD_NAMESPACE), instead of VAULT_ADDR")
+	unsealCmd.PersistentFlags().StringSlice(cfgAddresses, nil, "Comma separated list of the addresses of the Vault nodes of an HA cluster to unseal every one of, instead of VAULT_ADDR")
+	unsealCmd.PersistentFlags().Bool(cfgUnsealConcurrently, false, "Unseal the sealed nodes of --"+cfgAddresses+" concurrently in every round, reporting the state of every node in the log and the status of --"+cfgAdminAddr)
+	unsealCmd.PersistentFlags().String(cfgVaultConfigFile, "", "The config file of Vault whose raft section sets how the nodes of --"+cfgHASelector+" or --"+cfgAddresses+" join the raft cluster")
+	unsealCmd.PersistentFlags().Bool(cfgLeaderElection, false, "Only the replica holding the lock (see --"+cfgLock+") unseals Vault, the others wait as standbys to take over")
+
+	unsealCmd.PersistentFlags().Bool(cfgEvents, false, "Emit Kubernetes Events about the lifecycle actions on the Vault pod (set in POD_NAME and POD_NAMESPACE)")
+	unsealCmd.PersistentFlags().Bool(cfgEventsAnnotate, false, "Also annotate the Vault pod with the last lifecycle event (only if -events=true)")
 
 	rootCmd.AddCommand(unsealCmd)
 }
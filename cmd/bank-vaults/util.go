@@ -2,147 +2,209 @@ package main
 
 import (
 	"fmt"
+	"os"
+	"strconv"
+	"strings"
 
 	"github.com/banzaicloud/bank-vaults/pkg/kv"
-	"github.com/banzaicloud/bank-vaults/pkg/kv/alibabakms"
-	"github.com/banzaicloud/bank-vaults/pkg/kv/alibabaoss"
-	"github.com/banzaicloud/bank-vaults/pkg/kv/awskms"
-	"github.com/banzaicloud/bank-vaults/pkg/kv/azurekv"
-	"github.com/banzaicloud/bank-vaults/pkg/kv/dev"
-	"github.com/banzaicloud/bank-vaults/pkg/kv/gckms"
-	"github.com/banzaicloud/bank-vaults/pkg/kv/gcs"
-	"github.com/banzaicloud/bank-vaults/pkg/kv/k8s"
-	"github.com/banzaicloud/bank-vaults/pkg/kv/s3"
+	"github.com/banzaicloud/bank-vaults/pkg/tracing"
 	"github.com/banzaicloud/bank-vaults/pkg/vault"
 	"github.com/spf13/viper"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
 )
 
-func vaultConfigForConfig(cfg *viper.Viper) (vault.Config, error) {
-
-	return vault.Config{
-		SecretShares:    appConfig.GetInt(cfgSecretShares),
-		SecretThreshold: appConfig.GetInt(cfgSecretThreshold),
-
-		InitRootToken:  appConfig.GetString(cfgInitRootToken),
-		StoreRootToken: appConfig.GetBool(cfgStoreRootToken),
-	}, nil
+// keyNamesForConfig returns the names of the keys in the key store
+func keyNamesForConfig(cfg *viper.Viper) vault.KeyNames {
+	return vault.KeyNames{
+		Cluster:      cfg.GetString(cfgClusterName),
+		Prefix:       cfg.GetString(cfgKeyPrefix),
+		UnsealKey:    cfg.GetString(cfgUnsealKeyName),
+		RecoveryKey:  cfg.GetString(cfgRecoveryKeyName),
+		RootToken:    cfg.GetString(cfgRootTokenKeyName),
+		Test:         cfg.GetString(cfgTestKeyName),
+		KeysMetadata: cfg.GetString(cfgKeysMetadataKeyName),
+
+		ProvisionerToken: cfg.GetString(cfgProvisionerTokenKeyName),
+	}
 }
 
-func kvStoreForConfig(cfg *viper.Viper) (kv.Service, error) {
-
-	if cfg.GetString(cfgMode) == cfgModeValueGoogleCloudKMSGCS {
-
-		g, err := gcs.New(
-			cfg.GetString(cfgGoogleCloudStorageBucket),
-			cfg.GetString(cfgGoogleCloudStoragePrefix),
-		)
-
-		if err != nil {
-			return nil, fmt.Errorf("error creating google cloud storage kv store: %s", err.Error())
+// retryOverridesForConfig returns the retry policies of the kinds of operations of --retry-overrides,
+// they only override the number of retries, the backoff is the same for every operation
+func retryOverridesForConfig(cfg *viper.Viper) (map[vault.RetryOperation]vault.RetryPolicy, error) {
+	overrides := map[vault.RetryOperation]vault.RetryPolicy{}
+	for _, override := range strings.Split(cfg.GetString(cfgRetryOverrides), ",") {
+		if override = strings.TrimSpace(override); override == "" {
+			continue
+		}
+		parts := strings.SplitN(override, "=", 2)
+		operation := vault.RetryOperation(strings.TrimSpace(parts[0]))
+		switch operation {
+		case vault.RetryVault, vault.RetryKeyStore, vault.RetryUnseal:
+		default:
+			return nil, fmt.Errorf("invalid --%s: unknown operation %s", cfgRetryOverrides, operation)
+		}
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid --%s: %s is not operation=retries", cfgRetryOverrides, override)
+		}
+		retries, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil || retries < 0 {
+			return nil, fmt.Errorf("invalid --%s: the retries of %s must be a non-negative number", cfgRetryOverrides, operation)
 		}
+		overrides[operation] = vault.RetryPolicy{
+			MaxRetries:      retries,
+			InitialInterval: cfg.GetDuration(cfgRetryInterval),
+			MaxInterval:     cfg.GetDuration(cfgRetryMaxInterval),
+			Jitter:          cfg.GetFloat64(cfgRetryJitter),
+		}
+	}
+	return overrides, nil
+}
 
-		kms, err := gckms.New(g,
-			cfg.GetString(cfgGoogleCloudKMSProject),
-			cfg.GetString(cfgGoogleCloudKMSLocation),
-			cfg.GetString(cfgGoogleCloudKMSKeyRing),
-			cfg.GetString(cfgGoogleCloudKMSCryptoKey),
-		)
+func vaultConfigForConfig(cfg *viper.Viper) (vault.Config, error) {
+	hooks, err := hooksForConfig(cfg)
+	if err != nil {
+		return vault.Config{}, err
+	}
 
-		if err != nil {
-			return nil, fmt.Errorf("error creating google cloud kms kv store: %s", err.Error())
-		}
+	retryOverrides, err := retryOverridesForConfig(cfg)
+	if err != nil {
+		return vault.Config{}, err
+	}
 
-		return kms, nil
+	shareKeyStores, err := shareKeyStoresForConfig(cfg)
+	if err != nil {
+		return vault.Config{}, err
 	}
 
-	if cfg.GetString(cfgMode) == cfgModeValueAWSKMS3 {
-		s3, err := s3.New(
-			cfg.GetString(cfgAWSS3Region),
-			cfg.GetString(cfgAWSS3Bucket),
-			cfg.GetString(cfgAWSS3Prefix),
-		)
+	return vault.Config{
+		SecretShares:    cfg.GetInt(cfgSecretShares),
+		SecretThreshold: cfg.GetInt(cfgSecretThreshold),
 
-		if err != nil {
-			return nil, fmt.Errorf("error creating AWS S3 kv store: %s", err.Error())
-		}
+		InitRootToken:  cfg.GetString(cfgInitRootToken),
+		StoreRootToken: cfg.GetBool(cfgStoreRootToken),
 
-		kms, err := awskms.New(s3, cfg.GetString(cfgAWSKMSRegion), cfg.GetString(cfgAWSKMSKeyID))
+		EphemeralRootToken: cfg.GetBool(cfgEphemeralRootToken),
+		ProvisionerToken:   cfg.GetBool(cfgProvisionerToken),
+		WrapKeysTTL:        cfg.GetDuration(cfgWrapKeysTTL),
 
-		if err != nil {
-			return nil, fmt.Errorf("error creating AWS KMS kv store: %s", err.Error())
-		}
+		KeyNames:       keyNamesForConfig(cfg),
+		ShareKeyStores: shareKeyStores,
 
-		return kms, nil
-	}
+		Context: shutdownContext,
 
-	if cfg.GetString(cfgMode) == cfgModeValueAzureKeyVault {
-		kms, err := azurekv.New(cfg.GetString(cfgAzureKeyVaultName))
-		if err != nil {
-			return nil, fmt.Errorf("error creating Azure Key Vault kv store: %s", err.Error())
-		}
+		InitWaitTimeout:  cfg.GetDuration(cfgInitWaitTimeout),
+		UnsealTimeout:    cfg.GetDuration(cfgUnsealTimeout),
+		ConfigureTimeout: cfg.GetDuration(cfgConfigureTimeout),
+		KVTimeout:        cfg.GetDuration(cfgKVTimeout),
 
-		return kms, nil
-	}
+		Retries:              cfg.GetInt(cfgRetries),
+		RetryInitialInterval: cfg.GetDuration(cfgRetryInterval),
+		RetryMaxInterval:     cfg.GetDuration(cfgRetryMaxInterval),
+		RetryJitter:          cfg.GetFloat64(cfgRetryJitter),
+		RetryOverrides:       retryOverrides,
 
-	if cfg.GetString(cfgMode) == cfgModeValueAlibabaKMSOSS {
-		accessKeyID := cfg.GetString(cfgAlibabaAccessKeyID)
-		accessKeySecret := cfg.GetString(cfgAlibabaAccessKeySecret)
+		ConfigureConcurrency: cfg.GetInt(cfgConfigureConcurrency),
+		RateLimit:            cfg.GetFloat64(cfgVaultRateLimit),
+		RateBurst:            cfg.GetInt(cfgVaultRateBurst),
 
-		if accessKeyID == "" || accessKeySecret == "" {
-			return nil, fmt.Errorf("Alibaba accessKeyID or accessKeySecret can't be empty")
-		}
+		StrictConfig: cfg.GetBool(cfgStrictConfig),
 
-		bucket := cfg.GetString(cfgAlibabaOSSBucket)
+		ReadBack:       cfg.GetBool(cfgReadBack),
+		ReadBackSample: cfg.GetInt(cfgReadBackSample),
 
-		if bucket == "" {
-			return nil, fmt.Errorf("Alibaba OSS bucket should be specified")
-		}
+		PurgeUnmanaged: cfg.GetBool(cfgPurgeUnmanaged),
+		PurgeProtected: purgeProtectedForConfig(cfg),
+
+		TrackConfigState: cfg.GetBool(cfgTrackConfigState),
+
+		Hooks: hooks,
+	}, nil
+}
 
-		oss, err := alibabaoss.New(
-			cfg.GetString(cfgAlibabaOSSEndpoint),
-			accessKeyID,
-			accessKeySecret,
-			bucket,
-			cfg.GetString(cfgAlibabaOSSPrefix),
-		)
+// shareKeyStoresForConfig returns the key stores of the files of --share-key-stores, the secret
+// shares are distributed across
+func shareKeyStoresForConfig(cfg *viper.Viper) ([]kv.Service, error) {
+	var stores []kv.Service
+	for _, file := range cfg.GetStringSlice(cfgShareKeyStores) {
+		storeCfg, err := keyStoreConfigForFile(file)
 		if err != nil {
-			return nil, fmt.Errorf("error creating Alibaba OSS kv store: %s", err.Error())
+			return nil, err
 		}
-
-		kms, err := alibabakms.New(
-			cfg.GetString(cfgAlibabaKMSRegion),
-			accessKeyID,
-			accessKeySecret,
-			cfg.GetString(cfgAlibabaKMSKeyID),
-			oss)
+		store, err := kvStoreForConfig(storeCfg)
 		if err != nil {
-			return nil, fmt.Errorf("error creating Alibaba KMS kv store: %s", err.Error())
+			return nil, fmt.Errorf("error creating the share key store of %s: %s", file, err.Error())
 		}
+		stores = append(stores, store)
+	}
+	return stores, nil
+}
 
-		return kms, nil
+// kvStoreForConfig returns the key store selected by the mode, instrumented with metrics and tracing
+func kvStoreForConfig(cfg *viper.Viper) (kv.Service, error) {
+	return tracedKVStoreForConfig(cfg, tracing.DefaultTracer)
+}
+
+// tracedKVStoreForConfig returns the key store selected by the mode, with its requests traced by tracer
+func tracedKVStoreForConfig(cfg *viper.Viper, tracer *tracing.Tracer) (kv.Service, error) {
+	store, err := kvStoreForMode(cfg)
+	if err != nil {
+		return nil, err
 	}
+	return &instrumentedKVStore{
+		Service: store,
+		backend: cfg.GetString(cfgMode),
+		tracer:  tracer,
+		breaker: newCircuitBreaker(cfg.GetString(cfgMode), keyStoreLocation(cfg)),
+	}, nil
+}
 
-	if cfg.GetString(cfgMode) == cfgModeValueK8S {
-		k8s, err := k8s.New(
-			cfg.GetString(cfgK8SNamespace),
-			cfg.GetString(cfgK8SSecret),
-		)
+func kvStoreForMode(cfg *viper.Viper) (kv.Service, error) {
+	return kv.NewFromConfig(cfg)
+}
 
-		if err != nil {
-			return nil, fmt.Errorf("error creating K8S Secret kv store: %s", err.Error())
+// otherKVStoresHelp lists the backends registered with kv.Register besides the built-in ones in the help of --mode
+func otherKVStoresHelp() string {
+	builtin := map[string]bool{
+		cfgModeValueGoogleCloudKMSGCS: true,
+		cfgModeValueAWSKMS3:           true,
+		cfgModeValueAzureKeyVault:     true,
+		cfgModeValueAlibabaKMSOSS:     true,
+		cfgModeValueK8S:               true,
+		cfgModeValueDev:               true,
+		cfgModeValueFile:              true,
+		cfgModeValueEncrypted:         true,
+	}
+	help := ""
+	for _, name := range kv.Backends() {
+		if !builtin[name] {
+			help += fmt.Sprintf(";\n\t\t\t\t\t\t'%s' => registered backend", name)
 		}
+	}
+	return help
+}
+
+func kubernetesClient() (*kubernetes.Clientset, error) {
+	kubeconfig := os.Getenv(clientcmd.RecommendedConfigPathEnvVar)
+
+	var config *rest.Config
+	var err error
 
-		return k8s, nil
+	if kubeconfig != "" {
+		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+	} else {
+		config, err = rest.InClusterConfig()
 	}
 
-	if cfg.GetString(cfgMode) == cfgModeValueDev {
-		k8s, err := dev.New()
-		if err != nil {
-			return nil, fmt.Errorf("error creating Dev Secret kv store: %s", err.Error())
-		}
+	if err != nil {
+		return nil, fmt.Errorf("error creating k8s config: %s", err.Error())
+	}
 
-		return k8s, nil
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("error creating k8s client: %s", err.Error())
 	}
 
-	return nil, fmt.Errorf("Unsupported backend mode: '%s'", cfg.GetString(cfgMode))
+	return client, nil
 }
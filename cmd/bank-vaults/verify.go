@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const cfgCheckPolicyReferences = "check-policy-references"
+
+var verifyCmd = &cobra.Command{
+	Use:     "verify",
+	Aliases: []string{"validate"},
+	Short:   "Verifies a YAML/JSON Vault configuration file without contacting Vault",
+	Long: `It checks the structure of the configuration file used by the configure command, the syntax
+of the policies and the types of the auth method role payloads, so configuration changes can
+be checked in CI before they are merged. The policies of the auth roles, users and mappings have to
+be defined in the configuration, unless --check-policy-references=false. With --strict-config the
+unknown fields are reported too. Every problem is reported at once, and it exits with 5 if there are any.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		appConfig.BindPFlag(cfgVaultConfigFile, cmd.PersistentFlags().Lookup(cfgVaultConfigFile))
+		appConfig.BindPFlag(cfgVaultConfigValues, cmd.PersistentFlags().Lookup(cfgVaultConfigValues))
+		appConfig.BindPFlag(cfgOutput, cmd.PersistentFlags().Lookup(cfgOutput))
+		appConfig.BindPFlag(cfgStrictConfig, cmd.PersistentFlags().Lookup(cfgStrictConfig))
+		appConfig.BindPFlag(cfgCheckPolicyReferences, cmd.PersistentFlags().Lookup(cfgCheckPolicyReferences))
+		vaultConfigFile := appConfig.GetString(cfgVaultConfigFile)
+
+		output := appConfig.GetString(cfgOutput)
+		checkOutput(output, cfgOutputValueText, cfgOutputValueJSON, cfgOutputValueYAML)
+
+		cfg := viper.New()
+		if err := readVaultConfig(vaultConfigFile, cfg); err != nil {
+			exitWithError(exitCodeInvalidConfig, "%s", err.Error())
+		}
+
+		verify := vault.VerifyConfig
+		if appConfig.GetBool(cfgStrictConfig) {
+			verify = vault.VerifyConfigStrict
+		}
+		errs := verify(cfg.AllSettings())
+		if appConfig.GetBool(cfgCheckPolicyReferences) {
+			errs = append(errs, vault.VerifyPolicyReferences(cfg.AllSettings())...)
+		}
+
+		if output != cfgOutputValueText {
+			writeOutput(output, errs)
+		} else {
+			for _, err := range errs {
+				fmt.Printf("%s: %s\n", vaultConfigFile, err.Error())
+			}
+			if len(errs) == 0 {
+				fmt.Printf("%s: ok\n", vaultConfigFile)
+			}
+		}
+
+		if len(errs) > 0 {
+			os.Exit(exitCodeInvalidConfig)
+		}
+	},
+}
+
+func init() {
+	verifyCmd.PersistentFlags().String(cfgVaultConfigFile, vault.DefaultConfigFile, vaultConfigFileHelp)
+	verifyCmd.PersistentFlags().String(cfgVaultConfigValues, "", "A YAML/JSON file with the values of the Vault configuration template (.Values)")
+	verifyCmd.PersistentFlags().Bool(cfgStrictConfig, false, "Report the unknown fields as well, which are ignored by configure")
+	verifyCmd.PersistentFlags().Bool(cfgCheckPolicyReferences, true, "Report the policies of the auth roles, users and mappings which aren't defined in the configuration")
+	verifyCmd.PersistentFlags().String(cfgOutput, cfgOutputValueText, outputHelp(cfgOutputValueText, cfgOutputValueJSON, cfgOutputValueYAML))
+
+	rootCmd.AddCommand(verifyCmd)
+}
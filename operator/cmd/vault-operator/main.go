@@ -4,8 +4,11 @@ import (
 	"context"
 	"os"
 	"runtime"
+	"strings"
 
 	stub "github.com/banzaicloud/bank-vaults/operator/pkg/stub"
+	"github.com/banzaicloud/bank-vaults/operator/pkg/webhook"
+	"github.com/banzaicloud/bank-vaults/pkg/metrics"
 	sdk "github.com/operator-framework/operator-sdk/pkg/sdk"
 	sdkVersion "github.com/operator-framework/operator-sdk/version"
 
@@ -13,18 +16,77 @@ import (
 )
 
 const operatorNamespace = "OPERATOR_NAMESPACE"
+const watchNamespaces = "WATCH_NAMESPACES"
+const webhookAddr = "WEBHOOK_ADDR"
+const webhookCertFile = "WEBHOOK_CERT_FILE"
+const webhookKeyFile = "WEBHOOK_KEY_FILE"
+const agentImage = "AGENT_IMAGE"
+const agentVaultAddr = "AGENT_VAULT_ADDR"
+const metricsAddr = "METRICS_ADDR"
 
-func printVersion(namespace string) {
+func printVersion(namespaces []string) {
 	logrus.Infof("Go Version: %s", runtime.Version())
 	logrus.Infof("Go OS/Arch: %s/%s", runtime.GOOS, runtime.GOARCH)
 	logrus.Infof("operator-sdk Version: %v", sdkVersion.Version)
-	logrus.Infof("operator namespace: %s", namespace)
+	logrus.Infof("operator namespaces: %s", strings.Join(namespaces, ","))
+}
+
+// namespacesToWatch returns the namespaces listed in WATCH_NAMESPACES,
+// or the operator's own namespace if that is not set. The namespaces are of
+// the cluster the operator runs in: remote clusters can't be watched, as
+// the watches, queries and updates of the operator SDK share its single
+// in-cluster client.
+func namespacesToWatch() []string {
+	namespaces := []string{}
+	for _, ns := range strings.Split(os.Getenv(watchNamespaces), ",") {
+		if ns = strings.TrimSpace(ns); ns != "" {
+			namespaces = append(namespaces, ns)
+		}
+	}
+	if len(namespaces) == 0 {
+		namespaces = append(namespaces, os.Getenv(operatorNamespace))
+	}
+	return namespaces
+}
+
+// runWebhook starts the admission webhooks in the background if a certificate is configured for them
+func runWebhook() {
+	certFile := os.Getenv(webhookCertFile)
+	if certFile == "" {
+		return
+	}
+	addr := os.Getenv(webhookAddr)
+	if addr == "" {
+		addr = ":8443"
+	}
+	server := webhook.NewServer(addr, certFile, os.Getenv(webhookKeyFile), webhook.AgentConfig{
+		Image:   os.Getenv(agentImage),
+		Address: os.Getenv(agentVaultAddr),
+	})
+	go func() {
+		logrus.Fatalf("error serving the admission webhooks: %s", server.Run())
+	}()
+}
+
+// runMetrics serves the metrics of the operator in the background
+func runMetrics() {
+	addr := os.Getenv(metricsAddr)
+	if addr == "" {
+		addr = ":9102"
+	}
+	go func() {
+		logrus.Errorf("error serving metrics: %s", metrics.Serve(addr))
+	}()
 }
 
 func main() {
-	ns := os.Getenv(operatorNamespace)
-	printVersion(ns)
-	sdk.Watch("vault.banzaicloud.com/v1alpha1", "Vault", ns, 5)
+	namespaces := namespacesToWatch()
+	printVersion(namespaces)
+	runWebhook()
+	runMetrics()
+	for _, ns := range namespaces {
+		sdk.Watch("vault.banzaicloud.com/v1alpha1", "Vault", ns, 5)
+	}
 	sdk.Handle(stub.NewHandler())
 	sdk.Run(context.TODO())
 }
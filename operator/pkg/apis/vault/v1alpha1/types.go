@@ -1,10 +1,14 @@
 package v1alpha1
 
 import (
+	"crypto/sha256"
 	"encoding/json"
+	"fmt"
 	"reflect"
 
 	"github.com/spf13/cast"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -42,6 +46,17 @@ type VaultSpec struct {
 	ExternalConfig    map[string]interface{} `json:"externalConfig"`
 	UnsealConfig      UnsealConfig           `json:"unsealConfig"`
 	CredentialsConfig CredentialsConfig      `json:"credentialsConfig"`
+	VeleroEnabled     bool                   `json:"veleroEnabled"`
+	OpenShift         *OpenShiftConfig       `json:"openshift"`
+	PKCS11Seal        *PKCS11SealConfig      `json:"pkcs11Seal"`
+	NodeSelector      map[string]string      `json:"nodeSelector"`
+	NodeLocalUnseal   bool                   `json:"nodeLocalUnseal"`
+	Audit             *AuditConfig           `json:"audit"`
+	ExternalVault     *ExternalVaultConfig   `json:"externalVault"`
+	// RekeyPeriod is how often the operator replaces the unseal keys of Vault, never if empty
+	RekeyPeriod metav1.Duration `json:"rekeyPeriod"`
+	// RootTokenRotationPeriod is how often the operator replaces and revokes the root token of Vault, never if empty
+	RootTokenRotationPeriod metav1.Duration `json:"rootTokenRotationPeriod"`
 }
 
 // HAStorageTypes is the set of storage backends supporting High Availability
@@ -96,10 +111,28 @@ func (spec *VaultSpec) GetBankVaultsImage() string {
 	return spec.BankVaultsImage
 }
 
-// ConfigJSON returns the Config field as a JSON string
+// IsExternal returns true if Vault is managed outside of the operator, which only configures it
+func (spec *VaultSpec) IsExternal() bool {
+	return spec.ExternalVault != nil
+}
+
+// UsesServiceCA returns true if Vault's certificate is issued by the OpenShift service CA
+func (spec *VaultSpec) UsesServiceCA() bool {
+	return spec.OpenShift != nil && spec.OpenShift.ServiceCA
+}
+
+// ConfigJSON returns the Config field as a JSON string, with the seal stanza of the PKCS11Seal field added
 func (spec *VaultSpec) ConfigJSON() string {
-	config, _ := json.Marshal(spec.Config)
-	return string(config)
+	config := spec.Config
+	if spec.PKCS11Seal != nil {
+		config = map[string]interface{}{}
+		for key, value := range spec.Config {
+			config[key] = value
+		}
+		config["seal"] = map[string]interface{}{"pkcs11": spec.PKCS11Seal.SealStanza()}
+	}
+	configJSON, _ := json.Marshal(config)
+	return string(configJSON)
 }
 
 // ExternalConfigJSON returns the ExternalConfig field as a JSON string
@@ -108,9 +141,83 @@ func (spec *VaultSpec) ExternalConfigJSON() string {
 	return string(config)
 }
 
+// ExternalConfigHash returns the SHA-256 hash of the ExternalConfig field's JSON representation
+func (spec *VaultSpec) ExternalConfigHash() string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(spec.ExternalConfigJSON())))
+}
+
 // VaultStatus represents the Status field of a Vault Kubernetes object
 type VaultStatus struct {
-	Nodes []string `json:"nodes"`
+	Nodes       []string         `json:"nodes"`
+	Initialized bool             `json:"initialized"`
+	Sealed      []string         `json:"sealed"`
+	Leader      string           `json:"leader"`
+	ConfigHash  string           `json:"configHash"`
+	Conditions  []VaultCondition `json:"conditions"`
+	// LastRekeyTime is when the operator rekeyed Vault the last time
+	LastRekeyTime *metav1.Time `json:"lastRekeyTime,omitempty"`
+	// LastRootTokenRotationTime is when the operator rotated the root token of Vault the last time
+	LastRootTokenRotationTime *metav1.Time `json:"lastRootTokenRotationTime,omitempty"`
+	// LastConfiguration is the summary of the last configuration of the current external config
+	LastConfiguration *VaultConfigurationSummary `json:"lastConfiguration,omitempty"`
+}
+
+// VaultConfigurationSummary is the number of the resources of the external config by the outcome
+// of applying them, reported by the configurer
+type VaultConfigurationSummary struct {
+	Created  int    `json:"created"`
+	Updated  int    `json:"updated"`
+	Skipped  int    `json:"skipped"`
+	Deleted  int    `json:"deleted,omitempty"`
+	Failed   int    `json:"failed"`
+	Duration string `json:"duration"`
+}
+
+// VaultConditionType is the type of a VaultCondition
+type VaultConditionType string
+
+const (
+	// VaultInitialized is true when the Vault cluster has been initialized
+	VaultInitialized VaultConditionType = "Initialized"
+	// VaultUnsealed is true when every Vault node is unsealed
+	VaultUnsealed VaultConditionType = "Unsealed"
+	// VaultConfigured is true when the last configuration of the current external config succeeded
+	VaultConfigured VaultConditionType = "Configured"
+)
+
+// VaultCondition represents the state of one aspect of a Vault cluster
+type VaultCondition struct {
+	Type               VaultConditionType `json:"type"`
+	Status             v1.ConditionStatus `json:"status"`
+	LastTransitionTime metav1.Time        `json:"lastTransitionTime,omitempty"`
+	Reason             string             `json:"reason,omitempty"`
+	Message            string             `json:"message,omitempty"`
+}
+
+// GetCondition returns the condition with the given type or nil
+func (status *VaultStatus) GetCondition(conditionType VaultConditionType) *VaultCondition {
+	for i := range status.Conditions {
+		if status.Conditions[i].Type == conditionType {
+			return &status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// SetCondition adds or updates a condition, LastTransitionTime only changes if the status changes
+func (status *VaultStatus) SetCondition(condition VaultCondition) {
+	existing := status.GetCondition(condition.Type)
+	if existing == nil {
+		condition.LastTransitionTime = metav1.Now()
+		status.Conditions = append(status.Conditions, condition)
+		return
+	}
+	if existing.Status != condition.Status {
+		existing.LastTransitionTime = metav1.Now()
+	}
+	existing.Status = condition.Status
+	existing.Reason = condition.Reason
+	existing.Message = condition.Message
 }
 
 // UnsealConfig represents the UnsealConfig field of a VaultSpec Kubernetes object
@@ -120,20 +227,60 @@ type UnsealConfig struct {
 	Alibaba    *AlibabaUnsealConfig    `json:"alibaba"`
 	Azure      *AzureUnsealConfig      `json:"azure"`
 	AWS        *AWSUnsealConfig        `json:"aws"`
+	Options    UnsealOptions           `json:"options"`
+}
+
+// UnsealOptions represents the common options of all unseal backends
+type UnsealOptions struct {
+	SecretShares    int  `json:"secretShares"`
+	SecretThreshold int  `json:"secretThreshold"`
+	Events          bool `json:"events"`
+	EventsAnnotate  bool `json:"eventsAnnotate"`
+}
+
+// GetSecretShares returns the number of unseal key shares, 5 by default as in bank-vaults
+func (uso *UnsealOptions) GetSecretShares() int {
+	if uso.SecretShares == 0 {
+		return 5
+	}
+	return uso.SecretShares
+}
+
+// GetSecretThreshold returns the number of unseal key shares required to unseal Vault, 3 by default as in bank-vaults
+func (uso *UnsealOptions) GetSecretThreshold() int {
+	if uso.SecretThreshold == 0 {
+		return 3
+	}
+	return uso.SecretThreshold
+}
+
+// ToArgs returns the UnsealOptions as an argument array for bank-vaults, unset options use the bank-vaults defaults
+func (uso *UnsealOptions) ToArgs() []string {
+	args := []string{}
+	if uso.SecretShares != 0 {
+		args = append(args, "--secret-shares", fmt.Sprint(uso.SecretShares))
+	}
+	if uso.SecretThreshold != 0 {
+		args = append(args, "--secret-threshold", fmt.Sprint(uso.SecretThreshold))
+	}
+	return args
 }
 
 // ToArgs returns the UnsealConfig as and argument array for bank-vaults
 func (usc *UnsealConfig) ToArgs(vault *Vault) []string {
+	return append(usc.backendArgs(vault), usc.Options.ToArgs()...)
+}
+
+func (usc *UnsealConfig) backendArgs(vault *Vault) []string {
 	if usc.Kubernetes != nil {
-		secretNamespace := vault.Namespace
-		if usc.Kubernetes.SecretNamespace != "" {
-			secretNamespace = usc.Kubernetes.SecretNamespace
-		}
-		secretName := vault.Name + "-unseal-keys"
-		if usc.Kubernetes.SecretName != "" {
-			secretName = usc.Kubernetes.SecretName
+		return []string{
+			"--mode",
+			"k8s",
+			"--k8s-secret-namespace",
+			usc.Kubernetes.GetSecretNamespace(vault),
+			"--k8s-secret-name",
+			usc.Kubernetes.GetSecretName(vault),
 		}
-		return []string{"--mode", "k8s", "--k8s-secret-namespace", secretNamespace, "--k8s-secret-name", secretName}
 	}
 	if usc.Google != nil {
 		return []string{
@@ -195,6 +342,22 @@ type KubernetesUnsealConfig struct {
 	SecretName      string `json:"secretName"`
 }
 
+// GetSecretNamespace returns the namespace of the Secret holding the unseal keys
+func (kusc *KubernetesUnsealConfig) GetSecretNamespace(vault *Vault) string {
+	if kusc.SecretNamespace == "" {
+		return vault.Namespace
+	}
+	return kusc.SecretNamespace
+}
+
+// GetSecretName returns the name of the Secret holding the unseal keys
+func (kusc *KubernetesUnsealConfig) GetSecretName(vault *Vault) string {
+	if kusc.SecretName == "" {
+		return vault.Name + "-unseal-keys"
+	}
+	return kusc.SecretName
+}
+
 // GoogleUnsealConfig holds the parameters for Google KMS based unsealing
 type GoogleUnsealConfig struct {
 	KMSKeyRing    string `json:"kmsKeyRing"`
@@ -234,3 +397,106 @@ type CredentialsConfig struct {
 	Path       string `json:"path"`
 	SecretName string `json:"secretName"`
 }
+
+// OpenShiftConfig holds the OpenShift specific settings of a Vault cluster
+type OpenShiftConfig struct {
+	// Route exposes the Vault API and UI through an OpenShift Route
+	Route bool `json:"route"`
+	// RouteHost is the host of the Route, generated by OpenShift if empty
+	RouteHost string `json:"routeHost"`
+	// ServiceCA makes Vault use a certificate issued by the OpenShift service CA
+	ServiceCA bool `json:"serviceCA"`
+}
+
+// ExternalVaultConfig describes an existing Vault (e.g. HCP Vault or a cluster managed by another team),
+// the operator doesn't deploy, initialize or unseal it, only applies the external configuration to it
+type ExternalVaultConfig struct {
+	// Address is the API address of Vault, e.g. https://vault.example.com:8200
+	Address string `json:"address"`
+	// CASecret is the name of a Secret holding the CA certificate of Vault in its "ca.crt" key,
+	// the system CAs are used if empty
+	CASecret string `json:"caSecret"`
+	// TokenSecret is the name of a Secret holding the token to configure Vault with in its "token" key
+	TokenSecret string `json:"tokenSecret"`
+	// AuthRole logs the configurer in with the Kubernetes auth method of Vault with the given role,
+	// instead of using a token from a Secret
+	AuthRole string `json:"authRole"`
+	// AuthPath is the mount path of the Kubernetes auth method, "kubernetes" by default
+	AuthPath string `json:"authPath"`
+}
+
+// AuthMethod returns the bank-vaults configure auth method to use
+func (evc *ExternalVaultConfig) AuthMethod() string {
+	if evc.TokenSecret != "" {
+		return "token"
+	}
+	return "kubernetes"
+}
+
+// GetAuthPath returns the mount path of the Kubernetes auth method
+func (evc *ExternalVaultConfig) GetAuthPath() string {
+	if evc.AuthPath == "" {
+		return "kubernetes"
+	}
+	return evc.AuthPath
+}
+
+// ToArgs returns the bank-vaults configure arguments to authenticate to the external Vault
+func (evc *ExternalVaultConfig) ToArgs() []string {
+	args := []string{"--auth-method", evc.AuthMethod()}
+	if evc.AuthMethod() == "kubernetes" {
+		args = append(args, "--auth-role", evc.AuthRole, "--auth-path", evc.GetAuthPath())
+	}
+	return args
+}
+
+// PKCS11SealConfig describes a PKCS#11 (HSM) seal of Vault, see:
+// https://www.vaultproject.io/docs/configuration/seal/pkcs11.html
+type PKCS11SealConfig struct {
+	// Lib is the path of the PKCS#11 library in the Vault container
+	Lib string `json:"lib"`
+	// LibHostPath is a directory on the host mounted to /vault/hsm, for libraries not present in the Vault image
+	LibHostPath string `json:"libHostPath"`
+	// Slot is the slot number of the HSM to use
+	Slot string `json:"slot"`
+	// PinSecret is the name of the Secret holding the PIN in its "pin" key
+	PinSecret string `json:"pinSecret"`
+	// KeyLabel and HMACKeyLabel are the labels of the keys to use in the HSM
+	KeyLabel     string `json:"keyLabel"`
+	HMACKeyLabel string `json:"hmacKeyLabel"`
+	// GenerateKey generates the keys in the HSM if they don't exist
+	GenerateKey bool `json:"generateKey"`
+	// Resources requests HSM devices exposed by a device plugin, e.g. "vendor.com/hsm: 1"
+	Resources v1.ResourceList `json:"resources"`
+}
+
+// SealStanza returns the pkcs11 seal stanza of the Vault configuration, the PIN is passed in the VAULT_HSM_PIN environment variable
+func (pkcs11 *PKCS11SealConfig) SealStanza() map[string]interface{} {
+	stanza := map[string]interface{}{
+		"lib":            pkcs11.Lib,
+		"slot":           pkcs11.Slot,
+		"key_label":      pkcs11.KeyLabel,
+		"hmac_key_label": pkcs11.HMACKeyLabel,
+	}
+	if pkcs11.GenerateKey {
+		stanza["generate_key"] = "true"
+	}
+	return stanza
+}
+
+// AuditConfig describes the storage and shipping of the file audit device logs of Vault,
+// the file audit device has to write to /vault/logs/audit.log
+type AuditConfig struct {
+	// Storage puts the audit logs on a dedicated PersistentVolumeClaim instead of an emptyDir volume
+	Storage *AuditStorageConfig `json:"storage"`
+	// Stdout adds a sidecar container streaming the audit log to its standard output
+	Stdout bool `json:"stdout"`
+	// Shipper is a log shipping sidecar container, the audit log volume is mounted into it at /vault/logs
+	Shipper *v1.Container `json:"shipper"`
+}
+
+// AuditStorageConfig describes the PersistentVolumeClaim of the audit logs of each Vault pod
+type AuditStorageConfig struct {
+	Size             resource.Quantity `json:"size"`
+	StorageClassName *string           `json:"storageClassName"`
+}
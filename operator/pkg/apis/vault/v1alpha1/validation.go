@@ -0,0 +1,171 @@
+package v1alpha1
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// Validate checks the Vault object for errors which would break the reconciliation
+func (v *Vault) Validate() error {
+	allErrs := v.Spec.validate(field.NewPath("spec"))
+	return allErrs.ToAggregate()
+}
+
+func (spec *VaultSpec) validate(fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	// Only the external configuration is used for externally managed Vault instances
+	if spec.ExternalVault != nil {
+		allErrs = append(allErrs, spec.ExternalVault.validate(fldPath.Child("externalVault"))...)
+		allErrs = append(allErrs, validateExternalConfig(spec.ExternalConfig, fldPath.Child("externalConfig"))...)
+		return allErrs
+	}
+
+	if spec.Size < 1 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("size"), spec.Size, "must be at least 1"))
+	}
+
+	configPath := fldPath.Child("config")
+	storage := spec.getStorage()
+	if len(storage) != 1 {
+		allErrs = append(allErrs, field.Invalid(configPath.Child("storage"), len(storage), "exactly one storage backend has to be configured"))
+	} else if spec.Size > 1 && !spec.HasHAStorage() {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("size"), spec.Size, "more than 1 node requires a storage backend with High Availability enabled"))
+	}
+	if _, ok := spec.Config["listener"]; !ok {
+		allErrs = append(allErrs, field.Required(configPath.Child("listener"), ""))
+	}
+
+	if spec.PKCS11Seal != nil {
+		if _, ok := spec.Config["seal"]; ok {
+			allErrs = append(allErrs, field.Forbidden(configPath.Child("seal"), "the seal stanza is generated from pkcs11Seal"))
+		}
+		allErrs = append(allErrs, spec.PKCS11Seal.validate(fldPath.Child("pkcs11Seal"))...)
+	}
+
+	allErrs = append(allErrs, spec.UnsealConfig.validate(fldPath.Child("unsealConfig"))...)
+
+	// The operator has access to the stored keys only if they are in a Kubernetes Secret
+	for name, period := range map[string]metav1.Duration{
+		"rekeyPeriod":             spec.RekeyPeriod,
+		"rootTokenRotationPeriod": spec.RootTokenRotationPeriod,
+	} {
+		if period.Duration < 0 {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child(name), period.Duration.String(), "must not be negative"))
+		} else if period.Duration > 0 && spec.UnsealConfig.Kubernetes == nil {
+			allErrs = append(allErrs, field.Forbidden(fldPath.Child(name), "only supported with the kubernetes unseal config"))
+		}
+	}
+	allErrs = append(allErrs, validateExternalConfig(spec.ExternalConfig, fldPath.Child("externalConfig"))...)
+
+	return allErrs
+}
+
+func (usc *UnsealConfig) validate(fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	required := func(backend string, fields map[string]string) {
+		for name, value := range fields {
+			if value == "" {
+				allErrs = append(allErrs, field.Required(fldPath.Child(backend, name), ""))
+			}
+		}
+	}
+
+	backends := 0
+	if usc.Kubernetes != nil {
+		backends++
+	}
+	if usc.Google != nil {
+		backends++
+		required("google", map[string]string{
+			"kmsKeyRing":    usc.Google.KMSKeyRing,
+			"kmsCryptoKey":  usc.Google.KMSCryptoKey,
+			"kmsLocation":   usc.Google.KMSLocation,
+			"kmsProject":    usc.Google.KMSProject,
+			"storageBucket": usc.Google.StorageBucket,
+		})
+	}
+	if usc.Alibaba != nil {
+		backends++
+		required("alibaba", map[string]string{
+			"kmsRegion":   usc.Alibaba.KMSRegion,
+			"kmsKeyId":    usc.Alibaba.KMSKeyID,
+			"ossEndpoint": usc.Alibaba.OSSEndpoint,
+			"ossBucket":   usc.Alibaba.OSSBucket,
+		})
+	}
+	if usc.Azure != nil {
+		backends++
+		required("azure", map[string]string{
+			"keyVaultName": usc.Azure.KeyVaultName,
+		})
+	}
+	if usc.AWS != nil {
+		backends++
+		required("aws", map[string]string{
+			"kmsKeyId":  usc.AWS.KMSKeyID,
+			"kmsRegion": usc.AWS.KMSRegion,
+			"s3Bucket":  usc.AWS.S3Bucket,
+			"s3Region":  usc.AWS.S3Region,
+		})
+	}
+	if backends != 1 {
+		allErrs = append(allErrs, field.Invalid(fldPath, backends, "exactly one unseal backend has to be configured"))
+	}
+
+	shares, threshold := usc.Options.GetSecretShares(), usc.Options.GetSecretThreshold()
+	optionsPath := fldPath.Child("options")
+	if shares < 1 {
+		allErrs = append(allErrs, field.Invalid(optionsPath.Child("secretShares"), shares, "must be at least 1"))
+	}
+	if threshold < 1 || threshold > shares {
+		allErrs = append(allErrs, field.Invalid(optionsPath.Child("secretThreshold"), threshold, fmt.Sprintf("must be between 1 and the number of secret shares (%d)", shares)))
+	}
+
+	return allErrs
+}
+
+func (pkcs11 *PKCS11SealConfig) validate(fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	for name, value := range map[string]string{
+		"lib":       pkcs11.Lib,
+		"slot":      pkcs11.Slot,
+		"pinSecret": pkcs11.PinSecret,
+		"keyLabel":  pkcs11.KeyLabel,
+	} {
+		if value == "" {
+			allErrs = append(allErrs, field.Required(fldPath.Child(name), ""))
+		}
+	}
+	return allErrs
+}
+
+func (evc *ExternalVaultConfig) validate(fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if evc.Address == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("address"), ""))
+	} else if u, err := url.Parse(evc.Address); err != nil || u.Scheme == "" || u.Host == "" {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("address"), evc.Address, "must be an absolute URL"))
+	}
+	if (evc.TokenSecret == "") == (evc.AuthRole == "") {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("authRole"), evc.AuthRole, "exactly one of tokenSecret and authRole has to be set"))
+	}
+	return allErrs
+}
+
+func validateExternalConfig(config map[string]interface{}, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	for _, err := range vault.VerifyConfig(config) {
+		if err.Value == nil {
+			allErrs = append(allErrs, field.Required(fldPath.Child(err.Path), err.Message))
+		} else {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child(err.Path), err.Value, err.Message))
+		}
+	}
+	return allErrs
+}
@@ -5,6 +5,7 @@
 package v1alpha1
 
 import (
+	v1 "k8s.io/api/core/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
@@ -40,6 +41,54 @@ func (in *AlibabaUnsealConfig) DeepCopy() *AlibabaUnsealConfig {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AuditConfig) DeepCopyInto(out *AuditConfig) {
+	*out = *in
+	if in.Storage != nil {
+		in, out := &in.Storage, &out.Storage
+		*out = new(AuditStorageConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Shipper != nil {
+		in, out := &in.Shipper, &out.Shipper
+		*out = new(v1.Container)
+		(*in).DeepCopyInto(*out)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AuditConfig.
+func (in *AuditConfig) DeepCopy() *AuditConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(AuditConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AuditStorageConfig) DeepCopyInto(out *AuditStorageConfig) {
+	*out = *in
+	out.Size = in.Size.DeepCopy()
+	if in.StorageClassName != nil {
+		in, out := &in.StorageClassName, &out.StorageClassName
+		*out = new(string)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AuditStorageConfig.
+func (in *AuditStorageConfig) DeepCopy() *AuditStorageConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(AuditStorageConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *AzureUnsealConfig) DeepCopyInto(out *AzureUnsealConfig) {
 	*out = *in
@@ -72,6 +121,22 @@ func (in *CredentialsConfig) DeepCopy() *CredentialsConfig {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ExternalVaultConfig) DeepCopyInto(out *ExternalVaultConfig) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ExternalVaultConfig.
+func (in *ExternalVaultConfig) DeepCopy() *ExternalVaultConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(ExternalVaultConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *GoogleUnsealConfig) DeepCopyInto(out *GoogleUnsealConfig) {
 	*out = *in
@@ -104,6 +169,45 @@ func (in *KubernetesUnsealConfig) DeepCopy() *KubernetesUnsealConfig {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OpenShiftConfig) DeepCopyInto(out *OpenShiftConfig) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OpenShiftConfig.
+func (in *OpenShiftConfig) DeepCopy() *OpenShiftConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(OpenShiftConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PKCS11SealConfig) DeepCopyInto(out *PKCS11SealConfig) {
+	*out = *in
+	if in.Resources != nil {
+		in, out := &in.Resources, &out.Resources
+		*out = make(v1.ResourceList, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val.DeepCopy()
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PKCS11SealConfig.
+func (in *PKCS11SealConfig) DeepCopy() *PKCS11SealConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(PKCS11SealConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *UnsealConfig) DeepCopyInto(out *UnsealConfig) {
 	*out = *in
@@ -132,6 +236,7 @@ func (in *UnsealConfig) DeepCopyInto(out *UnsealConfig) {
 		*out = new(AWSUnsealConfig)
 		**out = **in
 	}
+	out.Options = in.Options
 	return
 }
 
@@ -145,6 +250,22 @@ func (in *UnsealConfig) DeepCopy() *UnsealConfig {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *UnsealOptions) DeepCopyInto(out *UnsealOptions) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new UnsealOptions.
+func (in *UnsealOptions) DeepCopy() *UnsealOptions {
+	if in == nil {
+		return nil
+	}
+	out := new(UnsealOptions)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Vault) DeepCopyInto(out *Vault) {
 	*out = *in
@@ -173,6 +294,39 @@ func (in *Vault) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VaultCondition) DeepCopyInto(out *VaultCondition) {
+	*out = *in
+	in.LastTransitionTime.DeepCopyInto(&out.LastTransitionTime)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VaultCondition.
+func (in *VaultCondition) DeepCopy() *VaultCondition {
+	if in == nil {
+		return nil
+	}
+	out := new(VaultCondition)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VaultConfigurationSummary) DeepCopyInto(out *VaultConfigurationSummary) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VaultConfigurationSummary.
+func (in *VaultConfigurationSummary) DeepCopy() *VaultConfigurationSummary {
+	if in == nil {
+		return nil
+	}
+	out := new(VaultConfigurationSummary)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *VaultList) DeepCopyInto(out *VaultList) {
 	*out = *in
@@ -233,6 +387,35 @@ func (in *VaultSpec) DeepCopyInto(out *VaultSpec) {
 	}
 	in.UnsealConfig.DeepCopyInto(&out.UnsealConfig)
 	out.CredentialsConfig = in.CredentialsConfig
+	if in.OpenShift != nil {
+		in, out := &in.OpenShift, &out.OpenShift
+		*out = new(OpenShiftConfig)
+		**out = **in
+	}
+	if in.PKCS11Seal != nil {
+		in, out := &in.PKCS11Seal, &out.PKCS11Seal
+		*out = new(PKCS11SealConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.NodeSelector != nil {
+		in, out := &in.NodeSelector, &out.NodeSelector
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Audit != nil {
+		in, out := &in.Audit, &out.Audit
+		*out = new(AuditConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ExternalVault != nil {
+		in, out := &in.ExternalVault, &out.ExternalVault
+		*out = new(ExternalVaultConfig)
+		**out = **in
+	}
+	out.RekeyPeriod = in.RekeyPeriod
+	out.RootTokenRotationPeriod = in.RootTokenRotationPeriod
 	return
 }
 
@@ -254,6 +437,31 @@ func (in *VaultStatus) DeepCopyInto(out *VaultStatus) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.Sealed != nil {
+		in, out := &in.Sealed, &out.Sealed
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]VaultCondition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.LastRekeyTime != nil {
+		in, out := &in.LastRekeyTime, &out.LastRekeyTime
+		*out = (*in).DeepCopy()
+	}
+	if in.LastRootTokenRotationTime != nil {
+		in, out := &in.LastRootTokenRotationTime, &out.LastRootTokenRotationTime
+		*out = (*in).DeepCopy()
+	}
+	if in.LastConfiguration != nil {
+		in, out := &in.LastConfiguration, &out.LastConfiguration
+		*out = new(VaultConfigurationSummary)
+		**out = **in
+	}
 	return
 }
 
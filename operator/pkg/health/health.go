@@ -0,0 +1,68 @@
+// Package health collects the state of the Vault nodes of a Vault CR from their health endpoints
+package health
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/banzaicloud/bank-vaults/operator/pkg/apis/vault/v1alpha1"
+	"github.com/hashicorp/vault/api"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+)
+
+// Func returns the health of a Vault pod
+type Func func(pod *v1.Pod) (*api.HealthResponse, error)
+
+// SetNodeStatus sets the sealed nodes, the leader and the Initialized and Unsealed conditions of the
+// status from the health of the pods. The pods whose health is unknown (e.g. they are down) aren't
+// counted as unsealed, the Unsealed condition is Unknown with them if no pod is known to be sealed.
+func SetNodeStatus(status *v1alpha1.VaultStatus, pods []v1.Pod, health Func) {
+	var unreachable []string
+	for i := range pods {
+		pod := &pods[i]
+		if pod.Status.PodIP == "" {
+			unreachable = append(unreachable, pod.Name)
+			continue
+		}
+		h, err := health(pod)
+		if err != nil {
+			logrus.Debugf("failed to get health of vault pod %s: %v", pod.Name, err)
+			unreachable = append(unreachable, pod.Name)
+			continue
+		}
+		if h.Initialized {
+			status.Initialized = true
+		}
+		if h.Sealed {
+			status.Sealed = append(status.Sealed, pod.Name)
+		} else if !h.Standby {
+			status.Leader = pod.Name
+		}
+	}
+
+	if status.Initialized {
+		status.SetCondition(v1alpha1.VaultCondition{Type: v1alpha1.VaultInitialized, Status: v1.ConditionTrue})
+	} else {
+		status.SetCondition(v1alpha1.VaultCondition{Type: v1alpha1.VaultInitialized, Status: v1.ConditionFalse, Reason: "NotInitialized"})
+	}
+
+	switch {
+	case len(status.Sealed) > 0 || len(pods) == 0:
+		status.SetCondition(v1alpha1.VaultCondition{
+			Type:    v1alpha1.VaultUnsealed,
+			Status:  v1.ConditionFalse,
+			Reason:  "Sealed",
+			Message: fmt.Sprintf("sealed nodes: %s", strings.Join(status.Sealed, ",")),
+		})
+	case len(unreachable) > 0:
+		status.SetCondition(v1alpha1.VaultCondition{
+			Type:    v1alpha1.VaultUnsealed,
+			Status:  v1.ConditionUnknown,
+			Reason:  "Unreachable",
+			Message: fmt.Sprintf("unreachable nodes: %s", strings.Join(unreachable, ",")),
+		})
+	default:
+		status.SetCondition(v1alpha1.VaultCondition{Type: v1alpha1.VaultUnsealed, Status: v1.ConditionTrue})
+	}
+}
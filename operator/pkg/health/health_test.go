@@ -0,0 +1,90 @@
+package health
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/banzaicloud/bank-vaults/operator/pkg/apis/vault/v1alpha1"
+	"github.com/hashicorp/vault/api"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSetNodeStatus(t *testing.T) {
+	pod := func(name, ip string) v1.Pod {
+		return v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name}, Status: v1.PodStatus{PodIP: ip}}
+	}
+	// health is a fake of the health of the pods by their names, the missing ones fail
+	health := func(healths map[string]*api.HealthResponse) Func {
+		return func(pod *v1.Pod) (*api.HealthResponse, error) {
+			if h, ok := healths[pod.Name]; ok {
+				return h, nil
+			}
+			return nil, fmt.Errorf("connection refused")
+		}
+	}
+	active := &api.HealthResponse{Initialized: true}
+	standby := &api.HealthResponse{Initialized: true, Standby: true}
+	sealed := &api.HealthResponse{Initialized: true, Sealed: true}
+
+	tests := []struct {
+		name     string
+		pods     []v1.Pod
+		healths  map[string]*api.HealthResponse
+		unsealed v1.ConditionStatus
+		reason   string
+		leader   string
+	}{{
+		name:     "unsealed",
+		pods:     []v1.Pod{pod("vault-0", "10.0.0.1"), pod("vault-1", "10.0.0.2")},
+		healths:  map[string]*api.HealthResponse{"vault-0": active, "vault-1": standby},
+		unsealed: v1.ConditionTrue,
+		leader:   "vault-0",
+	}, {
+		name:     "sealed",
+		pods:     []v1.Pod{pod("vault-0", "10.0.0.1"), pod("vault-1", "10.0.0.2")},
+		healths:  map[string]*api.HealthResponse{"vault-0": active, "vault-1": sealed},
+		unsealed: v1.ConditionFalse,
+		reason:   "Sealed",
+		leader:   "vault-0",
+	}, {
+		name:     "health check failing",
+		pods:     []v1.Pod{pod("vault-0", "10.0.0.1"), pod("vault-1", "10.0.0.2")},
+		healths:  map[string]*api.HealthResponse{"vault-0": active},
+		unsealed: v1.ConditionUnknown,
+		reason:   "Unreachable",
+		leader:   "vault-0",
+	}, {
+		name:     "pod without ip",
+		pods:     []v1.Pod{pod("vault-0", "10.0.0.1"), pod("vault-1", "")},
+		healths:  map[string]*api.HealthResponse{"vault-0": active, "vault-1": active},
+		unsealed: v1.ConditionUnknown,
+		reason:   "Unreachable",
+		leader:   "vault-0",
+	}, {
+		name:     "sealed and unreachable",
+		pods:     []v1.Pod{pod("vault-0", "10.0.0.1"), pod("vault-1", "10.0.0.2")},
+		healths:  map[string]*api.HealthResponse{"vault-0": sealed},
+		unsealed: v1.ConditionFalse,
+		reason:   "Sealed",
+	}, {
+		name:     "no pods",
+		unsealed: v1.ConditionFalse,
+		reason:   "Sealed",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			status := &v1alpha1.VaultStatus{}
+			SetNodeStatus(status, test.pods, health(test.healths))
+
+			condition := status.GetCondition(v1alpha1.VaultUnsealed)
+			if condition == nil || condition.Status != test.unsealed || condition.Reason != test.reason {
+				t.Errorf("unexpected Unsealed condition: %+v", condition)
+			}
+			if status.Leader != test.leader {
+				t.Errorf("unexpected leader: %q", status.Leader)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package stub
+
+import (
+	"github.com/banzaicloud/bank-vaults/operator/pkg/apis/vault/v1alpha1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// auditLogDir is where the audit log volume is mounted in the Vault and the sidecar containers,
+// the entrypoint of the Vault image makes it writable for Vault
+const auditLogDir = "/vault/logs"
+
+// auditLogFile is the file the stdout sidecar streams, the file audit device should write to it
+const auditLogFile = auditLogDir + "/audit.log"
+
+func withAuditVolume(v *v1alpha1.Vault, volumes []v1.Volume) []v1.Volume {
+	// The volume of the PersistentVolumeClaim comes from the volumeClaimTemplates
+	if v.Spec.Audit == nil || v.Spec.Audit.Storage != nil {
+		return volumes
+	}
+	return append(volumes, v1.Volume{
+		Name: "vault-audit",
+		VolumeSource: v1.VolumeSource{
+			EmptyDir: &v1.EmptyDirVolumeSource{},
+		},
+	})
+}
+
+func withAuditVolumeMount(v *v1alpha1.Vault, volumeMounts []v1.VolumeMount) []v1.VolumeMount {
+	if v.Spec.Audit == nil {
+		return volumeMounts
+	}
+	return append(volumeMounts, v1.VolumeMount{
+		Name:      "vault-audit",
+		MountPath: auditLogDir,
+	})
+}
+
+// volumeClaimTemplatesForVault returns a dedicated PersistentVolumeClaim for the audit logs of each Vault pod
+func volumeClaimTemplatesForVault(v *v1alpha1.Vault) []v1.PersistentVolumeClaim {
+	if v.Spec.Audit == nil || v.Spec.Audit.Storage == nil {
+		return nil
+	}
+	return []v1.PersistentVolumeClaim{{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "vault-audit",
+			Labels: labelsForVault(v.Name),
+		},
+		Spec: v1.PersistentVolumeClaimSpec{
+			AccessModes:      []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+			StorageClassName: v.Spec.Audit.Storage.StorageClassName,
+			Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{
+					v1.ResourceStorage: v.Spec.Audit.Storage.Size,
+				},
+			},
+		},
+	}}
+}
+
+// withAuditContainers adds the audit log stdout and shipper sidecars to the Vault pod
+func withAuditContainers(v *v1alpha1.Vault, containers []v1.Container) []v1.Container {
+	if v.Spec.Audit == nil {
+		return containers
+	}
+
+	auditMount := withAuditVolumeMount(v, nil)
+
+	if v.Spec.Audit.Stdout {
+		// The Vault image has the tools needed, no need to pull another one
+		containers = append(containers, v1.Container{
+			Image:           v.Spec.Image,
+			ImagePullPolicy: v1.PullIfNotPresent,
+			Name:            "audit-stdout",
+			Command:         []string{"tail", "-n+1", "-F", auditLogFile},
+			VolumeMounts:    auditMount,
+		})
+	}
+
+	if v.Spec.Audit.Shipper != nil {
+		shipper := v.Spec.Audit.Shipper.DeepCopy()
+		if shipper.Name == "" {
+			shipper.Name = "audit-shipper"
+		}
+		shipper.VolumeMounts = append(shipper.VolumeMounts, auditMount...)
+		containers = append(containers, *shipper)
+	}
+
+	return containers
+}
@@ -0,0 +1,43 @@
+package stub
+
+import (
+	"github.com/banzaicloud/bank-vaults/operator/pkg/apis/vault/v1alpha1"
+	"github.com/operator-framework/operator-sdk/pkg/sdk/action"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// recordVaultEvent emits a Kubernetes Event about an action of the operator against a Vault CR
+func recordVaultEvent(v *v1alpha1.Vault, eventType, reason, message string) {
+	now := metav1.Now()
+	event := &v1.Event{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "Event",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: v.Name + ".",
+			Namespace:    v.Namespace,
+		},
+		InvolvedObject: v1.ObjectReference{
+			APIVersion: v.APIVersion,
+			Kind:       v.Kind,
+			Namespace:  v.Namespace,
+			Name:       v.Name,
+			UID:        v.UID,
+		},
+		Reason:         reason,
+		Message:        message,
+		Type:           eventType,
+		Source:         v1.EventSource{Component: "vault-operator"},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+
+	err := action.Create(event)
+	if err != nil {
+		logrus.Errorf("failed to create %s event for vault %s: %v", reason, v.Name, err)
+	}
+}
@@ -0,0 +1,115 @@
+package stub
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/banzaicloud/bank-vaults/operator/pkg/apis/vault/v1alpha1"
+	"github.com/hashicorp/vault/api"
+	"github.com/operator-framework/operator-sdk/pkg/sdk/action"
+	"k8s.io/api/core/v1"
+)
+
+// configurerArgsForVault returns the arguments of bank-vaults configure, an external Vault
+// is configured with the token of an auth method instead of the root token from the key store
+func configurerArgsForVault(v *v1alpha1.Vault) []string {
+	if v.Spec.IsExternal() {
+		return v.Spec.ExternalVault.ToArgs()
+	}
+	return v.Spec.UnsealConfig.ToArgs(v)
+}
+
+// vaultAddressForConfigurer returns the address the configurer reaches Vault at
+func vaultAddressForConfigurer(v *v1alpha1.Vault) string {
+	if v.Spec.IsExternal() {
+		return v.Spec.ExternalVault.Address
+	}
+	return fmt.Sprintf("https://%s:8200", serverNameForVault(v))
+}
+
+// vaultTLSSecretForConfigurer returns the Secret holding the CA certificate of Vault,
+// or an empty string if the system CAs have to be used
+func vaultTLSSecretForConfigurer(v *v1alpha1.Vault) string {
+	if v.Spec.IsExternal() {
+		return v.Spec.ExternalVault.CASecret
+	}
+	return v.Name + "-tls"
+}
+
+func withVaultTLSEnv(v *v1alpha1.Vault, envs []v1.EnvVar) []v1.EnvVar {
+	if vaultTLSSecretForConfigurer(v) == "" {
+		return envs
+	}
+	caCertPath := caCertPathForVault(v)
+	if v.Spec.IsExternal() {
+		caCertPath = "/vault/tls/ca.crt"
+	}
+	return append(envs, v1.EnvVar{
+		Name:  api.EnvVaultCACert,
+		Value: caCertPath,
+	})
+}
+
+func withVaultTLSVolume(v *v1alpha1.Vault, volumes []v1.Volume) []v1.Volume {
+	secretName := vaultTLSSecretForConfigurer(v)
+	if secretName == "" {
+		return volumes
+	}
+	return append(volumes, v1.Volume{
+		Name: "vault-tls",
+		VolumeSource: v1.VolumeSource{
+			Secret: &v1.SecretVolumeSource{
+				SecretName: secretName,
+			},
+		},
+	})
+}
+
+func withVaultTLSVolumeMount(v *v1alpha1.Vault, volumeMounts []v1.VolumeMount) []v1.VolumeMount {
+	if vaultTLSSecretForConfigurer(v) == "" {
+		return volumeMounts
+	}
+	return append(volumeMounts, v1.VolumeMount{
+		Name:      "vault-tls",
+		MountPath: "/vault/tls",
+	})
+}
+
+// withVaultTokenEnv passes the token of an external Vault from its Secret in VAULT_TOKEN
+func withVaultTokenEnv(v *v1alpha1.Vault, envs []v1.EnvVar) []v1.EnvVar {
+	if !v.Spec.IsExternal() || v.Spec.ExternalVault.TokenSecret == "" {
+		return envs
+	}
+	return append(envs, v1.EnvVar{
+		Name: api.EnvVaultToken,
+		ValueFrom: &v1.EnvVarSource{
+			SecretKeyRef: &v1.SecretKeySelector{
+				LocalObjectReference: v1.LocalObjectReference{Name: v.Spec.ExternalVault.TokenSecret},
+				Key:                  "token",
+			},
+		},
+	})
+}
+
+// updateExternalStatus updates the status of an external Vault, only its configuration is tracked
+func updateExternalStatus(v *v1alpha1.Vault) error {
+	status := v.Status.DeepCopy()
+	status.ConfigHash = v.Spec.ExternalConfigHash()
+
+	configured, summary, err := configuredConditionForVault(v, status.ConfigHash)
+	if err != nil {
+		return err
+	}
+	status.SetCondition(configured)
+	status.LastConfiguration = summary
+
+	if !reflect.DeepEqual(*status, v.Status) {
+		v.Status = *status
+		err := action.Update(v)
+		if err != nil {
+			return fmt.Errorf("failed to update vault status: %v", err)
+		}
+	}
+
+	return nil
+}
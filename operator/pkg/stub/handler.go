@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"net/url"
 	"path/filepath"
-	"reflect"
 	"strings"
 
 	"github.com/banzaicloud/bank-vaults/operator/pkg/apis/vault/v1alpha1"
@@ -50,6 +49,20 @@ func (h *Handler) Handle(ctx types.Context, event types.Event) error {
 			return nil
 		}
 
+		// Don't reconcile invalid CRs which got past the (optional) validating webhook
+		if err := v.Validate(); err != nil {
+			return fmt.Errorf("invalid vault %s/%s: %v", v.Namespace, v.Name, err)
+		}
+
+		// Externally managed Vault instances are only configured
+		if v.Spec.IsExternal() {
+			err := reconcileConfigurer(v)
+			if err != nil {
+				return err
+			}
+			return updateExternalStatus(v)
+		}
+
 		// check if we need to create an etcd cluster
 		if v.Spec.GetStorageType() == "etcd" {
 
@@ -77,17 +90,19 @@ func (h *Handler) Handle(ctx types.Context, event types.Event) error {
 			}
 		}
 
-		// Create the secret if it doesn't exist
-		sec, err := secretForVault(v)
-		if err != nil {
-			return fmt.Errorf("failed to fabricate secret for vault: %v", err)
-		}
+		// Create the secret if it doesn't exist, the OpenShift service CA creates it otherwise
+		if !v.Spec.UsesServiceCA() {
+			sec, err := secretForVault(v)
+			if err != nil {
+				return fmt.Errorf("failed to fabricate secret for vault: %v", err)
+			}
 
-		addOwnerRefToObject(sec, asOwner(v))
+			addOwnerRefToObject(sec, asOwner(v))
 
-		err = action.Create(sec)
-		if err != nil && !apierrors.IsAlreadyExists(err) {
-			return fmt.Errorf("failed to create secret for vault: %v", err)
+			err = action.Create(sec)
+			if err != nil && !apierrors.IsAlreadyExists(err) {
+				return fmt.Errorf("failed to create secret for vault: %v", err)
+			}
 		}
 
 		// Create the StatefulSet if it doesn't exist
@@ -116,7 +131,7 @@ func (h *Handler) Handle(ctx types.Context, event types.Event) error {
 			}
 		}
 
-		// Update the Vault status with the pod names
+		// Update the Vault status with the pod names and their states
 		podList := podList()
 		labelSelector := labels.SelectorFromSet(labelsForVault(v.Name)).String()
 		listOps := &metav1.ListOptions{LabelSelector: labelSelector}
@@ -124,13 +139,21 @@ func (h *Handler) Handle(ctx types.Context, event types.Event) error {
 		if err != nil {
 			return fmt.Errorf("failed to list pods: %v", err)
 		}
-		podNames := getPodNames(podList.Items)
-		if !reflect.DeepEqual(podNames, v.Status.Nodes) {
-			v.Status.Nodes = podNames
-			err := action.Update(v)
-			if err != nil {
-				return fmt.Errorf("failed to update vault status: %v", err)
-			}
+		err = updateStatus(v, podList.Items)
+		if err != nil {
+			return err
+		}
+
+		// Rekey Vault and rotate its root token periodically if requested
+		err = rotateVaultKeys(v, podList.Items)
+		if err != nil {
+			return err
+		}
+
+		// Upgrade the outdated pods of HA clusters one by one
+		err = upgradeVault(v, statefulSet, podList.Items)
+		if err != nil {
+			return fmt.Errorf("failed to upgrade vault: %v", err)
 		}
 
 		// Create the service if it doesn't exist
@@ -140,36 +163,85 @@ func (h *Handler) Handle(ctx types.Context, event types.Event) error {
 			return fmt.Errorf("failed to create service: %v", err)
 		}
 
-		// Create the deployment if it doesn't exist
-		configurerDep := deploymentForConfigurer(v)
-		err = action.Create(configurerDep)
-		if err != nil && !apierrors.IsAlreadyExists(err) {
-			return fmt.Errorf("failed to create configurer deployment: %v", err)
+		// Create the OpenShift route if requested and it doesn't exist
+		if v.Spec.OpenShift != nil && v.Spec.OpenShift.Route {
+			route := routeForVault(v)
+			err = action.Create(route)
+			if err != nil && !apierrors.IsAlreadyExists(err) {
+				return fmt.Errorf("failed to create route: %v", err)
+			}
 		}
-		logDeployment(configurerDep)
 
-		// Create the configmap if it doesn't exist
-		cm := configMapForConfigurer(v)
-		err = action.Create(cm)
-		if err != nil && !apierrors.IsAlreadyExists(err) {
-			return fmt.Errorf("failed to create configurer configmap: %v", err)
+		// Create the node local unsealer DaemonSet if requested, or update it to the spec
+		if v.Spec.NodeLocalUnseal {
+			unsealerDs, err := daemonSetForUnsealer(v)
+			if err != nil {
+				return fmt.Errorf("failed to fabricate unsealer daemonset: %v", err)
+			}
+			err = action.Create(unsealerDs)
+			if apierrors.IsAlreadyExists(err) {
+				newUnsealerDs := unsealerDs.DeepCopy()
+				if err := query.Get(unsealerDs); err != nil {
+					return fmt.Errorf("failed to get unsealer daemonset: %v", err)
+				}
+				unsealerDs.Spec = newUnsealerDs.Spec
+				err = action.Update(unsealerDs)
+				if err != nil {
+					return fmt.Errorf("failed to update unsealer daemonset: %v", err)
+				}
+			} else if err != nil {
+				return fmt.Errorf("failed to create unsealer daemonset: %v", err)
+			}
 		}
 
-		// Ensure the configmap is the same as the spec
-		err = query.Get(cm)
+		err = reconcileConfigurer(v)
 		if err != nil {
-			return fmt.Errorf("failed to get deployment: %v", err)
+			return err
 		}
+	}
+	return nil
+}
 
-		externalConfig := v.Spec.ExternalConfigJSON()
-		if cm.Data[vault.DefaultConfigFile] != externalConfig {
-			cm.Data[vault.DefaultConfigFile] = externalConfig
-			err = action.Update(cm)
-			if err != nil {
-				return fmt.Errorf("failed to update configurer configmap: %v", err)
-			}
+// reconcileConfigurer creates the configurer Deployment and keeps it and its ConfigMap in sync with the spec
+func reconcileConfigurer(v *v1alpha1.Vault) error {
+	// Create the deployment if it doesn't exist, update it otherwise (e.g. the image or the unseal config has changed)
+	configurerDep := deploymentForConfigurer(v)
+	logDeployment(configurerDep)
+	err := action.Create(configurerDep)
+	if apierrors.IsAlreadyExists(err) {
+		newConfigurerDep := configurerDep.DeepCopy()
+		if err := query.Get(configurerDep); err != nil {
+			return fmt.Errorf("failed to get configurer deployment: %v", err)
+		}
+		configurerDep.Spec = newConfigurerDep.Spec
+		err = action.Update(configurerDep)
+		if err != nil {
+			return fmt.Errorf("failed to update configurer deployment: %v", err)
 		}
+	} else if err != nil {
+		return fmt.Errorf("failed to create configurer deployment: %v", err)
+	}
+
+	// Create the configmap if it doesn't exist
+	cm := configMapForConfigurer(v)
+	err = action.Create(cm)
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create configurer configmap: %v", err)
+	}
 
+	// Ensure the configmap is the same as the spec
+	err = query.Get(cm)
+	if err != nil {
+		return fmt.Errorf("failed to get deployment: %v", err)
+	}
+
+	externalConfig := v.Spec.ExternalConfigJSON()
+	if cm.Data[vault.DefaultConfigFile] != externalConfig {
+		cm.Data[vault.DefaultConfigFile] = externalConfig
+		err = action.Update(cm)
+		if err != nil {
+			return fmt.Errorf("failed to update configurer configmap: %v", err)
+		}
 	}
 	return nil
 }
@@ -265,7 +337,7 @@ func statefulSetForVault(v *v1alpha1.Vault) (*appsv1.StatefulSet, error) {
 		return nil, fmt.Errorf("More than 1 replicas are not supported without HA storage backend")
 	}
 
-	volumes := withCredentialsVolume(v, []v1.Volume{
+	volumes := withAuditVolume(v, withPKCS11Volume(v, withCredentialsVolume(v, []v1.Volume{
 		{
 			Name: "vault-config",
 			VolumeSource: v1.VolumeSource{
@@ -286,9 +358,9 @@ func statefulSetForVault(v *v1alpha1.Vault) (*appsv1.StatefulSet, error) {
 				},
 			},
 		},
-	})
+	})))
 
-	volumeMounts := withCredentialsVolumeMount(v, []v1.VolumeMount{
+	volumeMounts := withAuditVolumeMount(v, withPKCS11VolumeMount(v, withCredentialsVolumeMount(v, []v1.VolumeMount{
 		{
 			Name:      "vault-config",
 			MountPath: "/vault/config",
@@ -299,7 +371,7 @@ func statefulSetForVault(v *v1alpha1.Vault) (*appsv1.StatefulSet, error) {
 			Name:      "vault-tls",
 			MountPath: "/vault/tls",
 		},
-	})
+	})))
 
 	// TODO Configure Vault to wait for etcd in an init container in this case
 	if v.Spec.GetStorageType() == "etcd" {
@@ -343,7 +415,6 @@ func statefulSetForVault(v *v1alpha1.Vault) (*appsv1.StatefulSet, error) {
 		return nil, err
 	}
 
-	// TODO check if upgrade strategy is HA
 	dep := &appsv1.StatefulSet{
 		TypeMeta: metav1.TypeMeta{
 			APIVersion: "apps/v1",
@@ -358,9 +429,12 @@ func statefulSetForVault(v *v1alpha1.Vault) (*appsv1.StatefulSet, error) {
 			Selector: &metav1.LabelSelector{
 				MatchLabels: ls,
 			},
+			UpdateStrategy:       upgradeStrategyForVault(v),
+			VolumeClaimTemplates: volumeClaimTemplatesForVault(v),
 			Template: v1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
-					Labels: ls,
+					Labels:      ls,
+					Annotations: veleroAnnotationsForVault(v),
 				},
 				Spec: v1.PodSpec{
 					Containers: []v1.Container{
@@ -373,15 +447,16 @@ func statefulSetForVault(v *v1alpha1.Vault) (*appsv1.StatefulSet, error) {
 								ContainerPort: 8200,
 								Name:          "vault",
 							}},
-							Env: withCredentialsEnv(v, []v1.EnvVar{
+							Env: withPKCS11Env(v, withCredentialsEnv(v, []v1.EnvVar{
 								{
 									Name:  "VAULT_LOCAL_CONFIG",
 									Value: configJSON,
 								}, {
 									Name:  api.EnvVaultCACert,
-									Value: "/vault/tls/ca.crt",
+									Value: caCertPathForVault(v),
 								},
-							}),
+							})),
+							Resources: resourcesForVault(v),
 							SecurityContext: &v1.SecurityContext{
 								Capabilities: &v1.Capabilities{
 									Add: []v1.Capability{"IPC_LOCK"},
@@ -415,26 +490,35 @@ func statefulSetForVault(v *v1alpha1.Vault) (*appsv1.StatefulSet, error) {
 							Name:            "bank-vaults",
 							Command:         []string{"bank-vaults", "unseal", "--init"},
 							Args:            v.Spec.UnsealConfig.ToArgs(v),
-							Env: withCredentialsEnv(v, []v1.EnvVar{
+							Env: withEventsEnv(v, withCredentialsEnv(v, []v1.EnvVar{
 								{
 									Name:  k8s.EnvK8SOwnerReference,
 									Value: string(ownerJSON),
 								}, {
 									Name:  api.EnvVaultCACert,
-									Value: "/vault/tls/ca.crt",
+									Value: caCertPathForVault(v),
 								},
-							}),
+							})),
 							VolumeMounts: withCredentialsVolumeMount(v, []v1.VolumeMount{{
 								Name:      "vault-tls",
 								MountPath: "/vault/tls",
 							}}),
 						},
 					},
-					Volumes: volumes,
+					Volumes:      volumes,
+					NodeSelector: v.Spec.NodeSelector,
 				},
 			},
 		},
 	}
+
+	// The unseal sidecar is replaced by the node local unsealer DaemonSet in this mode
+	if v.Spec.NodeLocalUnseal {
+		dep.Spec.Template.Spec.Containers = dep.Spec.Template.Spec.Containers[:1]
+	}
+
+	dep.Spec.Template.Spec.Containers = withAuditContainers(v, dep.Spec.Template.Spec.Containers)
+
 	addOwnerRefToObject(dep, owner)
 	return dep, nil
 }
@@ -521,8 +605,9 @@ func serviceForVault(v *v1alpha1.Vault) *v1.Service {
 			Kind:       "Service",
 		},
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      v.Name,
-			Namespace: v.Namespace,
+			Name:        v.Name,
+			Namespace:   v.Namespace,
+			Annotations: serviceAnnotationsForVault(v),
 		},
 		Spec: v1.ServiceSpec{
 			Type:     v1.ServiceTypeNodePort,
@@ -556,7 +641,8 @@ func deploymentForConfigurer(v *v1alpha1.Vault) *appsv1.Deployment {
 			},
 			Template: v1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
-					Labels: ls,
+					Labels:      ls,
+					Annotations: veleroAnnotationsForConfigurer(v),
 				},
 				Spec: v1.PodSpec{
 					Containers: []v1.Container{
@@ -565,27 +651,27 @@ func deploymentForConfigurer(v *v1alpha1.Vault) *appsv1.Deployment {
 							ImagePullPolicy: v1.PullIfNotPresent,
 							Name:            "bank-vaults",
 							Command:         []string{"bank-vaults", "configure"},
-							Args:            v.Spec.UnsealConfig.ToArgs(v),
-							Env: withCredentialsEnv(v, []v1.EnvVar{
+							Args:            configurerArgsForVault(v),
+							Env: withCredentialsEnv(v, withVaultTokenEnv(v, withVaultTLSEnv(v, []v1.EnvVar{
 								{
 									Name:  api.EnvVaultAddress,
-									Value: fmt.Sprintf("https://%s.%s:8200", v.Name, v.Namespace),
+									Value: vaultAddressForConfigurer(v),
 								}, {
-									Name:  api.EnvVaultCACert,
-									Value: "/vault/tls/ca.crt",
+									Name:      "POD_NAME",
+									ValueFrom: &v1.EnvVarSource{FieldRef: &v1.ObjectFieldSelector{FieldPath: "metadata.name"}},
+								}, {
+									Name:      "POD_NAMESPACE",
+									ValueFrom: &v1.EnvVarSource{FieldRef: &v1.ObjectFieldSelector{FieldPath: "metadata.namespace"}},
 								},
-							}),
-							VolumeMounts: withCredentialsVolumeMount(v, []v1.VolumeMount{{
+							}))),
+							VolumeMounts: withVeleroSnapshotVolumeMount(v, withCredentialsVolumeMount(v, withVaultTLSVolumeMount(v, []v1.VolumeMount{{
 								Name:      "config",
 								MountPath: "/config",
-							}, {
-								Name:      "vault-tls",
-								MountPath: "/vault/tls",
-							}}),
+							}}))),
 							WorkingDir: "/config",
 						},
 					},
-					Volumes: withCredentialsVolume(v, []v1.Volume{
+					Volumes: withVeleroSnapshotVolume(v, withCredentialsVolume(v, withVaultTLSVolume(v, []v1.Volume{
 						{
 							Name: "config",
 							VolumeSource: v1.VolumeSource{
@@ -594,15 +680,7 @@ func deploymentForConfigurer(v *v1alpha1.Vault) *appsv1.Deployment {
 								},
 							},
 						},
-						{
-							Name: "vault-tls",
-							VolumeSource: v1.VolumeSource{
-								Secret: &v1.SecretVolumeSource{
-									SecretName: v.Name + "-tls",
-								},
-							},
-						},
-					}),
+					}))),
 				},
 			},
 		},
@@ -635,6 +713,12 @@ func labelsForVault(name string) map[string]string {
 	return map[string]string{"app": "vault", "vault_cr": name}
 }
 
+// labelsForVaultUnsealer returns the labels for selecting the resources
+// belonging to the given vault CR name.
+func labelsForVaultUnsealer(name string) map[string]string {
+	return map[string]string{"app": "vault-unsealer", "vault_cr": name}
+}
+
 // labelsForVaultConfigurer returns the labels for selecting the resources
 // belonging to the given vault CR name.
 func labelsForVaultConfigurer(name string) map[string]string {
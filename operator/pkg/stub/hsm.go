@@ -0,0 +1,60 @@
+package stub
+
+import (
+	"github.com/banzaicloud/bank-vaults/operator/pkg/apis/vault/v1alpha1"
+	"k8s.io/api/core/v1"
+)
+
+// hsmLibPath is where the host directory with the PKCS#11 library is mounted in the Vault container
+const hsmLibPath = "/vault/hsm"
+
+func withPKCS11Env(v *v1alpha1.Vault, envs []v1.EnvVar) []v1.EnvVar {
+	if v.Spec.PKCS11Seal == nil {
+		return envs
+	}
+	return append(envs, v1.EnvVar{
+		Name: "VAULT_HSM_PIN",
+		ValueFrom: &v1.EnvVarSource{
+			SecretKeyRef: &v1.SecretKeySelector{
+				LocalObjectReference: v1.LocalObjectReference{Name: v.Spec.PKCS11Seal.PinSecret},
+				Key:                  "pin",
+			},
+		},
+	})
+}
+
+func withPKCS11Volume(v *v1alpha1.Vault, volumes []v1.Volume) []v1.Volume {
+	if v.Spec.PKCS11Seal == nil || v.Spec.PKCS11Seal.LibHostPath == "" {
+		return volumes
+	}
+	return append(volumes, v1.Volume{
+		Name: "vault-hsm",
+		VolumeSource: v1.VolumeSource{
+			HostPath: &v1.HostPathVolumeSource{
+				Path: v.Spec.PKCS11Seal.LibHostPath,
+			},
+		},
+	})
+}
+
+func withPKCS11VolumeMount(v *v1alpha1.Vault, volumeMounts []v1.VolumeMount) []v1.VolumeMount {
+	if v.Spec.PKCS11Seal == nil || v.Spec.PKCS11Seal.LibHostPath == "" {
+		return volumeMounts
+	}
+	return append(volumeMounts, v1.VolumeMount{
+		Name:      "vault-hsm",
+		MountPath: hsmLibPath,
+		ReadOnly:  true,
+	})
+}
+
+// resourcesForVault requests the HSM devices of the device plugin for the Vault container,
+// extended resources have to be set as limits
+func resourcesForVault(v *v1alpha1.Vault) v1.ResourceRequirements {
+	if v.Spec.PKCS11Seal == nil {
+		return v1.ResourceRequirements{}
+	}
+	return v1.ResourceRequirements{
+		Limits: v.Spec.PKCS11Seal.Resources,
+	}
+}
@@ -0,0 +1,71 @@
+package stub
+
+import (
+	"fmt"
+
+	"github.com/banzaicloud/bank-vaults/operator/pkg/apis/vault/v1alpha1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// serviceCACertPath is where OpenShift mounts the service serving CA into every pod
+const serviceCACertPath = "/var/run/secrets/kubernetes.io/serviceaccount/service-ca.crt"
+
+// serviceAnnotationsForVault asks the OpenShift service CA to generate the TLS secret of Vault
+func serviceAnnotationsForVault(v *v1alpha1.Vault) map[string]string {
+	if !v.Spec.UsesServiceCA() {
+		return nil
+	}
+	return map[string]string{
+		"service.alpha.openshift.io/serving-cert-secret-name": v.Name + "-tls",
+	}
+}
+
+// caCertPathForVault returns the path of the CA certificate which signed the Vault server certificate
+func caCertPathForVault(v *v1alpha1.Vault) string {
+	if v.Spec.UsesServiceCA() {
+		return serviceCACertPath
+	}
+	return "/vault/tls/ca.crt"
+}
+
+// serverNameForVault returns the host name the Vault server certificate is valid for,
+// the service CA issues certificates for the <service>.<namespace>.svc name only
+func serverNameForVault(v *v1alpha1.Vault) string {
+	if v.Spec.UsesServiceCA() {
+		return fmt.Sprintf("%s.%s.svc", v.Name, v.Namespace)
+	}
+	return fmt.Sprintf("%s.%s", v.Name, v.Namespace)
+}
+
+// routeForVault returns an OpenShift Route exposing the Vault service, TLS is passed
+// through to Vault, so the route host has to be included in the Vault certificate
+func routeForVault(v *v1alpha1.Vault) *unstructured.Unstructured {
+	spec := map[string]interface{}{
+		"to": map[string]interface{}{
+			"kind": "Service",
+			"name": v.Name,
+		},
+		"port": map[string]interface{}{
+			"targetPort": "vault",
+		},
+		"tls": map[string]interface{}{
+			"termination": "passthrough",
+		},
+	}
+	if v.Spec.OpenShift.RouteHost != "" {
+		spec["host"] = v.Spec.OpenShift.RouteHost
+	}
+
+	route := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "route.openshift.io/v1",
+			"kind":       "Route",
+			"spec":       spec,
+		},
+	}
+	route.SetName(v.Name)
+	route.SetNamespace(v.Namespace)
+	route.SetLabels(labelsForVault(v.Name))
+	addOwnerRefToObject(route, asOwner(v))
+	return route
+}
@@ -0,0 +1,133 @@
+package stub
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/banzaicloud/bank-vaults/operator/pkg/apis/vault/v1alpha1"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/k8s"
+	"github.com/banzaicloud/bank-vaults/pkg/metrics"
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/operator-framework/operator-sdk/pkg/sdk/action"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var (
+	rekeysTotal = metrics.NewCounter(
+		"vault_operator_rekeys_total",
+		"Number of rekeys of Vault done by the operator.",
+		"namespace", "vault", "result")
+	rootTokenRotationsTotal = metrics.NewCounter(
+		"vault_operator_root_token_rotations_total",
+		"Number of root token rotations of Vault done by the operator.",
+		"namespace", "vault", "result")
+	lastRekeyTimestamp = metrics.NewGauge(
+		"vault_operator_last_rekey_timestamp_seconds",
+		"Time of the last successful rekey of Vault.",
+		"namespace", "vault")
+	lastRootTokenRotationTimestamp = metrics.NewGauge(
+		"vault_operator_last_root_token_rotation_timestamp_seconds",
+		"Time of the last successful root token rotation of Vault.",
+		"namespace", "vault")
+)
+
+// rotateVaultKeys rekeys Vault and rotates its root token if their periods have elapsed,
+// it works only on an unsealed cluster with the keys stored in a Kubernetes Secret
+func rotateVaultKeys(v *v1alpha1.Vault, pods []v1.Pod) error {
+	rekeyDue := isRotationDue(v, v.Spec.RekeyPeriod, v.Status.LastRekeyTime)
+	rootTokenRotationDue := isRotationDue(v, v.Spec.RootTokenRotationPeriod, v.Status.LastRootTokenRotationTime)
+	if !rekeyDue && !rootTokenRotationDue {
+		return nil
+	}
+
+	if !v.Status.Initialized || len(v.Status.Sealed) > 0 || v.Status.Leader == "" {
+		logrus.Infof("vault %s is not ready, postponing the rotation of its keys", v.Name)
+		return nil
+	}
+
+	var leader *v1.Pod
+	for i := range pods {
+		if pods[i].Name == v.Status.Leader {
+			leader = &pods[i]
+		}
+	}
+	if leader == nil {
+		return nil
+	}
+
+	caCert, err := caCertForVault(v)
+	if err != nil {
+		return err
+	}
+	cl, err := vaultClientForPod(v, leader, caCert)
+	if err != nil {
+		return err
+	}
+
+	kubernetes := v.Spec.UnsealConfig.Kubernetes
+	store, err := k8s.New(kubernetes.GetSecretNamespace(v), kubernetes.GetSecretName(v))
+	if err != nil {
+		return fmt.Errorf("failed to create key store: %v", err)
+	}
+
+	vaultHelper, err := vault.New(store, cl, vault.Config{
+		SecretShares:    v.Spec.UnsealConfig.Options.GetSecretShares(),
+		SecretThreshold: v.Spec.UnsealConfig.Options.GetSecretThreshold(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create vault helper: %v", err)
+	}
+
+	if rekeyDue {
+		_, err := vaultHelper.Rekey(vault.RekeyOptions{})
+		if err != nil {
+			rekeysTotal.Inc(v.Namespace, v.Name, "failure")
+			recordVaultEvent(v, v1.EventTypeWarning, "RekeyFailed", err.Error())
+			return fmt.Errorf("failed to rekey vault: %v", err)
+		}
+		now := metav1.Now()
+		v.Status.LastRekeyTime = &now
+		rekeysTotal.Inc(v.Namespace, v.Name, "success")
+		lastRekeyTimestamp.Set(float64(now.Unix()), v.Namespace, v.Name)
+		recordVaultEvent(v, v1.EventTypeNormal, "Rekeyed", "vault has been rekeyed, the new keys are stored in the key store")
+	}
+
+	// A successful rekey has to be recorded in the status even if the root token rotation fails
+	var rotationErr error
+	if rootTokenRotationDue {
+		rotationErr = vaultHelper.RotateRootToken()
+		if rotationErr != nil {
+			rootTokenRotationsTotal.Inc(v.Namespace, v.Name, "failure")
+			recordVaultEvent(v, v1.EventTypeWarning, "RootTokenRotationFailed", rotationErr.Error())
+		} else {
+			now := metav1.Now()
+			v.Status.LastRootTokenRotationTime = &now
+			rootTokenRotationsTotal.Inc(v.Namespace, v.Name, "success")
+			lastRootTokenRotationTimestamp.Set(float64(now.Unix()), v.Namespace, v.Name)
+			recordVaultEvent(v, v1.EventTypeNormal, "RootTokenRotated", "a new root token has been stored in the key store, the old one has been revoked")
+		}
+	}
+
+	if err := action.Update(v); err != nil {
+		return fmt.Errorf("failed to update vault status: %v", err)
+	}
+	if rotationErr != nil {
+		return fmt.Errorf("failed to rotate root token: %v", rotationErr)
+	}
+	return nil
+}
+
+// isRotationDue returns true if the period has elapsed since the last rotation, or since
+// the creation of the Vault CR if it has never been done
+func isRotationDue(v *v1alpha1.Vault, period metav1.Duration, last *metav1.Time) bool {
+	if period.Duration <= 0 {
+		return false
+	}
+	since := v.CreationTimestamp.Time
+	if last != nil {
+		since = last.Time
+	}
+	return time.Since(since) >= period.Duration
+}
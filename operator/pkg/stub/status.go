@@ -0,0 +1,154 @@
+package stub
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+
+	"github.com/banzaicloud/bank-vaults/operator/pkg/apis/vault/v1alpha1"
+	"github.com/banzaicloud/bank-vaults/operator/pkg/health"
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/hashicorp/vault/api"
+	"github.com/operator-framework/operator-sdk/pkg/sdk/action"
+	"github.com/operator-framework/operator-sdk/pkg/sdk/query"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// updateStatus collects the state of the Vault nodes and the configurer and
+// updates the Vault CR's status if it has changed
+func updateStatus(v *v1alpha1.Vault, pods []v1.Pod) error {
+	status := v.Status.DeepCopy()
+	status.Nodes = getPodNames(pods)
+	status.ConfigHash = v.Spec.ExternalConfigHash()
+	status.Sealed = nil
+	status.Leader = ""
+
+	caCert, err := caCertForVault(v)
+	if err != nil {
+		return err
+	}
+
+	health.SetNodeStatus(status, pods, func(pod *v1.Pod) (*api.HealthResponse, error) {
+		cl, err := vaultClientForPod(v, pod, caCert)
+		if err != nil {
+			return nil, err
+		}
+		return cl.Sys().Health()
+	})
+
+	configured, summary, err := configuredConditionForVault(v, status.ConfigHash)
+	if err != nil {
+		return err
+	}
+	status.SetCondition(configured)
+	status.LastConfiguration = summary
+
+	if !reflect.DeepEqual(*status, v.Status) {
+		v.Status = *status
+		err := action.Update(v)
+		if err != nil {
+			return fmt.Errorf("failed to update vault status: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// configuredConditionForVault reads the result of the last configuration reported by the configurer pods,
+// and its summary if it has been reported
+func configuredConditionForVault(v *v1alpha1.Vault, configHash string) (v1alpha1.VaultCondition, *v1alpha1.VaultConfigurationSummary, error) {
+	condition := v1alpha1.VaultCondition{
+		Type:   v1alpha1.VaultConfigured,
+		Status: v1.ConditionUnknown,
+		Reason: "WaitingForConfigurer",
+	}
+
+	podList := podList()
+	labelSelector := labels.SelectorFromSet(labelsForVaultConfigurer(v.Name)).String()
+	listOps := &metav1.ListOptions{LabelSelector: labelSelector}
+	err := query.List(v.Namespace, podList, query.WithListOptions(listOps))
+	if err != nil {
+		return condition, nil, fmt.Errorf("failed to list configurer pods: %v", err)
+	}
+
+	for _, pod := range podList.Items {
+		if pod.Annotations[vault.ConfigHashAnnotation] != configHash {
+			continue
+		}
+		result := pod.Annotations[vault.ConfigureResultAnnotation]
+		if result == vault.ConfigureResultSuccess {
+			condition.Status = v1.ConditionTrue
+			condition.Reason = ""
+		} else {
+			condition.Status = v1.ConditionFalse
+			condition.Reason = "ConfigurationFailed"
+			condition.Message = result
+		}
+		return condition, configurationSummaryForPod(&pod), nil
+	}
+
+	return condition, nil, nil
+}
+
+// configurationSummaryForPod parses the summary of the last configuration from the annotation of a configurer pod
+func configurationSummaryForPod(pod *v1.Pod) *v1alpha1.VaultConfigurationSummary {
+	annotation, ok := pod.Annotations[vault.ConfigureSummaryAnnotation]
+	if !ok {
+		return nil
+	}
+	var summary vault.ConfigureSummary
+	if err := json.Unmarshal([]byte(annotation), &summary); err != nil {
+		logrus.Warnf("failed to parse the configure summary of pod %s: %v", pod.Name, err)
+		return nil
+	}
+	return &v1alpha1.VaultConfigurationSummary{
+		Created:  summary.Created,
+		Updated:  summary.Updated,
+		Skipped:  summary.Skipped,
+		Deleted:  summary.Deleted,
+		Failed:   summary.Failed,
+		Duration: summary.Duration.String(),
+	}
+}
+
+// caCertForVault returns the CA certificate generated for the Vault cluster
+func caCertForVault(v *v1alpha1.Vault) ([]byte, error) {
+	if v.Spec.UsesServiceCA() {
+		return ioutil.ReadFile(serviceCACertPath)
+	}
+
+	sec := &v1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "Secret",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      v.Name + "-tls",
+			Namespace: v.Namespace,
+		},
+	}
+	err := query.Get(sec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tls secret for vault: %v", err)
+	}
+	return sec.Data["ca.crt"], nil
+}
+
+// vaultClientForPod returns a Vault client which talks to the given Vault pod directly
+func vaultClientForPod(v *v1alpha1.Vault, pod *v1.Pod, caCert []byte) (*api.Client, error) {
+	cl, err := vault.NewAPIClient(vault.ClientOptions{
+		Address:   fmt.Sprintf("https://%s:8200", pod.Status.PodIP),
+		CACertPEM: caCert,
+		// The generated certificate is valid for the service name only
+		TLSServerName: serverNameForVault(v),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create vault client for pod %s: %v", pod.Name, err)
+	}
+	cl.ClearToken()
+	return cl, nil
+}
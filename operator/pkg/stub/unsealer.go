@@ -0,0 +1,121 @@
+package stub
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/banzaicloud/bank-vaults/operator/pkg/apis/vault/v1alpha1"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/k8s"
+	"github.com/hashicorp/vault/api"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// daemonSetForUnsealer returns a DaemonSet which unseals the Vault pods running on the same node
+// as its pods, for Vault clusters pinned to specific nodes with the nodeSelector of the CR
+func daemonSetForUnsealer(v *v1alpha1.Vault) (*appsv1.DaemonSet, error) {
+	ls := labelsForVaultUnsealer(v.Name)
+	owner := asOwner(v)
+	ownerJSON, err := json.Marshal(owner)
+	if err != nil {
+		return nil, err
+	}
+
+	args := append([]string{"--node-local-selector", labels.SelectorFromSet(labelsForVault(v.Name)).String()}, v.Spec.UnsealConfig.ToArgs(v)...)
+
+	ds := &appsv1.DaemonSet{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "apps/v1",
+			Kind:       "DaemonSet",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      v.Name + "-unsealer",
+			Namespace: v.Namespace,
+		},
+		Spec: appsv1.DaemonSetSpec{
+			Selector: &metav1.LabelSelector{
+				MatchLabels: ls,
+			},
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: ls,
+				},
+				Spec: v1.PodSpec{
+					Containers: []v1.Container{
+						{
+							Image:           v.Spec.GetBankVaultsImage(),
+							ImagePullPolicy: v1.PullIfNotPresent,
+							Name:            "bank-vaults",
+							Command:         []string{"bank-vaults", "unseal", "--init"},
+							Args:            args,
+							Env: withEventsEnv(v, withCredentialsEnv(v, []v1.EnvVar{
+								{
+									Name:  k8s.EnvK8SOwnerReference,
+									Value: string(ownerJSON),
+								}, {
+									Name:  api.EnvVaultCACert,
+									Value: caCertPathForVault(v),
+								}, {
+									// The unsealer connects to the pod IPs, which are not in the Vault certificate
+									Name:  api.EnvVaultTLSServerName,
+									Value: serverNameForVault(v),
+								}, {
+									Name:      "NODE_NAME",
+									ValueFrom: &v1.EnvVarSource{FieldRef: &v1.ObjectFieldSelector{FieldPath: "spec.nodeName"}},
+								}, {
+									Name:      "POD_NAMESPACE",
+									ValueFrom: &v1.EnvVarSource{FieldRef: &v1.ObjectFieldSelector{FieldPath: "metadata.namespace"}},
+								},
+							})),
+							VolumeMounts: withCredentialsVolumeMount(v, []v1.VolumeMount{{
+								Name:      "vault-tls",
+								MountPath: "/vault/tls",
+							}}),
+						},
+					},
+					Volumes: withCredentialsVolume(v, []v1.Volume{
+						{
+							Name: "vault-tls",
+							VolumeSource: v1.VolumeSource{
+								Secret: &v1.SecretVolumeSource{
+									SecretName: v.Name + "-tls",
+								},
+							},
+						},
+					}),
+					NodeSelector: v.Spec.NodeSelector,
+				},
+			},
+		},
+	}
+	addOwnerRefToObject(ds, owner)
+	return ds, nil
+}
+
+// withEventsEnv enables the Kubernetes Events of the unsealer about the Vault pods, the unsealer
+// sidecar reports about its own pod, so it gets the name of the pod as well
+func withEventsEnv(v *v1alpha1.Vault, envs []v1.EnvVar) []v1.EnvVar {
+	options := v.Spec.UnsealConfig.Options
+	if !options.Events {
+		return envs
+	}
+	envs = append(envs, v1.EnvVar{
+		Name:  "BANK_VAULTS_EVENTS",
+		Value: "true",
+	}, v1.EnvVar{
+		Name:  "BANK_VAULTS_EVENTS_ANNOTATE",
+		Value: fmt.Sprint(options.EventsAnnotate),
+	})
+	if !v.Spec.NodeLocalUnseal {
+		envs = append(envs, v1.EnvVar{
+			Name:      "POD_NAME",
+			ValueFrom: &v1.EnvVarSource{FieldRef: &v1.ObjectFieldSelector{FieldPath: "metadata.name"}},
+		}, v1.EnvVar{
+			Name:      "POD_NAMESPACE",
+			ValueFrom: &v1.EnvVarSource{FieldRef: &v1.ObjectFieldSelector{FieldPath: "metadata.namespace"}},
+		})
+	}
+	return envs
+}
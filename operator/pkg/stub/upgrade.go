@@ -0,0 +1,146 @@
+package stub
+
+import (
+	"fmt"
+
+	"github.com/banzaicloud/bank-vaults/operator/pkg/apis/vault/v1alpha1"
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/hashicorp/vault/api"
+	"github.com/operator-framework/operator-sdk/pkg/sdk/action"
+	"github.com/operator-framework/operator-sdk/pkg/sdk/query"
+	"github.com/sirupsen/logrus"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// upgradeStrategyForVault returns the update strategy of the Vault StatefulSet, HA clusters are
+// upgraded by the operator (see upgradeVault) instead of a plain rolling update
+func upgradeStrategyForVault(v *v1alpha1.Vault) appsv1.StatefulSetUpdateStrategy {
+	if v.Spec.HasHAStorage() {
+		return appsv1.StatefulSetUpdateStrategy{Type: appsv1.OnDeleteStatefulSetStrategyType}
+	}
+	return appsv1.StatefulSetUpdateStrategy{Type: appsv1.RollingUpdateStatefulSetStrategyType}
+}
+
+// upgradeVault replaces the outdated Vault pods of an HA cluster one at a time: standbys first,
+// then the leader after stepping it down. Each step waits until every pod is ready and unsealed,
+// so it is called on every reconciliation and does at most one step.
+func upgradeVault(v *v1alpha1.Vault, statefulSet *appsv1.StatefulSet, pods []v1.Pod) error {
+	if statefulSet.Spec.UpdateStrategy.Type != appsv1.OnDeleteStatefulSetStrategyType {
+		return nil
+	}
+	updateRevision := statefulSet.Status.UpdateRevision
+	if updateRevision == "" || statefulSet.Spec.Replicas == nil || int32(len(pods)) != *statefulSet.Spec.Replicas {
+		return nil
+	}
+
+	caCert, err := caCertForVault(v)
+	if err != nil {
+		return err
+	}
+
+	var outdatedStandby, outdatedLeader *v1.Pod
+	var leaderClient *api.Client
+	for i := range pods {
+		pod := &pods[i]
+		if pod.DeletionTimestamp != nil || !isPodReady(pod) {
+			logrus.Debugf("waiting for vault pod %s to become ready before upgrading", pod.Name)
+			return nil
+		}
+
+		cl, err := vaultClientForPod(v, pod, caCert)
+		if err != nil {
+			return err
+		}
+		health, err := cl.Sys().Health()
+		if err != nil || !health.Initialized || health.Sealed {
+			logrus.Debugf("waiting for vault pod %s to become unsealed before upgrading", pod.Name)
+			return nil
+		}
+
+		if pod.Labels[appsv1.StatefulSetRevisionLabel] == updateRevision {
+			continue
+		}
+		if health.Standby {
+			outdatedStandby = pod
+		} else {
+			outdatedLeader = pod
+			leaderClient = cl
+		}
+	}
+
+	if outdatedStandby != nil {
+		logrus.Infof("upgrading vault standby pod %s", outdatedStandby.Name)
+		return deletePod(outdatedStandby)
+	}
+
+	if outdatedLeader != nil {
+		if len(pods) > 1 {
+			err := stepDownLeader(v, leaderClient)
+			if err == nil {
+				// The pod is upgraded as a standby in one of the next rounds
+				logrus.Infof("stepped down vault leader pod %s before upgrading it", outdatedLeader.Name)
+				return nil
+			}
+			logrus.Warnf("failed to step down vault leader pod %s, deleting it: %v", outdatedLeader.Name, err)
+		}
+		logrus.Infof("upgrading vault leader pod %s", outdatedLeader.Name)
+		return deletePod(outdatedLeader)
+	}
+
+	return nil
+}
+
+// stepDownLeader asks the leader to give up its leadership, this needs the root token which is only
+// available to the operator if it is stored in a Kubernetes Secret
+func stepDownLeader(v *v1alpha1.Vault, cl *api.Client) error {
+	if v.Spec.UnsealConfig.Kubernetes == nil {
+		return fmt.Errorf("the root token is not stored in a kubernetes secret")
+	}
+
+	sec := &v1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "Secret",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      v.Spec.UnsealConfig.Kubernetes.GetSecretName(v),
+			Namespace: v.Spec.UnsealConfig.Kubernetes.GetSecretNamespace(v),
+		},
+	}
+	err := query.Get(sec)
+	if err != nil {
+		return fmt.Errorf("failed to get unseal keys secret: %v", err)
+	}
+	rootToken, ok := sec.Data[vault.RootTokenKey]
+	if !ok {
+		return fmt.Errorf("the root token is not stored in the unseal keys secret")
+	}
+
+	cl.SetToken(string(rootToken))
+	defer cl.ClearToken()
+
+	return cl.Sys().StepDown()
+}
+
+func isPodReady(pod *v1.Pod) bool {
+	if pod.Status.Phase != v1.PodRunning {
+		return false
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == v1.PodReady {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+func deletePod(pod *v1.Pod) error {
+	pod.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"}
+	err := action.Delete(pod)
+	if err != nil {
+		return fmt.Errorf("failed to delete pod %s: %v", pod.Name, err)
+	}
+	return nil
+}
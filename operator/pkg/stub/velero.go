@@ -0,0 +1,93 @@
+package stub
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/banzaicloud/bank-vaults/operator/pkg/apis/vault/v1alpha1"
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"k8s.io/api/core/v1"
+)
+
+// The raft snapshot taken by the pre backup hook of the configurer pod, its volume is backed up
+// with restic
+const (
+	veleroSnapshotVolume = "vault-raft-snapshot"
+	veleroSnapshotPath   = "/vault/snapshots"
+	veleroSnapshotFile   = veleroSnapshotPath + "/vault.snap"
+)
+
+// veleroAnnotationsForVault returns the Velero annotations of the Vault pods: the backup hooks
+// of the unsealer, which pause its rekeying and root token rotation while the backup is running,
+// and with the file storage the vault-file volume gets backed up with restic, since it holds the
+// whole Vault data
+func veleroAnnotationsForVault(v *v1alpha1.Vault) map[string]string {
+	if !v.Spec.VeleroEnabled {
+		return nil
+	}
+	annotations := map[string]string{}
+	if !v.Spec.NodeLocalUnseal {
+		annotations = veleroPauseHooks("touch " + vault.ConfigurePauseFile)
+	}
+	if v.Spec.GetStorageType() == "file" {
+		annotations["backup.velero.io/backup-volumes"] = "vault-file"
+	}
+	return annotations
+}
+
+// veleroAnnotationsForConfigurer returns the Velero backup hooks of the configurer pod, which
+// pause the configuration loop while the backup is running. With the raft storage the pre hook
+// saves a raft snapshot into the vault-raft-snapshot volume after pausing, which is backed up with
+// restic, since the raft volumes of the running Vault pods aren't consistent.
+func veleroAnnotationsForConfigurer(v *v1alpha1.Vault) map[string]string {
+	if !v.Spec.VeleroEnabled {
+		return nil
+	}
+	pre := "touch " + vault.ConfigurePauseFile
+	if veleroRaftSnapshot(v) {
+		args := append([]string{"bank-vaults", "snapshot", "save", veleroSnapshotFile}, configurerArgsForVault(v)...)
+		pre += " && " + strings.Join(args, " ")
+	}
+	annotations := veleroPauseHooks(pre)
+	if veleroRaftSnapshot(v) {
+		annotations["backup.velero.io/backup-volumes"] = veleroSnapshotVolume
+	}
+	return annotations
+}
+
+// veleroPauseHooks returns the annotations of the pre backup hook running the pre shell command,
+// and of the post backup hook resuming the configuration, in the bank-vaults container
+func veleroPauseHooks(pre string) map[string]string {
+	preCommand, _ := json.Marshal([]string{"/bin/sh", "-c", pre})
+	postCommand, _ := json.Marshal([]string{"/bin/sh", "-c", "rm -f " + vault.ConfigurePauseFile})
+	return map[string]string{
+		"pre.hook.backup.velero.io/container":  "bank-vaults",
+		"pre.hook.backup.velero.io/command":    string(preCommand),
+		"post.hook.backup.velero.io/container": "bank-vaults",
+		"post.hook.backup.velero.io/command":   string(postCommand),
+	}
+}
+
+// veleroRaftSnapshot returns whether the configurer takes a raft snapshot before the backups
+func veleroRaftSnapshot(v *v1alpha1.Vault) bool {
+	return v.Spec.VeleroEnabled && !v.Spec.IsExternal() && v.Spec.GetStorageType() == "raft"
+}
+
+// withVeleroSnapshotVolume adds the volume of the raft snapshot to the volumes of the configurer
+func withVeleroSnapshotVolume(v *v1alpha1.Vault, volumes []v1.Volume) []v1.Volume {
+	if !veleroRaftSnapshot(v) {
+		return volumes
+	}
+	return append(volumes, v1.Volume{
+		Name:         veleroSnapshotVolume,
+		VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}},
+	})
+}
+
+// withVeleroSnapshotVolumeMount mounts the volume of the raft snapshot into the configurer
+func withVeleroSnapshotVolumeMount(v *v1alpha1.Vault, volumeMounts []v1.VolumeMount) []v1.VolumeMount {
+	if !veleroRaftSnapshot(v) {
+		return volumeMounts
+	}
+	return append(volumeMounts, v1.VolumeMount{Name: veleroSnapshotVolume, MountPath: veleroSnapshotPath})
+}
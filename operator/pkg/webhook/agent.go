@@ -0,0 +1,306 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// The annotations of the Pods requesting a Vault Agent
+const (
+	// AgentInjectAnnotation set to "true" injects the Vault Agent into the Pod
+	AgentInjectAnnotation = "vault.banzaicloud.com/agent-inject"
+	// AgentRoleAnnotation is the role of the kubernetes auth method the agent logs in with, required
+	AgentRoleAnnotation = "vault.banzaicloud.com/agent-role"
+	// AgentAuthPathAnnotation is the path of the kubernetes auth method, kubernetes by default
+	AgentAuthPathAnnotation = "vault.banzaicloud.com/agent-auth-path"
+	// AgentAddressAnnotation is the address of Vault, the default of the webhook if empty
+	AgentAddressAnnotation = "vault.banzaicloud.com/agent-address"
+	// AgentImageAnnotation is the image of the agent, the default of the webhook if empty
+	AgentImageAnnotation = "vault.banzaicloud.com/agent-image"
+	// AgentCASecretAnnotation is a Secret in the namespace of the Pod holding the ca.crt of Vault
+	AgentCASecretAnnotation = "vault.banzaicloud.com/agent-ca-secret"
+	// AgentPrePopulateOnlyAnnotation set to "true" injects only the init container, the files are
+	// rendered once before the containers start and aren't refreshed
+	AgentPrePopulateOnlyAnnotation = "vault.banzaicloud.com/agent-pre-populate-only"
+	// AgentSecretAnnotationPrefix followed by a file name is the path of the secret rendered into
+	// /vault/secrets/<file name>, as JSON unless there is a template for the file
+	AgentSecretAnnotationPrefix = "vault.banzaicloud.com/agent-inject-secret-"
+	// AgentTemplateAnnotationPrefix followed by a file name is the Consul Template of the file
+	AgentTemplateAnnotationPrefix = "vault.banzaicloud.com/agent-inject-template-"
+)
+
+// The defaults of the agent injection
+const (
+	DefaultAgentImage    = "vault:latest"
+	DefaultAgentAddress  = "https://vault:8200"
+	defaultAgentAuthPath = "kubernetes"
+)
+
+const (
+	agentSecretsVolume = "vault-secrets"
+	agentSecretsPath   = "/vault/secrets"
+	agentCAVolume      = "vault-agent-ca"
+	agentCAPath        = "/vault/tls"
+	agentConfigEnv     = "VAULT_AGENT_CONFIG"
+	agentTokenSinkPath = "/home/vault/.vault-token"
+	serviceAccountPath = "/var/run/secrets/kubernetes.io/serviceaccount"
+)
+
+// AgentConfig holds the defaults of the Vault Agents the webhook injects
+type AgentConfig struct {
+	Image   string
+	Address string
+}
+
+// jsonPatchOperation is an operation of the JSON patch of a mutating webhook
+type jsonPatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// mutate injects the Vault Agent into the Pod of the request if it is annotated with
+// AgentInjectAnnotation, the Pod is allowed unchanged otherwise
+func (c *AgentConfig) mutate(req *admissionRequest) *admissionResponse {
+	resp := &admissionResponse{UID: req.UID, Allowed: true}
+
+	pod := v1.Pod{}
+	if err := json.Unmarshal(req.Object, &pod); err != nil {
+		resp.Allowed = false
+		resp.Result = &metav1.Status{Status: metav1.StatusFailure, Message: fmt.Sprintf("error decoding the pod: %s", err.Error())}
+		return resp
+	}
+	if pod.Annotations[AgentInjectAnnotation] != "true" {
+		return resp
+	}
+
+	patch, err := c.agentPatch(&pod)
+	if err == nil {
+		resp.Patch, err = json.Marshal(patch)
+	}
+	if err != nil {
+		logrus.Infof("rejecting pod %s/%s: %v", req.Namespace, podName(&pod), err)
+		resp.Allowed = false
+		resp.Result = &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Reason:  metav1.StatusReasonInvalid,
+			Message: err.Error(),
+		}
+		return resp
+	}
+	patchType := "JSONPatch"
+	resp.PatchType = &patchType
+	logrus.Infof("injecting vault agent into pod %s/%s", req.Namespace, podName(&pod))
+	return resp
+}
+
+// podName returns the name of the Pod, or its generate name if it hasn't got a name yet
+func podName(pod *v1.Pod) string {
+	if pod.Name != "" {
+		return pod.Name
+	}
+	return pod.GenerateName
+}
+
+// agentPatch returns the JSON patch adding the secrets volume mounted into every container, the
+// init container rendering the files before the containers start, and the agent sidecar keeping
+// them up to date
+func (c *AgentConfig) agentPatch(pod *v1.Pod) ([]jsonPatchOperation, error) {
+	annotations := pod.Annotations
+	if annotations[AgentRoleAnnotation] == "" {
+		return nil, fmt.Errorf("the %s annotation is required by %s", AgentRoleAnnotation, AgentInjectAnnotation)
+	}
+
+	templates, err := agentTemplates(annotations)
+	if err != nil {
+		return nil, err
+	}
+
+	address := c.Address
+	if value := annotations[AgentAddressAnnotation]; value != "" {
+		address = value
+	}
+	image := c.Image
+	if value := annotations[AgentImageAnnotation]; value != "" {
+		image = value
+	}
+	caSecret := annotations[AgentCASecretAnnotation]
+
+	volumes := []v1.Volume{{
+		Name:         agentSecretsVolume,
+		VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{Medium: v1.StorageMediumMemory}},
+	}}
+	agentMounts := []v1.VolumeMount{{Name: agentSecretsVolume, MountPath: agentSecretsPath}}
+	if caSecret != "" {
+		volumes = append(volumes, v1.Volume{
+			Name:         agentCAVolume,
+			VolumeSource: v1.VolumeSource{Secret: &v1.SecretVolumeSource{SecretName: caSecret}},
+		})
+		agentMounts = append(agentMounts, v1.VolumeMount{Name: agentCAVolume, MountPath: agentCAPath, ReadOnly: true})
+	}
+	// The ServiceAccount token the agent logs in with is mounted into the app containers only, it is
+	// the same volume in all of them
+	if mount, ok := serviceAccountMount(pod); ok {
+		agentMounts = append(agentMounts, mount)
+	}
+
+	agentContainer := func(name string, exitAfterAuth bool) (v1.Container, error) {
+		config, err := agentConfigJSON(annotations, address, caSecret != "", exitAfterAuth, templates)
+		if err != nil {
+			return v1.Container{}, err
+		}
+		return v1.Container{
+			Name:    name,
+			Image:   image,
+			Command: []string{"/bin/sh", "-ec"},
+			Args:    []string{fmt.Sprintf(`echo "$%s" > /home/vault/config.json && exec vault agent -config=/home/vault/config.json`, agentConfigEnv)},
+			Env: []v1.EnvVar{
+				{Name: agentConfigEnv, Value: config},
+			},
+			VolumeMounts: agentMounts,
+		}, nil
+	}
+
+	var patch []jsonPatchOperation
+	add := func(path string, existing int, values ...interface{}) {
+		for i, value := range values {
+			if existing == 0 && i == 0 {
+				patch = append(patch, jsonPatchOperation{Op: "add", Path: path, Value: []interface{}{value}})
+			} else {
+				patch = append(patch, jsonPatchOperation{Op: "add", Path: path + "/-", Value: value})
+			}
+		}
+	}
+
+	for i, volume := range volumes {
+		add("/spec/volumes", len(pod.Spec.Volumes)+i, volume)
+	}
+
+	secretsMount := v1.VolumeMount{Name: agentSecretsVolume, MountPath: agentSecretsPath, ReadOnly: true}
+	for i, container := range pod.Spec.Containers {
+		add(fmt.Sprintf("/spec/containers/%d/volumeMounts", i), len(container.VolumeMounts), secretsMount)
+	}
+
+	// The init container is prepended, so the files are rendered before the other init containers run
+	initContainer, err := agentContainer("vault-agent-init", true)
+	if err != nil {
+		return nil, err
+	}
+	if len(pod.Spec.InitContainers) == 0 {
+		patch = append(patch, jsonPatchOperation{Op: "add", Path: "/spec/initContainers", Value: []v1.Container{initContainer}})
+	} else {
+		patch = append(patch, jsonPatchOperation{Op: "add", Path: "/spec/initContainers/0", Value: initContainer})
+	}
+
+	if annotations[AgentPrePopulateOnlyAnnotation] != "true" {
+		sidecar, err := agentContainer("vault-agent", false)
+		if err != nil {
+			return nil, err
+		}
+		add("/spec/containers", len(pod.Spec.Containers), sidecar)
+	}
+
+	return patch, nil
+}
+
+// serviceAccountMount returns the mount of the ServiceAccount token of the first container having one
+func serviceAccountMount(pod *v1.Pod) (v1.VolumeMount, bool) {
+	for _, container := range pod.Spec.Containers {
+		for _, mount := range container.VolumeMounts {
+			if mount.MountPath == serviceAccountPath {
+				return mount, true
+			}
+		}
+	}
+	return v1.VolumeMount{}, false
+}
+
+// agentTemplates returns the templates of the files of the secrets of the annotations by the file
+// names, a secret without a template is rendered as JSON
+func agentTemplates(annotations map[string]string) (map[string]string, error) {
+	templates := map[string]string{}
+	for annotation, path := range annotations {
+		if !strings.HasPrefix(annotation, AgentSecretAnnotationPrefix) {
+			continue
+		}
+		file := strings.TrimPrefix(annotation, AgentSecretAnnotationPrefix)
+		if file == "" || strings.Contains(file, "/") || file == "." || file == ".." {
+			return nil, fmt.Errorf("invalid file name in %s", annotation)
+		}
+		if path == "" {
+			return nil, fmt.Errorf("the path of the secret is empty in %s", annotation)
+		}
+		template := annotations[AgentTemplateAnnotationPrefix+file]
+		if template == "" {
+			template = fmt.Sprintf(`{{ with secret %q }}{{ .Data | toJSONPretty }}{{ end }}`, path)
+		}
+		templates[file] = template
+	}
+	for annotation := range annotations {
+		if file := strings.TrimPrefix(annotation, AgentTemplateAnnotationPrefix); file != annotation {
+			if _, ok := templates[file]; !ok {
+				return nil, fmt.Errorf("%s has no %s%s annotation", annotation, AgentSecretAnnotationPrefix, file)
+			}
+		}
+	}
+	if len(templates) == 0 {
+		return nil, fmt.Errorf("no %s<file> annotation, there is nothing to render", AgentSecretAnnotationPrefix)
+	}
+	return templates, nil
+}
+
+// agentConfigJSON returns the configuration of the Vault Agent in JSON: auto-auth with the kubernetes
+// auth method of the annotations, and a template stanza rendering each file into /vault/secrets
+func agentConfigJSON(annotations map[string]string, address string, caCert, exitAfterAuth bool, templates map[string]string) (string, error) {
+	authPath := strings.Trim(annotations[AgentAuthPathAnnotation], "/")
+	if authPath == "" {
+		authPath = defaultAgentAuthPath
+	}
+	if !strings.HasPrefix(authPath, "auth/") {
+		authPath = "auth/" + authPath
+	}
+
+	vaultStanza := map[string]interface{}{"address": address}
+	if caCert {
+		vaultStanza["ca_cert"] = agentCAPath + "/ca.crt"
+	}
+
+	files := make([]string, 0, len(templates))
+	for file := range templates {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	templateStanzas := make([]interface{}, 0, len(files))
+	for _, file := range files {
+		templateStanzas = append(templateStanzas, map[string]interface{}{
+			"destination": agentSecretsPath + "/" + file,
+			"contents":    templates[file],
+		})
+	}
+
+	config := map[string]interface{}{
+		"exit_after_auth": exitAfterAuth,
+		"pid_file":        "/home/vault/.pid",
+		"vault":           vaultStanza,
+		"auto_auth": map[string]interface{}{
+			"method": []interface{}{map[string]interface{}{
+				"type":       "kubernetes",
+				"mount_path": authPath,
+				"config":     map[string]interface{}{"role": annotations[AgentRoleAnnotation]},
+			}},
+			"sink": []interface{}{map[string]interface{}{
+				"type":   "file",
+				"config": map[string]interface{}{"path": agentTokenSinkPath},
+			}},
+		},
+		"template": templateStanzas,
+	}
+	data, err := json.Marshal(config)
+	return string(data), err
+}
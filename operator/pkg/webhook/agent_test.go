@@ -0,0 +1,219 @@
+package webhook
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var serviceAccountVolumeMount = v1.VolumeMount{Name: "default-token", MountPath: serviceAccountPath, ReadOnly: true}
+
+func agentTestPod(annotations map[string]string, containers ...v1.Container) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Annotations: annotations},
+		Spec:       v1.PodSpec{Containers: containers},
+	}
+}
+
+func TestAgentTemplates(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		templates   map[string]string
+		err         string
+	}{{
+		name:        "default template",
+		annotations: map[string]string{AgentSecretAnnotationPrefix + "db": "secret/data/db"},
+		templates:   map[string]string{"db": `{{ with secret "secret/data/db" }}{{ .Data | toJSONPretty }}{{ end }}`},
+	}, {
+		name: "custom template",
+		annotations: map[string]string{
+			AgentSecretAnnotationPrefix + "db":   "secret/data/db",
+			AgentTemplateAnnotationPrefix + "db": `{{ with secret "secret/data/db" }}{{ .Data.data.password }}{{ end }}`,
+		},
+		templates: map[string]string{"db": `{{ with secret "secret/data/db" }}{{ .Data.data.password }}{{ end }}`},
+	}, {
+		name:        "no secrets",
+		annotations: map[string]string{AgentInjectAnnotation: "true"},
+		err:         "nothing to render",
+	}, {
+		name:        "invalid file name",
+		annotations: map[string]string{AgentSecretAnnotationPrefix + "..": "secret/data/db"},
+		err:         "invalid file name",
+	}, {
+		name:        "empty path",
+		annotations: map[string]string{AgentSecretAnnotationPrefix + "db": ""},
+		err:         "is empty",
+	}, {
+		name: "template without secret",
+		annotations: map[string]string{
+			AgentSecretAnnotationPrefix + "db":    "secret/data/db",
+			AgentTemplateAnnotationPrefix + "api": "{{ . }}",
+		},
+		err: "has no " + AgentSecretAnnotationPrefix + "api",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			templates, err := agentTemplates(test.annotations)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected an error containing %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if len(templates) != len(test.templates) {
+				t.Fatalf("unexpected templates: %v", templates)
+			}
+			for file, template := range test.templates {
+				if templates[file] != template {
+					t.Errorf("unexpected template of %s: %q", file, templates[file])
+				}
+			}
+		})
+	}
+}
+
+func TestAgentPatch(t *testing.T) {
+	annotations := map[string]string{
+		AgentInjectAnnotation:              "true",
+		AgentRoleAnnotation:                "app",
+		AgentSecretAnnotationPrefix + "db": "secret/data/db",
+	}
+	withAnnotation := func(key, value string) map[string]string {
+		copied := map[string]string{key: value}
+		for k, v := range annotations {
+			copied[k] = v
+		}
+		return copied
+	}
+	app := v1.Container{Name: "app", VolumeMounts: []v1.VolumeMount{serviceAccountVolumeMount}}
+	proxy := v1.Container{Name: "proxy", VolumeMounts: []v1.VolumeMount{serviceAccountVolumeMount}}
+
+	tests := []struct {
+		name string
+		pod  *v1.Pod
+		// the names of the added init containers and containers
+		initContainers []string
+		containers     []string
+		volumes        int
+		err            string
+	}{{
+		name:           "single container",
+		pod:            agentTestPod(annotations, app),
+		initContainers: []string{"vault-agent-init"},
+		containers:     []string{"vault-agent"},
+		volumes:        1,
+	}, {
+		name:           "multiple containers",
+		pod:            agentTestPod(annotations, app, proxy),
+		initContainers: []string{"vault-agent-init"},
+		containers:     []string{"vault-agent"},
+		volumes:        1,
+	}, {
+		name:           "pre-populate only",
+		pod:            agentTestPod(withAnnotation(AgentPrePopulateOnlyAnnotation, "true"), app),
+		initContainers: []string{"vault-agent-init"},
+		volumes:        1,
+	}, {
+		name:           "ca secret",
+		pod:            agentTestPod(withAnnotation(AgentCASecretAnnotation, "vault-tls"), app),
+		initContainers: []string{"vault-agent-init"},
+		containers:     []string{"vault-agent"},
+		volumes:        2,
+	}, {
+		name: "no role",
+		pod: agentTestPod(map[string]string{
+			AgentInjectAnnotation:              "true",
+			AgentSecretAnnotationPrefix + "db": "secret/data/db",
+		}, app),
+		err: AgentRoleAnnotation,
+	}}
+
+	config := &AgentConfig{Image: DefaultAgentImage, Address: DefaultAgentAddress}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			patch, err := config.agentPatch(test.pod)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected an error containing %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+
+			var initContainers, containers []string
+			volumes, secretsMounts := 0, 0
+			for _, operation := range patch {
+				switch {
+				case operation.Path == "/spec/initContainers" || operation.Path == "/spec/initContainers/0":
+					for _, container := range patchContainers(t, operation.Value) {
+						initContainers = append(initContainers, container.Name)
+						checkAgentMounts(t, container)
+					}
+				case strings.HasPrefix(operation.Path, "/spec/containers") && !strings.Contains(operation.Path, "volumeMounts"):
+					for _, container := range patchContainers(t, operation.Value) {
+						containers = append(containers, container.Name)
+						checkAgentMounts(t, container)
+					}
+				case strings.HasPrefix(operation.Path, "/spec/volumes"):
+					volumes++
+				case strings.HasSuffix(operation.Path, "/volumeMounts") || strings.HasSuffix(operation.Path, "/volumeMounts/-"):
+					secretsMounts++
+				}
+			}
+			if strings.Join(initContainers, ",") != strings.Join(test.initContainers, ",") {
+				t.Errorf("unexpected init containers: %v", initContainers)
+			}
+			if strings.Join(containers, ",") != strings.Join(test.containers, ",") {
+				t.Errorf("unexpected containers: %v", containers)
+			}
+			if volumes != test.volumes {
+				t.Errorf("expected %d volumes, got %d", test.volumes, volumes)
+			}
+			if secretsMounts != len(test.pod.Spec.Containers) {
+				t.Errorf("the secrets volume should be mounted into each of the %d containers, got %d mounts", len(test.pod.Spec.Containers), secretsMounts)
+			}
+		})
+	}
+}
+
+// patchContainers decodes the containers of a patch operation, a single container or a list of them
+func patchContainers(t *testing.T, value interface{}) []v1.Container {
+	data, err := json.Marshal(value)
+	if err != nil {
+		t.Fatalf("error encoding the patch value: %s", err.Error())
+	}
+	var containers []v1.Container
+	if err := json.Unmarshal(data, &containers); err == nil {
+		return containers
+	}
+	var container v1.Container
+	if err := json.Unmarshal(data, &container); err != nil {
+		t.Fatalf("error decoding the container of the patch: %s", err.Error())
+	}
+	return []v1.Container{container}
+}
+
+// checkAgentMounts checks that the mount paths of an agent container are unique, which the API
+// server requires
+func checkAgentMounts(t *testing.T, container v1.Container) {
+	paths := map[string]bool{}
+	for _, mount := range container.VolumeMounts {
+		if paths[mount.MountPath] {
+			t.Errorf("duplicate mount path %s in %s", mount.MountPath, container.Name)
+		}
+		paths[mount.MountPath] = true
+	}
+	if !paths[serviceAccountPath] {
+		t.Errorf("the service account token isn't mounted into %s", container.Name)
+	}
+}
@@ -0,0 +1,120 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/banzaicloud/bank-vaults/operator/pkg/apis/vault/v1alpha1"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// The admission.k8s.io/v1beta1 API types are not vendored, these hold the fields the webhook needs
+
+type admissionReview struct {
+	metav1.TypeMeta `json:",inline"`
+	Request         *admissionRequest  `json:"request,omitempty"`
+	Response        *admissionResponse `json:"response,omitempty"`
+}
+
+type admissionRequest struct {
+	UID       types.UID       `json:"uid"`
+	Namespace string          `json:"namespace,omitempty"`
+	Operation string          `json:"operation"`
+	Object    json.RawMessage `json:"object"`
+}
+
+type admissionResponse struct {
+	UID       types.UID      `json:"uid"`
+	Allowed   bool           `json:"allowed"`
+	Result    *metav1.Status `json:"status,omitempty"`
+	Patch     []byte         `json:"patch,omitempty"`
+	PatchType *string        `json:"patchType,omitempty"`
+}
+
+// Server serves the validating admission webhook of Vault CRs, and the mutating admission webhook
+// injecting the Vault Agent into the annotated Pods
+type Server struct {
+	addr     string
+	certFile string
+	keyFile  string
+	agent    AgentConfig
+}
+
+// NewServer returns a webhook Server listening on addr with the given TLS certificate, injecting the
+// Vault Agents with the defaults of agent
+func NewServer(addr, certFile, keyFile string, agent AgentConfig) *Server {
+	if agent.Image == "" {
+		agent.Image = DefaultAgentImage
+	}
+	if agent.Address == "" {
+		agent.Address = DefaultAgentAddress
+	}
+	return &Server{addr: addr, certFile: certFile, keyFile: keyFile, agent: agent}
+}
+
+// Run starts serving the webhook, it blocks until the server fails
+func (s *Server) Run() error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/validate", func(w http.ResponseWriter, r *http.Request) {
+		serve(w, r, validate)
+	})
+	mux.HandleFunc("/mutate", func(w http.ResponseWriter, r *http.Request) {
+		serve(w, r, s.agent.mutate)
+	})
+	logrus.Infof("serving vault admission webhooks on %s", s.addr)
+	return http.ListenAndServeTLS(s.addr, s.certFile, s.keyFile, mux)
+}
+
+// serve decodes the admission review of the request and responds with the response of review
+func serve(w http.ResponseWriter, r *http.Request, review func(*admissionRequest) *admissionResponse) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	admission := admissionReview{}
+	if err := json.Unmarshal(body, &admission); err != nil || admission.Request == nil {
+		http.Error(w, "failed to decode admission review", http.StatusBadRequest)
+		return
+	}
+
+	admission.Response = review(admission.Request)
+	admission.Request = nil
+
+	resp, err := json.Marshal(admission)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to encode admission review: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resp)
+}
+
+func validate(req *admissionRequest) *admissionResponse {
+	resp := &admissionResponse{UID: req.UID, Allowed: true}
+
+	if req.Operation == "DELETE" {
+		return resp
+	}
+
+	v := v1alpha1.Vault{}
+	err := json.Unmarshal(req.Object, &v)
+	if err == nil {
+		err = v.Validate()
+	}
+	if err != nil {
+		logrus.Infof("rejecting vault %s/%s: %v", v.Namespace, v.Name, err)
+		resp.Allowed = false
+		resp.Result = &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Reason:  metav1.StatusReasonInvalid,
+			Message: err.Error(),
+		}
+	}
+	return resp
+}
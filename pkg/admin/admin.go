@@ -0,0 +1,281 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TargetStatus is the last known state of a Vault instance managed by the process
+type TargetStatus struct {
+	// Target is the name of the Vault cluster or Pod, empty for the single Vault of the process
+	Target string `json:"target,omitempty"`
+	// Sealed is nil until the seal status has been checked
+	Sealed      *bool      `json:"sealed,omitempty"`
+	LastCheck   *time.Time `json:"lastCheck,omitempty"`
+	LastUnseal  *time.Time `json:"lastUnseal,omitempty"`
+	LastError   string     `json:"lastError,omitempty"`
+	Initialized bool       `json:"initialized,omitempty"`
+	// Version and HAMode are reported only by the concurrent unseal of the nodes of an HA cluster
+	Version string `json:"version,omitempty"`
+	HAMode  string `json:"haMode,omitempty"`
+	// ConfigureRequired is true if the target is ready only after it has been configured
+	ConfigureRequired bool       `json:"configureRequired,omitempty"`
+	Configured        bool       `json:"configured"`
+	LastConfigure     *time.Time `json:"lastConfigure,omitempty"`
+	ConfigureError    string     `json:"configureError,omitempty"`
+	// KeyStoreError is the error of the last check of the key store, empty if it was reachable
+	KeyStoreError     string     `json:"keyStoreError,omitempty"`
+	LastKeyStoreCheck *time.Time `json:"lastKeyStoreCheck,omitempty"`
+}
+
+// notReadyReason returns why the target isn't ready, empty if it is
+func (s *TargetStatus) notReadyReason() string {
+	switch {
+	case s.KeyStoreError != "":
+		return "the key store is unreachable: " + s.KeyStoreError
+	case s.Sealed != nil && *s.Sealed:
+		return "vault is sealed"
+	case s.ConfigureRequired:
+		// A configured Vault has been unsealed, the configurer doesn't check the seal status
+		if !s.Configured {
+			return "vault is not configured"
+		}
+		if s.ConfigureError != "" {
+			return "the last configuration failed: " + s.ConfigureError
+		}
+	case s.Sealed == nil:
+		return "seal status not checked yet"
+	}
+	return ""
+}
+
+// Status is the JSON document served on /status
+type Status struct {
+	Ready   bool            `json:"ready"`
+	Started time.Time       `json:"started"`
+	Version string          `json:"version,omitempty"`
+	Targets []*TargetStatus `json:"targets"`
+}
+
+// Server serves the health, the readiness and the status of the Vault instances managed by the process
+// (e.g. for the liveness and readiness probes of its Pod), the lifecycle actions report to its Targets
+type Server struct {
+	mu       sync.Mutex
+	started  time.Time
+	version  string
+	targets  map[string]*TargetStatus
+	handlers map[string]http.Handler
+}
+
+// NewServer returns a Server reporting the given version of the process in the status
+func NewServer(version string) *Server {
+	return &Server{
+		started:  time.Now(),
+		version:  version,
+		targets:  map[string]*TargetStatus{},
+		handlers: map[string]http.Handler{},
+	}
+}
+
+// Target is the reporter of the lifecycle actions on a Vault instance
+type Target struct {
+	server *Server
+	name   string
+}
+
+// Target returns the reporter of the named Vault instance, the process is not ready until every
+// reported instance is unsealed (and configured if it is required)
+func (s *Server) Target(name string) *Target {
+	s.update(name, func(*TargetStatus) {})
+	return &Target{server: s, name: name}
+}
+
+// RemoveTarget stops reporting the named Vault instance, e.g. when its Pod has been deleted
+func (s *Server) RemoveTarget(name string) {
+	s.mu.Lock()
+	delete(s.targets, name)
+	s.mu.Unlock()
+}
+
+func (s *Server) update(name string, update func(*TargetStatus)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status, ok := s.targets[name]
+	if !ok {
+		status = &TargetStatus{Target: name}
+		s.targets[name] = status
+	}
+	update(status)
+}
+
+// RequireConfiguration makes the target ready only after it has been configured
+func (t *Target) RequireConfiguration() {
+	t.server.update(t.name, func(status *TargetStatus) {
+		status.ConfigureRequired = true
+	})
+}
+
+// ReportInitialized reports the initialization of Vault
+func (t *Target) ReportInitialized(err error) {
+	t.server.update(t.name, func(status *TargetStatus) {
+		if err != nil {
+			status.LastError = err.Error()
+			return
+		}
+		status.Initialized = true
+	})
+}
+
+// ReportSealed reports the result of checking the seal status of Vault
+func (t *Target) ReportSealed(sealed bool, err error) {
+	t.server.update(t.name, func(status *TargetStatus) {
+		status.LastCheck = now()
+		if err != nil {
+			status.LastError = err.Error()
+			return
+		}
+		status.Sealed = &sealed
+		status.LastError = ""
+	})
+}
+
+// ReportUnsealed reports the result of unsealing Vault
+func (t *Target) ReportUnsealed(err error) {
+	t.server.update(t.name, func(status *TargetStatus) {
+		if err != nil {
+			status.LastError = err.Error()
+			return
+		}
+		sealed := false
+		status.Sealed = &sealed
+		status.LastUnseal = now()
+		status.LastError = ""
+	})
+}
+
+// ReportNode reports the version and the HA mode (active, standby or disabled) of the Vault node
+func (t *Target) ReportNode(version, haMode string) {
+	t.server.update(t.name, func(status *TargetStatus) {
+		status.Version = version
+		status.HAMode = haMode
+	})
+}
+
+// ReportConfigured reports the result of configuring Vault, the target is not ready while the last
+// configuration has failed
+func (t *Target) ReportConfigured(err error) {
+	t.server.update(t.name, func(status *TargetStatus) {
+		status.LastConfigure = now()
+		if err != nil {
+			status.ConfigureError = err.Error()
+			return
+		}
+		status.Configured = true
+		status.ConfigureError = ""
+	})
+}
+
+// ReportKeyStore reports the result of checking whether the key store can be reached
+func (t *Target) ReportKeyStore(err error) {
+	t.server.update(t.name, func(status *TargetStatus) {
+		status.LastKeyStoreCheck = now()
+		status.KeyStoreError = ""
+		if err != nil {
+			status.KeyStoreError = err.Error()
+		}
+	})
+}
+
+func now() *time.Time {
+	t := time.Now()
+	return &t
+}
+
+// Status returns the status of the process and of the reported Vault instances
+func (s *Server) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := Status{
+		Ready:   true,
+		Started: s.started,
+		Version: s.version,
+		Targets: []*TargetStatus{},
+	}
+	for _, target := range s.targets {
+		copied := *target
+		status.Targets = append(status.Targets, &copied)
+		if target.notReadyReason() != "" {
+			status.Ready = false
+		}
+	}
+	sort.Slice(status.Targets, func(i, j int) bool { return status.Targets[i].Target < status.Targets[j].Target })
+	return status
+}
+
+// Handle serves another handler on the admin server, e.g. the metrics, it has to be called before Handler
+func (s *Server) Handle(path string, handler http.Handler) {
+	s.mu.Lock()
+	s.handlers[path] = handler
+	s.mu.Unlock()
+}
+
+// Handler returns a http.Handler serving /healthz, /readyz and /status (and the added handlers)
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", s.healthz)
+	mux.HandleFunc("/readyz", s.readyz)
+	mux.HandleFunc("/status", s.status)
+
+	s.mu.Lock()
+	for path, handler := range s.handlers {
+		mux.Handle(path, handler)
+	}
+	s.mu.Unlock()
+	return mux
+}
+
+// ListenAndServe serves the Handler on the given address
+func (s *Server) ListenAndServe(addr string) error {
+	return http.ListenAndServe(addr, s.Handler())
+}
+
+// healthz reports that the process is alive, the state of Vault doesn't make it unhealthy
+func (s *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "ok")
+}
+
+// readyz reports whether every reported Vault instance is unsealed (and configured if it is required)
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	reasons := []string{}
+	for _, target := range s.targets {
+		if reason := target.notReadyReason(); reason != "" {
+			if target.Target != "" {
+				reason = target.Target + ": " + reason
+			}
+			reasons = append(reasons, reason)
+		}
+	}
+	s.mu.Unlock()
+
+	if len(reasons) > 0 {
+		sort.Strings(reasons)
+		http.Error(w, strings.Join(reasons, "\n"), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+func (s *Server) status(w http.ResponseWriter, r *http.Request) {
+	status := s.Status()
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(status)
+}
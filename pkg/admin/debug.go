@@ -0,0 +1,43 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+)
+
+// RuntimeStats is the JSON document served on /debug/runtime
+type RuntimeStats struct {
+	Goroutines int              `json:"goroutines"`
+	GOMAXPROCS int              `json:"gomaxprocs"`
+	GoVersion  string           `json:"goVersion"`
+	Uptime     string           `json:"uptime"`
+	MemStats   runtime.MemStats `json:"memStats"`
+}
+
+// HandleDebug serves the pprof profiles on /debug/pprof/ and the goroutine and memory statistics on
+// /debug/runtime, it has to be called before Handler. The command line isn't served, it may hold secrets.
+func (s *Server) HandleDebug() {
+	s.Handle("/debug/pprof/", http.HandlerFunc(pprof.Index))
+	s.Handle("/debug/pprof/profile", http.HandlerFunc(pprof.Profile))
+	s.Handle("/debug/pprof/symbol", http.HandlerFunc(pprof.Symbol))
+	s.Handle("/debug/pprof/trace", http.HandlerFunc(pprof.Trace))
+	s.Handle("/debug/runtime", http.HandlerFunc(s.runtimeStats))
+}
+
+func (s *Server) runtimeStats(w http.ResponseWriter, r *http.Request) {
+	stats := RuntimeStats{
+		Goroutines: runtime.NumGoroutine(),
+		GOMAXPROCS: runtime.GOMAXPROCS(0),
+		GoVersion:  runtime.Version(),
+		Uptime:     time.Since(s.started).Round(time.Second).String(),
+	}
+	runtime.ReadMemStats(&stats.MemStats)
+
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(stats)
+}
@@ -0,0 +1,103 @@
+package backoff
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// The intervals of the Default backoff
+const (
+	DefaultInitialInterval = time.Second
+	DefaultMaxInterval     = 30 * time.Second
+)
+
+// ErrTimeout is returned by Wait if the condition isn't met within MaxWait
+var ErrTimeout = errors.New("timed out")
+
+// Exponential is an exponential backoff between the checks of a condition: the interval starts at
+// InitialInterval, and is doubled after every check up to MaxInterval
+type Exponential struct {
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+	// MaxWait is how long to wait for the condition at most, forever if 0
+	MaxWait time.Duration
+	// Jitter randomizes every interval by up to this fraction of it, e.g. with 0.2 the intervals
+	// are between 80% and 120% of the exponential ones, so the clients don't retry in lockstep
+	Jitter float64
+}
+
+// Jittered returns the interval randomized by up to the fraction of it, the interval itself if the
+// fraction is not positive
+func Jittered(interval time.Duration, fraction float64) time.Duration {
+	if fraction <= 0 || interval <= 0 {
+		return interval
+	}
+	if fraction > 1 {
+		fraction = 1
+	}
+	delta := fraction * float64(interval)
+	return interval + time.Duration(delta*(2*rand.Float64()-1))
+}
+
+// Default returns the default backoff with the given maximum wait
+func Default(maxWait time.Duration) Exponential {
+	return Exponential{InitialInterval: DefaultInitialInterval, MaxInterval: DefaultMaxInterval, MaxWait: maxWait}
+}
+
+// Wait checks the condition until it returns true, with the intervals of the backoff between the
+// checks. The errors of the condition are retried, notify (if not nil) is called with them (nil if
+// the condition is simply not met yet) and the next interval before waiting. It returns ErrTimeout
+// after MaxWait, or the error of the context when it is cancelled.
+func Wait(ctx context.Context, b Exponential, condition func() (bool, error), notify func(err error, next time.Duration)) error {
+	interval := b.InitialInterval
+	if interval <= 0 {
+		interval = DefaultInitialInterval
+	}
+	maxInterval := b.MaxInterval
+	if maxInterval <= 0 {
+		maxInterval = DefaultMaxInterval
+	}
+
+	var deadline time.Time
+	if b.MaxWait > 0 {
+		deadline = time.Now().Add(b.MaxWait)
+	}
+
+	for {
+		done, err := condition()
+		if err == nil && done {
+			return nil
+		}
+
+		next := Jittered(interval, b.Jitter)
+		if !deadline.IsZero() {
+			remaining := time.Until(deadline)
+			if remaining <= 0 {
+				return ErrTimeout
+			}
+			// The last check is right at the deadline
+			if next > remaining {
+				next = remaining
+			}
+		}
+
+		if notify != nil {
+			notify(err, next)
+		}
+
+		timer := time.NewTimer(next)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		interval *= 2
+		if interval > maxInterval {
+			interval = maxInterval
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package backoff
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWait(t *testing.T) {
+	b := Exponential{InitialInterval: time.Millisecond, MaxInterval: 4 * time.Millisecond, MaxWait: time.Second}
+
+	var intervals []time.Duration
+	checks := 0
+	err := Wait(context.Background(), b, func() (bool, error) {
+		checks++
+		if checks == 2 {
+			return false, errors.New("connection refused")
+		}
+		return checks == 5, nil
+	}, func(err error, next time.Duration) {
+		intervals = append(intervals, next)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	expected := []time.Duration{time.Millisecond, 2 * time.Millisecond, 4 * time.Millisecond, 4 * time.Millisecond}
+	if len(intervals) != len(expected) {
+		t.Fatalf("expected the intervals %v, got %v", expected, intervals)
+	}
+	for i := range expected {
+		if intervals[i] != expected[i] {
+			t.Fatalf("expected the intervals %v, got %v", expected, intervals)
+		}
+	}
+
+	b.MaxWait = 20 * time.Millisecond
+	if err := Wait(context.Background(), b, func() (bool, error) { return false, nil }, nil); err != ErrTimeout {
+		t.Errorf("expected a timeout, got: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	b.MaxWait = 0
+	if err := Wait(ctx, b, func() (bool, error) { return false, nil }, nil); err != context.Canceled {
+		t.Errorf("expected the cancellation, got: %v", err)
+	}
+}
+
+func TestJittered(t *testing.T) {
+	if interval := Jittered(time.Second, 0); interval != time.Second {
+		t.Errorf("expected no jitter, got %s", interval)
+	}
+	for i := 0; i < 100; i++ {
+		interval := Jittered(time.Second, 0.2)
+		if interval < 800*time.Millisecond || interval > 1200*time.Millisecond {
+			t.Fatalf("expected the interval to be within 20%% of 1s, got %s", interval)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package circuit
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// State is the state of a Breaker
+type State int
+
+// States of a Breaker
+const (
+	// Closed lets every request through
+	Closed State = iota
+	// HalfOpen lets a single probe request through after the breaker has been open
+	HalfOpen
+	// Open fails the requests without sending them until the next probe
+	Open
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case HalfOpen:
+		return "half-open"
+	case Open:
+		return "open"
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+// OpenError is returned instead of sending a request while the Breaker is open
+type OpenError struct {
+	Name string
+	// Retry is the time of the next probe request
+	Retry time.Time
+}
+
+func (e *OpenError) Error() string {
+	return fmt.Sprintf("%s is unavailable after repeated failures, the next attempt is at %s", e.Name, e.Retry.Format(time.RFC3339))
+}
+
+// Breaker stops sending requests to a backend (e.g. Vault or a KMS) after threshold consecutive
+// failures, so an outage doesn't generate a storm of failing requests. While it is open, a single
+// probe request is let through after the probe interval, which is doubled after every failed probe
+// up to the maximum interval. A successful request closes it again.
+//
+// A nil Breaker lets every request through.
+type Breaker struct {
+	name          string
+	threshold     int
+	interval      time.Duration
+	maxInterval   time.Duration
+	onStateChange func(State)
+
+	mu           sync.Mutex
+	state        State
+	failures     int
+	nextInterval time.Duration
+	retry        time.Time
+}
+
+// New returns a Breaker of the named backend, or nil if threshold isn't positive. onStateChange
+// (if not nil) is called on every state change, e.g. to expose the state in the metrics.
+func New(name string, threshold int, interval, maxInterval time.Duration, onStateChange func(State)) *Breaker {
+	if threshold <= 0 {
+		return nil
+	}
+	if maxInterval < interval {
+		maxInterval = interval
+	}
+	return &Breaker{
+		name:          name,
+		threshold:     threshold,
+		interval:      interval,
+		maxInterval:   maxInterval,
+		onStateChange: onStateChange,
+		nextInterval:  interval,
+	}
+}
+
+// State returns the current state of the Breaker
+func (b *Breaker) State() State {
+	if b == nil {
+		return Closed
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Allow returns an *OpenError if the request mustn't be sent, otherwise its result has to be
+// reported with Record
+func (b *Breaker) Allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case Open:
+		if time.Now().Before(b.retry) {
+			return &OpenError{Name: b.name, Retry: b.retry}
+		}
+		b.setState(HalfOpen)
+		return nil
+	case HalfOpen:
+		// The probe is still running
+		return &OpenError{Name: b.name, Retry: b.retry}
+	}
+	return nil
+}
+
+// Record reports the result of an allowed request, failed is true if the backend is unavailable
+// (e.g. the request couldn't be sent or timed out), not if the request itself was invalid
+func (b *Breaker) Record(failed bool) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !failed {
+		b.failures = 0
+		b.nextInterval = b.interval
+		if b.state != Closed {
+			b.setState(Closed)
+		}
+		return
+	}
+
+	b.failures++
+	if b.state == HalfOpen || b.failures >= b.threshold {
+		b.retry = time.Now().Add(b.nextInterval)
+		b.nextInterval *= 2
+		if b.nextInterval > b.maxInterval {
+			b.nextInterval = b.maxInterval
+		}
+		b.setState(Open)
+	}
+}
+
+// Do calls f unless the Breaker is open, every error of f counts as a failure
+func (b *Breaker) Do(f func() error) error {
+	if err := b.Allow(); err != nil {
+		return err
+	}
+	err := f()
+	b.Record(err != nil)
+	return err
+}
+
+func (b *Breaker) setState(state State) {
+	if b.state == state {
+		return
+	}
+	b.state = state
+	if b.onStateChange != nil {
+		b.onStateChange(state)
+	}
+}
@@ -0,0 +1,149 @@
+// Package hook provides the vault.Hook implementations running a command or calling a webhook
+// around the lifecycle phases, so the configuration can be validated, approved or announced by
+// other systems without embedding the vault package.
+package hook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+)
+
+// DefaultTimeout is the timeout of a command or a webhook call if none is given
+const DefaultTimeout = 30 * time.Second
+
+// The environment variables of the commands, besides the environment of the process
+const (
+	EnvEvent = "BANK_VAULTS_HOOK_EVENT"
+	EnvPhase = "BANK_VAULTS_HOOK_PHASE"
+	EnvStage = "BANK_VAULTS_HOOK_STAGE"
+)
+
+// maxOutput is the length of the output of a command or the response of a webhook kept in the errors
+const maxOutput = 1024
+
+type execHook struct {
+	command []string
+	timeout time.Duration
+}
+
+// NewExec returns a Hook running the command with the event as JSON on its standard input, and its
+// name, phase and stage in the BANK_VAULTS_HOOK_* environment variables. The hook fails if the
+// command exits with a non-zero status or doesn't finish within the timeout (DefaultTimeout if 0).
+func NewExec(command []string, timeout time.Duration) (vault.Hook, error) {
+	if len(command) == 0 {
+		return nil, fmt.Errorf("empty hook command")
+	}
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	return &execHook{command: command, timeout: timeout}, nil
+}
+
+func (h *execHook) Call(event *vault.HookEvent) error {
+	input, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, h.command[0], h.command[1:]...)
+	cmd.Env = append(os.Environ(),
+		EnvEvent+"="+event.Name(),
+		EnvPhase+"="+event.Phase,
+		EnvStage+"="+event.Stage,
+	)
+	cmd.Stdin = bytes.NewReader(input)
+	output, err := cmd.CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("%s timed out after %s", h.command[0], h.timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("%s failed: %s: %s", h.command[0], err.Error(), truncate(output))
+	}
+	return nil
+}
+
+type webhookHook struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhook returns a Hook posting the event as JSON to the url. The hook fails if the response
+// isn't 2xx, its body is included in the error, e.g. the reason of rejecting a configuration.
+func NewWebhook(url string, timeout time.Duration) vault.Hook {
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	return &webhookHook{url: url, client: &http.Client{Timeout: timeout}}
+}
+
+func (h *webhookHook) Call(event *vault.HookEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	resp, err := h.client.Post(h.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected status from %s: %s: %s", h.url, resp.Status, truncate(body))
+	}
+	return nil
+}
+
+type filteredHook struct {
+	hook   vault.Hook
+	events map[string]bool
+}
+
+// Filter calls the hook only for the given events, e.g. pre-configure or post-unseal
+func Filter(hook vault.Hook, events ...string) vault.Hook {
+	f := &filteredHook{hook: hook, events: map[string]bool{}}
+	for _, event := range events {
+		f.events[event] = true
+	}
+	return f
+}
+
+func (f *filteredHook) Call(event *vault.HookEvent) error {
+	if !f.events[event.Name()] {
+		return nil
+	}
+	return f.hook.Call(event)
+}
+
+// Events returns the names of all the events, e.g. pre-init, post-init, pre-unseal, ...
+func Events() []string {
+	events := []string{}
+	for _, phase := range vault.HookPhases {
+		for _, stage := range []string{vault.HookStagePre, vault.HookStagePost} {
+			events = append(events, stage+"-"+phase)
+		}
+	}
+	return events
+}
+
+func truncate(output []byte) string {
+	s := strings.TrimSpace(string(output))
+	if len(s) > maxOutput {
+		s = s[:maxOutput] + "..."
+	}
+	return s
+}
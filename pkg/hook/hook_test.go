@@ -0,0 +1,50 @@
+package hook
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+)
+
+func TestWebhookRejects(t *testing.T) {
+	var events []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event vault.HookEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("invalid body: %s", err.Error())
+		}
+		events = append(events, event.Name())
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("change freeze"))
+	}))
+	defer server.Close()
+
+	hook := Filter(NewWebhook(server.URL, 0), "pre-configure")
+	if err := hook.Call(&vault.HookEvent{Phase: vault.HookPhaseUnseal, Stage: vault.HookStagePre}); err != nil {
+		t.Errorf("unexpected error for a filtered event: %s", err.Error())
+	}
+	err := hook.Call(&vault.HookEvent{Phase: vault.HookPhaseConfigure, Stage: vault.HookStagePre})
+	if err == nil || !strings.Contains(err.Error(), "change freeze") {
+		t.Errorf("expected the rejection, got: %v", err)
+	}
+	if len(events) != 1 || events[0] != "pre-configure" {
+		t.Errorf("unexpected events: %v", events)
+	}
+}
+
+func TestExec(t *testing.T) {
+	hook, err := NewExec([]string{"sh", "-c", `test "$` + EnvEvent + `" = post-init && grep -q '"phase":"init"'`}, 0)
+	if err != nil {
+		t.Fatalf("error creating the hook: %s", err.Error())
+	}
+	if err := hook.Call(&vault.HookEvent{Phase: vault.HookPhaseInit, Stage: vault.HookStagePost}); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if err := hook.Call(&vault.HookEvent{Phase: vault.HookPhaseInit, Stage: vault.HookStagePre}); err == nil {
+		t.Errorf("expected an error for a non-zero exit status")
+	}
+}
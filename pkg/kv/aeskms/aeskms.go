@@ -0,0 +1,127 @@
+package aeskms
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"golang.org/x/crypto/scrypt"
+)
+
+// KeySize is the size of the AES-256 keys
+const KeySize = 32
+
+// saltSize is the size of the random salt of the keys derived from a passphrase, stored with every
+// cipher text
+const saltSize = 16
+
+// The scrypt parameters of the keys derived from a passphrase, recommended for interactive logins
+const (
+	scryptN = 32768
+	scryptR = 8
+	scryptP = 1
+)
+
+// aesKMS is an implementation of the kv.KMS interface, that encrypts and decrypts data with
+// AES-256-GCM using a locally provided key, or keys derived from a passphrase
+type aesKMS struct {
+	key        []byte
+	passphrase []byte
+}
+
+var _ kv.KMS = &aesKMS{}
+
+// NewKMS creates a new kv.KMS encrypting with the AES-256 key, to be composed with a storage by
+// kv.NewEncrypted, e.g. for the on-premises key-value stores without a cloud KMS
+func NewKMS(key []byte) (kv.KMS, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("the AES key has to be %d bytes long, got %d", KeySize, len(key))
+	}
+	return &aesKMS{key: key}, nil
+}
+
+// NewKMSWithPassphrase creates a new kv.KMS encrypting every value with a key derived from the
+// passphrase with scrypt and a random salt
+func NewKMSWithPassphrase(passphrase string) (kv.KMS, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("the passphrase can't be empty")
+	}
+	return &aesKMS{passphrase: []byte(passphrase)}, nil
+}
+
+// ParseKey parses an AES-256 key encoded in hex or base64, e.g. the content of a key file
+func ParseKey(encoded string) ([]byte, error) {
+	encoded = strings.TrimSpace(encoded)
+	if key, err := hex.DecodeString(encoded); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(encoded); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	return nil, fmt.Errorf("the AES key has to be %d bytes encoded in hex or base64", KeySize)
+}
+
+// gcm returns the cipher of the key, derived from the passphrase with the salt if there is one
+func (a *aesKMS) gcm(salt []byte) (cipher.AEAD, error) {
+	key := a.key
+	if a.passphrase != nil {
+		var err error
+		if key, err = scrypt.Key(a.passphrase, salt, scryptN, scryptR, scryptP, KeySize); err != nil {
+			return nil, fmt.Errorf("error deriving key from passphrase: %s", err.Error())
+		}
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Encrypt returns the salt (with a passphrase), the nonce and the sealed plain text
+func (a *aesKMS) Encrypt(plainText []byte) ([]byte, error) {
+	var salt []byte
+	if a.passphrase != nil {
+		salt = make([]byte, saltSize)
+		if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+			return nil, fmt.Errorf("error generating salt: %s", err.Error())
+		}
+	}
+	gcm, err := a.gcm(salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("error generating nonce: %s", err.Error())
+	}
+	return gcm.Seal(append(salt, nonce...), nonce, plainText, nil), nil
+}
+
+func (a *aesKMS) Decrypt(cipherText []byte) ([]byte, error) {
+	var salt []byte
+	if a.passphrase != nil {
+		if len(cipherText) < saltSize {
+			return nil, fmt.Errorf("error decrypting value: the cipher text is too short")
+		}
+		salt, cipherText = cipherText[:saltSize], cipherText[saltSize:]
+	}
+	gcm, err := a.gcm(salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(cipherText) < gcm.NonceSize() {
+		return nil, fmt.Errorf("error decrypting value: the cipher text is too short")
+	}
+	nonce, sealed := cipherText[:gcm.NonceSize()], cipherText[gcm.NonceSize():]
+	plainText, err := gcm.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting value: %s", err.Error())
+	}
+	return plainText, nil
+}
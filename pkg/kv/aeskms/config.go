@@ -0,0 +1,39 @@
+package aeskms
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+)
+
+// The settings of the AES KMS in a kv.Config, the key file takes precedence over the passphrase
+const (
+	KMSName       = "aes"
+	KeyFileKey    = "aes-key-file"
+	PassphraseKey = "aes-passphrase"
+)
+
+func init() {
+	kv.RegisterKMS(KMSName, NewKMSFromConfig)
+}
+
+// NewKMSFromConfig creates a new kv.KMS encrypting with the key of the key file, or with keys derived
+// from the passphrase of the config
+func NewKMSFromConfig(config kv.Config) (kv.KMS, error) {
+	if keyFile := config.GetString(KeyFileKey); keyFile != "" {
+		encoded, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading AES key file: %s", err.Error())
+		}
+		key, err := ParseKey(string(encoded))
+		if err != nil {
+			return nil, err
+		}
+		return NewKMS(key)
+	}
+	if passphrase := config.GetString(PassphraseKey); passphrase != "" {
+		return NewKMSWithPassphrase(passphrase)
+	}
+	return nil, fmt.Errorf("either %s or %s is required", KeyFileKey, PassphraseKey)
+}
@@ -0,0 +1,58 @@
+package alibabakms
+
+import (
+	"fmt"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/alibabaoss"
+)
+
+// The settings of Alibaba KMS in a kv.Config, the mode stores the values in Alibaba OSS with the
+// credentials of alibabaoss
+const (
+	Mode      = "alibaba-kms-oss"
+	RegionKey = "alibaba-kms-region"
+	KeyIDKey  = "alibaba-kms-key-id"
+)
+
+func init() {
+	kv.Register(Mode, NewFromConfig)
+}
+
+// NewFromConfig creates a new kv.Service of Alibaba OSS encrypted by Alibaba KMS from the settings
+// of the config, authenticated with the RAM role if there is one
+func NewFromConfig(config kv.Config) (kv.Service, error) {
+	accessKeyID := config.GetString(alibabaoss.AccessKeyIDKey)
+	accessKeySecret := config.GetString(alibabaoss.AccessKeySecretKey)
+	ramRole := config.GetString(alibabaoss.RAMRoleKey)
+
+	if ramRole == "" && (accessKeyID == "" || accessKeySecret == "") {
+		return nil, fmt.Errorf("Alibaba accessKeyID or accessKeySecret can't be empty without a RAM role")
+	}
+
+	oss, err := alibabaoss.NewFromConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	var kms kv.Service
+	if ramRole != "" {
+		kms, err = NewWithRAMRole(
+			config.GetString(RegionKey),
+			ramRole,
+			config.GetString(KeyIDKey),
+			oss)
+	} else {
+		kms, err = New(
+			config.GetString(RegionKey),
+			accessKeyID,
+			accessKeySecret,
+			config.GetString(KeyIDKey),
+			oss)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error creating Alibaba KMS kv store: %s", err.Error())
+	}
+
+	return kms, nil
+}
@@ -29,6 +29,19 @@ func New(regionID, accessKeyID, accessKeySecret, kmsID string, store kv.Service)
 	return &alibabaKMS{store: store, kmsClient: client, kmsID: kmsID}, nil
 }
 
+// NewWithRAMRole creates a new kv.Service encrypted by Alibaba KMS, authenticated with a RAM role
+// attached to the ECS instance instead of an AccessKey
+func NewWithRAMRole(regionID, roleName, kmsID string, store kv.Service) (kv.Service, error) {
+	client, err := kms.NewClientWithEcsRamRole(regionID, roleName)
+	if err != nil {
+		return nil, err
+	}
+
+	client.GetConfig().Scheme = requests.HTTPS
+
+	return &alibabaKMS{store: store, kmsClient: client, kmsID: kmsID}, nil
+}
+
 func (a *alibabaKMS) decrypt(cipherText []byte) ([]byte, error) {
 	request := kms.CreateDecryptRequest()
 	request.CiphertextBlob = string(cipherText)
@@ -64,6 +77,20 @@ func (a *alibabaKMS) Set(key string, val []byte) error {
 	return a.store.Set(key, cipherText)
 }
 
+func (a *alibabaKMS) Create(key string, val []byte) error {
+	cipherText, err := a.encrypt(val)
+
+	if err != nil {
+		return err
+	}
+
+	return kv.Create(a.store, key, cipherText)
+}
+
+func (a *alibabaKMS) Delete(key string) error {
+	return kv.Delete(a.store, key)
+}
+
 func (a *alibabaKMS) Test(key string) error {
 	inputString := "test"
 
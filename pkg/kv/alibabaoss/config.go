@@ -0,0 +1,56 @@
+package alibabaoss
+
+import (
+	"fmt"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+)
+
+// The settings of the storage of Alibaba OSS in a kv.Config, the credentials are shared with Alibaba KMS
+const (
+	StorageName        = "alibaba-oss"
+	EndpointKey        = "alibaba-oss-endpoint"
+	BucketKey          = "alibaba-oss-bucket"
+	PrefixKey          = "alibaba-oss-prefix"
+	AccessKeyIDKey     = "alibaba-access-key-id"
+	AccessKeySecretKey = "alibaba-access-key-secret"
+	RAMRoleKey         = "alibaba-ram-role"
+)
+
+func init() {
+	kv.RegisterStorage(StorageName, NewFromConfig)
+}
+
+// NewFromConfig creates a new kv.Service backed by Alibaba OSS from the settings of the config,
+// authenticated with the RAM role if there is one
+func NewFromConfig(config kv.Config) (kv.Service, error) {
+	bucket := config.GetString(BucketKey)
+
+	if bucket == "" {
+		return nil, fmt.Errorf("Alibaba OSS bucket should be specified")
+	}
+
+	var oss kv.Service
+	var err error
+	if ramRole := config.GetString(RAMRoleKey); ramRole != "" {
+		oss, err = NewWithRAMRole(
+			config.GetString(EndpointKey),
+			ramRole,
+			bucket,
+			config.GetString(PrefixKey),
+		)
+	} else {
+		oss, err = New(
+			config.GetString(EndpointKey),
+			config.GetString(AccessKeyIDKey),
+			config.GetString(AccessKeySecretKey),
+			bucket,
+			config.GetString(PrefixKey),
+		)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error creating Alibaba OSS kv store: %s", err.Error())
+	}
+
+	return oss, nil
+}
@@ -11,24 +11,49 @@ import (
 
 type ossStorage struct {
 	client *oss.Client
+	// role creates the clients instead of client if the RAM role of the instance is used
+	role   *ramRole
 	bucket string
 	prefix string
 }
 
-// New creates a new kv.Service backed by AWS S3
+// New creates a new kv.Service backed by Alibaba OSS
 func New(endpoint, accessKeyID, accessKeySecret, bucket, prefix string) (kv.Service, error) {
 	client, err := oss.New(endpoint, accessKeyID, accessKeySecret)
 	if err != nil {
 		return nil, err
 	}
 
-	return &ossStorage{client, bucket, prefix}, nil
+	return &ossStorage{client: client, bucket: bucket, prefix: prefix}, nil
+}
+
+// NewWithRAMRole creates a new kv.Service backed by Alibaba OSS, authenticated with a RAM role
+// attached to the ECS instance, whose temporary credentials are read from the instance metadata
+// and refreshed before they expire
+func NewWithRAMRole(endpoint, roleName, bucket, prefix string) (kv.Service, error) {
+	role := &ramRole{name: roleName, endpoint: endpoint}
+	if _, err := role.ossClient(); err != nil {
+		return nil, err
+	}
+
+	return &ossStorage{role: role, bucket: bucket, prefix: prefix}, nil
+}
+
+func (o *ossStorage) ossBucket() (*oss.Bucket, error) {
+	client := o.client
+	if o.role != nil {
+		var err error
+		if client, err = o.role.ossClient(); err != nil {
+			return nil, err
+		}
+	}
+	return client.Bucket(o.bucket)
 }
 
 func (o *ossStorage) Set(key string, val []byte) error {
 	objectKey := objectNameWithPrefix(o.prefix, key)
 
-	bucket, err := o.client.Bucket(o.bucket)
+	bucket, err := o.ossBucket()
 	if err != nil {
 		return err
 	}
@@ -43,7 +68,7 @@ func (o *ossStorage) Set(key string, val []byte) error {
 func (o *ossStorage) Get(key string) ([]byte, error) {
 	objectKey := objectNameWithPrefix(o.prefix, key)
 
-	bucket, err := o.client.Bucket(o.bucket)
+	bucket, err := o.ossBucket()
 	if err != nil {
 		return nil, err
 	}
@@ -70,6 +95,21 @@ func (o *ossStorage) Get(key string) ([]byte, error) {
 	return b, nil
 }
 
+func (o *ossStorage) Delete(key string) error {
+	objectKey := objectNameWithPrefix(o.prefix, key)
+
+	bucket, err := o.ossBucket()
+	if err != nil {
+		return err
+	}
+
+	if err := bucket.DeleteObject(objectKey); err != nil {
+		return fmt.Errorf("error deleting key '%s' from OSS bucket '%s': '%s'", objectKey, o.bucket, err.Error())
+	}
+
+	return nil
+}
+
 func objectNameWithPrefix(prefix, key string) string {
 	return fmt.Sprintf("%s%s", prefix, key)
 }
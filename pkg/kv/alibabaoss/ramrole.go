@@ -0,0 +1,72 @@
+package alibabaoss
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+)
+
+// ecsRAMRoleURL is the instance metadata endpoint of the temporary credentials of the RAM roles
+// attached to the ECS instance
+var ecsRAMRoleURL = "http://100.100.100.200/latest/meta-data/ram/security-credentials/"
+
+// ramRoleRefreshMargin is how long before their expiration the credentials are refreshed
+const ramRoleRefreshMargin = 5 * time.Minute
+
+// ramRole creates the OSS clients with the temporary credentials of a RAM role of the ECS instance,
+// a new client is created when the credentials are about to expire
+type ramRole struct {
+	name     string
+	endpoint string
+
+	mu         sync.Mutex
+	client     *oss.Client
+	expiration time.Time
+}
+
+type ramRoleCredentials struct {
+	Code            string
+	AccessKeyID     string `json:"AccessKeyId"`
+	AccessKeySecret string
+	SecurityToken   string
+	Expiration      time.Time
+}
+
+func (r *ramRole) ossClient() (*oss.Client, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.client != nil && time.Now().Add(ramRoleRefreshMargin).Before(r.expiration) {
+		return r.client, nil
+	}
+
+	httpClient := http.Client{Timeout: 10 * time.Second}
+	resp, err := httpClient.Get(ecsRAMRoleURL + r.name)
+	if err != nil {
+		return nil, fmt.Errorf("error getting the credentials of RAM role '%s': %s", r.name, err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error getting the credentials of RAM role '%s': %s", r.name, resp.Status)
+	}
+
+	var credentials ramRoleCredentials
+	if err := json.NewDecoder(resp.Body).Decode(&credentials); err != nil {
+		return nil, fmt.Errorf("error parsing the credentials of RAM role '%s': %s", r.name, err.Error())
+	}
+	if credentials.Code != "Success" {
+		return nil, fmt.Errorf("error getting the credentials of RAM role '%s': %s", r.name, credentials.Code)
+	}
+
+	client, err := oss.New(r.endpoint, credentials.AccessKeyID, credentials.AccessKeySecret, oss.SecurityToken(credentials.SecurityToken))
+	if err != nil {
+		return nil, err
+	}
+	r.client = client
+	r.expiration = credentials.Expiration
+	return client, nil
+}
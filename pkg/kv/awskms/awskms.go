@@ -9,28 +9,46 @@ import (
 	"github.com/banzaicloud/bank-vaults/pkg/kv"
 )
 
+// awsKMS is an implementation of the kv.KMS interface, that encrypts and decrypts data using AWS KMS
 type awsKMS struct {
-	store      kv.Service
 	kmsService *kms.KMS
 
 	kmsID string
 }
 
-var _ kv.Service = &awsKMS{}
+var _ kv.KMS = &awsKMS{}
 
-// NewWithSession creates a new kv.Service encrypted by AWS KMS with and existing AWS Session
-func NewWithSession(sess *session.Session, store kv.Service, kmsID string) (kv.Service, error) {
+// NewKMSWithSession creates a new kv.KMS of AWS KMS with an existing AWS Session, to be composed with
+// a storage by kv.NewEncrypted
+func NewKMSWithSession(sess *session.Session, kmsID string) (kv.KMS, error) {
 	if kmsID == "" {
 		return nil, fmt.Errorf("invalid kmsID specified: '%s'", kmsID)
 	}
 
 	return &awsKMS{
-		store:      store,
 		kmsService: kms.New(sess),
 		kmsID:      kmsID,
 	}, nil
 }
 
+// NewKMS creates a new kv.KMS of AWS KMS, to be composed with a storage by kv.NewEncrypted
+func NewKMS(region string, kmsID string) (kv.KMS, error) {
+
+	sess := session.Must(session.NewSession(aws.NewConfig().WithRegion(region)))
+
+	return NewKMSWithSession(sess, kmsID)
+}
+
+// NewWithSession creates a new kv.Service encrypted by AWS KMS with and existing AWS Session
+func NewWithSession(sess *session.Session, store kv.Service, kmsID string) (kv.Service, error) {
+	kms, err := NewKMSWithSession(sess, kmsID)
+	if err != nil {
+		return nil, err
+	}
+
+	return kv.NewEncrypted(kms, store), nil
+}
+
 // New creates a new kv.Service encrypted by AWS KMS
 func New(store kv.Service, region string, kmsID string) (kv.Service, error) {
 
@@ -39,7 +57,7 @@ func New(store kv.Service, region string, kmsID string) (kv.Service, error) {
 	return NewWithSession(sess, store, kmsID)
 }
 
-func (a *awsKMS) decrypt(cipherText []byte) ([]byte, error) {
+func (a *awsKMS) Decrypt(cipherText []byte) ([]byte, error) {
 	out, err := a.kmsService.Decrypt(&kms.DecryptInput{
 		CiphertextBlob: cipherText,
 		EncryptionContext: map[string]*string{
@@ -47,19 +65,13 @@ func (a *awsKMS) decrypt(cipherText []byte) ([]byte, error) {
 		},
 		GrantTokens: []*string{},
 	})
-	return out.Plaintext, err
-}
-
-func (a *awsKMS) Get(key string) ([]byte, error) {
-	cipherText, err := a.store.Get(key)
 	if err != nil {
 		return nil, err
 	}
-
-	return a.decrypt(cipherText)
+	return out.Plaintext, nil
 }
 
-func (a *awsKMS) encrypt(plainText []byte) ([]byte, error) {
+func (a *awsKMS) Encrypt(plainText []byte) ([]byte, error) {
 
 	out, err := a.kmsService.Encrypt(&kms.EncryptInput{
 		KeyId:     aws.String(a.kmsID),
@@ -69,40 +81,8 @@ func (a *awsKMS) encrypt(plainText []byte) ([]byte, error) {
 		},
 		GrantTokens: []*string{},
 	})
-	return out.CiphertextBlob, err
-}
-
-func (a *awsKMS) Set(key string, val []byte) error {
-	cipherText, err := a.encrypt(val)
-
-	if err != nil {
-		return err
-	}
-
-	return a.store.Set(key, cipherText)
-}
-
-func (a *awsKMS) Test(key string) error {
-	inputString := "test"
-
-	err := a.store.Test(key)
-	if err != nil {
-		return fmt.Errorf("test of backend store failed: %s", err.Error())
-	}
-
-	cipherText, err := a.encrypt([]byte(inputString))
-	if err != nil {
-		return err
-	}
-
-	plainText, err := a.decrypt(cipherText)
 	if err != nil {
-		return err
-	}
-
-	if string(plainText) != inputString {
-		return fmt.Errorf("encrypted and decryped text doesn't match: exp: '%v', act: '%v'", inputString, string(plainText))
+		return nil, err
 	}
-
-	return nil
+	return out.CiphertextBlob, nil
 }
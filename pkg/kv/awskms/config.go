@@ -0,0 +1,41 @@
+package awskms
+
+import (
+	"fmt"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/s3"
+)
+
+// The settings of AWS KMS in a kv.Config, the mode stores the values in AWS S3
+const (
+	Mode      = "aws-kms-s3"
+	KMSName   = "aws-kms"
+	RegionKey = "aws-kms-region"
+	KeyIDKey  = "aws-kms-key-id"
+)
+
+func init() {
+	kv.Register(Mode, NewFromConfig)
+	kv.RegisterKMS(KMSName, NewKMSFromConfig)
+}
+
+// NewKMSFromConfig creates a new kv.KMS of AWS KMS from the settings of the config
+func NewKMSFromConfig(config kv.Config) (kv.KMS, error) {
+	return NewKMS(config.GetString(RegionKey), config.GetString(KeyIDKey))
+}
+
+// NewFromConfig creates a new kv.Service of AWS S3 encrypted by AWS KMS from the settings of the config
+func NewFromConfig(config kv.Config) (kv.Service, error) {
+	store, err := s3.NewFromConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	kms, err := New(store, config.GetString(RegionKey), config.GetString(KeyIDKey))
+	if err != nil {
+		return nil, fmt.Errorf("error creating AWS KMS kv store: %s", err.Error())
+	}
+
+	return kms, nil
+}
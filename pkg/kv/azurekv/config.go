@@ -0,0 +1,35 @@
+package azurekv
+
+import (
+	"fmt"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+)
+
+// The settings of Azure Key Vault in a kv.Config, it is both a mode storing the values as secrets
+// and a KMS encrypting them with an RSA key
+const (
+	Mode       = "azure-key-vault"
+	KMSName    = "azure-key-vault"
+	NameKey    = "azure-key-vault-name"
+	KeyNameKey = "azure-key-vault-key-name"
+)
+
+func init() {
+	kv.Register(Mode, NewFromConfig)
+	kv.RegisterKMS(KMSName, NewKMSFromConfig)
+}
+
+// NewKMSFromConfig creates a new kv.KMS of the key of Azure Key Vault from the settings of the config
+func NewKMSFromConfig(config kv.Config) (kv.KMS, error) {
+	return NewKMS(config.GetString(NameKey), config.GetString(KeyNameKey))
+}
+
+// NewFromConfig creates a new kv.Service backed by Azure Key Vault from the settings of the config
+func NewFromConfig(config kv.Config) (kv.Service, error) {
+	kms, err := New(config.GetString(NameKey))
+	if err != nil {
+		return nil, fmt.Errorf("error creating Azure Key Vault kv store: %s", err.Error())
+	}
+	return kms, nil
+}
@@ -1,6 +1,7 @@
 package azurekv
 
 import (
+	"fmt"
 	"log"
 	"net/url"
 	"os"
@@ -76,29 +77,58 @@ func getAuthorizer(endpoint string) (a autorest.Authorizer, err error) {
 	return
 }
 
-// GetKeyvaultAuthorizer gets an authorizer for the keyvault dataplane
+// UseMSI returns true if the managed identity (MSI) of the VM or the AKS node is used to
+// authenticate instead of a service principal, i.e. AZURE_CLIENT_SECRET isn't set
+func UseMSI() bool {
+	return clientSecret == ""
+}
+
+// GetKeyvaultAuthorizer gets an authorizer for the keyvault dataplane, with the service principal
+// of AZURE_TENANT_ID, AZURE_CLIENT_ID and AZURE_CLIENT_SECRET, or with the managed identity if
+// AZURE_CLIENT_SECRET isn't set: the user assigned identity of AZURE_CLIENT_ID, or the system
+// assigned one if it isn't set either
 func GetKeyvaultAuthorizer() (a autorest.Authorizer, err error) {
 	if keyvaultAuthorizer != nil {
 		return keyvaultAuthorizer, nil
 	}
 
 	vaultEndpoint := "https://vault.azure.net"
-	config, err := adal.NewOAuthConfig(azure.PublicCloud.ActiveDirectoryEndpoint, tenantID)
-	updatedAuthorizeEndpoint, err := url.Parse("https://login.windows.net/" + tenantID + "/oauth2/token")
-	config.AuthorizeEndpoint = *updatedAuthorizeEndpoint
-	if err != nil {
-		return
+	var token *adal.ServicePrincipalToken
+	if UseMSI() {
+		token, err = getMSIToken(vaultEndpoint)
+	} else {
+		token, err = getServicePrincipalToken(vaultEndpoint)
 	}
-
-	token, err := adal.NewServicePrincipalToken(*config, clientID, clientSecret, vaultEndpoint)
 	if err != nil {
 		return a, err
 	}
 	a = autorest.NewBearerAuthorizer(token)
+	keyvaultAuthorizer = a
 
-	if err == nil {
-		keyvaultAuthorizer = a
+	return
+}
+
+func getServicePrincipalToken(resource string) (*adal.ServicePrincipalToken, error) {
+	config, err := adal.NewOAuthConfig(azure.PublicCloud.ActiveDirectoryEndpoint, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	updatedAuthorizeEndpoint, err := url.Parse("https://login.windows.net/" + tenantID + "/oauth2/token")
+	if err != nil {
+		return nil, err
 	}
+	config.AuthorizeEndpoint = *updatedAuthorizeEndpoint
 
-	return
+	return adal.NewServicePrincipalToken(*config, clientID, clientSecret, resource)
+}
+
+func getMSIToken(resource string) (*adal.ServicePrincipalToken, error) {
+	msiEndpoint, err := adal.GetMSIVMEndpoint()
+	if err != nil {
+		return nil, fmt.Errorf("error getting the MSI endpoint: %s", err.Error())
+	}
+	if clientID != "" {
+		return adal.NewServicePrincipalTokenFromMSIWithUserAssignedID(msiEndpoint, resource, clientID)
+	}
+	return adal.NewServicePrincipalTokenFromMSI(msiEndpoint, resource)
 }
@@ -38,8 +38,7 @@ func (a *azureKeyVault) Get(key string) ([]byte, error) {
 	bundle, err := a.client.GetSecret(context.Background(), a.vaultBaseURL, key, "")
 
 	if err != nil {
-		err := err.(autorest.DetailedError)
-		if err.StatusCode == http.StatusNotFound {
+		if detailedErr, ok := err.(autorest.DetailedError); ok && detailedErr.StatusCode == http.StatusNotFound {
 			return nil, kv.NewNotFoundError("error getting secret for key '%s': %s", key, err.Error())
 		}
 		return nil, err
@@ -60,7 +59,19 @@ func (a *azureKeyVault) Set(key string, val []byte) error {
 	return err
 }
 
+func (a *azureKeyVault) Delete(key string) error {
+
+	_, err := a.client.DeleteSecret(context.Background(), a.vaultBaseURL, key)
+
+	return err
+}
+
+// Test checks that the secrets of the key vault can be read with the credentials, the key doesn't
+// have to exist
 func (a *azureKeyVault) Test(key string) error {
-	// TODO: Implement me properly
-	return nil
+	_, err := a.Get(key)
+	if _, ok := err.(*kv.NotFoundError); ok {
+		return nil
+	}
+	return err
 }
@@ -0,0 +1,81 @@
+package azurekv
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/services/keyvault/2016-10-01/keyvault"
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+)
+
+// azureKMS is an implementation of the kv.KMS interface, that encrypts and decrypts data with an RSA
+// key of Azure Key Vault. The cipher texts hold the version of the key which encrypted them, so they
+// can be decrypted after the key has been rotated.
+type azureKMS struct {
+	client       *keyvault.BaseClient
+	vaultBaseURL string
+	keyName      string
+}
+
+var _ kv.KMS = &azureKMS{}
+
+// NewKMS creates a new kv.KMS of the key of Azure Key Vault, to be composed with a storage by
+// kv.NewEncrypted. The RSA-OAEP encryption of the key limits the size of the values, which is enough
+// for the unseal keys and the root token.
+func NewKMS(name, keyName string) (kv.KMS, error) {
+	if keyName == "" {
+		return nil, fmt.Errorf("the name of the Azure Key Vault key is required")
+	}
+	keyClient := keyvault.New()
+	authorizer, err := GetKeyvaultAuthorizer()
+	if err != nil {
+		return nil, err
+	}
+	keyClient.Authorizer = authorizer
+	return &azureKMS{
+		client:       &keyClient,
+		vaultBaseURL: fmt.Sprintf("https://%s.vault.azure.net", name),
+		keyName:      keyName,
+	}, nil
+}
+
+func (a *azureKMS) Encrypt(plainText []byte) ([]byte, error) {
+	value := base64.RawURLEncoding.EncodeToString(plainText)
+	result, err := a.client.Encrypt(context.Background(), a.vaultBaseURL, a.keyName, "", keyvault.KeyOperationsParameters{
+		Algorithm: keyvault.RSAOAEP,
+		Value:     &value,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error encrypting data: %s", err.Error())
+	}
+	if result.Kid == nil || result.Result == nil {
+		return nil, fmt.Errorf("error encrypting data: empty response")
+	}
+
+	// The key ID is https://{vault}.vault.azure.net/keys/{name}/{version}
+	version := path.Base(*result.Kid)
+	return []byte(version + ":" + *result.Result), nil
+}
+
+func (a *azureKMS) Decrypt(cipherText []byte) ([]byte, error) {
+	parts := strings.SplitN(string(cipherText), ":", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("error decrypting data: the cipher text has no key version")
+	}
+	version, value := parts[0], parts[1]
+	result, err := a.client.Decrypt(context.Background(), a.vaultBaseURL, a.keyName, version, keyvault.KeyOperationsParameters{
+		Algorithm: keyvault.RSAOAEP,
+		Value:     &value,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting data: %s", err.Error())
+	}
+	if result.Result == nil {
+		return nil, fmt.Errorf("error decrypting data: empty response")
+	}
+
+	return base64.RawURLEncoding.DecodeString(*result.Result)
+}
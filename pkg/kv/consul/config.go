@@ -0,0 +1,32 @@
+package consul
+
+import (
+	"fmt"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+)
+
+// The settings of the storage of Consul in a kv.Config
+const (
+	StorageName = "consul"
+	AddressKey  = "consul-address"
+	TokenKey    = "consul-token"
+	PrefixKey   = "consul-prefix"
+)
+
+func init() {
+	kv.RegisterStorage(StorageName, NewFromConfig)
+}
+
+// NewFromConfig creates a new kv.Service backed by the KV store of Consul from the settings of the config
+func NewFromConfig(config kv.Config) (kv.Service, error) {
+	c, err := New(Config{
+		Address: config.GetString(AddressKey),
+		Token:   config.GetString(TokenKey),
+		Prefix:  config.GetString(PrefixKey),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating consul kv store: %s", err.Error())
+	}
+	return c, nil
+}
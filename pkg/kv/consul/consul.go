@@ -0,0 +1,143 @@
+package consul
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+)
+
+// Config holds the settings of the Consul agent the values are stored with
+type Config struct {
+	// Address of the Consul agent, CONSUL_HTTP_ADDR or http://127.0.0.1:8500 by default
+	Address string
+	// Token is the ACL token, CONSUL_HTTP_TOKEN by default
+	Token string
+	// Prefix of the keys, e.g. bank-vaults/
+	Prefix string
+	// Timeout of the requests, 10s by default
+	Timeout time.Duration
+}
+
+// consulStorage is a kv.Service storing the values in the KV store of Consul with its HTTP API
+type consulStorage struct {
+	client  *http.Client
+	address string
+	token   string
+	prefix  string
+}
+
+var _ kv.Service = &consulStorage{}
+var _ kv.Creator = &consulStorage{}
+var _ kv.Deleter = &consulStorage{}
+
+// New creates a new kv.Service backed by the KV store of Consul. The values are stored as they
+// are, it should be composed with a KMS by kv.NewEncrypted.
+func New(config Config) (kv.Service, error) {
+	address := config.Address
+	if address == "" {
+		address = os.Getenv("CONSUL_HTTP_ADDR")
+	}
+	if address == "" {
+		address = "http://127.0.0.1:8500"
+	}
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+	token := config.Token
+	if token == "" {
+		token = os.Getenv("CONSUL_HTTP_TOKEN")
+	}
+	timeout := config.Timeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+
+	return &consulStorage{
+		client:  &http.Client{Timeout: timeout},
+		address: strings.TrimSuffix(address, "/"),
+		token:   token,
+		prefix:  config.Prefix,
+	}, nil
+}
+
+// do sends a request to the KV endpoint of the key, and returns the body of the response
+func (c *consulStorage) do(method, key string, query url.Values, body []byte) (int, []byte, error) {
+	u := fmt.Sprintf("%s/v1/kv/%s%s", c.address, c.prefix, key)
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("X-Consul-Token", c.token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return resp.StatusCode, nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	return resp.StatusCode, respBody, nil
+}
+
+func (c *consulStorage) Get(key string) ([]byte, error) {
+	status, value, err := c.do(http.MethodGet, key, url.Values{"raw": {""}}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error getting key '%s' from consul: %s", key, err.Error())
+	}
+	if status == http.StatusNotFound {
+		return nil, kv.NewNotFoundError("key '%s' is not present in consul", key)
+	}
+	return value, nil
+}
+
+func (c *consulStorage) Set(key string, val []byte) error {
+	if _, _, err := c.do(http.MethodPut, key, nil, val); err != nil {
+		return fmt.Errorf("error writing key '%s' to consul: %s", key, err.Error())
+	}
+	return nil
+}
+
+// Create sets the key with the check-and-set index 0, which writes it only if it doesn't exist
+func (c *consulStorage) Create(key string, val []byte) error {
+	_, written, err := c.do(http.MethodPut, key, url.Values{"cas": {"0"}}, val)
+	if err != nil {
+		return fmt.Errorf("error writing key '%s' to consul: %s", key, err.Error())
+	}
+	if strings.TrimSpace(string(written)) != "true" {
+		return kv.NewAlreadyExistsError("key '%s' already exists in consul", key)
+	}
+	return nil
+}
+
+func (c *consulStorage) Delete(key string) error {
+	if _, _, err := c.do(http.MethodDelete, key, nil, nil); err != nil {
+		return fmt.Errorf("error deleting key '%s' from consul: %s", key, err.Error())
+	}
+	return nil
+}
+
+// Test checks that the key can be read, it may be missing
+func (c *consulStorage) Test(key string) error {
+	if _, err := c.Get(key); err != nil {
+		if _, ok := err.(*kv.NotFoundError); !ok {
+			return err
+		}
+	}
+	return nil
+}
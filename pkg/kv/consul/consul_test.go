@@ -0,0 +1,98 @@
+package consul_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/aeskms"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/consul"
+)
+
+// fakeConsul serves the raw reads, the writes (with cas=0) and the deletes of the KV endpoint
+func fakeConsul() *httptest.Server {
+	var mu sync.Mutex
+	values := map[string][]byte{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Header.Get("X-Consul-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		key := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+		switch r.Method {
+		case http.MethodGet:
+			value, ok := values[key]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write(value)
+		case http.MethodPut:
+			if _, ok := values[key]; ok && r.URL.Query().Get("cas") == "0" {
+				w.Write([]byte("false"))
+				return
+			}
+			values[key], _ = ioutil.ReadAll(r.Body)
+			w.Write([]byte("true"))
+		case http.MethodDelete:
+			delete(values, key)
+			w.Write([]byte("true"))
+		}
+	}))
+}
+
+func TestConsul(t *testing.T) {
+	server := fakeConsul()
+	defer server.Close()
+
+	storage, err := consul.New(consul.Config{Address: server.URL, Token: "token", Prefix: "bank-vaults/"})
+	if err != nil {
+		t.Fatalf("error creating the consul store: %s", err.Error())
+	}
+	kms, err := aeskms.NewKMSWithPassphrase("correct horse battery staple")
+	if err != nil {
+		t.Fatalf("error creating the aes kms: %s", err.Error())
+	}
+	store := kv.NewEncrypted(kms, storage)
+
+	if err := store.Test("test"); err != nil {
+		t.Fatalf("test of the consul store failed: %s", err.Error())
+	}
+	if _, err := store.Get("vault-root"); err == nil {
+		t.Fatalf("expected a not found error")
+	} else if _, ok := err.(*kv.NotFoundError); !ok {
+		t.Fatalf("expected a not found error, got %s", err.Error())
+	}
+	if err := kv.Create(store, "vault-root", []byte("token")); err != nil {
+		t.Fatalf("error creating the key: %s", err.Error())
+	}
+	if err := kv.Create(store, "vault-root", []byte("other")); err == nil {
+		t.Errorf("expected an error creating an existing key")
+	} else if _, ok := err.(*kv.AlreadyExistsError); !ok {
+		t.Errorf("expected an already exists error, got %s", err.Error())
+	}
+	if stored, _ := storage.Get("vault-root"); strings.Contains(string(stored), "token") {
+		t.Errorf("the value is stored in plain text")
+	}
+	if value, err := store.Get("vault-root"); err != nil || string(value) != "token" {
+		t.Errorf("expected the decrypted value, got %q, %v", value, err)
+	}
+
+	other, _ := aeskms.NewKMSWithPassphrase("another passphrase")
+	if _, err := kv.NewEncrypted(other, storage).Get("vault-root"); err == nil {
+		t.Errorf("expected an error decrypting with another passphrase")
+	}
+
+	if err := kv.Delete(store, "vault-root"); err != nil {
+		t.Fatalf("error deleting the key: %s", err.Error())
+	}
+	if _, err := store.Get("vault-root"); err == nil {
+		t.Errorf("the key hasn't been deleted")
+	}
+}
@@ -0,0 +1,23 @@
+package dev
+
+import (
+	"fmt"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+)
+
+// Mode is the name of the dev store in a kv.Config, it has no settings
+const Mode = "dev"
+
+func init() {
+	kv.Register(Mode, NewFromConfig)
+}
+
+// NewFromConfig creates a new kv.Service backed by memory, for kv.Register
+func NewFromConfig(config kv.Config) (kv.Service, error) {
+	dev, err := New()
+	if err != nil {
+		return nil, fmt.Errorf("error creating Dev Secret kv store: %s", err.Error())
+	}
+	return dev, nil
+}
@@ -32,10 +32,11 @@ func (d *dev) Set(key string, val []byte) error {
 func (d *dev) Get(key string) ([]byte, error) {
 
 	if key == "vault-root" {
-		return d.rootToken, nil
+		// The caller may wipe the returned value
+		return append([]byte{}, d.rootToken...), nil
 	}
 
-	return nil, kv.NewNotFoundError("key '%s' is not present in secret: %s", key)
+	return nil, kv.NewNotFoundError("key '%s' is not present in the dev store", key)
 }
 
 func (d *dev) Test(key string) error {
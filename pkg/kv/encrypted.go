@@ -0,0 +1,124 @@
+package kv
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/banzaicloud/bank-vaults/pkg/securemem"
+)
+
+// KMS encrypts and decrypts the values of an encrypted store with a key management service, e.g.
+// AWS KMS, Google Cloud KMS or the keys of Azure Key Vault
+type KMS interface {
+	Encrypt(plainText []byte) ([]byte, error)
+	Decrypt(cipherText []byte) ([]byte, error)
+}
+
+// The settings of the encrypted backend, it composes the KMS and the storage registered by these names
+// with RegisterKMS and RegisterStorage
+const (
+	EncryptedMode       = "encrypted"
+	EncryptedKMSKey     = "encrypted-kms"
+	EncryptedStorageKey = "encrypted-storage"
+)
+
+func init() {
+	Register(EncryptedMode, newEncryptedFromConfig)
+}
+
+// encrypted is a kv.Service which encrypts the values with a KMS and stores the cipher texts in
+// another kv.Service
+type encrypted struct {
+	kms     KMS
+	storage Service
+}
+
+var _ Service = &encrypted{}
+var _ Creator = &encrypted{}
+var _ Deleter = &encrypted{}
+
+// NewEncrypted composes a kv.Service of a KMS encrypting the values and a storage persisting the
+// cipher texts, so any KMS can be used with any storage, e.g. Google Cloud KMS with S3. It is a
+// Creator and a Deleter if the storage is.
+func NewEncrypted(kms KMS, storage Service) Service {
+	return &encrypted{kms: kms, storage: storage}
+}
+
+func (e *encrypted) Get(key string) ([]byte, error) {
+	cipherText, err := e.storage.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return e.kms.Decrypt(cipherText)
+}
+
+func (e *encrypted) Set(key string, val []byte) error {
+	cipherText, err := e.kms.Encrypt(val)
+	if err != nil {
+		return err
+	}
+	return e.storage.Set(key, cipherText)
+}
+
+func (e *encrypted) Create(key string, val []byte) error {
+	cipherText, err := e.kms.Encrypt(val)
+	if err != nil {
+		return err
+	}
+	return Create(e.storage, key, cipherText)
+}
+
+func (e *encrypted) Delete(key string) error {
+	return Delete(e.storage, key)
+}
+
+// Test checks the storage, and that a value encrypted by the KMS can be decrypted
+func (e *encrypted) Test(key string) error {
+	inputString := []byte("test")
+
+	if err := e.storage.Test(key); err != nil {
+		return fmt.Errorf("test of backend store failed: %s", err.Error())
+	}
+
+	cipherText, err := e.kms.Encrypt(inputString)
+	if err != nil {
+		return err
+	}
+
+	plainText, err := e.kms.Decrypt(cipherText)
+	if err != nil {
+		return err
+	}
+	defer securemem.Wipe(plainText)
+
+	if !bytes.Equal(plainText, inputString) {
+		return fmt.Errorf("encrypted and decrypted text doesn't match")
+	}
+	return nil
+}
+
+// newEncryptedFromConfig composes the KMS of EncryptedKMSKey with the storage of EncryptedStorageKey
+func newEncryptedFromConfig(config Config) (Service, error) {
+	factoriesMu.RLock()
+	kmsFactory, kmsOK := kmsFactories[config.GetString(EncryptedKMSKey)]
+	storageFactory, storageOK := storageFactories[config.GetString(EncryptedStorageKey)]
+	factoriesMu.RUnlock()
+
+	if !kmsOK {
+		return nil, fmt.Errorf("unsupported %s: '%s'", EncryptedKMSKey, config.GetString(EncryptedKMSKey))
+	}
+	if !storageOK {
+		return nil, fmt.Errorf("unsupported %s: '%s'", EncryptedStorageKey, config.GetString(EncryptedStorageKey))
+	}
+
+	kms, err := kmsFactory(config)
+	if err != nil {
+		return nil, fmt.Errorf("error creating %s kms: %s", config.GetString(EncryptedKMSKey), err.Error())
+	}
+	storage, err := storageFactory(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewEncrypted(kms, storage), nil
+}
@@ -0,0 +1,66 @@
+package kv_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/kvtest"
+)
+
+// reverseKMS "encrypts" by reversing the value behind a prefix
+type reverseKMS struct{}
+
+func reverse(value []byte) []byte {
+	result := make([]byte, len(value))
+	for i, b := range value {
+		result[len(value)-1-i] = b
+	}
+	return result
+}
+
+func (reverseKMS) Encrypt(plainText []byte) ([]byte, error) {
+	return append([]byte("enc:"), reverse(plainText)...), nil
+}
+
+func (reverseKMS) Decrypt(cipherText []byte) ([]byte, error) {
+	if !bytes.HasPrefix(cipherText, []byte("enc:")) {
+		return nil, fmt.Errorf("not encrypted: %q", cipherText)
+	}
+	return reverse(cipherText[len("enc:"):]), nil
+}
+
+func TestEncrypted(t *testing.T) {
+	storage := kvtest.New()
+	store := kv.NewEncrypted(reverseKMS{}, storage)
+
+	if err := store.Test("test"); err != nil {
+		t.Fatalf("test of the encrypted store failed: %s", err.Error())
+	}
+
+	if err := store.Set("root", []byte("token")); err != nil {
+		t.Fatalf("error setting the key: %s", err.Error())
+	}
+	if stored := string(storage.Value("root")); stored != "enc:nekot" {
+		t.Errorf("the storage should hold the cipher text, got %q", stored)
+	}
+	if value, err := store.Get("root"); err != nil || string(value) != "token" {
+		t.Errorf("expected the decrypted value, got %q, %v", value, err)
+	}
+
+	if err := kv.Create(store, "root", []byte("other")); err == nil {
+		t.Errorf("expected an error creating an existing key")
+	} else if _, ok := err.(*kv.AlreadyExistsError); !ok {
+		t.Errorf("expected an AlreadyExistsError, got %s", err.Error())
+	}
+
+	if err := kv.Delete(store, "root"); err != nil {
+		t.Fatalf("error deleting the key: %s", err.Error())
+	}
+	if _, err := store.Get("root"); err == nil {
+		t.Errorf("expected a NotFoundError of the deleted key")
+	} else if _, ok := err.(*kv.NotFoundError); !ok {
+		t.Errorf("expected a NotFoundError, got %s", err.Error())
+	}
+}
@@ -0,0 +1,47 @@
+package etcd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+)
+
+// The settings of the storage of etcd in a kv.Config, the endpoints are separated by commas
+const (
+	StorageName  = "etcd"
+	EndpointsKey = "etcd-endpoints"
+	PrefixKey    = "etcd-prefix"
+	CACertKey    = "etcd-ca-cert"
+	CertKey      = "etcd-cert"
+	KeyKey       = "etcd-key"
+	UsernameKey  = "etcd-username"
+	PasswordKey  = "etcd-password"
+)
+
+func init() {
+	kv.RegisterStorage(StorageName, NewFromConfig)
+}
+
+// NewFromConfig creates a new kv.Service backed by etcd v3 from the settings of the config
+func NewFromConfig(config kv.Config) (kv.Service, error) {
+	var endpoints []string
+	for _, endpoint := range strings.Split(config.GetString(EndpointsKey), ",") {
+		if endpoint = strings.TrimSpace(endpoint); endpoint != "" {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	e, err := New(Config{
+		Endpoints: endpoints,
+		Prefix:    config.GetString(PrefixKey),
+		CACert:    config.GetString(CACertKey),
+		Cert:      config.GetString(CertKey),
+		Key:       config.GetString(KeyKey),
+		Username:  config.GetString(UsernameKey),
+		Password:  config.GetString(PasswordKey),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating etcd kv store: %s", err.Error())
+	}
+	return e, nil
+}
@@ -0,0 +1,159 @@
+package etcd
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/coreos/etcd/clientv3"
+)
+
+// Config holds the settings of the etcd v3 cluster the values are stored in
+type Config struct {
+	// Endpoints of the cluster, e.g. https://etcd-0:2379
+	Endpoints []string
+	// Prefix of the keys, e.g. /bank-vaults/
+	Prefix string
+	// CACert, Cert and Key are the PEM files of the TLS connection, the client certificate is optional
+	CACert string
+	Cert   string
+	Key    string
+	// Username and Password of the etcd authentication, if it is enabled
+	Username string
+	Password string
+	// Timeout of the requests, 10s by default
+	Timeout time.Duration
+}
+
+// etcdStorage is a kv.Service storing the values in etcd v3
+type etcdStorage struct {
+	client  *clientv3.Client
+	prefix  string
+	timeout time.Duration
+}
+
+var _ kv.Service = &etcdStorage{}
+var _ kv.Creator = &etcdStorage{}
+var _ kv.Deleter = &etcdStorage{}
+
+// New creates a new kv.Service backed by etcd v3. The values are stored as they are, it should be
+// composed with a KMS by kv.NewEncrypted.
+func New(config Config) (kv.Service, error) {
+	if len(config.Endpoints) == 0 {
+		return nil, fmt.Errorf("at least one etcd endpoint is required")
+	}
+	timeout := config.Timeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+
+	clientConfig := clientv3.Config{
+		Endpoints:   config.Endpoints,
+		DialTimeout: timeout,
+		Username:    config.Username,
+		Password:    config.Password,
+	}
+	if config.CACert != "" || config.Cert != "" {
+		tlsConfig, err := tlsConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		clientConfig.TLS = tlsConfig
+	}
+
+	client, err := clientv3.New(clientConfig)
+	if err != nil {
+		return nil, fmt.Errorf("error creating etcd client: %s", err.Error())
+	}
+	return &etcdStorage{client: client, prefix: config.Prefix, timeout: timeout}, nil
+}
+
+func tlsConfig(config Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+	if config.CACert != "" {
+		ca, err := ioutil.ReadFile(config.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("error reading etcd CA certificate: %s", err.Error())
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in %s", config.CACert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if config.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(config.Cert, config.Key)
+		if err != nil {
+			return nil, fmt.Errorf("error reading etcd client certificate: %s", err.Error())
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+func (e *etcdStorage) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), e.timeout)
+}
+
+func (e *etcdStorage) Get(key string) ([]byte, error) {
+	ctx, cancel := e.context()
+	defer cancel()
+	resp, err := e.client.Get(ctx, e.prefix+key)
+	if err != nil {
+		return nil, fmt.Errorf("error getting key '%s' from etcd: %s", key, err.Error())
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, kv.NewNotFoundError("key '%s' is not present in etcd", key)
+	}
+	return resp.Kvs[0].Value, nil
+}
+
+func (e *etcdStorage) Set(key string, val []byte) error {
+	ctx, cancel := e.context()
+	defer cancel()
+	if _, err := e.client.Put(ctx, e.prefix+key, string(val)); err != nil {
+		return fmt.Errorf("error writing key '%s' to etcd: %s", key, err.Error())
+	}
+	return nil
+}
+
+// Create puts the key in a transaction conditional on its creation revision being 0, which means
+// it doesn't exist
+func (e *etcdStorage) Create(key string, val []byte) error {
+	ctx, cancel := e.context()
+	defer cancel()
+	resp, err := e.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(e.prefix+key), "=", 0)).
+		Then(clientv3.OpPut(e.prefix+key, string(val))).
+		Commit()
+	if err != nil {
+		return fmt.Errorf("error writing key '%s' to etcd: %s", key, err.Error())
+	}
+	if !resp.Succeeded {
+		return kv.NewAlreadyExistsError("key '%s' already exists in etcd", key)
+	}
+	return nil
+}
+
+func (e *etcdStorage) Delete(key string) error {
+	ctx, cancel := e.context()
+	defer cancel()
+	if _, err := e.client.Delete(ctx, e.prefix+key); err != nil {
+		return fmt.Errorf("error deleting key '%s' from etcd: %s", key, err.Error())
+	}
+	return nil
+}
+
+// Test checks that the key can be read, it may be missing
+func (e *etcdStorage) Test(key string) error {
+	if _, err := e.Get(key); err != nil {
+		if _, ok := err.(*kv.NotFoundError); !ok {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package file
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/gpg"
+)
+
+// The settings of the file store in a kv.Config, the GPG keys are separated by commas
+const (
+	Mode       = "file"
+	PathKey    = "file-path"
+	GPGKeysKey = "file-gpg-keys"
+	GPGHomeKey = "file-gpg-home"
+)
+
+func init() {
+	kv.Register(Mode, NewFromConfig)
+}
+
+// NewFromConfig creates a new kv.Service storing the values in the files of the directory of the
+// config, encrypted to its GPG keys if there are any
+func NewFromConfig(config kv.Config) (kv.Service, error) {
+	f, err := New(config.GetString(PathKey))
+	if err != nil {
+		return nil, fmt.Errorf("error creating file kv store: %s", err.Error())
+	}
+
+	var keys []string
+	for _, key := range strings.Split(config.GetString(GPGKeysKey), ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return f, nil
+	}
+
+	kms, err := gpg.NewKMS(gpg.Config{Recipients: keys, Home: config.GetString(GPGHomeKey)})
+	if err != nil {
+		return nil, fmt.Errorf("error creating gpg kms: %s", err.Error())
+	}
+	return kv.NewEncrypted(kms, f), nil
+}
@@ -0,0 +1,111 @@
+package file
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+)
+
+// file is a kv.Service storing every key in a file of a directory, for local development and CI
+type file struct {
+	dir string
+}
+
+var _ kv.Service = &file{}
+var _ kv.Creator = &file{}
+var _ kv.Deleter = &file{}
+
+// New creates a new kv.Service storing the keys in the files of the directory, which is created
+// if it doesn't exist. Only the owner can read the files, the values are stored as they are, so
+// it should be composed with a KMS by kv.NewEncrypted outside of development, e.g. with GPG.
+func New(dir string) (kv.Service, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("the directory of the file kv store is required")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("error creating directory %s: %s", dir, err.Error())
+	}
+	return &file{dir: dir}, nil
+}
+
+// path returns the file of the key, the keys with a prefix (e.g. prod-eu/vault-root) are stored
+// in subdirectories
+func (f *file) path(key string) (string, error) {
+	name := filepath.Clean(filepath.FromSlash(key))
+	if key == "" || filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid key name '%s'", key)
+	}
+	return filepath.Join(f.dir, name), nil
+}
+
+func (f *file) Get(key string) ([]byte, error) {
+	path, err := f.path(key)
+	if err != nil {
+		return nil, err
+	}
+	value, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, kv.NewNotFoundError("key '%s' is not present in %s", key, f.dir)
+	} else if err != nil {
+		return nil, fmt.Errorf("error reading key '%s': %s", key, err.Error())
+	}
+	return value, nil
+}
+
+func (f *file) Set(key string, val []byte) error {
+	return f.write(key, val, os.O_TRUNC)
+}
+
+// Create opens the file exclusively, so it fails if the key exists
+func (f *file) Create(key string, val []byte) error {
+	return f.write(key, val, os.O_EXCL)
+}
+
+func (f *file) write(key string, val []byte, flag int) error {
+	path, err := f.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("error creating directory of key '%s': %s", key, err.Error())
+	}
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|flag, 0600)
+	if os.IsExist(err) {
+		return kv.NewAlreadyExistsError("key '%s' already exists in %s", key, f.dir)
+	} else if err != nil {
+		return fmt.Errorf("error writing key '%s': %s", key, err.Error())
+	}
+	if _, err := out.Write(val); err != nil {
+		out.Close()
+		return fmt.Errorf("error writing key '%s': %s", key, err.Error())
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("error writing key '%s': %s", key, err.Error())
+	}
+	return nil
+}
+
+func (f *file) Delete(key string) error {
+	path, err := f.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error deleting key '%s': %s", key, err.Error())
+	}
+	return nil
+}
+
+// Test checks that the directory is writable
+func (f *file) Test(key string) error {
+	test, err := ioutil.TempFile(f.dir, ".test")
+	if err != nil {
+		return fmt.Errorf("the directory %s is not writable: %s", f.dir, err.Error())
+	}
+	test.Close()
+	return os.Remove(test.Name())
+}
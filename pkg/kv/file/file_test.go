@@ -0,0 +1,120 @@
+package file_test
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/file"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/gpg"
+)
+
+func TestFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bank-vaults-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := file.New(filepath.Join(dir, "keys"))
+	if err != nil {
+		t.Fatalf("error creating the file store: %s", err.Error())
+	}
+	if err := store.Test("test"); err != nil {
+		t.Fatalf("test of the file store failed: %s", err.Error())
+	}
+	if _, err := store.Get("prod/vault-root"); err == nil {
+		t.Fatalf("expected a not found error")
+	} else if _, ok := err.(*kv.NotFoundError); !ok {
+		t.Fatalf("expected a not found error, got %s", err.Error())
+	}
+	if err := kv.Create(store, "prod/vault-root", []byte("token")); err != nil {
+		t.Fatalf("error creating the key: %s", err.Error())
+	}
+	if err := kv.Create(store, "prod/vault-root", []byte("other")); err == nil {
+		t.Errorf("expected an error creating an existing key")
+	} else if _, ok := err.(*kv.AlreadyExistsError); !ok {
+		t.Errorf("expected an already exists error, got %s", err.Error())
+	}
+	if info, err := os.Stat(filepath.Join(dir, "keys", "prod", "vault-root")); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("expected a file only the owner can read, got %v, %v", info, err)
+	}
+	if err := store.Set("../outside", []byte("value")); err == nil {
+		t.Errorf("expected an error writing a key outside of the directory")
+	}
+	if err := kv.Delete(store, "prod/vault-root"); err != nil {
+		t.Fatalf("error deleting the key: %s", err.Error())
+	}
+	if _, err := store.Get("prod/vault-root"); err == nil {
+		t.Errorf("the key hasn't been deleted")
+	}
+}
+
+type testConfig map[string]string
+
+func (c testConfig) GetString(key string) string { return c[key] }
+
+func TestFileRegistered(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bank-vaults-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := kv.NewFromConfig(testConfig{kv.ModeKey: file.Mode, file.PathKey: dir})
+	if err != nil {
+		t.Fatalf("error creating the file store from the config: %s", err.Error())
+	}
+	if err := store.Set("vault-root", []byte("token")); err != nil {
+		t.Fatalf("error setting the value: %s", err.Error())
+	}
+	if value, err := ioutil.ReadFile(filepath.Join(dir, "vault-root")); err != nil || string(value) != "token" {
+		t.Errorf("the value isn't stored in the directory of the config: %q, %v", value, err)
+	}
+}
+
+func TestFileWithGPG(t *testing.T) {
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg is not installed")
+	}
+	dir, err := ioutil.TempDir("", "bank-vaults-gpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	home := filepath.Join(dir, "gnupg")
+	os.Mkdir(home, 0700)
+	gen := exec.Command("gpg", "--homedir", home, "--batch", "--passphrase", "", "--quick-gen-key", "operator@example.com", "default", "default", "never")
+	if out, err := gen.CombinedOutput(); err != nil {
+		t.Skipf("error generating a GPG key: %s", out)
+	}
+	defer exec.Command("gpgconf", "--homedir", home, "--kill", "gpg-agent").Run()
+	public, err := exec.Command("gpg", "--homedir", home, "--batch", "--armor", "--export", "operator@example.com").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(dir, "operator.asc")
+	ioutil.WriteFile(keyFile, public, 0600)
+
+	kms, err := gpg.NewKMS(gpg.Config{Recipients: []string{keyFile}, Home: home})
+	if err != nil {
+		t.Fatalf("error creating the gpg kms: %s", err.Error())
+	}
+	storage, _ := file.New(filepath.Join(dir, "keys"))
+	store := kv.NewEncrypted(kms, storage)
+
+	if err := store.Set("vault-root", []byte("token")); err != nil {
+		t.Fatalf("error setting the key: %s", err.Error())
+	}
+	if stored, _ := storage.Get("vault-root"); strings.Contains(string(stored), "token") {
+		t.Errorf("the value is stored in plain text")
+	}
+	if value, err := store.Get("vault-root"); err != nil || string(value) != "token" {
+		t.Errorf("expected the decrypted value, got %q, %v", value, err)
+	}
+}
@@ -10,19 +10,17 @@ import (
 	cloudkms "google.golang.org/api/cloudkms/v1"
 )
 
-// googleKms is an implementation of the kv.Service interface, that encrypts
-// and decrypts data using Google Cloud KMS before storing into another kv
-// backend.
+// googleKms is an implementation of the kv.KMS interface, that encrypts
+// and decrypts data using Google Cloud KMS.
 type googleKms struct {
 	svc     *cloudkms.Service
-	store   kv.Service
 	keyPath string
 }
 
-var _ kv.Service = &googleKms{}
+var _ kv.KMS = &googleKms{}
 
-// New creates a new kv.Service encrypted by Google KMS
-func New(store kv.Service, project, location, keyring, cryptoKey string) (kv.Service, error) {
+// NewKMS creates a new kv.KMS of Google KMS, to be composed with a storage by kv.NewEncrypted
+func NewKMS(project, location, keyring, cryptoKey string) (kv.KMS, error) {
 	ctx := context.Background()
 	client, err := google.DefaultClient(ctx, cloudkms.CloudPlatformScope)
 
@@ -37,13 +35,22 @@ func New(store kv.Service, project, location, keyring, cryptoKey string) (kv.Ser
 	}
 
 	return &googleKms{
-		store:   store,
 		svc:     kmsService,
 		keyPath: fmt.Sprintf("projects/%s/locations/%s/keyRings/%s/cryptoKeys/%s", project, location, keyring, cryptoKey),
 	}, nil
 }
 
-func (g *googleKms) encrypt(s []byte) ([]byte, error) {
+// New creates a new kv.Service encrypted by Google KMS
+func New(store kv.Service, project, location, keyring, cryptoKey string) (kv.Service, error) {
+	kms, err := NewKMS(project, location, keyring, cryptoKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return kv.NewEncrypted(kms, store), nil
+}
+
+func (g *googleKms) Encrypt(s []byte) ([]byte, error) {
 	resp, err := g.svc.Projects.Locations.KeyRings.CryptoKeys.Encrypt(g.keyPath, &cloudkms.EncryptRequest{
 		Plaintext: base64.StdEncoding.EncodeToString(s),
 	}).Do()
@@ -55,7 +62,7 @@ func (g *googleKms) encrypt(s []byte) ([]byte, error) {
 	return base64.StdEncoding.DecodeString(resp.Ciphertext)
 }
 
-func (g *googleKms) decrypt(s []byte) ([]byte, error) {
+func (g *googleKms) Decrypt(s []byte) ([]byte, error) {
 	resp, err := g.svc.Projects.Locations.KeyRings.CryptoKeys.Decrypt(g.keyPath, &cloudkms.DecryptRequest{
 		Ciphertext: base64.StdEncoding.EncodeToString(s),
 	}).Do()
@@ -66,28 +73,3 @@ func (g *googleKms) decrypt(s []byte) ([]byte, error) {
 
 	return base64.StdEncoding.DecodeString(resp.Plaintext)
 }
-
-func (g *googleKms) Get(key string) ([]byte, error) {
-	cipherText, err := g.store.Get(key)
-
-	if err != nil {
-		return nil, err
-	}
-
-	return g.decrypt(cipherText)
-}
-
-func (g *googleKms) Set(key string, val []byte) error {
-	cipherText, err := g.encrypt(val)
-
-	if err != nil {
-		return err
-	}
-
-	return g.store.Set(key, cipherText)
-}
-
-func (g *googleKms) Test(key string) error {
-	// TODO: Implement me properly
-	return nil
-}
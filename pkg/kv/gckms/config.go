@@ -0,0 +1,54 @@
+package gckms
+
+import (
+	"fmt"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/gcs"
+)
+
+// The settings of Google Cloud KMS in a kv.Config, the mode stores the values in Google Cloud Storage
+const (
+	Mode         = "google-cloud-kms-gcs"
+	KMSName      = "google-cloud-kms"
+	ProjectKey   = "google-cloud-kms-project"
+	LocationKey  = "google-cloud-kms-location"
+	KeyRingKey   = "google-cloud-kms-key-ring"
+	CryptoKeyKey = "google-cloud-kms-crypto-key"
+)
+
+func init() {
+	kv.Register(Mode, NewFromConfig)
+	kv.RegisterKMS(KMSName, NewKMSFromConfig)
+}
+
+// NewKMSFromConfig creates a new kv.KMS of Google KMS from the settings of the config
+func NewKMSFromConfig(config kv.Config) (kv.KMS, error) {
+	return NewKMS(
+		config.GetString(ProjectKey),
+		config.GetString(LocationKey),
+		config.GetString(KeyRingKey),
+		config.GetString(CryptoKeyKey),
+	)
+}
+
+// NewFromConfig creates a new kv.Service of Google Cloud Storage encrypted by Google KMS from the
+// settings of the config
+func NewFromConfig(config kv.Config) (kv.Service, error) {
+	g, err := gcs.NewFromConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	kms, err := New(g,
+		config.GetString(ProjectKey),
+		config.GetString(LocationKey),
+		config.GetString(KeyRingKey),
+		config.GetString(CryptoKeyKey),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error creating google cloud kms kv store: %s", err.Error())
+	}
+
+	return kms, nil
+}
@@ -0,0 +1,27 @@
+package gcs
+
+import (
+	"fmt"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+)
+
+// The settings of the storage of Google Cloud Storage in a kv.Config
+const (
+	StorageName = "google-cloud-storage"
+	BucketKey   = "google-cloud-storage-bucket"
+	PrefixKey   = "google-cloud-storage-prefix"
+)
+
+func init() {
+	kv.RegisterStorage(StorageName, NewFromConfig)
+}
+
+// NewFromConfig creates a new kv.Service backed by Google GCS from the settings of the config
+func NewFromConfig(config kv.Config) (kv.Service, error) {
+	g, err := New(config.GetString(BucketKey), config.GetString(PrefixKey))
+	if err != nil {
+		return nil, fmt.Errorf("error creating google cloud storage kv store: %s", err.Error())
+	}
+	return g, nil
+}
@@ -4,9 +4,11 @@ import (
 	"context"
 	"fmt"
 	"io/ioutil"
+	"net/http"
 
 	"cloud.google.com/go/storage"
 	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"google.golang.org/api/googleapi"
 )
 
 type gcsStorage struct {
@@ -23,6 +25,11 @@ func New(bucket, prefix string) (kv.Service, error) {
 		return nil, fmt.Errorf("error creating gcs client: %s", err.Error())
 	}
 
+	return NewWithClient(cl, bucket, prefix)
+}
+
+// NewWithClient creates a new kv.Service backed by Google GCS with the given client, e.g. of an emulator
+func NewWithClient(cl *storage.Client, bucket, prefix string) (kv.Service, error) {
 	return &gcsStorage{cl, bucket, prefix}, nil
 }
 
@@ -37,6 +44,25 @@ func (g *gcsStorage) Set(key string, val []byte) error {
 	return w.Close()
 }
 
+// Create writes the object with a does-not-exist precondition, so only one of the concurrent writers can create it
+func (g *gcsStorage) Create(key string, val []byte) error {
+	ctx := context.Background()
+	n := objectNameWithPrefix(g.prefix, key)
+	w := g.cl.Bucket(g.bucket).Object(n).If(storage.Conditions{DoesNotExist: true}).NewWriter(ctx)
+	if _, err := w.Write(val); err != nil {
+		return fmt.Errorf("error writing key '%s' to gcs bucket '%s'", n, g.bucket)
+	}
+
+	if err := w.Close(); err != nil {
+		if gerr, ok := err.(*googleapi.Error); ok && gerr.Code == http.StatusPreconditionFailed {
+			return kv.NewAlreadyExistsError("key '%s' already exists in gcs bucket '%s'", n, g.bucket)
+		}
+		return fmt.Errorf("error writing key '%s' to gcs bucket '%s': %s", n, g.bucket, err.Error())
+	}
+
+	return nil
+}
+
 func (g *gcsStorage) Get(key string) ([]byte, error) {
 	ctx := context.Background()
 	n := objectNameWithPrefix(g.prefix, key)
@@ -60,6 +86,16 @@ func (g *gcsStorage) Get(key string) ([]byte, error) {
 	return b, nil
 }
 
+func (g *gcsStorage) Delete(key string) error {
+	n := objectNameWithPrefix(g.prefix, key)
+
+	if err := g.cl.Bucket(g.bucket).Object(n).Delete(context.Background()); err != nil && err != storage.ErrObjectNotExist {
+		return fmt.Errorf("error deleting key '%s' from gcs bucket '%s': %s", n, g.bucket, err.Error())
+	}
+
+	return nil
+}
+
 func objectNameWithPrefix(prefix, key string) string {
 	return fmt.Sprintf("%s%s", prefix, key)
 }
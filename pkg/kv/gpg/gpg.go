@@ -0,0 +1,124 @@
+package gpg
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+)
+
+// Config holds the settings of the GPG encryption
+type Config struct {
+	// Recipients are the files of the public keys the values are encrypted to, binary, ASCII
+	// armored or base64 encoded (like the keys given to vault operator init -pgp-keys)
+	Recipients []string
+	// Home is the GnuPG home directory holding the private key to decrypt the values with,
+	// GNUPGHOME or ~/.gnupg by default
+	Home string
+	// Binary is the gpg executable, gpg by default
+	Binary string
+}
+
+// gpgKMS is an implementation of the kv.KMS interface, that encrypts the values to every recipient
+// with the gpg executable, so each of them can decrypt the values with their own private key
+type gpgKMS struct {
+	recipients [][]byte
+	home       string
+	binary     string
+}
+
+var _ kv.KMS = &gpgKMS{}
+
+// NewKMS creates a new kv.KMS encrypting the values to the public keys of the recipients with gpg,
+// to be composed with a storage by kv.NewEncrypted
+func NewKMS(config Config) (kv.KMS, error) {
+	if len(config.Recipients) == 0 {
+		return nil, fmt.Errorf("at least one GPG recipient key is required")
+	}
+	binary := config.Binary
+	if binary == "" {
+		binary = "gpg"
+	}
+	if _, err := exec.LookPath(binary); err != nil {
+		return nil, fmt.Errorf("error finding gpg: %s", err.Error())
+	}
+
+	recipients := [][]byte{}
+	for _, file := range config.Recipients {
+		key, err := readKey(file)
+		if err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, key)
+	}
+	return &gpgKMS{recipients: recipients, home: config.Home, binary: binary}, nil
+}
+
+// readKey reads a public key file, decoding it if it is base64 encoded
+func readKey(file string) ([]byte, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading GPG key: %s", err.Error())
+	}
+	if key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data))); err == nil {
+		return key, nil
+	}
+	return data, nil
+}
+
+// gpg runs the gpg executable non-interactively with the input
+func (g *gpgKMS) gpg(input []byte, args ...string) ([]byte, error) {
+	args = append([]string{"--batch", "--no-tty", "--quiet"}, args...)
+	if g.home != "" {
+		args = append([]string{"--homedir", g.home}, args...)
+	}
+	cmd := exec.Command(g.binary, args...)
+	cmd.Stdin = bytes.NewReader(input)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s: %s", err.Error(), strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// Encrypt encrypts the value to the recipients, their keys are passed in files, so they don't
+// have to be imported into the keyring
+func (g *gpgKMS) Encrypt(plainText []byte) ([]byte, error) {
+	dir, err := ioutil.TempDir("", "bank-vaults-gpg")
+	if err != nil {
+		return nil, fmt.Errorf("error creating directory of the GPG keys: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	args := []string{"--trust-model", "always", "--encrypt"}
+	for i, key := range g.recipients {
+		file := filepath.Join(dir, fmt.Sprintf("recipient-%d", i))
+		if err := ioutil.WriteFile(file, key, 0600); err != nil {
+			return nil, fmt.Errorf("error writing GPG key: %s", err.Error())
+		}
+		args = append(args, "--recipient-file", file)
+	}
+
+	cipherText, err := g.gpg(plainText, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error encrypting value with gpg: %s", err.Error())
+	}
+	return cipherText, nil
+}
+
+// Decrypt decrypts the value with the private key of the recipient in the GnuPG home directory
+func (g *gpgKMS) Decrypt(cipherText []byte) ([]byte, error) {
+	plainText, err := g.gpg(cipherText, "--decrypt")
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting value with gpg: %s", err.Error())
+	}
+	return plainText, nil
+}
@@ -0,0 +1,29 @@
+package k8s
+
+import (
+	"fmt"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+)
+
+// The settings of the K8S Secret in a kv.Config, it is both a mode and a storage of the encrypted mode
+const (
+	Mode         = "k8s"
+	StorageName  = "k8s"
+	NamespaceKey = "k8s-secret-namespace"
+	SecretKey    = "k8s-secret-name"
+)
+
+func init() {
+	kv.Register(Mode, NewFromConfig)
+	kv.RegisterStorage(StorageName, NewFromConfig)
+}
+
+// NewFromConfig creates a new kv.Service backed by K8S Secrets from the settings of the config
+func NewFromConfig(config kv.Config) (kv.Service, error) {
+	k8s, err := New(config.GetString(NamespaceKey), config.GetString(SecretKey))
+	if err != nil {
+		return nil, fmt.Errorf("error creating K8S Secret kv store: %s", err.Error())
+	}
+	return k8s, nil
+}
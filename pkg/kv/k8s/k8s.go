@@ -24,8 +24,16 @@ type k8sStorage struct {
 	ownerReference *metav1.OwnerReference
 }
 
-// New creates a new kv.Service backed by K8S Secrets
+// New creates a new kv.Service backed by K8S Secrets, the namespace defaults to POD_NAMESPACE (the
+// namespace of the Pod when running in the cluster)
 func New(namespace, secret string) (service kv.Service, err error) {
+	if namespace == "" {
+		namespace = os.Getenv("POD_NAMESPACE")
+	}
+	if namespace == "" || secret == "" {
+		return nil, fmt.Errorf("the namespace and the name of the k8s secret are required")
+	}
+
 	kubeconfig := os.Getenv(clientcmd.RecommendedConfigPathEnvVar)
 	var config *rest.Config
 
@@ -59,33 +67,75 @@ func New(namespace, secret string) (service kv.Service, err error) {
 	return
 }
 
-func (k *k8sStorage) Set(key string, val []byte) error {
-	secret, err := k.cl.CoreV1().Secrets(k.namespace).Get(k.secret, metav1.GetOptions{})
-
-	if errors.IsNotFound(err) {
-		secret := &v1.Secret{
-			ObjectMeta: metav1.ObjectMeta{
-				Namespace: k.namespace,
-				Name:      k.secret,
-			},
-			Data: map[string][]byte{key: val},
+// updateAttempts is the number of times a write retries after a concurrent write of the secret
+const updateAttempts = 5
+
+// update applies the change to the data of the secret (created if it doesn't exist yet, unless
+// create is false, then there is nothing to change) and writes it. The resourceVersion of the
+// secret makes the write fail if it has been changed concurrently (optimistic concurrency), in
+// which case the secret is read and the change is applied again.
+func (k *k8sStorage) update(key string, create bool, change func(data map[string][]byte) error) error {
+	var err error
+	for i := 0; i < updateAttempts; i++ {
+		var secret *v1.Secret
+		secret, err = k.cl.CoreV1().Secrets(k.namespace).Get(k.secret, metav1.GetOptions{})
+
+		if errors.IsNotFound(err) && !create {
+			return nil
+		} else if errors.IsNotFound(err) {
+			secret = &v1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: k.namespace,
+					Name:      k.secret,
+				},
+				Data: map[string][]byte{},
+			}
+			if k.ownerReference != nil {
+				secret.ObjectMeta.SetOwnerReferences([]metav1.OwnerReference{*k.ownerReference})
+			}
+			if err = change(secret.Data); err != nil {
+				return err
+			}
+			_, err = k.cl.CoreV1().Secrets(k.namespace).Create(secret)
+		} else if err == nil {
+			if secret.Data == nil {
+				secret.Data = map[string][]byte{}
+			}
+			if err = change(secret.Data); err != nil {
+				return err
+			}
+			_, err = k.cl.CoreV1().Secrets(k.namespace).Update(secret)
+		} else {
+			return fmt.Errorf("error checking if '%s' secret exists: '%s'", k.secret, err.Error())
 		}
-		if k.ownerReference != nil {
-			secret.ObjectMeta.SetOwnerReferences([]metav1.OwnerReference{*k.ownerReference})
+
+		if err == nil {
+			return nil
+		} else if !errors.IsAlreadyExists(err) && !errors.IsConflict(err) {
+			break
 		}
-		secret, err = k.cl.CoreV1().Secrets(k.namespace).Create(secret)
-	} else if err == nil {
-		secret.Data[key] = val
-		secret, err = k.cl.CoreV1().Secrets(k.namespace).Update(secret)
-		//reflect.DeepEqual()
-	} else {
-		return fmt.Errorf("error checking if '%s' secret exists: '%s'", k.secret, err.Error())
 	}
 
-	if err != nil {
-		return fmt.Errorf("error writing secret key '%s' into secret '%s': '%s'", key, k.secret, err.Error())
-	}
-	return nil
+	return fmt.Errorf("error writing secret key '%s' into secret '%s': '%s'", key, k.secret, err.Error())
+}
+
+func (k *k8sStorage) Set(key string, val []byte) error {
+	return k.update(key, true, func(data map[string][]byte) error {
+		data[key] = val
+		return nil
+	})
+}
+
+// Create adds the key to the secret only if it isn't present, if the secret has been changed
+// concurrently the key is checked again
+func (k *k8sStorage) Create(key string, val []byte) error {
+	return k.update(key, true, func(data map[string][]byte) error {
+		if _, ok := data[key]; ok {
+			return kv.NewAlreadyExistsError("key '%s' already exists in secret '%s'", key, k.secret)
+		}
+		data[key] = val
+		return nil
+	})
 }
 
 func (k *k8sStorage) Get(key string) ([]byte, error) {
@@ -100,12 +150,26 @@ func (k *k8sStorage) Get(key string) ([]byte, error) {
 
 	val := secret.Data[key]
 	if val == nil {
-		return nil, kv.NewNotFoundError("key '%s' is not present in secret: %s", key)
+		return nil, kv.NewNotFoundError("key '%s' is not present in secret: %s", key, k.secret)
 	}
 
 	return val, nil
 }
 
+func (k *k8sStorage) Delete(key string) error {
+	// The secret isn't created again if it has been deleted meanwhile
+	return k.update(key, false, func(data map[string][]byte) error {
+		delete(data, key)
+		return nil
+	})
+}
+
+// Test checks that the secret can be read with the permissions of the client, the secret and the key
+// don't have to exist
 func (k *k8sStorage) Test(key string) error {
-	return nil
+	_, err := k.Get(key)
+	if _, ok := err.(*kv.NotFoundError); ok {
+		return nil
+	}
+	return err
 }
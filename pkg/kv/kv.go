@@ -1,6 +1,10 @@
 package kv
 
-import "fmt"
+import (
+	"fmt"
+
+	"github.com/banzaicloud/bank-vaults/pkg/securemem"
+)
 
 // NotFoundError represents an error when a key is not found
 type NotFoundError struct {
@@ -16,10 +20,66 @@ func NewNotFoundError(msg string, args ...interface{}) *NotFoundError {
 	}
 }
 
+// AlreadyExistsError represents an error when a key to be created exists already
+type AlreadyExistsError struct {
+	msg string // description of error
+}
+
+func (e *AlreadyExistsError) Error() string { return e.msg }
+
+// NewAlreadyExistsError creates a new AlreadyExistsError
+func NewAlreadyExistsError(msg string, args ...interface{}) *AlreadyExistsError {
+	return &AlreadyExistsError{
+		msg: fmt.Sprintf(msg, args...),
+	}
+}
+
 // Service defines a basic key-value store. Implementations of this interface
 // may or may not guarantee consistency or security properties.
+//
+// The values are secrets which the callers wipe after use: Get has to return a value
+// owned by the caller, and Set must not keep a reference to the value after returning.
 type Service interface {
 	Set(key string, value []byte) error
 	Get(key string) ([]byte, error)
 	Test(key string) error
 }
+
+// Deleter is implemented by the kv.Services which can delete keys
+type Deleter interface {
+	Delete(key string) error
+}
+
+// Delete deletes the key from the store, or returns an error if the store can't delete keys
+func Delete(store Service, key string) error {
+	deleter, ok := store.(Deleter)
+	if !ok {
+		return fmt.Errorf("the key store doesn't support deleting keys")
+	}
+	return deleter.Delete(key)
+}
+
+// Creator is implemented by the kv.Services which can create a key atomically with a conditional
+// write of the backend, so only one of the concurrent writers can create it
+type Creator interface {
+	// Create sets the key only if it doesn't exist, or returns an *AlreadyExistsError
+	Create(key string, value []byte) error
+}
+
+// Create sets the key only if it doesn't exist in the store yet, or returns an *AlreadyExistsError.
+// It is atomic if the store is a Creator, otherwise the key is checked before setting it, so
+// concurrent writers may overwrite each other.
+func Create(store Service, key string, value []byte) error {
+	if creator, ok := store.(Creator); ok {
+		return creator.Create(key, value)
+	}
+
+	existing, err := store.Get(key)
+	if err == nil {
+		securemem.Wipe(existing)
+		return NewAlreadyExistsError("key '%s' already exists", key)
+	} else if _, ok := err.(*NotFoundError); !ok {
+		return err
+	}
+	return store.Set(key, value)
+}
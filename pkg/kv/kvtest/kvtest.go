@@ -0,0 +1,178 @@
+package kvtest
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+)
+
+// The operations of a Store, e.g. in the Calls or in FailOn
+const (
+	OperationGet    = "get"
+	OperationSet    = "set"
+	OperationCreate = "create"
+	OperationTest   = "test"
+	OperationDelete = "delete"
+)
+
+// Call is a call of a Store
+type Call struct {
+	Operation string
+	Key       string
+}
+
+// Store is an in-memory kv.Service for tests (of the packages and of the applications embedding
+// them), which records its calls and can be scripted to fail chosen operations. It implements
+// kv.Creator and kv.Deleter as well, the zero value is not usable, see New.
+type Store struct {
+	mu       sync.Mutex
+	values   map[string][]byte
+	failures map[Call]error
+	calls    []Call
+	// hook is called before every operation, an error fails the operation
+	hook func(operation, key string) error
+}
+
+var _ kv.Service = &Store{}
+var _ kv.Creator = &Store{}
+var _ kv.Deleter = &Store{}
+
+// New returns an empty Store
+func New() *Store {
+	return &Store{
+		values:   map[string][]byte{},
+		failures: map[Call]error{},
+	}
+}
+
+// FailOn makes the operation on the key fail with err, on every key if key is empty.
+// A nil err removes the failure.
+func (s *Store) FailOn(operation, key string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	call := Call{Operation: operation, Key: key}
+	if err == nil {
+		delete(s.failures, call)
+		return
+	}
+	s.failures[call] = err
+}
+
+// SetHook calls hook before every operation (e.g. to block it, or to change the values concurrently),
+// an error returned by hook fails the operation
+func (s *Store) SetHook(hook func(operation, key string) error) {
+	s.mu.Lock()
+	s.hook = hook
+	s.mu.Unlock()
+}
+
+// Calls returns the calls of the Store in their order
+func (s *Store) Calls() []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Call{}, s.calls...)
+}
+
+// Keys returns the stored keys in alphabetical order
+func (s *Store) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := []string{}
+	for key := range s.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Value returns a copy of the stored value of the key, nil if it isn't stored
+func (s *Store) Value(key string) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.values[key]
+	if !ok {
+		return nil
+	}
+	return append([]byte{}, value...)
+}
+
+// Put stores the value of the key without recording a call, e.g. to prepare or corrupt the Store
+func (s *Store) Put(key string, value []byte) {
+	s.mu.Lock()
+	s.values[key] = append([]byte{}, value...)
+	s.mu.Unlock()
+}
+
+// call records the call and returns its scripted failure
+func (s *Store) call(operation, key string) error {
+	s.mu.Lock()
+	s.calls = append(s.calls, Call{Operation: operation, Key: key})
+	hook := s.hook
+	err, ok := s.failures[Call{Operation: operation, Key: key}]
+	if !ok {
+		err = s.failures[Call{Operation: operation}]
+	}
+	s.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+	if hook != nil {
+		return hook(operation, key)
+	}
+	return nil
+}
+
+// Get returns a copy of the value, as the callers wipe it
+func (s *Store) Get(key string) ([]byte, error) {
+	if err := s.call(OperationGet, key); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.values[key]
+	if !ok {
+		return nil, kv.NewNotFoundError("key '%s' is not present in the store", key)
+	}
+	return append([]byte{}, value...), nil
+}
+
+// Set stores a copy of the value, as the callers wipe it
+func (s *Store) Set(key string, value []byte) error {
+	if err := s.call(OperationSet, key); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.values[key] = append([]byte{}, value...)
+	s.mu.Unlock()
+	return nil
+}
+
+// Create stores a copy of the value atomically, if the key isn't stored yet
+func (s *Store) Create(key string, value []byte) error {
+	if err := s.call(OperationCreate, key); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.values[key]; ok {
+		return kv.NewAlreadyExistsError("key '%s' already exists in the store", key)
+	}
+	s.values[key] = append([]byte{}, value...)
+	return nil
+}
+
+func (s *Store) Test(key string) error {
+	return s.call(OperationTest, key)
+}
+
+func (s *Store) Delete(key string) error {
+	if err := s.call(OperationDelete, key); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	delete(s.values, key)
+	s.mu.Unlock()
+	return nil
+}
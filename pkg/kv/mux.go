@@ -0,0 +1,69 @@
+package kv
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Mux is a kv.Service distributing the keys across several independent key stores, e.g. the unseal
+// key shares across the KMSs of different cloud accounts, so none of them holds all the keys. A key
+// is stored in the store it is routed to, the keys without a route in the first store.
+type Mux struct {
+	stores []Service
+
+	mu     sync.RWMutex
+	routes map[string]int
+}
+
+var _ Service = &Mux{}
+var _ Creator = &Mux{}
+var _ Deleter = &Mux{}
+
+// NewMux creates a Mux of the stores, the first one is the default store of the keys. It is a
+// Creator and a Deleter if the store of the key is.
+func NewMux(stores ...Service) *Mux {
+	return &Mux{stores: stores, routes: map[string]int{}}
+}
+
+// Route stores the key in the store of the index of NewMux from now on
+func (m *Mux) Route(key string, store int) {
+	if store < 0 || store >= len(m.stores) {
+		panic(fmt.Sprintf("kv: Route to store %d of %d", store, len(m.stores)))
+	}
+	m.mu.Lock()
+	m.routes[key] = store
+	m.mu.Unlock()
+}
+
+// store returns the store of the key
+func (m *Mux) store(key string) Service {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.stores[m.routes[key]]
+}
+
+func (m *Mux) Get(key string) ([]byte, error) {
+	return m.store(key).Get(key)
+}
+
+func (m *Mux) Set(key string, val []byte) error {
+	return m.store(key).Set(key, val)
+}
+
+func (m *Mux) Create(key string, val []byte) error {
+	return Create(m.store(key), key, val)
+}
+
+func (m *Mux) Delete(key string) error {
+	return Delete(m.store(key), key)
+}
+
+// Test tests every store with the key, all of them have to be available to read the keys
+func (m *Mux) Test(key string) error {
+	for i, store := range m.stores {
+		if err := store.Test(key); err != nil {
+			return fmt.Errorf("error testing key store %d: %s", i, err.Error())
+		}
+	}
+	return nil
+}
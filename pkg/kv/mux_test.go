@@ -0,0 +1,43 @@
+package kv_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/kvtest"
+)
+
+func TestMux(t *testing.T) {
+	first, second := kvtest.New(), kvtest.New()
+	store := kv.NewMux(first, second)
+	store.Route("share", 1)
+
+	if err := store.Set("share", []byte("key")); err != nil {
+		t.Fatalf("error setting the key: %s", err.Error())
+	}
+	if err := store.Set("root", []byte("token")); err != nil {
+		t.Fatalf("error setting the key: %s", err.Error())
+	}
+	if second.Value("share") == nil || first.Value("share") != nil {
+		t.Errorf("the routed key should be stored in the second store only")
+	}
+	if first.Value("root") == nil || second.Value("root") != nil {
+		t.Errorf("the key without a route should be stored in the first store only")
+	}
+	if value, err := store.Get("share"); err != nil || string(value) != "key" {
+		t.Errorf("expected the routed value, got %q, %v", value, err)
+	}
+
+	if err := kv.Create(store, "share", []byte("other")); err == nil {
+		t.Errorf("expected an error creating an existing key")
+	}
+	if err := kv.Delete(store, "share"); err != nil || second.Value("share") != nil {
+		t.Errorf("the routed key hasn't been deleted: %v", err)
+	}
+
+	second.FailOn(kvtest.OperationTest, "test", errors.New("unavailable"))
+	if err := store.Test("test"); err == nil {
+		t.Errorf("expected the error of the second store")
+	}
+}
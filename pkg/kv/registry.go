@@ -0,0 +1,105 @@
+package kv
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// ModeKey is the setting of a Config selecting the backend by its registered name
+const ModeKey = "mode"
+
+// Config holds the settings of the key store backends by their names, e.g. the command line flags
+// of bank-vaults, a *viper.Viper satisfies it
+type Config interface {
+	GetString(key string) string
+}
+
+// Factory creates a key store backend from its settings
+type Factory func(config Config) (Service, error)
+
+// KMSFactory creates a KMS of the encrypted backend from its settings
+type KMSFactory func(config Config) (KMS, error)
+
+var (
+	factoriesMu      sync.RWMutex
+	factories        = map[string]Factory{}
+	kmsFactories     = map[string]KMSFactory{}
+	storageFactories = map[string]Factory{}
+)
+
+// Register makes a key store backend available by name for NewFromConfig, it is usually called from
+// an init function of the package of the backend (or of the application embedding it). Register
+// panics if the factory is nil or if it is called twice with the same name.
+func Register(name string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+
+	if factory == nil {
+		panic("kv: Register factory is nil for backend " + name)
+	}
+	if _, ok := factories[name]; ok {
+		panic("kv: Register called twice for backend " + name)
+	}
+	factories[name] = factory
+}
+
+// Backends returns the sorted names of the registered key store backends
+func Backends() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New creates the key store backend registered by name from its settings
+func New(name string, config Config) (Service, error) {
+	factoriesMu.RLock()
+	factory, ok := factories[name]
+	factoriesMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("Unsupported backend mode: '%s'", name)
+	}
+	return factory(config)
+}
+
+// NewFromConfig creates the key store backend selected by the mode setting (ModeKey) of the config
+func NewFromConfig(config Config) (Service, error) {
+	return New(config.GetString(ModeKey), config)
+}
+
+// RegisterKMS makes a KMS available by name for the encrypted backend (EncryptedKMSKey), it panics
+// like Register
+func RegisterKMS(name string, factory KMSFactory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+
+	if factory == nil {
+		panic("kv: RegisterKMS factory is nil for KMS " + name)
+	}
+	if _, ok := kmsFactories[name]; ok {
+		panic("kv: RegisterKMS called twice for KMS " + name)
+	}
+	kmsFactories[name] = factory
+}
+
+// RegisterStorage makes a storage available by name for the encrypted backend (EncryptedStorageKey),
+// it panics like Register
+func RegisterStorage(name string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+
+	if factory == nil {
+		panic("kv: RegisterStorage factory is nil for storage " + name)
+	}
+	if _, ok := storageFactories[name]; ok {
+		panic("kv: RegisterStorage called twice for storage " + name)
+	}
+	storageFactories[name] = factory
+}
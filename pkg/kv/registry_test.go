@@ -0,0 +1,68 @@
+package kv_test
+
+import (
+	"testing"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/kvtest"
+)
+
+type testConfig map[string]string
+
+func (c testConfig) GetString(key string) string { return c[key] }
+
+func TestRegistry(t *testing.T) {
+	store := kvtest.New()
+	kv.Register("test", func(config kv.Config) (kv.Service, error) {
+		store.Put("prefix", []byte(config.GetString("test-prefix")))
+		return store, nil
+	})
+
+	service, err := kv.NewFromConfig(testConfig{kv.ModeKey: "test", "test-prefix": "team-a/"})
+	if err != nil {
+		t.Fatalf("error creating the registered backend: %s", err.Error())
+	}
+	if service != store || string(store.Value("prefix")) != "team-a/" {
+		t.Errorf("the registered factory hasn't been called with the config")
+	}
+
+	if _, err := kv.NewFromConfig(testConfig{kv.ModeKey: "unknown"}); err == nil {
+		t.Errorf("expected an error for an unregistered backend")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected registering a backend twice to panic")
+		}
+	}()
+	kv.Register("test", func(config kv.Config) (kv.Service, error) { return nil, nil })
+}
+
+func TestRegistryEncrypted(t *testing.T) {
+	storage := kvtest.New()
+	kv.RegisterKMS("test-reverse", func(config kv.Config) (kv.KMS, error) { return reverseKMS{}, nil })
+	kv.RegisterStorage("test-storage", func(config kv.Config) (kv.Service, error) { return storage, nil })
+
+	service, err := kv.NewFromConfig(testConfig{
+		kv.ModeKey:             kv.EncryptedMode,
+		kv.EncryptedKMSKey:     "test-reverse",
+		kv.EncryptedStorageKey: "test-storage",
+	})
+	if err != nil {
+		t.Fatalf("error creating the encrypted backend: %s", err.Error())
+	}
+	if err := service.Set("vault-root", []byte("token")); err != nil {
+		t.Fatalf("error setting the value: %s", err.Error())
+	}
+	if string(storage.Value("vault-root")) == "token" {
+		t.Errorf("the value hasn't been encrypted by the registered KMS")
+	}
+
+	if _, err := kv.NewFromConfig(testConfig{
+		kv.ModeKey:             kv.EncryptedMode,
+		kv.EncryptedKMSKey:     "unknown",
+		kv.EncryptedStorageKey: "test-storage",
+	}); err == nil {
+		t.Errorf("expected an error for an unregistered KMS")
+	}
+}
@@ -0,0 +1,32 @@
+package s3
+
+import (
+	"fmt"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+)
+
+// The settings of the storage of AWS S3 in a kv.Config
+const (
+	StorageName = "aws-s3"
+	BucketKey   = "aws-s3-bucket"
+	PrefixKey   = "aws-s3-prefix"
+	RegionKey   = "aws-s3-region"
+)
+
+func init() {
+	kv.RegisterStorage(StorageName, NewFromConfig)
+}
+
+// NewFromConfig creates a new kv.Service backed by AWS S3 from the settings of the config
+func NewFromConfig(config kv.Config) (kv.Service, error) {
+	s3, err := New(
+		config.GetString(RegionKey),
+		config.GetString(BucketKey),
+		config.GetString(PrefixKey),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error creating AWS S3 kv store: %s", err.Error())
+	}
+	return s3, nil
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"io/ioutil"
+	"net/http"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
@@ -31,6 +32,16 @@ func New(region, bucket, prefix string) (kv.Service, error) {
 
 	sess := session.Must(session.NewSession(aws.NewConfig().WithRegion(region)))
 
+	return NewWithSession(sess, bucket, prefix)
+}
+
+// NewWithSession creates a new kv.Service backed by AWS S3 with the given session, e.g. of an
+// S3 compatible endpoint
+func NewWithSession(sess *session.Session, bucket, prefix string) (kv.Service, error) {
+	if bucket == "" {
+		return nil, fmt.Errorf("bucket must be specified")
+	}
+
 	cl := awss3.New(sess)
 
 	return &s3Storage{cl, bucket, prefix}, nil
@@ -51,6 +62,31 @@ func (s3 *s3Storage) Set(key string, val []byte) error {
 	return nil
 }
 
+// Create writes the object with an If-None-Match condition, so only one of the concurrent writers can
+// create it. S3 compatible stores ignoring the condition overwrite the object like Set.
+func (s3 *s3Storage) Create(key string, val []byte) error {
+	n := objectNameWithPrefix(s3.prefix, key)
+	input := awss3.PutObjectInput{
+		Bucket: aws.String(s3.bucket),
+		Key:    aws.String(n),
+		Body:   bytes.NewReader(val),
+	}
+
+	req, _ := s3.client.PutObjectRequest(&input)
+	req.HTTPRequest.Header.Set("If-None-Match", "*")
+
+	if err := req.Send(); err != nil {
+		// 409 is returned if a concurrent conditional write of the object is in progress
+		if aerr, ok := err.(awserr.RequestFailure); ok &&
+			(aerr.StatusCode() == http.StatusPreconditionFailed || aerr.StatusCode() == http.StatusConflict) {
+			return kv.NewAlreadyExistsError("key '%s' already exists in s3 bucket '%s'", n, s3.bucket)
+		}
+		return fmt.Errorf("error writing key '%s' to s3 bucket '%s': '%s'", n, s3.bucket, err.Error())
+	}
+
+	return nil
+}
+
 func (s3 *s3Storage) Get(key string) ([]byte, error) {
 	n := objectNameWithPrefix(s3.prefix, key)
 
@@ -78,6 +114,20 @@ func (s3 *s3Storage) Get(key string) ([]byte, error) {
 	return b, nil
 }
 
+func (s3 *s3Storage) Delete(key string) error {
+	n := objectNameWithPrefix(s3.prefix, key)
+	input := awss3.DeleteObjectInput{
+		Bucket: aws.String(s3.bucket),
+		Key:    aws.String(n),
+	}
+
+	if _, err := s3.client.DeleteObject(&input); err != nil {
+		return fmt.Errorf("error deleting key '%s' from s3 bucket '%s': '%s'", n, s3.bucket, err.Error())
+	}
+
+	return nil
+}
+
 func objectNameWithPrefix(prefix, key string) string {
 	return fmt.Sprintf("%s%s", prefix, key)
 }
@@ -0,0 +1,103 @@
+package vaultkv
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/hashicorp/vault/api"
+)
+
+type vaultKV struct {
+	cl   *api.Client
+	path string
+	v2   bool
+}
+
+// New creates a new kv.Service backed by a KV secret engine of Vault, the keys are stored under
+// the path (e.g. secret/bank-vaults) as secrets with a base64 encoded value field. With v2 the
+// path has to be the data path of a KV version 2 engine (e.g. secret/data/bank-vaults), and
+// the keys are created with check-and-set, so only one of the concurrent writers can create them.
+func New(cl *api.Client, path string, v2 bool) (kv.Service, error) {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil, fmt.Errorf("the path of the vault kv store is empty")
+	}
+	return &vaultKV{cl: cl, path: path, v2: v2}, nil
+}
+
+func (v *vaultKV) Set(key string, val []byte) error {
+	return v.write(key, val, nil)
+}
+
+// Create writes the secret with cas=0 on a KV version 2 engine, it is checked before writing it on version 1
+func (v *vaultKV) Create(key string, val []byte) error {
+	if !v.v2 {
+		if _, err := v.Get(key); err == nil {
+			return kv.NewAlreadyExistsError("key '%s' already exists in vault path '%s'", key, v.path)
+		} else if _, ok := err.(*kv.NotFoundError); !ok {
+			return err
+		}
+		return v.Set(key, val)
+	}
+
+	err := v.write(key, val, map[string]interface{}{"cas": 0})
+	if err != nil && strings.Contains(err.Error(), "check-and-set parameter did not match") {
+		return kv.NewAlreadyExistsError("key '%s' already exists in vault path '%s'", key, v.path)
+	}
+	return err
+}
+
+func (v *vaultKV) write(key string, val []byte, options map[string]interface{}) error {
+	data := map[string]interface{}{"value": base64.StdEncoding.EncodeToString(val)}
+	if v.v2 {
+		data = map[string]interface{}{"data": data}
+		if options != nil {
+			data["options"] = options
+		}
+	}
+
+	if _, err := v.cl.Logical().Write(v.keyPath(key), data); err != nil {
+		return fmt.Errorf("error writing key '%s' to vault path '%s': %s", key, v.path, err.Error())
+	}
+	return nil
+}
+
+func (v *vaultKV) Get(key string) ([]byte, error) {
+	secret, err := v.cl.Logical().Read(v.keyPath(key))
+	if err != nil {
+		return nil, fmt.Errorf("error reading key '%s' from vault path '%s': %s", key, v.path, err.Error())
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, kv.NewNotFoundError("key '%s' not found in vault path '%s'", key, v.path)
+	}
+
+	data := secret.Data
+	if v.v2 {
+		// A deleted version of a KV version 2 secret has no data
+		var ok bool
+		if data, ok = secret.Data["data"].(map[string]interface{}); !ok {
+			return nil, kv.NewNotFoundError("key '%s' not found in vault path '%s'", key, v.path)
+		}
+	}
+
+	value, ok := data["value"].(string)
+	if !ok {
+		return nil, fmt.Errorf("key '%s' in vault path '%s' has no value", key, v.path)
+	}
+	val, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding key '%s' from vault path '%s': %s", key, v.path, err.Error())
+	}
+	return val, nil
+}
+
+func (v *vaultKV) Test(key string) error {
+	// TODO: Implement me properly
+	return nil
+}
+
+func (v *vaultKV) keyPath(key string) string {
+	return v.path + "/" + key
+}
@@ -0,0 +1,34 @@
+package vaulttransit
+
+import (
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+)
+
+// The settings of the transit engine KMS in a kv.Config
+const (
+	KMSName        = "vault-transit"
+	AddressKey     = "transit-vault-addr"
+	TokenKey       = "transit-vault-token"
+	RoleIDKey      = "transit-vault-role-id"
+	SecretIDKey    = "transit-vault-secret-id"
+	AppRolePathKey = "transit-vault-approle-path"
+	MountKey       = "transit-mount"
+	KeyNameKey     = "transit-key-name"
+)
+
+func init() {
+	kv.RegisterKMS(KMSName, NewKMSFromConfig)
+}
+
+// NewKMSFromConfig creates a new kv.KMS of the transit engine from the settings of the config
+func NewKMSFromConfig(config kv.Config) (kv.KMS, error) {
+	return NewKMS(Config{
+		Address:     config.GetString(AddressKey),
+		Token:       config.GetString(TokenKey),
+		RoleID:      config.GetString(RoleIDKey),
+		SecretID:    config.GetString(SecretIDKey),
+		AppRolePath: config.GetString(AppRolePathKey),
+		Mount:       config.GetString(MountKey),
+		KeyName:     config.GetString(KeyNameKey),
+	})
+}
@@ -0,0 +1,160 @@
+package vaulttransit
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/hashicorp/vault/api"
+)
+
+// Config holds the settings of the Vault cluster whose transit engine encrypts the values
+type Config struct {
+	// Address of the Vault cluster, VAULT_ADDR by default
+	Address string
+	// Token to authenticate with, VAULT_TOKEN by default if there is no RoleID
+	Token string
+	// RoleID and SecretID of the AppRole to log in with instead of the Token
+	RoleID   string
+	SecretID string
+	// AppRolePath is the path of the AppRole auth method, approle by default
+	AppRolePath string
+	// Mount is the path of the transit engine, transit by default
+	Mount string
+	// KeyName is the name of the transit key
+	KeyName string
+}
+
+// transitKMS is an implementation of the kv.KMS interface, that encrypts and decrypts data with a
+// key of the transit engine of another Vault cluster
+type transitKMS struct {
+	cl      *api.Client
+	mount   string
+	keyName string
+
+	// login gets a new token with the AppRole if its token expired, nil with a static token
+	login   func() error
+	loginMu sync.Mutex
+}
+
+var _ kv.KMS = &transitKMS{}
+
+// NewKMS creates a new kv.KMS of the transit engine of the Vault cluster of the config, to be composed
+// with a storage by kv.NewEncrypted. This gives open source Vault clusters the semantics of the
+// transit auto-unseal: the unseal keys can only be read while the other cluster is available.
+func NewKMS(config Config) (kv.KMS, error) {
+	clientConfig := api.DefaultConfig()
+	if config.Address != "" {
+		clientConfig.Address = config.Address
+	}
+	cl, err := api.NewClient(clientConfig)
+	if err != nil {
+		return nil, fmt.Errorf("error creating transit vault client: %s", err.Error())
+	}
+	if config.Token != "" {
+		cl.SetToken(config.Token)
+	}
+
+	return NewKMSWithClient(cl, config)
+}
+
+// NewKMSWithClient creates a new kv.KMS of the transit engine with an existing client, the Address
+// and the Token of the config are ignored
+func NewKMSWithClient(cl *api.Client, config Config) (kv.KMS, error) {
+	if config.KeyName == "" {
+		return nil, fmt.Errorf("the name of the transit key is required")
+	}
+	mount := strings.Trim(config.Mount, "/")
+	if mount == "" {
+		mount = "transit"
+	}
+
+	t := &transitKMS{cl: cl, mount: mount, keyName: config.KeyName}
+	if config.RoleID != "" {
+		appRolePath := strings.Trim(config.AppRolePath, "/")
+		if appRolePath == "" {
+			appRolePath = "approle"
+		}
+		t.login = func() error {
+			secret, err := cl.Logical().Write(fmt.Sprintf("auth/%s/login", appRolePath), map[string]interface{}{
+				"role_id":   config.RoleID,
+				"secret_id": config.SecretID,
+			})
+			if err != nil {
+				return fmt.Errorf("error logging in to transit vault with approle: %s", err.Error())
+			}
+			if secret == nil || secret.Auth == nil {
+				return fmt.Errorf("error logging in to transit vault with approle: no token in the response")
+			}
+			cl.SetToken(secret.Auth.ClientToken)
+			return nil
+		}
+		if err := t.login(); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// New creates a new kv.Service encrypted by the transit engine of the Vault cluster of the config
+func New(store kv.Service, config Config) (kv.Service, error) {
+	kms, err := NewKMS(config)
+	if err != nil {
+		return nil, err
+	}
+	return kv.NewEncrypted(kms, store), nil
+}
+
+func (t *transitKMS) Encrypt(plainText []byte) ([]byte, error) {
+	data, err := t.write("encrypt", map[string]interface{}{
+		"plaintext": base64.StdEncoding.EncodeToString(plainText),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error encrypting data: %s", err.Error())
+	}
+	cipherText, ok := data["ciphertext"].(string)
+	if !ok {
+		return nil, fmt.Errorf("error encrypting data: no ciphertext in the response")
+	}
+	return []byte(cipherText), nil
+}
+
+func (t *transitKMS) Decrypt(cipherText []byte) ([]byte, error) {
+	data, err := t.write("decrypt", map[string]interface{}{
+		"ciphertext": string(cipherText),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting data: %s", err.Error())
+	}
+	plainText, ok := data["plaintext"].(string)
+	if !ok {
+		return nil, fmt.Errorf("error decrypting data: no plaintext in the response")
+	}
+	return base64.StdEncoding.DecodeString(plainText)
+}
+
+// write calls the operation of the transit key, logging in again once if the token of the AppRole
+// has expired
+func (t *transitKMS) write(operation string, data map[string]interface{}) (map[string]interface{}, error) {
+	path := fmt.Sprintf("%s/%s/%s", t.mount, operation, t.keyName)
+	secret, err := t.cl.Logical().Write(path, data)
+	// The errors of the Vault API client carry the status code as text only
+	if err != nil && strings.Contains(err.Error(), "Code: 403") && t.login != nil {
+		t.loginMu.Lock()
+		err = t.login()
+		t.loginMu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		secret, err = t.cl.Logical().Write(path, data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, fmt.Errorf("empty response from %s", path)
+	}
+	return secret.Data, nil
+}
@@ -0,0 +1,61 @@
+package vaulttransit_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/kvtest"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/vaulttransit"
+	"github.com/banzaicloud/bank-vaults/pkg/vault/vaulttest"
+	"github.com/hashicorp/vault/api"
+)
+
+func TestTransit(t *testing.T) {
+	server := vaulttest.NewServer()
+	defer server.Close()
+	client, err := server.Client()
+	if err != nil {
+		t.Fatalf("error creating the client of the fake vault: %s", err.Error())
+	}
+
+	init, err := client.Sys().Init(&api.InitRequest{SecretShares: 1, SecretThreshold: 1})
+	if err != nil {
+		t.Fatalf("error initializing the fake vault: %s", err.Error())
+	}
+	if _, err := client.Sys().Unseal(init.Keys[0]); err != nil {
+		t.Fatalf("error unsealing the fake vault: %s", err.Error())
+	}
+	client.SetToken(init.RootToken)
+	if err := client.Sys().Mount("unseal-transit", &api.MountInput{Type: "transit"}); err != nil {
+		t.Fatalf("error mounting the transit engine: %s", err.Error())
+	}
+
+	kms, err := vaulttransit.NewKMSWithClient(client, vaulttransit.Config{Mount: "unseal-transit", KeyName: "bank-vaults"})
+	if err != nil {
+		t.Fatalf("error creating the transit kms: %s", err.Error())
+	}
+	storage := kvtest.New()
+	store := kv.NewEncrypted(kms, storage)
+
+	if err := store.Test("test"); err != nil {
+		t.Fatalf("test of the transit store failed: %s", err.Error())
+	}
+	if err := store.Set("vault-root", []byte("token")); err != nil {
+		t.Fatalf("error setting the key: %s", err.Error())
+	}
+	if stored := string(storage.Value("vault-root")); !strings.HasPrefix(stored, "vault:v1:") {
+		t.Errorf("the storage should hold the transit ciphertext, got %q", stored)
+	}
+	if value, err := store.Get("vault-root"); err != nil || string(value) != "token" {
+		t.Errorf("expected the decrypted value, got %q, %v", value, err)
+	}
+
+	other, err := vaulttransit.NewKMSWithClient(client, vaulttransit.Config{Mount: "unseal-transit", KeyName: "other"})
+	if err != nil {
+		t.Fatalf("error creating the transit kms: %s", err.Error())
+	}
+	if _, err := kv.NewEncrypted(other, storage).Get("vault-root"); err == nil {
+		t.Errorf("expected an error decrypting with another key")
+	}
+}
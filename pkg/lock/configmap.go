@@ -0,0 +1,125 @@
+package lock
+
+import (
+	"fmt"
+	"time"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// LeaseAnnotation is the annotation of the ConfigMap holding the lease of a ConfigMap lock
+const LeaseAnnotation = "vault.banzaicloud.com/lock"
+
+// configMapLocker holds the lease in an annotation of a ConfigMap, every change of it is
+// conditional on the resourceVersion read before, like the ConfigMap locks of the Kubernetes
+// leader election
+type configMapLocker struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+	holder    string
+	ttl       time.Duration
+	renewer
+}
+
+// NewConfigMap returns a Locker keeping its lease in the named ConfigMap, holder identifies this
+// replica (e.g. the name of its Pod). Acquiring, renewing and taking over an expired lease are
+// all atomic.
+func NewConfigMap(client kubernetes.Interface, namespace, name, holder string, ttl time.Duration) Locker {
+	return &configMapLocker{client: client, namespace: namespace, name: name, holder: holder, ttl: ttl}
+}
+
+func (l *configMapLocker) TryLock() (bool, error) {
+	locked, err := l.update(func(current *lease) (*lease, bool) {
+		if current != nil && current.Holder != l.holder && !current.expired() {
+			return nil, false
+		}
+		return newLease(l.holder, l.ttl), true
+	})
+	if err != nil || !locked {
+		return false, err
+	}
+
+	l.start(l.name, l.ttl, l.renew)
+	return true, nil
+}
+
+func (l *configMapLocker) renew() error {
+	renewed, err := l.update(func(current *lease) (*lease, bool) {
+		if current == nil || current.Holder != l.holder {
+			return nil, false
+		}
+		return newLease(l.holder, l.ttl), true
+	})
+	if err == nil && !renewed {
+		return fmt.Errorf("lock '%s/%s' has been taken over", l.namespace, l.name)
+	}
+	return err
+}
+
+func (l *configMapLocker) Unlock() error {
+	l.stopRenewing()
+
+	_, err := l.update(func(current *lease) (*lease, bool) {
+		if current == nil || current.Holder != l.holder {
+			return nil, false
+		}
+		// An expired lease can be acquired by anyone
+		return &lease{Holder: l.holder}, true
+	})
+	return err
+}
+
+// update replaces the lease of the ConfigMap with the one returned by change, if it returns true,
+// the ConfigMap is created if it doesn't exist. It returns false if the ConfigMap has been
+// changed concurrently.
+func (l *configMapLocker) update(change func(current *lease) (*lease, bool)) (bool, error) {
+	configMaps := l.client.CoreV1().ConfigMaps(l.namespace)
+
+	configMap, err := configMaps.Get(l.name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		configMap = &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: l.namespace,
+				Name:      l.name,
+			},
+		}
+	} else if err != nil {
+		return false, fmt.Errorf("error getting lock '%s/%s': %s", l.namespace, l.name, err.Error())
+	}
+
+	var current *lease
+	if value, ok := configMap.Annotations[LeaseAnnotation]; ok {
+		if current, err = unmarshalLease([]byte(value)); err != nil {
+			return false, err
+		}
+	}
+
+	next, ok := change(current)
+	if !ok {
+		return false, nil
+	}
+	value, err := next.marshal()
+	if err != nil {
+		return false, err
+	}
+	if configMap.Annotations == nil {
+		configMap.Annotations = map[string]string{}
+	}
+	configMap.Annotations[LeaseAnnotation] = string(value)
+
+	if configMap.ResourceVersion == "" {
+		_, err = configMaps.Create(configMap)
+	} else {
+		_, err = configMaps.Update(configMap)
+	}
+	if errors.IsAlreadyExists(err) || errors.IsConflict(err) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("error updating lock '%s/%s': %s", l.namespace, l.name, err.Error())
+	}
+	return true, nil
+}
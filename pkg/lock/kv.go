@@ -0,0 +1,124 @@
+package lock
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+)
+
+// kvLocker holds the lease under a key of the key store, created with kv.Create
+type kvLocker struct {
+	store  kv.Service
+	key    string
+	holder string
+	ttl    time.Duration
+	renewer
+}
+
+// NewKV returns a Locker keeping its lease under key in the key store, holder identifies this
+// replica (e.g. the name of its Pod). The lease is created atomically if the key store supports
+// conditional writes (see kv.Creator), but taking over an expired lease isn't atomic: a replica
+// may delete the lease another one has just taken over, so the ttl should be well above the time
+// it takes to notice the expiry.
+func NewKV(store kv.Service, key, holder string, ttl time.Duration) Locker {
+	return &kvLocker{store: store, key: key, holder: holder, ttl: ttl}
+}
+
+func (l *kvLocker) TryLock() (bool, error) {
+	value, err := newLease(l.holder, l.ttl).marshal()
+	if err != nil {
+		return false, err
+	}
+
+	created, err := l.create(value)
+	if err != nil || !created {
+		return false, err
+	}
+
+	l.start(l.key, l.ttl, l.renew)
+	return true, nil
+}
+
+// create creates the lease, or replaces the current one if it is expired or has the same holder
+// (e.g. after a restart)
+func (l *kvLocker) create(value []byte) (bool, error) {
+	err := kv.Create(l.store, l.key, value)
+	if err == nil {
+		return true, nil
+	} else if _, ok := err.(*kv.AlreadyExistsError); !ok {
+		return false, fmt.Errorf("error creating lock '%s': %s", l.key, err.Error())
+	}
+
+	current, err := l.current()
+	if err != nil {
+		return false, err
+	} else if current == nil {
+		// Released in the meantime, the next attempt can create it
+		return false, nil
+	}
+
+	if current.Holder == l.holder {
+		if err := l.store.Set(l.key, value); err != nil {
+			return false, fmt.Errorf("error updating lock '%s': %s", l.key, err.Error())
+		}
+		return true, nil
+	}
+	if !current.expired() {
+		return false, nil
+	}
+
+	if err := kv.Delete(l.store, l.key); err != nil {
+		return false, fmt.Errorf("error deleting expired lock '%s' of %s: %s", l.key, current.Holder, err.Error())
+	}
+	err = kv.Create(l.store, l.key, value)
+	if _, ok := err.(*kv.AlreadyExistsError); ok {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("error creating lock '%s': %s", l.key, err.Error())
+	}
+	return true, nil
+}
+
+// current returns the current lease, nil if there is none
+func (l *kvLocker) current() (*lease, error) {
+	value, err := l.store.Get(l.key)
+	if _, ok := err.(*kv.NotFoundError); ok {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error reading lock '%s': %s", l.key, err.Error())
+	}
+	return unmarshalLease(value)
+}
+
+func (l *kvLocker) renew() error {
+	current, err := l.current()
+	if err != nil {
+		return err
+	}
+	if current == nil || current.Holder != l.holder {
+		return fmt.Errorf("lock '%s' has been taken over", l.key)
+	}
+
+	value, err := newLease(l.holder, l.ttl).marshal()
+	if err != nil {
+		return err
+	}
+	return l.store.Set(l.key, value)
+}
+
+func (l *kvLocker) Unlock() error {
+	l.stopRenewing()
+
+	current, err := l.current()
+	if err != nil {
+		return err
+	}
+	if current == nil || current.Holder != l.holder {
+		return nil
+	}
+	if err := kv.Delete(l.store, l.key); err != nil {
+		return fmt.Errorf("error deleting lock '%s': %s", l.key, err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/banzaicloud/bank-vaults/pkg/logging"
+)
+
+// LeaseWatcher is implemented by the Lockers which can report that the lease of the held lock has
+// been lost, e.g. because it couldn't be renewed before it expired
+type LeaseWatcher interface {
+	// Lost returns a channel which is closed when the lease of the held lock is lost
+	Lost() <-chan struct{}
+}
+
+// ErrLeaseLost is returned by RunAsLeader if the lease of the lock was lost while f was running
+var ErrLeaseLost = errors.New("the lease of the leader lock has been lost")
+
+// RunAsLeader waits until the lock is acquired, trying again every retryInterval, then runs f as
+// the leader and releases the lock when it returns. The context of f is cancelled when ctx is done
+// or the lease of the lock is lost (if the Locker is a LeaseWatcher), so a replica which isn't the
+// leader anymore stops, and ErrLeaseLost is returned. The replicas waiting for the lock are the
+// standbys, one of them takes over when the leader releases the lock or its lease expires.
+func RunAsLeader(ctx context.Context, locker Locker, retryInterval time.Duration, f func(ctx context.Context) error) error {
+	logger := logging.Default()
+	for {
+		locked, err := locker.TryLock()
+		if err != nil {
+			logger.Warnf("error acquiring the leader lock, trying again: %s", err.Error())
+		} else if locked {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(retryInterval):
+		}
+	}
+	logger.Infof("acquired the leader lock")
+
+	leaderCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	lost := make(chan struct{})
+	if watcher, ok := locker.(LeaseWatcher); ok {
+		leaseLost := watcher.Lost()
+		go func() {
+			select {
+			case <-leaseLost:
+				close(lost)
+				cancel()
+			case <-leaderCtx.Done():
+			}
+		}()
+	}
+
+	err := f(leaderCtx)
+	cancel()
+
+	if unlockErr := locker.Unlock(); unlockErr != nil {
+		logger.Errorf("error releasing the leader lock: %s", unlockErr.Error())
+	}
+
+	select {
+	case <-lost:
+		return ErrLeaseLost
+	default:
+	}
+	return err
+}
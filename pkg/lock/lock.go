@@ -0,0 +1,128 @@
+package lock
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/banzaicloud/bank-vaults/pkg/logging"
+)
+
+// Locker is a lock shared by the replicas of bank-vaults (e.g. of an HA deployment or a DaemonSet),
+// so only one of them initializes or configures Vault at a time. It is held as a lease, which is
+// renewed in the background until it is released, so a crashed holder doesn't keep it forever.
+type Locker interface {
+	// TryLock acquires the lock if it is free or its lease has expired, and returns false if
+	// another holder has it
+	TryLock() (bool, error)
+	// Unlock releases the lock if it is held
+	Unlock() error
+}
+
+// Lock waits until the lock is acquired, trying again every retryInterval, or returns an error
+// after timeout, there is no timeout if it is 0
+func Lock(locker Locker, retryInterval, timeout time.Duration) error {
+	start := time.Now()
+	for {
+		locked, err := locker.TryLock()
+		if err != nil {
+			return err
+		}
+		if locked {
+			return nil
+		}
+		if timeout > 0 && time.Since(start) >= timeout {
+			return fmt.Errorf("the lock is held by another replica, gave up waiting for it after %s", timeout)
+		}
+		time.Sleep(retryInterval)
+	}
+}
+
+// lease is the record of the current holder of a lock
+type lease struct {
+	Holder  string    `json:"holder"`
+	Expires time.Time `json:"expires"`
+}
+
+func newLease(holder string, ttl time.Duration) *lease {
+	return &lease{Holder: holder, Expires: time.Now().Add(ttl)}
+}
+
+func (l *lease) expired() bool {
+	return time.Now().After(l.Expires)
+}
+
+func (l *lease) marshal() ([]byte, error) {
+	return json.Marshal(l)
+}
+
+func unmarshalLease(data []byte) (*lease, error) {
+	var l lease
+	if err := json.Unmarshal(data, &l); err != nil {
+		return nil, fmt.Errorf("error parsing lock lease: %s", err.Error())
+	}
+	return &l, nil
+}
+
+// renewer renews a lease every third of its ttl until it is stopped or the renewal fails
+type renewer struct {
+	mu   sync.Mutex
+	stop chan struct{}
+	done chan struct{}
+	lost chan struct{}
+}
+
+func (r *renewer) start(name string, ttl time.Duration, renew func() error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stop != nil {
+		return
+	}
+	stop, done, lost := make(chan struct{}), make(chan struct{}), make(chan struct{})
+	r.stop, r.done, r.lost = stop, done, lost
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := renew(); err != nil {
+					logging.Default().WithField("lock", name).Errorf("error renewing lock lease, it expires after %s: %s", ttl, err.Error())
+					r.mu.Lock()
+					if r.stop == stop {
+						r.stop, r.done = nil, nil
+					}
+					r.mu.Unlock()
+					close(lost)
+					return
+				}
+			}
+		}
+	}()
+}
+
+// Lost returns a channel which is closed when the renewal of the current lease fails
+func (r *renewer) Lost() <-chan struct{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lost
+}
+
+// stopRenewing stops the renewal and waits until a renewal in progress finishes, so it can't
+// extend the lease after it has been released
+func (r *renewer) stopRenewing() {
+	r.mu.Lock()
+	stop, done := r.stop, r.done
+	r.stop, r.done = nil, nil
+	r.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
@@ -0,0 +1,132 @@
+package logging
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ComponentField is the field of the entries naming the subsystem logging them, e.g. vault or runner
+const ComponentField = "component"
+
+// Levels are the minimum levels of the entries by the components logging them
+type Levels struct {
+	// Default is the level of the entries without a component, or of a component not in Components
+	Default logrus.Level
+	// Components are the levels of the components, e.g. {"vault": logrus.DebugLevel}
+	Components map[string]logrus.Level
+}
+
+// ParseLevels parses the comma separated levels of the components, e.g. vault=debug,runner=warning,
+// the level without a component replaces defaultLevel
+func ParseLevels(levels string, defaultLevel logrus.Level) (Levels, error) {
+	result := Levels{Default: defaultLevel, Components: map[string]logrus.Level{}}
+	for _, item := range strings.Split(levels, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		component, levelName := "", item
+		if i := strings.Index(item, "="); i >= 0 {
+			component, levelName = strings.TrimSpace(item[:i]), strings.TrimSpace(item[i+1:])
+		}
+		level, err := logrus.ParseLevel(levelName)
+		if err != nil {
+			return Levels{}, fmt.Errorf("invalid level of %q: %s", item, err.Error())
+		}
+		if component == "" {
+			result.Default = level
+		} else {
+			result.Components[component] = level
+		}
+	}
+	return result, nil
+}
+
+// Enabled returns whether the entries of the component at the level are logged
+func (l Levels) Enabled(component string, level logrus.Level) bool {
+	minimum, ok := l.Components[component]
+	if !ok {
+		minimum = l.Default
+	}
+	return level <= minimum
+}
+
+// Max returns the most verbose level of the components, the level of the logrus logger filtered by
+// the levels has to be set to it
+func (l Levels) Max() logrus.Level {
+	max := l.Default
+	for _, level := range l.Components {
+		if level > max {
+			max = level
+		}
+	}
+	return max
+}
+
+// leveledLogger drops the entries below the level of its component
+type leveledLogger struct {
+	logger    Logger
+	levels    Levels
+	component string
+}
+
+// NewLeveled returns a Logger passing only the entries enabled by the levels of their component (the
+// ComponentField set with WithField) to logger
+func NewLeveled(logger Logger, levels Levels) Logger {
+	return &leveledLogger{logger: logger, levels: levels}
+}
+
+func (l *leveledLogger) Debugf(format string, args ...interface{}) {
+	if l.levels.Enabled(l.component, logrus.DebugLevel) {
+		l.logger.Debugf(format, args...)
+	}
+}
+
+func (l *leveledLogger) Infof(format string, args ...interface{}) {
+	if l.levels.Enabled(l.component, logrus.InfoLevel) {
+		l.logger.Infof(format, args...)
+	}
+}
+
+func (l *leveledLogger) Warnf(format string, args ...interface{}) {
+	if l.levels.Enabled(l.component, logrus.WarnLevel) {
+		l.logger.Warnf(format, args...)
+	}
+}
+
+func (l *leveledLogger) Errorf(format string, args ...interface{}) {
+	if l.levels.Enabled(l.component, logrus.ErrorLevel) {
+		l.logger.Errorf(format, args...)
+	}
+}
+
+func (l *leveledLogger) WithField(key string, value interface{}) Logger {
+	component := l.component
+	if key == ComponentField {
+		component = fmt.Sprint(value)
+	}
+	return &leveledLogger{logger: l.logger.WithField(key, value), levels: l.levels, component: component}
+}
+
+// leveledFormatter drops the logrus entries below the level of their component
+type leveledFormatter struct {
+	formatter logrus.Formatter
+	levels    Levels
+}
+
+// NewLeveledFormatter returns a logrus formatter which formats only the entries enabled by the levels
+// of their component with formatter, so the entries logged with logrus directly are filtered as well.
+// The level of the logrus logger has to be Levels.Max().
+func NewLeveledFormatter(formatter logrus.Formatter, levels Levels) logrus.Formatter {
+	return &leveledFormatter{formatter: formatter, levels: levels}
+}
+
+func (f *leveledFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	component, _ := entry.Data[ComponentField].(string)
+	if !f.levels.Enabled(component, entry.Level) {
+		return nil, nil
+	}
+	return f.formatter.Format(entry)
+}
@@ -0,0 +1,52 @@
+package logging
+
+import (
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Logger is the logging interface of the bank-vaults library, so it can be integrated with the
+// logging stack of the application embedding it
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+	// WithField returns a Logger adding the field to every entry, e.g. the component or the key
+	WithField(key string, value interface{}) Logger
+}
+
+// logrusLogger adapts a logrus logger or entry to Logger
+type logrusLogger struct {
+	logrus.FieldLogger
+}
+
+// NewLogrus returns a Logger writing to a logrus logger or entry, e.g. logrus.StandardLogger()
+func NewLogrus(logger logrus.FieldLogger) Logger {
+	return &logrusLogger{logger}
+}
+
+func (l *logrusLogger) WithField(key string, value interface{}) Logger {
+	return &logrusLogger{l.FieldLogger.WithField(key, value)}
+}
+
+var (
+	defaultLoggerMu sync.RWMutex
+	defaultLogger   = NewRedacting(NewLogrus(logrus.StandardLogger()))
+)
+
+// Default returns the Logger of the library where none is injected, the standard logrus logger by default.
+// The secrets are redacted from its entries.
+func Default() Logger {
+	defaultLoggerMu.RLock()
+	defer defaultLoggerMu.RUnlock()
+	return defaultLogger
+}
+
+// SetDefault replaces the Logger of the library where none is injected
+func SetDefault(logger Logger) {
+	defaultLoggerMu.Lock()
+	defaultLogger = NewRedacting(logger)
+	defaultLoggerMu.Unlock()
+}
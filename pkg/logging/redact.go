@@ -0,0 +1,198 @@
+package logging
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/banzaicloud/bank-vaults/pkg/securemem"
+	"github.com/sirupsen/logrus"
+)
+
+// Redacted replaces the secrets in the log entries
+const Redacted = "[REDACTED]"
+
+// minSecretLength is the length of the shortest value registered as a secret, shorter values would
+// redact unrelated parts of the entries
+const minSecretLength = 8
+
+// sensitiveFields are the (lowercase) parts of the field and payload key names holding secrets
+var sensitiveFields = []string{
+	"token",
+	"password",
+	"passphrase",
+	"bindpass",
+	"secret_key",
+	"secret-key",
+	"unseal-key",
+	"unseal_key",
+	"recovery-key",
+	"recovery_key",
+	"private_key",
+	"private-key",
+	"client_secret",
+	"credentials",
+}
+
+// tokenPattern matches Vault service and batch tokens, even if they haven't been registered
+var tokenPattern = regexp.MustCompile(`\b(s|b|hvs|hvb)\.[A-Za-z0-9_-]{24,}`)
+
+// secrets are the registered secrets by their hashes, kept in locked memory
+var (
+	secretsMu sync.RWMutex
+	secrets   = map[[sha256.Size]byte]*securemem.Buffer{}
+)
+
+// RegisterSecret makes the redacting loggers replace the value (e.g. an unseal key or a root token) with Redacted
+func RegisterSecret(secret string) {
+	secretBytes := []byte(strings.TrimSpace(secret))
+	RegisterSecretBytes(secretBytes)
+	securemem.Wipe(secretBytes)
+}
+
+// RegisterSecretBytes is RegisterSecret for a secret held in a byte slice, e.g. in a securemem.Buffer,
+// the registry keeps its own copy
+func RegisterSecretBytes(secret []byte) {
+	secret = bytes.TrimSpace(secret)
+	if len(secret) < minSecretLength {
+		return
+	}
+	hash := sha256.Sum256(secret)
+	secretsMu.Lock()
+	if _, ok := secrets[hash]; !ok {
+		secrets[hash] = securemem.Copy(secret)
+	}
+	secretsMu.Unlock()
+}
+
+// ForgetSecrets wipes the registered secrets, e.g. when the process shuts down, only the Vault tokens and
+// the sensitive fields are redacted afterwards
+func ForgetSecrets() {
+	secretsMu.Lock()
+	for hash, secret := range secrets {
+		secret.Destroy()
+		delete(secrets, hash)
+	}
+	secretsMu.Unlock()
+}
+
+// IsSensitiveField tells whether the field or payload key name (e.g. bindpass) holds a secret
+func IsSensitiveField(name string) bool {
+	name = strings.ToLower(name)
+	for _, sensitive := range sensitiveFields {
+		if strings.Contains(name, sensitive) {
+			return true
+		}
+	}
+	return false
+}
+
+// Redact replaces the registered secrets and the Vault tokens in s with Redacted
+func Redact(s string) string {
+	redacted := []byte(s)
+	secretsMu.RLock()
+	for _, secret := range secrets {
+		redacted = bytes.Replace(redacted, secret.Bytes(), []byte(Redacted), -1)
+	}
+	secretsMu.RUnlock()
+	return string(tokenPattern.ReplaceAll(redacted, []byte(Redacted)))
+}
+
+// redactField returns the value of a field, Redacted if it holds a secret
+func redactField(key string, value interface{}) interface{} {
+	if IsSensitiveField(key) {
+		return Redacted
+	}
+	switch value := value.(type) {
+	case string:
+		return Redact(value)
+	case error:
+		return Redact(value.Error())
+	case fmt.Stringer:
+		return Redact(value.String())
+	case map[string]interface{}:
+		return RedactMap(value)
+	}
+	return value
+}
+
+// RedactMap returns a copy of a payload (e.g. the config of an auth method) with the values of
+// the sensitive keys and the secrets in the other values replaced with Redacted
+func RedactMap(payload map[string]interface{}) map[string]interface{} {
+	redacted := make(map[string]interface{}, len(payload))
+	for key, value := range payload {
+		redacted[key] = redactField(key, value)
+	}
+	return redacted
+}
+
+// redactingLogger redacts the entries before passing them to the wrapped Logger
+type redactingLogger struct {
+	logger Logger
+}
+
+// NewRedacting returns a Logger which never passes the registered secrets, the Vault tokens and the
+// values of the sensitive fields to logger
+func NewRedacting(logger Logger) Logger {
+	if _, ok := logger.(*redactingLogger); ok {
+		return logger
+	}
+	return &redactingLogger{logger}
+}
+
+func (l *redactingLogger) Debugf(format string, args ...interface{}) {
+	l.logger.Debugf("%s", Redact(fmt.Sprintf(format, redactArgs(args)...)))
+}
+
+func (l *redactingLogger) Infof(format string, args ...interface{}) {
+	l.logger.Infof("%s", Redact(fmt.Sprintf(format, redactArgs(args)...)))
+}
+
+func (l *redactingLogger) Warnf(format string, args ...interface{}) {
+	l.logger.Warnf("%s", Redact(fmt.Sprintf(format, redactArgs(args)...)))
+}
+
+func (l *redactingLogger) Errorf(format string, args ...interface{}) {
+	l.logger.Errorf("%s", Redact(fmt.Sprintf(format, redactArgs(args)...)))
+}
+
+func (l *redactingLogger) WithField(key string, value interface{}) Logger {
+	return &redactingLogger{l.logger.WithField(key, redactField(key, value))}
+}
+
+// redactArgs redacts the sensitive keys of the payloads among the arguments of a log entry,
+// the secrets in the other arguments are redacted from the formatted message
+func redactArgs(args []interface{}) []interface{} {
+	redacted := make([]interface{}, len(args))
+	for i, arg := range args {
+		if payload, ok := arg.(map[string]interface{}); ok {
+			arg = RedactMap(payload)
+		}
+		redacted[i] = arg
+	}
+	return redacted
+}
+
+// redactingFormatter redacts the logrus entries before formatting them
+type redactingFormatter struct {
+	formatter logrus.Formatter
+}
+
+// NewRedactingFormatter returns a logrus formatter which redacts the entries like NewRedacting before
+// formatting them with formatter, so the entries logged with logrus directly are redacted as well
+func NewRedactingFormatter(formatter logrus.Formatter) logrus.Formatter {
+	return &redactingFormatter{formatter}
+}
+
+func (f *redactingFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	redacted := *entry
+	redacted.Message = Redact(entry.Message)
+	redacted.Data = make(logrus.Fields, len(entry.Data))
+	for key, value := range entry.Data {
+		redacted.Data[key] = redactField(key, value)
+	}
+	return f.formatter.Format(&redacted)
+}
@@ -0,0 +1,143 @@
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	testUnsealKey = "c5d4a2b0e6f1a8c3d9b7e2f4a6c8d0b1e3f5a7c9d1b3e5f7a9c1d3e5f7a9c1d3"
+	testRootToken = "9c9a5f3e-7b1d-4c2a-8e6f-0d1b2c3a4e5f"
+	testBindPass  = "ldap-bind-secret"
+	testVaultTok  = "s.Yb0AeGx3ZuGgvJxd2aTfYHnm"
+)
+
+func newTestLogger() (Logger, *bytes.Buffer) {
+	var buffer bytes.Buffer
+	logger := logrus.New()
+	logger.Out = &buffer
+	logger.Level = logrus.DebugLevel
+	logger.Formatter = &logrus.JSONFormatter{}
+	return NewRedacting(NewLogrus(logger)), &buffer
+}
+
+func assertRedacted(t *testing.T, output string, secrets ...string) {
+	for _, secret := range secrets {
+		if strings.Contains(output, secret) {
+			t.Errorf("secret %q has been logged: %s", secret, output)
+		}
+	}
+	if !strings.Contains(output, Redacted) {
+		t.Errorf("no redacted value has been logged: %s", output)
+	}
+}
+
+func TestRedactRegisteredSecrets(t *testing.T) {
+	RegisterSecret(testUnsealKey)
+	RegisterSecret(testRootToken)
+
+	logger, buffer := newTestLogger()
+	logger.Debugf("got unseal key: %s", testUnsealKey)
+	logger.Warnf("won't store root token %s", testRootToken)
+	logger.Errorf("error: %s", errors.New("invalid key "+testUnsealKey))
+
+	assertRedacted(t, buffer.String(), testUnsealKey, testRootToken)
+}
+
+func TestRedactVaultTokens(t *testing.T) {
+	logger, buffer := newTestLogger()
+	logger.Infof("using token %s", testVaultTok)
+
+	assertRedacted(t, buffer.String(), testVaultTok)
+}
+
+func TestRedactSensitiveFields(t *testing.T) {
+	logger, buffer := newTestLogger()
+	logger.WithField("root-token", "not-registered-token").Warnf("root token")
+	logger.WithField("bindpass", "not-registered-pass").Infof("ldap")
+	logger.WithField("key", "vault-unseal-0").Infof("unseal key stored in key store")
+
+	output := buffer.String()
+	assertRedacted(t, output, "not-registered-token", "not-registered-pass")
+	if !strings.Contains(output, "vault-unseal-0") {
+		t.Errorf("the key ID has been redacted: %s", output)
+	}
+}
+
+func TestRedactPayloads(t *testing.T) {
+	config := map[string]interface{}{
+		"url":      "ldap://ldap.example.com",
+		"binddn":   "cn=vault,ou=Users,dc=example,dc=com",
+		"bindpass": testBindPass,
+	}
+
+	logger, buffer := newTestLogger()
+	logger.Errorf("error putting %v ldap config into vault", config)
+	logger.WithField("config", config).Infof("ldap config")
+
+	output := buffer.String()
+	assertRedacted(t, output, testBindPass)
+	if !strings.Contains(output, "ldap.example.com") {
+		t.Errorf("the non-sensitive fields have been redacted: %s", output)
+	}
+	if config["bindpass"] != testBindPass {
+		t.Errorf("the payload has been modified: %v", config)
+	}
+}
+
+func TestRedactingFormatter(t *testing.T) {
+	RegisterSecret(testUnsealKey)
+
+	var buffer bytes.Buffer
+	logger := logrus.New()
+	logger.Out = &buffer
+	logger.Formatter = NewRedactingFormatter(&logrus.TextFormatter{DisableColors: true})
+
+	logger.WithField("token", testVaultTok).WithError(errors.New(testUnsealKey)).Errorf("unseal key %s", testUnsealKey)
+
+	assertRedacted(t, buffer.String(), testUnsealKey, testVaultTok)
+}
+
+func TestRegisterShortSecret(t *testing.T) {
+	RegisterSecret("vault")
+
+	if Redact("vault-unseal-0") != "vault-unseal-0" {
+		t.Errorf("a short value has been registered as a secret")
+	}
+}
+
+func TestLeveledLogger(t *testing.T) {
+	levels, err := ParseLevels("vault=debug, runner=error", logrus.InfoLevel)
+	if err != nil {
+		t.Fatalf("error parsing levels: %s", err.Error())
+	}
+	if levels.Max() != logrus.DebugLevel {
+		t.Errorf("expected the debug level to be the most verbose, got %s", levels.Max())
+	}
+
+	logger, buffer := newTestLogger()
+	logger = NewLeveled(logger, levels)
+	logger.WithField(ComponentField, "vault").Debugf("vault debug %s", testVaultTok)
+	logger.WithField(ComponentField, "runner").Warnf("runner warning")
+	logger.Debugf("default debug")
+	logger.Infof("default info")
+
+	output := buffer.String()
+	if !strings.Contains(output, "vault debug") || !strings.Contains(output, "default info") {
+		t.Errorf("expected the enabled entries to be logged: %s", output)
+	}
+	if strings.Contains(output, "runner warning") || strings.Contains(output, "default debug") {
+		t.Errorf("expected the entries below the levels to be dropped: %s", output)
+	}
+	if strings.Contains(output, testVaultTok) {
+		t.Errorf("the token has been logged at the debug level: %s", output)
+	}
+
+	if _, err := ParseLevels("vault=verbose", logrus.InfoLevel); err == nil {
+		t.Errorf("expected an error parsing an invalid level")
+	}
+}
@@ -0,0 +1,233 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// metric is a labeled Prometheus metric family
+type metric struct {
+	name       string
+	help       string
+	metricType string
+	labelNames []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// collector is a metric family which can be written in the Prometheus text format
+type collector interface {
+	write(w io.Writer)
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []collector
+)
+
+func register(c collector) {
+	registryMu.Lock()
+	registry = append(registry, c)
+	registryMu.Unlock()
+}
+
+func newMetric(name, help, metricType string, labelNames []string) *metric {
+	m := &metric{
+		name:       name,
+		help:       help,
+		metricType: metricType,
+		labelNames: labelNames,
+		values:     map[string]float64{},
+	}
+	register(m)
+	return m
+}
+
+// labels formats the label values in the Prometheus text format
+func (m *metric) labels(labelValues []string) string {
+	if len(labelValues) != len(m.labelNames) {
+		panic(fmt.Sprintf("metric %s has %d labels, got %d values", m.name, len(m.labelNames), len(labelValues)))
+	}
+	return formatLabels(m.labelNames, labelValues)
+}
+
+func formatLabels(labelNames, labelValues []string) string {
+	if len(labelValues) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(labelValues))
+	for i, value := range labelValues {
+		value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+		pairs[i] = fmt.Sprintf(`%s="%s"`, labelNames[i], value)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func (m *metric) add(delta float64, labelValues []string) {
+	labels := m.labels(labelValues)
+	m.mu.Lock()
+	m.values[labels] += delta
+	m.mu.Unlock()
+}
+
+func (m *metric) set(value float64, labelValues []string) {
+	labels := m.labels(labelValues)
+	m.mu.Lock()
+	m.values[labels] = value
+	m.mu.Unlock()
+}
+
+func (m *metric) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", m.name, m.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.metricType)
+
+	labels := make([]string, 0, len(m.values))
+	for l := range m.values {
+		labels = append(labels, l)
+	}
+	sort.Strings(labels)
+	for _, l := range labels {
+		fmt.Fprintf(w, "%s%s %v\n", m.name, l, m.values[l])
+	}
+}
+
+// Counter is a metric which only goes up
+type Counter struct {
+	*metric
+}
+
+// NewCounter registers a new Counter with the given label names
+func NewCounter(name, help string, labelNames ...string) *Counter {
+	return &Counter{newMetric(name, help, "counter", labelNames)}
+}
+
+// Inc increments the counter with the given label values by one
+func (c *Counter) Inc(labelValues ...string) {
+	c.add(1, labelValues)
+}
+
+// Gauge is a metric which can go up and down
+type Gauge struct {
+	*metric
+}
+
+// NewGauge registers a new Gauge with the given label names
+func NewGauge(name, help string, labelNames ...string) *Gauge {
+	return &Gauge{newMetric(name, help, "gauge", labelNames)}
+}
+
+// Set sets the value of the gauge with the given label values
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.set(value, labelValues)
+}
+
+// DefaultBuckets are the upper bounds of the buckets of a Histogram in seconds, for latencies of network calls
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Histogram is a metric which counts observed values in buckets, e.g. latencies
+type Histogram struct {
+	name       string
+	help       string
+	labelNames []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labelValues []string
+	counts      []uint64
+	sum         float64
+	count       uint64
+}
+
+// NewHistogram registers a new Histogram with the given bucket upper bounds and label names
+func NewHistogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+	h := &Histogram{
+		name:       name,
+		help:       help,
+		labelNames: labelNames,
+		buckets:    buckets,
+		series:     map[string]*histogramSeries{},
+	}
+	register(h)
+	return h
+}
+
+// Observe adds a value to the histogram with the given label values
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	if len(labelValues) != len(h.labelNames) {
+		panic(fmt.Sprintf("metric %s has %d labels, got %d values", h.name, len(h.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{labelValues: labelValues, counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			s.counts[i]++
+		}
+	}
+	s.sum += value
+	s.count++
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", h.name, h.help)
+	fmt.Fprintf(w, "# TYPE %s histogram\n", h.name)
+
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	bucketLabelNames := append(append([]string{}, h.labelNames...), "le")
+	for _, key := range keys {
+		s := h.series[key]
+		for i, bound := range h.buckets {
+			labels := formatLabels(bucketLabelNames, append(append([]string{}, s.labelValues...), fmt.Sprint(bound)))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labels, s.counts[i])
+		}
+		labels := formatLabels(bucketLabelNames, append(append([]string{}, s.labelValues...), "+Inf"))
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labels, s.count)
+		fmt.Fprintf(w, "%s_sum%s %v\n", h.name, formatLabels(h.labelNames, s.labelValues), s.sum)
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labelNames, s.labelValues), s.count)
+	}
+}
+
+// Handler returns a http.Handler serving every registered metric in the Prometheus text format
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		registryMu.Lock()
+		metrics := append([]collector{}, registry...)
+		registryMu.Unlock()
+		for _, m := range metrics {
+			m.write(w)
+		}
+	})
+}
+
+// Serve serves the metrics on the /metrics path of the given address
+func Serve(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	return http.ListenAndServe(addr, mux)
+}
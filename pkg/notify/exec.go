@@ -0,0 +1,68 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// The environment variables of the commands, besides the environment of the process
+const (
+	EnvEventType   = "BANK_VAULTS_EVENT_TYPE"
+	EnvEventTarget = "BANK_VAULTS_EVENT_TARGET"
+)
+
+// maxOutput is the length of the output of a command kept in the errors
+const maxOutput = 1024
+
+type execNotifier struct {
+	command []string
+	payload *Payload
+}
+
+// NewExec returns a Notifier running the command with the event on its standard input, as JSON or
+// rendered by the payload if it isn't nil, and its type and target in the BANK_VAULTS_EVENT_*
+// environment variables. The notification fails if the command exits with a non-zero status.
+func NewExec(command []string, payload *Payload) (Notifier, error) {
+	if len(command) == 0 {
+		return nil, fmt.Errorf("empty notification command")
+	}
+	return &execNotifier{command: command, payload: payload}, nil
+}
+
+func (e *execNotifier) Notify(event Event) error {
+	var input []byte
+	var err error
+	if e.payload != nil {
+		input, err = e.payload.Render(event)
+	} else {
+		input, err = json.Marshal(event)
+	}
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, e.command[0], e.command[1:]...)
+	cmd.Env = append(os.Environ(),
+		EnvEventType+"="+event.Type,
+		EnvEventTarget+"="+event.Target,
+	)
+	cmd.Stdin = bytes.NewReader(input)
+	output, err := cmd.CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("%s timed out after %s", e.command[0], sendTimeout)
+	}
+	if err != nil {
+		if len(output) > maxOutput {
+			output = output[:maxOutput]
+		}
+		return fmt.Errorf("%s failed: %s: %s", e.command[0], err.Error(), bytes.TrimSpace(output))
+	}
+	return nil
+}
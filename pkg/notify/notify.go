@@ -0,0 +1,140 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// The types of the lifecycle events
+const (
+	EventInitialized = "initialized"
+	EventUnsealed    = "unsealed"
+	EventRekeyed     = "rekeyed"
+	EventSealed      = "sealed"
+	// EventRootTokenStored is sent when the root token of the initialization has been stored in the
+	// key store
+	EventRootTokenStored = "root-token-stored"
+	// EventKeyWrapped is sent for every unseal or recovery key the initialization has handed off in a
+	// response-wrapping token instead of storing it, the token is in the WrappingToken of the event
+	EventKeyWrapped = "key-wrapped"
+	// EventRootTokenRotated is sent when the stored root token has been replaced and revoked
+	EventRootTokenRotated = "root-token-rotated"
+	// EventConfigureFailed is sent when the configuration fails, EventConfigured when it succeeds
+	// again after a failure
+	EventConfigureFailed = "configure-failed"
+	EventConfigured      = "configured"
+)
+
+// EventTypes are all the event types, in the order of the lifecycle
+var EventTypes = []string{EventInitialized, EventKeyWrapped, EventRootTokenStored, EventSealed, EventUnsealed, EventConfigureFailed, EventConfigured, EventRekeyed, EventRootTokenRotated}
+
+// sendTimeout is the timeout of sending a notification
+const sendTimeout = 10 * time.Second
+
+// Event is a lifecycle event of a Vault instance
+type Event struct {
+	Type string `json:"type"`
+	// Target is the name of the Vault cluster or Pod, empty for the single Vault of the process
+	Target  string    `json:"target,omitempty"`
+	Message string    `json:"message"`
+	Error   string    `json:"error,omitempty"`
+	Time    time.Time `json:"time"`
+	// WrappingToken is the response-wrapping token of a key-wrapped event, it isn't in the summary
+	WrappingToken string `json:"wrappingToken,omitempty"`
+}
+
+// NewEvent returns an event of the given type happening now, with the error if it is a failure
+func NewEvent(eventType, target, message string, err error) Event {
+	event := Event{Type: eventType, Target: target, Message: message, Time: time.Now().UTC()}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	return event
+}
+
+// Failure returns true if the event needs attention, e.g. Vault has been sealed
+func (e Event) Failure() bool {
+	return e.Type == EventSealed || e.Type == EventConfigureFailed
+}
+
+// summary is the one line description of the event in chat messages and incidents
+func (e Event) summary() string {
+	summary := "vault"
+	if e.Target != "" {
+		summary += " " + e.Target
+	}
+	summary += ": " + e.Message
+	if e.Error != "" {
+		summary += ": " + e.Error
+	}
+	return summary
+}
+
+// Notifier sends the lifecycle events somewhere, e.g. to the on-call team
+type Notifier interface {
+	Notify(event Event) error
+}
+
+type multiNotifier []Notifier
+
+// Multi sends the events to every notifier, and returns the first error after trying all of them
+func Multi(notifiers ...Notifier) Notifier {
+	return multiNotifier(notifiers)
+}
+
+func (m multiNotifier) Notify(event Event) error {
+	var firstErr error
+	for _, notifier := range m {
+		if err := notifier.Notify(event); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+type filteredNotifier struct {
+	notifier Notifier
+	types    map[string]bool
+}
+
+// Filter sends only the events of the given types to the notifier
+func Filter(notifier Notifier, types ...string) Notifier {
+	f := &filteredNotifier{notifier: notifier, types: map[string]bool{}}
+	for _, eventType := range types {
+		f.types[eventType] = true
+	}
+	return f
+}
+
+func (f *filteredNotifier) Notify(event Event) error {
+	if !f.types[event.Type] {
+		return nil
+	}
+	return f.notifier.Notify(event)
+}
+
+// postJSON sends the body to the url, which has to respond with a 2xx status
+func postJSON(client *http.Client, url string, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	return post(client, url, data)
+}
+
+// post sends the JSON data to the url, which has to respond with a 2xx status
+func post(client *http.Client, url string, data []byte) error {
+	resp, err := client.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status from %s: %s", url, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPagerDutyResolvesTheIncidentOfTheFailure(t *testing.T) {
+	var bodies []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("invalid body: %s", err.Error())
+		}
+		bodies = append(bodies, body)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	notifier := Filter(&pagerDutyNotifier{url: server.URL, routingKey: "key", client: server.Client()},
+		EventSealed, EventUnsealed)
+
+	events := []Event{
+		NewEvent(EventSealed, "vault-0", "vault is sealed", nil),
+		NewEvent(EventConfigureFailed, "vault-0", "vault configuration failed", errors.New("permission denied")),
+		NewEvent(EventUnsealed, "vault-0", "vault has been unsealed", nil),
+	}
+	for _, event := range events {
+		if err := notifier.Notify(event); err != nil {
+			t.Fatalf("error notifying %s: %s", event.Type, err.Error())
+		}
+	}
+
+	if len(bodies) != 2 {
+		t.Fatalf("expected 2 PagerDuty events, got %d", len(bodies))
+	}
+	for i, action := range []string{"trigger", "resolve"} {
+		if bodies[i]["event_action"] != action {
+			t.Errorf("expected %s, got %v", action, bodies[i]["event_action"])
+		}
+		if bodies[i]["dedup_key"] != "bank-vaults/vault-0/sealed" {
+			t.Errorf("unexpected dedup_key: %v", bodies[i]["dedup_key"])
+		}
+	}
+}
+
+func TestWebhookStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	if err := NewWebhook(server.URL).Notify(NewEvent(EventRekeyed, "", "vault has been rekeyed", nil)); err == nil {
+		t.Fatal("expected an error for a 500 response")
+	}
+}
+
+func TestTemplatedWebhook(t *testing.T) {
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	payload, err := ParsePayload(`{"title": {{ json .Type }}, "body": {{ json .Summary }}, "urgent": {{ .Failure }}}`)
+	if err != nil {
+		t.Fatalf("error parsing the payload: %s", err.Error())
+	}
+	event := NewEvent(EventSealed, "vault-0", `vault is "sealed"`, nil)
+	if err := NewTemplatedWebhook(server.URL, payload).Notify(event); err != nil {
+		t.Fatalf("error notifying: %s", err.Error())
+	}
+	if expected := `{"title": "sealed", "body": "vault vault-0: vault is \"sealed\"", "urgent": true}`; string(body) != expected {
+		t.Errorf("expected %s, got %s", expected, body)
+	}
+
+	if _, err := ParsePayload("{{ .Type "); err == nil {
+		t.Errorf("expected an error for the invalid template")
+	}
+}
+
+func TestExec(t *testing.T) {
+	notifier, err := NewExec([]string{"sh", "-c", `test "$BANK_VAULTS_EVENT_TYPE" = root-token-stored && grep -q '"target":"vault-0"'`}, nil)
+	if err != nil {
+		t.Fatalf("error creating the notifier: %s", err.Error())
+	}
+	if err := notifier.Notify(NewEvent(EventRootTokenStored, "vault-0", "the root token has been stored", nil)); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if err := notifier.Notify(NewEvent(EventSealed, "vault-0", "vault is sealed", nil)); err == nil {
+		t.Errorf("expected the error of the failing command")
+	}
+
+	if _, err := NewExec(nil, nil); err == nil {
+		t.Errorf("expected an error for the empty command")
+	}
+}
@@ -0,0 +1,58 @@
+package notify
+
+import (
+	"net/http"
+)
+
+// PagerDutyEventsURL is the endpoint of the PagerDuty Events API v2
+const PagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+type pagerDutyNotifier struct {
+	url        string
+	routingKey string
+	client     *http.Client
+}
+
+// NewPagerDuty returns a Notifier triggering PagerDuty incidents with the Events API v2 of the
+// service of the routing key. The failures (a sealed Vault, a failed configuration) trigger an
+// incident, which is resolved when Vault is unsealed or configured again, the other events
+// are ignored.
+func NewPagerDuty(routingKey string) Notifier {
+	return &pagerDutyNotifier{url: PagerDutyEventsURL, routingKey: routingKey, client: &http.Client{Timeout: sendTimeout}}
+}
+
+func (p *pagerDutyNotifier) Notify(event Event) error {
+	// The incidents are deduplicated by the failure and its target, so they can be resolved
+	var action, failure string
+	switch event.Type {
+	case EventSealed, EventConfigureFailed:
+		action, failure = "trigger", event.Type
+	case EventUnsealed:
+		action, failure = "resolve", EventSealed
+	case EventConfigured:
+		action, failure = "resolve", EventConfigureFailed
+	default:
+		return nil
+	}
+
+	body := map[string]interface{}{
+		"routing_key":  p.routingKey,
+		"event_action": action,
+		"dedup_key":    "bank-vaults/" + event.Target + "/" + failure,
+	}
+	if action == "trigger" {
+		source := event.Target
+		if source == "" {
+			source = "vault"
+		}
+		body["payload"] = map[string]interface{}{
+			"summary":        event.summary(),
+			"source":         source,
+			"severity":       "critical",
+			"timestamp":      event.Time,
+			"component":      "vault",
+			"custom_details": event,
+		}
+	}
+	return postJSON(p.client, p.url, body)
+}
@@ -0,0 +1,49 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"text/template"
+)
+
+// Payload is a text/template rendering the events into the payloads of the notifications, e.g. the
+// body of a webhook in the format of the receiver. The fields of the Event are available, and the
+// summary of the event as .Summary and whether it needs attention as .Failure, the json function
+// quotes a value as JSON:
+//
+//	{"title": {{ json .Type }}, "body": {{ json .Summary }}}
+type Payload struct {
+	template *template.Template
+}
+
+// payloadData is the data of the Payload templates
+type payloadData struct {
+	Event
+	Summary string
+	Failure bool
+}
+
+// ParsePayload parses the text/template of a Payload
+func ParsePayload(text string) (*Payload, error) {
+	tmpl, err := template.New("payload").Option("missingkey=error").Funcs(template.FuncMap{
+		"json": func(value interface{}) (string, error) {
+			data, err := json.Marshal(value)
+			return string(data), err
+		},
+	}).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing the notification payload: %s", err.Error())
+	}
+	return &Payload{template: tmpl}, nil
+}
+
+// Render renders the payload of the event
+func (p *Payload) Render(event Event) ([]byte, error) {
+	var payload bytes.Buffer
+	data := payloadData{Event: event, Summary: event.summary(), Failure: event.Failure()}
+	if err := p.template.Execute(&payload, data); err != nil {
+		return nil, fmt.Errorf("error rendering the notification payload: %s", err.Error())
+	}
+	return payload.Bytes(), nil
+}
@@ -0,0 +1,36 @@
+package notify
+
+import (
+	"net/http"
+)
+
+type slackNotifier struct {
+	url     string
+	payload *Payload
+	client  *http.Client
+}
+
+// NewSlack returns a Notifier posting the events as messages to a Slack incoming webhook url
+func NewSlack(url string) Notifier {
+	return NewTemplatedSlack(url, nil)
+}
+
+// NewTemplatedSlack returns a Notifier posting the events as messages rendered by the payload to a
+// Slack incoming webhook url, the summaries of the events if the payload is nil
+func NewTemplatedSlack(url string, payload *Payload) Notifier {
+	return &slackNotifier{url: url, payload: payload, client: &http.Client{Timeout: sendTimeout}}
+}
+
+func (s *slackNotifier) Notify(event Event) error {
+	text := event.summary()
+	if s.payload != nil {
+		rendered, err := s.payload.Render(event)
+		if err != nil {
+			return err
+		}
+		text = string(rendered)
+	} else if event.Failure() {
+		text = ":rotating_light: " + text
+	}
+	return postJSON(s.client, s.url, map[string]string{"text": text})
+}
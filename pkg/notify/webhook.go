@@ -0,0 +1,33 @@
+package notify
+
+import (
+	"net/http"
+)
+
+type webhookNotifier struct {
+	url     string
+	payload *Payload
+	client  *http.Client
+}
+
+// NewWebhook returns a Notifier posting the events as JSON to the url
+func NewWebhook(url string) Notifier {
+	return NewTemplatedWebhook(url, nil)
+}
+
+// NewTemplatedWebhook returns a Notifier posting the events rendered by the payload to the url, as
+// JSON if the payload is nil
+func NewTemplatedWebhook(url string, payload *Payload) Notifier {
+	return &webhookNotifier{url: url, payload: payload, client: &http.Client{Timeout: sendTimeout}}
+}
+
+func (w *webhookNotifier) Notify(event Event) error {
+	if w.payload == nil {
+		return postJSON(w.client, w.url, event)
+	}
+	body, err := w.payload.Render(event)
+	if err != nil {
+		return err
+	}
+	return post(w.client, w.url, body)
+}
@@ -0,0 +1,526 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/banzaicloud/bank-vaults/pkg/admin"
+	"github.com/banzaicloud/bank-vaults/pkg/lock"
+	"github.com/banzaicloud/bank-vaults/pkg/logging"
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+)
+
+// The defaults of the Config
+const (
+	DefaultUnsealPeriod      = 30 * time.Second
+	DefaultLockRetryInterval = 5 * time.Second
+)
+
+// The phases of a round of the Runner
+const (
+	PhaseInit      = "init"
+	PhaseSealCheck = "seal-check"
+	PhaseUnseal    = "unseal"
+	PhaseRekey     = "rekey"
+	// PhaseRotateRootToken is the rotation of the root token when it has reached RootTokenRotationPeriod
+	PhaseRotateRootToken = "rotate-root-token"
+	PhaseConfigure       = "configure"
+)
+
+// The states of the Vault managed by a Runner
+const (
+	// StatePending is the state before the first round
+	StatePending = "pending"
+	// StateUninitialized is the state while the initialization fails
+	StateUninitialized = "uninitialized"
+	StateSealed        = "sealed"
+	StateUnsealed      = "unsealed"
+	// StateConfigured is the state of an unsealed Vault with the current configuration applied
+	StateConfigured = "configured"
+)
+
+// Error is the error of a phase of a round
+type Error struct {
+	Phase string
+	Err   error
+}
+
+func (e *Error) Error() string {
+	switch e.Phase {
+	case PhaseInit:
+		return fmt.Sprintf("error initializing vault: %s", e.Err.Error())
+	case PhaseSealCheck:
+		return fmt.Sprintf("error checking if vault is sealed: %s", e.Err.Error())
+	case PhaseUnseal:
+		return fmt.Sprintf("error unsealing vault: %s", e.Err.Error())
+	case PhaseRekey:
+		return fmt.Sprintf("error rekeying vault: %s", e.Err.Error())
+	case PhaseRotateRootToken:
+		return fmt.Sprintf("error rotating the root token of vault: %s", e.Err.Error())
+	default:
+		return fmt.Sprintf("error configuring vault: %s", e.Err.Error())
+	}
+}
+
+// Unwrap returns the error of the phase
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Hooks are called by the Runner after the lifecycle actions, e.g. to emit events or metrics,
+// the nil hooks are skipped. They are called from the goroutine running the rounds.
+type Hooks struct {
+	// Initialized is called after Init, result.AlreadyInitialized is true if Vault had been initialized before
+	Initialized func(result *vault.InitResult)
+	InitFailed  func(err error)
+	// SealChecked is called after every successful check of the seal status
+	SealChecked     func(sealed bool)
+	SealCheckFailed func(err error)
+	// Sealed is called when Vault is found sealed for the first time, or after it has been unsealed
+	Sealed       func()
+	Unsealed     func()
+	UnsealFailed func(err error)
+	// Rekeyed is called after the keys have been replaced because of their age
+	Rekeyed     func(result *vault.RekeyResult)
+	RekeyFailed func(err error)
+	// RootTokenRotated is called after the root token has been rotated because of its age
+	RootTokenRotated        func()
+	RootTokenRotationFailed func(err error)
+	// Configured and ConfigureFailed are called after the configuration, the report is nil if the
+	// configuration couldn't be started
+	Configured      func(report *vault.ConfigureReport)
+	ConfigureFailed func(report *vault.ConfigureReport, err error)
+}
+
+// Config is the configuration of a Runner
+type Config struct {
+	// Init makes the Runner initialize Vault before it is unsealed for the first time
+	Init bool
+	// Lock is held while Vault is initialized or configured, so only one replica does it at the
+	// same time, it isn't locked if nil
+	Lock              lock.Locker
+	LockRetryInterval time.Duration
+	// LockTimeout is how long to wait for the lock, 0 means forever
+	LockTimeout time.Duration
+
+	// UnsealPeriod is the time between the rounds of Run, DefaultUnsealPeriod if 0
+	UnsealPeriod time.Duration
+
+	// RekeyPeriod is the age of the keys after which the unsealed Vault is rekeyed (with the shares
+	// and threshold of the helper), never if 0. The keys without metadata are rekeyed right away,
+	// as their age is unknown. The helper has to implement vault.Rekeyer.
+	RekeyPeriod time.Duration
+	// RootTokenRotationPeriod is the age of the stored root token after which it is replaced with
+	// a new one and revoked, never if 0. The helper has to implement vault.RootTokenRotator.
+	RootTokenRotationPeriod time.Duration
+
+	// Configuration returns the external configuration applied after Vault has been unsealed, and
+	// after Reconfigure, Vault isn't configured if it is nil
+	Configuration func() (*vault.ExternalConfig, error)
+	// ConfigurePeriod is how often the configuration is reapplied by Run besides Reconfigure, never if 0
+	ConfigurePeriod time.Duration
+
+	// Status is the admin server target the results are reported to, nil if none. The key store is
+	// checked in every round for it, if the helper is a vault.KeyStoreChecker.
+	Status *admin.Target
+	// Logger is the logger of the Runner, logging.Default() if nil
+	Logger logging.Logger
+	Hooks  Hooks
+}
+
+// Vault is the part of vault.Vault used by the Runner
+type Vault interface {
+	vault.Initializer
+	vault.Unsealer
+	vault.Configurer
+}
+
+// Runner initializes, unseals and configures a Vault, in rounds like the bank-vaults unseal and
+// configure commands, so applications (e.g. other controllers) can embed it instead of running
+// bank-vaults. It is safe for concurrent use, the rounds are serialized.
+type Runner struct {
+	vault  Vault
+	config Config
+	log    logging.Logger
+
+	// round serializes the rounds
+	round sync.Mutex
+
+	mu    sync.Mutex
+	state string
+	// initialized is true after Vault has been initialized (or found initialized)
+	initialized bool
+	// sealed is true if Vault has been found sealed, so the Sealed hook is called once
+	sealed bool
+	// configured is true if the current configuration has been applied
+	configured bool
+
+	reconfigure chan struct{}
+}
+
+// New returns a Runner managing the Vault of the helper
+func New(v Vault, config Config) *Runner {
+	if config.UnsealPeriod == 0 {
+		config.UnsealPeriod = DefaultUnsealPeriod
+	}
+	if config.LockRetryInterval == 0 {
+		config.LockRetryInterval = DefaultLockRetryInterval
+	}
+	log := config.Logger
+	if log == nil {
+		log = logging.Default()
+	}
+	if config.Configuration != nil && config.Status != nil {
+		config.Status.RequireConfiguration()
+	}
+	return &Runner{
+		vault:       v,
+		config:      config,
+		log:         log.WithField(logging.ComponentField, "runner"),
+		state:       StatePending,
+		reconfigure: make(chan struct{}, 1),
+	}
+}
+
+// State returns the state of Vault after the last round
+func (r *Runner) State() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.state
+}
+
+func (r *Runner) setState(state string) {
+	r.mu.Lock()
+	r.state = state
+	r.mu.Unlock()
+}
+
+// Reconfigure makes the next round apply the configuration again (e.g. after it has changed),
+// and starts the next round of Run right away
+func (r *Runner) Reconfigure() {
+	r.mu.Lock()
+	r.configured = false
+	r.mu.Unlock()
+
+	select {
+	case r.reconfigure <- struct{}{}:
+	default:
+	}
+}
+
+// Step runs a single round: it initializes Vault (if Init is set and it hasn't been initialized
+// yet), checks whether it is sealed and unseals it, then applies the configuration if it hasn't
+// been applied yet, unless vault.ConfigurePauseFile exists. The error is an *Error with the failed
+// phase.
+func (r *Runner) Step(ctx context.Context) error {
+	r.round.Lock()
+	defer r.round.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if r.config.Init && !r.initialized {
+		if err := r.init(ctx); err != nil {
+			return err
+		}
+	}
+
+	r.checkKeyStore(ctx)
+
+	r.log.Infof("checking if vault is sealed...")
+	sealed, err := r.vault.Sealed(ctx)
+	r.reportSealed(sealed, err)
+	if err != nil {
+		call(r.config.Hooks.SealCheckFailed, err)
+		return &Error{Phase: PhaseSealCheck, Err: err}
+	}
+	r.log.Infof("vault sealed: %t", sealed)
+	if r.config.Hooks.SealChecked != nil {
+		r.config.Hooks.SealChecked(sealed)
+	}
+
+	if sealed {
+		r.mu.Lock()
+		wasSealed := r.sealed
+		r.sealed = true
+		r.configured = false
+		r.mu.Unlock()
+		r.setState(StateSealed)
+		if !wasSealed && r.config.Hooks.Sealed != nil {
+			r.config.Hooks.Sealed()
+		}
+
+		err = r.vault.Unseal(ctx)
+		r.reportUnsealed(err)
+		if err != nil {
+			call(r.config.Hooks.UnsealFailed, err)
+			return &Error{Phase: PhaseUnseal, Err: err}
+		}
+		r.mu.Lock()
+		r.sealed = false
+		r.mu.Unlock()
+		r.log.Infof("successfully unsealed vault")
+		if r.config.Hooks.Unsealed != nil {
+			r.config.Hooks.Unsealed()
+		}
+	} else {
+		r.mu.Lock()
+		r.sealed = false
+		r.mu.Unlock()
+	}
+
+	// The rest of the round writes to Vault, it is skipped while paused and done in a later round
+	if vault.ConfigurePaused() {
+		r.log.Infof("configuration is paused, skipping it in this round")
+		return nil
+	}
+
+	if r.config.RekeyPeriod > 0 {
+		if err := r.rekey(); err != nil {
+			return err
+		}
+	}
+	if r.config.RootTokenRotationPeriod > 0 {
+		if err := r.rotateRootToken(); err != nil {
+			return err
+		}
+	}
+
+	r.mu.Lock()
+	configured := r.configured
+	r.mu.Unlock()
+	if configured {
+		return nil
+	}
+	r.setState(StateUnsealed)
+	if r.config.Configuration == nil {
+		return nil
+	}
+	return r.configure()
+}
+
+func (r *Runner) init(ctx context.Context) error {
+	r.log.Infof("initializing vault...")
+	var result *vault.InitResult
+	err := r.withLock("initialize", func() (err error) {
+		result, err = r.vault.Init(ctx)
+		return err
+	})
+	if r.config.Status != nil {
+		r.config.Status.ReportInitialized(err)
+	}
+	if err != nil {
+		r.setState(StateUninitialized)
+		call(r.config.Hooks.InitFailed, err)
+		return &Error{Phase: PhaseInit, Err: err}
+	}
+	r.initialized = true
+	if r.config.Hooks.Initialized != nil {
+		r.config.Hooks.Initialized(result)
+	}
+	return nil
+}
+
+// rekey rekeys Vault if its keys are older than RekeyPeriod
+func (r *Runner) rekey() error {
+	rekeyer, ok := r.vault.(vault.Rekeyer)
+	if !ok {
+		return nil
+	}
+	due := func() (bool, error) {
+		metadata, err := rekeyer.KeysMetadata()
+		if err != nil {
+			return false, err
+		}
+		return metadata == nil || time.Since(metadata.Created) >= r.config.RekeyPeriod, nil
+	}
+
+	var result *vault.RekeyResult
+	done, err := r.whenDue("rekey", due, func() (err error) {
+		r.log.Infof("the keys of vault are older than %s, rekeying...", r.config.RekeyPeriod)
+		result, err = rekeyer.Rekey(vault.RekeyOptions{})
+		return err
+	})
+	if err != nil {
+		call(r.config.Hooks.RekeyFailed, err)
+		return &Error{Phase: PhaseRekey, Err: err}
+	}
+	if done {
+		r.log.Infof("successfully rekeyed vault")
+		if r.config.Hooks.Rekeyed != nil {
+			r.config.Hooks.Rekeyed(result)
+		}
+	}
+	return nil
+}
+
+// rotateRootToken rotates the root token of Vault if it is older than RootTokenRotationPeriod
+func (r *Runner) rotateRootToken() error {
+	rotator, ok := r.vault.(vault.RootTokenRotator)
+	if !ok {
+		return nil
+	}
+	due := func() (bool, error) {
+		created, err := rotator.RootTokenCreated()
+		if err != nil {
+			return false, err
+		}
+		return time.Since(created) >= r.config.RootTokenRotationPeriod, nil
+	}
+
+	done, err := r.whenDue("rotate the root token of", due, func() error {
+		r.log.Infof("the root token of vault is older than %s, rotating it...", r.config.RootTokenRotationPeriod)
+		return rotator.RotateRootToken()
+	})
+	if err != nil {
+		call(r.config.Hooks.RootTokenRotationFailed, err)
+		return &Error{Phase: PhaseRotateRootToken, Err: err}
+	}
+	if done {
+		r.log.Infof("successfully rotated the root token of vault")
+		if r.config.Hooks.RootTokenRotated != nil {
+			r.config.Hooks.RootTokenRotated()
+		}
+	}
+	return nil
+}
+
+// whenDue calls f while holding the lock if due returns true, it is checked again after the lock
+// has been acquired, so only one of the replicas calls f. It returns true if f has been called.
+func (r *Runner) whenDue(action string, due func() (bool, error), f func() error) (bool, error) {
+	if isDue, err := due(); err != nil || !isDue {
+		return false, err
+	}
+	called := false
+	err := r.withLock(action, func() error {
+		if isDue, err := due(); err != nil || !isDue {
+			return err
+		}
+		called = true
+		return f()
+	})
+	return called && err == nil, err
+}
+
+func (r *Runner) configure() error {
+	config, err := r.config.Configuration()
+	var report *vault.ConfigureReport
+	if err == nil {
+		r.log.Infof("vault is not sealed, configuring...")
+		err = r.withLock("configure", func() (err error) {
+			report, err = r.vault.ConfigureWithReport(config)
+			return err
+		})
+	}
+	if r.config.Status != nil {
+		r.config.Status.ReportConfigured(err)
+	}
+	if err != nil {
+		if r.config.Hooks.ConfigureFailed != nil {
+			r.config.Hooks.ConfigureFailed(report, err)
+		}
+		return &Error{Phase: PhaseConfigure, Err: err}
+	}
+
+	r.mu.Lock()
+	r.configured = true
+	r.mu.Unlock()
+	r.setState(StateConfigured)
+	r.log.Infof("successfully configured vault")
+	if r.config.Hooks.Configured != nil {
+		r.config.Hooks.Configured(report)
+	}
+	return nil
+}
+
+// Run runs the rounds every UnsealPeriod (and right after Reconfigure) until the context is done,
+// the errors of the rounds are logged and retried in the next round
+func (r *Runner) Run(ctx context.Context) error {
+	if r.config.Configuration != nil && r.config.ConfigurePeriod > 0 {
+		go func() {
+			ticker := time.NewTicker(r.config.ConfigurePeriod)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					r.Reconfigure()
+				}
+			}
+		}()
+	}
+
+	for {
+		if err := r.Step(ctx); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			r.log.Errorf("%s", err.Error())
+		}
+
+		timer := time.NewTimer(r.config.UnsealPeriod)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-r.reconfigure:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// withLock calls f while holding the lock of the Config, f is called without locking if there is none
+func (r *Runner) withLock(action string, f func() error) error {
+	if r.config.Lock == nil {
+		return f()
+	}
+
+	r.log.Infof("acquiring the lock to %s vault...", action)
+	if err := lock.Lock(r.config.Lock, r.config.LockRetryInterval, r.config.LockTimeout); err != nil {
+		return fmt.Errorf("error acquiring the lock to %s vault: %s", action, err.Error())
+	}
+	defer func() {
+		if err := r.config.Lock.Unlock(); err != nil {
+			r.log.Warnf("error releasing the lock to %s vault: %s", action, err.Error())
+		}
+	}()
+
+	return f()
+}
+
+// checkKeyStore reports whether the key store can be reached to the Status, if the helper is a
+// vault.KeyStoreChecker, an unreachable key store doesn't fail the round of an unsealed Vault
+func (r *Runner) checkKeyStore(ctx context.Context) {
+	checker, ok := r.vault.(vault.KeyStoreChecker)
+	if !ok || r.config.Status == nil {
+		return
+	}
+	err := checker.CheckKeyStore(ctx)
+	if err != nil {
+		r.log.Warnf("%s", err.Error())
+	}
+	r.config.Status.ReportKeyStore(err)
+}
+
+func (r *Runner) reportSealed(sealed bool, err error) {
+	if r.config.Status != nil {
+		r.config.Status.ReportSealed(sealed, err)
+	}
+}
+
+func (r *Runner) reportUnsealed(err error) {
+	if r.config.Status != nil {
+		r.config.Status.ReportUnsealed(err)
+	}
+}
+
+func call(hook func(err error), err error) {
+	if hook != nil {
+		hook(err)
+	}
+}
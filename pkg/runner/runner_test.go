@@ -0,0 +1,219 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/banzaicloud/bank-vaults/pkg/admin"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/kvtest"
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/banzaicloud/bank-vaults/pkg/vault/vaulttest"
+)
+
+func TestRunner(t *testing.T) {
+	server := vaulttest.NewServer()
+	defer server.Close()
+	client, err := server.Client()
+	if err != nil {
+		t.Fatalf("error creating the client of the fake vault: %s", err.Error())
+	}
+	v, err := vault.New(kvtest.New(), client, vault.Config{SecretShares: 5, SecretThreshold: 3, StoreRootToken: true})
+	if err != nil {
+		t.Fatalf("error creating vault: %s", err.Error())
+	}
+
+	config := &vault.ExternalConfig{Policies: []map[string]string{{"name": "allow_secrets", "rules": `path "secret/*" { capabilities = ["read"] }`}}}
+	events := []string{}
+	r := New(v, Config{
+		Init:          true,
+		Configuration: func() (*vault.ExternalConfig, error) { return config, nil },
+		Hooks: Hooks{
+			Initialized:  func(*vault.InitResult) { events = append(events, "initialized") },
+			Sealed:       func() { events = append(events, "sealed") },
+			Unsealed:     func() { events = append(events, "unsealed") },
+			Configured:   func(*vault.ConfigureReport) { events = append(events, "configured") },
+			UnsealFailed: func(err error) { t.Errorf("error unsealing vault: %s", err.Error()) },
+		},
+	})
+	if r.State() != StatePending {
+		t.Errorf("unexpected state: %s", r.State())
+	}
+
+	if err := r.Step(context.Background()); err != nil {
+		t.Fatalf("error running the first round: %s", err.Error())
+	}
+	if r.State() != StateConfigured || server.Sealed() {
+		t.Errorf("vault hasn't been unsealed and configured, the state is %s", r.State())
+	}
+	if _, ok := server.Policy("allow_secrets"); !ok {
+		t.Errorf("the configuration hasn't been applied")
+	}
+
+	// A configured Vault isn't configured again until it is sealed or Reconfigure is called
+	if err := r.Step(context.Background()); err != nil {
+		t.Fatalf("error running the second round: %s", err.Error())
+	}
+	server.Seal()
+	if err := r.Step(context.Background()); err != nil {
+		t.Fatalf("error running the round after the seal: %s", err.Error())
+	}
+	r.Reconfigure()
+	if err := r.Step(context.Background()); err != nil {
+		t.Fatalf("error running the round after Reconfigure: %s", err.Error())
+	}
+
+	expected := []string{"initialized", "sealed", "unsealed", "configured", "sealed", "unsealed", "configured", "configured"}
+	if len(events) != len(expected) {
+		t.Fatalf("unexpected hooks: %v, expected %v", events, expected)
+	}
+	for i := range expected {
+		if events[i] != expected[i] {
+			t.Fatalf("unexpected hooks: %v, expected %v", events, expected)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := r.Run(ctx); err != context.Canceled {
+		t.Errorf("expected Run to stop with the context, got: %v", err)
+	}
+}
+
+func TestRunnerRekey(t *testing.T) {
+	server := vaulttest.NewServer()
+	defer server.Close()
+	client, err := server.Client()
+	if err != nil {
+		t.Fatalf("error creating the client of the fake vault: %s", err.Error())
+	}
+	store := kvtest.New()
+	v, err := vault.New(store, client, vault.Config{SecretShares: 5, SecretThreshold: 3, StoreRootToken: true})
+	if err != nil {
+		t.Fatalf("error creating vault: %s", err.Error())
+	}
+
+	rekeyed := 0
+	r := New(v, Config{
+		Init:        true,
+		RekeyPeriod: time.Hour,
+		Hooks: Hooks{
+			Rekeyed:     func(*vault.RekeyResult) { rekeyed++ },
+			RekeyFailed: func(err error) { t.Errorf("error rekeying vault: %s", err.Error()) },
+		},
+	})
+
+	if err := r.Step(context.Background()); err != nil {
+		t.Fatalf("error running the first round: %s", err.Error())
+	}
+	if rekeyed != 0 {
+		t.Fatalf("the new keys have been rekeyed")
+	}
+
+	// The keys get older than the period
+	var metadata vault.KeysMetadata
+	json.Unmarshal(store.Value(vault.KeysMetadataKey), &metadata)
+	metadata.Created = metadata.Created.Add(-2 * time.Hour)
+	value, _ := json.Marshal(metadata)
+	store.Put(vault.KeysMetadataKey, value)
+
+	for i := 0; i < 2; i++ {
+		if err := r.Step(context.Background()); err != nil {
+			t.Fatalf("error running round %d after the keys expired: %s", i, err.Error())
+		}
+	}
+	if rekeyed != 1 {
+		t.Errorf("expected a single rekey, got %d", rekeyed)
+	}
+	if store.Value("vault-unseal-0-v1") == nil {
+		t.Errorf("the new keys haven't been stored: %v", store.Keys())
+	}
+}
+
+func TestRunnerRotateRootToken(t *testing.T) {
+	server := vaulttest.NewServer()
+	defer server.Close()
+	client, err := server.Client()
+	if err != nil {
+		t.Fatalf("error creating the client of the fake vault: %s", err.Error())
+	}
+	store := kvtest.New()
+	v, err := vault.New(store, client, vault.Config{SecretShares: 5, SecretThreshold: 3, StoreRootToken: true})
+	if err != nil {
+		t.Fatalf("error creating vault: %s", err.Error())
+	}
+
+	rotated := 0
+	r := New(v, Config{
+		Init:                    true,
+		RootTokenRotationPeriod: time.Hour,
+		Hooks: Hooks{
+			RootTokenRotated:        func() { rotated++ },
+			RootTokenRotationFailed: func(err error) { t.Errorf("error rotating the root token: %s", err.Error()) },
+		},
+	})
+
+	if err := r.Step(context.Background()); err != nil {
+		t.Fatalf("error running the first round: %s", err.Error())
+	}
+	if rotated != 0 {
+		t.Fatalf("the new root token has been rotated")
+	}
+
+	oldRootToken := server.RootToken()
+	server.SetTokenCreated(oldRootToken, time.Now().Add(-2*time.Hour))
+	for i := 0; i < 2; i++ {
+		if err := r.Step(context.Background()); err != nil {
+			t.Fatalf("error running round %d after the root token expired: %s", i, err.Error())
+		}
+	}
+	if rotated != 1 || server.ValidToken(oldRootToken) {
+		t.Errorf("expected a single rotation revoking the old root token, got %d", rotated)
+	}
+}
+
+func TestRunnerReadiness(t *testing.T) {
+	server := vaulttest.NewServer()
+	defer server.Close()
+	client, err := server.Client()
+	if err != nil {
+		t.Fatalf("error creating the client of the fake vault: %s", err.Error())
+	}
+	store := kvtest.New()
+	v, err := vault.New(store, client, vault.Config{SecretShares: 5, SecretThreshold: 3, StoreRootToken: true})
+	if err != nil {
+		t.Fatalf("error creating vault: %s", err.Error())
+	}
+
+	adminServer := admin.NewServer("test")
+	r := New(v, Config{Init: true, Status: adminServer.Target("")})
+	if adminServer.Status().Ready {
+		t.Fatalf("ready before the first round")
+	}
+	if err := r.Step(context.Background()); err != nil {
+		t.Fatalf("error running the first round: %s", err.Error())
+	}
+	if !adminServer.Status().Ready {
+		t.Fatalf("not ready after vault has been unsealed")
+	}
+
+	// The key store becomes unreachable, the unsealed Vault is still running but not ready
+	store.FailOn(kvtest.OperationTest, "", errors.New("connection refused"))
+	if err := r.Step(context.Background()); err != nil {
+		t.Fatalf("error running a round with an unreachable key store: %s", err.Error())
+	}
+	status := adminServer.Status()
+	if status.Ready || status.Targets[0].KeyStoreError == "" {
+		t.Errorf("ready with an unreachable key store: %+v", status.Targets[0])
+	}
+
+	store.FailOn(kvtest.OperationTest, "", nil)
+	if err := r.Step(context.Background()); err != nil {
+		t.Fatalf("error running a round after the key store has recovered: %s", err.Error())
+	}
+	if !adminServer.Status().Ready {
+		t.Errorf("not ready after the key store has recovered")
+	}
+}
@@ -0,0 +1,107 @@
+package securemem
+
+import (
+	"runtime"
+	"sync"
+)
+
+// live are the Buffers which haven't been destroyed yet
+var (
+	liveMu sync.Mutex
+	live   = map[*Buffer]struct{}{}
+)
+
+// Buffer holds a secret (e.g. an unseal key or a root token) in memory which is locked against
+// swapping and excluded from core dumps where the operating system supports it. Destroy wipes the
+// secret, it has to be called as soon as the secret isn't needed anymore.
+//
+// Buffer has no String method on purpose, so the secret can't be formatted into a log entry by
+// accident. The Vault API client takes the keys and tokens as strings: the conversions are immutable
+// copies on the heap which Destroy can't wipe, so they are made right at the calls, and they are
+// left to the garbage collector. Only the Buffers are wiped.
+type Buffer struct {
+	data []byte
+	// mem is the whole locked allocation of data, nil if it has been allocated on the heap
+	mem []byte
+}
+
+// New moves data into a Buffer, data is wiped
+func New(data []byte) *Buffer {
+	b := Copy(data)
+	Wipe(data)
+	return b
+}
+
+// Copy copies data into a Buffer, data is kept
+func Copy(data []byte) *Buffer {
+	b := track(allocate(len(data)))
+	copy(b.data, data)
+	return b
+}
+
+// FromString copies s into a Buffer, s itself can't be wiped
+func FromString(s string) *Buffer {
+	b := track(allocate(len(s)))
+	copy(b.data, s)
+	return b
+}
+
+// Bytes returns the secret, the slice is wiped by Destroy
+func (b *Buffer) Bytes() []byte {
+	return b.data
+}
+
+// Len returns the length of the secret
+func (b *Buffer) Len() int {
+	return len(b.data)
+}
+
+// Destroy wipes the secret and releases the locked memory, the Buffer is empty afterwards
+func (b *Buffer) Destroy() {
+	if b == nil {
+		return
+	}
+	liveMu.Lock()
+	delete(live, b)
+	liveMu.Unlock()
+	Wipe(b.data)
+	if b.mem != nil {
+		release(b.mem)
+	}
+	b.data, b.mem = nil, nil
+}
+
+// Wipe overwrites b with zeroes
+func Wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+	// Keep the writes from being optimized away
+	runtime.KeepAlive(b)
+}
+
+// DestroyAll destroys every Buffer in buffers
+func DestroyAll(buffers []*Buffer) {
+	for _, b := range buffers {
+		b.Destroy()
+	}
+}
+
+// DestroyRemaining destroys every Buffer which hasn't been destroyed yet, e.g. when the process is
+// terminated while the secrets are in use, the Buffers mustn't be used afterwards
+func DestroyRemaining() {
+	liveMu.Lock()
+	remaining := make([]*Buffer, 0, len(live))
+	for b := range live {
+		remaining = append(remaining, b)
+	}
+	liveMu.Unlock()
+	DestroyAll(remaining)
+}
+
+func track(b *Buffer) *Buffer {
+	liveMu.Lock()
+	live[b] = struct{}{}
+	liveMu.Unlock()
+	return b
+}
@@ -0,0 +1,30 @@
+package securemem
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// allocate maps anonymous memory for a secret of the given size, locks it and excludes it from core
+// dumps. Locking is best-effort, it fails beyond RLIMIT_MEMLOCK, the secret is still wiped in this case.
+func allocate(size int) *Buffer {
+	if size == 0 {
+		return &Buffer{data: []byte{}}
+	}
+
+	pageSize := os.Getpagesize()
+	mem, err := unix.Mmap(-1, 0, (size+pageSize-1)/pageSize*pageSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS)
+	if err != nil {
+		return &Buffer{data: make([]byte, size)}
+	}
+	unix.Mlock(mem)
+	unix.Madvise(mem, unix.MADV_DONTDUMP)
+
+	return &Buffer{data: mem[:size], mem: mem}
+}
+
+func release(mem []byte) {
+	unix.Munlock(mem)
+	unix.Munmap(mem)
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package securemem
+
+// allocate allocates a secret of the given size on the heap, it can't be locked on this platform
+func allocate(size int) *Buffer {
+	return &Buffer{data: make([]byte, size)}
+}
+
+func release(mem []byte) {}
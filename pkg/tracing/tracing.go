@@ -0,0 +1,346 @@
+package tracing
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/banzaicloud/bank-vaults/pkg/logging"
+)
+
+// Kinds of a Span in OTLP
+const (
+	spanKindInternal = 1
+	spanKindClient   = 3
+)
+
+// Status codes of a Span in OTLP
+const (
+	statusCodeOK    = 1
+	statusCodeError = 2
+)
+
+const (
+	exportBatchSize = 100
+	exportInterval  = 5 * time.Second
+	exportTimeout   = 10 * time.Second
+)
+
+// Attribute describes a Span, e.g. the path of the configured auth method
+type Attribute struct {
+	Key   string
+	Value string
+}
+
+// Span is a timed operation, e.g. a phase of the configuration or a request to Vault
+type Span struct {
+	tracer       *Tracer
+	traceID      string
+	spanID       string
+	parentSpanID string
+	name         string
+	kind         int
+	start        time.Time
+	attributes   []Attribute
+}
+
+// Tracer keeps track of the running spans of the operations on a Vault cluster (e.g. its unsealing
+// and configuration, which may run concurrently). The parents of the spans of the operations are
+// passed explicitly. The client spans of the requests to the backends, whose callers can't pass
+// them, are the children of the innermost span all the running spans are nested in.
+type Tracer struct {
+	mu sync.Mutex
+	// active are the running spans (not the client spans) in the order they have been started
+	active []*Span
+}
+
+// DefaultTracer is the Tracer of the operations on a single Vault cluster
+var DefaultTracer = NewTracer()
+
+// NewTracer returns a Tracer for an independent sequence of operations, e.g. on another Vault cluster
+func NewTracer() *Tracer {
+	return &Tracer{}
+}
+
+// StartSpan starts a span of an operation as the child of parent, or of a new trace if parent is nil
+func (t *Tracer) StartSpan(parent *Span, name string, attributes ...Attribute) *Span {
+	span := newSpan(t, name, spanKindInternal, attributes)
+	if parent != nil {
+		span.traceID = parent.traceID
+		span.parentSpanID = parent.spanID
+	} else {
+		span.traceID = randomID(16)
+	}
+
+	t.mu.Lock()
+	t.active = append(t.active, span)
+	t.mu.Unlock()
+	return span
+}
+
+// StartClientSpan starts a span of a request to a backend, e.g. Vault or the key store. It is the
+// child of the innermost span all the running spans are nested in, or of a new trace if the running
+// spans belong to different operations.
+func (t *Tracer) StartClientSpan(name string, attributes ...Attribute) *Span {
+	span := newSpan(t, name, spanKindClient, attributes)
+
+	t.mu.Lock()
+	parent := t.commonAncestor()
+	t.mu.Unlock()
+
+	if parent != nil {
+		span.traceID = parent.traceID
+		span.parentSpanID = parent.spanID
+	} else {
+		span.traceID = randomID(16)
+	}
+	return span
+}
+
+func newSpan(t *Tracer, name string, kind int, attributes []Attribute) *Span {
+	return &Span{
+		tracer:     t,
+		spanID:     randomID(8),
+		name:       name,
+		kind:       kind,
+		start:      time.Now(),
+		attributes: attributes,
+	}
+}
+
+// commonAncestor returns the innermost of the running spans every running span is nested in (or is),
+// nil if there is none. It has to be called with the lock held.
+func (t *Tracer) commonAncestor() *Span {
+	byID := make(map[string]*Span, len(t.active))
+	parents := make(map[string]bool, len(t.active))
+	for _, span := range t.active {
+		byID[span.spanID] = span
+		parents[span.parentSpanID] = true
+	}
+
+	// The common ancestor is the longest common prefix of the paths from the root to the leaves
+	var common []*Span
+	first := true
+	for _, span := range t.active {
+		if parents[span.spanID] {
+			continue
+		}
+		var path []*Span
+		for s := span; s != nil; s = byID[s.parentSpanID] {
+			path = append([]*Span{s}, path...)
+		}
+		if first {
+			common, first = path, false
+			continue
+		}
+		i := 0
+		for i < len(common) && i < len(path) && common[i] == path[i] {
+			i++
+		}
+		common = common[:i]
+	}
+	if len(common) == 0 {
+		return nil
+	}
+	return common[len(common)-1]
+}
+
+// SetAttribute adds an attribute to the span
+func (s *Span) SetAttribute(key, value string) {
+	s.attributes = append(s.attributes, Attribute{Key: key, Value: value})
+}
+
+// End ends the span with the result of the operation and exports it
+func (s *Span) End(err error) {
+	end := time.Now()
+
+	s.tracer.mu.Lock()
+	for i := len(s.tracer.active) - 1; i >= 0; i-- {
+		if s.tracer.active[i] == s {
+			s.tracer.active = append(s.tracer.active[:i], s.tracer.active[i+1:]...)
+			break
+		}
+	}
+	s.tracer.mu.Unlock()
+
+	exporterMu.Lock()
+	e := exporter
+	exporterMu.Unlock()
+	if e != nil {
+		e.add(s.otlp(end, err))
+	}
+}
+
+func randomID(size int) string {
+	id := make([]byte, size)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// otlpSpan is a span in the JSON encoding of OTLP
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpAttribute struct {
+	Key   string            `json:"key"`
+	Value map[string]string `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+func otlpAttributes(attributes []Attribute) []otlpAttribute {
+	result := []otlpAttribute{}
+	for _, attribute := range attributes {
+		// The attributes may hold secrets, e.g. a token in the URL of a request
+		result = append(result, otlpAttribute{Key: attribute.Key, Value: map[string]string{"stringValue": logging.Redact(attribute.Value)}})
+	}
+	return result
+}
+
+func (s *Span) otlp(end time.Time, err error) otlpSpan {
+	span := otlpSpan{
+		TraceID:           s.traceID,
+		SpanID:            s.spanID,
+		ParentSpanID:      s.parentSpanID,
+		Name:              s.name,
+		Kind:              s.kind,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(end.UnixNano(), 10),
+		Attributes:        otlpAttributes(s.attributes),
+		Status:            otlpStatus{Code: statusCodeOK},
+	}
+	if err != nil {
+		// The errors of Vault may hold secrets, which mustn't leave the process
+		span.Status = otlpStatus{Code: statusCodeError, Message: logging.Redact(err.Error())}
+	}
+	return span
+}
+
+// otlpExporter sends the ended spans in batches to an OTLP/HTTP collector with the JSON encoding
+type otlpExporter struct {
+	url         string
+	serviceName string
+	client      *http.Client
+
+	mu    sync.Mutex
+	spans []otlpSpan
+	// sending serializes the exports, so Flush returns after the running export
+	sending sync.Mutex
+}
+
+var (
+	exporterMu sync.Mutex
+	exporter   *otlpExporter
+)
+
+// Init starts exporting the spans to the OTLP/HTTP collector at endpoint (e.g. http://otel-collector:4318),
+// the spans are dropped until it is called
+func Init(endpoint, serviceName string) {
+	e := &otlpExporter{
+		url:         strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		serviceName: serviceName,
+		client:      &http.Client{Timeout: exportTimeout},
+	}
+
+	exporterMu.Lock()
+	exporter = e
+	exporterMu.Unlock()
+
+	go func() {
+		for range time.Tick(exportInterval) {
+			e.export()
+		}
+	}()
+}
+
+// Flush exports the spans which haven't been exported yet, it has to be called before exiting
+func Flush() {
+	exporterMu.Lock()
+	e := exporter
+	exporterMu.Unlock()
+	if e != nil {
+		e.export()
+	}
+}
+
+func (e *otlpExporter) add(span otlpSpan) {
+	e.mu.Lock()
+	e.spans = append(e.spans, span)
+	full := len(e.spans) >= exportBatchSize
+	e.mu.Unlock()
+
+	if full {
+		go e.export()
+	}
+}
+
+func (e *otlpExporter) export() {
+	e.sending.Lock()
+	defer e.sending.Unlock()
+
+	e.mu.Lock()
+	spans := e.spans
+	e.spans = nil
+	e.mu.Unlock()
+
+	if len(spans) == 0 {
+		return
+	}
+
+	if err := e.send(spans); err != nil {
+		logging.Default().Warnf("error exporting %d spans: %s", len(spans), err.Error())
+	}
+}
+
+func (e *otlpExporter) send(spans []otlpSpan) error {
+	request := map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": otlpAttributes([]Attribute{{Key: "service.name", Value: e.serviceName}}),
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]string{"name": "github.com/banzaicloud/bank-vaults"},
+						"spans": spans,
+					},
+				},
+			},
+		},
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	resp, err := e.client.Post(e.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status from %s: %s", e.url, resp.Status)
+	}
+	return nil
+}
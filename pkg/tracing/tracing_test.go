@@ -0,0 +1,77 @@
+package tracing
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/banzaicloud/bank-vaults/pkg/logging"
+)
+
+func TestSpanParents(t *testing.T) {
+	tracer := NewTracer()
+
+	configure := tracer.StartSpan(nil, "configure")
+	policies := tracer.StartSpan(configure, "policies")
+	if policies.traceID != configure.traceID || policies.parentSpanID != configure.spanID {
+		t.Errorf("the span isn't the child of its parent")
+	}
+
+	// The requests of the concurrent workers of a phase belong to the phase
+	var wg sync.WaitGroup
+	clients := make([]*Span, 10)
+	for i := range clients {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			clients[i] = tracer.StartClientSpan(fmt.Sprintf("request %d", i))
+			clients[i].End(nil)
+		}(i)
+	}
+	wg.Wait()
+	for _, client := range clients {
+		if client.parentSpanID != policies.spanID {
+			t.Errorf("the request isn't the child of the running phase")
+		}
+	}
+
+	// The requests of sibling spans belong to their common parent, as it is ambiguous which one sent them
+	secrets := tracer.StartSpan(configure, "secrets")
+	if client := tracer.StartClientSpan("request"); client.parentSpanID != configure.spanID {
+		t.Errorf("the request isn't the child of the common parent of the running spans")
+	}
+	secrets.End(nil)
+	policies.End(nil)
+
+	// The spans of a concurrent operation are in their own trace, and so are the requests then
+	unseal := tracer.StartSpan(nil, "unseal")
+	if unseal.traceID == configure.traceID || unseal.parentSpanID != "" {
+		t.Errorf("the concurrent operation should have its own trace")
+	}
+	if client := tracer.StartClientSpan("request"); client.parentSpanID != "" || client.traceID == configure.traceID || client.traceID == unseal.traceID {
+		t.Errorf("the request of concurrent operations should have its own trace")
+	}
+	unseal.End(nil)
+	configure.End(nil)
+
+	if client := tracer.StartClientSpan("request"); client.parentSpanID != "" {
+		t.Errorf("the request without running spans should have its own trace")
+	}
+}
+
+func TestSpanRedactsError(t *testing.T) {
+	logging.RegisterSecret("s.topsecrettoken")
+	span := NewTracer().StartClientSpan("request", Attribute{Key: "http.url", Value: "https://vault:8200/v1/auth/token/lookup/s.topsecrettoken"})
+
+	exported := span.otlp(time.Now(), fmt.Errorf("permission denied for s.topsecrettoken"))
+	if strings.Contains(exported.Status.Message, "s.topsecrettoken") {
+		t.Errorf("the error of the span isn't redacted: %s", exported.Status.Message)
+	}
+	for _, attribute := range exported.Attributes {
+		if strings.Contains(attribute.Value["stringValue"], "s.topsecrettoken") {
+			t.Errorf("the attribute %s of the span isn't redacted", attribute.Key)
+		}
+	}
+}
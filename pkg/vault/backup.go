@@ -0,0 +1,179 @@
+package vault
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/banzaicloud/bank-vaults/pkg/logging"
+	"github.com/banzaicloud/bank-vaults/pkg/securemem"
+	"golang.org/x/crypto/scrypt"
+)
+
+// keyBackupVersion is the version of the format of the key backup bundles
+const keyBackupVersion = 1
+
+// scrypt parameters of the key derived from the passphrase of a key backup bundle
+const (
+	keyBackupScryptN = 1 << 15
+	keyBackupScryptR = 8
+	keyBackupScryptP = 1
+)
+
+// keyBackup is a passphrase encrypted bundle of the keys of a key store
+type keyBackup struct {
+	Version int `json:"version"`
+	// KDF is the key derivation function of the passphrase with its parameters
+	KDF   string `json:"kdf"`
+	N     int    `json:"n"`
+	R     int    `json:"r"`
+	P     int    `json:"p"`
+	Salt  []byte `json:"salt"`
+	Nonce []byte `json:"nonce"`
+	// Ciphertext is the AES-256-GCM encrypted JSON object of the keys by their IDs
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// BackupKeys reads the root token, the unseal keys and the recovery keys with the given names from
+// the key store and returns them in a bundle encrypted with a key derived from the passphrase, for offline escrow
+func BackupKeys(store kv.Service, names KeyNames, passphrase []byte) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("the passphrase of the backup can't be empty")
+	}
+
+	keys, err := StoredKeys(store, names)
+	if err != nil {
+		return nil, err
+	}
+	defer WipeKeys(keys)
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no keys found in the key store")
+	}
+
+	values := map[string][]byte{}
+	for _, key := range keys {
+		values[key.ID] = key.Value
+	}
+	plaintext, err := json.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+	defer securemem.Wipe(plaintext)
+
+	backup := keyBackup{
+		Version: keyBackupVersion,
+		KDF:     "scrypt",
+		N:       keyBackupScryptN,
+		R:       keyBackupScryptR,
+		P:       keyBackupScryptP,
+		Salt:    make([]byte, 32),
+	}
+	if _, err := rand.Read(backup.Salt); err != nil {
+		return nil, fmt.Errorf("error generating salt: %s", err.Error())
+	}
+
+	aead, err := backup.cipher(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	backup.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(backup.Nonce); err != nil {
+		return nil, fmt.Errorf("error generating nonce: %s", err.Error())
+	}
+	backup.Ciphertext = aead.Seal(nil, backup.Nonce, plaintext, nil)
+
+	return json.MarshalIndent(backup, "", "  ")
+}
+
+// RestoreKeys decrypts a bundle of BackupKeys with the passphrase and writes its keys to the
+// key store, then verifies them by reading them back. Keys existing in the key store are only
+// overwritten if overwrite is set. The IDs of the restored keys are returned.
+func RestoreKeys(store kv.Service, bundle, passphrase []byte, overwrite bool) ([]string, error) {
+	var backup keyBackup
+	if err := json.Unmarshal(bundle, &backup); err != nil {
+		return nil, fmt.Errorf("error parsing backup: %s", err.Error())
+	}
+	if backup.Version != keyBackupVersion || backup.KDF != "scrypt" {
+		return nil, fmt.Errorf("unsupported backup version %d with %s key derivation", backup.Version, backup.KDF)
+	}
+
+	aead, err := backup.cipher(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if len(backup.Nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("invalid backup nonce")
+	}
+	plaintext, err := aead.Open(nil, backup.Nonce, backup.Ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting backup, wrong passphrase or corrupted backup")
+	}
+	defer securemem.Wipe(plaintext)
+
+	values := map[string][]byte{}
+	if err := json.Unmarshal(plaintext, &values); err != nil {
+		return nil, fmt.Errorf("error parsing decrypted backup: %s", err.Error())
+	}
+	defer func() {
+		for _, value := range values {
+			securemem.Wipe(value)
+		}
+	}()
+
+	ids := []string{}
+	for id := range values {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	if !overwrite {
+		for _, id := range ids {
+			value, err := store.Get(id)
+			securemem.Wipe(value)
+			if err == nil {
+				return nil, fmt.Errorf("key '%s' already exists in the key store", id)
+			} else if _, ok := err.(*kv.NotFoundError); !ok {
+				return nil, fmt.Errorf("unable to get key '%s': %s", id, err.Error())
+			}
+		}
+	}
+
+	for _, id := range ids {
+		if err := store.Set(id, values[id]); err != nil {
+			return nil, fmt.Errorf("error storing key '%s': %s", id, err.Error())
+		}
+		logging.Default().WithField("key", id).Infof("key restored to the key store")
+	}
+
+	for _, id := range ids {
+		value, err := store.Get(id)
+		if err != nil {
+			return nil, fmt.Errorf("error verifying key '%s': %s", id, err.Error())
+		}
+		equal := bytes.Equal(value, values[id])
+		securemem.Wipe(value)
+		if !equal {
+			return nil, fmt.Errorf("error verifying key '%s': the value doesn't match", id)
+		}
+	}
+
+	return ids, nil
+}
+
+// cipher derives the AES-256-GCM cipher of the backup from the passphrase
+func (b *keyBackup) cipher(passphrase []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, b.Salt, b.N, b.R, b.P, 32)
+	if err != nil {
+		return nil, fmt.Errorf("error deriving key from passphrase: %s", err.Error())
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
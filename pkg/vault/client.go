@@ -0,0 +1,154 @@
+package vault
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// EnvVaultAgentAddress is the address of a Vault agent, the requests are sent to it instead of
+// VAULT_ADDR if it is set and no address is given in the ClientOptions
+const EnvVaultAgentAddress = "VAULT_AGENT_ADDR"
+
+// NamespaceHeader selects the namespace of Vault Enterprise the requests are sent to
+const NamespaceHeader = "X-Vault-Namespace"
+
+// ClientOptions are the settings of a Vault client in a single struct. The empty settings are the
+// defaults of the Vault client, read from the environment (VAULT_ADDR, VAULT_CACERT, etc.).
+type ClientOptions struct {
+	// Address is the address of Vault, e.g. https://vault:8200
+	Address string `json:"address,omitempty" mapstructure:"address"`
+	// AgentAddress is the address of a Vault agent the requests are sent to instead of Address,
+	// VAULT_AGENT_ADDR if both are empty
+	AgentAddress string `json:"agentAddress,omitempty" mapstructure:"agentAddress"`
+
+	// CACert and CAPath are the PEM encoded CA certificate file and the directory of them
+	CACert string `json:"caCert,omitempty" mapstructure:"caCert"`
+	CAPath string `json:"caPath,omitempty" mapstructure:"caPath"`
+	// CACertPEM is a PEM encoded CA bundle, e.g. read from a Kubernetes Secret
+	CACertPEM []byte `json:"caCertPEM,omitempty" mapstructure:"caCertPEM"`
+	// ClientCert and ClientKey are the PEM encoded files of the certificate for TLS authentication
+	ClientCert string `json:"clientCert,omitempty" mapstructure:"clientCert"`
+	ClientKey  string `json:"clientKey,omitempty" mapstructure:"clientKey"`
+	// ClientCertPEM and ClientKeyPEM are the PEM encoded certificate and key for TLS authentication
+	// instead of the files
+	ClientCertPEM []byte `json:"clientCertPEM,omitempty" mapstructure:"clientCertPEM"`
+	ClientKeyPEM  []byte `json:"clientKeyPEM,omitempty" mapstructure:"clientKeyPEM"`
+	// TLSServerName is the name the certificate of Vault is verified with, the host of the address if empty
+	TLSServerName string `json:"tlsServerName,omitempty" mapstructure:"tlsServerName"`
+	// TLSSkipVerify disables the verification of the certificate of Vault, insecure
+	TLSSkipVerify bool `json:"tlsSkipVerify,omitempty" mapstructure:"tlsSkipVerify"`
+
+	// Namespace is the Vault Enterprise namespace the requests are sent to
+	Namespace string `json:"namespace,omitempty" mapstructure:"namespace"`
+	// Proxy is the URL of the HTTP proxy, the one of the HTTP_PROXY/HTTPS_PROXY environment variables if empty
+	Proxy string `json:"proxy,omitempty" mapstructure:"proxy"`
+	// Timeout is the timeout of the requests (VAULT_CLIENT_TIMEOUT, 60s by default)
+	Timeout time.Duration `json:"timeout,omitempty" mapstructure:"timeout"`
+	// Token is the token of the client (VAULT_TOKEN by default)
+	Token string `json:"-" mapstructure:"token"`
+}
+
+// NewConfig returns the configuration of a client with the options, based on api.DefaultConfig
+func (o ClientOptions) NewConfig() (*api.Config, error) {
+	config := api.DefaultConfig()
+	if config.Error != nil {
+		return nil, config.Error
+	}
+
+	switch {
+	case o.AgentAddress != "":
+		config.Address = o.AgentAddress
+	case o.Address != "":
+		config.Address = o.Address
+	case os.Getenv(EnvVaultAgentAddress) != "":
+		config.Address = os.Getenv(EnvVaultAgentAddress)
+	}
+
+	if o.Timeout > 0 {
+		config.Timeout = o.Timeout
+		config.HttpClient.Timeout = o.Timeout
+	}
+
+	if o.CACert == "" && o.CAPath == "" && o.CACertPEM == nil && o.ClientCert == "" && o.ClientKey == "" &&
+		o.ClientCertPEM == nil && o.ClientKeyPEM == nil && o.TLSServerName == "" && !o.TLSSkipVerify && o.Proxy == "" {
+		return config, nil
+	}
+
+	transport, ok := config.HttpClient.Transport.(*http.Transport)
+	if !ok {
+		return nil, fmt.Errorf("the tls and proxy settings can't be applied to a custom transport")
+	}
+
+	tlsConfig := &api.TLSConfig{
+		CACert:        o.CACert,
+		CAPath:        o.CAPath,
+		ClientCert:    o.ClientCert,
+		ClientKey:     o.ClientKey,
+		TLSServerName: o.TLSServerName,
+		Insecure:      o.TLSSkipVerify,
+	}
+	if err := config.ConfigureTLS(tlsConfig); err != nil {
+		return nil, fmt.Errorf("error configuring vault tls: %s", err.Error())
+	}
+
+	if o.CACertPEM != nil {
+		certPool := x509.NewCertPool()
+		if !certPool.AppendCertsFromPEM(o.CACertPEM) {
+			return nil, fmt.Errorf("error parsing the vault ca certificate")
+		}
+		transport.TLSClientConfig.RootCAs = certPool
+	}
+
+	if o.ClientCertPEM != nil || o.ClientKeyPEM != nil {
+		if o.ClientCert != "" || o.ClientKey != "" {
+			return nil, fmt.Errorf("the vault client certificate can't be given both in files and in PEM")
+		}
+		certificate, err := tls.X509KeyPair(o.ClientCertPEM, o.ClientKeyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing the vault client certificate: %s", err.Error())
+		}
+		transport.TLSClientConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	if o.Proxy != "" {
+		proxyURL, err := url.Parse(o.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing the vault proxy url: %s", err.Error())
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	return config, nil
+}
+
+// ConfigureClient applies the options which are set on the client instead of its configuration:
+// the namespace and the token
+func (o ClientOptions) ConfigureClient(client *api.Client) {
+	if o.Namespace != "" {
+		client.SetHeaders(http.Header{NamespaceHeader: []string{o.Namespace}})
+	}
+	if o.Token != "" {
+		client.SetToken(o.Token)
+	}
+}
+
+// NewAPIClient returns a Vault client with the options
+func NewAPIClient(options ClientOptions) (*api.Client, error) {
+	config, err := options.NewConfig()
+	if err != nil {
+		return nil, err
+	}
+	client, err := api.NewClient(config)
+	if err != nil {
+		return nil, fmt.Errorf("error creating vault client: %s", err.Error())
+	}
+	options.ConfigureClient(client)
+	return client, nil
+}
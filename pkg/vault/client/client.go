@@ -0,0 +1,302 @@
+// Package client is a small Vault client for the applications using a Vault configured by
+// bank-vaults: it logs in with the role of the Kubernetes auth method, keeps the token renewed (and
+// logs in again when it can't be renewed anymore), and reads the KV version 1 and 2 secrets, so the
+// applications don't have to implement the Vault login themselves.
+package client
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/banzaicloud/bank-vaults/pkg/backoff"
+	"github.com/banzaicloud/bank-vaults/pkg/logging"
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/hashicorp/vault/api"
+)
+
+// DefaultAuthPath is the path of the Kubernetes auth method enabled by the configurer by default
+const DefaultAuthPath = "kubernetes"
+
+// DefaultTokenFile is the token of the Pod's ServiceAccount, which is sent to the Kubernetes auth method
+const DefaultTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// Config is the configuration of a Client
+type Config struct {
+	// Options of the Vault client, VAULT_ADDR, VAULT_CACERT, etc. of the environment are used by default
+	Options vault.ClientOptions
+	// Role of the Kubernetes auth method to log in with, the token of the Options (or VAULT_TOKEN) is
+	// used instead if it is empty
+	Role string
+	// AuthPath is the path of the Kubernetes auth method, DefaultAuthPath if empty
+	AuthPath string
+	// TokenFile is the ServiceAccount token sent to the Kubernetes auth method, DefaultTokenFile if empty
+	TokenFile string
+	// Logger of the token and lease renewals, logging.Default() if nil
+	Logger logging.Logger
+}
+
+// Client is a logged in Vault client, it has to be closed with Close to stop the renewals
+type Client struct {
+	cl     *api.Client
+	config Config
+	logger logging.Logger
+
+	mu       sync.Mutex
+	renewers []*api.Renewer
+	stop     chan struct{}
+	closed   bool
+}
+
+// New returns a Client logged in to Vault, which keeps its token renewed until it is closed
+func New(config Config) (*Client, error) {
+	cl, err := vault.NewAPIClient(config.Options)
+	if err != nil {
+		return nil, err
+	}
+	return NewWithClient(cl, config)
+}
+
+// NewWithClient is New with an existing Vault client, the Options of the config are ignored
+func NewWithClient(cl *api.Client, config Config) (*Client, error) {
+	if config.AuthPath == "" {
+		config.AuthPath = DefaultAuthPath
+	}
+	if config.TokenFile == "" {
+		config.TokenFile = DefaultTokenFile
+	}
+	logger := config.Logger
+	if logger == nil {
+		logger = logging.Default()
+	}
+
+	c := &Client{
+		cl:     cl,
+		config: config,
+		logger: logger.WithField(logging.ComponentField, "vault-client"),
+		stop:   make(chan struct{}),
+	}
+	secret, err := c.login()
+	if err != nil {
+		return nil, err
+	}
+	if secret != nil && secret.Auth != nil && secret.Auth.Renewable {
+		go c.keepLoggedIn(secret)
+	}
+	return c, nil
+}
+
+// RawClient returns the underlying Vault client, e.g. for the requests without a helper
+func (c *Client) RawClient() *api.Client {
+	return c.cl
+}
+
+// Close stops renewing the token and the leases of the secrets read
+func (c *Client) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	close(c.stop)
+	for _, renewer := range c.renewers {
+		renewer.Stop()
+	}
+	c.renewers = nil
+}
+
+// login sets the token of the client, and returns the secret of the token if it is renewable
+func (c *Client) login() (*api.Secret, error) {
+	if c.config.Role == "" {
+		if c.cl.Token() == "" {
+			return nil, fmt.Errorf("either a role or a token has to be set")
+		}
+		secret, err := c.cl.Auth().Token().LookupSelf()
+		if err != nil {
+			return nil, fmt.Errorf("error looking up token: %s", err.Error())
+		}
+		if renewable, _ := secret.TokenIsRenewable(); !renewable {
+			return nil, nil
+		}
+		// The renewer needs the auth of the token, which is returned by the renewal
+		secret, err = c.cl.Auth().Token().RenewSelf(0)
+		if err != nil {
+			return nil, fmt.Errorf("error renewing token: %s", err.Error())
+		}
+		return secret, nil
+	}
+
+	jwt, err := ioutil.ReadFile(c.config.TokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading service account token: %s", err.Error())
+	}
+	// Log in without the previous, possibly expired token
+	c.cl.ClearToken()
+	secret, err := c.cl.Logical().Write(fmt.Sprintf("auth/%s/login", c.config.AuthPath), map[string]interface{}{
+		"role": c.config.Role,
+		"jwt":  string(jwt),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error logging in to vault: %s", err.Error())
+	}
+	if secret == nil || secret.Auth == nil {
+		return nil, fmt.Errorf("error logging in to vault: no token in response")
+	}
+	c.cl.SetToken(secret.Auth.ClientToken)
+	return secret, nil
+}
+
+// keepLoggedIn renews the token until it can't be renewed anymore, then logs in again (with the
+// role) with an exponential backoff, until the client is closed
+func (c *Client) keepLoggedIn(secret *api.Secret) {
+	for {
+		if err := c.renew(secret, "token"); err != nil {
+			c.logger.Warnf("%s", err.Error())
+		}
+		if c.config.Role == "" {
+			c.logger.Errorf("the token can't be renewed anymore, and there is no role to log in with")
+			return
+		}
+
+		interval := backoff.DefaultInitialInterval
+		for {
+			select {
+			case <-c.stop:
+				return
+			default:
+			}
+			var err error
+			if secret, err = c.login(); err == nil {
+				c.logger.Infof("logged in to vault again")
+				break
+			}
+			c.logger.Errorf("%s, trying again in %s", err.Error(), interval)
+			select {
+			case <-c.stop:
+				return
+			case <-time.After(interval):
+			}
+			if interval *= 2; interval > backoff.DefaultMaxInterval {
+				interval = backoff.DefaultMaxInterval
+			}
+		}
+	}
+}
+
+// renew renews the token or the lease of the secret within its TTL, and returns when it can't be
+// renewed anymore or the client is closed
+func (c *Client) renew(secret *api.Secret, name string) error {
+	renewer, err := c.cl.NewRenewer(&api.RenewerInput{Secret: secret})
+	if err != nil {
+		return fmt.Errorf("error creating %s renewer: %s", name, err.Error())
+	}
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.renewers = append(c.renewers, renewer)
+	c.mu.Unlock()
+
+	go renewer.Renew()
+	defer c.removeRenewer(renewer)
+
+	for {
+		select {
+		case <-c.stop:
+			return nil
+		case err := <-renewer.DoneCh():
+			if err != nil {
+				return fmt.Errorf("error renewing %s: %s", name, err.Error())
+			}
+			return fmt.Errorf("the %s can't be renewed anymore", name)
+		case renewal := <-renewer.RenewCh():
+			c.logger.Debugf("%s renewed at %s", name, renewal.RenewedAt)
+		}
+	}
+}
+
+func (c *Client) removeRenewer(renewer *api.Renewer) {
+	renewer.Stop()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, r := range c.renewers {
+		if r == renewer {
+			c.renewers = append(c.renewers[:i], c.renewers[i+1:]...)
+			break
+		}
+	}
+}
+
+// Read reads the secret at the path, nil if there is none. The lease of a renewable secret (e.g.
+// database credentials) is renewed until it can't be renewed anymore or the client is closed.
+func (c *Client) Read(path string) (*api.Secret, error) {
+	secret, err := c.cl.Logical().Read(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %s", path, err.Error())
+	}
+	if secret != nil && secret.Renewable && secret.LeaseID != "" {
+		go func() {
+			if err := c.renew(secret, fmt.Sprintf("lease of %s", path)); err != nil {
+				c.logger.Warnf("%s", err.Error())
+			}
+		}()
+	}
+	return secret, nil
+}
+
+// ReadKV1 returns the data of the secret at the path of a KV version 1 engine, nil if there is none
+func (c *Client) ReadKV1(path string) (map[string]interface{}, error) {
+	secret, err := c.Read(path)
+	if err != nil || secret == nil {
+		return nil, err
+	}
+	return secret.Data, nil
+}
+
+// ReadKV2 returns the data of the latest version of the secret at the path of the KV version 2
+// engine mounted at mount, nil if there is none (or its latest version is deleted)
+func (c *Client) ReadKV2(mount, path string) (map[string]interface{}, error) {
+	return c.ReadKV2Version(mount, path, 0)
+}
+
+// ReadKV2Version is ReadKV2 with a version of the secret, 0 is the latest one
+func (c *Client) ReadKV2Version(mount, path string, version int) (map[string]interface{}, error) {
+	dataPath := fmt.Sprintf("%s/data/%s", strings.Trim(mount, "/"), strings.Trim(path, "/"))
+	secret, err := c.readVersion(dataPath, version)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %s", dataPath, err.Error())
+	}
+	if secret == nil {
+		return nil, nil
+	}
+	data, ok := secret.Data["data"].(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+	return data, nil
+}
+
+// readVersion reads the path with the version parameter, which Logical().Read doesn't send
+func (c *Client) readVersion(path string, version int) (*api.Secret, error) {
+	if version <= 0 {
+		return c.cl.Logical().Read(path)
+	}
+	r := c.cl.NewRequest("GET", "/v1/"+path)
+	r.Params.Set("version", strconv.Itoa(version))
+	resp, err := c.cl.RawRequest(r)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if resp != nil && resp.StatusCode == 404 {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return api.ParseSecret(resp.Body)
+}
@@ -0,0 +1,59 @@
+package client
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/banzaicloud/bank-vaults/pkg/vault/vaulttest"
+	"github.com/hashicorp/vault/api"
+)
+
+func TestReadKV(t *testing.T) {
+	server := vaulttest.NewServer()
+	defer server.Close()
+	cl, err := server.Client()
+	if err != nil {
+		t.Fatalf("error creating the client of the fake vault: %s", err.Error())
+	}
+	init, err := cl.Sys().Init(&api.InitRequest{SecretShares: 1, SecretThreshold: 1})
+	if err != nil {
+		t.Fatalf("error initializing vault: %s", err.Error())
+	}
+	if _, err := cl.Sys().Unseal(init.Keys[0]); err != nil {
+		t.Fatalf("error unsealing vault: %s", err.Error())
+	}
+
+	if _, err := NewWithClient(cl, Config{}); err == nil {
+		t.Fatalf("expected an error without a role and a token")
+	}
+
+	cl.SetToken(init.RootToken)
+	c, err := NewWithClient(cl, Config{})
+	if err != nil {
+		t.Fatalf("error creating client: %s", err.Error())
+	}
+	defer c.Close()
+
+	server.SetData("secret/app", map[string]interface{}{"password": "v1"})
+	server.SetData("kv/data/app", map[string]interface{}{"data": map[string]interface{}{"password": "v2"}})
+
+	data, err := c.ReadKV1("secret/app")
+	if err != nil {
+		t.Fatalf("error reading kv1 secret: %s", err.Error())
+	}
+	if !reflect.DeepEqual(data, map[string]interface{}{"password": "v1"}) {
+		t.Errorf("unexpected kv1 data: %v", data)
+	}
+
+	data, err = c.ReadKV2("kv/", "app")
+	if err != nil {
+		t.Fatalf("error reading kv2 secret: %s", err.Error())
+	}
+	if !reflect.DeepEqual(data, map[string]interface{}{"password": "v2"}) {
+		t.Errorf("unexpected kv2 data: %v", data)
+	}
+
+	if data, err := c.ReadKV2("kv", "missing"); err != nil || data != nil {
+		t.Errorf("expected no data for a missing secret, got %v, %v", data, err)
+	}
+}
@@ -0,0 +1,67 @@
+package vault
+
+import (
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// newLimiter returns the client-side rate limiter of the requests to Vault, nil if RateLimit is 0
+func newLimiter(config *Config) *rate.Limiter {
+	if config.RateLimit <= 0 {
+		return nil
+	}
+	burst := config.RateBurst
+	if burst <= 0 {
+		burst = 1
+	}
+	return rate.NewLimiter(rate.Limit(config.RateLimit), burst)
+}
+
+// waitForLimiter blocks until the next request to Vault is allowed by the rate limiter, or the
+// context of the operations is done
+func (v *vault) waitForLimiter() error {
+	if v.limiter == nil {
+		return nil
+	}
+	return v.limiter.Wait(v.context())
+}
+
+// applyConcurrently calls apply for the items 0..count-1 with at most ConfigureConcurrency of them
+// at a time, one by one if it is 0 or 1. Every item records its outcome in its own report, which are
+// appended to the report in the order of the items, so the report (and the errors of Configure) are
+// the same as if they had been applied one by one.
+func (v *vault) applyConcurrently(count int, report *ConfigureReport, apply func(i int, report *ConfigureReport)) {
+	workers := v.config.ConfigureConcurrency
+	if workers > count {
+		workers = count
+	}
+	if workers <= 1 {
+		for i := 0; i < count; i++ {
+			apply(i, report)
+		}
+		return
+	}
+
+	reports := make([]ConfigureReport, count)
+	items := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range items {
+				apply(i, &reports[i])
+			}
+		}()
+	}
+	for i := 0; i < count; i++ {
+		items <- i
+	}
+	close(items)
+	wg.Wait()
+
+	for _, itemReport := range reports {
+		report.Resources = append(report.Resources, itemReport.Resources...)
+	}
+}
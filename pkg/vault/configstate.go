@@ -0,0 +1,193 @@
+package vault
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/hashicorp/vault/api"
+)
+
+// ConfigStateKey is the key of the state of the generic configuration of the secret engines in the
+// key store (prefixed with the Prefix of the KeyNames), see Config.TrackConfigState
+const ConfigStateKey = "vault-config-state"
+
+// configState is the state of the generic configuration of the secret engines: the hashes of the
+// entries Configure has written, so the next run can tell the changed entries from the unchanged
+// ones, and the removed entries from the ones created by hand
+type configState struct {
+	// Applied are the hashes of the applied entries by their namespaces and paths
+	Applied map[string]map[string]string `json:"applied"`
+	// desired are the entries of the configuration of this run by their namespaces and paths
+	desired map[string]map[string]bool
+}
+
+// configStateKey returns the name of the state in the key store
+func (v *vault) configStateKey() string {
+	if v.config.KeyNamer != nil {
+		return ConfigStateKey
+	}
+	return v.config.KeyNames.Prefix + ConfigStateKey
+}
+
+// applied returns the hash of the entry at path of the namespace applied by a previous run
+func (s *configState) applied(namespace, path string) string {
+	return s.Applied[namespace][path]
+}
+
+// apply records the hash of the entry at path of the namespace written by this run
+func (s *configState) apply(namespace, path, hash string) {
+	if s.Applied[namespace] == nil {
+		s.Applied[namespace] = map[string]string{}
+	}
+	s.Applied[namespace][path] = hash
+}
+
+// desire records that the entry at path of the namespace is in the configuration of this run
+func (s *configState) desire(namespace, path string) {
+	if s.desired[namespace] == nil {
+		s.desired[namespace] = map[string]bool{}
+	}
+	s.desired[namespace][path] = true
+}
+
+// configStateHash returns the hash of the payload of an entry, the values are normalized like the
+// ones Diff compares
+func configStateHash(payload map[string]interface{}) string {
+	hash := sha256.Sum256([]byte(normalizeConfigValue(payload)))
+	return hex.EncodeToString(hash[:])
+}
+
+// loadConfigState reads the state from the key store, nil without Config.TrackConfigState, an empty
+// state if it hasn't been stored yet
+func (v *vault) loadConfigState() (*configState, error) {
+	if !v.config.TrackConfigState {
+		return nil, nil
+	}
+	if v.keyStore == nil {
+		return nil, fmt.Errorf("the state of the configuration can't be tracked without a key store")
+	}
+
+	state := &configState{Applied: map[string]map[string]string{}, desired: map[string]map[string]bool{}}
+	var value []byte
+	err := v.retryKeyStore(v.context(), fmt.Sprintf("getting key '%s'", v.configStateKey()), func() (err error) {
+		value, err = v.keyStore.Get(v.configStateKey())
+		return err
+	})
+	if _, ok := err.(*kv.NotFoundError); ok {
+		return state, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to get key '%s': %s", v.configStateKey(), err.Error())
+	}
+	if err := json.Unmarshal(value, state); err != nil {
+		return nil, fmt.Errorf("error parsing the state of the configuration: %s", err.Error())
+	}
+	if state.Applied == nil {
+		state.Applied = map[string]map[string]string{}
+	}
+	return state, nil
+}
+
+// storeConfigState writes the state to the key store
+func (v *vault) storeConfigState(state *configState) error {
+	value, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("error encoding the state of the configuration: %s", err.Error())
+	}
+	err = v.retryKeyStore(v.context(), fmt.Sprintf("setting key '%s'", v.configStateKey()), func() error {
+		return v.keyStore.Set(v.configStateKey(), value)
+	})
+	if err != nil {
+		return fmt.Errorf("error storing the state of the configuration in key '%s': %s", v.configStateKey(), err.Error())
+	}
+	return nil
+}
+
+// configUnchanged returns true if the entry at path of Vault has the fields of payload, so an entry
+// which hasn't changed since the previous run isn't written again unless it has drifted
+func (v *vault) configUnchanged(path string, payload map[string]interface{}) bool {
+	var secret *api.Secret
+	err := v.retry(fmt.Sprintf("reading %s", path), func() (err error) {
+		secret, err = v.cl.Logical().Read(path)
+		return err
+	})
+	if err != nil || secret == nil {
+		return false
+	}
+	_, equal := compareConfigObjects(secret.Data, payload)
+	return equal
+}
+
+// deleteRemovedConfig deletes the entries of the generic configuration which have been applied by a
+// previous run but aren't in the configuration anymore, the entries created by hand are never in the
+// state, every outcome is recorded in the report
+func (v *vault) deleteRemovedConfig(state *configState, report *ConfigureReport) error {
+	namespaces := []string{}
+	for namespace := range state.Applied {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+
+	for _, namespace := range namespaces {
+		removed := []string{}
+		for path := range state.Applied[namespace] {
+			if !state.desired[namespace][path] {
+				removed = append(removed, path)
+			}
+		}
+		sort.Strings(removed)
+		if len(removed) == 0 {
+			continue
+		}
+
+		nv, err := v.namespaced(namespace)
+		if err != nil {
+			return err
+		}
+		groupReport := &ConfigureReport{}
+		for _, path := range removed {
+			if nv.deleteRemovedConfigEntry(path, groupReport) {
+				delete(state.Applied[namespace], path)
+			}
+		}
+		if len(state.Applied[namespace]) == 0 {
+			delete(state.Applied, namespace)
+		}
+		for _, result := range groupReport.Resources {
+			result.Namespace = namespace
+			report.Resources = append(report.Resources, result)
+		}
+	}
+	return nil
+}
+
+// deleteRemovedConfigEntry deletes a removed entry of the generic configuration if it still exists,
+// it returns true if the entry is gone
+func (v *vault) deleteRemovedConfigEntry(path string, report *ConfigureReport) bool {
+	started := time.Now()
+	var existing *api.Secret
+	err := v.retry(fmt.Sprintf("reading %s", path), func() (err error) {
+		existing, err = v.cl.Logical().Read(path)
+		return err
+	})
+	if err == nil && existing == nil {
+		// e.g. the secret engine has been unmounted with its configuration, or it has been deleted by hand
+		return true
+	}
+	if err == nil {
+		v.logger().Infof("deleting %s, it has been removed from the configuration", path)
+		err = v.retry(fmt.Sprintf("deleting %s", path), func() error {
+			_, err := v.cl.Logical().Delete(path)
+			return err
+		})
+	}
+	if err != nil {
+		err = fmt.Errorf("error deleting %s from vault: %s", path, err.Error())
+	}
+	report.delete(ResourceSecretEngineConfig, path, started, err)
+	return err == nil
+}
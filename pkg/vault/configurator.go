@@ -0,0 +1,153 @@
+package vault
+
+import (
+	"fmt"
+	"plugin"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// Configurator applies a top-level section of the external configuration which isn't known to
+// Configure, e.g. the configuration of a proprietary Vault plugin. The configurators are registered
+// with RegisterConfigurator, compiled in or loaded from a Go plugin with LoadConfiguratorPlugin, and
+// their sections are verified and applied in the same pipeline as the auth, policies and secrets.
+type Configurator interface {
+	// Section is the name of the top-level section of the configuration, e.g. "transform"
+	Section() string
+	// Validate checks the value of the section without contacting Vault, the paths of the problems
+	// are relative to the section, e.g. [0].name
+	Validate(value interface{}) []*ConfigError
+	// Configure applies the value of the section with the client, which has the root token if it
+	// is stored. Every resource should be recorded in the report, the resources failing there
+	// don't abort the configuration, a returned error does.
+	Configure(client *api.Client, value interface{}, report *ConfigureReport) error
+}
+
+// ConfiguratorPluginSymbol is the exported variable of a Go plugin holding its Configurator
+const ConfiguratorPluginSymbol = "Configurator"
+
+var (
+	configuratorsLock sync.RWMutex
+	configurators     = map[string]Configurator{}
+)
+
+// RegisterConfigurator makes the section of the configurator known to the external configuration,
+// usually from the init function of the package of the configurator. It panics if the section is
+// one of ConfigSections or it has been registered already, like database/sql.Register.
+func RegisterConfigurator(configurator Configurator) {
+	configuratorsLock.Lock()
+	defer configuratorsLock.Unlock()
+
+	section := configurator.Section()
+	if ConfigSections[section] || section == configAPIVersionKey {
+		panic(fmt.Sprintf("vault: the %s section of the configuration can't be overridden by a configurator", section))
+	}
+	if _, ok := configurators[section]; ok {
+		panic(fmt.Sprintf("vault: configurator of the %s section is registered twice", section))
+	}
+	configurators[section] = configurator
+}
+
+// Configurators returns the registered configurators, ordered by their sections
+func Configurators() []Configurator {
+	configuratorsLock.RLock()
+	defer configuratorsLock.RUnlock()
+
+	sections := make([]string, 0, len(configurators))
+	for section := range configurators {
+		sections = append(sections, section)
+	}
+	sort.Strings(sections)
+	result := make([]Configurator, len(sections))
+	for i, section := range sections {
+		result[i] = configurators[section]
+	}
+	return result
+}
+
+// configuratorFor returns the configurator of the section, nil if there is none
+func configuratorFor(section string) Configurator {
+	configuratorsLock.RLock()
+	defer configuratorsLock.RUnlock()
+	return configurators[section]
+}
+
+// knownConfigSection returns true if the section is applied by Configure
+func knownConfigSection(section string) bool {
+	return ConfigSections[section] || configuratorFor(section) != nil
+}
+
+// LoadConfiguratorPlugin opens the Go plugin (built with -buildmode=plugin against the same version
+// of this package) and registers the Configurator in its exported Configurator variable. A plugin
+// may register its configurators in its init function instead.
+func LoadConfiguratorPlugin(path string) error {
+	registered := len(Configurators())
+
+	p, err := plugin.Open(path)
+	if err != nil {
+		return fmt.Errorf("error opening configurator plugin %s: %s", path, err.Error())
+	}
+
+	symbol, err := p.Lookup(ConfiguratorPluginSymbol)
+	if err != nil {
+		if len(Configurators()) > registered {
+			return nil
+		}
+		return fmt.Errorf("configurator plugin %s has neither a %s variable nor registers a configurator", path, ConfiguratorPluginSymbol)
+	}
+	configurator, ok := symbol.(*Configurator)
+	if !ok || *configurator == nil {
+		return fmt.Errorf("the %s of configurator plugin %s isn't a vault.Configurator: %T", ConfiguratorPluginSymbol, path, symbol)
+	}
+	RegisterConfigurator(*configurator)
+	return nil
+}
+
+// validateConfigurator checks the value of a section with its configurator
+func validateConfigurator(configurator Configurator, section string, value interface{}) []*ConfigError {
+	errs := configurator.Validate(value)
+	for _, err := range errs {
+		switch {
+		case err.Path == "":
+			err.Path = section
+		case strings.HasPrefix(err.Path, "["):
+			err.Path = section + err.Path
+		default:
+			err.Path = section + "." + err.Path
+		}
+	}
+	return errs
+}
+
+// configureCustomSections applies the sections of the registered configurators in the order of their
+// sections, the sections missing from the configuration are skipped
+func (v *vault) configureCustomSections(config *ExternalConfig, configured *deadline, report *ConfigureReport) error {
+	sections := config.sections()
+	for _, configurator := range Configurators() {
+		section := configurator.Section()
+		value, ok := sections[section]
+		if !ok || value == nil {
+			continue
+		}
+		if err := configured.check(fmt.Sprintf("configuring the %s section", section)); err != nil {
+			return err
+		}
+		if err := configurator.Configure(v.cl, value, report); err != nil {
+			return fmt.Errorf("error configuring the %s section of vault: %s", section, err.Error())
+		}
+	}
+	return nil
+}
+
+// hasCustomSections returns true if the configuration has a section of a registered configurator
+func (c *ExternalConfig) hasCustomSections() bool {
+	for section, value := range c.sections() {
+		if !ConfigSections[section] && value != nil && configuratorFor(section) != nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,384 @@
+package vault
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cast"
+)
+
+// Actions of a ConfigChange
+const (
+	ConfigChangeCreate = "create"
+	ConfigChangeUpdate = "update"
+	ConfigChangeDelete = "delete"
+)
+
+// ConfigChange is a difference between the external configuration and the live state of Vault
+type ConfigChange struct {
+	// Action is create, update or delete
+	Action string `json:"action"`
+	// Path is the Vault API path of the object, e.g. sys/policy/allow_secrets
+	Path string `json:"path"`
+	// Live and Desired are the compared fields of the object in Vault and in the configuration
+	Live    interface{} `json:"live,omitempty"`
+	Desired interface{} `json:"desired,omitempty"`
+}
+
+// Diff compares the external configuration with the live state of Vault and returns what Configure
+// would create or update, and what exists only in Vault (Configure deletes only the auth methods,
+// secret engines and policies among them, with PurgeUnmanaged). Only the
+// fields present in the configuration and returned by Vault are compared, so write-only fields
+// (passwords, secret keys) don't show up as changes.
+func (v *vault) Diff(config *ExternalConfig) ([]ConfigChange, error) {
+	clearToken, err := v.useConfigurerToken()
+	if err != nil {
+		return nil, err
+	}
+	defer clearToken()
+
+	return v.diff(config)
+}
+
+// Plan returns the changes Configure would apply to Vault without applying anything: the objects it
+// would create or update, and the ones it would delete (the absent secret engines, and the unmanaged
+// resources with PurgeUnmanaged). The rest of the objects existing only in Vault are left out, unlike
+// in Diff.
+func (v *vault) Plan(config *ExternalConfig) ([]ConfigChange, error) {
+	changes, err := v.Diff(config)
+	if err != nil {
+		return nil, err
+	}
+
+	absent := map[string]bool{}
+	for _, secret := range config.Secrets {
+		if secretEngineIsAbsent(secret) {
+			absent[purgeMountPrefix+configItemPath(secret)] = true
+		}
+	}
+
+	plan := []ConfigChange{}
+	for _, change := range changes {
+		if change.Action != ConfigChangeDelete || absent[change.Path] || v.purges(change.Path) {
+			plan = append(plan, change)
+		}
+	}
+	return plan, nil
+}
+
+// diff is Diff with the token already set on the client
+func (v *vault) diff(config *ExternalConfig) ([]ConfigChange, error) {
+	// Only the root namespace is exported
+	if namespaces := config.namespaces(); len(namespaces) > 0 {
+		return nil, fmt.Errorf("comparing the configuration with vault isn't supported with namespaces: %s", strings.Join(namespaces, ", "))
+	}
+
+	live, err := v.export()
+	if err != nil {
+		return nil, err
+	}
+
+	desiredObjects, createOnly := flattenConfig(config.desiredSections())
+
+	// The create only objects are never updated by Configure, and neither are the policies whose
+	// rules differ only in their formatting
+	changes := []ConfigChange{}
+	for _, change := range diffConfigObjects(configObjects(live), desiredObjects) {
+		if change.Action == ConfigChangeUpdate && (createOnly[change.Path] || samePolicyRules(change)) {
+			continue
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+// samePolicyRules returns true if the change is the update of a policy with the same rules
+func samePolicyRules(change ConfigChange) bool {
+	if !strings.HasPrefix(change.Path, "sys/policy/") {
+		return false
+	}
+	live, liveOK := change.Live.(string)
+	desired, desiredOK := change.Desired.(string)
+	return liveOK && desiredOK && policyRulesEqual(live, desired)
+}
+
+func diffConfigObjects(liveObjects, desiredObjects map[string]interface{}) []ConfigChange {
+	changes := []ConfigChange{}
+
+	for path, desired := range desiredObjects {
+		live, ok := liveObjects[path]
+		if !ok {
+			changes = append(changes, ConfigChange{Action: ConfigChangeCreate, Path: path, Desired: desired})
+			continue
+		}
+		if compared, equal := compareConfigObjects(live, desired); !equal {
+			changes = append(changes, ConfigChange{Action: ConfigChangeUpdate, Path: path, Live: compared, Desired: desired})
+		}
+	}
+
+	for path, live := range liveObjects {
+		if _, ok := desiredObjects[path]; !ok {
+			changes = append(changes, ConfigChange{Action: ConfigChangeDelete, Path: path, Live: live})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+// compareConfigObjects compares the fields of desired with live, and returns the compared fields of live
+func compareConfigObjects(live, desired interface{}) (interface{}, bool) {
+	desiredMap, err := cast.ToStringMapE(desired)
+	if err != nil {
+		return live, normalizeConfigValue(live) == normalizeConfigValue(desired)
+	}
+	liveMap := cast.ToStringMap(live)
+
+	compared := map[string]interface{}{}
+	equal := true
+	for key, desiredValue := range desiredMap {
+		liveValue, ok := liveMap[key]
+		if !ok {
+			// Not returned by Vault, either empty or write-only
+			continue
+		}
+		compared[key] = liveValue
+		if normalizeConfigValue(liveValue) != normalizeConfigValue(desiredValue) {
+			equal = false
+		}
+	}
+	return compared, equal
+}
+
+// normalizeConfigValue formats a value of the configuration or of Vault in a comparable way:
+// lists and comma separated strings are sorted, durations are converted to seconds
+func normalizeConfigValue(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return ""
+	case string:
+		value = strings.TrimSpace(value)
+		if duration, err := time.ParseDuration(value); err == nil {
+			return strconv.FormatInt(int64(duration.Seconds()), 10)
+		}
+		if strings.Contains(value, ",") && !strings.ContainsAny(value, "{[\n") {
+			items := []interface{}{}
+			for _, item := range strings.Split(value, ",") {
+				items = append(items, item)
+			}
+			return normalizeConfigValue(items)
+		}
+		return value
+	case []interface{}:
+		items := make([]string, len(value))
+		for i, item := range value {
+			items[i] = normalizeConfigValue(item)
+		}
+		sort.Strings(items)
+		return strings.Join(items, ",")
+	case []string:
+		return normalizeConfigValue(cast.ToSlice(value))
+	case map[string]string:
+		// e.g. the options of the mounts
+		m := map[string]interface{}{}
+		for key, item := range value {
+			m[key] = item
+		}
+		return normalizeConfigValue(m)
+	case json.Number:
+		return value.String()
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+
+	if m, err := cast.ToStringMapE(value); err == nil {
+		normalized := map[string]string{}
+		for key, item := range m {
+			normalized[key] = normalizeConfigValue(item)
+		}
+		b, _ := json.Marshal(normalized)
+		return string(b)
+	}
+	return fmt.Sprint(value)
+}
+
+// configObjects flattens the external configuration into the objects written to Vault by their API paths
+func configObjects(config map[string]interface{}) map[string]interface{} {
+	objects, _ := flattenConfig(config)
+	return objects
+}
+
+// flattenConfig returns the objects of configObjects, and the paths of the create only ones
+func flattenConfig(config map[string]interface{}) (map[string]interface{}, map[string]bool) {
+	objects := map[string]interface{}{}
+	createOnly := map[string]bool{}
+
+	list := func(value interface{}) []map[string]interface{} {
+		items := []map[string]interface{}{}
+		for _, item := range cast.ToSlice(value) {
+			items = append(items, cast.ToStringMap(item))
+		}
+		return items
+	}
+
+	// named adds the items of a list by their names
+	named := func(prefix string, value interface{}) {
+		for _, item := range list(value) {
+			path := fmt.Sprintf("%s/%s", prefix, item["name"])
+			fields := map[string]interface{}{}
+			for key, field := range item {
+				if key != "name" && key != createOnlyField && key != rotateRootField {
+					fields[key] = field
+				}
+			}
+			objects[path] = fields
+			// The connections whose root credentials are rotated are created only
+			if cast.ToBool(item[createOnlyField]) || cast.ToBool(item[rotateRootField]) {
+				createOnly[path] = true
+			}
+		}
+	}
+
+	for _, policy := range list(config["policies"]) {
+		path := fmt.Sprintf("sys/policy/%s", policy["name"])
+		objects[path] = policy["rules"]
+		if cast.ToBool(policy[createOnlyField]) {
+			createOnly[path] = true
+		}
+	}
+
+	for _, auth := range list(config["auth"]) {
+		authType := cast.ToString(auth["type"])
+		path := authType
+		if pathOverwrite, ok := auth["path"]; ok {
+			path = cast.ToString(pathOverwrite)
+		}
+		// The built-in token auth method is always enabled
+		if !builtinAuthMethods[authType] {
+			objects["sys/auth/"+path] = map[string]interface{}{"type": authType}
+		}
+
+		switch authType {
+		case "token":
+			// The tuning isn't compared, like the tune section of sys
+			named(fmt.Sprintf("auth/%s/roles", path), auth["roles"])
+		case "kubernetes":
+			named(fmt.Sprintf("auth/%s/role", path), auth["roles"])
+		case "aws":
+			if config, ok := auth["config"]; ok {
+				objects[fmt.Sprintf("auth/%s/config/client", path)] = config
+			}
+			named(fmt.Sprintf("auth/%s/role", path), auth["roles"])
+		case "github":
+			if config, ok := auth["config"]; ok {
+				objects[fmt.Sprintf("auth/%s/config", path)] = config
+			}
+			for mappingType, mapping := range cast.ToStringMap(auth["map"]) {
+				for name, value := range cast.ToStringMap(mapping) {
+					objects[fmt.Sprintf("auth/%s/map/%s/%s", path, mappingType, name)] = map[string]interface{}{"value": value}
+				}
+			}
+		case "approle":
+			// The role_id is read from its own path
+			rolePath := fmt.Sprintf("auth/%s/role", path)
+			named(rolePath, auth["roles"])
+			for _, role := range list(auth["roles"]) {
+				if roleID, ok := role["role_id"]; ok {
+					path := fmt.Sprintf("%s/%s", rolePath, role["name"])
+					delete(objects[path].(map[string]interface{}), "role_id")
+					objects[path+"/role-id"] = map[string]interface{}{"role_id": roleID}
+					if createOnly[path] {
+						createOnly[path+"/role-id"] = true
+					}
+				}
+			}
+		case "jwt", "oidc", "gcp", "azure":
+			if config, ok := auth["config"]; ok {
+				objects[fmt.Sprintf("auth/%s/config", path)] = config
+			}
+			named(fmt.Sprintf("auth/%s/role", path), auth["roles"])
+		case "userpass":
+			// The passwords can't be read back from Vault
+			usersPath := fmt.Sprintf("auth/%s/users", path)
+			named(usersPath, auth["users"])
+			for _, user := range list(auth["users"]) {
+				fields := objects[fmt.Sprintf("%s/%s", usersPath, user["name"])].(map[string]interface{})
+				delete(fields, "password")
+				delete(fields, userPasswordKeyField)
+			}
+		case "cert":
+			if config, ok := auth["config"]; ok {
+				objects[fmt.Sprintf("auth/%s/config", path)] = config
+			}
+			named(fmt.Sprintf("auth/%s/certs", path), auth["certs"])
+		case "ldap", "okta", "radius":
+			if config, ok := auth["config"]; ok {
+				objects[fmt.Sprintf("auth/%s/config", path)] = config
+			}
+			for _, mappingType := range authMappingTypes[authType] {
+				for name, mapping := range cast.ToStringMap(auth[mappingType]) {
+					objects[fmt.Sprintf("auth/%s/%s/%s", path, mappingType, name)] = mapping
+				}
+			}
+		}
+	}
+
+	for _, audit := range list(config["audit"]) {
+		auditType := cast.ToString(audit["type"])
+		path := auditType
+		if pathOverwrite, ok := audit["path"]; ok {
+			path = cast.ToString(pathOverwrite)
+		}
+		device := map[string]interface{}{"type": auditType}
+		for _, key := range []string{"description", "options"} {
+			if value, ok := audit[key]; ok {
+				device[key] = value
+			}
+		}
+		objects["sys/audit/"+path] = device
+		// The enabled audit devices aren't changed by Configure
+		createOnly["sys/audit/"+path] = true
+	}
+
+	for _, secret := range list(config["secrets"]) {
+		// The absent secret engines are left out, so they are deleted
+		if cast.ToString(secret[secretEngineStateField]) == secretEngineAbsent {
+			continue
+		}
+		secretType := cast.ToString(secret["type"])
+		path := secretType
+		if pathOverwrite, ok := secret["path"]; ok {
+			path = cast.ToString(pathOverwrite)
+		}
+		mount := map[string]interface{}{"type": secretType}
+		for _, key := range []string{"description", "options"} {
+			if value, ok := secret[key]; ok {
+				mount[key] = value
+			}
+		}
+		if _, ok := secret[kvVersionField]; ok {
+			mount["options"] = secretEngineOptions(secret)
+		}
+		objects["sys/mounts/"+path] = mount
+
+		for configOption, configData := range cast.ToStringMap(secret["configuration"]) {
+			named(fmt.Sprintf("%s/%s", path, configOption), configData)
+		}
+
+		// The CA of a pki secret engine is generated once, only its URLs and roles are compared
+		pki := cast.ToStringMap(secret[pkiField])
+		if urls, ok := pki["urls"]; ok {
+			objects[path+"/config/urls"] = urls
+		}
+		named(path+"/roles", pki["roles"])
+
+		// The CA signing key of an ssh secret engine is set once as well, only its roles are compared
+		named(path+"/roles", cast.ToStringMap(secret[sshField])["roles"])
+	}
+
+	return objects, createOnly
+}
@@ -0,0 +1,141 @@
+package vault
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ghodss/yaml"
+)
+
+// EnvConfigPrefix is the prefix of the environment variables overriding the fields of the external
+// configuration, e.g. VAULT_CONFIG_SECRETS__SECRET__OPTIONS__VERSION=2
+const EnvConfigPrefix = "VAULT_CONFIG_"
+
+// envConfigSeparator separates the keys of the path in the names of the variables, because the
+// fields of the configuration contain single underscores
+const envConfigSeparator = "__"
+
+var envConfigInvalidChars = regexp.MustCompile("[^a-z0-9_]")
+
+// OverrideConfigFromEnv returns a copy of the settings of an external configuration with the fields
+// set by the VAULT_CONFIG_* variables of environ (in the format of os.Environ), so the configuration
+// of a container can be changed without templating the file. The keys of the path are separated by
+// double underscores and matched case-insensitively, the items of the lists are selected by their
+// index or by their name, path or type (with the characters not allowed in variable names replaced
+// by underscores). The index after the last item appends a new one. The values starting with { or [
+// are parsed as YAML/JSON objects and lists, the others are strings.
+func OverrideConfigFromEnv(settings map[string]interface{}, environ []string) (map[string]interface{}, error) {
+	overridden := make(map[string]interface{}, len(settings))
+	for key, value := range settings {
+		overridden[key] = copyConfigSection(value)
+	}
+
+	variables := append([]string{}, environ...)
+	sort.Strings(variables)
+	for _, variable := range variables {
+		if !strings.HasPrefix(variable, EnvConfigPrefix) {
+			continue
+		}
+		parts := strings.SplitN(strings.TrimPrefix(variable, EnvConfigPrefix), "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			continue
+		}
+		name, raw := parts[0], parts[1]
+
+		var value interface{} = raw
+		if strings.HasPrefix(raw, "{") || strings.HasPrefix(raw, "[") {
+			if err := yaml.Unmarshal([]byte(raw), &value); err != nil {
+				return nil, fmt.Errorf("error parsing %s%s: %s", EnvConfigPrefix, name, err.Error())
+			}
+		}
+
+		path := strings.Split(strings.ToLower(name), envConfigSeparator)
+		result, err := setConfigPath(overridden, path, value)
+		if err != nil {
+			return nil, fmt.Errorf("error overriding vault config with %s%s: %s", EnvConfigPrefix, name, err.Error())
+		}
+		overridden = result.(map[string]interface{})
+	}
+
+	return overridden, nil
+}
+
+// setConfigPath sets the field at the path within the value to override, the maps and lists on the
+// path are created if they are missing
+func setConfigPath(value interface{}, path []string, override interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return override, nil
+	}
+	key := path[0]
+	if key == "" {
+		return nil, fmt.Errorf("empty key")
+	}
+
+	switch value := value.(type) {
+	case nil:
+		if _, err := strconv.Atoi(key); err == nil {
+			return setConfigPath([]interface{}{}, path, override)
+		}
+		return setConfigPath(map[string]interface{}{}, path, override)
+	case map[string]interface{}:
+		field := key
+		for existing := range value {
+			if strings.EqualFold(existing, key) {
+				field = existing
+				break
+			}
+		}
+		item, err := setConfigPath(value[field], path[1:], override)
+		if err != nil {
+			return nil, err
+		}
+		value[field] = item
+		return value, nil
+	case []interface{}:
+		index := configItemIndex(value, key)
+		if index < 0 {
+			return nil, fmt.Errorf("no item %s in the list", key)
+		}
+		if index == len(value) {
+			value = append(value, nil)
+		}
+		item, err := setConfigPath(value[index], path[1:], override)
+		if err != nil {
+			return nil, err
+		}
+		value[index] = item
+		return value, nil
+	default:
+		return nil, fmt.Errorf("%s can't be set in a %T", key, value)
+	}
+}
+
+// configItemIndex returns the index of the item of the list selected by the key, -1 if there is none
+func configItemIndex(items []interface{}, key string) int {
+	if index, err := strconv.Atoi(key); err == nil {
+		if index < 0 || index > len(items) {
+			return -1
+		}
+		return index
+	}
+	for _, field := range []string{"name", "path", "type"} {
+		for i, item := range items {
+			fields, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if value, ok := fields[field].(string); ok && envConfigName(value) == key {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// envConfigName returns the name of a value in the variables
+func envConfigName(value string) string {
+	return envConfigInvalidChars.ReplaceAllString(strings.ToLower(strings.Trim(value, "/")), "_")
+}
@@ -0,0 +1,164 @@
+package vault
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/banzaicloud/bank-vaults/pkg/circuit"
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+)
+
+// The kinds of the errors of the package, the errors of these kinds can be told apart with errors.Is,
+// e.g. errors.Is(err, vault.ErrKeyStoreUnavailable), even if they are wrapped by a *RetryableError or
+// a *FatalError
+var (
+	// ErrAlreadyInitialized is returned if Vault has been initialized by someone else during Init
+	ErrAlreadyInitialized = errors.New("vault is already initialized")
+	// ErrKeyStoreExists is returned by Init if the key store holds keys already, e.g. of another cluster
+	ErrKeyStoreExists = errors.New("the key store holds keys already")
+	// ErrKeyStoreUnavailable is returned if the key store can't be reached or read
+	ErrKeyStoreUnavailable = errors.New("the key store is unavailable")
+	// ErrUnsealFailed is returned if the keys couldn't be sent to Vault, or they didn't unseal it
+	ErrUnsealFailed = errors.New("vault couldn't be unsealed")
+	// ErrPermissionDenied is returned if Vault or the key store has denied a request (403)
+	ErrPermissionDenied = errors.New("permission denied")
+)
+
+// Error is an error of one of the kinds of the package, wrapping the underlying error of Vault or the
+// key store if there is one
+type Error struct {
+	// Kind is one of the Err* errors of the package
+	Kind    error
+	Message string
+	Err     error
+}
+
+func (e *Error) Error() string { return e.Message }
+
+// Unwrap returns the underlying error
+func (e *Error) Unwrap() error { return e.Err }
+
+// Is returns true if the target is the kind of the error
+func (e *Error) Is(target error) bool { return target == e.Kind }
+
+// newError returns an *Error of the kind, its message is the formatted one followed by the message of
+// the underlying error
+func newError(kind, err error, format string, args ...interface{}) error {
+	message := fmt.Sprintf(format, args...)
+	if err != nil {
+		message = fmt.Sprintf("%s: %s", message, err.Error())
+	}
+	return &Error{Kind: kind, Message: message, Err: err}
+}
+
+// RetryableError is an error which may go away when the request is retried: Vault or the key store
+// is unreachable or overloaded (e.g. connection refused, timeouts, 429 or 5xx responses)
+type RetryableError struct {
+	Err error
+}
+
+func (e *RetryableError) Error() string { return e.Err.Error() }
+
+// Unwrap returns the classified error
+func (e *RetryableError) Unwrap() error { return e.Err }
+
+// FatalError is an error which doesn't go away by retrying the request, e.g. an invalid request
+// or permission denied (4xx responses)
+type FatalError struct {
+	Err error
+}
+
+func (e *FatalError) Error() string { return e.Err.Error() }
+
+// Unwrap returns the classified error
+func (e *FatalError) Unwrap() error { return e.Err }
+
+// The error messages of the Vault API client, the cloud SDKs and the network, which carry the
+// status code or the cause of a failed request, as they are wrapped as text by the callers
+var (
+	statusCodePattern = regexp.MustCompile(`(?:Code: |[Ss]tatus [Cc]ode: |googleapi: Error |StatusCode=)(\d{3})`)
+
+	retryableMessages = []string{
+		"connection refused",
+		"connection reset",
+		"broken pipe",
+		"no such host",
+		"i/o timeout",
+		"TLS handshake timeout",
+		"timeout awaiting response headers",
+		"server misbehaving",
+		"unexpected EOF",
+		"Vault is sealed",
+		"RequestTimeout",
+		"SlowDown",
+		"Throttling",
+		"TooManyRequests",
+		"ServiceUnavailable",
+	}
+)
+
+// ClassifyError wraps the error in a *RetryableError or a *FatalError by its type or message, the
+// typed errors of the package (e.g. *TimeoutError, *KeyValidationError) are returned as they are,
+// IsRetryable classifies them. The unknown errors are fatal.
+func ClassifyError(err error) error {
+	switch err.(type) {
+	case nil, *RetryableError, *FatalError, *TimeoutError, *KeyValidationError, *ConfigureError, ConfigErrors, *ConfigHistoryError, *HookError:
+		return err
+	}
+	if isPermissionDenied(err) {
+		err = &Error{Kind: ErrPermissionDenied, Message: err.Error(), Err: err}
+	}
+	if isRetryableError(err) {
+		return &RetryableError{Err: err}
+	}
+	return &FatalError{Err: err}
+}
+
+// isPermissionDenied returns true if Vault or the key store has denied the request
+func isPermissionDenied(err error) bool {
+	message := err.Error()
+	if match := statusCodePattern.FindStringSubmatch(message); match != nil {
+		return match[1] == "403"
+	}
+	return strings.Contains(strings.ToLower(message), "permission denied") || strings.Contains(message, "AccessDenied")
+}
+
+// IsRetryable returns true if the error may go away when the request is retried
+func IsRetryable(err error) bool {
+	switch err.(type) {
+	case nil:
+		return false
+	case *RetryableError, *TimeoutError:
+		return true
+	case *FatalError, *KeyValidationError, *ConfigureError, ConfigErrors, *ConfigHistoryError, *HookError:
+		return false
+	}
+	return isRetryableError(err)
+}
+
+func isRetryableError(err error) bool {
+	switch err.(type) {
+	case *kv.NotFoundError, *kv.AlreadyExistsError:
+		return false
+	case *circuit.OpenError:
+		return true
+	case net.Error:
+		return true
+	}
+
+	message := err.Error()
+	if match := statusCodePattern.FindStringSubmatch(message); match != nil {
+		code, _ := strconv.Atoi(match[1])
+		return code == 429 || code >= 500
+	}
+	for _, retryable := range retryableMessages {
+		if strings.Contains(message, retryable) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,544 @@
+package vault
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cast"
+)
+
+// systemMounts are the secret engines mounted by Vault itself, they are not part of the configuration
+var systemMounts = map[string]bool{
+	"sys":       true,
+	"cubbyhole": true,
+	"identity":  true,
+}
+
+// Export reads the policies, auth methods with their roles, the secret engines, the audit devices and
+// the tunings of the auth methods and secret engines from Vault, and returns them in the format of
+// the external configuration. Secret values (passwords,
+// secret keys) are not returned by Vault, they have to be added to the result by hand.
+func (v *vault) Export() (map[string]interface{}, error) {
+	clearToken, err := v.useConfigurerToken()
+	if err != nil {
+		return nil, err
+	}
+	defer clearToken()
+
+	config, err := v.export()
+	if err != nil {
+		return nil, err
+	}
+	config[configAPIVersionKey] = ConfigAPIVersion
+	return config, nil
+}
+
+// export is Export with the token already set on the client
+func (v *vault) export() (map[string]interface{}, error) {
+	policies, err := v.exportPolicies()
+	if err != nil {
+		return nil, fmt.Errorf("error exporting policies: %s", err.Error())
+	}
+
+	auths, err := v.exportAuthMethods()
+	if err != nil {
+		return nil, fmt.Errorf("error exporting auth methods: %s", err.Error())
+	}
+
+	secrets, err := v.exportSecretEngines()
+	if err != nil {
+		return nil, fmt.Errorf("error exporting secret engines: %s", err.Error())
+	}
+
+	audit, err := v.exportAuditDevices()
+	if err != nil {
+		return nil, fmt.Errorf("error exporting audit devices: %s", err.Error())
+	}
+
+	tune, err := v.exportTune()
+	if err != nil {
+		return nil, fmt.Errorf("error exporting the tuning of the mounts: %s", err.Error())
+	}
+
+	config := map[string]interface{}{
+		"policies": policies,
+		"auth":     auths,
+		"secrets":  secrets,
+		"audit":    audit,
+	}
+	if len(tune) > 0 {
+		config["sys"] = map[string]interface{}{sysTuneField: tune}
+	}
+	return config, nil
+}
+
+// exportTune reads the tuning parameters of the auth methods (auth/<path>) and the secret engines
+// set to other than the defaults of Vault by their paths, in the format of the tune section of sys
+func (v *vault) exportTune() (map[string]interface{}, error) {
+	tune := map[string]interface{}{}
+
+	auths, err := v.cl.Sys().ListAuth()
+	if err != nil {
+		return nil, err
+	}
+	for mountPath, mount := range auths {
+		config := mount.Config
+		mountTune := exportMountTune(config.DefaultLeaseTTL, config.MaxLeaseTTL, config.ListingVisibility,
+			config.AuditNonHMACRequestKeys, config.AuditNonHMACResponseKeys, config.PassthroughRequestHeaders)
+		if len(mountTune) > 0 {
+			tune["auth/"+strings.TrimSuffix(mountPath, "/")] = mountTune
+		}
+	}
+
+	mounts, err := v.cl.Sys().ListMounts()
+	if err != nil {
+		return nil, err
+	}
+	for mountPath, mount := range mounts {
+		path := strings.TrimSuffix(mountPath, "/")
+		if systemMounts[path] {
+			continue
+		}
+		config := mount.Config
+		mountTune := exportMountTune(config.DefaultLeaseTTL, config.MaxLeaseTTL, config.ListingVisibility,
+			config.AuditNonHMACRequestKeys, config.AuditNonHMACResponseKeys, config.PassthroughRequestHeaders)
+		if config.ForceNoCache {
+			mountTune["force_no_cache"] = true
+		}
+		if len(mountTune) > 0 {
+			tune[path] = mountTune
+		}
+	}
+	return tune, nil
+}
+
+// exportMountTune returns the tuning parameters of a mount which aren't the defaults, the TTLs are
+// formatted as durations
+func exportMountTune(defaultLeaseTTL, maxLeaseTTL int, listingVisibility string, auditNonHMACRequestKeys, auditNonHMACResponseKeys, passthroughRequestHeaders []string) map[string]interface{} {
+	tune := map[string]interface{}{}
+	if defaultLeaseTTL > 0 {
+		tune["default_lease_ttl"] = formatTTL(defaultLeaseTTL)
+	}
+	if maxLeaseTTL > 0 {
+		tune["max_lease_ttl"] = formatTTL(maxLeaseTTL)
+	}
+	if listingVisibility != "" {
+		tune["listing_visibility"] = listingVisibility
+	}
+	for field, values := range map[string][]string{
+		"audit_non_hmac_request_keys":  auditNonHMACRequestKeys,
+		"audit_non_hmac_response_keys": auditNonHMACResponseKeys,
+		"passthrough_request_headers":  passthroughRequestHeaders,
+	} {
+		if len(values) > 0 {
+			tune[field] = values
+		}
+	}
+	return tune
+}
+
+// formatTTL formats a TTL in seconds as a duration in its largest whole unit, e.g. 24h or 90m
+func formatTTL(seconds int) string {
+	switch {
+	case seconds%3600 == 0:
+		return fmt.Sprintf("%dh", seconds/3600)
+	case seconds%60 == 0:
+		return fmt.Sprintf("%dm", seconds/60)
+	default:
+		return fmt.Sprintf("%ds", seconds)
+	}
+}
+
+func (v *vault) exportPolicies() ([]interface{}, error) {
+	names, err := v.cl.Sys().ListPolicies()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	policies := []interface{}{}
+	for _, name := range names {
+		// Built-in policies
+		if name == "root" || name == "default" {
+			continue
+		}
+		rules, err := v.cl.Sys().GetPolicy(name)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s policy: %s", name, err.Error())
+		}
+		policies = append(policies, map[string]interface{}{
+			"name":  name,
+			"rules": rules,
+		})
+	}
+	return policies, nil
+}
+
+func (v *vault) exportAuthMethods() ([]interface{}, error) {
+	mounts, err := v.cl.Sys().ListAuth()
+	if err != nil {
+		return nil, err
+	}
+
+	paths := []string{}
+	for path := range mounts {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	auths := []interface{}{}
+	for _, mountPath := range paths {
+		mount := mounts[mountPath]
+		path := strings.TrimSuffix(mountPath, "/")
+		// The built-in token auth method is exported only with its roles
+		if mount.Type == "token" {
+			roles, err := v.exportItems(fmt.Sprintf("auth/%s/roles", path))
+			if err != nil {
+				return nil, err
+			}
+			if len(roles) > 0 {
+				auths = append(auths, map[string]interface{}{"type": mount.Type, "roles": roles})
+			}
+			continue
+		}
+
+		auth := map[string]interface{}{"type": mount.Type}
+		if path != mount.Type {
+			auth["path"] = path
+		}
+
+		switch mount.Type {
+		case "kubernetes":
+			// The config is generated by the configure command from the environment
+			roles, err := v.exportItems(fmt.Sprintf("auth/%s/role", path))
+			if err != nil {
+				return nil, err
+			}
+			auth["roles"] = roles
+		case "aws":
+			config, err := v.exportItem(fmt.Sprintf("auth/%s/config/client", path))
+			if err != nil {
+				return nil, err
+			}
+			if config != nil {
+				auth["config"] = config
+			}
+			roles, err := v.exportItems(fmt.Sprintf("auth/%s/role", path))
+			if err != nil {
+				return nil, err
+			}
+			auth["roles"] = roles
+		case "approle":
+			roles, err := v.exportItems(fmt.Sprintf("auth/%s/role", path))
+			if err != nil {
+				return nil, err
+			}
+			for _, role := range roles {
+				role := role.(map[string]interface{})
+				roleID, err := v.exportItem(fmt.Sprintf("auth/%s/role/%s/role-id", path, role["name"]))
+				if err != nil {
+					return nil, err
+				}
+				if roleID != nil {
+					role["role_id"] = roleID["role_id"]
+				}
+			}
+			auth["roles"] = roles
+		case "jwt", "oidc", "gcp", "azure":
+			config, err := v.exportItem(fmt.Sprintf("auth/%s/config", path))
+			if err != nil {
+				return nil, err
+			}
+			if config != nil {
+				auth["config"] = config
+			}
+			roles, err := v.exportItems(fmt.Sprintf("auth/%s/role", path))
+			if err != nil {
+				return nil, err
+			}
+			auth["roles"] = roles
+		case "userpass":
+			// The passwords can't be exported, they have to be added to the users
+			users, err := v.exportItems(fmt.Sprintf("auth/%s/users", path))
+			if err != nil {
+				return nil, err
+			}
+			auth["users"] = users
+		case "github":
+			config, err := v.exportItem(fmt.Sprintf("auth/%s/config", path))
+			if err != nil {
+				return nil, err
+			}
+			if config != nil {
+				auth["config"] = config
+			}
+			mappings := map[string]interface{}{}
+			for _, mappingType := range []string{"teams", "users"} {
+				items, err := v.exportMappings(fmt.Sprintf("auth/%s/map/%s", path, mappingType))
+				if err != nil {
+					return nil, err
+				}
+				mapping := map[string]interface{}{}
+				for name, item := range items {
+					mapping[name] = item["value"]
+				}
+				if len(mapping) > 0 {
+					mappings[mappingType] = mapping
+				}
+			}
+			if len(mappings) > 0 {
+				auth["map"] = mappings
+			}
+		case "cert":
+			config, err := v.exportItem(fmt.Sprintf("auth/%s/config", path))
+			if err != nil {
+				return nil, err
+			}
+			if config != nil {
+				auth["config"] = config
+			}
+			certs, err := v.exportItems(fmt.Sprintf("auth/%s/certs", path))
+			if err != nil {
+				return nil, err
+			}
+			auth["certs"] = certs
+		case "ldap", "okta", "radius":
+			config, err := v.exportItem(fmt.Sprintf("auth/%s/config", path))
+			if err != nil {
+				return nil, err
+			}
+			if config != nil {
+				auth["config"] = config
+			}
+			for _, mappingType := range authMappingTypes[mount.Type] {
+				items, err := v.exportMappings(fmt.Sprintf("auth/%s/%s", path, mappingType))
+				if err != nil {
+					return nil, err
+				}
+				if len(items) > 0 {
+					mapping := map[string]interface{}{}
+					for name, item := range items {
+						mapping[name] = item
+					}
+					auth[mappingType] = mapping
+				}
+			}
+		}
+
+		auths = append(auths, auth)
+	}
+	return auths, nil
+}
+
+func (v *vault) exportAuditDevices() ([]interface{}, error) {
+	devices, err := v.cl.Sys().ListAudit()
+	if err != nil {
+		return nil, err
+	}
+
+	paths := []string{}
+	for path := range devices {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	audits := []interface{}{}
+	for _, devicePath := range paths {
+		device := devices[devicePath]
+		path := strings.TrimSuffix(devicePath, "/")
+
+		audit := map[string]interface{}{"type": device.Type}
+		if path != device.Type {
+			audit["path"] = path
+		}
+		if device.Description != "" {
+			audit["description"] = device.Description
+		}
+		if len(device.Options) > 0 {
+			audit["options"] = device.Options
+		}
+		if device.Local {
+			audit["local"] = true
+		}
+		audits = append(audits, audit)
+	}
+	return audits, nil
+}
+
+func (v *vault) exportSecretEngines() ([]interface{}, error) {
+	mounts, err := v.cl.Sys().ListMounts()
+	if err != nil {
+		return nil, err
+	}
+
+	paths := []string{}
+	for path := range mounts {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	secrets := []interface{}{}
+	for _, mountPath := range paths {
+		mount := mounts[mountPath]
+		path := strings.TrimSuffix(mountPath, "/")
+		if systemMounts[path] {
+			continue
+		}
+
+		secret := map[string]interface{}{"type": mount.Type}
+		if path != mount.Type {
+			secret["path"] = path
+		}
+		if mount.Description != "" {
+			secret["description"] = mount.Description
+		}
+		if len(mount.Options) > 0 {
+			secret["options"] = mount.Options
+		}
+
+		// Only the configuration of the database secret engine can be listed in a generic way
+		if mount.Type == "database" {
+			configuration := map[string]interface{}{}
+			for _, configOption := range []string{"config", "roles"} {
+				items, err := v.exportItems(fmt.Sprintf("%s/%s", path, configOption))
+				if err != nil {
+					return nil, err
+				}
+				if len(items) > 0 {
+					configuration[configOption] = items
+				}
+			}
+			if len(configuration) > 0 {
+				secret["configuration"] = configuration
+			}
+		}
+
+		if mount.Type == "pki" {
+			pki, err := v.exportPKI(path)
+			if err != nil {
+				return nil, err
+			}
+			if len(pki) > 0 {
+				secret[pkiField] = pki
+			}
+		}
+
+		if mount.Type == "ssh" {
+			roles, err := v.exportItems(path + "/roles")
+			if err != nil {
+				return nil, err
+			}
+			if len(roles) > 0 {
+				secret[sshField] = map[string]interface{}{"roles": roles}
+			}
+		}
+
+		secrets = append(secrets, secret)
+	}
+	return secrets, nil
+}
+
+// exportPKI reads the URLs and the roles of the pki secret engine at path, the CA is left out
+func (v *vault) exportPKI(path string) (map[string]interface{}, error) {
+	pki := map[string]interface{}{}
+	urls, err := v.exportItem(path + "/config/urls")
+	if err != nil {
+		return nil, err
+	}
+	if len(urls) > 0 {
+		pki["urls"] = urls
+	}
+	roles, err := v.exportItems(path + "/roles")
+	if err != nil {
+		return nil, err
+	}
+	if len(roles) > 0 {
+		pki["roles"] = roles
+	}
+	return pki, nil
+}
+
+// exportItems lists the items under path and returns them with their names
+func (v *vault) exportItems(path string) ([]interface{}, error) {
+	mappings, err := v.exportMappings(path)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for name := range mappings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	items := []interface{}{}
+	for _, name := range names {
+		item := mappings[name]
+		item["name"] = name
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// exportMappings lists the items under path and returns them by their names
+func (v *vault) exportMappings(path string) (map[string]map[string]interface{}, error) {
+	secret, err := v.cl.Logical().List(path)
+	if err != nil {
+		return nil, fmt.Errorf("error listing %s: %s", path, err.Error())
+	}
+
+	items := map[string]map[string]interface{}{}
+	if secret == nil {
+		return items, nil
+	}
+	for _, key := range cast.ToStringSlice(secret.Data["keys"]) {
+		item, err := v.exportItem(path + "/" + key)
+		if err != nil {
+			return nil, err
+		}
+		if item != nil {
+			items[key] = item
+		}
+	}
+	return items, nil
+}
+
+// exportItem reads the item at path without its empty fields, nil if it doesn't exist
+func (v *vault) exportItem(path string) (map[string]interface{}, error) {
+	secret, err := v.cl.Logical().Read(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %s", path, err.Error())
+	}
+	if secret == nil {
+		return nil, nil
+	}
+
+	item := map[string]interface{}{}
+	for key, value := range secret.Data {
+		if isEmptyValue(value) {
+			continue
+		}
+		item[key] = value
+	}
+	return item, nil
+}
+
+func isEmptyValue(value interface{}) bool {
+	switch value := value.(type) {
+	case nil:
+		return true
+	case string:
+		return value == ""
+	case bool:
+		return !value
+	case json.Number:
+		return value.String() == "0"
+	case []interface{}:
+		return len(value) == 0
+	case map[string]interface{}:
+		return len(value) == 0
+	}
+	return false
+}
@@ -0,0 +1,229 @@
+package vault
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/banzaicloud/bank-vaults/pkg/logging"
+	"github.com/ghodss/yaml"
+	"github.com/mitchellh/mapstructure"
+)
+
+// ExternalConfig is the external configuration applied by Configure: the auth methods, the policies
+// and the secret engines, in the format of the configuration file. It can be built as a literal, or
+// unmarshaled from YAML or JSON with UnmarshalExternalConfig, encoding/json or a YAML library
+// (github.com/ghodss/yaml, sigs.k8s.io/yaml or gopkg.in/yaml.v2), and it is marshaled in the format
+// of the configuration file, with the unknown sections and fields it has been unmarshaled with.
+type ExternalConfig struct {
+	// APIVersion is the version of the schema, ConfigAPIVersion after parsing (the older versions are migrated)
+	APIVersion string `json:"apiVersion,omitempty" mapstructure:"apiVersion"`
+	// Namespace is the Vault Enterprise namespace the auth methods, policies, secret engines and startup
+	// secrets are applied in (unless they have a namespace field), the root namespace if it is empty
+	Namespace string `json:"namespace,omitempty" mapstructure:"namespace"`
+	// Auth are the auth methods, e.g. {"type": "kubernetes", "path": "k8s", "roles": [...]}
+	Auth []map[string]interface{} `json:"auth,omitempty" mapstructure:"auth"`
+	// Policies are the ACL policies with their name and rules (HCL)
+	Policies []map[string]string `json:"policies,omitempty" mapstructure:"policies"`
+	// Secrets are the secret engines, e.g. {"type": "database", "path": "db", "configuration": {...}}
+	Secrets []map[string]interface{} `json:"secrets,omitempty" mapstructure:"secrets"`
+	// Audit are the audit devices, e.g. {"type": "file", "options": {"file_path": "/vault/logs/audit.log"}}
+	Audit []map[string]interface{} `json:"audit,omitempty" mapstructure:"audit"`
+	// Sys are the cluster-wide settings, e.g. {"cors": {...}, "tune": {"secret": {"max_lease_ttl": "24h"}}}
+	Sys map[string]interface{} `json:"sys,omitempty" mapstructure:"sys"`
+	// Identity are the entities and groups of the identity secret engine, e.g. {"entities": [{"name":
+	// "jane", "aliases": [{"name": "jane", "mount": "ldap"}]}], "groups": [...]}, applied in Namespace
+	Identity map[string]interface{} `json:"identity,omitempty" mapstructure:"identity"`
+	// StartupSecrets are the secrets written after the secret engines have been mounted, e.g.
+	// {"path": "secret/app", "data": {"password": "..."}}, only if they don't exist unless cas is false
+	StartupSecrets []map[string]interface{} `json:"startupSecrets,omitempty" mapstructure:"startupSecrets"`
+
+	// settings are the sections as they have been parsed, with the fields unknown to Configure
+	settings map[string]interface{}
+}
+
+// ParseExternalConfig parses the sections of the external configuration, e.g. the settings of the
+// configuration file read with viper. The configurations of the older versions of the schema are
+// migrated to ConfigAPIVersion, with a warning about every deprecated field.
+func ParseExternalConfig(settings map[string]interface{}) (*ExternalConfig, error) {
+	settings, warnings, err := MigrateConfig(settings)
+	if err != nil {
+		return nil, err
+	}
+	for _, warning := range warnings {
+		logging.Default().Warnf("vault config: %s", warning.Error())
+	}
+
+	config := ExternalConfig{settings: map[string]interface{}{}}
+	if err := mapstructure.WeakDecode(settings, &config); err != nil {
+		return nil, fmt.Errorf("error parsing vault config: %s", err.Error())
+	}
+	for section, value := range settings {
+		config.settings[section] = value
+	}
+	return &config, nil
+}
+
+// Section returns the value of a top-level section as it has been parsed, e.g. the section of a
+// Configurator, nil if it is missing
+func (c *ExternalConfig) Section(name string) interface{} {
+	return c.sections()[name]
+}
+
+// sections returns the sections of the configuration as they have been parsed, so the unknown
+// sections and fields can be verified, or built from the fields if it hasn't been parsed
+func (c *ExternalConfig) sections() map[string]interface{} {
+	if c == nil {
+		return map[string]interface{}{}
+	}
+	if c.settings != nil {
+		return c.settings
+	}
+
+	sections := map[string]interface{}{}
+	if c.APIVersion != "" {
+		sections[configAPIVersionKey] = c.APIVersion
+	}
+	if c.Namespace != "" {
+		sections[namespaceField] = c.Namespace
+	}
+	list := func(section string, items []interface{}) {
+		if len(items) > 0 {
+			sections[section] = items
+		}
+	}
+	auth := []interface{}{}
+	for _, item := range c.Auth {
+		auth = append(auth, item)
+	}
+	list("auth", auth)
+	policies := []interface{}{}
+	for _, item := range c.Policies {
+		policy := map[string]interface{}{}
+		for key, value := range item {
+			policy[key] = value
+		}
+		policies = append(policies, policy)
+	}
+	list("policies", policies)
+	secrets := []interface{}{}
+	for _, item := range c.Secrets {
+		secrets = append(secrets, item)
+	}
+	list("secrets", secrets)
+	audit := []interface{}{}
+	for _, item := range c.Audit {
+		audit = append(audit, item)
+	}
+	list("audit", audit)
+	startupSecrets := []interface{}{}
+	for _, item := range c.StartupSecrets {
+		startupSecrets = append(startupSecrets, item)
+	}
+	list("startupSecrets", startupSecrets)
+	if len(c.Sys) > 0 {
+		sections["sys"] = c.Sys
+	}
+	if len(c.Identity) > 0 {
+		sections["identity"] = c.Identity
+	}
+	return sections
+}
+
+// desiredSections returns the sections of the configuration applied by Configure, with the sections
+// of the registered Configurators
+func (c *ExternalConfig) desiredSections() map[string]interface{} {
+	desired := map[string]interface{}{}
+	for section, value := range c.sections() {
+		if knownConfigSection(section) && value != nil {
+			desired[section] = value
+		}
+	}
+	return desired
+}
+
+// UnmarshalExternalConfig parses the external configuration from YAML or JSON
+func UnmarshalExternalConfig(data []byte) (*ExternalConfig, error) {
+	var config ExternalConfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("error parsing vault config: %s", err.Error())
+	}
+	return &config, nil
+}
+
+// Validate checks the configuration without contacting Vault like VerifyConfig (or VerifyConfigStrict
+// if strict), the problems are returned as ConfigErrors
+func (c *ExternalConfig) Validate(strict bool) error {
+	if errs := verifyConfig(c.sections(), strict); len(errs) > 0 {
+		return ConfigErrors(errs)
+	}
+	return nil
+}
+
+// MarshalJSON marshals the sections of the configuration, in the format of the configuration file
+func (c ExternalConfig) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.sections())
+}
+
+// UnmarshalJSON parses the configuration like ParseExternalConfig, the numbers are kept as json.Number
+func (c *ExternalConfig) UnmarshalJSON(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var settings map[string]interface{}
+	if err := decoder.Decode(&settings); err != nil {
+		return err
+	}
+	return c.parse(settings)
+}
+
+// MarshalYAML implements the yaml.Marshaler interface of gopkg.in/yaml.v2
+func (c ExternalConfig) MarshalYAML() (interface{}, error) {
+	return c.sections(), nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface of gopkg.in/yaml.v2
+func (c *ExternalConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var settings map[string]interface{}
+	if err := unmarshal(&settings); err != nil {
+		return err
+	}
+	for section, value := range settings {
+		settings[section] = stringKeys(value)
+	}
+	return c.parse(settings)
+}
+
+func (c *ExternalConfig) parse(settings map[string]interface{}) error {
+	if settings == nil {
+		settings = map[string]interface{}{}
+	}
+	config, err := ParseExternalConfig(settings)
+	if err != nil {
+		return err
+	}
+	*c = *config
+	return nil
+}
+
+// stringKeys converts the map[interface{}]interface{} maps of gopkg.in/yaml.v2 to map[string]interface{}
+func stringKeys(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[interface{}]interface{}:
+		m := map[string]interface{}{}
+		for key, item := range value {
+			m[fmt.Sprint(key)] = stringKeys(item)
+		}
+		return m
+	case map[string]interface{}:
+		for key, item := range value {
+			value[key] = stringKeys(item)
+		}
+		return value
+	case []interface{}:
+		for i, item := range value {
+			value[i] = stringKeys(item)
+		}
+		return value
+	}
+	return value
+}
@@ -0,0 +1,228 @@
+package vault
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+)
+
+// The keys of the configuration history in its store, the records are stored under
+// ConfigHistoryKeyPrefix followed by their sequence number
+const (
+	ConfigHistoryHeadKey   = "config-history-head"
+	ConfigHistoryKeyPrefix = "config-history-"
+)
+
+// ConfigRecord is an entry of the configuration history, written after every successful Configure
+type ConfigRecord struct {
+	// Sequence is the number of the record in the history, starting from 1
+	Sequence int       `json:"sequence"`
+	Time     time.Time `json:"time"`
+	// ConfigHash is the SHA-256 hash of the applied configuration sections
+	ConfigHash string `json:"configHash"`
+	// Actor is who applied the configuration, e.g. the auth method and the host
+	Actor string `json:"actor"`
+	// Changes are the objects created or updated by the configuration (without the values, which
+	// may be secrets), nil if they couldn't be compared, Resources is the number of the resources applied
+	Changes   []ConfigChange `json:"changes"`
+	Resources int            `json:"resources"`
+	// PreviousHash is the SHA-256 hash of the previous record, empty for the first one
+	PreviousHash string `json:"previousHash,omitempty"`
+	// Signature is the HMAC-SHA256 of the record (without the signature) with the signing key
+	Signature string `json:"signature"`
+}
+
+// ConfigHistoryError is returned if a record of the history has been modified, removed or
+// inserted without the signing key
+type ConfigHistoryError struct {
+	Sequence int
+	Reason   string
+}
+
+func (e *ConfigHistoryError) Error() string {
+	return fmt.Sprintf("configuration history record %d has been tampered with: %s", e.Sequence, e.Reason)
+}
+
+// ConfigHistory is a tamper-evident history of the configuration kept in a key store: every record
+// is signed with the signing key and holds the hash of the previous one, so a changed, removed or
+// inserted record is detected by Records
+type ConfigHistory struct {
+	store      kv.Service
+	signingKey []byte
+}
+
+// NewConfigHistory returns the configuration history kept in the store, signed with the key
+func NewConfigHistory(store kv.Service, signingKey []byte) (*ConfigHistory, error) {
+	if len(signingKey) == 0 {
+		return nil, errors.New("the signing key of the configuration history is empty")
+	}
+	return &ConfigHistory{store: store, signingKey: signingKey}, nil
+}
+
+// Append signs the record and stores it after the last one, Sequence, PreviousHash and Signature
+// are set by it. Of two concurrent writers only one can store the record of a sequence number
+// if the store is a kv.Creator.
+func (h *ConfigHistory) Append(record ConfigRecord) (*ConfigRecord, error) {
+	head, err := h.head()
+	if err != nil {
+		return nil, err
+	}
+
+	record.Sequence = head + 1
+	record.PreviousHash = ""
+	if head > 0 {
+		previous, err := h.store.Get(configRecordKey(head))
+		if err != nil {
+			return nil, fmt.Errorf("error reading configuration history record %d: %s", head, err.Error())
+		}
+		record.PreviousHash = hashConfigRecord(previous)
+	}
+
+	record.Signature, err = h.sign(record)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling configuration history record: %s", err.Error())
+	}
+
+	err = kv.Create(h.store, configRecordKey(record.Sequence), data)
+	if _, ok := err.(*kv.AlreadyExistsError); ok {
+		return nil, fmt.Errorf("configuration history record %d has been written concurrently", record.Sequence)
+	} else if err != nil {
+		return nil, fmt.Errorf("error storing configuration history record %d: %s", record.Sequence, err.Error())
+	}
+
+	if err := h.store.Set(ConfigHistoryHeadKey, []byte(strconv.Itoa(record.Sequence))); err != nil {
+		return nil, fmt.Errorf("error storing configuration history head: %s", err.Error())
+	}
+	return &record, nil
+}
+
+// Records returns the history from the first record, or a *ConfigHistoryError with the valid
+// records before the first one which has been tampered with
+func (h *ConfigHistory) Records() ([]ConfigRecord, error) {
+	head, err := h.head()
+	if err != nil {
+		return nil, err
+	}
+
+	records := []ConfigRecord{}
+	previousHash := ""
+	for sequence := 1; sequence <= head; sequence++ {
+		data, err := h.store.Get(configRecordKey(sequence))
+		if _, ok := err.(*kv.NotFoundError); ok {
+			return records, &ConfigHistoryError{Sequence: sequence, Reason: "it is missing"}
+		} else if err != nil {
+			return nil, fmt.Errorf("error reading configuration history record %d: %s", sequence, err.Error())
+		}
+
+		var record ConfigRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			return records, &ConfigHistoryError{Sequence: sequence, Reason: "it isn't a valid record"}
+		}
+
+		signature, err := h.sign(record)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case !hmac.Equal([]byte(signature), []byte(record.Signature)):
+			return records, &ConfigHistoryError{Sequence: sequence, Reason: "invalid signature"}
+		case record.Sequence != sequence:
+			return records, &ConfigHistoryError{Sequence: sequence, Reason: fmt.Sprintf("it is record %d", record.Sequence)}
+		case record.PreviousHash != previousHash:
+			return records, &ConfigHistoryError{Sequence: sequence, Reason: "the previous record has been changed"}
+		}
+
+		records = append(records, record)
+		previousHash = hashConfigRecord(data)
+	}
+	return records, nil
+}
+
+// head returns the sequence number of the last record, 0 if the history is empty
+func (h *ConfigHistory) head() (int, error) {
+	data, err := h.store.Get(ConfigHistoryHeadKey)
+	if _, ok := err.(*kv.NotFoundError); ok {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("error reading configuration history head: %s", err.Error())
+	}
+
+	head, err := strconv.Atoi(string(data))
+	if err != nil || head < 0 {
+		return 0, fmt.Errorf("invalid configuration history head: %q", data)
+	}
+	return head, nil
+}
+
+// sign returns the signature of the record, which is computed without its own signature
+func (h *ConfigHistory) sign(record ConfigRecord) (string, error) {
+	record.Signature = ""
+	data, err := json.Marshal(record)
+	if err != nil {
+		return "", fmt.Errorf("error marshaling configuration history record: %s", err.Error())
+	}
+	mac := hmac.New(sha256.New, h.signingKey)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+func configRecordKey(sequence int) string {
+	return ConfigHistoryKeyPrefix + strconv.Itoa(sequence)
+}
+
+func hashConfigRecord(data []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(data))
+}
+
+// recordConfiguration appends the successful configuration to the history, with the changes
+// which have been applied by it
+func (v *vault) recordConfiguration(externalConfig *ExternalConfig, changes []ConfigChange, report *ConfigureReport) error {
+	// The keys of the maps are sorted by json.Marshal, so the hash is stable
+	config, err := json.Marshal(externalConfig.desiredSections())
+	if err != nil {
+		return fmt.Errorf("error marshaling vault configuration for the history: %s", err.Error())
+	}
+
+	var applied []ConfigChange
+	if changes != nil {
+		applied = appliedChanges(changes, v.purges)
+	}
+
+	record, err := v.config.History.Append(ConfigRecord{
+		Time:       time.Now().UTC(),
+		ConfigHash: fmt.Sprintf("%x", sha256.Sum256(config)),
+		Actor:      v.config.HistoryActor,
+		Changes:    applied,
+		Resources:  len(report.Resources),
+	})
+	if err != nil {
+		return fmt.Errorf("error recording the configuration in the history: %s", err.Error())
+	}
+	v.logger().Infof("recorded the configuration in the history as record %d", record.Sequence)
+	return nil
+}
+
+// appliedChanges returns the objects created or updated in the changes returned by Diff without
+// their values, which may be secrets, and the deleted objects which are purged
+func appliedChanges(changes []ConfigChange, purges func(path string) bool) []ConfigChange {
+	applied := []ConfigChange{}
+	for _, change := range changes {
+		if change.Action == ConfigChangeDelete && !purges(change.Path) {
+			continue
+		}
+		applied = append(applied, ConfigChange{Action: change.Action, Path: change.Path})
+	}
+	return applied
+}
@@ -0,0 +1,116 @@
+package vault
+
+import (
+	"fmt"
+	"time"
+)
+
+// The phases of the lifecycle actions the hooks are called around
+const (
+	HookPhaseInit   = "init"
+	HookPhaseUnseal = "unseal"
+	// HookPhaseConfigure is the whole configuration, HookPhaseConfigureAudit, HookPhaseConfigureAuth,
+	// HookPhaseConfigurePolicies and HookPhaseConfigureSecrets are its parts, applying the sections of
+	// the external configuration, HookPhaseConfigureSys applies the sys section, if there is one,
+	// HookPhaseConfigureIdentity the identity section, if there is one,
+	// HookPhaseConfigureCustom applies the sections of the registered Configurators, if there are any,
+	// HookPhaseConfigurePurge deletes the unmanaged resources, if PurgeUnmanaged is set
+	HookPhaseConfigure         = "configure"
+	HookPhaseConfigureAudit    = "configure-audit"
+	HookPhaseConfigureAuth     = "configure-auth"
+	HookPhaseConfigurePolicies = "configure-policies"
+	HookPhaseConfigureSecrets  = "configure-secrets"
+	HookPhaseConfigureSys      = "configure-sys"
+	HookPhaseConfigureIdentity = "configure-identity"
+	HookPhaseConfigureCustom   = "configure-custom"
+	HookPhaseConfigurePurge    = "configure-purge"
+)
+
+// HookPhases are all the phases, in the order of the lifecycle
+var HookPhases = []string{HookPhaseInit, HookPhaseUnseal, HookPhaseConfigure, HookPhaseConfigureAudit, HookPhaseConfigureAuth, HookPhaseConfigurePolicies, HookPhaseConfigureSecrets, HookPhaseConfigureSys, HookPhaseConfigureIdentity, HookPhaseConfigureCustom, HookPhaseConfigurePurge}
+
+// The stages of a phase
+const (
+	HookStagePre  = "pre"
+	HookStagePost = "post"
+)
+
+// HookEvent is the phase a Hook is called before or after
+type HookEvent struct {
+	Phase string `json:"phase"`
+	Stage string `json:"stage"`
+	// Config is the external configuration of the configure phases, it isn't marshaled, because it may
+	// contain secrets
+	Config *ExternalConfig `json:"-"`
+	// Report is the outcome of the resources applied so far in the post stage of the configure phases
+	Report *ConfigureReport `json:"report,omitempty"`
+	// Error is the error of the phase in the post stage, empty if it has succeeded
+	Error string    `json:"error,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+// Name returns the stage and the phase of the event, e.g. pre-configure
+func (e *HookEvent) Name() string {
+	return e.Stage + "-" + e.Phase
+}
+
+// Hook is called before and after the lifecycle phases, e.g. to validate or approve a configuration,
+// or to notify other systems. The error of a pre hook aborts the phase with a *HookError, the errors
+// of the post hooks are logged only. The hooks are called from the goroutine of the action.
+type Hook interface {
+	Call(event *HookEvent) error
+}
+
+// HookFunc is a Hook calling the function
+type HookFunc func(event *HookEvent) error
+
+// Call calls the function
+func (f HookFunc) Call(event *HookEvent) error {
+	return f(event)
+}
+
+// HookError is returned if a pre hook has aborted a phase
+type HookError struct {
+	// Event is the name of the event, e.g. pre-configure
+	Event string
+	Err   error
+}
+
+func (e *HookError) Error() string {
+	return fmt.Sprintf("%s hook failed: %s", e.Event, e.Err.Error())
+}
+
+// Unwrap returns the error of the hook
+func (e *HookError) Unwrap() error {
+	return e.Err
+}
+
+// withHooks calls the pre hooks of the phase, then f unless one of them fails, and the post hooks
+// with the outcome of f. f can set the fields of the event passed to the post hooks.
+func (v *vault) withHooks(event *HookEvent, f func() error) error {
+	if v.config == nil || len(v.config.Hooks) == 0 {
+		return f()
+	}
+
+	event.Stage = HookStagePre
+	event.Time = time.Now().UTC()
+	for _, hook := range v.config.Hooks {
+		if err := hook.Call(event); err != nil {
+			return &HookError{Event: event.Name(), Err: err}
+		}
+	}
+
+	err := f()
+
+	event.Stage = HookStagePost
+	event.Time = time.Now().UTC()
+	if err != nil {
+		event.Error = err.Error()
+	}
+	for _, hook := range v.config.Hooks {
+		if hookErr := hook.Call(event); hookErr != nil {
+			v.logger().Warnf("%s hook failed: %s", event.Name(), hookErr.Error())
+		}
+	}
+	return err
+}
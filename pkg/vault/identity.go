@@ -0,0 +1,249 @@
+package vault
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/banzaicloud/bank-vaults/pkg/tracing"
+	"github.com/hashicorp/vault/api"
+	"github.com/spf13/cast"
+)
+
+// The fields of the identity section, the entities and groups of the identity secret engine
+const (
+	// identityEntitiesField are the entities by their names, with their aliases in the auth methods,
+	// e.g. [{"name": "jane", "policies": ["dev"], "aliases": [{"name": "jane", "mount": "ldap"}]}]
+	identityEntitiesField = "entities"
+	// identityGroupsField are the groups by their names, the internal ones with their members (the
+	// names of entities and groups), the external ones with their alias in an auth method, e.g.
+	// [{"name": "admins", "type": "external", "alias": {"name": "vault-admins", "mount": "ldap"}}]
+	identityGroupsField = "groups"
+)
+
+// identityFields are the fields of the identity section in the order they are applied
+var identityFields = []string{identityEntitiesField, identityGroupsField}
+
+// The fields of the entities and groups which aren't written to Vault as they are
+const (
+	identityAliasesField        = "aliases"
+	identityAliasField          = "alias"
+	identityMemberEntitiesField = "member_entities"
+	identityMemberGroupsField   = "member_groups"
+)
+
+// identityAlias is an alias of an entity or a group in the auth method mounted at mount
+type identityAlias struct {
+	name  string
+	mount string
+}
+
+// identityAliasOf parses an alias of the configuration
+func identityAliasOf(alias interface{}) identityAlias {
+	fields := cast.ToStringMap(alias)
+	return identityAlias{name: cast.ToString(fields["name"]), mount: strings.Trim(cast.ToString(fields["mount"]), "/")}
+}
+
+// configureIdentity applies the identity section: the entities with their aliases first, then the
+// groups, whose members are looked up by their names, so a group may contain the entities and the
+// groups defined before it. Every outcome is recorded in the report.
+func (v *vault) configureIdentity(parent *tracing.Span, identity map[string]interface{}, report *ConfigureReport) (err error) {
+	if len(identity) == 0 {
+		return nil
+	}
+
+	span := v.tracer().StartSpan(parent, "vault.configureIdentity")
+	defer func() { span.End(err) }()
+
+	// The aliases refer to the auth methods by the accessors of their mounts
+	var auths map[string]*api.AuthMount
+	err = v.retry("listing auth methods", func() (err error) {
+		auths, err = v.cl.Sys().ListAuth()
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error listing auth methods: %s", err.Error())
+	}
+	accessors := map[string]string{}
+	for path, auth := range auths {
+		accessors[strings.Trim(path, "/")] = auth.Accessor
+	}
+
+	ids := map[string]string{}
+	for _, entity := range cast.ToSlice(identity[identityEntitiesField]) {
+		v.configureIdentityEntity(cast.ToStringMap(entity), accessors, ids, report)
+	}
+	for _, group := range cast.ToSlice(identity[identityGroupsField]) {
+		v.configureIdentityGroup(cast.ToStringMap(group), accessors, ids, report)
+	}
+	return nil
+}
+
+// configureIdentityEntity creates or updates the entity by its name and adds its missing aliases,
+// the ID of the entity is recorded in ids by its path
+func (v *vault) configureIdentityEntity(entity map[string]interface{}, accessors map[string]string, ids map[string]string, report *ConfigureReport) {
+	started := time.Now()
+	name := cast.ToString(entity["name"])
+	path := "identity/entity/name/" + name
+
+	existing, err := v.readIdentity(path)
+	if err == nil {
+		err = v.retryWrite(path, withoutField(withoutField(entity, "name"), identityAliasesField))
+	}
+	var current *api.Secret
+	if err == nil {
+		// The ID of a new entity is read back, Vault returns it only when it is created
+		current, err = v.readIdentity(path)
+		if err == nil && current == nil {
+			err = fmt.Errorf("the entity doesn't exist after writing it")
+		}
+	}
+	if err != nil {
+		report.add(ResourceIdentityEntity, path, existing != nil, started, fmt.Errorf("error configuring entity %s: %s", name, err.Error()))
+		return
+	}
+	report.add(ResourceIdentityEntity, path, existing != nil, started, nil)
+	id := cast.ToString(current.Data["id"])
+	ids[path] = id
+
+	aliased := map[identityAlias]bool{}
+	for _, alias := range cast.ToSlice(current.Data["aliases"]) {
+		alias := cast.ToStringMap(alias)
+		aliased[identityAlias{name: cast.ToString(alias["name"]), mount: cast.ToString(alias["mount_accessor"])}] = true
+	}
+	for _, alias := range cast.ToSlice(entity[identityAliasesField]) {
+		alias := identityAliasOf(alias)
+		started := time.Now()
+		aliasPath := fmt.Sprintf("identity/entity-alias/%s/%s", alias.mount, alias.name)
+		accessor, ok := accessors[alias.mount]
+		if !ok {
+			report.add(ResourceIdentityAlias, aliasPath, false, started, fmt.Errorf("error configuring alias %s of entity %s: auth method %s isn't enabled", alias.name, name, alias.mount))
+			continue
+		}
+		if aliased[identityAlias{name: alias.name, mount: accessor}] {
+			report.skip(ResourceIdentityAlias, aliasPath, started)
+			continue
+		}
+		err := v.retryWrite("identity/entity-alias", map[string]interface{}{
+			"name":           alias.name,
+			"mount_accessor": accessor,
+			"canonical_id":   id,
+		})
+		if err != nil {
+			err = fmt.Errorf("error configuring alias %s of entity %s: %s", alias.name, name, err.Error())
+		}
+		report.add(ResourceIdentityAlias, aliasPath, false, started, err)
+	}
+}
+
+// configureIdentityGroup creates or updates the group by its name with the IDs of its members, and
+// sets the alias of an external group, the ID of the group is recorded in ids by its path
+func (v *vault) configureIdentityGroup(group map[string]interface{}, accessors map[string]string, ids map[string]string, report *ConfigureReport) {
+	started := time.Now()
+	name := cast.ToString(group["name"])
+	path := "identity/group/name/" + name
+
+	fields := map[string]interface{}{}
+	for key, value := range group {
+		switch key {
+		case "name", identityAliasField, identityMemberEntitiesField, identityMemberGroupsField:
+		default:
+			fields[key] = value
+		}
+	}
+
+	existing, err := v.readIdentity(path)
+	for _, members := range []struct{ field, kind, idsField string }{
+		{identityMemberEntitiesField, "entity", "member_entity_ids"},
+		{identityMemberGroupsField, "group", "member_group_ids"},
+	} {
+		if _, ok := group[members.field]; !ok || err != nil {
+			continue
+		}
+		memberIDs := []string{}
+		for _, member := range cast.ToStringSlice(group[members.field]) {
+			var id string
+			if id, err = v.identityID(members.kind, member, ids); err != nil {
+				break
+			}
+			memberIDs = append(memberIDs, id)
+		}
+		fields[members.idsField] = memberIDs
+	}
+	if err == nil {
+		err = v.retryWrite(path, fields)
+	}
+	var current *api.Secret
+	if err == nil {
+		current, err = v.readIdentity(path)
+		if err == nil && current == nil {
+			err = fmt.Errorf("the group doesn't exist after writing it")
+		}
+	}
+	if err != nil {
+		report.add(ResourceIdentityGroup, path, existing != nil, started, fmt.Errorf("error configuring group %s: %s", name, err.Error()))
+		return
+	}
+	report.add(ResourceIdentityGroup, path, existing != nil, started, nil)
+	id := cast.ToString(current.Data["id"])
+	ids[path] = id
+
+	if _, ok := group[identityAliasField]; !ok {
+		return
+	}
+	alias := identityAliasOf(group[identityAliasField])
+	started = time.Now()
+	aliasPath := fmt.Sprintf("identity/group-alias/%s/%s", alias.mount, alias.name)
+	accessor, ok := accessors[alias.mount]
+	if !ok {
+		report.add(ResourceIdentityAlias, aliasPath, false, started, fmt.Errorf("error configuring alias %s of group %s: auth method %s isn't enabled", alias.name, name, alias.mount))
+		return
+	}
+	// An external group has a single alias, it is renamed if it exists
+	currentAlias := cast.ToStringMap(current.Data["alias"])
+	aliasID := cast.ToString(currentAlias["id"])
+	if aliasID != "" && cast.ToString(currentAlias["name"]) == alias.name && cast.ToString(currentAlias["mount_accessor"]) == accessor {
+		report.skip(ResourceIdentityAlias, aliasPath, started)
+		return
+	}
+	aliasWritePath := "identity/group-alias"
+	if aliasID != "" {
+		aliasWritePath += "/id/" + aliasID
+	}
+	err = v.retryWrite(aliasWritePath, map[string]interface{}{
+		"name":           alias.name,
+		"mount_accessor": accessor,
+		"canonical_id":   id,
+	})
+	if err != nil {
+		err = fmt.Errorf("error configuring alias %s of group %s: %s", alias.name, name, err.Error())
+	}
+	report.add(ResourceIdentityAlias, aliasPath, aliasID != "", started, err)
+}
+
+// identityID returns the ID of the entity or group of the name, of ids if it has been configured,
+// otherwise it is read from Vault
+func (v *vault) identityID(kind, name string, ids map[string]string) (string, error) {
+	path := fmt.Sprintf("identity/%s/name/%s", kind, name)
+	if id, ok := ids[path]; ok {
+		return id, nil
+	}
+	secret, err := v.readIdentity(path)
+	if err != nil {
+		return "", err
+	}
+	if secret == nil {
+		return "", fmt.Errorf("member %s %s doesn't exist", kind, name)
+	}
+	ids[path] = cast.ToString(secret.Data["id"])
+	return ids[path], nil
+}
+
+// readIdentity reads an entity or a group, nil if it doesn't exist
+func (v *vault) readIdentity(path string) (secret *api.Secret, err error) {
+	err = v.retry("reading "+path, func() (err error) {
+		secret, err = v.cl.Logical().Read(path)
+		return err
+	})
+	return secret, err
+}
@@ -0,0 +1,409 @@
+//go:build integration
+// +build integration
+
+package vault
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/awskms"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/gcs"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/k8s"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/kvtest"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/s3"
+	"github.com/hashicorp/vault/api"
+	"google.golang.org/api/option"
+)
+
+// The integration tests run the whole Init, Unseal and Configure cycle against real Vault
+// containers, with a Shamir seal and with an AWS KMS auto-unseal seal, and with every key store
+// which has an emulator. They need Docker, and are run with:
+//
+//	go test -tags integration -v ./pkg/vault/
+//
+// The Vault image can be changed with VAULT_IMAGE, e.g. to check a new release of Vault. The
+// Kubernetes key store is tested only if KUBECONFIG is set, in its default namespace.
+
+const defaultVaultImage = "vault:1.0.3"
+
+const integrationConfig = `
+auth:
+  - type: userpass
+    path: people
+policies:
+  - name: allow_secrets
+    rules: path "secret/*" { capabilities = ["read"] }
+secrets:
+  - type: kv
+    path: secret
+    options:
+      version: 2
+`
+
+const (
+	emulatorRegion    = "eu-west-1"
+	emulatorAccessKey = "bank-vaults"
+	emulatorSecretKey = "bank-vaults-secret"
+)
+
+// emulators are the containers of the key store and KMS emulators shared by the tests
+var emulators struct {
+	network string
+	s3      string
+	gcs     string
+	kms     string
+	kmsKey  string
+}
+
+// testID tells apart the buckets, secrets and containers of the tests
+var testID = fmt.Sprint(time.Now().Unix())
+
+func TestMain(m *testing.M) {
+	if err := exec.Command("docker", "version").Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "the integration tests need docker: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	code, err := runWithEmulators(m)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error starting the emulators: %s\n", err.Error())
+		code = 1
+	}
+	os.Exit(code)
+}
+
+func runWithEmulators(m *testing.M) (int, error) {
+	emulators.network = "bank-vaults-it-" + testID
+	if _, err := docker("network", "create", emulators.network); err != nil {
+		return 0, err
+	}
+	defer docker("network", "rm", emulators.network)
+
+	var containers []string
+	defer func() {
+		for _, container := range containers {
+			docker("rm", "-f", container)
+		}
+	}()
+
+	minio, address, err := runContainer("minio", "9000",
+		[]string{"MINIO_ACCESS_KEY=" + emulatorAccessKey, "MINIO_SECRET_KEY=" + emulatorSecretKey,
+			"MINIO_ROOT_USER=" + emulatorAccessKey, "MINIO_ROOT_PASSWORD=" + emulatorSecretKey},
+		"minio/minio", "server", "/data")
+	if err != nil {
+		return 0, err
+	}
+	containers = append(containers, minio)
+	emulators.s3 = "http://" + address
+
+	fakeGCS, address, err := runContainer("gcs", "4443", nil, "fsouza/fake-gcs-server", "-scheme", "http", "-port", "4443")
+	if err != nil {
+		return 0, err
+	}
+	containers = append(containers, fakeGCS)
+	emulators.gcs = address
+
+	localKMS, address, err := runContainer("kms", "8080", []string{"KMS_REGION=" + emulatorRegion}, "nsmithuk/local-kms")
+	if err != nil {
+		return 0, err
+	}
+	containers = append(containers, localKMS)
+	emulators.kms = "http://" + address
+
+	err = waitFor(time.Minute, func() error {
+		key, err := kms.New(emulatorSession(emulators.kms)).CreateKey(&kms.CreateKeyInput{})
+		if err != nil {
+			return err
+		}
+		emulators.kmsKey = aws.StringValue(key.KeyMetadata.KeyId)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error creating the kms key: %s", err.Error())
+	}
+
+	return m.Run(), nil
+}
+
+// keyStores are the key stores the cycle is tested with, each returns a new empty store
+var keyStores = []struct {
+	name string
+	new  func(t *testing.T) kv.Service
+}{
+	{"memory", func(t *testing.T) kv.Service { return kvtest.New() }},
+	{"s3", newS3},
+	{"gcs", newGCS},
+	{"awskms", func(t *testing.T) kv.Service {
+		store, err := awskms.NewWithSession(emulatorSession(emulators.kms), newS3(t), emulators.kmsKey)
+		if err != nil {
+			t.Fatalf("error creating the awskms key store: %s", err.Error())
+		}
+		return store
+	}},
+	{"k8s", newK8S},
+}
+
+func TestIntegration(t *testing.T) {
+	for _, keyStore := range keyStores {
+		for _, autoSeal := range []bool{false, true} {
+			keyStore, autoSeal := keyStore, autoSeal
+			seal := "shamir"
+			if autoSeal {
+				seal = "awskms"
+			}
+			t.Run(keyStore.name+"/"+seal, func(t *testing.T) {
+				testCycle(t, keyStore.new(t), autoSeal)
+			})
+		}
+	}
+}
+
+// testCycle initializes, unseals and configures a new Vault, then seals and unseals it again
+// with the stored keys
+func testCycle(t *testing.T, store kv.Service, autoSeal bool) {
+	client := startVault(t, autoSeal)
+
+	v, err := New(store, client, Config{SecretShares: 5, SecretThreshold: 3, StoreRootToken: true})
+	if err != nil {
+		t.Fatalf("error creating vault: %s", err.Error())
+	}
+
+	result, err := v.Init(context.Background())
+	if err != nil {
+		t.Fatalf("error initializing vault: %s", err.Error())
+	}
+	if autoSeal && len(result.RecoveryKeys) != 5 || !autoSeal && len(result.UnsealKeys) != 5 {
+		t.Fatalf("unexpected init result: %+v", result)
+	}
+
+	unseal := func() {
+		if autoSeal {
+			err = waitFor(time.Minute, func() error {
+				if sealed, err := v.Sealed(context.Background()); err != nil || sealed {
+					return fmt.Errorf("vault is still sealed: %v", err)
+				}
+				return nil
+			})
+		} else {
+			err = v.Unseal(context.Background())
+		}
+		if err != nil {
+			t.Fatalf("error unsealing vault: %s", err.Error())
+		}
+	}
+	unseal()
+
+	config := parseTestConfig(t, integrationConfig)
+
+	// Configure is idempotent
+	for i := 0; i < 2; i++ {
+		if err := v.Configure(config); err != nil {
+			t.Fatalf("error configuring vault: %s", err.Error())
+		}
+	}
+
+	rootToken, err := store.Get(RootTokenKey)
+	if err != nil {
+		t.Fatalf("error reading the root token: %s", err.Error())
+	}
+	client.SetToken(string(rootToken))
+	auths, err := client.Sys().ListAuth()
+	if err != nil || auths["people/"] == nil || auths["people/"].Type != "userpass" {
+		t.Errorf("the userpass auth method hasn't been enabled: %v, %v", auths, err)
+	}
+	rules, err := client.Sys().GetPolicy("allow_secrets")
+	if err != nil || !strings.Contains(rules, "secret/*") {
+		t.Errorf("the policy hasn't been written: %q, %v", rules, err)
+	}
+	mounts, err := client.Sys().ListMounts()
+	if err != nil || mounts["secret/"] == nil || mounts["secret/"].Options["version"] != "2" {
+		t.Errorf("the secret engine hasn't been mounted: %v, %v", mounts, err)
+	}
+	client.ClearToken()
+
+	if autoSeal {
+		return
+	}
+	if err := v.Seal(); err != nil {
+		t.Fatalf("error sealing vault: %s", err.Error())
+	}
+	unseal()
+}
+
+// startVault starts an uninitialized Vault with in-memory storage, and returns its client
+func startVault(t *testing.T, autoSeal bool) *api.Client {
+	config := `{"storage": {"inmem": {}}, "listener": {"tcp": {"address": "0.0.0.0:8200", "tls_disable": true}}, "disable_mlock": true`
+	if autoSeal {
+		// Vault reaches the emulator on the network of the containers
+		config += fmt.Sprintf(`, "seal": {"awskms": {"region": %q, "kms_key_id": %q, "endpoint": "http://kms:8080", "access_key": %q, "secret_key": %q}}`,
+			emulatorRegion, emulators.kmsKey, emulatorAccessKey, emulatorSecretKey)
+	}
+	config += "}"
+
+	image := os.Getenv("VAULT_IMAGE")
+	if image == "" {
+		image = defaultVaultImage
+	}
+	container, address, err := runContainer("", "8200", []string{"VAULT_LOCAL_CONFIG=" + config, "SKIP_SETCAP=true"}, image, "server")
+	if err != nil {
+		t.Fatalf("error starting vault: %s", err.Error())
+	}
+	t.Cleanup(func() {
+		if t.Failed() {
+			logs, _ := docker("logs", container)
+			t.Logf("vault logs:\n%s", logs)
+		}
+		docker("rm", "-f", container)
+	})
+
+	apiConfig := api.DefaultConfig()
+	apiConfig.Address = "http://" + address
+	client, err := api.NewClient(apiConfig)
+	if err != nil {
+		t.Fatalf("error creating the vault client: %s", err.Error())
+	}
+	client.ClearToken()
+
+	err = waitFor(time.Minute, func() error {
+		_, err := client.Sys().SealStatus()
+		return err
+	})
+	if err != nil {
+		t.Fatalf("vault hasn't started: %s", err.Error())
+	}
+	return client
+}
+
+func newS3(t *testing.T) kv.Service {
+	sess := emulatorSession(emulators.s3).Copy(aws.NewConfig().WithS3ForcePathStyle(true))
+	bucket := "bank-vaults-" + strings.ToLower(strings.Replace(t.Name(), "/", "-", -1))
+	err := waitFor(time.Minute, func() error {
+		_, err := awss3.New(sess).CreateBucket(&awss3.CreateBucketInput{Bucket: aws.String(bucket)})
+		return err
+	})
+	if err != nil {
+		t.Fatalf("error creating the s3 bucket: %s", err.Error())
+	}
+
+	store, err := s3.NewWithSession(sess, bucket, "vault/")
+	if err != nil {
+		t.Fatalf("error creating the s3 key store: %s", err.Error())
+	}
+	return store
+}
+
+func newGCS(t *testing.T) kv.Service {
+	// The client reads the objects from storage.googleapis.com, every request is sent to the emulator instead
+	httpClient := &http.Client{Transport: emulatorTransport(emulators.gcs)}
+	client, err := storage.NewClient(context.Background(), option.WithHTTPClient(httpClient))
+	if err != nil {
+		t.Fatalf("error creating the gcs client: %s", err.Error())
+	}
+
+	bucket := "bank-vaults-" + strings.ToLower(strings.Replace(t.Name(), "/", "-", -1))
+	err = waitFor(time.Minute, func() error {
+		return client.Bucket(bucket).Create(context.Background(), "bank-vaults", nil)
+	})
+	if err != nil {
+		t.Fatalf("error creating the gcs bucket: %s", err.Error())
+	}
+
+	store, err := gcs.NewWithClient(client, bucket, "vault/")
+	if err != nil {
+		t.Fatalf("error creating the gcs key store: %s", err.Error())
+	}
+	return store
+}
+
+func newK8S(t *testing.T) kv.Service {
+	if os.Getenv("KUBECONFIG") == "" {
+		t.Skip("KUBECONFIG isn't set")
+	}
+
+	secret := "bank-vaults-it-" + testID + "-" + strings.ToLower(strings.Replace(t.Name(), "/", "-", -1))
+	store, err := k8s.New("default", secret)
+	if err != nil {
+		t.Fatalf("error creating the k8s key store: %s", err.Error())
+	}
+	t.Cleanup(func() {
+		exec.Command("kubectl", "delete", "secret", "--namespace", "default", "--ignore-not-found", secret).Run()
+	})
+	return store
+}
+
+func emulatorSession(endpoint string) *session.Session {
+	return session.Must(session.NewSession(aws.NewConfig().
+		WithRegion(emulatorRegion).
+		WithEndpoint(endpoint).
+		WithCredentials(credentials.NewStaticCredentials(emulatorAccessKey, emulatorSecretKey, ""))))
+}
+
+type emulatorTransport string
+
+func (e emulatorTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Scheme = "http"
+	r.URL.Host = string(e)
+	r.Host = string(e)
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+// runContainer starts a container on the network of the tests with the given alias, and returns
+// its ID and the local address of the exposed port
+func runContainer(alias, port string, env []string, image string, args ...string) (string, string, error) {
+	runArgs := []string{"run", "--detach", "--network", emulators.network, "--publish", "127.0.0.1::" + port}
+	if alias != "" {
+		runArgs = append(runArgs, "--network-alias", alias)
+	}
+	for _, e := range env {
+		runArgs = append(runArgs, "--env", e)
+	}
+	runArgs = append(runArgs, image)
+	runArgs = append(runArgs, args...)
+
+	container, err := docker(runArgs...)
+	if err != nil {
+		return "", "", err
+	}
+	address, err := docker("port", container, port)
+	if err != nil {
+		docker("rm", "-f", container)
+		return "", "", err
+	}
+	// docker port may list an address per line
+	return container, strings.Split(address, "\n")[0], nil
+}
+
+func docker(args ...string) (string, error) {
+	output, err := exec.Command("docker", args...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("docker %s failed: %s: %s", args[0], err.Error(), output)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// waitFor calls f until it succeeds, or returns its last error after timeout
+func waitFor(timeout time.Duration, f func() error) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := f()
+		if err == nil || time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(time.Second)
+	}
+}
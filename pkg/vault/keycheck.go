@@ -0,0 +1,137 @@
+package vault
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/hashicorp/vault/api"
+)
+
+// KeysMetadataKey is the default name of the metadata of the stored keys in the key store
+const KeysMetadataKey = "vault-keys-metadata"
+
+// KeysMetadata is stored next to the keys by Init and Rekey, the keys read from the key store are
+// validated with it before they are sent to Vault
+type KeysMetadata struct {
+	// SealType is the type of the seal of the Vault cluster the keys have been created for
+	SealType string `json:"sealType"`
+	// SecretShares and SecretThreshold are of the stored keys, the recovery keys with an auto-unseal seal
+	SecretShares    int `json:"secretShares"`
+	SecretThreshold int `json:"secretThreshold"`
+	// Checksums are the hex encoded SHA-256 checksums of the keys by their key store IDs
+	Checksums map[string]string `json:"checksums"`
+	Created   time.Time         `json:"created"`
+	// Version is increased by every Rekey, the IDs of the keys are suffixed with it (see KeyNames)
+	Version int `json:"version,omitempty"`
+}
+
+// KeyValidationError is returned instead of sending the keys to Vault if they don't match their
+// metadata, e.g. because the key store has been corrupted, or it holds the keys of another cluster
+type KeyValidationError struct {
+	Reason string
+}
+
+func (e *KeyValidationError) Error() string {
+	return fmt.Sprintf("the key store is corrupted or holds the keys of another vault cluster: %s", e.Reason)
+}
+
+func newKeysMetadata(sealType string, shares, threshold int) *KeysMetadata {
+	return &KeysMetadata{
+		SealType:        sealType,
+		SecretShares:    shares,
+		SecretThreshold: threshold,
+		Checksums:       map[string]string{},
+		Created:         time.Now().UTC(),
+	}
+}
+
+func keyChecksum(key []byte) string {
+	checksum := sha256.Sum256(key)
+	return hex.EncodeToString(checksum[:])
+}
+
+// add records the checksum of a stored key
+func (m *KeysMetadata) add(keyID string, key []byte) {
+	m.Checksums[keyID] = keyChecksum(key)
+}
+
+// validateSealStatus checks that the keys have been created for a Vault with the given seal status
+func (m *KeysMetadata) validateSealStatus(status *api.SealStatusResponse) error {
+	if m == nil {
+		return nil
+	}
+	if m.SealType != "" && status.Type != "" && m.SealType != status.Type {
+		return &KeyValidationError{Reason: fmt.Sprintf("the keys have been created for a vault with the %s seal, but vault uses the %s seal", m.SealType, status.Type)}
+	}
+	if !status.RecoverySeal && (status.N != m.SecretShares || status.T != m.SecretThreshold) {
+		return &KeyValidationError{Reason: fmt.Sprintf("the keys have been created for %d shares with a threshold of %d, but vault has %d shares with a threshold of %d",
+			m.SecretShares, m.SecretThreshold, status.N, status.T)}
+	}
+	return nil
+}
+
+// validateKey checks the key read from the key store against its recorded checksum
+func (m *KeysMetadata) validateKey(keyID string, key []byte) error {
+	if m == nil {
+		return nil
+	}
+	checksum, ok := m.Checksums[keyID]
+	if !ok {
+		return &KeyValidationError{Reason: fmt.Sprintf("key '%s' hasn't been recorded in the metadata of the keys", keyID)}
+	}
+	if keyChecksum(key) != checksum {
+		return &KeyValidationError{Reason: fmt.Sprintf("key '%s' doesn't match the checksum recorded when it was stored", keyID)}
+	}
+	return nil
+}
+
+// KeysMetadata returns the metadata of the stored keys, nil if there is none
+func (v *vault) KeysMetadata() (*KeysMetadata, error) {
+	return v.keysMetadata()
+}
+
+// keysMetadata reads the metadata of the stored keys, nil if there is none (e.g. for keys stored
+// by earlier versions), the keys aren't validated in this case. The IDs of the keys are of the
+// version of the metadata afterwards.
+func (v *vault) keysMetadata() (*KeysMetadata, error) {
+	var value []byte
+	err := v.retryKeyStore(v.context(), fmt.Sprintf("getting key '%s'", v.keysMetadataKey()), func() (err error) {
+		value, err = v.keyStore.Get(v.keysMetadataKey())
+		return err
+	})
+	if _, ok := err.(*kv.NotFoundError); ok {
+		v.keysVersion = 0
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to get key '%s': %s", v.keysMetadataKey(), err.Error())
+	}
+
+	var metadata KeysMetadata
+	if err := json.Unmarshal(value, &metadata); err != nil {
+		return nil, &KeyValidationError{Reason: fmt.Sprintf("error parsing the metadata of the keys: %s", err.Error())}
+	}
+	v.keysVersion = metadata.Version
+	return &metadata, nil
+}
+
+// storeKeysMetadata stores the metadata of the keys, it is created like the keys by Init, and
+// replaced by Rekey
+func (v *vault) storeKeysMetadata(metadata *KeysMetadata, replace bool) error {
+	value, err := json.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("error marshalling the metadata of the keys: %s", err.Error())
+	}
+	if replace {
+		err = v.keyStore.Set(v.keysMetadataKey(), value)
+	} else {
+		err = v.keyStoreSet(v.keysMetadataKey(), value)
+	}
+	if err != nil {
+		return fmt.Errorf("error storing the metadata of the keys in key '%s': %s", v.keysMetadataKey(), err.Error())
+	}
+	return nil
+}
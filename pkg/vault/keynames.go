@@ -0,0 +1,221 @@
+package vault
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+)
+
+// The default names of the keys in the key store
+const (
+	DefaultUnsealKeyName    = "vault-unseal-{{.Index}}"
+	DefaultRecoveryKeyName  = "vault-recovery-{{.Index}}"
+	DefaultRootTokenKeyName = RootTokenKey
+	DefaultTestKeyName      = "vault-test"
+	DefaultKeysMetadataName = KeysMetadataKey
+
+	DefaultProvisionerTokenKeyName = "vault-configurer-token"
+)
+
+// KeyNamer names the keys in the key store, KeyNames implements it with templates. Custom schemes
+// (e.g. names looked up from a table of another tool) can be set as the KeyNamer of the Config, the
+// names of the unseal and recovery keys are suffixed with the version of the keys by the vault
+// package then, like the ones of KeyNames.
+type KeyNamer interface {
+	UnsealKeyName(i int) string
+	RecoveryKeyName(i int) string
+	RootTokenName() string
+	TestKeyName() string
+	KeysMetadataName() string
+}
+
+var _ KeyNamer = KeyNames{}
+
+// KeyNames are the names of the keys in the key store, as text/template templates with the Cluster
+// field and (in the names of the unseal and recovery keys) the Index field, e.g. "{{.Cluster}}-unseal-{{.Index}}".
+// The empty names are the defaults, so several Vault clusters can share a key store with their own
+// names, and the keys stored by other tools can be used or migrated.
+type KeyNames struct {
+	// Cluster is the value of the Cluster field of the templates
+	Cluster string
+	// Prefix is prepended to every name, e.g. "prod-eu/" stores the keys as prod-eu/vault-unseal-0,
+	// etc., so several Vault clusters can share a key store (a KMS key and a bucket) with the default
+	// names
+	Prefix string
+
+	UnsealKey    string
+	RecoveryKey  string
+	RootToken    string
+	Test         string
+	KeysMetadata string
+	// ProvisionerToken is the name of the token of the vault-configurer policy, see
+	// Config.ProvisionerToken
+	ProvisionerToken string
+
+	// Version is the version of the unseal and recovery keys, increased by every Rekey, their names
+	// are suffixed with -v<Version> above 0, e.g. vault-unseal-0-v2. The vault package reads it from
+	// the metadata of the keys.
+	Version int
+}
+
+// keyNameData is the data of the key name templates
+type keyNameData struct {
+	Cluster string
+	Index   int
+}
+
+// Validate checks the templates of the names, and that the names of different keys don't collide
+func (n KeyNames) Validate() error {
+	// Two unseal and recovery keys are rendered, so a name without the Index is found
+	keys := []struct {
+		kind        string
+		name        string
+		defaultName string
+		index       int
+	}{
+		{"root token", n.RootToken, DefaultRootTokenKeyName, 0},
+		{"test key", n.Test, DefaultTestKeyName, 0},
+		{"keys metadata", n.KeysMetadata, DefaultKeysMetadataName, 0},
+		{"provisioner token", n.ProvisionerToken, DefaultProvisionerTokenKeyName, 0},
+		{"unseal key 0", n.UnsealKey, DefaultUnsealKeyName, 0},
+		{"unseal key 1", n.UnsealKey, DefaultUnsealKeyName, 1},
+		{"recovery key 0", n.RecoveryKey, DefaultRecoveryKeyName, 0},
+		{"recovery key 1", n.RecoveryKey, DefaultRecoveryKeyName, 1},
+	}
+
+	names := map[string]string{}
+	for _, key := range keys {
+		rendered, err := n.render(key.name, key.defaultName, key.index)
+		if err != nil {
+			return fmt.Errorf("invalid name of the %s: %s", key.kind, err.Error())
+		}
+		if err := addKeyName(names, key.kind, rendered); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateKeyNamer checks that the names of different keys of a custom KeyNamer aren't empty and
+// don't collide
+func ValidateKeyNamer(namer KeyNamer) error {
+	keys := []struct {
+		kind string
+		name string
+	}{
+		{"root token", namer.RootTokenName()},
+		{"test key", namer.TestKeyName()},
+		{"keys metadata", namer.KeysMetadataName()},
+		{"unseal key 0", namer.UnsealKeyName(0)},
+		{"unseal key 1", namer.UnsealKeyName(1)},
+		{"recovery key 0", namer.RecoveryKeyName(0)},
+		{"recovery key 1", namer.RecoveryKeyName(1)},
+	}
+
+	names := map[string]string{}
+	for _, key := range keys {
+		if err := addKeyName(names, key.kind, key.name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addKeyName adds the name of the kind of key to names, unless it is empty or taken by another key
+func addKeyName(names map[string]string, kind, name string) error {
+	if name == "" {
+		return fmt.Errorf("invalid name of the %s: it is empty", kind)
+	}
+	if other, ok := names[name]; ok {
+		return fmt.Errorf("the %s and the %s have the same name: %s", kind, other, name)
+	}
+	names[name] = kind
+	return nil
+}
+
+// UnsealKeyName returns the name of the i-th unseal key
+func (n KeyNames) UnsealKeyName(i int) string {
+	return n.versioned(n.mustRender(n.UnsealKey, DefaultUnsealKeyName, i))
+}
+
+// RecoveryKeyName returns the name of the i-th recovery key
+func (n KeyNames) RecoveryKeyName(i int) string {
+	return n.versioned(n.mustRender(n.RecoveryKey, DefaultRecoveryKeyName, i))
+}
+
+// versioned suffixes the name of an unseal or recovery key with the version of the keys
+func (n KeyNames) versioned(name string) string {
+	return versionedKeyName(name, n.Version)
+}
+
+// versionedKeyName suffixes the name of an unseal or recovery key with the version of the keys
+func versionedKeyName(name string, version int) string {
+	if version == 0 {
+		return name
+	}
+	return fmt.Sprintf("%s-v%d", name, version)
+}
+
+// versionedKeyNamer is a custom KeyNamer whose unseal and recovery keys are of a version
+type versionedKeyNamer struct {
+	KeyNamer
+	version int
+}
+
+func (n versionedKeyNamer) UnsealKeyName(i int) string {
+	return versionedKeyName(n.KeyNamer.UnsealKeyName(i), n.version)
+}
+
+func (n versionedKeyNamer) RecoveryKeyName(i int) string {
+	return versionedKeyName(n.KeyNamer.RecoveryKeyName(i), n.version)
+}
+
+// RootTokenName returns the name of the root token
+func (n KeyNames) RootTokenName() string {
+	return n.mustRender(n.RootToken, DefaultRootTokenKeyName, 0)
+}
+
+// TestKeyName returns the name of the key written to test the key store before the initialization
+func (n KeyNames) TestKeyName() string {
+	return n.mustRender(n.Test, DefaultTestKeyName, 0)
+}
+
+// KeysMetadataName returns the name of the metadata of the stored keys
+func (n KeyNames) KeysMetadataName() string {
+	return n.mustRender(n.KeysMetadata, DefaultKeysMetadataName, 0)
+}
+
+// ProvisionerTokenName returns the name of the provisioner token Configure uses instead of the root token
+func (n KeyNames) ProvisionerTokenName() string {
+	return n.mustRender(n.ProvisionerToken, DefaultProvisionerTokenKeyName, 0)
+}
+
+func (n KeyNames) render(name, defaultName string, index int) (string, error) {
+	if name == "" {
+		name = defaultName
+	}
+	tmpl, err := template.New("key").Option("missingkey=error").Parse(name)
+	if err != nil {
+		return "", err
+	}
+	var buffer bytes.Buffer
+	if err := tmpl.Execute(&buffer, keyNameData{Cluster: n.Cluster, Index: index}); err != nil {
+		return "", err
+	}
+	if buffer.Len() == 0 {
+		return "", nil
+	}
+	return n.Prefix + buffer.String(), nil
+}
+
+// mustRender renders a name checked by Validate, the template is used as the name if it is invalid
+func (n KeyNames) mustRender(name, defaultName string, index int) string {
+	rendered, err := n.render(name, defaultName, index)
+	if err != nil {
+		if name == "" {
+			return n.Prefix + defaultName
+		}
+		return n.Prefix + name
+	}
+	return rendered
+}
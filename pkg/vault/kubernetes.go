@@ -0,0 +1,76 @@
+package vault
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// kubernetesServiceAccountDir is the directory of the token and the CA certificate of the
+// ServiceAccount of the Pod, it is on the system drive on Windows nodes
+const kubernetesServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// KubernetesAuthConfig is a Kubernetes cluster the Kubernetes auth methods are configured with from
+// outside of it, the empty fields are left to Vault (e.g. without a TokenReviewerJWT Vault reviews
+// the tokens of the logins with themselves)
+type KubernetesAuthConfig struct {
+	// Host is the URL of the API server, e.g. https://192.168.99.100:8443
+	Host string
+	// CACert is the PEM encoded CA certificate of the API server
+	CACert string
+	// TokenReviewerJWT is the token Vault reviews the tokens of the logins with
+	TokenReviewerJWT string
+}
+
+// kubernetesAuthConfig writes the config of the Kubernetes auth method at path. The fields of config
+// take precedence over Config.KubernetesAuth, which takes precedence over the cluster bank-vaults runs
+// in: its KUBERNETES_SERVICE_HOST, and the CA certificate and the token (or TokenReviewerJWTFile) of
+// the ServiceAccount of the Pod, which are only used if the host is the one of the cluster.
+func (v *vault) kubernetesAuthConfig(path string, config map[string]interface{}) error {
+	authConfig := map[string]interface{}{}
+	for field, value := range config {
+		authConfig[field] = value
+	}
+	setDefault := func(field, value string) {
+		if _, ok := authConfig[field]; !ok && value != "" {
+			authConfig[field] = value
+		}
+	}
+	setDefaultFromFile := func(field, file string) error {
+		if _, ok := authConfig[field]; ok || file == "" {
+			return nil
+		}
+		value, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		authConfig[field] = string(value)
+		return nil
+	}
+
+	if outside := v.config.KubernetesAuth; outside != nil {
+		setDefault("kubernetes_host", outside.Host)
+		setDefault("kubernetes_ca_cert", outside.CACert)
+		setDefault("token_reviewer_jwt", outside.TokenReviewerJWT)
+	}
+	if err := setDefaultFromFile("token_reviewer_jwt", v.config.TokenReviewerJWTFile); err != nil {
+		return err
+	}
+
+	if _, ok := authConfig["kubernetes_host"]; !ok {
+		host := os.Getenv("KUBERNETES_SERVICE_HOST")
+		if host == "" {
+			return fmt.Errorf("kubernetes_host has to be set in the config of the auth method (or with the out-of-cluster settings) outside of a Kubernetes cluster")
+		}
+		authConfig["kubernetes_host"] = "https://" + host
+		if err := setDefaultFromFile("kubernetes_ca_cert", filepath.Join(kubernetesServiceAccountDir, "ca.crt")); err != nil {
+			return err
+		}
+		if err := setDefaultFromFile("token_reviewer_jwt", filepath.Join(kubernetesServiceAccountDir, "token")); err != nil {
+			return err
+		}
+	}
+
+	return v.retryWrite(fmt.Sprintf("auth/%s/config", path), authConfig)
+}
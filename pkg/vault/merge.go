@@ -0,0 +1,150 @@
+package vault
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cast"
+)
+
+// configFileExtensions are the extensions of the configuration files read from a directory
+var configFileExtensions = map[string]bool{".yml": true, ".yaml": true, ".json": true, ".hcl": true}
+
+// ConfigFragment is a part of the external configuration, e.g. the settings read from one file of
+// a directory of configuration files
+type ConfigFragment struct {
+	// Source names the fragment in the errors, e.g. the name of the file
+	Source   string
+	Settings map[string]interface{}
+}
+
+// ConfigFiles returns the configuration files of the paths in order, the YAML/JSON/HCL files of the
+// directories (by their extensions, sorted by name) in place of them. The hidden files and the
+// subdirectories are skipped, e.g. the ..data directory of a mounted Kubernetes ConfigMap.
+func ConfigFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading vault config: %s", err.Error())
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		entries, err := ioutil.ReadDir(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading vault config directory: %s", err.Error())
+		}
+		var names []string
+		for _, entry := range entries {
+			name := entry.Name()
+			if strings.HasPrefix(name, ".") || !configFileExtensions[filepath.Ext(name)] {
+				continue
+			}
+			// The files of a ConfigMap are symlinks, they are followed
+			if info, err := os.Stat(filepath.Join(path, name)); err != nil || info.IsDir() {
+				continue
+			}
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			files = append(files, filepath.Join(path, name))
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no vault config files found in %s", strings.Join(paths, ", "))
+	}
+	return files, nil
+}
+
+// MergeConfigFragments merges the sections of the fragments in order: the items of the list
+// sections (e.g. policies, auth, secrets) are concatenated, the fields of the object sections are
+// merged, and the other values have to be the same. An item defined by two fragments (e.g. policies
+// of the same name, or auth methods of the same path) is a conflict, it is reported with both
+// sources, so the fragments of different teams can't override each other silently.
+func MergeConfigFragments(fragments []ConfigFragment) (map[string]interface{}, error) {
+	merged := map[string]interface{}{}
+	// the sources of the merged sections, items and fields
+	sources := map[string]string{}
+
+	for _, fragment := range fragments {
+		sections := make([]string, 0, len(fragment.Settings))
+		for section := range fragment.Settings {
+			sections = append(sections, section)
+		}
+		sort.Strings(sections)
+
+		for _, section := range sections {
+			value := fragment.Settings[section]
+			existing, exists := merged[section]
+
+			switch value := value.(type) {
+			case []interface{}:
+				items, ok := existing.([]interface{})
+				if exists && !ok {
+					return nil, fmt.Errorf("%s: the %s section is a list, but not in %s", fragment.Source, section, sources[section])
+				}
+				for _, item := range value {
+					if key := configItemKey(section, item); key != "" {
+						id := section + "/" + key
+						if source, ok := sources[id]; ok {
+							return nil, fmt.Errorf("%s: %s %s is defined by %s already", fragment.Source, section, key, source)
+						}
+						sources[id] = fragment.Source
+					}
+					items = append(items, item)
+				}
+				merged[section] = items
+			case map[string]interface{}:
+				fields, ok := existing.(map[string]interface{})
+				if exists && !ok {
+					return nil, fmt.Errorf("%s: the %s section is an object, but not in %s", fragment.Source, section, sources[section])
+				}
+				if fields == nil {
+					fields = map[string]interface{}{}
+				}
+				for field, fieldValue := range value {
+					id := section + "." + field
+					if source, ok := sources[id]; ok {
+						return nil, fmt.Errorf("%s: %s is defined by %s already", fragment.Source, id, source)
+					}
+					sources[id] = fragment.Source
+					fields[field] = fieldValue
+				}
+				merged[section] = fields
+			default:
+				if exists && !reflect.DeepEqual(existing, value) {
+					return nil, fmt.Errorf("%s: %s is %v, but %v in %s", fragment.Source, section, value, existing, sources[section])
+				}
+				merged[section] = value
+			}
+			if !exists {
+				sources[section] = fragment.Source
+			}
+		}
+	}
+	return merged, nil
+}
+
+// configItemKey returns what identifies an item of a list section: the path of the auth methods,
+// secret engines and audit devices (their type by default), the name of the other items (e.g.
+// policies), empty if it has none
+func configItemKey(section string, item interface{}) string {
+	fields := cast.ToStringMap(item)
+	if path := strings.Trim(cast.ToString(fields["path"]), "/"); path != "" {
+		return path
+	}
+	switch section {
+	case "auth", "secrets", "audit":
+		return cast.ToString(fields["type"])
+	}
+	return cast.ToString(fields["name"])
+}
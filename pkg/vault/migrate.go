@@ -0,0 +1,148 @@
+package vault
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/banzaicloud/bank-vaults/pkg/logging"
+	"github.com/banzaicloud/bank-vaults/pkg/securemem"
+)
+
+// StoredKey is a key in the key store
+type StoredKey struct {
+	ID    string
+	Value []byte
+}
+
+// WipeKeys overwrites the values of the keys with zeroes, once they aren't needed anymore
+func WipeKeys(keys []StoredKey) {
+	for _, key := range keys {
+		securemem.Wipe(key.Value)
+	}
+}
+
+// StoredKeys reads the root token, the metadata of the keys, the unseal keys and the recovery keys
+// with the given names from the key store, the values should be wiped with WipeKeys after use
+func StoredKeys(store kv.Service, names KeyNames) ([]StoredKey, error) {
+	keys, _, err := storedKeysWithNames(store, names, names)
+	return keys, err
+}
+
+// storedKeysWithNames reads the stored keys like StoredKeys, and returns their names in the other
+// set of names as well
+func storedKeysWithNames(store kv.Service, names, otherNames KeyNames) ([]StoredKey, []string, error) {
+	for _, names := range []KeyNames{names, otherNames} {
+		if err := names.Validate(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	v := &vault{keyStore: store, config: &Config{KeyNames: names}}
+	// The keys keep their version in the other set of names
+	if _, err := v.keysMetadata(); err != nil {
+		if _, ok := err.(*KeyValidationError); !ok {
+			return nil, nil, err
+		}
+	}
+	other := &vault{config: &Config{KeyNames: otherNames}, keysVersion: v.keysVersion}
+
+	keys := []StoredKey{}
+	otherIDs := []string{}
+
+	for _, ids := range [][2]string{{v.rootTokenKey(), other.rootTokenKey()}, {v.keysMetadataKey(), other.keysMetadataKey()}} {
+		value, err := store.Get(ids[0])
+		if err == nil {
+			keys = append(keys, StoredKey{ID: ids[0], Value: value})
+			otherIDs = append(otherIDs, ids[1])
+		} else if _, ok := err.(*kv.NotFoundError); !ok {
+			WipeKeys(keys)
+			return nil, nil, fmt.Errorf("unable to get key '%s': %s", ids[0], err.Error())
+		}
+	}
+
+	for _, keysForID := range [][2]func(int) string{{v.unsealKeyForID, other.unsealKeyForID}, {v.recoveryKeyForID, other.recoveryKeyForID}} {
+		stored, err := v.storedKeys(keysForID[0])
+		if err != nil {
+			WipeKeys(keys)
+			return nil, nil, err
+		}
+		for i, key := range stored {
+			keys = append(keys, StoredKey{ID: keysForID[0](i), Value: key})
+			otherIDs = append(otherIDs, keysForID[1](i))
+		}
+	}
+
+	return keys, otherIDs, nil
+}
+
+// MigrateKeys copies the root token, the unseal keys, the recovery keys and their metadata from the source key
+// store to the destination, re-encrypting them with the destination's encryption if it has one. The keys
+// are read with the source names and stored with the destination names (e.g. to migrate the keys stored
+// by another tool), the metadata of the keys is updated with the new names.
+// Every copied key is read back from the destination and compared with the source before the
+// IDs of the copied keys in the source are returned.
+func MigrateKeys(source, destination kv.Service, sourceNames, destinationNames KeyNames) ([]string, error) {
+	keys, destinationIDs, err := storedKeysWithNames(source, sourceNames, destinationNames)
+	if err != nil {
+		return nil, err
+	}
+	defer WipeKeys(keys)
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no keys found in the source key store")
+	}
+
+	renamed := map[string]string{}
+	for i, key := range keys {
+		renamed[key.ID] = destinationIDs[i]
+	}
+
+	ids := []string{}
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		values[i] = key.Value
+		if key.ID == sourceNames.KeysMetadataName() && sourceNames != destinationNames {
+			if values[i], err = renameKeysMetadata(key.Value, renamed); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := destination.Set(destinationIDs[i], values[i]); err != nil {
+			return nil, fmt.Errorf("error storing key '%s' in the destination key store: %s", destinationIDs[i], err.Error())
+		}
+		logging.Default().WithField("key", key.ID).WithField("destinationKey", destinationIDs[i]).Infof("key copied to the destination key store")
+		ids = append(ids, key.ID)
+	}
+
+	for i := range keys {
+		value, err := destination.Get(destinationIDs[i])
+		if err != nil {
+			return nil, fmt.Errorf("error verifying key '%s' in the destination key store: %s", destinationIDs[i], err.Error())
+		}
+		equal := bytes.Equal(value, values[i])
+		securemem.Wipe(value)
+		if !equal {
+			return nil, fmt.Errorf("error verifying key '%s' in the destination key store: the value doesn't match", destinationIDs[i])
+		}
+	}
+
+	return ids, nil
+}
+
+// renameKeysMetadata replaces the IDs of the keys in their metadata
+func renameKeysMetadata(value []byte, renamed map[string]string) ([]byte, error) {
+	var metadata KeysMetadata
+	if err := json.Unmarshal(value, &metadata); err != nil {
+		return nil, fmt.Errorf("error parsing the metadata of the keys: %s", err.Error())
+	}
+	checksums := map[string]string{}
+	for id, checksum := range metadata.Checksums {
+		if newID, ok := renamed[id]; ok {
+			id = newID
+		}
+		checksums[id] = checksum
+	}
+	metadata.Checksums = checksums
+	return json.Marshal(metadata)
+}
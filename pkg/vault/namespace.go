@@ -0,0 +1,185 @@
+package vault
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/spf13/cast"
+)
+
+// namespaceField is the Vault Enterprise namespace of an auth method, a policy, a secret engine or
+// a startup secret, it overrides the namespace of the configuration
+const namespaceField = "namespace"
+
+// namespaceGroup is the items of a section which are applied in the same namespace, in the order of
+// the section
+type namespaceGroup struct {
+	namespace string
+	items     []int
+}
+
+// namespaceOf returns the namespace an item is applied in: the value of its namespace field, or the
+// namespace of the configuration, empty for the root namespace
+func (c *ExternalConfig) namespaceOf(namespace interface{}) string {
+	if namespace := strings.Trim(cast.ToString(namespace), "/"); namespace != "" {
+		return namespace
+	}
+	return strings.Trim(c.Namespace, "/")
+}
+
+// groupByNamespace groups the items 0..count-1 of a section by the namespaces returned by namespace,
+// in the order of their first items, so the configuration without namespaces is a single group
+func (c *ExternalConfig) groupByNamespace(count int, namespace func(i int) interface{}) []namespaceGroup {
+	var groups []namespaceGroup
+	index := map[string]int{}
+	for i := 0; i < count; i++ {
+		ns := c.namespaceOf(namespace(i))
+		j, ok := index[ns]
+		if !ok {
+			j = len(groups)
+			index[ns] = j
+			groups = append(groups, namespaceGroup{namespace: ns})
+		}
+		groups[j].items = append(groups[j].items, i)
+	}
+	return groups
+}
+
+// namespaces returns the namespaces the configuration is applied in (the identity section is applied
+// in the namespace of the configuration), except for the root namespace, sorted so the parents
+// precede their children
+func (c *ExternalConfig) namespaces() []string {
+	found := map[string]bool{}
+	add := func(namespace interface{}) {
+		if ns := c.namespaceOf(namespace); ns != "" {
+			found[ns] = true
+		}
+	}
+	for _, item := range c.Auth {
+		add(item[namespaceField])
+	}
+	for _, item := range c.Policies {
+		add(item[namespaceField])
+	}
+	for _, item := range c.Secrets {
+		add(item[namespaceField])
+	}
+	for _, item := range c.StartupSecrets {
+		add(item[namespaceField])
+	}
+	if len(c.Identity) > 0 {
+		add(nil)
+	}
+
+	namespaces := make([]string, 0, len(found))
+	for ns := range found {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+	return namespaces
+}
+
+// namespaced returns a copy of v which sends its requests to the namespace with a client of its own
+// (with the token of v), or v itself for the root namespace
+func (v *vault) namespaced(namespace string) (*vault, error) {
+	if namespace == "" {
+		return v, nil
+	}
+	cl, err := v.cl.Clone()
+	if err != nil {
+		return nil, fmt.Errorf("error creating vault client for namespace %s: %s", namespace, err.Error())
+	}
+	cl.SetToken(v.cl.Token())
+	cl.SetHeaders(http.Header{NamespaceHeader: []string{namespace}})
+
+	namespaced := *v
+	namespaced.cl = cl
+	namespaced.namespace = namespace
+	return &namespaced, nil
+}
+
+// inNamespaces calls apply for the groups of items with the vault of their namespaces, the resources
+// applied in a namespace are recorded in the report with it
+func (v *vault) inNamespaces(groups []namespaceGroup, report *ConfigureReport, apply func(v *vault, items []int, report *ConfigureReport) error) error {
+	for _, group := range groups {
+		if group.namespace == "" {
+			if err := apply(v, group.items, report); err != nil {
+				return err
+			}
+			continue
+		}
+
+		nv, err := v.namespaced(group.namespace)
+		if err != nil {
+			return err
+		}
+		groupReport := &ConfigureReport{}
+		err = apply(nv, group.items, groupReport)
+		for _, result := range groupReport.Resources {
+			result.Namespace = group.namespace
+			report.Resources = append(report.Resources, result)
+		}
+		for path, publicKey := range groupReport.SSHCAPublicKeys {
+			report.addSSHCAPublicKey(group.namespace+"/"+path, publicKey)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// configureNamespaces creates the namespaces of the configuration which don't exist yet, with their
+// parents, every outcome is recorded in the report. The resources of a namespace which couldn't be
+// created fail on their own.
+func (v *vault) configureNamespaces(namespaces []string, report *ConfigureReport) error {
+	checked := map[string]bool{}
+	for _, namespace := range namespaces {
+		names := strings.Split(namespace, "/")
+		for i := range names {
+			path := strings.Join(names[:i+1], "/")
+			if checked[path] {
+				continue
+			}
+			checked[path] = true
+
+			parent, err := v.namespaced(strings.Join(names[:i], "/"))
+			if err != nil {
+				return err
+			}
+			parent.configureNamespace(path, names[i], report)
+		}
+	}
+	return nil
+}
+
+// configureNamespace creates the child namespace of the namespace of v if it doesn't exist, path is
+// the full path of the child
+func (v *vault) configureNamespace(path, name string, report *ConfigureReport) {
+	started := time.Now()
+	var existing *api.Secret
+	err := v.retry(fmt.Sprintf("reading namespace %s", path), func() (err error) {
+		existing, err = v.cl.Logical().Read("sys/namespaces/" + name)
+		return err
+	})
+	if err != nil {
+		report.add(ResourceNamespace, path, false, started, fmt.Errorf("error reading namespace %s: %s", path, err.Error()))
+		return
+	}
+	if existing != nil {
+		v.logger().Debugf("namespace %s exists already", path)
+		report.skip(ResourceNamespace, path, started)
+		return
+	}
+
+	v.logger().Infof("creating namespace %s", path)
+	err = v.retryWrite("sys/namespaces/"+name, map[string]interface{}{})
+	if err != nil {
+		err = fmt.Errorf("error creating namespace %s: %s", path, err.Error())
+	}
+	report.add(ResourceNamespace, path, false, started, err)
+}
@@ -0,0 +1,154 @@
+package vault
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cast"
+)
+
+// pkiField is the section of a pki secret engine describing its CA, URLs and roles, which can't be
+// expressed by the generic configuration as the CA is set up in several dependent steps
+const pkiField = "pki"
+
+// The types of the CA of a pki secret engine
+const (
+	pkiCARoot         = "root"
+	pkiCAIntermediate = "intermediate"
+)
+
+// pkiCAFields are the fields of the ca of the pki section which aren't parameters of Vault
+var pkiCAFields = []string{"type", "pem_bundle", "signed_by"}
+
+// configurePKI sets up the CA of the pki secret engine at path if it has none yet, then writes its
+// URLs and roles, the outcome of every step is recorded in the report
+func (v *vault) configurePKI(path string, pki map[string]interface{}, report *ConfigureReport) {
+	if ca := cast.ToStringMap(pki["ca"]); len(ca) > 0 {
+		v.configurePKICA(path, ca, report)
+	}
+
+	if urls, ok := pki["urls"]; ok {
+		started := time.Now()
+		urlsPath := path + "/config/urls"
+		err := v.retryWrite(urlsPath, cast.ToStringMap(urls))
+		if err != nil {
+			err = fmt.Errorf("error putting %s into vault: %s", urlsPath, err.Error())
+		}
+		report.add(ResourceSecretEngineConfig, urlsPath, true, started, err)
+	}
+
+	for _, roleData := range cast.ToSlice(pki["roles"]) {
+		started := time.Now()
+		role := cast.ToStringMap(roleData)
+		rolePath := fmt.Sprintf("%s/roles/%s", path, role["name"])
+		role, existed, skip := v.existingResource(ResourceSecretEngineConfig, rolePath, role, started, report)
+		if skip {
+			continue
+		}
+		err := v.retryWrite(rolePath, role)
+		if err != nil {
+			err = fmt.Errorf("error putting %s pki role into vault: %s", role["name"], err.Error())
+		}
+		report.add(ResourceSecretEngineConfig, rolePath, existed, started, err)
+	}
+}
+
+// configurePKICA generates or imports the CA of the pki secret engine at path, unless it has one
+// already. A root CA is imported from its pem_bundle, or generated with an internal key. An
+// intermediate CA is imported from its pem_bundle, or generated with an internal key and signed by
+// the root CA of the pki secret engine at signed_by, which has to be configured before it.
+func (v *vault) configurePKICA(path string, ca map[string]interface{}, report *ConfigureReport) {
+	started := time.Now()
+	caPath := path + "/cert/ca"
+
+	exists, err := v.pkiCAExists(path)
+	if err != nil {
+		report.add(ResourceSecretEngineConfig, caPath, false, started, err)
+		return
+	}
+	if exists {
+		v.logger().Debugf("%s has a CA already", path)
+		report.skip(ResourceSecretEngineConfig, caPath, started)
+		return
+	}
+
+	params := ca
+	for _, field := range pkiCAFields {
+		params = withoutField(params, field)
+	}
+
+	if bundle := getOrDefault(ca, "pem_bundle"); bundle != "" {
+		v.logger().Infof("importing the CA of %s", path)
+		err = v.retryWrite(path+"/config/ca", map[string]interface{}{"pem_bundle": bundle})
+	} else if getOrDefault(ca, "type") == pkiCAIntermediate {
+		v.logger().Infof("generating the intermediate CA of %s signed by %s", path, ca["signed_by"])
+		err = v.generatePKIIntermediate(path, getOrDefault(ca, "signed_by"), params)
+	} else {
+		v.logger().Infof("generating the root CA of %s", path)
+		err = v.retryWrite(path+"/root/generate/internal", params)
+	}
+	if err != nil {
+		err = fmt.Errorf("error setting up the CA of %s: %s", path, err.Error())
+	}
+	report.add(ResourceSecretEngineConfig, caPath, false, started, err)
+}
+
+// pkiCAExists returns true if the pki secret engine at path has a CA certificate
+func (v *vault) pkiCAExists(path string) (bool, error) {
+	var certificate string
+	err := v.retry(fmt.Sprintf("reading the CA of %s", path), func() error {
+		secret, err := v.cl.Logical().Read(path + "/cert/ca")
+		if err != nil {
+			return err
+		}
+		if secret != nil && secret.Data != nil {
+			certificate = cast.ToString(secret.Data["certificate"])
+		}
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("error reading the CA of %s: %s", path, err.Error())
+	}
+	return certificate != "", nil
+}
+
+// generatePKIIntermediate generates the CSR of the intermediate CA of the pki secret engine at
+// path, signs it with the root CA at rootPath, and sets the signed certificate
+func (v *vault) generatePKIIntermediate(path, rootPath string, params map[string]interface{}) error {
+	var csr string
+	err := v.retry(fmt.Sprintf("generating the intermediate CSR of %s", path), func() error {
+		secret, err := v.cl.Logical().Write(path+"/intermediate/generate/internal", params)
+		if err != nil {
+			return err
+		}
+		if secret == nil || secret.Data == nil {
+			return fmt.Errorf("no CSR in the response")
+		}
+		csr = cast.ToString(secret.Data["csr"])
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error generating the intermediate CSR: %s", err.Error())
+	}
+
+	sign := withoutField(params, "csr")
+	sign["csr"] = csr
+	sign["format"] = "pem_bundle"
+	var certificate string
+	err = v.retry(fmt.Sprintf("signing the intermediate CA of %s with %s", path, rootPath), func() error {
+		secret, err := v.cl.Logical().Write(rootPath+"/root/sign-intermediate", sign)
+		if err != nil {
+			return err
+		}
+		if secret == nil || secret.Data == nil {
+			return fmt.Errorf("no certificate in the response")
+		}
+		certificate = cast.ToString(secret.Data["certificate"])
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error signing the intermediate CA with %s: %s", rootPath, err.Error())
+	}
+
+	return v.retryWrite(path+"/intermediate/set-signed", map[string]interface{}{"certificate": certificate})
+}
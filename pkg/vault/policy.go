@@ -0,0 +1,125 @@
+package vault
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl"
+	"github.com/hashicorp/hcl/hcl/ast"
+	"github.com/hashicorp/hcl/hcl/printer"
+	"github.com/hashicorp/hcl/hcl/token"
+)
+
+// policyCapabilityOrder is the canonical order of the capabilities of a path rule
+var policyCapabilityOrder = []string{"create", "read", "update", "delete", "list", "sudo", "deny"}
+
+// LintPolicy returns the problems of the HCL rules of a policy: the errors reported by the
+// configuration verification, paths defined more than once (Vault merges them) and paths
+// covered by the glob path of another rule (the longest match wins, the other rule is ignored)
+func LintPolicy(rules string) []string {
+	problems := verifyPolicyRules(rules)
+	if len(problems) > 0 {
+		return problems
+	}
+
+	file, _ := hcl.ParseString(rules)
+	paths := []string{}
+	seen := map[string]bool{}
+	for _, item := range file.Node.(*ast.ObjectList).Items {
+		path := item.Keys[1].Token.Value().(string)
+		if seen[path] {
+			problems = append(problems, fmt.Sprintf("path %s is defined more than once", path))
+			continue
+		}
+		seen[path] = true
+		paths = append(paths, path)
+	}
+
+	for _, glob := range paths {
+		if !strings.HasSuffix(glob, "*") {
+			continue
+		}
+		prefix := strings.TrimSuffix(glob, "*")
+		for _, path := range paths {
+			if path != glob && strings.HasPrefix(path, prefix) {
+				problems = append(problems, fmt.Sprintf("path %s overlaps %s, the rule of %s doesn't apply to it", path, glob, glob))
+			}
+		}
+	}
+	return problems
+}
+
+// FormatPolicy formats the HCL rules of a policy canonically: the HCL is reformatted and
+// the capabilities of every path are deduplicated and sorted
+func FormatPolicy(rules string) (string, error) {
+	file, err := hcl.ParseString(rules)
+	if err != nil {
+		return "", fmt.Errorf("invalid policy: %s", err.Error())
+	}
+
+	order := map[string]int{}
+	for i, capability := range policyCapabilityOrder {
+		order[capability] = i
+	}
+	capabilityRank := func(node ast.Node) (int, string) {
+		literal, ok := node.(*ast.LiteralType)
+		if !ok || literal.Token.Type != token.STRING {
+			return len(order), ""
+		}
+		capability, _ := literal.Token.Value().(string)
+		if rank, ok := order[capability]; ok {
+			return rank, capability
+		}
+		return len(order), capability
+	}
+
+	if list, ok := file.Node.(*ast.ObjectList); ok {
+		for _, item := range list.Items {
+			object, ok := item.Val.(*ast.ObjectType)
+			if !ok {
+				continue
+			}
+			for _, field := range object.List.Items {
+				capabilities, ok := field.Val.(*ast.ListType)
+				if !ok || len(field.Keys) != 1 || field.Keys[0].Token.Value() != "capabilities" {
+					continue
+				}
+				sort.SliceStable(capabilities.List, func(i, j int) bool {
+					rankI, nameI := capabilityRank(capabilities.List[i])
+					rankJ, nameJ := capabilityRank(capabilities.List[j])
+					return rankI < rankJ || (rankI == rankJ && nameI < nameJ)
+				})
+				unique := []ast.Node{}
+				for i, node := range capabilities.List {
+					if _, name := capabilityRank(node); i > 0 && name != "" {
+						if _, previous := capabilityRank(capabilities.List[i-1]); name == previous {
+							continue
+						}
+					}
+					unique = append(unique, node)
+				}
+				capabilities.List = unique
+			}
+		}
+	}
+
+	var buffer bytes.Buffer
+	if err := printer.Fprint(&buffer, file); err != nil {
+		return "", fmt.Errorf("error formatting policy: %s", err.Error())
+	}
+	return strings.TrimSpace(buffer.String()) + "\n", nil
+}
+
+// policyRulesEqual returns true if the HCL rules of two policies are the same once formatted
+// canonically, rules which can't be parsed are compared without the surrounding whitespace
+func policyRulesEqual(a, b string) bool {
+	normalize := func(rules string) string {
+		if formatted, err := FormatPolicy(rules); err == nil {
+			return formatted
+		}
+		return strings.TrimSpace(rules)
+	}
+	return normalize(a) == normalize(b)
+}
@@ -0,0 +1,109 @@
+package vault
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// Endpoint is a Vault node or cluster of a ClientPool
+type Endpoint struct {
+	Address string
+	// TLS is applied on top of the TLS settings of the base configuration if not nil
+	TLS *api.TLSConfig
+	// Token is the token of the client, the one of the base configuration (VAULT_TOKEN) if empty
+	Token string
+	// Configure adjusts the configuration of the client (e.g. its transport) before creating it
+	Configure func(config *api.Config) error
+}
+
+// ClientPool keeps a separate client for every Vault endpoint, created from its own copy of the
+// base configuration, so the clients of several nodes or clusters don't share their address,
+// transport or token: the lifecycle actions set the token of their client while they run.
+// It is safe for concurrent use.
+type ClientPool struct {
+	newConfig func() (*api.Config, error)
+	newClient func(config *api.Config) (*api.Client, error)
+
+	mu      sync.Mutex
+	clients map[string]*api.Client
+}
+
+// NewClientPool returns a pool creating the configuration of every client with newConfig (e.g.
+// api.DefaultConfig with the settings of the application) and the client with newClient,
+// api.NewClient if nil
+func NewClientPool(newConfig func() (*api.Config, error), newClient func(config *api.Config) (*api.Client, error)) *ClientPool {
+	if newClient == nil {
+		newClient = api.NewClient
+	}
+	return &ClientPool{newConfig: newConfig, newClient: newClient, clients: map[string]*api.Client{}}
+}
+
+// Client returns the client of the endpoint's address, it is created with the endpoint's settings
+// the first time, the settings of the later calls are ignored until the client is removed
+func (p *ClientPool) Client(endpoint Endpoint) (*api.Client, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if cl, ok := p.clients[endpoint.Address]; ok {
+		return cl, nil
+	}
+
+	config, err := p.newConfig()
+	if err != nil {
+		return nil, fmt.Errorf("error creating vault client config of %s: %s", endpoint.Address, err.Error())
+	}
+	config.Address = endpoint.Address
+
+	if endpoint.TLS != nil {
+		if _, ok := config.HttpClient.Transport.(*http.Transport); !ok {
+			return nil, fmt.Errorf("the tls settings of %s can't be applied to a custom transport", endpoint.Address)
+		}
+		if err := config.ConfigureTLS(endpoint.TLS); err != nil {
+			return nil, fmt.Errorf("error configuring vault tls of %s: %s", endpoint.Address, err.Error())
+		}
+	}
+	if endpoint.Configure != nil {
+		if err := endpoint.Configure(config); err != nil {
+			return nil, fmt.Errorf("error configuring vault client of %s: %s", endpoint.Address, err.Error())
+		}
+	}
+
+	cl, err := p.newClient(config)
+	if err != nil {
+		return nil, fmt.Errorf("error creating vault client of %s: %s", endpoint.Address, err.Error())
+	}
+	if endpoint.Token != "" {
+		cl.SetToken(endpoint.Token)
+	}
+
+	p.clients[endpoint.Address] = cl
+	return cl, nil
+}
+
+// Remove forgets the client of the address (e.g. of a deleted Pod), its token is cleared
+func (p *ClientPool) Remove(address string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if cl, ok := p.clients[address]; ok {
+		cl.ClearToken()
+		delete(p.clients, address)
+	}
+}
+
+// Addresses returns the addresses of the clients in the pool, sorted
+func (p *ClientPool) Addresses() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	addresses := make([]string, 0, len(p.clients))
+	for address := range p.clients {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+	return addresses
+}
@@ -0,0 +1,196 @@
+package vault
+
+import (
+	"fmt"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/banzaicloud/bank-vaults/pkg/logging"
+	"github.com/banzaicloud/bank-vaults/pkg/securemem"
+	"github.com/hashicorp/vault/api"
+)
+
+// ProvisionerPolicy is the name of the policy of the provisioner token
+const ProvisionerPolicy = "vault-configurer"
+
+// provisionerTokenPeriod is the period of the provisioner token, it is renewed by every use
+const provisionerTokenPeriod = "768h"
+
+// provisionerPolicyRules are the capabilities Configure, Diff and Export need: the auth methods,
+// secret engines, audit devices, policies and cluster-wide settings, and the paths of the auth
+// methods, identity and secret engines. The rest of sys (e.g. seal, rekey and generate-root) and
+// creating tokens are denied, the more specific paths take precedence over the wildcards.
+const provisionerPolicyRules = `
+path "sys/*" {
+  capabilities = ["deny"]
+}
+path "sys/auth" {
+  capabilities = ["read"]
+}
+path "sys/auth/*" {
+  capabilities = ["create", "read", "update", "delete", "sudo"]
+}
+path "sys/mounts" {
+  capabilities = ["read"]
+}
+path "sys/mounts/*" {
+  capabilities = ["create", "read", "update", "delete"]
+}
+path "sys/remount" {
+  capabilities = ["update"]
+}
+path "sys/audit" {
+  capabilities = ["read", "sudo"]
+}
+path "sys/audit/*" {
+  capabilities = ["create", "read", "update", "delete", "sudo"]
+}
+path "sys/policy" {
+  capabilities = ["read", "list"]
+}
+path "sys/policy/*" {
+  capabilities = ["create", "read", "update", "delete"]
+}
+path "sys/config/*" {
+  capabilities = ["create", "read", "update", "delete", "list", "sudo"]
+}
+path "sys/namespaces/*" {
+  capabilities = ["create", "read", "update", "delete", "list"]
+}
+path "auth/token/*" {
+  capabilities = ["deny"]
+}
+path "auth/token/roles/*" {
+  capabilities = ["create", "read", "update", "delete", "list"]
+}
+path "auth/token/lookup-self" {
+  capabilities = ["read"]
+}
+path "auth/token/renew-self" {
+  capabilities = ["update"]
+}
+path "auth/*" {
+  capabilities = ["create", "read", "update", "delete", "list", "sudo"]
+}
+path "identity/*" {
+  capabilities = ["create", "read", "update", "delete", "list"]
+}
+path "+/*" {
+  capabilities = ["create", "read", "update", "delete", "list"]
+}
+`
+
+// provisionerTokenKey returns the name of the provisioner token in the key store, a custom KeyNamer
+// names it with a ProvisionerTokenName method, otherwise it is the name of the root token suffixed
+// with -configurer
+func (v *vault) provisionerTokenKey() string {
+	if v.config.KeyNamer != nil {
+		if namer, ok := v.config.KeyNamer.(interface{ ProvisionerTokenName() string }); ok {
+			return namer.ProvisionerTokenName()
+		}
+		return v.config.KeyNamer.RootTokenName() + "-configurer"
+	}
+	return v.config.KeyNames.ProvisionerTokenName()
+}
+
+// createProvisionerToken writes the vault-configurer policy and stores a new periodic orphan token of
+// it in the key store with the root token set on the client, the token is returned. The token is
+// stored with a conditional write like the other keys of Init, unless it replaces a revoked one.
+func (v *vault) createProvisionerToken(replace bool) (string, error) {
+	err := v.retry("writing the provisioner policy", func() error {
+		return v.cl.Sys().PutPolicy(ProvisionerPolicy, provisionerPolicyRules)
+	})
+	if err != nil {
+		return "", fmt.Errorf("error writing the %s policy: %s", ProvisionerPolicy, err.Error())
+	}
+
+	var secret *api.Secret
+	err = v.retry("creating the provisioner token", func() (err error) {
+		secret, err = v.cl.Auth().Token().CreateOrphan(&api.TokenCreateRequest{
+			Policies:    []string{ProvisionerPolicy},
+			DisplayName: ProvisionerPolicy,
+			Period:      provisionerTokenPeriod,
+			NoParent:    true,
+		})
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("error creating the provisioner token: %s", err.Error())
+	}
+	if secret == nil || secret.Auth == nil || secret.Auth.ClientToken == "" {
+		return "", fmt.Errorf("error creating the provisioner token: no token in the response")
+	}
+	token := secret.Auth.ClientToken
+	logging.RegisterSecret(token)
+
+	key := v.provisionerTokenKey()
+	value := []byte(token)
+	if replace {
+		err = v.retryKeyStore(v.context(), fmt.Sprintf("setting key '%s'", key), func() error {
+			return v.keyStore.Set(key, value)
+		})
+	} else {
+		err = v.keyStoreSet(key, value)
+	}
+	securemem.Wipe(value)
+	if err != nil {
+		return "", fmt.Errorf("error storing the provisioner token in key '%s': %s", key, err.Error())
+	}
+	v.logger().WithField("key", key).Infof("provisioner token stored in key store")
+	return token, nil
+}
+
+// useConfigurerToken sets the token Configure, Diff and Export use on the client, the returned
+// function clears it. It is the root token, or the provisioner token with Config.ProvisionerToken,
+// which is created with the root token if Init hasn't stored it (e.g. Vault has been initialized
+// without it) or replaced if it has been revoked.
+func (v *vault) useConfigurerToken() (func(), error) {
+	if !v.config.ProvisionerToken || v.keyStore == nil {
+		return v.useRootToken()
+	}
+
+	key := v.provisionerTokenKey()
+	revoked := false
+	var value []byte
+	err := v.retryKeyStore(v.context(), fmt.Sprintf("getting key '%s'", key), func() (err error) {
+		value, err = v.keyStore.Get(key)
+		return err
+	})
+	if _, ok := err.(*kv.NotFoundError); ok {
+		v.logger().Infof("no provisioner token in key store, creating one")
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to get key '%s': %s", key, err.Error())
+	} else {
+		token := securemem.New(value)
+		logging.RegisterSecretBytes(token.Bytes())
+		// The client keeps a string copy of the token until it is cleared, which can't be wiped
+		v.cl.SetToken(string(token.Bytes()))
+		clearToken := func() {
+			v.cl.SetToken("")
+			token.Destroy()
+		}
+
+		// The periodic token is renewed by every use, so it expires only if it isn't used for a period
+		_, err := v.cl.Auth().Token().RenewSelf(0)
+		if err == nil {
+			return clearToken, nil
+		}
+		clearToken()
+		if !isPermissionDenied(err) {
+			return nil, fmt.Errorf("error renewing the provisioner token: %s", err.Error())
+		}
+		v.logger().Warnf("the stored provisioner token is revoked or expired, creating a new one: %s", err.Error())
+		revoked = true
+	}
+
+	clearRootToken, err := v.useRootToken()
+	if err != nil {
+		return nil, err
+	}
+	token, err := v.createProvisionerToken(revoked)
+	clearRootToken()
+	if err != nil {
+		return nil, err
+	}
+	v.cl.SetToken(token)
+	return func() { v.cl.SetToken("") }, nil
+}
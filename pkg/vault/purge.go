@@ -0,0 +1,166 @@
+package vault
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/banzaicloud/bank-vaults/pkg/tracing"
+	"github.com/hashicorp/vault/api"
+	"github.com/spf13/cast"
+)
+
+// The kinds of the paths in PurgeProtected, in the format of the paths of Diff
+const (
+	purgeAuthPrefix   = "sys/auth/"
+	purgeMountPrefix  = "sys/mounts/"
+	purgePolicyPrefix = "sys/policy/"
+)
+
+// builtinAuthMethods and builtinPolicies are created by Vault itself, they are never purged (like
+// systemMounts)
+var (
+	builtinAuthMethods = map[string]bool{"token": true}
+	builtinPolicies    = map[string]bool{"root": true, "default": true}
+)
+
+// purgeUnmanaged disables the auth methods and secret engines, and deletes the policies which aren't
+// in the configuration, except for the built-in ones and PurgeProtected, every outcome is recorded
+// in the report
+func (v *vault) purgeUnmanaged(parent *tracing.Span, config *ExternalConfig, report *ConfigureReport) (err error) {
+	span := v.tracer().StartSpan(parent, "vault.purgeUnmanaged")
+	defer func() { span.End(err) }()
+
+	managed := func(prefix, path string, desired map[string]bool) bool {
+		return desired[path] || v.purgeProtected(prefix+path)
+	}
+
+	desiredAuths := map[string]bool{}
+	for _, auth := range config.Auth {
+		desiredAuths[configItemPath(auth)] = true
+	}
+	var existingAuths map[string]*api.AuthMount
+	err = v.retry("listing auth methods", func() (err error) {
+		existingAuths, err = v.cl.Sys().ListAuth()
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error listing auth methods: %s", err.Error())
+	}
+	var auths []string
+	for path := range existingAuths {
+		auths = append(auths, strings.TrimSuffix(path, "/"))
+	}
+	sort.Strings(auths)
+	for _, path := range auths {
+		if builtinAuthMethods[path] || managed(purgeAuthPrefix, path, desiredAuths) {
+			continue
+		}
+		started := time.Now()
+		v.logger().Infof("disabling unmanaged %s auth method", path)
+		err := v.retry(fmt.Sprintf("disabling the %s auth method", path), func() error {
+			return v.cl.Sys().DisableAuth(path)
+		})
+		if err != nil {
+			err = fmt.Errorf("error disabling %s auth method: %s", path, err.Error())
+		}
+		report.delete(ResourceAuthMethod, path, started, err)
+	}
+
+	desiredMounts := map[string]bool{}
+	for _, secret := range config.Secrets {
+		desiredMounts[configItemPath(secret)] = true
+	}
+	var existingMounts map[string]*api.MountOutput
+	err = v.retry("listing secret engines", func() (err error) {
+		existingMounts, err = v.cl.Sys().ListMounts()
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error listing secret engines: %s", err.Error())
+	}
+	var mounts []string
+	for path := range existingMounts {
+		mounts = append(mounts, strings.TrimSuffix(path, "/"))
+	}
+	sort.Strings(mounts)
+	for _, path := range mounts {
+		if systemMounts[path] || managed(purgeMountPrefix, path, desiredMounts) {
+			continue
+		}
+		started := time.Now()
+		v.logger().Infof("unmounting unmanaged %s secret engine", path)
+		err := v.retry(fmt.Sprintf("unmounting the %s secret engine", path), func() error {
+			return v.cl.Sys().Unmount(path)
+		})
+		if err != nil {
+			err = fmt.Errorf("error unmounting %s secret engine: %s", path, err.Error())
+		}
+		report.delete(ResourceSecretEngine, path, started, err)
+	}
+
+	desiredPolicies := map[string]bool{}
+	for _, policy := range config.Policies {
+		desiredPolicies[policy["name"]] = true
+	}
+	var policies []string
+	err = v.retry("listing policies", func() (err error) {
+		policies, err = v.cl.Sys().ListPolicies()
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error listing policies: %s", err.Error())
+	}
+	sort.Strings(policies)
+	for _, name := range policies {
+		if builtinPolicies[name] || managed(purgePolicyPrefix, name, desiredPolicies) {
+			continue
+		}
+		started := time.Now()
+		v.logger().Infof("deleting unmanaged %s policy", name)
+		err := v.retry(fmt.Sprintf("deleting the %s policy", name), func() error {
+			return v.cl.Sys().DeletePolicy(name)
+		})
+		if err != nil {
+			err = fmt.Errorf("error deleting %s policy: %s", name, err.Error())
+		}
+		report.delete(ResourcePolicy, name, started, err)
+	}
+	return nil
+}
+
+// purgeProtected returns true if the path (in the format of the paths of Diff) is in PurgeProtected
+func (v *vault) purgeProtected(path string) bool {
+	for _, protected := range v.config.PurgeProtected {
+		if strings.Trim(protected, "/") == path {
+			return true
+		}
+	}
+	return false
+}
+
+// purges returns true if the object at the path (in the format of the paths of Diff) is deleted by
+// Configure if it isn't in the configuration
+func (v *vault) purges(path string) bool {
+	if !v.config.PurgeUnmanaged || v.purgeProtected(path) {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(path, purgeAuthPrefix):
+		return !builtinAuthMethods[strings.TrimPrefix(path, purgeAuthPrefix)]
+	case strings.HasPrefix(path, purgeMountPrefix):
+		return !systemMounts[strings.TrimPrefix(path, purgeMountPrefix)]
+	case strings.HasPrefix(path, purgePolicyPrefix):
+		return !builtinPolicies[strings.TrimPrefix(path, purgePolicyPrefix)]
+	}
+	return false
+}
+
+// configItemPath returns the path of an auth method or a secret engine, its type by default
+func configItemPath(item map[string]interface{}) string {
+	if path, ok := item["path"]; ok {
+		return strings.Trim(cast.ToString(path), "/")
+	}
+	return cast.ToString(item["type"])
+}
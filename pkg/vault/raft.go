@@ -0,0 +1,180 @@
+package vault
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+// raftSection is the section of the external configuration holding the settings of the integrated
+// (raft) storage, it isn't applied by Configure but by the unseal and configure commands
+const raftSection = "raft"
+
+// RaftConfig is the raft section of the external configuration
+type RaftConfig struct {
+	// Join are the settings of joining the new nodes to the raft cluster, nil if they aren't joined
+	Join *RaftJoinOptions `json:"join,omitempty" mapstructure:"join"`
+	// Snapshots are the settings of the periodic snapshots, nil if they aren't taken
+	Snapshots *RaftSnapshotOptions `json:"snapshots,omitempty" mapstructure:"snapshots"`
+}
+
+// RaftJoinOptions are the settings of joining a node to the raft cluster of a leader, the PEM
+// certificates of the TLS connection to the leader are optional
+type RaftJoinOptions struct {
+	LeaderCACert     string `json:"leader_ca_cert,omitempty" mapstructure:"leader_ca_cert"`
+	LeaderClientCert string `json:"leader_client_cert,omitempty" mapstructure:"leader_client_cert"`
+	LeaderClientKey  string `json:"leader_client_key,omitempty" mapstructure:"leader_client_key"`
+	// Retry makes Vault keep trying to join the leader in the background if it isn't reachable yet
+	Retry bool `json:"retry,omitempty" mapstructure:"retry"`
+}
+
+// RaftSnapshotOptions are the settings of the periodic raft snapshots
+type RaftSnapshotOptions struct {
+	// Period is the time between the snapshots, e.g. 1h
+	Period string `json:"period" mapstructure:"period"`
+	// Location is a text/template of the location of the snapshots with the .Time field (in UTC),
+	// e.g. s3://vault-backups/vault-{{ .Time.Format "20060102-150405" }}.snap
+	Location string `json:"location" mapstructure:"location"`
+}
+
+// RaftJoiner joins a Vault node to the raft cluster of the other nodes
+type RaftJoiner interface {
+	RaftJoin(ctx context.Context, leaderAddresses []string, options RaftJoinOptions) (bool, error)
+}
+
+// Raft parses the raft section of the external configuration, nil if it is missing
+func (c *ExternalConfig) Raft() (*RaftConfig, error) {
+	section := c.Section(raftSection)
+	if section == nil {
+		return nil, nil
+	}
+	var config RaftConfig
+	if err := mapstructure.WeakDecode(section, &config); err != nil {
+		return nil, fmt.Errorf("error parsing the %s section: %s", raftSection, err.Error())
+	}
+	if config.Snapshots != nil {
+		if _, err := config.Snapshots.PeriodDuration(); err != nil {
+			return nil, err
+		}
+		if _, err := config.Snapshots.SnapshotLocation(time.Time{}); err != nil {
+			return nil, err
+		}
+	}
+	return &config, nil
+}
+
+// PeriodDuration returns the parsed Period of the snapshots
+func (o *RaftSnapshotOptions) PeriodDuration() (time.Duration, error) {
+	period, err := time.ParseDuration(o.Period)
+	if err != nil {
+		return 0, fmt.Errorf("invalid period of the raft snapshots: %s", err.Error())
+	}
+	if period <= 0 {
+		return 0, fmt.Errorf("the period of the raft snapshots must be positive: %s", o.Period)
+	}
+	return period, nil
+}
+
+// SnapshotLocation renders the Location of the snapshot taken at t
+func (o *RaftSnapshotOptions) SnapshotLocation(t time.Time) (string, error) {
+	if o.Location == "" {
+		return "", fmt.Errorf("the location of the raft snapshots is required")
+	}
+	tmpl, err := template.New("location").Option("missingkey=error").Parse(o.Location)
+	if err != nil {
+		return "", fmt.Errorf("invalid location of the raft snapshots: %s", err.Error())
+	}
+	var location bytes.Buffer
+	if err := tmpl.Execute(&location, struct{ Time time.Time }{Time: t.UTC()}); err != nil {
+		return "", fmt.Errorf("invalid location of the raft snapshots: %s", err.Error())
+	}
+	return location.String(), nil
+}
+
+// RaftJoin joins the Vault node of the client to the raft cluster of the first leader which accepts
+// it, it returns true if the node has joined. An initialized node is a member of a cluster already,
+// it is left alone. The join endpoint is unauthenticated, no token is used.
+func (v *vault) RaftJoin(ctx context.Context, leaderAddresses []string, options RaftJoinOptions) (joined bool, err error) {
+	span := v.tracer().StartSpan(nil, "vault.RaftJoin")
+	defer func() { span.End(err) }()
+
+	var initialized bool
+	err = v.retryContext(ctx, RetryVault, "checking the init status", func() error {
+		return withContext(ctx, func() (err error) {
+			initialized, err = v.cl.Sys().InitStatus()
+			return err
+		})
+	})
+	if err != nil {
+		return false, fmt.Errorf("error checking the init status of vault: %s", err.Error())
+	}
+	if initialized {
+		return false, nil
+	}
+	if len(leaderAddresses) == 0 {
+		return false, fmt.Errorf("no raft leader to join")
+	}
+
+	var errs []string
+	for _, address := range leaderAddresses {
+		v.logger().Infof("joining the raft cluster of %s", address)
+		err := withContext(ctx, func() error {
+			return v.raftJoin(address, options)
+		})
+		if err == nil {
+			return true, nil
+		}
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+		errs = append(errs, fmt.Sprintf("%s: %s", address, err.Error()))
+	}
+	return false, fmt.Errorf("error joining the raft cluster: %s", strings.Join(errs, ", "))
+}
+
+// raftJoin sends the join request of a leader
+func (v *vault) raftJoin(address string, options RaftJoinOptions) error {
+	if err := v.waitForLimiter(); err != nil {
+		return err
+	}
+	req := v.cl.NewRequest("POST", "/v1/sys/storage/raft/join")
+	body := map[string]interface{}{
+		"leader_api_addr": address,
+		"retry":           options.Retry,
+	}
+	if options.LeaderCACert != "" {
+		body["leader_ca_cert"] = options.LeaderCACert
+	}
+	if options.LeaderClientCert != "" {
+		body["leader_client_cert"] = options.LeaderClientCert
+		body["leader_client_key"] = options.LeaderClientKey
+	}
+	if err := req.SetJSONBody(body); err != nil {
+		return err
+	}
+
+	resp, err := v.cl.RawRequest(req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return err
+	}
+	// The response is the joined field without the wrapper of the secrets
+	var result struct {
+		Joined bool `json:"joined"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("error parsing the response: %s", err.Error())
+	}
+	if !result.Joined && !options.Retry {
+		return fmt.Errorf("the node hasn't joined")
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package vault
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/spf13/cast"
+)
+
+// FieldMismatch is a field of a resource which reads back from Vault differently than configured
+type FieldMismatch struct {
+	Live    interface{} `json:"live"`
+	Desired interface{} `json:"desired"`
+}
+
+// ReadBackMismatch is a resource applied by Configure which doesn't read back from Vault as configured,
+// e.g. because Vault has normalized or ignored some of its fields
+type ReadBackMismatch struct {
+	// Path is the Vault API path of the resource, e.g. sys/policy/allow_secrets
+	Path string `json:"path"`
+	// Missing is true if the resource doesn't exist in Vault
+	Missing bool `json:"missing,omitempty"`
+	// Fields are the mismatching fields by their names
+	Fields map[string]FieldMismatch `json:"fields,omitempty"`
+}
+
+// ReadBackResult is the outcome of reading back the resources applied by Configure
+type ReadBackResult struct {
+	// Checked is the number of resources read back
+	Checked int `json:"checked"`
+	// Mismatches are the resources which don't read back as configured
+	Mismatches []ReadBackMismatch `json:"mismatches,omitempty"`
+	// Unverified are the paths which can't be read back (e.g. write-only endpoints)
+	Unverified []string `json:"unverified,omitempty"`
+}
+
+// readBack reads back the resources of the configuration (a random sample of ReadBackSample of them,
+// all if 0) from Vault and compares them with the configuration. Only the fields returned by Vault
+// are compared, as in Diff.
+func (v *vault) readBack(config *ExternalConfig) (*ReadBackResult, error) {
+	if namespaces := config.namespaces(); len(namespaces) > 0 {
+		return nil, fmt.Errorf("reading back the configuration isn't supported with namespaces: %s", strings.Join(namespaces, ", "))
+	}
+
+	objects, createOnly := flattenConfig(config.desiredSections())
+
+	paths := make([]string, 0, len(objects))
+	for path := range objects {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	if sample := v.config.ReadBackSample; sample > 0 && sample < len(paths) {
+		sampled := []string{}
+		for _, i := range rand.Perm(len(paths))[:sample] {
+			sampled = append(sampled, paths[i])
+		}
+		paths = sampled
+		sort.Strings(paths)
+	}
+
+	// The auth methods and secret engines are read back from their lists, once
+	var auths map[string]*api.AuthMount
+	var mounts map[string]*api.MountOutput
+	var audits map[string]*api.Audit
+
+	result := &ReadBackResult{}
+	for _, path := range paths {
+		var live interface{}
+		var err error
+
+		switch {
+		case strings.HasPrefix(path, "sys/policy/"):
+			var rules string
+			rules, err = v.cl.Sys().GetPolicy(strings.TrimPrefix(path, "sys/policy/"))
+			if err == nil && rules != "" {
+				live = map[string]interface{}{"rules": rules}
+			}
+			objects[path] = map[string]interface{}{"rules": objects[path]}
+		case strings.HasPrefix(path, "sys/auth/"):
+			if auths == nil {
+				if auths, err = v.cl.Sys().ListAuth(); err != nil {
+					return nil, err
+				}
+			}
+			if auth, ok := auths[strings.TrimPrefix(path, "sys/auth/")+"/"]; ok {
+				live = map[string]interface{}{"type": auth.Type}
+			}
+		case strings.HasPrefix(path, "sys/mounts/"):
+			if mounts == nil {
+				if mounts, err = v.cl.Sys().ListMounts(); err != nil {
+					return nil, err
+				}
+			}
+			if mount, ok := mounts[strings.TrimPrefix(path, "sys/mounts/")+"/"]; ok {
+				live = map[string]interface{}{"type": mount.Type, "description": mount.Description, "options": mount.Options}
+			}
+		case strings.HasPrefix(path, "sys/audit/"):
+			if audits == nil {
+				if audits, err = v.cl.Sys().ListAudit(); err != nil {
+					return nil, err
+				}
+			}
+			if audit, ok := audits[strings.TrimPrefix(path, "sys/audit/")+"/"]; ok {
+				live = map[string]interface{}{"type": audit.Type, "description": audit.Description, "options": audit.Options}
+			}
+		default:
+			// The generic endpoints may be write-only, so they can only be verified if they can be read
+			var item map[string]interface{}
+			item, err = v.exportItem(path)
+			if err != nil || item == nil {
+				v.logger().Debugf("can't read back %s: %v", path, err)
+				result.Unverified = append(result.Unverified, path)
+				continue
+			}
+			live = item
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		result.Checked++
+		if live == nil {
+			result.Mismatches = append(result.Mismatches, ReadBackMismatch{Path: path, Missing: true})
+			continue
+		}
+		// The fields of the create only objects may have been changed in Vault
+		if createOnly[path] {
+			continue
+		}
+		if fields := mismatchingFields(live, objects[path]); len(fields) > 0 {
+			result.Mismatches = append(result.Mismatches, ReadBackMismatch{Path: path, Fields: fields})
+		}
+	}
+
+	for _, mismatch := range result.Mismatches {
+		if mismatch.Missing {
+			v.logger().Warnf("%s has been configured, but it doesn't exist in vault", mismatch.Path)
+			continue
+		}
+		fields := make([]string, 0, len(mismatch.Fields))
+		for field := range mismatch.Fields {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		for _, field := range fields {
+			v.logger().WithField("path", mismatch.Path).WithField("field", field).
+				Warnf("vault returns %v instead of the configured %v", mismatch.Fields[field].Live, mismatch.Fields[field].Desired)
+		}
+	}
+
+	return result, nil
+}
+
+// mismatchingFields returns the fields of desired which are returned by Vault with a different value
+func mismatchingFields(live, desired interface{}) map[string]FieldMismatch {
+	liveMap := cast.ToStringMap(live)
+	fields := map[string]FieldMismatch{}
+	for key, desiredValue := range cast.ToStringMap(desired) {
+		liveValue, ok := liveMap[key]
+		if !ok {
+			// Not returned by Vault, either empty or write-only
+			continue
+		}
+		if normalizeConfigValue(liveValue) != normalizeConfigValue(desiredValue) {
+			fields[key] = FieldMismatch{Live: liveValue, Desired: desiredValue}
+		}
+	}
+	return fields
+}
@@ -0,0 +1,374 @@
+package vault
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/banzaicloud/bank-vaults/pkg/logging"
+	"github.com/banzaicloud/bank-vaults/pkg/securemem"
+	"github.com/hashicorp/vault/api"
+)
+
+// RekeyOptions are the parameters of a rekey, zero values keep the shares and threshold of the Config
+type RekeyOptions struct {
+	SecretShares    int
+	SecretThreshold int
+	// PGPKeys are the base64 encoded public keys (one for each share) the new keys are encrypted
+	// with, for key holders instead of the key store
+	PGPKeys []string
+}
+
+// RekeyResult describes the new keys of a rekey
+type RekeyResult struct {
+	// Keys are the key store IDs of the new keys, empty if they have been encrypted with PGP keys
+	Keys []string `json:"keys,omitempty"`
+	// EncryptedKeys are the new keys encrypted with the PGP keys, in the order of the PGP keys
+	EncryptedKeys   []string `json:"encryptedKeys,omitempty"`
+	PGPFingerprints []string `json:"pgpFingerprints,omitempty"`
+	SecretShares    int      `json:"secretShares"`
+	SecretThreshold int      `json:"secretThreshold"`
+}
+
+// Rekey replaces the unseal keys (or the recovery keys if Vault uses an auto-unseal seal) of Vault
+// with new ones generated with the stored keys. The new keys are stored with the next version of
+// the keys (see KeyNames), they only take effect after they have been verified with the values read
+// back from the key store, and the old keys are deleted afterwards. If PGP keys are given, the new
+// keys are returned encrypted with them instead, and the old keys are removed from the key store.
+func (v *vault) Rekey(options RekeyOptions) (*RekeyResult, error) {
+	if options.SecretShares == 0 {
+		options.SecretShares = v.config.SecretShares
+	}
+	if options.SecretThreshold == 0 {
+		options.SecretThreshold = v.config.SecretThreshold
+	}
+	if options.SecretShares < options.SecretThreshold {
+		return nil, fmt.Errorf("the secret threshold can't be bigger than the shares")
+	}
+	if len(options.PGPKeys) > 0 && len(options.PGPKeys) != options.SecretShares {
+		return nil, fmt.Errorf("the number of PGP keys must match the secret shares")
+	}
+
+	sealStatus, err := v.cl.Sys().SealStatus()
+	if err != nil {
+		return nil, fmt.Errorf("error checking the seal type of vault: %s", err.Error())
+	}
+
+	// The current version of the keys is read from their metadata
+	if _, err := v.keysMetadata(); err != nil {
+		return nil, err
+	}
+	newVersion := v.keysVersion + 1
+	oldNames, newNames := v.keyNamer(), v.keyNamerOfVersion(newVersion)
+
+	sys := v.cl.Sys()
+	oldKeyForID, newKeyForID := oldNames.UnsealKeyName, newNames.UnsealKeyName
+	rekeyInit, rekeyUpdate, rekeyCancel := sys.RekeyInit, sys.RekeyUpdate, sys.RekeyCancel
+	verificationUpdate, verificationCancel := sys.RekeyVerificationUpdate, sys.RekeyVerificationCancel
+	if sealStatus.RecoverySeal {
+		oldKeyForID, newKeyForID = oldNames.RecoveryKeyName, newNames.RecoveryKeyName
+		rekeyInit, rekeyUpdate, rekeyCancel = sys.RekeyRecoveryKeyInit, sys.RekeyRecoveryKeyUpdate, sys.RekeyRecoveryKeyCancel
+		verificationUpdate, verificationCancel = sys.RekeyRecoveryKeyVerificationUpdate, sys.RekeyRecoveryKeyVerificationCancel
+	}
+
+	oldKeys, err := v.storedKeys(oldKeyForID)
+	if err != nil {
+		return nil, err
+	}
+	defer wipeAll(oldKeys)
+	if len(oldKeys) == 0 {
+		return nil, fmt.Errorf("no keys found in the key store to rekey vault with")
+	}
+
+	v.logger().Infof("rekeying vault")
+
+	// The PGP encrypted keys can only be verified by their holders
+	status, err := rekeyInit(&api.RekeyInitRequest{
+		SecretShares:        options.SecretShares,
+		SecretThreshold:     options.SecretThreshold,
+		PGPKeys:             options.PGPKeys,
+		RequireVerification: len(options.PGPKeys) == 0,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error starting rekey: %s", err.Error())
+	}
+
+	var resp *api.RekeyUpdateResponse
+	for _, key := range oldKeys {
+		resp, err = rekeyUpdate(string(key), status.Nonce)
+		if err != nil {
+			rekeyCancel()
+			return nil, fmt.Errorf("error sending rekey update to vault: %s", err.Error())
+		}
+		if resp.Complete {
+			break
+		}
+	}
+	if resp == nil || !resp.Complete {
+		rekeyCancel()
+		return nil, fmt.Errorf("failed to rekey vault, not enough keys in the key store")
+	}
+	for _, keys := range [][]string{resp.Keys, resp.KeysB64} {
+		for _, k := range keys {
+			logging.RegisterSecret(k)
+		}
+	}
+
+	result := &RekeyResult{
+		SecretShares:    options.SecretShares,
+		SecretThreshold: options.SecretThreshold,
+	}
+
+	if len(options.PGPKeys) > 0 {
+		result.EncryptedKeys = resp.KeysB64
+		result.PGPFingerprints = resp.PGPFingerprints
+		// The old keys are not valid anymore
+		v.deleteKeys(oldKeyForID, 0, len(oldKeys))
+		v.deleteKeysMetadata()
+		v.logger().WithField("shares", len(resp.Keys)).Infof("vault rekeyed, new keys encrypted with the PGP keys")
+		return result, nil
+	}
+
+	// The new keys are stored next to the old ones with the next version, the old keys are only
+	// deleted after Vault has switched to the new ones
+	metadata := newKeysMetadata(sealStatus.Type, options.SecretShares, options.SecretThreshold)
+	metadata.Version = newVersion
+	for i, k := range resp.Keys {
+		keyID := newKeyForID(i)
+		value := []byte(k)
+		metadata.add(keyID, value)
+		err := v.keyStore.Set(keyID, value)
+		securemem.Wipe(value)
+		if err != nil {
+			verificationCancel()
+			v.deleteKeys(newKeyForID, 0, i)
+			return nil, fmt.Errorf("error storing new key '%s': %s", keyID, err.Error())
+		}
+		result.Keys = append(result.Keys, keyID)
+	}
+
+	// Verify the new keys with the stored values, so Vault switches to them only if they can be read back
+	var verification *api.RekeyVerificationUpdateResponse
+	for i := range resp.Keys {
+		keyID := newKeyForID(i)
+		key, err := v.keyStore.Get(keyID)
+		if err == nil {
+			verification, err = verificationUpdate(string(key), resp.VerificationNonce)
+			securemem.Wipe(key)
+		}
+		if err != nil {
+			verificationCancel()
+			v.deleteKeys(newKeyForID, 0, len(resp.Keys))
+			return nil, fmt.Errorf("error verifying new key '%s': %s", keyID, err.Error())
+		}
+		if verification.Complete {
+			break
+		}
+	}
+	if verification == nil || !verification.Complete {
+		verificationCancel()
+		v.deleteKeys(newKeyForID, 0, len(resp.Keys))
+		return nil, fmt.Errorf("failed to verify the new keys of vault")
+	}
+
+	// Vault uses the new keys already, the metadata switches the key IDs to their version
+	err = v.retryKeyStore(v.context(), "storing the metadata of the keys", func() error {
+		return v.storeKeysMetadata(metadata, true)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("vault has been rekeyed and the new keys are stored with version %d, but the metadata of the keys pointing to them couldn't be stored: %s", newVersion, err.Error())
+	}
+	v.keysVersion = newVersion
+
+	v.deleteKeys(oldKeyForID, 0, len(oldKeys))
+
+	v.logger().WithField("shares", len(resp.Keys)).WithField("version", newVersion).Infof("vault rekeyed, new keys stored in key store")
+
+	return result, nil
+}
+
+// RotateRootToken generates a new root token with the stored keys, replaces the one in the
+// key store with it and revokes the old root token
+func (v *vault) RotateRootToken() error {
+	if v.config.EphemeralRootToken {
+		return fmt.Errorf("no root token is stored to rotate, short-lived root tokens are generated with the stored keys")
+	}
+	value, err := v.keyStore.Get(v.rootTokenKey())
+	if err != nil {
+		return fmt.Errorf("unable to get key '%s': %s", v.rootTokenKey(), err.Error())
+	}
+	oldRootToken := securemem.New(value)
+	defer oldRootToken.Destroy()
+	logging.RegisterSecretBytes(oldRootToken.Bytes())
+
+	v.logger().Infof("generating new root token")
+
+	rootToken, err := v.generateRootToken()
+	if err != nil {
+		return err
+	}
+
+	value = []byte(rootToken)
+	err = v.keyStore.Set(v.rootTokenKey(), value)
+	securemem.Wipe(value)
+	if err != nil {
+		return fmt.Errorf("error storing new root token, the old one is still valid: %s", err.Error())
+	}
+	v.logger().WithField("key", v.rootTokenKey()).Infof("new root token stored in key store")
+
+	v.cl.SetToken(rootToken)
+	defer v.cl.SetToken("")
+
+	// Tokens created by the old root token stay valid
+	if err := v.cl.Auth().Token().RevokeOrphan(string(oldRootToken.Bytes())); err != nil {
+		return fmt.Errorf("error revoking old root token: %s", err.Error())
+	}
+	v.logger().Infof("old root token revoked")
+
+	return nil
+}
+
+// generateRootToken generates a new root token with the stored unseal keys, or recovery keys if Vault
+// uses an auto-unseal seal (sys/generate-root), the token isn't stored anywhere
+func (v *vault) generateRootToken() (string, error) {
+	sealStatus, err := v.cl.Sys().SealStatus()
+	if err != nil {
+		return "", fmt.Errorf("error checking the seal type of vault: %s", err.Error())
+	}
+	if _, err := v.keysMetadata(); err != nil {
+		return "", err
+	}
+	keyForID := v.unsealKeyForID
+	if sealStatus.RecoverySeal {
+		keyForID = v.recoveryKeyForID
+	}
+
+	keys, err := v.storedKeys(keyForID)
+	if err != nil {
+		return "", err
+	}
+	defer wipeAll(keys)
+
+	otp := make([]byte, 16)
+	defer securemem.Wipe(otp)
+	if _, err := rand.Read(otp); err != nil {
+		return "", fmt.Errorf("error generating one time password: %s", err.Error())
+	}
+
+	status, err := v.cl.Sys().GenerateRootInit(base64.StdEncoding.EncodeToString(otp), "")
+	if err != nil {
+		return "", fmt.Errorf("error starting root token generation: %s", err.Error())
+	}
+
+	for _, key := range keys {
+		status, err = v.cl.Sys().GenerateRootUpdate(string(key), status.Nonce)
+		if err != nil {
+			v.cl.Sys().GenerateRootCancel()
+			return "", fmt.Errorf("error sending root token generation update to vault: %s", err.Error())
+		}
+		if status.Complete {
+			break
+		}
+	}
+	if !status.Complete {
+		v.cl.Sys().GenerateRootCancel()
+		return "", fmt.Errorf("failed to generate root token, not enough keys in the key store")
+	}
+
+	encodedRootToken := status.EncodedRootToken
+	if encodedRootToken == "" {
+		encodedRootToken = status.EncodedToken
+	}
+	rootToken, err := decodeRootToken(encodedRootToken, otp)
+	if err != nil {
+		return "", err
+	}
+	logging.RegisterSecret(rootToken)
+	return rootToken, nil
+}
+
+// RootTokenCreated returns the creation time of the stored root token, looked up with the token itself
+func (v *vault) RootTokenCreated() (time.Time, error) {
+	clearToken, err := v.useRootToken()
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer clearToken()
+
+	secret, err := v.cl.Auth().Token().LookupSelf()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error looking up the root token: %s", err.Error())
+	}
+	if secret == nil || secret.Data == nil {
+		return time.Time{}, fmt.Errorf("error looking up the root token: empty response")
+	}
+	creationTime, ok := secret.Data["creation_time"].(json.Number)
+	if !ok {
+		return time.Time{}, fmt.Errorf("error looking up the root token: no creation time in the response")
+	}
+	seconds, err := creationTime.Int64()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error parsing the creation time of the root token: %s", err.Error())
+	}
+	return time.Unix(seconds, 0), nil
+}
+
+// storedKeys reads the keys stored under the IDs of keyForID until the first missing one,
+// they have to be wiped with wipeAll after use
+func (v *vault) storedKeys(keyForID func(int) string) ([][]byte, error) {
+	keys := [][]byte{}
+	for i := 0; ; i++ {
+		key, err := v.keyStore.Get(keyForID(i))
+		if _, ok := err.(*kv.NotFoundError); ok {
+			return keys, nil
+		} else if err != nil {
+			wipeAll(keys)
+			return nil, fmt.Errorf("unable to get key '%s': %s", keyForID(i), err.Error())
+		}
+		logging.RegisterSecret(string(key))
+		keys = append(keys, key)
+	}
+}
+
+// wipeAll overwrites the keys with zeroes
+func wipeAll(keys [][]byte) {
+	for _, key := range keys {
+		securemem.Wipe(key)
+	}
+}
+
+// deleteKeys deletes the keys with the IDs of keyForID in the range [from, to) from the key store
+func (v *vault) deleteKeys(keyForID func(int) string, from, to int) {
+	for i := from; i < to; i++ {
+		if err := kv.Delete(v.keyStore, keyForID(i)); err != nil {
+			v.logger().Warnf("error deleting invalid key '%s': %s", keyForID(i), err.Error())
+		}
+	}
+}
+
+// deleteKeysMetadata deletes the metadata of keys which aren't valid anymore
+func (v *vault) deleteKeysMetadata() {
+	if err := kv.Delete(v.keyStore, v.keysMetadataKey()); err != nil {
+		v.logger().Warnf("error deleting key '%s': %s", v.keysMetadataKey(), err.Error())
+	}
+}
+
+// decodeRootToken decodes a root token generated with a one time password, the root tokens
+// of the supported Vault versions are UUIDs, encoded as the XOR of their bytes and the password
+func decodeRootToken(encodedRootToken string, otp []byte) (string, error) {
+	tokenBytes, err := base64.StdEncoding.DecodeString(encodedRootToken)
+	if err != nil {
+		return "", fmt.Errorf("error decoding root token: %s", err.Error())
+	}
+	defer securemem.Wipe(tokenBytes)
+	if len(tokenBytes) != len(otp) {
+		return "", fmt.Errorf("error decoding root token: length mismatch")
+	}
+	for i := range tokenBytes {
+		tokenBytes[i] ^= otp[i]
+	}
+	return fmt.Sprintf("%x-%x-%x-%x-%x", tokenBytes[0:4], tokenBytes[4:6], tokenBytes[6:8], tokenBytes[8:10], tokenBytes[10:16]), nil
+}
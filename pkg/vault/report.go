@@ -0,0 +1,179 @@
+package vault
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The kinds of the resources in a ConfigureReport
+const (
+	ResourceAuthMethod         = "auth method"
+	ResourceAuthRole           = "auth role"
+	ResourceAuthMapping        = "auth mapping"
+	ResourceAuthUser           = "auth user"
+	ResourcePolicy             = "policy"
+	ResourceSecretEngine       = "secret engine"
+	ResourceSecretEngineConfig = "secret engine configuration"
+	ResourceAuditDevice        = "audit device"
+	ResourceStartupSecret      = "startup secret"
+	ResourceSysConfig          = "sys configuration"
+	ResourceNamespace          = "namespace"
+	ResourceIdentityEntity     = "identity entity"
+	ResourceIdentityGroup      = "identity group"
+	ResourceIdentityAlias      = "identity alias"
+)
+
+// The actions of the resources in a ConfigureReport
+const (
+	// ActionCreated is the action of a resource which didn't exist in Vault
+	ActionCreated = "created"
+	// ActionUpdated is the action of a resource which existed in Vault, or whose existence couldn't be
+	// checked (e.g. a write-only path)
+	ActionUpdated = "updated"
+	// ActionSkipped is the action of a create_only resource which exists in Vault, of an audit device
+	// which is enabled already, or of a policy whose rules haven't changed, so it hasn't been written
+	ActionSkipped = "skipped"
+	// ActionDeleted is the action of a resource which isn't in the configuration, so it has been
+	// deleted from Vault with PurgeUnmanaged
+	ActionDeleted = "deleted"
+	// ActionFailed is the action of a resource which couldn't be applied
+	ActionFailed = "failed"
+)
+
+// ResourceResult is the outcome of applying a resource of the external configuration
+type ResourceResult struct {
+	Kind string `json:"kind"`
+	// Name is the path of the resource in Vault, or the name of a policy
+	Name string `json:"name"`
+	// Namespace is the Vault Enterprise namespace the resource has been applied in, empty for the root one
+	Namespace string `json:"namespace,omitempty"`
+	// Action is one of ActionCreated, ActionUpdated, ActionSkipped, ActionDeleted and ActionFailed
+	Action string `json:"action"`
+	// Duration is the time spent on applying the resource, with the retries
+	Duration time.Duration `json:"duration"`
+	// Error is empty if the resource has been applied
+	Error string `json:"error,omitempty"`
+}
+
+// ConfigureReport is the outcome of every resource applied by Configure, in the order of the configuration
+type ConfigureReport struct {
+	// Started is when the configuration has been started
+	Started time.Time `json:"started"`
+	// Duration is the time spent on the whole configuration
+	Duration  time.Duration    `json:"duration"`
+	Resources []ResourceResult `json:"resources"`
+	// ReadBack is the outcome of reading back the resources after a successful configuration, if enabled
+	ReadBack *ReadBackResult `json:"readBack,omitempty"`
+	// SSHCAPublicKeys are the public keys of the CAs of the ssh secret engines by their paths (prefixed
+	// with their namespaces), to be trusted by the SSH servers
+	SSHCAPublicKeys map[string]string `json:"sshCAPublicKeys,omitempty"`
+}
+
+// add records a resource applied since started, existed is true if it has been in Vault before
+func (r *ConfigureReport) add(kind, name string, existed bool, started time.Time, err error) {
+	result := ResourceResult{Kind: kind, Name: name, Action: ActionCreated, Duration: time.Since(started)}
+	if err != nil {
+		result.Action = ActionFailed
+		result.Error = err.Error()
+	} else if existed {
+		result.Action = ActionUpdated
+	}
+	r.Resources = append(r.Resources, result)
+}
+
+// Record records a resource applied by a Configurator since started, existed is true if it has
+// been in Vault before, the kind is e.g. the section of the configurator
+func (r *ConfigureReport) Record(kind, name string, existed bool, started time.Time, err error) {
+	r.add(kind, name, existed, started, err)
+}
+
+// addSSHCAPublicKey records the public key of the CA of the ssh secret engine at path
+func (r *ConfigureReport) addSSHCAPublicKey(path, publicKey string) {
+	if r.SSHCAPublicKeys == nil {
+		r.SSHCAPublicKeys = map[string]string{}
+	}
+	r.SSHCAPublicKeys[path] = publicKey
+}
+
+// skip records a resource which hasn't been written
+func (r *ConfigureReport) skip(kind, name string, started time.Time) {
+	r.Resources = append(r.Resources, ResourceResult{Kind: kind, Name: name, Action: ActionSkipped, Duration: time.Since(started)})
+}
+
+// delete records a resource deleted since started
+func (r *ConfigureReport) delete(kind, name string, started time.Time, err error) {
+	result := ResourceResult{Kind: kind, Name: name, Action: ActionDeleted, Duration: time.Since(started)}
+	if err != nil {
+		result.Action = ActionFailed
+		result.Error = err.Error()
+	}
+	r.Resources = append(r.Resources, result)
+}
+
+// ConfigureSummary is the number of the resources of a ConfigureReport by their actions
+type ConfigureSummary struct {
+	Created  int           `json:"created"`
+	Updated  int           `json:"updated"`
+	Skipped  int           `json:"skipped"`
+	Deleted  int           `json:"deleted,omitempty"`
+	Failed   int           `json:"failed"`
+	Duration time.Duration `json:"duration"`
+}
+
+func (s ConfigureSummary) String() string {
+	if s.Deleted > 0 {
+		return fmt.Sprintf("%d created, %d updated, %d skipped, %d deleted, %d failed in %s", s.Created, s.Updated, s.Skipped, s.Deleted, s.Failed, s.Duration)
+	}
+	return fmt.Sprintf("%d created, %d updated, %d skipped, %d failed in %s", s.Created, s.Updated, s.Skipped, s.Failed, s.Duration)
+}
+
+// Summary counts the resources of the report by their actions
+func (r *ConfigureReport) Summary() ConfigureSummary {
+	summary := ConfigureSummary{Duration: r.Duration}
+	for _, result := range r.Resources {
+		switch result.Action {
+		case ActionCreated:
+			summary.Created++
+		case ActionUpdated:
+			summary.Updated++
+		case ActionSkipped:
+			summary.Skipped++
+		case ActionDeleted:
+			summary.Deleted++
+		case ActionFailed:
+			summary.Failed++
+		}
+	}
+	return summary
+}
+
+// Failed returns the resources which couldn't be applied
+func (r *ConfigureReport) Failed() []ResourceResult {
+	var failed []ResourceResult
+	for _, result := range r.Resources {
+		if result.Error != "" {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+// ConfigureError is returned by Configure if some of the resources couldn't be applied, the others
+// have been applied nevertheless
+type ConfigureError struct {
+	Report *ConfigureReport
+}
+
+func (e *ConfigureError) Error() string {
+	failed := e.Report.Failed()
+	messages := make([]string, len(failed))
+	for i, result := range failed {
+		name := result.Name
+		if result.Namespace != "" {
+			name = result.Namespace + "/" + name
+		}
+		messages[i] = fmt.Sprintf("%s %s: %s", result.Kind, name, result.Error)
+	}
+	return fmt.Sprintf("%d of %d resources failed: %s", len(failed), len(e.Report.Resources), strings.Join(messages, "; "))
+}
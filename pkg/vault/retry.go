@@ -0,0 +1,134 @@
+package vault
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/banzaicloud/bank-vaults/pkg/backoff"
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/banzaicloud/bank-vaults/pkg/securemem"
+)
+
+// RetryOperation is a kind of operations whose retries can be configured separately with
+// Config.RetryOverrides
+type RetryOperation string
+
+// The kinds of operations which are retried
+const (
+	// RetryVault are the requests to Vault, e.g. checking its status or configuring it
+	RetryVault RetryOperation = "vault"
+	// RetryKeyStore are the operations of the key store, e.g. reading the unseal keys
+	RetryKeyStore RetryOperation = "key-store"
+	// RetryUnseal are the requests sending the unseal keys to Vault
+	RetryUnseal RetryOperation = "unseal"
+)
+
+// RetryPolicy is how an operation failing with a retryable error (e.g. connection refused, 429 or
+// 5xx) is retried
+type RetryPolicy struct {
+	// MaxRetries is how many times the operation is retried at most, 0 disables the retries
+	MaxRetries int
+	// the exponential backoff between the attempts, starting at InitialInterval and doubled after
+	// every attempt up to MaxInterval, backoff.DefaultInitialInterval and DefaultMaxInterval if 0
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+	// Jitter randomizes the intervals by up to this fraction of them, see backoff.Exponential
+	Jitter float64
+}
+
+// retryPolicy returns the policy of the kind of operations, its override in Config.RetryOverrides
+// if there is one, the policy of Retries, RetryInitialInterval, RetryMaxInterval and RetryJitter
+// otherwise
+func (v *vault) retryPolicy(operation RetryOperation) RetryPolicy {
+	if policy, ok := v.config.RetryOverrides[operation]; ok {
+		return policy
+	}
+	return RetryPolicy{
+		MaxRetries:      v.config.Retries,
+		InitialInterval: v.config.RetryInitialInterval,
+		MaxInterval:     v.config.RetryMaxInterval,
+		Jitter:          v.config.RetryJitter,
+	}
+}
+
+// retry calls f until it succeeds, fails with an error which isn't retryable, or the retries of the
+// policy of the Vault requests have failed, with an exponential backoff between the attempts
+func (v *vault) retry(operation string, f func() error) error {
+	return v.retryContext(v.context(), RetryVault, operation, f)
+}
+
+// retryContext is retry with the policy of the kind of operations, which stops waiting for the next
+// attempt when ctx is done
+func (v *vault) retryContext(ctx context.Context, kind RetryOperation, operation string, f func() error) error {
+	policy := v.retryPolicy(kind)
+	interval := policy.InitialInterval
+	if interval <= 0 {
+		interval = backoff.DefaultInitialInterval
+	}
+	maxInterval := policy.MaxInterval
+	if maxInterval <= 0 {
+		maxInterval = backoff.DefaultMaxInterval
+	}
+
+	for attempt := 1; ; attempt++ {
+		if kind == RetryVault {
+			if err := v.waitForLimiter(); err != nil {
+				return err
+			}
+		}
+		err := f()
+		if err == nil || attempt > policy.MaxRetries || !IsRetryable(err) || ctx.Err() != nil {
+			return err
+		}
+
+		next := backoff.Jittered(interval, policy.Jitter)
+		v.logger().Warnf("%s failed: %s, retrying in %s (%d/%d)", operation, err.Error(), next, attempt, policy.MaxRetries)
+		timer := time.NewTimer(next)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+
+		interval *= 2
+		if interval > maxInterval {
+			interval = maxInterval
+		}
+	}
+}
+
+// retryKeyStore is retryContext with the policy of the key store operations
+func (v *vault) retryKeyStore(ctx context.Context, operation string, f func() error) error {
+	return v.retryContext(ctx, RetryKeyStore, operation, f)
+}
+
+// retryWrite writes the data to the path of Vault with retries
+func (v *vault) retryWrite(path string, data map[string]interface{}) error {
+	return v.retry(fmt.Sprintf("writing %s", path), func() error {
+		_, err := v.cl.Logical().Write(path, data)
+		return err
+	})
+}
+
+// retryCreate creates the key in the key store with retries, an attempt may have stored the value
+// even if it has failed, so the key existing with the same value after a retry is a success
+func (v *vault) retryCreate(key string, value []byte) error {
+	attempted := false
+	return v.retryKeyStore(v.context(), fmt.Sprintf("setting key '%s'", key), func() error {
+		err := kv.Create(v.keyStore, key, value)
+		if _, ok := err.(*kv.AlreadyExistsError); ok && attempted {
+			if stored, getErr := v.keyStore.Get(key); getErr == nil {
+				same := bytes.Equal(stored, value)
+				securemem.Wipe(stored)
+				if same {
+					return nil
+				}
+			}
+		}
+		attempted = true
+		return err
+	})
+}
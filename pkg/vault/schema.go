@@ -0,0 +1,169 @@
+package vault
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cast"
+)
+
+// ConfigAPIVersion is the current version of the schema of the external configuration, set in its
+// apiVersion field. The configurations of the older versions are migrated to it when they are parsed.
+const ConfigAPIVersion = "v1"
+
+// configAPIVersionKey is the field of the external configuration holding its version
+const configAPIVersionKey = "apiVersion"
+
+// unversionedConfigAPIVersion is the version of the configurations without apiVersion, written
+// before the schema has been versioned
+const unversionedConfigAPIVersion = "v1"
+
+// configMigration upgrades the settings of the external configuration from a version to the next one
+type configMigration struct {
+	from, to string
+	// migrate changes the settings in place, and reports the changes the user should make
+	migrate func(settings map[string]interface{}, warn func(path, message string))
+}
+
+// configMigrations are the migrations between the versions of the schema, oldest first. A structural
+// change of the schema adds a new version with a migration from the previous one, so the existing
+// configuration files keep working.
+var configMigrations = []configMigration{}
+
+// deprecatedConfigKey is a field of the items of a section which has been replaced by another one
+// within the same version, it is renamed with a warning
+type deprecatedConfigKey struct {
+	section, key, replacement string
+}
+
+// deprecatedConfigKeys are the deprecated fields of the current version
+var deprecatedConfigKeys = []deprecatedConfigKey{}
+
+// MigrateConfig upgrades the settings of an external configuration (e.g. of a configuration file) to
+// ConfigAPIVersion and renames the deprecated fields, the settings aren't changed. The returned
+// warnings are the deprecated parts of the configuration, which should be updated by the user.
+func MigrateConfig(settings map[string]interface{}) (map[string]interface{}, []*ConfigError, error) {
+	var warnings []*ConfigError
+	warn := func(path, message string) {
+		warnings = append(warnings, &ConfigError{Path: path, Message: message})
+	}
+
+	migrated := map[string]interface{}{}
+	version := unversionedConfigAPIVersion
+	for key, value := range settings {
+		if strings.EqualFold(key, configAPIVersionKey) {
+			version = cast.ToString(value)
+			continue
+		}
+		migrated[configSectionName(key)] = copyConfigSection(value)
+	}
+
+	if !isConfigAPIVersion(version) {
+		return nil, nil, fmt.Errorf("unsupported %s of vault config: %s, the supported versions are up to %s", configAPIVersionKey, version, ConfigAPIVersion)
+	}
+
+	for _, migration := range configMigrations {
+		if migration.from != version {
+			continue
+		}
+		migration.migrate(migrated, func(path, message string) {
+			warn(path, fmt.Sprintf("%s (migrated from %s to %s)", message, migration.from, migration.to))
+		})
+		version = migration.to
+	}
+
+	for _, deprecated := range deprecatedConfigKeys {
+		for i, item := range cast.ToSlice(migrated[deprecated.section]) {
+			fields, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			value, ok := fields[deprecated.key]
+			if !ok {
+				continue
+			}
+			path := fmt.Sprintf("%s[%d].%s", deprecated.section, i, deprecated.key)
+			if _, ok := fields[deprecated.replacement]; ok {
+				warn(path, fmt.Sprintf("deprecated, it is ignored because %s is set", deprecated.replacement))
+			} else {
+				fields[deprecated.replacement] = value
+				warn(path, fmt.Sprintf("deprecated, use %s instead", deprecated.replacement))
+			}
+			delete(fields, deprecated.key)
+		}
+	}
+
+	migrated[configAPIVersionKey] = ConfigAPIVersion
+	return migrated, warnings, nil
+}
+
+// configSectionName returns the name of the section of ConfigSections matching the key case-insensitively,
+// as viper lowercases the keys of the configuration files (e.g. startupsecrets), the key otherwise
+func configSectionName(key string) string {
+	for section := range ConfigSections {
+		if strings.EqualFold(key, section) {
+			return section
+		}
+	}
+	return key
+}
+
+// isConfigAPIVersion returns true if the version can be migrated to ConfigAPIVersion
+func isConfigAPIVersion(version string) bool {
+	if version == ConfigAPIVersion {
+		return true
+	}
+	for _, migration := range configMigrations {
+		if migration.from == version {
+			return true
+		}
+	}
+	return false
+}
+
+// copyConfigSection copies a section of the settings, the repeated blocks of a section in HCL are its items
+func copyConfigSection(value interface{}) interface{} {
+	if blocks, ok := value.([]map[string]interface{}); ok {
+		items := make([]interface{}, len(blocks))
+		for i, block := range blocks {
+			items[i] = copyConfigValue(block)
+		}
+		return items
+	}
+	return copyConfigValue(value)
+}
+
+// copyConfigValue copies the maps and lists of the settings, so the migrations don't change the
+// settings of the caller. The blocks of HCL (parsed as lists of maps, the lists of HCL are
+// []interface{}) are objects, the fields of the repeated blocks are merged.
+func copyConfigValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case []map[string]interface{}:
+		copied := map[string]interface{}{}
+		for _, block := range value {
+			for key, item := range block {
+				copied[key] = copyConfigValue(item)
+			}
+		}
+		return copied
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			copied[key] = copyConfigValue(item)
+		}
+		return copied
+	case map[interface{}]interface{}:
+		copied := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			copied[fmt.Sprint(key)] = copyConfigValue(item)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(value))
+		for i, item := range value {
+			copied[i] = copyConfigValue(item)
+		}
+		return copied
+	}
+	return value
+}
@@ -0,0 +1,205 @@
+package vault
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// SealMigrator migrates Vault between a Shamir seal and an auto-unseal seal (e.g. awskms)
+type SealMigrator interface {
+	MigrateSeal(ctx context.Context) (*SealMigrationResult, error)
+}
+
+// SealMigrationResult describes the keys after a seal migration
+type SealMigrationResult struct {
+	// SealType is the type of the new seal of Vault
+	SealType string `json:"sealType"`
+	// RecoveryKeys is true if the stored keys are recovery keys (of an auto-unseal seal) afterwards
+	RecoveryKeys bool `json:"recoveryKeys"`
+	// Keys are the key store IDs of the keys
+	Keys []string `json:"keys"`
+	// Moved is true if the keys have been moved to the IDs of the other kind of keys
+	Moved bool `json:"moved"`
+}
+
+// sealMigrationStatus is the seal status with the migration field, which the api package doesn't have
+type sealMigrationStatus struct {
+	api.SealStatusResponse
+	Migration bool `json:"migration"`
+}
+
+// sealMigrationStatus reads the seal status of Vault with its migration field
+func (v *vault) sealMigrationStatus() (*sealMigrationStatus, error) {
+	resp, err := v.cl.RawRequest(v.cl.NewRequest("GET", "/v1/sys/seal-status"))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var status sealMigrationStatus
+	if err := resp.DecodeJSON(&status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// unsealMigrate sends a key of the old seal to Vault with the migrate option
+func (v *vault) unsealMigrate(key string) (*api.SealStatusResponse, error) {
+	req := v.cl.NewRequest("PUT", "/v1/sys/unseal")
+	if err := req.SetJSONBody(map[string]interface{}{"key": key, "migrate": true}); err != nil {
+		return nil, err
+	}
+	resp, err := v.cl.RawRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var status api.SealStatusResponse
+	if err := resp.DecodeJSON(&status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// MigrateSeal completes the seal migration of Vault restarted with its new seal in migration mode
+// (with the -migrate flag or the disabled old seal in its config): it unseals Vault with the stored
+// keys and the migrate option, then stores the keys as the keys of the new seal, as the unseal keys
+// of a Shamir seal become the recovery keys of an auto-unseal seal, and vice versa. The keys are
+// stored under their new IDs before the metadata of the keys is switched to the new seal and the old
+// IDs are deleted. The keys keep their values, so the nodes of an HA cluster can be migrated one by
+// one, and an interrupted migration can be completed by running it again once Vault is unsealed.
+func (v *vault) MigrateSeal(ctx context.Context) (*SealMigrationResult, error) {
+	if v.keyStore == nil {
+		return nil, fmt.Errorf("the seal can't be migrated without a key store")
+	}
+
+	status, err := v.sealMigrationStatus()
+	if err != nil {
+		return nil, fmt.Errorf("error checking the seal status of vault: %s", err.Error())
+	}
+	if !status.Migration && status.Sealed {
+		return nil, fmt.Errorf("vault isn't migrating its seal, it has to be restarted with the new seal and the -migrate flag")
+	}
+
+	metadata, err := v.keysMetadata()
+	if err != nil {
+		return nil, err
+	}
+
+	// The keys are stored as unseal keys of the old Shamir seal, or as recovery keys of the old
+	// auto-unseal seal (or of the new seal if they have been moved by a previous migration)
+	names := v.keyNamer()
+	keyForID, recoveryKeys := names.UnsealKeyName, false
+	keys, err := v.storedKeys(keyForID)
+	if err == nil && len(keys) == 0 {
+		keyForID, recoveryKeys = names.RecoveryKeyName, true
+		keys, err = v.storedKeys(keyForID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer wipeAll(keys)
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no keys found in the key store to migrate the seal of vault with")
+	}
+	for i, key := range keys {
+		if err := metadata.validateKey(keyForID(i), key); err != nil {
+			return nil, err
+		}
+	}
+
+	if status.Migration {
+		v.logger().Infof("migrating the %s seal of vault", status.Type)
+		if err := v.unsealMigrateWithKeys(ctx, keys); err != nil {
+			return nil, err
+		}
+	}
+
+	sealStatus, err := v.cl.Sys().SealStatus()
+	if err != nil {
+		return nil, fmt.Errorf("error checking the seal type of vault: %s", err.Error())
+	}
+
+	result := &SealMigrationResult{SealType: sealStatus.Type, RecoveryKeys: sealStatus.RecoverySeal}
+	newKeyForID := names.UnsealKeyName
+	if sealStatus.RecoverySeal {
+		newKeyForID = names.RecoveryKeyName
+	}
+	for i := range keys {
+		result.Keys = append(result.Keys, newKeyForID(i))
+	}
+
+	if recoveryKeys == sealStatus.RecoverySeal && (metadata == nil || metadata.SealType == sealStatus.Type) {
+		v.logger().WithField("seal", sealStatus.Type).Infof("the seal of vault has been migrated, the stored keys are up to date")
+		return result, nil
+	}
+
+	newMetadata := metadata
+	if metadata != nil {
+		newMetadata = newKeysMetadata(sealStatus.Type, metadata.SecretShares, metadata.SecretThreshold)
+		newMetadata.Version = metadata.Version
+	}
+
+	result.Moved = recoveryKeys != sealStatus.RecoverySeal
+	for i, key := range keys {
+		keyID := newKeyForID(i)
+		if newMetadata != nil {
+			newMetadata.add(keyID, key)
+		}
+		if !result.Moved {
+			continue
+		}
+		err := v.retryKeyStore(v.context(), fmt.Sprintf("setting key '%s'", keyID), func() error {
+			return v.keyStore.Set(keyID, key)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("the seal of vault has been migrated, but the keys couldn't be stored as the keys of the %s seal: error storing key '%s': %s", sealStatus.Type, keyID, err.Error())
+		}
+	}
+
+	if newMetadata != nil {
+		err := v.retryKeyStore(v.context(), "storing the metadata of the keys", func() error {
+			return v.storeKeysMetadata(newMetadata, true)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("the seal of vault has been migrated, but the metadata of the keys couldn't be updated to the %s seal: %s", sealStatus.Type, err.Error())
+		}
+	}
+
+	if result.Moved {
+		v.deleteKeys(keyForID, 0, len(keys))
+	}
+
+	v.logger().WithField("seal", sealStatus.Type).WithField("keys", len(keys)).Infof("the seal of vault has been migrated, keys updated in key store")
+
+	return result, nil
+}
+
+// unsealMigrateWithKeys sends the keys to Vault with the migrate option until it is unsealed
+func (v *vault) unsealMigrateWithKeys(ctx context.Context, keys [][]byte) error {
+	for _, key := range keys {
+		unsealKey := string(key)
+		var resp *api.SealStatusResponse
+		err := v.retryContext(ctx, RetryUnseal, "unseal request", func() error {
+			return withContext(ctx, func() (err error) {
+				resp, err = v.unsealMigrate(unsealKey)
+				return err
+			})
+		})
+		if err != nil {
+			return newError(ErrUnsealFailed, err, "fail to send the migrating unseal request to vault")
+		}
+
+		v.logger().Debugf("got unseal response: sealed: %t, progress: %d/%d", resp.Sealed, resp.Progress, resp.T)
+		if !resp.Sealed {
+			return nil
+		}
+		if resp.Progress == 0 {
+			return newError(ErrUnsealFailed, nil, "failed to migrate the seal of vault. progress reset to 0")
+		}
+	}
+	return newError(ErrUnsealFailed, nil, "failed to migrate the seal of vault, not enough keys in the key store")
+}
@@ -0,0 +1,52 @@
+package vault
+
+import (
+	"fmt"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+)
+
+// shareKeyNamer names the keys of a vault with ShareKeyStores, and routes the unseal and recovery
+// keys it names to the key stores of their shares, so every key is read from and written to its
+// own store whichever operation (Init, Unseal, Rekey, etc.) uses it
+type shareKeyNamer struct {
+	KeyNamer
+	shares *kv.Mux
+	stores int
+}
+
+func (n shareKeyNamer) UnsealKeyName(i int) string {
+	name := n.KeyNamer.UnsealKeyName(i)
+	n.shares.Route(name, n.store(i))
+	return name
+}
+
+func (n shareKeyNamer) RecoveryKeyName(i int) string {
+	name := n.KeyNamer.RecoveryKeyName(i)
+	n.shares.Route(name, n.store(i))
+	return name
+}
+
+// store returns the index of the key store of share i in the Mux, 0 is the key store of New
+func (n shareKeyNamer) store(i int) int {
+	return 1 + i%n.stores
+}
+
+// validateShareKeyStores checks that none of the ShareKeyStores holds as many shares as the
+// threshold, so a single compromised key store never yields a quorum
+func validateShareKeyStores(config *Config) error {
+	stores := len(config.ShareKeyStores)
+	if stores == 0 {
+		return nil
+	}
+	for i, store := range config.ShareKeyStores {
+		if store == nil {
+			return fmt.Errorf("share key store %d is nil", i)
+		}
+	}
+	if perStore := (config.SecretShares + stores - 1) / stores; perStore >= config.SecretThreshold {
+		return fmt.Errorf("%d of the %d shares would be stored in the same one of the %d share key stores, it must be less than the threshold of %d",
+			perStore, config.SecretShares, stores, config.SecretThreshold)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package vault
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// snapshotChecksumsFile is the file of a raft snapshot archive holding the SHA-256 sums of the others
+const snapshotChecksumsFile = "SHA256SUMS"
+
+// SaveSnapshot writes a raft snapshot of Vault, taken with the root token, to w
+func (v *vault) SaveSnapshot(w io.Writer) error {
+	clearToken, err := v.useRootToken()
+	if err != nil {
+		return err
+	}
+	defer clearToken()
+
+	resp, err := v.cl.RawRequest(v.cl.NewRequest("GET", "/v1/sys/storage/raft/snapshot"))
+	if err != nil {
+		return fmt.Errorf("error taking snapshot: %s", err.Error())
+	}
+	defer resp.Body.Close()
+
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return fmt.Errorf("error reading snapshot: %s", err.Error())
+	}
+	return nil
+}
+
+// RestoreSnapshot restores a raft snapshot read from r with the root token, force allows
+// restoring a snapshot of another Vault cluster (with different keys)
+func (v *vault) RestoreSnapshot(r io.Reader, force bool) error {
+	clearToken, err := v.useRootToken()
+	if err != nil {
+		return err
+	}
+	defer clearToken()
+
+	path := "/v1/sys/storage/raft/snapshot"
+	if force {
+		path = "/v1/sys/storage/raft/snapshot-force"
+	}
+	req := v.cl.NewRequest("POST", path)
+	req.Body = r
+
+	resp, err := v.cl.RawRequest(req)
+	if err != nil {
+		return fmt.Errorf("error restoring snapshot: %s", err.Error())
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// VerifySnapshot checks the files of a raft snapshot archive against the SHA-256 sums stored in it
+func VerifySnapshot(r io.Reader) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("invalid snapshot: %s", err.Error())
+	}
+	defer gz.Close()
+
+	sums := map[string]string{}
+	var expected map[string]string
+
+	archive := tar.NewReader(gz)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("invalid snapshot: %s", err.Error())
+		}
+
+		if header.Name == snapshotChecksumsFile {
+			expected = map[string]string{}
+			scanner := bufio.NewScanner(archive)
+			for scanner.Scan() {
+				fields := strings.Fields(scanner.Text())
+				if len(fields) != 2 {
+					return fmt.Errorf("invalid snapshot: malformed %s", snapshotChecksumsFile)
+				}
+				expected[fields[1]] = fields[0]
+			}
+			if err := scanner.Err(); err != nil {
+				return fmt.Errorf("invalid snapshot: %s", err.Error())
+			}
+			continue
+		}
+
+		hash := sha256.New()
+		if _, err := io.Copy(hash, archive); err != nil {
+			return fmt.Errorf("invalid snapshot: %s", err.Error())
+		}
+		sums[header.Name] = hex.EncodeToString(hash.Sum(nil))
+	}
+
+	if expected == nil {
+		return fmt.Errorf("invalid snapshot: %s is missing", snapshotChecksumsFile)
+	}
+	for name, sum := range expected {
+		if sums[name] != sum {
+			return fmt.Errorf("invalid snapshot: checksum mismatch of %s", name)
+		}
+	}
+	return nil
+}
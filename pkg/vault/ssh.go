@@ -0,0 +1,120 @@
+package vault
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/spf13/cast"
+)
+
+// sshField is the section of an ssh secret engine describing its CA and roles, which can't be
+// expressed by the generic configuration as the CA signing key can be set only once
+const sshField = "ssh"
+
+// The key types of the roles of an ssh secret engine
+const (
+	sshRoleCA  = "ca"
+	sshRoleOTP = "otp"
+)
+
+// configureSSH sets up the CA signing key of the ssh secret engine at path if it has none yet, then
+// writes its roles, the outcome of every step is recorded in the report, and the public key of the
+// CA in its SSHCAPublicKeys
+func (v *vault) configureSSH(path string, ssh map[string]interface{}, report *ConfigureReport) {
+	if ca, ok := ssh["ca"]; ok {
+		v.configureSSHCA(path, cast.ToStringMap(ca), report)
+	}
+
+	for _, roleData := range cast.ToSlice(ssh["roles"]) {
+		started := time.Now()
+		role := cast.ToStringMap(roleData)
+		rolePath := fmt.Sprintf("%s/roles/%s", path, role["name"])
+		role, existed, skip := v.existingResource(ResourceSecretEngineConfig, rolePath, role, started, report)
+		if skip {
+			continue
+		}
+		err := v.retryWrite(rolePath, role)
+		if err != nil {
+			err = fmt.Errorf("error putting %s ssh role into vault: %s", role["name"], err.Error())
+		}
+		report.add(ResourceSecretEngineConfig, rolePath, existed, started, err)
+	}
+}
+
+// configureSSHCA generates or imports the CA signing key of the ssh secret engine at path, unless it
+// has one already. The key pair is imported from private_key and public_key, or generated by Vault
+// with the rest of the fields as its parameters (e.g. key_type and key_bits).
+func (v *vault) configureSSHCA(path string, ca map[string]interface{}, report *ConfigureReport) {
+	started := time.Now()
+	caPath := path + "/config/ca"
+
+	publicKey, err := v.sshCAPublicKey(path)
+	if err != nil {
+		report.add(ResourceSecretEngineConfig, caPath, false, started, err)
+		return
+	}
+	if publicKey != "" {
+		v.logger().Debugf("%s has a CA signing key already", path)
+		report.skip(ResourceSecretEngineConfig, caPath, started)
+		report.addSSHCAPublicKey(path, publicKey)
+		return
+	}
+
+	params := ca
+	if getOrDefault(ca, "private_key") != "" {
+		v.logger().Infof("importing the CA signing key of %s", path)
+	} else {
+		v.logger().Infof("generating the CA signing key of %s", path)
+		params = withoutField(ca, "generate_signing_key")
+		params["generate_signing_key"] = true
+	}
+
+	var secret *api.Secret
+	err = v.retry(fmt.Sprintf("setting up the CA of %s", path), func() (err error) {
+		secret, err = v.cl.Logical().Write(caPath, params)
+		return err
+	})
+	if err != nil {
+		report.add(ResourceSecretEngineConfig, caPath, false, started, fmt.Errorf("error setting up the CA of %s: %s", path, err.Error()))
+		return
+	}
+	report.add(ResourceSecretEngineConfig, caPath, false, started, nil)
+
+	if secret != nil && secret.Data != nil {
+		publicKey = cast.ToString(secret.Data["public_key"])
+	}
+	if publicKey == "" {
+		if publicKey, err = v.sshCAPublicKey(path); err != nil {
+			v.logger().Warnf("the public key of the CA of %s couldn't be read: %s", path, err.Error())
+			return
+		}
+	}
+	v.logger().Infof("the public key of the CA of %s: %s", path, publicKey)
+	report.addSSHCAPublicKey(path, publicKey)
+}
+
+// sshCAPublicKey returns the public key of the CA of the ssh secret engine at path, it is empty if
+// the CA hasn't been set up yet
+func (v *vault) sshCAPublicKey(path string) (string, error) {
+	var publicKey string
+	err := v.retry(fmt.Sprintf("reading the CA of %s", path), func() error {
+		secret, err := v.cl.Logical().Read(path + "/config/ca")
+		if err != nil {
+			// Vault responds with an error instead of an empty response
+			if strings.Contains(err.Error(), "keys haven't been configured yet") {
+				return nil
+			}
+			return err
+		}
+		if secret != nil && secret.Data != nil {
+			publicKey = cast.ToString(secret.Data["public_key"])
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("error reading the CA of %s: %s", path, err.Error())
+	}
+	return publicKey, nil
+}
@@ -0,0 +1,135 @@
+package vault
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/banzaicloud/bank-vaults/pkg/logging"
+	"github.com/hashicorp/vault/api"
+	"github.com/spf13/cast"
+)
+
+// casField makes Configure write a startup secret only if it doesn't exist yet (true by default), with
+// the check-and-set option of the KV version 2 engines, so the secrets changed since then (e.g.
+// rotated credentials) aren't clobbered by the next configuration
+const casField = "cas"
+
+// kvVersionField is the version of a kv secret engine, a shorthand for its version option
+const kvVersionField = "version"
+
+// casMismatch is the error of Vault writing a KV version 2 secret with an outdated check-and-set version
+const casMismatch = "check-and-set parameter did not match the current version"
+
+// secretEngineOptions returns the options of a secret engine, with the version of a kv engine
+func secretEngineOptions(secretEngine map[string]interface{}) map[string]string {
+	options := getOrDefaultStringMapString(secretEngine, "options")
+	if version, ok := secretEngine[kvVersionField]; ok {
+		options["version"] = cast.ToString(version)
+	}
+	return options
+}
+
+// kvMount returns the path of the KV secret engine (with a trailing slash) which the path is in and
+// its version, 1 if its version option isn't set, 0 if the path isn't in a KV engine
+func kvMount(mounts map[string]*api.MountOutput, path string) (string, int) {
+	mount := ""
+	for mountPath := range mounts {
+		if strings.HasPrefix(path, mountPath) && len(mountPath) > len(mount) {
+			mount = mountPath
+		}
+	}
+	if mount == "" {
+		return "", 0
+	}
+	switch output := mounts[mount]; {
+	case output.Type == "kv" && output.Options["version"] == "2":
+		return mount, 2
+	case output.Type == "kv", output.Type == "generic":
+		return mount, 1
+	}
+	return mount, 0
+}
+
+// configureStartupSecrets writes the secrets of the startupSecrets section, e.g. the initial
+// credentials of the applications, after the secret engines have been mounted. The secrets of the
+// KV version 2 engines are written to their data/ paths.
+func (v *vault) configureStartupSecrets(secrets []map[string]interface{}, report *ConfigureReport) error {
+	if len(secrets) == 0 {
+		return nil
+	}
+
+	var mounts map[string]*api.MountOutput
+	err := v.retry("listing mounts", func() (err error) {
+		mounts, err = v.cl.Sys().ListMounts()
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error reading mounts from vault: %s", err.Error())
+	}
+
+	for _, secret := range secrets {
+		// The failures are recorded in the report
+		v.configureStartupSecret(secret, mounts, report)
+	}
+	return nil
+}
+
+// configureStartupSecret writes a secret of the startupSecrets section, or skips it if it exists
+// and it is written with check-and-set
+func (v *vault) configureStartupSecret(secret map[string]interface{}, mounts map[string]*api.MountOutput, report *ConfigureReport) {
+	started := time.Now()
+	path := strings.Trim(cast.ToString(secret["path"]), "/")
+	data := getOrDefaultStringMap(secret, "data")
+	cas := true
+	if value, ok := secret[casField]; ok {
+		cas = cast.ToBool(value)
+	}
+	// The values must not be logged, e.g. by the retries
+	for _, value := range data {
+		if value, ok := value.(string); ok {
+			logging.RegisterSecret(value)
+		}
+	}
+
+	mount, version := kvMount(mounts, path+"/")
+	if version == 2 {
+		dataPath := mount + "data/" + strings.TrimPrefix(path, mount)
+		payload := map[string]interface{}{"data": data}
+		if cas {
+			// Version 0 is written only if the secret doesn't exist
+			payload["options"] = map[string]interface{}{"cas": 0}
+		}
+		err := v.retryWrite(dataPath, payload)
+		if err != nil && cas && strings.Contains(err.Error(), casMismatch) {
+			v.logger().Debugf("startup secret %s exists already", path)
+			report.skip(ResourceStartupSecret, path, started)
+			return
+		}
+		if err != nil {
+			err = fmt.Errorf("error writing startup secret %s: %s", path, err.Error())
+		}
+		report.add(ResourceStartupSecret, path, false, started, err)
+		return
+	}
+
+	var existing *api.Secret
+	err := v.retry(fmt.Sprintf("reading %s", path), func() (err error) {
+		existing, err = v.cl.Logical().Read(path)
+		return err
+	})
+	if err != nil {
+		report.add(ResourceStartupSecret, path, false, started, fmt.Errorf("error reading startup secret %s: %s", path, err.Error()))
+		return
+	}
+	if existing != nil && cas {
+		v.logger().Debugf("startup secret %s exists already", path)
+		report.skip(ResourceStartupSecret, path, started)
+		return
+	}
+	err = v.retryWrite(path, data)
+	if err != nil {
+		err = fmt.Errorf("error writing startup secret %s: %s", path, err.Error())
+	}
+	report.add(ResourceStartupSecret, path, existing != nil, started, err)
+}
@@ -0,0 +1,143 @@
+package vault
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/banzaicloud/bank-vaults/pkg/tracing"
+	"github.com/hashicorp/vault/api"
+	"github.com/spf13/cast"
+)
+
+// The fields of the sys section, the cluster-wide settings of Vault
+const (
+	// sysAuditedHeadersField are the request headers written to the audit devices, e.g.
+	// [{"name": "X-Forwarded-For", "hmac": false}]
+	sysAuditedHeadersField = "audited_headers"
+	// sysCORSField is the CORS configuration, e.g. {"allowed_origins": ["https://example.com"]},
+	// it is disabled with {"enabled": false}
+	sysCORSField = "cors"
+	// sysUIHeadersField are the custom response headers of the UI, by their names
+	sysUIHeadersField = "ui_headers"
+	// sysTuneField are the tuning parameters of the secret engines and auth methods (auth/<path>)
+	// by their paths, e.g. {"secret": {"default_lease_ttl": "1h", "max_lease_ttl": "24h"}}
+	sysTuneField = "tune"
+	// sysControlGroupField is the configuration of the control groups, e.g. {"max_ttl": "24h"},
+	// which are supported by Vault Enterprise only
+	sysControlGroupField = "control_group"
+)
+
+// sysFields are the fields of the sys section in the order they are applied
+var sysFields = []string{sysAuditedHeadersField, sysCORSField, sysUIHeadersField, sysTuneField, sysControlGroupField}
+
+// configureSys applies the cluster-wide settings of the sys section, every outcome is recorded in
+// the report
+func (v *vault) configureSys(parent *tracing.Span, sys map[string]interface{}, report *ConfigureReport) (err error) {
+	if len(sys) == 0 {
+		return nil
+	}
+
+	span := v.tracer().StartSpan(parent, "vault.configureSys")
+	defer func() { span.End(err) }()
+
+	if headers, ok := sys[sysAuditedHeadersField]; ok {
+		if err := v.configureAuditedHeaders(cast.ToSlice(headers), report); err != nil {
+			return err
+		}
+	}
+
+	if cors, ok := sys[sysCORSField]; ok {
+		started := time.Now()
+		cors := cast.ToStringMap(cors)
+		var err error
+		if enabled, ok := cors["enabled"]; ok && !cast.ToBool(enabled) {
+			err = v.retry("disabling CORS", func() error {
+				_, err := v.cl.Logical().Delete("sys/config/cors")
+				return err
+			})
+		} else {
+			err = v.retryWrite("sys/config/cors", withoutField(cors, "enabled"))
+		}
+		if err != nil {
+			err = fmt.Errorf("error configuring CORS: %s", err.Error())
+		}
+		report.add(ResourceSysConfig, "sys/config/cors", true, started, err)
+	}
+
+	uiHeaders := cast.ToStringMap(sys[sysUIHeadersField])
+	for _, name := range sortedKeys(uiHeaders) {
+		started := time.Now()
+		path := "sys/config/ui/headers/" + name
+		err := v.retryWrite(path, map[string]interface{}{"values": cast.ToStringSlice(uiHeaders[name])})
+		if err != nil {
+			err = fmt.Errorf("error configuring UI header %s: %s", name, err.Error())
+		}
+		report.add(ResourceSysConfig, path, true, started, err)
+	}
+
+	tune := cast.ToStringMap(sys[sysTuneField])
+	for _, mount := range sortedKeys(tune) {
+		started := time.Now()
+		path := fmt.Sprintf("sys/mounts/%s/tune", strings.Trim(mount, "/"))
+		if strings.HasPrefix(mount, "auth/") {
+			path = fmt.Sprintf("sys/auth/%s/tune", strings.Trim(strings.TrimPrefix(mount, "auth/"), "/"))
+		}
+		err := v.retryWrite(path, cast.ToStringMap(tune[mount]))
+		if err != nil {
+			err = fmt.Errorf("error tuning %s: %s", mount, err.Error())
+		}
+		report.add(ResourceSysConfig, path, true, started, err)
+	}
+
+	if controlGroup, ok := sys[sysControlGroupField]; ok {
+		started := time.Now()
+		err := v.retryWrite("sys/config/control-group", cast.ToStringMap(controlGroup))
+		if err != nil {
+			err = fmt.Errorf("error configuring control groups (they are supported by Vault Enterprise only): %s", err.Error())
+		}
+		report.add(ResourceSysConfig, "sys/config/control-group", true, started, err)
+	}
+	return nil
+}
+
+// configureAuditedHeaders writes the audited request headers, the headers audited already with
+// the same hmac setting are skipped
+func (v *vault) configureAuditedHeaders(headers []interface{}, report *ConfigureReport) error {
+	var existing *api.Secret
+	err := v.retry("reading the audited headers", func() (err error) {
+		existing, err = v.cl.Logical().Read("sys/config/auditing/request-headers")
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error reading audited headers: %s", err.Error())
+	}
+	audited := map[string]interface{}{}
+	if existing != nil {
+		for name, header := range cast.ToStringMap(existing.Data["headers"]) {
+			// Vault returns the names in their canonical form
+			audited[strings.ToLower(name)] = header
+		}
+	}
+
+	for _, header := range headers {
+		started := time.Now()
+		header := cast.ToStringMap(header)
+		name := cast.ToString(header["name"])
+		hmac := cast.ToBool(header["hmac"])
+		path := "sys/config/auditing/request-headers/" + name
+
+		current, existed := audited[strings.ToLower(name)]
+		if existed && cast.ToBool(cast.ToStringMap(current)["hmac"]) == hmac {
+			v.logger().Debugf("%s header is audited already", name)
+			report.skip(ResourceSysConfig, path, started)
+			continue
+		}
+		err := v.retryWrite(path, map[string]interface{}{"hmac": hmac})
+		if err != nil {
+			err = fmt.Errorf("error configuring audited header %s: %s", name, err.Error())
+		}
+		report.add(ResourceSysConfig, path, existed, started, err)
+	}
+	return nil
+}
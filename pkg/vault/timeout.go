@@ -0,0 +1,130 @@
+package vault
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/banzaicloud/bank-vaults/pkg/securemem"
+)
+
+// TimeoutError is returned when an operation doesn't finish within its timeout in the Config
+type TimeoutError struct {
+	// Operation is what timed out, e.g. the configuration of Vault or a key store request
+	Operation string
+	Timeout   time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s timed out after %s", e.Operation, e.Timeout)
+}
+
+// withTimeout runs f and returns a *TimeoutError if it doesn't return within timeout, there is no
+// timeout if it is 0. The calls of the clients (e.g. of a KMS) can't be cancelled, so f is left
+// running in the background after a timeout and its result is dropped.
+func withTimeout(operation string, timeout time.Duration, f func() error) error {
+	if timeout <= 0 {
+		return f()
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- f() }()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		return &TimeoutError{Operation: operation, Timeout: timeout}
+	}
+}
+
+// withContext runs f and returns the error of ctx if it is done before f returns. The calls of the
+// clients can't be cancelled, so like after a timeout, f is left running in the background and its
+// result is dropped.
+func withContext(ctx context.Context, f func() error) error {
+	if ctx.Done() == nil {
+		return f()
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- f() }()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// deadline checks the remaining time of an operation with several steps, e.g. the configuration
+type deadline struct {
+	operation string
+	timeout   time.Duration
+	end       time.Time
+}
+
+func newDeadline(operation string, timeout time.Duration) *deadline {
+	return &deadline{operation: operation, timeout: timeout, end: time.Now().Add(timeout)}
+}
+
+// check returns a *TimeoutError if the deadline has passed before the given step
+func (d *deadline) check(step string) error {
+	if d.timeout <= 0 || time.Now().Before(d.end) {
+		return nil
+	}
+	return &TimeoutError{Operation: fmt.Sprintf("%s (before %s)", d.operation, step), Timeout: d.timeout}
+}
+
+// timeoutKV fails the requests to a key store which don't finish within the timeout
+type timeoutKV struct {
+	store   kv.Service
+	timeout time.Duration
+}
+
+func (t *timeoutKV) Get(key string) ([]byte, error) {
+	var value []byte
+	err := withTimeout(fmt.Sprintf("getting key '%s' from the key store", key), t.timeout, func() error {
+		var err error
+		value, err = t.store.Get(key)
+		return err
+	})
+	if _, ok := err.(*TimeoutError); ok {
+		// value is written by the request running in the background
+		return nil, err
+	}
+	return value, err
+}
+
+func (t *timeoutKV) Set(key string, value []byte) error {
+	// The caller may wipe the value after a timeout, while the request is still running
+	value = append([]byte{}, value...)
+	return withTimeout(fmt.Sprintf("setting key '%s' in the key store", key), t.timeout, func() error {
+		defer securemem.Wipe(value)
+		return t.store.Set(key, value)
+	})
+}
+
+func (t *timeoutKV) Create(key string, value []byte) error {
+	value = append([]byte{}, value...)
+	return withTimeout(fmt.Sprintf("creating key '%s' in the key store", key), t.timeout, func() error {
+		defer securemem.Wipe(value)
+		return kv.Create(t.store, key, value)
+	})
+}
+
+func (t *timeoutKV) Test(key string) error {
+	return withTimeout("testing the key store", t.timeout, func() error {
+		return t.store.Test(key)
+	})
+}
+
+func (t *timeoutKV) Delete(key string) error {
+	return withTimeout(fmt.Sprintf("deleting key '%s' from the key store", key), t.timeout, func() error {
+		return kv.Delete(t.store, key)
+	})
+}
@@ -0,0 +1,129 @@
+package vault
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/banzaicloud/bank-vaults/pkg/logging"
+)
+
+// HA modes of NodeStatus
+const (
+	HAModeActive   = "active"
+	HAModeStandby  = "standby"
+	HAModeDisabled = "disabled"
+)
+
+// NodeStatus is the state of a Vault node after UnsealAll, Error is set if it couldn't be unsealed
+// or queried
+type NodeStatus struct {
+	Address     string `json:"address"`
+	Error       string `json:"error,omitempty"`
+	Initialized bool   `json:"initialized"`
+	Sealed      bool   `json:"sealed"`
+	Progress    int    `json:"progress"`
+	Threshold   int    `json:"threshold"`
+	Version     string `json:"version,omitempty"`
+	// HAMode is active, standby or disabled, empty while the node is sealed
+	HAMode string `json:"haMode,omitempty"`
+}
+
+// UnsealAll unseals the Vault nodes of addresses concurrently with the keys of the key store, they
+// are nodes of the same cluster, the clients of the nodes are taken from clients. The uninitialized
+// nodes are skipped (e.g. the nodes waiting to join a raft cluster). The statuses are returned in
+// the order of addresses, an error is returned only if config is invalid.
+func UnsealAll(ctx context.Context, k kv.Service, clients *ClientPool, config Config, addresses []string) ([]NodeStatus, error) {
+	vaults := make([]*vault, len(addresses))
+	statuses := make([]NodeStatus, len(addresses))
+	for i, address := range addresses {
+		statuses[i].Address = address
+
+		cl, err := clients.Client(Endpoint{Address: address})
+		if err != nil {
+			statuses[i].Error = err.Error()
+			continue
+		}
+		nodeConfig := config
+		logger := logging.Default()
+		if config.Logger != nil {
+			logger = config.Logger
+		}
+		nodeConfig.Logger = logger.WithField("node", address)
+		v, err := New(k, cl, nodeConfig)
+		if err != nil {
+			return nil, err
+		}
+		vaults[i] = v.(*vault)
+	}
+
+	var wg sync.WaitGroup
+	for i, v := range vaults {
+		if v == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(v *vault, status *NodeStatus) {
+			defer wg.Done()
+			v.unsealNode(ctx, status)
+		}(v, &statuses[i])
+	}
+	wg.Wait()
+
+	return statuses, nil
+}
+
+// unsealNode unseals the node if it is initialized and sealed, and records its state in status
+func (v *vault) unsealNode(ctx context.Context, status *NodeStatus) {
+	initialized, err := v.cl.Sys().InitStatus()
+	if err != nil {
+		status.Error = fmt.Sprintf("error checking init status: %s", err.Error())
+		return
+	}
+	status.Initialized = initialized
+	if !initialized {
+		return
+	}
+
+	sealStatus, err := v.cl.Sys().SealStatus()
+	if err != nil {
+		status.Error = fmt.Sprintf("error checking status: %s", err.Error())
+		return
+	}
+	if sealStatus.Sealed {
+		if err := v.Unseal(ctx); err != nil {
+			status.Error = err.Error()
+		}
+		// The state of the node after the unseal, even if it has failed partway
+		if sealStatus, err = v.cl.Sys().SealStatus(); err != nil {
+			if status.Error == "" {
+				status.Error = fmt.Sprintf("error checking status: %s", err.Error())
+			}
+			return
+		}
+	}
+	status.Sealed = sealStatus.Sealed
+	status.Progress = sealStatus.Progress
+	status.Threshold = sealStatus.T
+	status.Version = sealStatus.Version
+
+	if sealStatus.Sealed {
+		return
+	}
+	leader, err := v.cl.Sys().Leader()
+	if err != nil {
+		if status.Error == "" {
+			status.Error = fmt.Sprintf("error checking the leader: %s", err.Error())
+		}
+		return
+	}
+	switch {
+	case !leader.HAEnabled:
+		status.HAMode = HAModeDisabled
+	case leader.IsSelf:
+		status.HAMode = HAModeActive
+	default:
+		status.HAMode = HAModeStandby
+	}
+}
@@ -1,24 +1,76 @@
 package vault
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"os"
-	"runtime"
+	"sort"
 	"strings"
 	"time"
 
+	"github.com/banzaicloud/bank-vaults/pkg/backoff"
 	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/banzaicloud/bank-vaults/pkg/logging"
+	"github.com/banzaicloud/bank-vaults/pkg/securemem"
+	"github.com/banzaicloud/bank-vaults/pkg/tracing"
 	"github.com/hashicorp/vault/api"
-	"github.com/sirupsen/logrus"
 	"github.com/spf13/cast"
-	"github.com/spf13/viper"
+	"golang.org/x/time/rate"
 )
 
 // DefaultConfigFile is the name of the default config file
 const DefaultConfigFile = "vault-config.yml"
 
+// ConfigHashAnnotation is the Pod annotation holding the hash of the last applied config file
+const ConfigHashAnnotation = "vault.banzaicloud.com/config-hash"
+
+// ConfigureResultAnnotation is the Pod annotation holding the result of the last configuration
+const ConfigureResultAnnotation = "vault.banzaicloud.com/configure-result"
+
+// ConfigureSummaryAnnotation is the Pod annotation holding the ConfigureSummary of the last configuration as JSON
+const ConfigureSummaryAnnotation = "vault.banzaicloud.com/configure-summary"
+
+// ConfigureResultSuccess is the value of ConfigureResultAnnotation after a successful configuration
+const ConfigureResultSuccess = "success"
+
+// ConfigurePauseFile pauses the configuration, the rekeying and the root token rotation while it
+// exists (e.g. during a backup), Vault is still unsealed
+const ConfigurePauseFile = "/tmp/bank-vaults-configure-paused"
+
+// ConfigurePaused returns whether ConfigurePauseFile exists
+func ConfigurePaused() bool {
+	_, err := os.Stat(ConfigurePauseFile)
+	return err == nil
+}
+
+// LastEventAnnotation is the Pod annotation holding the reason and time of the last lifecycle event
+const LastEventAnnotation = "vault.banzaicloud.com/last-event"
+
+// RootTokenKey is the default name of the root token in the key store
+const RootTokenKey = "vault-root"
+
+// createOnlyField makes Configure create a policy, an auth role or a secret engine configuration only
+// if it doesn't exist in Vault, so the changes made to it manually are preserved
+const createOnlyField = "create_only"
+
+// The fields of a secret engine which unmount it, or remount it from its old path
+const (
+	// secretEngineStateField is present by default, absent unmounts the secret engine
+	secretEngineStateField = "state"
+	secretEngineAbsent     = "absent"
+	// migrateFromField is the old path of a secret engine, it is remounted to its path if it isn't
+	// mounted there yet
+	migrateFromField = "migrate_from"
+)
+
+// rotateRootField makes Configure rotate the password of the root user of a connection of a database
+// secret engine right after creating it, so the password in the configuration is valid only until
+// then. The connection is created only, like with create_only, as its password in the configuration
+// is stale afterwards.
+const rotateRootField = "rotate_root"
+
 // Config holds the configuration of the Vault initialization
 type Config struct {
 	// how many key parts exist
@@ -30,6 +82,136 @@ type Config struct {
 	InitRootToken string
 	// should the root token be stored in the keyStore
 	StoreRootToken bool
+	// never persist a long-lived root token: Init unseals Vault with the new unseal keys (or waits for
+	// an auto-unseal seal) and revokes the initial root token instead of storing it (StoreRootToken is
+	// ignored), and the operations needing the root token generate a short-lived one with the stored
+	// keys (sys/generate-root) and revoke it after them. A stored root token which has been revoked falls back to the same even without it.
+	EphemeralRootToken bool
+	// least-privilege mode: Init creates the vault-configurer policy and a periodic token of it, stored
+	// in the key store, and Configure, Diff and Export use that token instead of the root token, so the
+	// root token can be sealed away. Init unseals Vault with the new unseal keys (or waits for an
+	// auto-unseal seal) to create it. The token is created with the root token on its first use if it
+	// is missing (e.g. Vault has been initialized without it) and replaced if it has been revoked.
+	ProvisionerToken bool
+	// key handoff mode: Init doesn't store the unseal or recovery keys (nor their metadata) in the key
+	// store, it response-wraps each of them in a single-use wrapping token of this TTL and returns the
+	// tokens in the WrappedKeys of the InitResult. Vault is unsealed with the unseal keys to wrap them.
+	// The stored keys are needed by Unseal, Rekey and EphemeralRootToken, so they don't work with it.
+	WrapKeysTTL time.Duration
+
+	// the names of the keys in the key store, the defaults (vault-unseal-N, vault-root, etc.) if empty
+	KeyNames KeyNames
+	// a custom naming scheme of the keys in the key store, KeyNames is ignored if set
+	KeyNamer KeyNamer
+
+	// the independent key stores (e.g. the KMSs of different cloud accounts) the unseal and recovery
+	// keys are distributed across instead of the key store of New: share i is stored in
+	// ShareKeyStores[i % len(ShareKeyStores)], so less than SecretThreshold shares are in any of them.
+	// The root token and the metadata of the keys are kept in the key store of New.
+	ShareKeyStores []kv.Service
+
+	// reject the external configuration in Configure if VerifyConfigStrict finds problems in it (e.g.
+	// unknown fields or wrong types), instead of ignoring the unknown fields, nothing is applied then
+	StrictConfig bool
+
+	// read back the resources from Vault after a successful Configure and compare them with the
+	// configuration, a random sample of ReadBackSample of them (all if 0), the mismatches are logged
+	// and returned in the ReadBack of the ConfigureReport
+	ReadBack       bool
+	ReadBackSample int
+
+	// delete the auth methods, secret engines and policies which aren't in the configuration from
+	// Vault after applying it, except for the built-in ones (the token auth method, the sys, cubbyhole
+	// and identity secret engines, and the root and default policies) and PurgeProtected, the paths
+	// of Diff, e.g. sys/auth/kubernetes, sys/mounts/secret or sys/policy/admin
+	PurgeUnmanaged bool
+	PurgeProtected []string
+
+	// keep the hashes of the entries of the generic configuration of the secret engines Configure has
+	// written in the key store (ConfigStateKey), and merge them with the configuration and Vault on
+	// the next run: the changed entries are updated, the unchanged ones only if they have drifted in
+	// Vault, and the removed ones are deleted, while the paths created by hand are left alone
+	TrackConfigState bool
+
+	// the history Configure records every successful configuration in, with the changes it has
+	// applied and HistoryActor as the actor, disabled if nil
+	History      *ConfigHistory
+	HistoryActor string
+
+	// the file holding the JWT used by Vault to review the tokens of the Kubernetes auth method,
+	// the token of the Pod's ServiceAccount if empty
+	TokenReviewerJWTFile string
+
+	// the cluster of the Kubernetes auth methods if Vault is configured from outside of it (e.g. from
+	// a laptop or a CI runner), instead of the cluster and the ServiceAccount of the Pod
+	KubernetesAuth *KubernetesAuthConfig
+
+	// the context of the waits and retries of the operations without a context argument (e.g.
+	// Configure), they return its error when it is cancelled, context.Background() if nil
+	Context context.Context
+
+	// the timeouts of the lifecycle actions, a *TimeoutError is returned after them, no timeout if 0:
+	// InitWaitTimeout of waiting for Vault to be unsealed during Init (to set up InitRootToken),
+	// UnsealTimeout of each unseal request, ConfigureTimeout of the whole Configure, and
+	// KVTimeout of each key store request
+	InitWaitTimeout  time.Duration
+	UnsealTimeout    time.Duration
+	ConfigureTimeout time.Duration
+	KVTimeout        time.Duration
+
+	// how many times the Vault and key store requests are retried if they fail with a
+	// *RetryableError (e.g. connection refused, 429 or 5xx), with an exponential backoff from
+	// RetryInitialInterval up to RetryMaxInterval (1s and 30s if 0) randomized by RetryJitter
+	Retries              int
+	RetryInitialInterval time.Duration
+	RetryMaxInterval     time.Duration
+	RetryJitter          float64
+	// the policies of the kinds of operations (e.g. RetryKeyStore) replacing the one above
+	RetryOverrides map[RetryOperation]RetryPolicy
+
+	// how many policies, auth roles, mappings and users Configure applies at a time, one by one if 0,
+	// they are recorded in the ConfigureReport in the order of the configuration nevertheless
+	ConfigureConcurrency int
+	// the client-side rate limit of the requests to Vault, RateLimit requests per second with bursts
+	// of RateBurst (1 if 0) requests, unlimited if 0
+	RateLimit float64
+	RateBurst int
+
+	// the tracer of the spans of the lifecycle actions, tracing.DefaultTracer if nil
+	Tracer *tracing.Tracer
+	// the logger of the lifecycle actions, logging.Default() if nil, the secrets are always redacted from its entries
+	Logger logging.Logger
+
+	// the hooks called before and after Init, Unseal, Configure and the phases of Configure, in order
+	Hooks []Hook
+}
+
+// InitResult describes what has been stored in the key store during the initialization of Vault
+type InitResult struct {
+	// AlreadyInitialized is true if Vault had been initialized before, nothing has been stored in this case
+	AlreadyInitialized bool `json:"alreadyInitialized"`
+	// UnsealKeys and RecoveryKeys are the key store IDs of the stored keys
+	UnsealKeys   []string `json:"unsealKeys,omitempty"`
+	RecoveryKeys []string `json:"recoveryKeys,omitempty"`
+	// SealType is the type of the seal of Vault, e.g. shamir or awskms
+	SealType string `json:"sealType,omitempty"`
+	// RootTokenKey is the key store ID of the root token, empty if it hasn't been stored
+	RootTokenKey string `json:"rootTokenKey,omitempty"`
+	// RootToken is the root token if it hasn't been stored nor set up with InitRootToken, it is never logged
+	RootToken string `json:"rootToken,omitempty"`
+	// WrappedKeys are the wrapping tokens of the keys handed off instead of being stored, with WrapKeysTTL
+	WrappedKeys []WrappedKey `json:"wrappedKeys,omitempty"`
+	// ProvisionerTokenKey is the key store ID of the provisioner token, empty if it hasn't been created
+	ProvisionerTokenKey string `json:"provisionerTokenKey,omitempty"`
+	// SecretShares and SecretThreshold are of the unseal keys, RecoveryShares and RecoveryThreshold of
+	// the recovery keys created instead of them with an auto-unseal seal
+	SecretShares      int `json:"secretShares,omitempty"`
+	SecretThreshold   int `json:"secretThreshold,omitempty"`
+	RecoveryShares    int `json:"recoveryShares,omitempty"`
+	RecoveryThreshold int `json:"recoveryThreshold,omitempty"`
+	// Duration is how long the initialization (including storing the keys) took, 0 if Vault had been
+	// initialized before
+	Duration time.Duration `json:"-"`
 }
 
 // vault is an implementation of the Vault interface that will perform actions
@@ -38,66 +220,270 @@ type vault struct {
 	keyStore kv.Service
 	cl       *api.Client
 	config   *Config
+	// keysVersion is the version of the stored keys, read from their metadata
+	keysVersion int
+	// shares routes the keys to the ShareKeyStores of the Config, nil without them
+	shares *kv.Mux
+	// limiter limits the rate of the requests to Vault, nil if they are unlimited
+	limiter *rate.Limiter
+	// namespace is the namespace the client sends the requests to, the root namespace if empty
+	namespace string
 }
 
 // Interface check
 var _ Vault = &vault{}
 
+// Initializer initializes Vault and stores its keys in the key store
+type Initializer interface {
+	Init(ctx context.Context) (*InitResult, error)
+}
+
+// Unsealer checks whether Vault is sealed and unseals it with the keys in the key store
+type Unsealer interface {
+	Sealed(ctx context.Context) (bool, error)
+	Unseal(ctx context.Context) error
+}
+
+// Configurer applies the external configuration to Vault, and compares it with the live state
+type Configurer interface {
+	Configure(config *ExternalConfig) error
+	ConfigureWithReport(config *ExternalConfig) (*ConfigureReport, error)
+	Diff(config *ExternalConfig) ([]ConfigChange, error)
+	Plan(config *ExternalConfig) ([]ConfigChange, error)
+	Export() (map[string]interface{}, error)
+}
+
+// Reconciler keeps Vault configured with the external configuration of a source, e.g. a file
+// changed by a ConfigMap update
+type Reconciler interface {
+	Reconcile(source ConfigSource) (*ConfigureReport, error)
+	Watch(ctx context.Context, source ConfigSource, options WatchOptions) error
+}
+
+// Rekeyer replaces the keys of Vault, e.g. periodically when they have reached a certain age
+type Rekeyer interface {
+	Rekey(options RekeyOptions) (*RekeyResult, error)
+	KeysMetadata() (*KeysMetadata, error)
+}
+
+// RootTokenRotator replaces the stored root token of Vault, e.g. periodically when it has reached
+// a certain age
+type RootTokenRotator interface {
+	RotateRootToken() error
+	RootTokenCreated() (time.Time, error)
+}
+
+// KeyStoreChecker checks whether the key store of Vault can be reached, e.g. for a readiness probe
+type KeyStoreChecker interface {
+	CheckKeyStore(ctx context.Context) error
+}
+
 // Vault is an interface that can be used to attempt to perform actions against
-// a Vault server.
+// a Vault server. It is composed of the focused interfaces, so the consumers which only unseal
+// Vault (and their mocks) can depend on Unsealer only.
 type Vault interface {
-	Sealed() (bool, error)
-	Unseal() error
-	Init() error
-	Configure() error
+	Initializer
+	Unsealer
+	Configurer
+	Reconciler
+	Rekeyer
+	RootTokenRotator
+	KeyStoreChecker
+	RaftJoiner
+	SealMigrator
+	Seal() error
+	SaveSnapshot(w io.Writer) error
+	RestoreSnapshot(r io.Reader, force bool) error
 }
 
-// New returns a new vault Vault, or an error.
+// New returns a new vault Vault, or an error. The key store may be nil if Vault is initialized
+// and unsealed externally, in this case only Sealed and Configure can be used, the latter with
+// the token of the client.
 func New(k kv.Service, cl *api.Client, config Config) (Vault, error) {
 
 	if config.SecretShares < config.SecretThreshold {
 		return nil, errors.New("the secret threshold can't be bigger than the shares")
 	}
 
+	if config.KeyNamer != nil {
+		if err := ValidateKeyNamer(config.KeyNamer); err != nil {
+			return nil, err
+		}
+	} else if err := config.KeyNames.Validate(); err != nil {
+		return nil, err
+	}
+
+	if config.WrapKeysTTL > 0 && (config.EphemeralRootToken || len(config.ShareKeyStores) > 0) {
+		return nil, errors.New("the keys can't be wrapped with an ephemeral root token or share key stores, which need the stored keys")
+	}
+
+	if err := validateShareKeyStores(&config); err != nil {
+		return nil, err
+	}
+	var shares *kv.Mux
+	if k != nil && len(config.ShareKeyStores) > 0 {
+		shares = kv.NewMux(append([]kv.Service{k}, config.ShareKeyStores...)...)
+		k = shares
+	}
+
+	if k != nil && config.KVTimeout > 0 {
+		k = &timeoutKV{store: k, timeout: config.KVTimeout}
+	}
+
 	return &vault{
 		keyStore: k,
 		cl:       cl,
 		config:   &config,
+		limiter:  newLimiter(&config),
+		shares:   shares,
 	}, nil
 }
 
-func (v *vault) Sealed() (bool, error) {
-	resp, err := v.cl.Sys().SealStatus()
+// CheckKeyStore tests the key store with the test key, it returns an error of the
+// ErrKeyStoreUnavailable kind if it fails, nil without a key store
+func (v *vault) CheckKeyStore(ctx context.Context) error {
+	if v.keyStore == nil {
+		return nil
+	}
+	err := withContext(ctx, func() error {
+		return v.keyStore.Test(v.testKey())
+	})
 	if err != nil {
+		return newError(ErrKeyStoreUnavailable, err, "error testing the key store")
+	}
+	return nil
+}
+
+// Sealed returns true if Vault is sealed, the error of ctx if it is done before Vault responds
+func (v *vault) Sealed(ctx context.Context) (bool, error) {
+	var resp *api.SealStatusResponse
+	err := v.retryContext(ctx, RetryVault, "checking the seal status", func() error {
+		return withContext(ctx, func() (err error) {
+			resp, err = v.cl.Sys().SealStatus()
+			return err
+		})
+	})
+	if ctx.Err() != nil {
+		return false, ctx.Err()
+	} else if err != nil {
 		return false, fmt.Errorf("error checking status: %s", err.Error())
 	}
 	return resp.Sealed, nil
 }
 
+// Seal seals the Vault node of the client with the root token, Vault refuses to seal standby nodes
+func (v *vault) Seal() error {
+	clearToken, err := v.useRootToken()
+	if err != nil {
+		return err
+	}
+	defer clearToken()
+
+	if err := v.cl.Sys().Seal(); err != nil {
+		return fmt.Errorf("error sealing vault: %s", err.Error())
+	}
+	return nil
+}
+
 // Unseal will attempt to unseal vault by retrieving keys from the kms service
 // and sending unseal requests to vault. It will return an error if retrieving
 // a key fails, or if the unseal progress is reset to 0 (indicating that a key)
-// was invalid.
-func (v *vault) Unseal() error {
-	defer runtime.GC()
+// was invalid. If the key store holds the metadata of the keys, they are validated
+// with it before sending them, and a *KeyValidationError is returned if they don't match. The error of
+// ctx is returned if it is done while waiting for the key store or Vault. It is a no-op if Vault uses
+// an auto-unseal seal (e.g. awskms), the stored keys are recovery keys, which can't unseal it.
+func (v *vault) Unseal(ctx context.Context) (err error) {
+	span := v.tracer().StartSpan(nil, "vault.Unseal")
+	defer func() { span.End(err) }()
+	defer func() { err = ClassifyError(err) }()
+
+	return v.withHooks(&HookEvent{Phase: HookPhaseUnseal}, func() error {
+		return v.unseal(ctx)
+	})
+}
+
+// unseal sends the unseal keys to Vault until it is unsealed
+func (v *vault) unseal(ctx context.Context) error {
+	var sealStatus *api.SealStatusResponse
+	err := v.retryContext(ctx, RetryVault, "checking the seal status", func() error {
+		return withContext(ctx, func() (err error) {
+			sealStatus, err = v.cl.Sys().SealStatus()
+			return err
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("error checking status: %s", err.Error())
+	}
+
+	// Vault unseals itself with an auto-unseal seal, it stays sealed only while the seal is unavailable
+	if sealStatus.RecoverySeal {
+		if sealStatus.Sealed {
+			v.logger().Warnf("vault uses the %s seal, waiting for it to unseal vault", sealStatus.Type)
+		} else {
+			v.logger().Debugf("vault uses the %s seal, it has been unsealed by it", sealStatus.Type)
+		}
+		return nil
+	}
+
+	var metadata *KeysMetadata
+	err = withContext(ctx, func() (err error) {
+		metadata, err = v.keysMetadata()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := metadata.validateSealStatus(sealStatus); err != nil {
+		return err
+	}
+
 	for i := 0; ; i++ {
 		keyID := v.unsealKeyForID(i)
 
-		logrus.Debugf("retrieving key from kms service...")
-		k, err := v.keyStore.Get(keyID)
+		v.logger().Debugf("retrieving key from kms service...")
+		var value []byte
+		err := v.retryKeyStore(ctx, fmt.Sprintf("getting key '%s'", keyID), func() error {
+			return withContext(ctx, func() (err error) {
+				value, err = v.keyStore.Get(keyID)
+				return err
+			})
+		})
 
-		if err != nil {
-			return fmt.Errorf("unable to get key '%s': %s", keyID, err.Error())
+		if _, ok := err.(*kv.NotFoundError); ok {
+			return newError(ErrUnsealFailed, err, "unable to get key '%s'", keyID)
+		} else if err != nil {
+			return newError(ErrKeyStoreUnavailable, err, "unable to get key '%s'", keyID)
+		}
+		key := securemem.New(value)
+		logging.RegisterSecretBytes(key.Bytes())
+
+		if err := metadata.validateKey(keyID, key.Bytes()); err != nil {
+			key.Destroy()
+			return err
 		}
 
-		logrus.Debugf("sending unseal request to vault...")
-		resp, err := v.cl.Sys().Unseal(string(k))
+		v.logger().Debugf("sending unseal request to vault...")
+		// The API client takes the key as a string, an immutable copy on the heap which can't be
+		// wiped, only the buffer is. The request may be left running after a timeout, so it can't
+		// use the buffer being destroyed.
+		unsealKey := string(key.Bytes())
+		key.Destroy()
+		var resp *api.SealStatusResponse
+		err = v.retryContext(ctx, RetryUnseal, "unseal request", func() error {
+			return withContext(ctx, func() error {
+				return withTimeout("unseal request", v.config.UnsealTimeout, func() error {
+					var err error
+					resp, err = v.cl.Sys().Unseal(unsealKey)
+					return err
+				})
+			})
+		})
 
 		if err != nil {
-			return fmt.Errorf("fail to send unseal request to vault: %s", err.Error())
+			return newError(ErrUnsealFailed, err, "fail to send unseal request to vault")
 		}
 
-		logrus.Debugf("got unseal response: %+v", *resp)
+		v.logger().Debugf("got unseal response: sealed: %t, progress: %d/%d", resp.Sealed, resp.Progress, resp.T)
 
 		if !resp.Sealed {
 			return nil
@@ -105,52 +491,91 @@ func (v *vault) Unseal() error {
 
 		// if progress is 0, we failed to unseal vault.
 		if resp.Progress == 0 {
-			return fmt.Errorf("failed to unseal vault. progress reset to 0")
+			return newError(ErrUnsealFailed, nil, "failed to unseal vault. progress reset to 0")
 		}
 	}
 }
 
 func (v *vault) keyStoreNotFound(key string) (bool, error) {
-	_, err := v.keyStore.Get(key)
+	err := v.retryKeyStore(v.context(), fmt.Sprintf("getting key '%s'", key), func() error {
+		_, err := v.keyStore.Get(key)
+		return err
+	})
 	if _, ok := err.(*kv.NotFoundError); ok {
 		return true, nil
 	}
 	return false, err
 }
 
+// keyStoreSet stores a key created by Init, with a conditional write if the key store supports it,
+// so of two racing Init attempts only one can store the keys
 func (v *vault) keyStoreSet(key string, val []byte) error {
-	notFound, err := v.keyStoreNotFound(key)
-	if notFound {
-		return v.keyStore.Set(key, val)
-	} else if err == nil {
-		return fmt.Errorf("error setting key '%s': it already exists", key)
-	} else {
-		return fmt.Errorf("error setting key '%s': %s", key, err.Error())
+	err := v.retryCreate(key, val)
+	if _, ok := err.(*kv.AlreadyExistsError); ok {
+		return &Error{Kind: ErrKeyStoreExists, Message: fmt.Sprintf("error setting key '%s': it already exists", key), Err: err}
+	} else if err != nil {
+		return newError(ErrKeyStoreUnavailable, err, "error setting key '%s'", key)
 	}
+	return nil
 }
 
-// Init initializes Vault if is not initialized already
-func (v *vault) Init() error {
-	initialized, err := v.cl.Sys().InitStatus()
-	if err != nil {
-		return fmt.Errorf("error testing if vault is initialized: %s", err.Error())
+// Init initializes Vault if is not initialized already. The error of ctx is returned if it is done
+// before the init request is sent, or while waiting for Vault to be unsealed to set up the init root
+// token. The init request and the storing of the keys are never abandoned, as the keys would be lost.
+func (v *vault) Init(ctx context.Context) (_ *InitResult, err error) {
+	span := v.tracer().StartSpan(nil, "vault.Init")
+	defer func() { span.End(err) }()
+
+	var initialized bool
+	err = v.retryContext(ctx, RetryVault, "checking the init status", func() error {
+		return withContext(ctx, func() (err error) {
+			initialized, err = v.cl.Sys().InitStatus()
+			return err
+		})
+	})
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	} else if err != nil {
+		return nil, fmt.Errorf("error testing if vault is initialized: %s", err.Error())
 	}
 	if initialized {
-		logrus.Info("vault is already initialized")
-		return nil
+		v.logger().Infof("vault is already initialized")
+		return &InitResult{AlreadyInitialized: true}, nil
 	}
 
-	logrus.Info("initializing vault")
+	v.logger().Infof("initializing vault")
+
+	started := time.Now()
+	var result *InitResult
+	err = v.withHooks(&HookEvent{Phase: HookPhaseInit}, func() (err error) {
+		result, err = v.initialize(ctx)
+		return err
+	})
+	if result != nil {
+		result.Duration = time.Since(started)
+	}
+	return result, err
+}
 
+// initialize initializes Vault and stores its keys, Init has checked that it isn't initialized yet
+func (v *vault) initialize(ctx context.Context) (_ *InitResult, err error) {
 	// test backend first
-	err = v.keyStore.Test(v.testKey())
+	err = v.retryKeyStore(ctx, "testing the key store", func() error {
+		return withContext(ctx, func() error {
+			return v.keyStore.Test(v.testKey())
+		})
+	})
 	if err != nil {
-		return fmt.Errorf("error testing keystore before init: %s", err.Error())
+		return nil, newError(ErrKeyStoreUnavailable, err, "error testing keystore before init")
 	}
 
+	// The keys of a new Vault are of the first version
+	v.keysVersion = 0
+
 	// test for an existing keys
 	keys := []string{
 		v.rootTokenKey(),
+		v.keysMetadataKey(),
 	}
 
 	// add unseal keys
@@ -160,409 +585,1411 @@ func (v *vault) Init() error {
 
 	// test every key
 	for _, key := range keys {
-		notFound, err := v.keyStoreNotFound(key)
+		var notFound bool
+		err := withContext(ctx, func() (err error) {
+			notFound, err = v.keyStoreNotFound(key)
+			return err
+		})
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 		if notFound && err != nil {
-			return fmt.Errorf("error before init: checking key '%s' failed: %s", key, err.Error())
+			return nil, newError(ErrKeyStoreUnavailable, err, "error before init: checking key '%s' failed", key)
 		} else if !notFound && err == nil {
-			return fmt.Errorf("error before init: keystore value for '%s' already exists", key)
+			return nil, newError(ErrKeyStoreExists, nil, "error before init: keystore value for '%s' already exists", key)
 		}
 	}
 
-	resp, err := v.cl.Sys().Init(&api.InitRequest{
+	var sealStatus *api.SealStatusResponse
+	err = v.retryContext(ctx, RetryVault, "checking the seal type", func() error {
+		return withContext(ctx, func() (err error) {
+			sealStatus, err = v.cl.Sys().SealStatus()
+			return err
+		})
+	})
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	} else if err != nil {
+		return nil, fmt.Errorf("error checking the seal type of vault: %s", err.Error())
+	}
+
+	initRequest := &api.InitRequest{
 		SecretShares:    v.config.SecretShares,
 		SecretThreshold: v.config.SecretThreshold,
-	})
+	}
 
-	if err != nil {
-		return fmt.Errorf("error initializing vault: %s", err.Error())
+	// Vault unseals itself with an auto-unseal seal (e.g. an HSM), the master key is stored
+	// by the seal and the configured shares are used for the recovery key instead
+	if sealStatus.RecoverySeal {
+		v.logger().Infof("vault uses the %s seal, creating recovery keys instead of unseal keys", sealStatus.Type)
+		initRequest = &api.InitRequest{
+			SecretShares:      1,
+			SecretThreshold:   1,
+			StoredShares:      1,
+			RecoveryShares:    v.config.SecretShares,
+			RecoveryThreshold: v.config.SecretThreshold,
+		}
 	}
 
-	for i, k := range resp.Keys {
-		keyID := v.unsealKeyForID(i)
-		err := v.keyStoreSet(keyID, []byte(k))
+	// Past this point the keys only exist in the response, so it is waited for even if ctx is done
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	resp, err := v.cl.Sys().Init(initRequest)
 
-		if err != nil {
-			return fmt.Errorf("error storing unseal key '%s': %s", keyID, err.Error())
+	// Another replica may have initialized Vault since it has been checked
+	if err != nil && strings.Contains(err.Error(), "already initialized") {
+		return nil, newError(ErrAlreadyInitialized, err, "error initializing vault")
+	} else if err != nil {
+		return nil, fmt.Errorf("error initializing vault: %s", err.Error())
+	}
+
+	for _, keys := range [][]string{resp.Keys, resp.KeysB64, resp.RecoveryKeys, resp.RecoveryKeysB64} {
+		for _, k := range keys {
+			logging.RegisterSecret(k)
+		}
+	}
+	logging.RegisterSecret(resp.RootToken)
+	logging.RegisterSecret(v.config.InitRootToken)
+
+	result := &InitResult{SealType: sealStatus.Type}
+	if sealStatus.RecoverySeal {
+		result.RecoveryShares, result.RecoveryThreshold = v.config.SecretShares, v.config.SecretThreshold
+	} else {
+		result.SecretShares, result.SecretThreshold = v.config.SecretShares, v.config.SecretThreshold
+	}
+
+	// Vault is unsealed right after the initialization only if something needs it during Init
+	unsealed := false
+	unseal := func(what string) error {
+		if unsealed {
+			return nil
 		}
+		if err := v.unsealInitialized(ctx, resp, sealStatus.RecoverySeal, what); err != nil {
+			return err
+		}
+		unsealed = true
+		return nil
+	}
 
-		logrus.WithField("key", keyID).Info("unseal key stored in key store")
+	if v.config.WrapKeysTTL > 0 {
+		// Nothing about the keys is kept in the key store, not even their metadata
+		result.WrappedKeys, err = v.handOffKeys(ctx, resp, sealStatus.RecoverySeal)
+		unsealed = err == nil
+		if err != nil {
+			return nil, err
+		}
+	} else if err := v.storeInitKeys(resp, sealStatus.Type, result); err != nil {
+		return nil, err
 	}
 
 	rootToken := resp.RootToken
 
 	// this sets up a predefined root token
 	if v.config.InitRootToken != "" {
-		logrus.Info("setting up init root token, waiting for vault to be unsealed")
-
-		count := 0
-		wait := time.Second * 2
-		for {
-			sealed, err := v.Sealed()
-			if !sealed {
-				break
-			}
-			if err == nil {
-				logrus.Info("vault still sealed, wait for unsealing")
-			} else {
-				logrus.Infof("vault not reachable: %s", err.Error())
-			}
+		v.logger().Infof("setting up init root token, waiting for vault to be unsealed")
 
-			count++
-			time.Sleep(wait)
+		if err := v.waitUnsealed(ctx, "set up the init root token"); err != nil {
+			return nil, err
 		}
+		unsealed = true
 
 		// use temporary token
 		v.cl.SetToken(resp.RootToken)
 
 		// setup root token with provided key
-		_, err := v.cl.Auth().Token().CreateOrphan(&api.TokenCreateRequest{
+		_, err = v.cl.Auth().Token().CreateOrphan(&api.TokenCreateRequest{
 			ID:          v.config.InitRootToken,
 			Policies:    []string{"root"},
 			DisplayName: "root-token",
 			NoParent:    true,
 		})
 		if err != nil {
-			return fmt.Errorf("unable to setup requested root token, (temporary root token: '%s'): %s", resp.RootToken, err)
+			return nil, fmt.Errorf("unable to setup requested root token, the temporary root token is still valid: %s", err.Error())
 		}
 
 		// revoke the temporary token
 		err = v.cl.Auth().Token().RevokeSelf(resp.RootToken)
 		if err != nil {
-			return fmt.Errorf("unable to revoke temporary root token: %s", err.Error())
+			return nil, fmt.Errorf("unable to revoke temporary root token: %s", err.Error())
 		}
 
 		rootToken = v.config.InitRootToken
 	}
 
-	if v.config.StoreRootToken {
+	// The keys are stored already, so the token is created on its first use if this fails
+	if v.config.ProvisionerToken {
+		if err := unseal("create the provisioner token"); err != nil {
+			v.logger().Warnf("unable to create the provisioner token, it is created on its first use: %s", err.Error())
+		} else {
+			v.cl.SetToken(rootToken)
+			_, err := v.createProvisionerToken(false)
+			v.cl.SetToken("")
+			if err != nil {
+				v.logger().Warnf("unable to create the provisioner token, it is created on its first use: %s", err.Error())
+			} else {
+				result.ProvisionerTokenKey = v.provisionerTokenKey()
+			}
+		}
+	}
+
+	if v.config.EphemeralRootToken {
+		if v.config.InitRootToken == "" {
+			if err := unseal("revoke the initial root token"); err != nil {
+				return nil, fmt.Errorf("unable to revoke the initial root token, it is still valid: %s", err.Error())
+			}
+			v.cl.SetToken(resp.RootToken)
+			err = v.cl.Auth().Token().RevokeSelf(resp.RootToken)
+			v.cl.SetToken("")
+			if err != nil {
+				return nil, fmt.Errorf("unable to revoke the initial root token: %s", err.Error())
+			}
+		}
+		v.logger().Infof("root token won't be stored in key store, short-lived root tokens are generated with the stored keys when needed")
+	} else if v.config.StoreRootToken {
 		rootTokenKey := v.rootTokenKey()
-		if err = v.keyStoreSet(rootTokenKey, []byte(resp.RootToken)); err != nil {
-			return fmt.Errorf("error storing root token '%s' in key'%s'", rootToken, rootTokenKey)
+		value := []byte(resp.RootToken)
+		err = v.keyStoreSet(rootTokenKey, value)
+		securemem.Wipe(value)
+		if err != nil {
+			return nil, fmt.Errorf("error storing root token in key '%s': %s", rootTokenKey, err.Error())
 		}
-		logrus.WithField("key", rootTokenKey).Info("root token stored in key store")
+		result.RootTokenKey = rootTokenKey
+		v.logger().WithField("key", rootTokenKey).Infof("root token stored in key store")
 	} else if v.config.InitRootToken == "" {
-		logrus.WithField("root-token", resp.RootToken).Warnf("won't store root token in key store, this token grants full privileges to vault, so keep this secret")
+		result.RootToken = rootToken
+		v.logger().Warnf("won't store root token in key store, it is only returned in the init result, this token grants full privileges to vault, so keep this secret")
 	}
 
-	return nil
+	return result, nil
 }
 
-func (v *vault) Configure() error {
-	logrus.Debugf("retrieving key from kms service...")
+// storeInitKeys stores the keys of the initialization and their metadata in the key store
+func (v *vault) storeInitKeys(resp *api.InitResponse, sealType string, result *InitResult) error {
+	metadata := newKeysMetadata(sealType, v.config.SecretShares, v.config.SecretThreshold)
 
-	rootToken, err := v.keyStore.Get(v.rootTokenKey())
-	if err != nil {
-		return fmt.Errorf("unable to get key '%s': %s", v.rootTokenKey(), err.Error())
-	}
+	for i, k := range resp.RecoveryKeys {
+		keyID := v.recoveryKeyForID(i)
+		value := []byte(k)
+		metadata.add(keyID, value)
+		err := v.keyStoreSet(keyID, value)
+		securemem.Wipe(value)
 
-	v.cl.SetToken(string(rootToken))
+		if err != nil {
+			return fmt.Errorf("error storing recovery key '%s': %s", keyID, err.Error())
+		}
 
-	// Clear the token and GC it
-	defer runtime.GC()
-	defer v.cl.SetToken("")
-	defer func() { rootToken = nil }()
+		result.RecoveryKeys = append(result.RecoveryKeys, keyID)
+		v.logger().WithField("key", keyID).Infof("recovery key stored in key store")
+	}
 
-	existingAuths, err := v.cl.Sys().ListAuth()
+	for i, k := range resp.Keys {
+		keyID := v.unsealKeyForID(i)
+		value := []byte(k)
+		metadata.add(keyID, value)
+		err := v.keyStoreSet(keyID, value)
+		securemem.Wipe(value)
 
-	if err != nil {
-		return fmt.Errorf("error listing auth backends vault: %s", err.Error())
-	}
+		if err != nil {
+			return fmt.Errorf("error storing unseal key '%s': %s", keyID, err.Error())
+		}
 
-	authMethods := []map[string]interface{}{}
-	err = viper.UnmarshalKey("auth", &authMethods)
-	if err != nil {
-		return fmt.Errorf("error unmarshalling vault auth methods config: %s", err.Error())
+		result.UnsealKeys = append(result.UnsealKeys, keyID)
+		v.logger().WithField("key", keyID).Infof("unseal key stored in key store")
 	}
-	for _, authMethod := range authMethods {
-		authMethodType := authMethod["type"].(string)
 
-		path := authMethodType
-		if pathOverwrite, ok := authMethod["path"]; ok {
-			path = pathOverwrite.(string)
+	// The unseal keys are validated with their metadata before they are sent to Vault
+	return v.storeKeysMetadata(metadata, false)
+}
+
+// waitUnsealed waits for Vault to be unsealed by the unsealer during Init to do what
+func (v *vault) waitUnsealed(ctx context.Context, what string) error {
+	err := backoff.Wait(ctx, backoff.Default(v.config.InitWaitTimeout), func() (bool, error) {
+		sealed, err := v.Sealed(ctx)
+		return !sealed, err
+	}, func(err error, next time.Duration) {
+		if err == nil {
+			v.logger().Infof("vault still sealed, checking again in %s", next)
+		} else {
+			v.logger().Infof("vault not reachable: %s, checking again in %s", err.Error(), next)
 		}
+	})
+	if err == backoff.ErrTimeout {
+		return &TimeoutError{Operation: "waiting for vault to be unsealed to " + what, Timeout: v.config.InitWaitTimeout}
+	} else if err != nil {
+		return fmt.Errorf("error waiting for vault to be unsealed to %s: %s", what, err.Error())
+	}
+	return nil
+}
 
-		// Check and skip existing auth mounts
-		exists := false
-		if authMount, ok := existingAuths[path+"/"]; ok {
-			if authMount.Type == authMethodType {
-				logrus.Debugf("%s auth backend is already mounted in vault", authMethodType)
-				exists = true
-			}
+// useRootToken sets the root token from the key store on the client, the returned function clears it.
+// Without a key store Vault is managed externally, the client's own token is used.
+func (v *vault) useRootToken() (func(), error) {
+	if v.keyStore == nil {
+		if v.cl.Token() == "" {
+			return nil, fmt.Errorf("no key store and no vault token to use")
 		}
+		return func() {}, nil
+	}
 
-		if !exists {
-			logrus.Debugf("enabling %s auth backend in vault...", authMethodType)
+	if v.config.EphemeralRootToken {
+		return v.useGeneratedRootToken()
+	}
 
-			// https://www.vaultproject.io/api/system/auth.html
-			options := api.EnableAuthOptions{
-				Type: authMethodType,
-			}
+	v.logger().Debugf("retrieving key from kms service...")
 
-			err := v.cl.Sys().EnableAuthWithOptions(path, &options)
+	var value []byte
+	err := v.retryKeyStore(v.context(), fmt.Sprintf("getting key '%s'", v.rootTokenKey()), func() error {
+		var err error
+		value, err = v.keyStore.Get(v.rootTokenKey())
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get key '%s': %s", v.rootTokenKey(), err.Error())
+	}
+	rootToken := securemem.New(value)
 
-			if err != nil {
-				return fmt.Errorf("error enabling %s auth method for vault: %s", authMethodType, err.Error())
-			}
-		}
+	logging.RegisterSecretBytes(rootToken.Bytes())
+	// The client keeps a string copy of the token until it is cleared, which can't be wiped
+	v.cl.SetToken(string(rootToken.Bytes()))
 
-		switch authMethodType {
-		case "kubernetes":
-			err = v.kubernetesAuthConfig(path)
-			if err != nil {
-				return fmt.Errorf("error configuring kubernetes auth for vault: %s", err.Error())
-			}
-			roles := authMethod["roles"].([]interface{})
-			err = v.configureKubernetesRoles(roles)
-			if err != nil {
-				return fmt.Errorf("error configuring kubernetes auth roles for vault: %s", err.Error())
-			}
-		case "github":
-			config := cast.ToStringMap(authMethod["config"])
-			err = v.configureGithubConfig(config)
-			if err != nil {
-				return fmt.Errorf("error configuring github auth for vault: %s", err.Error())
-			}
-			mappings := cast.ToStringMap(authMethod["map"])
-			err = v.configureGithubMappings(mappings)
-			if err != nil {
-				return fmt.Errorf("error configuring github mappings for vault: %s", err.Error())
-			}
-		case "aws":
-			config := cast.ToStringMap(authMethod["config"])
-			err = v.configureAwsConfig(config)
-			if err != nil {
-				return fmt.Errorf("error configuring aws auth for vault: %s", err.Error())
-			}
-			roles := authMethod["roles"].([]interface{})
-			err = v.configureAwsRoles(roles)
-			if err != nil {
-				return fmt.Errorf("error configuring aws auth roles for vault: %s", err.Error())
-			}
-		case "ldap":
-			config := cast.ToStringMap(authMethod["config"])
-			err = v.configureLdapConfig(config)
-			if err != nil {
-				return fmt.Errorf("error configuring ldap auth for vault: %s", err.Error())
-			}
-			groups := cast.ToStringMap(authMethod["groups"])
-			err = v.configureLdapMappings("groups", groups)
-			if err != nil {
-				return fmt.Errorf("error configuring ldap groups for vault: %s", err.Error())
-			}
-			users := cast.ToStringMap(authMethod["users"])
-			err = v.configureLdapMappings("users", users)
-			if err != nil {
-				return fmt.Errorf("error configuring ldap users for vault: %s", err.Error())
-			}
-		}
+	// Clear the token and wipe it
+	clearToken := func() {
+		v.cl.SetToken("")
+		rootToken.Destroy()
 	}
 
-	err = v.configurePolicies()
-	if err != nil {
-		return fmt.Errorf("error configuring policies for vault: %s", err.Error())
+	// A revoked or expired root token is replaced by a short-lived one for this operation
+	if _, err := v.cl.Auth().Token().LookupSelf(); err != nil && isPermissionDenied(err) {
+		clearToken()
+		v.logger().Warnf("the stored root token is revoked or expired, generating a short-lived one with the stored keys: %s", err.Error())
+		return v.useGeneratedRootToken()
 	}
+	return clearToken, nil
+}
 
-	err = v.configureSecretEngines()
+// useGeneratedRootToken generates a short-lived root token with the stored keys and sets it on the
+// client, the returned function revokes and clears it
+func (v *vault) useGeneratedRootToken() (func(), error) {
+	rootToken, err := v.generateRootToken()
 	if err != nil {
-		return fmt.Errorf("error configuring secret engines for vault: %s", err.Error())
+		return nil, fmt.Errorf("error generating a short-lived root token: %s", err.Error())
 	}
+	v.cl.SetToken(rootToken)
 
-	return err
+	return func() {
+		if err := v.cl.Auth().Token().RevokeSelf(rootToken); err != nil {
+			v.logger().Warnf("error revoking the short-lived root token: %s", err.Error())
+		}
+		v.cl.SetToken("")
+	}, nil
 }
 
-func (*vault) unsealKeyForID(i int) string {
-	return fmt.Sprint("vault-unseal-", i)
+// Configure applies the external configuration, see ConfigureWithReport
+func (v *vault) Configure(config *ExternalConfig) error {
+	_, err := v.ConfigureWithReport(config)
+	return err
 }
 
-func (*vault) rootTokenKey() string {
-	return fmt.Sprint("vault-root")
+// ConfigureWithReport applies the external configuration, and reports the outcome of every auth
+// method, role, mapping, policy, secret engine and secret engine configuration. A failing resource
+// doesn't stop the others from being applied, a *ConfigureError is returned if any of them failed.
+// Only the errors affecting every resource (e.g. a timeout) abort the configuration, or a pre hook
+// of the configuration or one of its phases with a *HookError.
+func (v *vault) ConfigureWithReport(config *ExternalConfig) (report *ConfigureReport, err error) {
+	span := v.tracer().StartSpan(nil, "vault.Configure")
+	defer func() { span.End(err) }()
+	defer func() { err = ClassifyError(err) }()
+
+	report = &ConfigureReport{Started: time.Now()}
+	defer func() { report.Duration = time.Since(report.Started) }()
+
+	event := &HookEvent{Phase: HookPhaseConfigure, Config: config}
+	err = v.withHooks(event, func() error {
+		event.Report = report
+		return v.configure(span, config, report)
+	})
+	return report, err
 }
 
-func (*vault) testKey() string {
-	return fmt.Sprint("vault-test")
-}
+// configure applies the external configuration for ConfigureWithReport, recording the outcome of
+// the resources in the report, the spans of its phases are the children of span
+func (v *vault) configure(span *tracing.Span, config *ExternalConfig, report *ConfigureReport) error {
+	configured := newDeadline("configuring vault", v.config.ConfigureTimeout)
 
-func (v *vault) kubernetesAuthConfig(path string) error {
-	kubernetesCACert, err := ioutil.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/ca.crt")
-	if err != nil {
-		return err
-	}
-	tokenReviewerJWT, err := ioutil.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/token")
-	if err != nil {
-		return err
+	if v.config.StrictConfig {
+		if errs := VerifyConfigStrict(config.sections()); len(errs) > 0 {
+			return ConfigErrors(errs)
+		}
 	}
-	config := map[string]interface{}{
-		"kubernetes_host":    fmt.Sprint("https://", os.Getenv("KUBERNETES_SERVICE_HOST")),
-		"kubernetes_ca_cert": string(kubernetesCACert),
-		"token_reviewer_jwt": string(tokenReviewerJWT),
+
+	// The unmanaged resources are purged from a single namespace, but which one would be ambiguous
+	namespaces := config.namespaces()
+	if v.config.PurgeUnmanaged && len(namespaces) > 0 {
+		return fmt.Errorf("purging the unmanaged resources isn't supported with namespaces: %s", strings.Join(namespaces, ", "))
 	}
-	_, err = v.cl.Logical().Write(fmt.Sprintf("auth/%s/config", path), config)
-	return err
-}
 
-func (v *vault) configurePolicies() error {
-	policies := []map[string]string{}
-	err := viper.UnmarshalKey("policies", &policies)
+	clearToken, err := v.useConfigurerToken()
 	if err != nil {
-		return fmt.Errorf("error unmarshalling vault policy config: %s", err.Error())
+		return err
 	}
-
-	for _, policy := range policies {
-		err := v.cl.Sys().PutPolicy(policy["name"], policy["rules"])
-
-		if err != nil {
-			return fmt.Errorf("error putting %s policy into vault: %s", policy["name"], err.Error())
+	defer clearToken()
+
+	// The changes are compared before applying them, the configuration is recorded without them
+	// if they can't be (e.g. the token can't read everything)
+	var changes []ConfigChange
+	if v.config.History != nil {
+		if changes, err = v.diff(config); err != nil {
+			v.logger().Warnf("error comparing vault configuration for the history: %s", err.Error())
 		}
 	}
 
-	return nil
-}
-
-func (v *vault) configureKubernetesRoles(roles []interface{}) error {
-	for _, roleInterface := range roles {
-		role := cast.ToStringMap(roleInterface)
-		_, err := v.cl.Logical().Write(fmt.Sprint("auth/kubernetes/role/", role["name"]), role)
-
+	// The audit devices are enabled first, so the rest of the configuration is audited
+	err = v.configurePhase(HookPhaseConfigureAudit, config, report, func() error {
+		if err := configured.check("configuring the audit devices"); err != nil {
+			return err
+		}
+		err := v.configureAuditDevices(span, config.Audit, report)
 		if err != nil {
-			return fmt.Errorf("error putting %s kubernetes role into vault: %s", role["name"], err.Error())
+			return fmt.Errorf("error configuring audit devices for vault: %s", err.Error())
 		}
-	}
-	return nil
-}
-
-func (v *vault) configureGithubConfig(config map[string]interface{}) error {
-	// https://www.vaultproject.io/api/auth/github/index.html
-	_, err := v.cl.Logical().Write("auth/github/config", config)
-
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("error putting %s github config into vault: %s", config, err.Error())
+		return err
 	}
-	return nil
-}
 
-func (v *vault) configureGithubMappings(mappings map[string]interface{}) error {
-	for mappingType, mapping := range mappings {
-		for userOrTeam, policy := range cast.ToStringMapString(mapping) {
-			_, err := v.cl.Logical().Write(fmt.Sprintf("auth/github/map/%s/%s", mappingType, userOrTeam), map[string]interface{}{"value": policy})
-			if err != nil {
-				return fmt.Errorf("error putting %s github mapping into vault: %s", mappingType, err.Error())
-			}
+	// The namespaces of Vault Enterprise are created before anything is applied in them
+	if len(namespaces) > 0 {
+		if err := configured.check("creating the namespaces"); err != nil {
+			return err
+		}
+		if err := v.configureNamespaces(namespaces, report); err != nil {
+			return err
 		}
 	}
-	return nil
-}
 
-func (v *vault) configureAwsConfig(config map[string]interface{}) error {
-	// https://www.vaultproject.io/api/auth/aws/index.html
-	_, err := v.cl.Logical().Write("auth/aws/config/client", config)
+	err = v.configurePhase(HookPhaseConfigureAuth, config, report, func() error {
+		groups := config.groupByNamespace(len(config.Auth), func(i int) interface{} { return config.Auth[i][namespaceField] })
+		return v.inNamespaces(groups, report, func(v *vault, items []int, report *ConfigureReport) error {
+			var existingAuths map[string]*api.AuthMount
+			err := v.retry("listing auth methods", func() error {
+				var err error
+				existingAuths, err = v.cl.Sys().ListAuth()
+				return err
+			})
 
+			if err != nil {
+				return fmt.Errorf("error listing auth backends vault: %s", err.Error())
+			}
+
+			for _, i := range items {
+				authMethod := config.Auth[i]
+				if err := configured.check(fmt.Sprintf("configuring the %v auth method", authMethod["type"])); err != nil {
+					return err
+				}
+				// The failures are recorded in the report
+				v.configureAuthMethod(span, authMethod, existingAuths, report)
+			}
+			return nil
+		})
+	})
 	if err != nil {
-		return fmt.Errorf("error putting %s aws config into vault: %s", config, err.Error())
+		return err
 	}
-	return nil
-}
 
-func (v *vault) configureAwsRoles(roles []interface{}) error {
-	for _, roleInterface := range roles {
-		role := cast.ToStringMap(roleInterface)
-		_, err := v.cl.Logical().Write(fmt.Sprint("auth/aws/role/", role["name"]), role)
-
-		if err != nil {
-			return fmt.Errorf("error putting %s aws role into vault: %s", role["name"], err.Error())
+	err = v.configurePhase(HookPhaseConfigurePolicies, config, report, func() error {
+		if err := configured.check("configuring the policies"); err != nil {
+			return err
 		}
-	}
-	return nil
-}
-
-func (v *vault) configureLdapConfig(config map[string]interface{}) error {
-	// https://www.vaultproject.io/api/auth/ldap/index.html
-	_, err := v.cl.Logical().Write("auth/ldap/config", config)
+		groups := config.groupByNamespace(len(config.Policies), func(i int) interface{} { return config.Policies[i][namespaceField] })
+		return v.inNamespaces(groups, report, func(v *vault, items []int, report *ConfigureReport) error {
+			policies := make([]map[string]string, len(items))
+			for j, i := range items {
+				policies[j] = config.Policies[i]
+			}
+			err := v.configurePolicies(span, policies, report)
+			if err != nil {
+				return fmt.Errorf("error configuring policies for vault: %s", err.Error())
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
 
+	err = v.configurePhase(HookPhaseConfigureSecrets, config, report, func() error {
+		state, err := v.loadConfigState()
+		if err != nil {
+			return err
+		}
+		groups := config.groupByNamespace(len(config.Secrets), func(i int) interface{} { return config.Secrets[i][namespaceField] })
+		err = v.inNamespaces(groups, report, func(v *vault, items []int, report *ConfigureReport) error {
+			secrets := make([]map[string]interface{}, len(items))
+			for j, i := range items {
+				secrets[j] = config.Secrets[i]
+			}
+			err := v.configureSecretEngines(span, secrets, state, configured, report)
+			if _, ok := err.(*TimeoutError); ok {
+				return err
+			} else if err != nil {
+				return fmt.Errorf("error configuring secret engines for vault: %s", err.Error())
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if state != nil {
+			if err := v.deleteRemovedConfig(state, report); err != nil {
+				return err
+			}
+			if err := v.storeConfigState(state); err != nil {
+				return err
+			}
+		}
+		if err := configured.check("writing the startup secrets"); err != nil {
+			return err
+		}
+		groups = config.groupByNamespace(len(config.StartupSecrets), func(i int) interface{} { return config.StartupSecrets[i][namespaceField] })
+		return v.inNamespaces(groups, report, func(v *vault, items []int, report *ConfigureReport) error {
+			secrets := make([]map[string]interface{}, len(items))
+			for j, i := range items {
+				secrets[j] = config.StartupSecrets[i]
+			}
+			if err := v.configureStartupSecrets(secrets, report); err != nil {
+				return fmt.Errorf("error writing startup secrets to vault: %s", err.Error())
+			}
+			return nil
+		})
+	})
 	if err != nil {
-		return fmt.Errorf("error putting %s ldap config into vault: %s", config, err.Error())
+		return err
+	}
+
+	// The secret engines are tuned after they have been mounted
+	if len(config.Sys) > 0 {
+		err = v.configurePhase(HookPhaseConfigureSys, config, report, func() error {
+			if err := configured.check("configuring the sys settings"); err != nil {
+				return err
+			}
+			err := v.configureSys(span, config.Sys, report)
+			if err != nil {
+				return fmt.Errorf("error configuring sys settings for vault: %s", err.Error())
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// The aliases of the entities and groups refer to the auth methods enabled above
+	if len(config.Identity) > 0 {
+		err = v.configurePhase(HookPhaseConfigureIdentity, config, report, func() error {
+			if err := configured.check("configuring the identity entities and groups"); err != nil {
+				return err
+			}
+			groups := []namespaceGroup{{namespace: config.namespaceOf(nil)}}
+			return v.inNamespaces(groups, report, func(v *vault, _ []int, report *ConfigureReport) error {
+				if err := v.configureIdentity(span, config.Identity, report); err != nil {
+					return fmt.Errorf("error configuring identity for vault: %s", err.Error())
+				}
+				return nil
+			})
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if config.hasCustomSections() {
+		err = v.configurePhase(HookPhaseConfigureCustom, config, report, func() error {
+			return v.configureCustomSections(config, configured, report)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(report.Failed()) > 0 {
+		return &ConfigureError{Report: report}
+	}
+
+	// Nothing is deleted unless the whole configuration has been applied
+	if v.config.PurgeUnmanaged {
+		err = v.configurePhase(HookPhaseConfigurePurge, config, report, func() error {
+			if err := configured.check("purging the unmanaged resources"); err != nil {
+				return err
+			}
+			return v.purgeUnmanaged(span, config, report)
+		})
+		if err != nil {
+			return err
+		}
+		if len(report.Failed()) > 0 {
+			return &ConfigureError{Report: report}
+		}
+	}
+
+	// Vault may normalize or ignore some fields silently, a failing read back doesn't fail the configuration
+	if v.config.ReadBack {
+		if report.ReadBack, err = v.readBack(config); err != nil {
+			v.logger().Warnf("error reading back vault configuration: %s", err.Error())
+		}
+	}
+
+	if v.config.History != nil {
+		if err := v.recordConfiguration(config, changes, report); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-func (v *vault) configureLdapMappings(mappingType string, mappings map[string]interface{}) error {
-	for userOrGroup, policy := range cast.ToStringMap(mappings) {
-		mapping := cast.ToStringMap(policy)
-		_, err := v.cl.Logical().Write(fmt.Sprintf("auth/ldap/%s/%s", mappingType, userOrGroup), mapping)
+// configurePhase calls f applying a section of the configuration with the hooks of the phase
+func (v *vault) configurePhase(phase string, config *ExternalConfig, report *ConfigureReport, f func() error) error {
+	return v.withHooks(&HookEvent{Phase: phase, Config: config, Report: report}, f)
+}
+
+// configureAuthMethod enables the auth method if needed and configures it, then its roles or
+// mappings, every outcome is recorded in the report
+func (v *vault) configureAuthMethod(parent *tracing.Span, authMethod map[string]interface{}, existingAuths map[string]*api.AuthMount, report *ConfigureReport) (err error) {
+	started := time.Now()
+	authMethodType := authMethod["type"].(string)
+
+	path := authMethodType
+	if pathOverwrite, ok := authMethod["path"]; ok {
+		path = pathOverwrite.(string)
+	}
+
+	span := v.tracer().StartSpan(parent, "vault.configureAuthMethod",
+		tracing.Attribute{Key: "type", Value: authMethodType},
+		tracing.Attribute{Key: "path", Value: path})
+	defer func() { span.End(err) }()
+
+	// Check and skip existing auth mounts, the built-in token auth method is always enabled
+	exists := builtinAuthMethods[authMethodType]
+	if authMount, ok := existingAuths[path+"/"]; ok {
+		if authMount.Type == authMethodType {
+			v.logger().Debugf("%s auth backend is already mounted in vault", authMethodType)
+			exists = true
+		}
+	}
+
+	if !exists {
+		v.logger().Debugf("enabling %s auth backend in vault...", authMethodType)
+
+		// https://www.vaultproject.io/api/system/auth.html
+		options := api.EnableAuthOptions{
+			Type: authMethodType,
+		}
+
+		err = v.retry(fmt.Sprintf("enabling the %s auth method", path), func() error {
+			return v.cl.Sys().EnableAuthWithOptions(path, &options)
+		})
+
+		if err != nil {
+			err = fmt.Errorf("error enabling %s auth method for vault: %s", authMethodType, err.Error())
+			report.add(ResourceAuthMethod, path, exists, started, err)
+			return err
+		}
+	}
+
+	switch authMethodType {
+	case "kubernetes":
+		err = v.kubernetesAuthConfig(path, cast.ToStringMap(authMethod["config"]))
+		if err != nil {
+			err = fmt.Errorf("error configuring kubernetes auth for vault: %s", err.Error())
+		}
+	case "github":
+		err = v.configureGithubConfig(cast.ToStringMap(authMethod["config"]))
+		if err != nil {
+			err = fmt.Errorf("error configuring github auth for vault: %s", err.Error())
+		}
+	case "aws":
+		err = v.configureAwsConfig(cast.ToStringMap(authMethod["config"]))
+		if err != nil {
+			err = fmt.Errorf("error configuring aws auth for vault: %s", err.Error())
+		}
+	case "ldap":
+		err = v.configureLdapConfig(cast.ToStringMap(authMethod["config"]))
 		if err != nil {
-			return fmt.Errorf("error putting %s ldap mapping into vault: %s", mappingType, err.Error())
+			err = fmt.Errorf("error configuring ldap auth for vault: %s", err.Error())
 		}
+	case "jwt", "oidc", "gcp", "azure", "okta", "radius", "cert":
+		err = v.configureAuthConfig(authMethodType, path, cast.ToStringMap(authMethod["config"]))
+		if err != nil {
+			err = fmt.Errorf("error configuring %s auth for vault: %s", authMethodType, err.Error())
+		}
+	case "token":
+		// https://www.vaultproject.io/api/system/auth.html#tune-auth-method
+		if tune := cast.ToStringMap(authMethod[authTuneField]); len(tune) > 0 {
+			err = v.retryWrite(fmt.Sprintf("sys/auth/%s/tune", path), tune)
+			if err != nil {
+				err = fmt.Errorf("error tuning token auth for vault: %s", err.Error())
+			}
+		}
+	}
+	report.add(ResourceAuthMethod, path, exists, started, err)
+	if err != nil {
+		return err
+	}
+
+	switch authMethodType {
+	case "kubernetes":
+		v.configureKubernetesRoles(cast.ToSlice(authMethod["roles"]), report)
+	case "github":
+		v.configureGithubMappings(cast.ToStringMap(authMethod["map"]), report)
+	case "aws":
+		v.configureAwsRoles(cast.ToSlice(authMethod["roles"]), report)
+	case "ldap":
+		for _, mappingType := range authMappingTypes[authMethodType] {
+			v.configureAuthMappings(authMethodType, "ldap", mappingType, cast.ToStringMap(authMethod[mappingType]), report)
+		}
+	case "okta", "radius":
+		for _, mappingType := range authMappingTypes[authMethodType] {
+			v.configureAuthMappings(authMethodType, path, mappingType, cast.ToStringMap(authMethod[mappingType]), report)
+		}
+	case "cert":
+		v.configureAuthRolesAt(authMethodType, fmt.Sprintf("auth/%s/certs", path), cast.ToSlice(authMethod["certs"]), report)
+	case "token":
+		// https://www.vaultproject.io/api/auth/token/index.html#create-update-token-role
+		v.configureAuthRolesAt(authMethodType, fmt.Sprintf("auth/%s/roles", path), cast.ToSlice(authMethod["roles"]), report)
+	case "approle":
+		v.configureApproleRoles(path, cast.ToSlice(authMethod["roles"]), report)
+	case "userpass":
+		v.configureUserpassUsers(path, cast.ToSlice(authMethod["users"]), report)
+	case "jwt", "oidc", "gcp", "azure":
+		v.configureAuthRoles(authMethodType, path, cast.ToSlice(authMethod["roles"]), report)
 	}
 	return nil
 }
 
-func (v *vault) configureSecretEngines() error {
-	secretsEngines := []map[string]interface{}{}
-	err := viper.UnmarshalKey("secrets", &secretsEngines)
+// tracer returns the tracer of the spans of the lifecycle actions
+func (v *vault) tracer() *tracing.Tracer {
+	if v.config != nil && v.config.Tracer != nil {
+		return v.config.Tracer
+	}
+	return tracing.DefaultTracer
+}
+
+// context returns the context of the waits of the lifecycle actions
+func (v *vault) context() context.Context {
+	if v.config != nil && v.config.Context != nil {
+		return v.config.Context
+	}
+	return context.Background()
+}
+
+// logger returns the logger of the lifecycle actions with the component field, which redacts the secrets
+func (v *vault) logger() logging.Logger {
+	logger := logging.Default()
+	if v.config != nil && v.config.Logger != nil {
+		logger = v.config.Logger
+	}
+	return logging.NewRedacting(logger).WithField(logging.ComponentField, "vault")
+}
+
+// keyNamer returns the names of the keys in the key store, of the custom KeyNamer of the Config if
+// there is one
+func (v *vault) keyNamer() KeyNamer {
+	return v.keyNamerOfVersion(v.keysVersion)
+}
+
+// keyNamerOfVersion returns the names of the keys of a version in the key store
+func (v *vault) keyNamerOfVersion(version int) KeyNamer {
+	var namer KeyNamer
+	if v.config != nil && v.config.KeyNamer != nil {
+		namer = versionedKeyNamer{KeyNamer: v.config.KeyNamer, version: version}
+	} else {
+		names := KeyNames{}
+		if v.config != nil {
+			names = v.config.KeyNames
+		}
+		names.Version = version
+		namer = names
+	}
+	if v.shares != nil {
+		namer = shareKeyNamer{KeyNamer: namer, shares: v.shares, stores: len(v.config.ShareKeyStores)}
+	}
+	return namer
+}
+
+func (v *vault) unsealKeyForID(i int) string {
+	return v.keyNamer().UnsealKeyName(i)
+}
+
+func (v *vault) recoveryKeyForID(i int) string {
+	return v.keyNamer().RecoveryKeyName(i)
+}
+
+func (v *vault) rootTokenKey() string {
+	return v.keyNamer().RootTokenName()
+}
+
+func (v *vault) testKey() string {
+	return v.keyNamer().TestKeyName()
+}
+
+func (v *vault) keysMetadataKey() string {
+	return v.keyNamer().KeysMetadataName()
+}
+
+// configureAuditDevices enables the audit devices which aren't enabled yet, every outcome is recorded
+// in the report. The enabled devices are skipped, because their options can't be changed without
+// disabling them.
+func (v *vault) configureAuditDevices(parent *tracing.Span, audits []map[string]interface{}, report *ConfigureReport) (err error) {
+	if len(audits) == 0 {
+		return nil
+	}
+
+	span := v.tracer().StartSpan(parent, "vault.configureAuditDevices")
+	defer func() { span.End(err) }()
+
+	var existing map[string]*api.Audit
+	err = v.retry("listing audit devices", func() (err error) {
+		existing, err = v.cl.Sys().ListAudit()
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("error unmarshalling vault secrets config: %s", err.Error())
+		return fmt.Errorf("error listing audit devices: %s", err.Error())
 	}
 
-	for _, secretEngine := range secretsEngines {
-		secretEngineType := secretEngine["type"].(string)
+	for _, audit := range audits {
+		started := time.Now()
+		auditType := cast.ToString(audit["type"])
+		path := auditType
+		if pathOverwrite, ok := audit["path"]; ok {
+			path = cast.ToString(pathOverwrite)
+		}
 
-		path := secretEngineType
-		if pathOverwrite, ok := secretEngine["path"]; ok {
-			path = pathOverwrite.(string)
+		if device, ok := existing[path+"/"]; ok {
+			if device.Type != auditType {
+				report.add(ResourceAuditDevice, path, true, started, fmt.Errorf("a %s audit device is enabled at %s already", device.Type, path))
+				continue
+			}
+			v.logger().Debugf("%s audit device is already enabled in vault", path)
+			report.skip(ResourceAuditDevice, path, started)
+			continue
 		}
 
-		mounts, err := v.cl.Sys().ListMounts()
+		// https://www.vaultproject.io/api/system/audit.html
+		options := api.EnableAuditOptions{
+			Type:        auditType,
+			Description: cast.ToString(audit["description"]),
+			Options:     cast.ToStringMapString(audit["options"]),
+			Local:       cast.ToBool(audit["local"]),
+		}
+		err := v.retry(fmt.Sprintf("enabling the %s audit device", path), func() error {
+			return v.cl.Sys().EnableAuditWithOptions(path, &options)
+		})
 		if err != nil {
-			return fmt.Errorf("error reading mounts from vault: %s", err.Error())
+			err = fmt.Errorf("error enabling %s audit device: %s", path, err.Error())
 		}
-		fmt.Printf("Already existing mounts: %#v\n", mounts)
-		if mounts[path+"/"] == nil {
-			input := api.MountInput{
-				Type:        secretEngineType,
-				Description: getOrDefault(secretEngine, "description"),
-				PluginName:  getOrDefault(secretEngine, "plugin_name"),
-				Options:     getOrDefaultStringMapString(secretEngine, "options"),
-			}
-			logrus.Infoln("Mounting secret engine with input: %#v\n", input)
-			err = v.cl.Sys().Mount(path, &input)
+		report.add(ResourceAuditDevice, path, false, started, err)
+	}
+	return nil
+}
+
+// configurePolicies writes the policies, every outcome is recorded in the report
+func (v *vault) configurePolicies(parent *tracing.Span, policies []map[string]string, report *ConfigureReport) (err error) {
+	span := v.tracer().StartSpan(parent, "vault.configurePolicies")
+	defer func() { span.End(err) }()
+
+	var names []string
+	err = v.retry("listing policies", func() (err error) {
+		names, err = v.cl.Sys().ListPolicies()
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error listing policies: %s", err.Error())
+	}
+	existing := map[string]bool{}
+	for _, name := range names {
+		existing[name] = true
+	}
+
+	changed := make([]bool, len(policies))
+	v.applyConcurrently(len(policies), report, func(i int, report *ConfigureReport) {
+		policy := policies[i]
+		started := time.Now()
+		if cast.ToBool(policy[createOnlyField]) && existing[policy["name"]] {
+			v.logger().Debugf("%s policy already exists, it is created only", policy["name"])
+			report.skip(ResourcePolicy, policy["name"], started)
+			return
+		}
+
+		// The policies are written only if their rules have changed, so the audit log isn't
+		// flooded by the periodic reconciliations
+		if existing[policy["name"]] {
+			var rules string
+			err := v.retry(fmt.Sprintf("reading the %s policy", policy["name"]), func() (err error) {
+				rules, err = v.cl.Sys().GetPolicy(policy["name"])
+				return err
+			})
 			if err != nil {
-				return fmt.Errorf("error mounting %s into vault: %s", path, err.Error())
+				v.logger().Warnf("error reading %s policy, writing it: %s", policy["name"], err.Error())
+			} else if policyRulesEqual(rules, policy["rules"]) {
+				v.logger().Debugf("%s policy is unchanged", policy["name"])
+				report.skip(ResourcePolicy, policy["name"], started)
+				return
 			}
+		}
 
-			logrus.Infoln("mounted", secretEngineType, "to", path)
+		err := v.retry(fmt.Sprintf("putting the %s policy", policy["name"]), func() error {
+			return v.cl.Sys().PutPolicy(policy["name"], policy["rules"])
+		})
 
+		if err != nil {
+			err = fmt.Errorf("error putting %s policy into vault: %s", policy["name"], err.Error())
 		} else {
-			input := api.MountConfigInput{
-				Options: getOrDefaultStringMapString(secretEngine, "options"),
+			changed[i] = true
+		}
+		report.add(ResourcePolicy, policy["name"], existing[policy["name"]], started, err)
+	})
+
+	var changedNames []string
+	for i, policy := range policies {
+		if changed[i] {
+			changedNames = append(changedNames, policy["name"])
+		}
+	}
+	if len(changedNames) > 0 {
+		v.logger().Infof("changed policies: %s", strings.Join(changedNames, ", "))
+	}
+	return nil
+}
+
+func (v *vault) configureKubernetesRoles(roles []interface{}, report *ConfigureReport) {
+	v.applyConcurrently(len(roles), report, func(i int, report *ConfigureReport) {
+		started := time.Now()
+		role := cast.ToStringMap(roles[i])
+		rolePath := fmt.Sprint("auth/kubernetes/role/", role["name"])
+		role, existed, skip := v.existingResource(ResourceAuthRole, rolePath, role, started, report)
+		if skip {
+			return
+		}
+		err := v.retryWrite(rolePath, role)
+
+		if err != nil {
+			err = fmt.Errorf("error putting %s kubernetes role into vault: %s", role["name"], err.Error())
+		}
+		report.add(ResourceAuthRole, rolePath, existed, started, err)
+	})
+}
+
+func (v *vault) configureGithubConfig(config map[string]interface{}) error {
+	// https://www.vaultproject.io/api/auth/github/index.html
+	err := v.retryWrite("auth/github/config", config)
+
+	if err != nil {
+		return fmt.Errorf("error putting %s github config into vault: %s", config, err.Error())
+	}
+	return nil
+}
+
+func (v *vault) configureGithubMappings(mappings map[string]interface{}, report *ConfigureReport) {
+	type githubMapping struct{ mappingType, userOrTeam, value string }
+	var all []githubMapping
+	for _, mappingType := range sortedKeys(mappings) {
+		mapping := cast.ToStringMap(mappings[mappingType])
+		for _, userOrTeam := range sortedKeys(mapping) {
+			all = append(all, githubMapping{mappingType, userOrTeam, cast.ToString(mapping[userOrTeam])})
+		}
+	}
+
+	v.applyConcurrently(len(all), report, func(i int, report *ConfigureReport) {
+		started := time.Now()
+		mapping := all[i]
+		mappingPath := fmt.Sprintf("auth/github/map/%s/%s", mapping.mappingType, mapping.userOrTeam)
+		payload, existed, _ := v.existingResource(ResourceAuthMapping, mappingPath, map[string]interface{}{"value": mapping.value}, started, report)
+		err := v.retryWrite(mappingPath, payload)
+		if err != nil {
+			err = fmt.Errorf("error putting %s github mapping into vault: %s", mapping.mappingType, err.Error())
+		}
+		report.add(ResourceAuthMapping, mappingPath, existed, started, err)
+	})
+}
+
+func (v *vault) configureAwsConfig(config map[string]interface{}) error {
+	// https://www.vaultproject.io/api/auth/aws/index.html
+	err := v.retryWrite("auth/aws/config/client", config)
+
+	if err != nil {
+		return fmt.Errorf("error putting %s aws config into vault: %s", config, err.Error())
+	}
+	return nil
+}
+
+func (v *vault) configureAwsRoles(roles []interface{}, report *ConfigureReport) {
+	v.applyConcurrently(len(roles), report, func(i int, report *ConfigureReport) {
+		started := time.Now()
+		role := cast.ToStringMap(roles[i])
+		rolePath := fmt.Sprint("auth/aws/role/", role["name"])
+		role, existed, skip := v.existingResource(ResourceAuthRole, rolePath, role, started, report)
+		if skip {
+			return
+		}
+		err := v.retryWrite(rolePath, role)
+
+		if err != nil {
+			err = fmt.Errorf("error putting %s aws role into vault: %s", role["name"], err.Error())
+		}
+		report.add(ResourceAuthRole, rolePath, existed, started, err)
+	})
+}
+
+// configureApproleRoles writes the AppRole roles (with their secret_id and token settings), and the
+// role_id of the roles which set it instead of the one generated by Vault
+func (v *vault) configureApproleRoles(path string, roles []interface{}, report *ConfigureReport) {
+	v.applyConcurrently(len(roles), report, func(i int, report *ConfigureReport) {
+		started := time.Now()
+		role := cast.ToStringMap(roles[i])
+		rolePath := fmt.Sprintf("auth/%s/role/%s", path, role["name"])
+		role, existed, skip := v.existingResource(ResourceAuthRole, rolePath, role, started, report)
+		if skip {
+			return
+		}
+
+		roleID, hasRoleID := role["role_id"]
+		if hasRoleID {
+			payload := make(map[string]interface{}, len(role))
+			for key, value := range role {
+				if key != "role_id" {
+					payload[key] = value
+				}
 			}
-			err = v.cl.Sys().TuneMount(path, input)
-			if err != nil {
-				return fmt.Errorf("error tuning %s in vault: %s", path, err.Error())
+			role = payload
+		}
+
+		err := v.retryWrite(rolePath, role)
+		if err == nil && hasRoleID {
+			err = v.retryWrite(rolePath+"/role-id", map[string]interface{}{"role_id": roleID})
+		}
+
+		if err != nil {
+			err = fmt.Errorf("error putting %s approle role into vault: %s", role["name"], err.Error())
+		}
+		report.add(ResourceAuthRole, rolePath, existed, started, err)
+	})
+}
+
+// configureAuthConfig writes the config of the auth methods configured at auth/<path>/config, nothing
+// if it is empty
+func (v *vault) configureAuthConfig(authMethodType, path string, config map[string]interface{}) error {
+	if len(config) == 0 {
+		return nil
+	}
+	for key, value := range config {
+		if logging.IsSensitiveField(key) {
+			logging.RegisterSecret(cast.ToString(value))
+		}
+	}
+
+	// https://www.vaultproject.io/api/auth/jwt/index.html#configure
+	// https://www.vaultproject.io/api/auth/gcp/index.html#configure
+	// https://www.vaultproject.io/api/auth/azure/index.html#configure
+	// https://www.vaultproject.io/api/auth/okta/index.html#configure
+	// https://www.vaultproject.io/api/auth/radius/index.html#configure-radius
+	// https://www.vaultproject.io/api/auth/cert/index.html#configure-tls-certificate-method
+	err := v.retryWrite(fmt.Sprintf("auth/%s/config", path), config)
+
+	if err != nil {
+		return fmt.Errorf("error putting %v %s config into vault: %s", logging.RedactMap(config), authMethodType, err.Error())
+	}
+	return nil
+}
+
+// configureAuthRoles writes the roles of the auth methods keeping them at auth/<path>/role/<name>, e.g.
+// the JWT/OIDC roles with their bound audiences and claim mappings, or the GCP and Azure roles with
+// their bound instance identities
+func (v *vault) configureAuthRoles(authMethodType, path string, roles []interface{}, report *ConfigureReport) {
+	v.configureAuthRolesAt(authMethodType, fmt.Sprintf("auth/%s/role", path), roles, report)
+}
+
+// configureAuthRolesAt writes the roles of an auth method at <rolesPath>/<name>, e.g. the trusted
+// CA certificates of the cert auth method with their allowed common names at auth/<path>/certs
+func (v *vault) configureAuthRolesAt(authMethodType, rolesPath string, roles []interface{}, report *ConfigureReport) {
+	v.applyConcurrently(len(roles), report, func(i int, report *ConfigureReport) {
+		started := time.Now()
+		role := cast.ToStringMap(roles[i])
+		rolePath := fmt.Sprintf("%s/%s", rolesPath, role["name"])
+		role, existed, skip := v.existingResource(ResourceAuthRole, rolePath, role, started, report)
+		if skip {
+			return
+		}
+		err := v.retryWrite(rolePath, role)
+
+		if err != nil {
+			err = fmt.Errorf("error putting %s %s role into vault: %s", role["name"], authMethodType, err.Error())
+		}
+		report.add(ResourceAuthRole, rolePath, existed, started, err)
+	})
+}
+
+// configureUserpassUsers writes the users with their password (or the password read from the key of
+// the key store in password_key) and their policies and token settings
+func (v *vault) configureUserpassUsers(path string, users []interface{}, report *ConfigureReport) {
+	v.applyConcurrently(len(users), report, func(i int, report *ConfigureReport) {
+		started := time.Now()
+		user := cast.ToStringMap(users[i])
+		userPath := fmt.Sprintf("auth/%s/users/%s", path, user["name"])
+		user, existed, skip := v.existingResource(ResourceAuthUser, userPath, user, started, report)
+		if skip {
+			return
+		}
+
+		payload := make(map[string]interface{}, len(user))
+		for key, value := range user {
+			if key != "name" && key != userPasswordKeyField {
+				payload[key] = value
 			}
 		}
+		var err error
+		if passwordKey, ok := user[userPasswordKeyField]; ok {
+			var password []byte
+			password, err = v.userPassword(cast.ToString(passwordKey))
+			if err == nil {
+				payload["password"] = string(password)
+				securemem.Wipe(password)
+			}
+		}
+		if password, ok := payload["password"]; ok {
+			logging.RegisterSecret(cast.ToString(password))
+		}
+
+		if err == nil {
+			err = v.retryWrite(userPath, payload)
+		}
+		if err != nil {
+			err = fmt.Errorf("error putting %s userpass user into vault: %s", user["name"], err.Error())
+		}
+		report.add(ResourceAuthUser, userPath, existed, started, err)
+	})
+}
+
+// userPasswordKeyField is the field of a userpass user naming the key of its password in the key store
+const userPasswordKeyField = "password_key"
+
+// userPassword reads the password of a userpass user from the key store
+func (v *vault) userPassword(key string) ([]byte, error) {
+	if v.keyStore == nil {
+		return nil, fmt.Errorf("no key store to read the password '%s' from", key)
+	}
+	var password []byte
+	err := v.retryKeyStore(v.context(), fmt.Sprintf("getting key '%s'", key), func() (err error) {
+		password, err = v.keyStore.Get(key)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get key '%s': %s", key, err.Error())
+	}
+	return password, nil
+}
+
+func (v *vault) configureLdapConfig(config map[string]interface{}) error {
+	for key, value := range config {
+		if logging.IsSensitiveField(key) {
+			logging.RegisterSecret(cast.ToString(value))
+		}
+	}
+
+	// https://www.vaultproject.io/api/auth/ldap/index.html
+	err := v.retryWrite("auth/ldap/config", config)
+
+	if err != nil {
+		return fmt.Errorf("error putting %v ldap config into vault: %s", logging.RedactMap(config), err.Error())
+	}
+	return nil
+}
 
-		// Configuration of the Secret Engine in a very generic manner, YAML config file should have the proper format
-		configuration := getOrDefaultStringMap(secretEngine, "configuration")
-		for configOption, configData := range configuration {
-			configData := configData.([]interface{})
-			for _, subConfigData := range configData {
-				subConfigData := subConfigData.(map[interface{}]interface{})
-				configPath := fmt.Sprintf("%s/%s/%s", path, configOption, subConfigData["name"])
-				_, err := v.cl.Logical().Write(configPath, cast.ToStringMap(subConfigData))
-
-				if err != nil {
-					if isOverwriteProbihitedError(err) {
-						logrus.Debugln("Can't reconfigure", configPath, "please delete it manually")
-						continue
-					}
-					return fmt.Errorf("error putting %+v -> %s config into vault: %s", configData, configPath, err.Error())
+// authTuneField are the tuning parameters of the token auth method, e.g. its default_lease_ttl and
+// max_lease_ttl, the other auth methods are tuned in the tune section of sys
+const authTuneField = "tune"
+
+// authMappingTypes are the kinds of the mappings of the auth methods mapping their users and groups
+// to policies by their names at auth/<path>/<mapping type>/<name>
+var authMappingTypes = map[string][]string{
+	"ldap":   {"groups", "users"},
+	"okta":   {"groups", "users"},
+	"radius": {"users"},
+}
+
+// configureAuthMappings writes the users or groups of an auth method of authMappingTypes with their
+// policies (and the groups of the users)
+func (v *vault) configureAuthMappings(authMethodType, path, mappingType string, mappings map[string]interface{}, report *ConfigureReport) {
+	usersOrGroups := sortedKeys(mappings)
+	v.applyConcurrently(len(usersOrGroups), report, func(i int, report *ConfigureReport) {
+		started := time.Now()
+		userOrGroup := usersOrGroups[i]
+		mapping := cast.ToStringMap(mappings[userOrGroup])
+		mappingPath := fmt.Sprintf("auth/%s/%s/%s", path, mappingType, userOrGroup)
+		mapping, existed, skip := v.existingResource(ResourceAuthMapping, mappingPath, mapping, started, report)
+		if skip {
+			return
+		}
+		err := v.retryWrite(mappingPath, mapping)
+		if err != nil {
+			err = fmt.Errorf("error putting %s %s mapping into vault: %s", mappingType, authMethodType, err.Error())
+		}
+		report.add(ResourceAuthMapping, mappingPath, existed, started, err)
+	})
+}
+
+// configureSecretEngines mounts and configures the secret engines, every outcome is recorded in the
+// report, and the applied entries of their generic configuration in the state if it isn't nil
+func (v *vault) configureSecretEngines(parent *tracing.Span, secretsEngines []map[string]interface{}, state *configState, configured *deadline, report *ConfigureReport) error {
+	for _, secretEngine := range secretsEngines {
+		if err := configured.check(fmt.Sprintf("configuring the %v secret engine", secretEngine["type"])); err != nil {
+			return err
+		}
+		// The failures are recorded in the report
+		v.configureSecretEngine(parent, secretEngine, state, report)
+	}
+
+	return nil
+}
+
+// configureSecretEngine mounts or tunes the secret engine and writes its configuration, merging it
+// with the state if it isn't nil
+func (v *vault) configureSecretEngine(parent *tracing.Span, secretEngine map[string]interface{}, state *configState, report *ConfigureReport) (err error) {
+	started := time.Now()
+	secretEngineType := secretEngine["type"].(string)
+
+	path := secretEngineType
+	if pathOverwrite, ok := secretEngine["path"]; ok {
+		path = pathOverwrite.(string)
+	}
+
+	span := v.tracer().StartSpan(parent, "vault.configureSecretEngine",
+		tracing.Attribute{Key: "type", Value: secretEngineType},
+		tracing.Attribute{Key: "path", Value: path})
+	defer func() { span.End(err) }()
+
+	var mounts map[string]*api.MountOutput
+	err = v.retry("listing mounts", func() error {
+		var err error
+		mounts, err = v.cl.Sys().ListMounts()
+		return err
+	})
+	if err != nil {
+		err = fmt.Errorf("error reading mounts from vault: %s", err.Error())
+		report.add(ResourceSecretEngine, path, false, started, err)
+		return err
+	}
+	v.logger().Debugf("already existing mounts: %#v", mounts)
+	existed := mounts[path+"/"] != nil
+
+	if secretEngineIsAbsent(secretEngine) {
+		if !existed {
+			v.logger().Debugf("%s secret engine is absent already", path)
+			return nil
+		}
+		v.logger().Infof("unmounting %s secret engine", path)
+		err = v.retry(fmt.Sprintf("unmounting %s", path), func() error {
+			return v.cl.Sys().Unmount(path)
+		})
+		if err != nil {
+			err = fmt.Errorf("error unmounting %s from vault: %s", path, err.Error())
+		}
+		report.delete(ResourceSecretEngine, path, started, err)
+		return err
+	}
+
+	if migrateFrom := strings.Trim(getOrDefault(secretEngine, migrateFromField), "/"); migrateFrom != "" && !existed && mounts[migrateFrom+"/"] != nil {
+		v.logger().Infof("remounting %s secret engine to %s", migrateFrom, path)
+		err = v.retry(fmt.Sprintf("remounting %s to %s", migrateFrom, path), func() error {
+			return v.cl.Sys().Remount(migrateFrom, path)
+		})
+		if err != nil {
+			err = fmt.Errorf("error remounting %s to %s in vault: %s", migrateFrom, path, err.Error())
+			report.add(ResourceSecretEngine, path, existed, started, err)
+			return err
+		}
+		// It is tuned as an existing secret engine
+		existed = true
+	}
+
+	if !existed {
+		input := api.MountInput{
+			Type:        secretEngineType,
+			Description: getOrDefault(secretEngine, "description"),
+			PluginName:  getOrDefault(secretEngine, "plugin_name"),
+			Options:     secretEngineOptions(secretEngine),
+		}
+		v.logger().Infof("mounting secret engine with input: %#v", input)
+		err = v.retry(fmt.Sprintf("mounting %s", path), func() error {
+			return v.cl.Sys().Mount(path, &input)
+		})
+		if err != nil {
+			err = fmt.Errorf("error mounting %s into vault: %s", path, err.Error())
+			report.add(ResourceSecretEngine, path, existed, started, err)
+			return err
+		}
+
+		v.logger().Infof("mounted %s to %s", secretEngineType, path)
+
+	} else {
+		input := api.MountConfigInput{
+			Options: secretEngineOptions(secretEngine),
+		}
+		err = v.retry(fmt.Sprintf("tuning %s", path), func() error {
+			return v.cl.Sys().TuneMount(path, input)
+		})
+		if err != nil {
+			err = fmt.Errorf("error tuning %s in vault: %s", path, err.Error())
+			report.add(ResourceSecretEngine, path, existed, started, err)
+			return err
+		}
+	}
+	report.add(ResourceSecretEngine, path, existed, started, nil)
+
+	if pki := getOrDefaultStringMap(secretEngine, pkiField); secretEngineType == "pki" && len(pki) > 0 {
+		v.configurePKI(path, pki, report)
+	}
+
+	if ssh := getOrDefaultStringMap(secretEngine, sshField); secretEngineType == "ssh" && len(ssh) > 0 {
+		v.configureSSH(path, ssh, report)
+	}
+
+	// Configuration of the Secret Engine in a very generic manner, YAML config file should have the proper format
+	configuration := getOrDefaultStringMap(secretEngine, "configuration")
+	for _, configOption := range configOptionsInOrder(configuration) {
+		for _, subConfigData := range cast.ToSlice(configuration[configOption]) {
+			started := time.Now()
+			subConfig := cast.ToStringMap(subConfigData)
+			configPath := fmt.Sprintf("%s/%s/%s", path, configOption, subConfig["name"])
+			rotateRoot := secretEngineType == "database" && configOption == "config" && cast.ToBool(subConfig[rotateRootField])
+			if _, ok := subConfig[rotateRootField]; ok {
+				subConfig = withoutField(subConfig, rotateRootField)
+				if rotateRoot {
+					subConfig[createOnlyField] = true
 				}
 			}
+			hash := configStateHash(withoutField(subConfig, createOnlyField))
+			if state != nil {
+				state.desire(v.namespace, configPath)
+				// An entry which hasn't changed since the previous run is written only if it has drifted
+				if state.applied(v.namespace, configPath) == hash && !cast.ToBool(subConfig[createOnlyField]) && v.configUnchanged(configPath, subConfig) {
+					report.skip(ResourceSecretEngineConfig, configPath, started)
+					continue
+				}
+			}
+			subConfig, existed, skip := v.existingResource(ResourceSecretEngineConfig, configPath, subConfig, started, report)
+			if skip {
+				continue
+			}
+			err := v.retryWrite(configPath, subConfig)
+			if err == nil && state != nil {
+				state.apply(v.namespace, configPath, hash)
+			}
+
+			if err != nil {
+				if isOverwriteProbihitedError(err) {
+					v.logger().Debugf("can't reconfigure %s, please delete it manually", configPath)
+					err = nil
+				} else {
+					err = fmt.Errorf("error putting %s config into vault: %s", configPath, err.Error())
+				}
+			} else if rotateRoot {
+				report.add(ResourceSecretEngineConfig, configPath, existed, started, nil)
+				started = time.Now()
+				configPath = fmt.Sprintf("%s/rotate-root/%s", path, subConfig["name"])
+				v.logger().Infof("rotating the root credentials of the %s connection", subConfig["name"])
+				if err = v.retryWrite(configPath, nil); err != nil {
+					err = fmt.Errorf("error rotating the root credentials of %s: %s", subConfig["name"], err.Error())
+				}
+				existed = true
+			}
+			report.add(ResourceSecretEngineConfig, configPath, existed, started, err)
 		}
 	}
 
 	return nil
 }
 
+// withoutField returns a copy of the resource without the field
+func withoutField(resource map[string]interface{}, field string) map[string]interface{} {
+	copied := make(map[string]interface{}, len(resource))
+	for key, value := range resource {
+		if key != field {
+			copied[key] = value
+		}
+	}
+	return copied
+}
+
+// secretEngineIsAbsent returns true if the secret engine has to be unmounted
+func secretEngineIsAbsent(secretEngine map[string]interface{}) bool {
+	return getOrDefault(secretEngine, secretEngineStateField) == secretEngineAbsent
+}
+
+// existingResource reads a role, a mapping or a secret engine configuration from Vault before it is
+// written, and returns its payload without the create_only field and whether it exists. The paths
+// which can't be read (e.g. write-only ones) are considered existing. skip is true if it mustn't be
+// written because it is create only and exists already (or it couldn't be read), the skipped
+// resources are recorded in the report.
+func (v *vault) existingResource(kind, path string, resource map[string]interface{}, started time.Time, report *ConfigureReport) (payload map[string]interface{}, existed, skip bool) {
+	payload = resource
+	createOnly := false
+	if value, ok := resource[createOnlyField]; ok {
+		createOnly = cast.ToBool(value)
+		payload = withoutField(resource, createOnlyField)
+	}
+
+	var secret *api.Secret
+	var err error
+	if createOnly {
+		err = v.retry(fmt.Sprintf("reading %s", path), func() (err error) {
+			secret, err = v.cl.Logical().Read(path)
+			return err
+		})
+		if err != nil {
+			report.add(kind, path, true, started, fmt.Errorf("error reading %s from vault: %s", path, err.Error()))
+			return nil, true, true
+		}
+	} else if err = v.waitForLimiter(); err == nil {
+		// Only the action of the resource depends on it, so it isn't retried
+		secret, err = v.cl.Logical().Read(path)
+	}
+	existed = err != nil || secret != nil
+
+	if createOnly && existed {
+		v.logger().Debugf("%s already exists, it is created only", path)
+		report.skip(kind, path, started)
+		return nil, true, true
+	}
+	return payload, existed, false
+}
+
+// sortedKeys returns the keys of the map in alphabetical order, so the resources are applied and
+// reported in a stable order
+func sortedKeys(m map[string]interface{}) []string {
+	keys := []string{}
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// configOptionsInOrder returns the options of a secret engine configuration in the order they are
+// applied in: the config options (e.g. config/root of the aws engine) first, as the other options
+// (e.g. the roles) may depend on them, then the rest in alphabetical order
+func configOptionsInOrder(configuration map[string]interface{}) []string {
+	options := sortedKeys(configuration)
+	sort.SliceStable(options, func(i, j int) bool {
+		return isConfigOption(options[i]) && !isConfigOption(options[j])
+	})
+	return options
+}
+
+func isConfigOption(option string) bool {
+	return option == "config" || strings.HasPrefix(option, "config/")
+}
+
 func getOrDefault(m map[string]interface{}, key string) string {
 	value := m[key]
 	if value != nil {
@@ -0,0 +1,3345 @@
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv/kvtest"
+	"github.com/banzaicloud/bank-vaults/pkg/vault/vaulttest"
+	"github.com/hashicorp/vault/api"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+	yamlv2 "gopkg.in/yaml.v2"
+)
+
+const testConfig = `
+auth:
+  - type: userpass
+    path: people
+policies:
+  - name: allow_secrets
+    rules: path "secret/*" { capabilities = ["read"] }
+secrets:
+  - type: database
+    path: db
+    options:
+      max_versions: 3
+    configuration:
+      config:
+        - name: mysql
+          plugin_name: mysql-database-plugin
+`
+
+// parseTestConfig parses the external configuration from YAML
+func parseTestConfig(t *testing.T, yaml string) *ExternalConfig {
+	cfg := viper.New()
+	cfg.SetConfigType("yaml")
+	if err := cfg.ReadConfig(strings.NewReader(yaml)); err != nil {
+		t.Fatalf("error reading the config: %s", err.Error())
+	}
+	config, err := ParseExternalConfig(cfg.AllSettings())
+	if err != nil {
+		t.Fatalf("error parsing the config: %s", err.Error())
+	}
+	return config
+}
+
+func newTestVault(t *testing.T, store *kvtest.Store) (Vault, *vaulttest.Server) {
+	server := vaulttest.NewServer()
+	client, err := server.Client()
+	if err != nil {
+		server.Close()
+		t.Fatalf("error creating the client of the fake vault: %s", err.Error())
+	}
+	v, err := New(store, client, Config{SecretShares: 5, SecretThreshold: 3, StoreRootToken: true})
+	if err != nil {
+		server.Close()
+		t.Fatalf("error creating vault: %s", err.Error())
+	}
+	return v, server
+}
+
+func TestInitUnsealConfigure(t *testing.T) {
+	store := kvtest.New()
+	v, server := newTestVault(t, store)
+	defer server.Close()
+
+	result, err := v.Init(context.Background())
+	if err != nil {
+		t.Fatalf("error initializing vault: %s", err.Error())
+	}
+	if len(result.UnsealKeys) != 5 || result.RootTokenKey != RootTokenKey {
+		t.Errorf("unexpected init result: %+v", result)
+	}
+	if string(store.Value(RootTokenKey)) != server.RootToken() {
+		t.Errorf("the root token hasn't been stored")
+	}
+	if store.Value(KeysMetadataKey) == nil {
+		t.Errorf("the metadata of the keys hasn't been stored")
+	}
+
+	result, err = v.Init(context.Background())
+	if err != nil || !result.AlreadyInitialized {
+		t.Errorf("the second init should find vault initialized: %+v, %v", result, err)
+	}
+
+	if err := v.Unseal(context.Background()); err != nil {
+		t.Fatalf("error unsealing vault: %s", err.Error())
+	}
+	if server.Sealed() {
+		t.Fatalf("vault is still sealed")
+	}
+
+	config := parseTestConfig(t, testConfig)
+
+	// Configure is idempotent
+	for i := 0; i < 2; i++ {
+		if err := v.Configure(config); err != nil {
+			t.Fatalf("error configuring vault: %s", err.Error())
+		}
+	}
+	if auth := server.Auths()["people/"]; auth == nil || auth.Type != "userpass" {
+		t.Errorf("the userpass auth method hasn't been enabled: %+v", auth)
+	}
+	if rules, _ := server.Policy("allow_secrets"); !strings.Contains(rules, "secret/*") {
+		t.Errorf("the policy hasn't been written: %q", rules)
+	}
+	if mount := server.Mounts()["db/"]; mount == nil || mount.Options["max_versions"] != "3" {
+		t.Errorf("the secret engine hasn't been mounted: %+v", mount)
+	}
+	if data := server.Data("db/config/mysql"); data == nil || data["plugin_name"] != "mysql-database-plugin" {
+		t.Errorf("the secret engine hasn't been configured: %+v", data)
+	}
+}
+
+func TestUnsealCorruptedKey(t *testing.T) {
+	store := kvtest.New()
+	v, server := newTestVault(t, store)
+	defer server.Close()
+
+	if _, err := v.Init(context.Background()); err != nil {
+		t.Fatalf("error initializing vault: %s", err.Error())
+	}
+	store.Put("vault-unseal-0", []byte(strings.Repeat("0", 64)))
+
+	err := v.Unseal(context.Background())
+	if _, ok := err.(*KeyValidationError); !ok {
+		t.Fatalf("expected a key validation error, got: %v", err)
+	}
+	for _, request := range server.Requests() {
+		if request.Path == "sys/unseal" {
+			t.Fatalf("the keys have been sent to vault after a validation error")
+		}
+	}
+}
+
+func TestInitUnsealRecoverySeal(t *testing.T) {
+	store := kvtest.New()
+	v, server := newTestVault(t, store)
+	defer server.Close()
+	server.UseRecoverySeal("awskms")
+
+	result, err := v.Init(context.Background())
+	if err != nil {
+		t.Fatalf("error initializing vault: %s", err.Error())
+	}
+	if len(result.UnsealKeys) != 0 || len(result.RecoveryKeys) != 5 || result.RecoveryShares != 5 || result.RecoveryThreshold != 3 || result.SealType != "awskms" {
+		t.Errorf("unexpected init result: %+v", result)
+	}
+	if store.Value("vault-recovery-0") == nil || store.Value("vault-unseal-0") != nil {
+		t.Errorf("the recovery keys haven't been stored instead of the unseal keys: %v", store.Keys())
+	}
+
+	// Vault unseals itself, the recovery keys are never sent to it
+	if err := v.Unseal(context.Background()); err != nil {
+		t.Fatalf("error unsealing vault: %s", err.Error())
+	}
+	for _, request := range server.Requests() {
+		if request.Path == "sys/unseal" {
+			t.Fatalf("the recovery keys have been sent to vault")
+		}
+	}
+}
+
+func TestInitKeyStoreFailure(t *testing.T) {
+	store := kvtest.New()
+	v, server := newTestVault(t, store)
+	defer server.Close()
+
+	store.FailOn(kvtest.OperationCreate, "vault-unseal-2", errors.New("connection refused"))
+
+	_, err := v.Init(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "connection refused") {
+		t.Fatalf("expected the error of the key store, got: %v", err)
+	}
+	if store.Value("vault-unseal-2") != nil {
+		t.Errorf("the failed key has been stored")
+	}
+}
+
+func TestRekey(t *testing.T) {
+	store := kvtest.New()
+	v, server := newTestVault(t, store)
+	defer server.Close()
+
+	if _, err := v.Init(context.Background()); err != nil {
+		t.Fatalf("error initializing vault: %s", err.Error())
+	}
+	if err := v.Unseal(context.Background()); err != nil {
+		t.Fatalf("error unsealing vault: %s", err.Error())
+	}
+
+	// Every rekey stores the keys with the next version and deletes the previous ones
+	for version := 1; version <= 2; version++ {
+		result, err := v.Rekey(RekeyOptions{})
+		if err != nil {
+			t.Fatalf("error rekeying vault: %s", err.Error())
+		}
+		if len(result.Keys) != 5 || result.Keys[0] != "vault-unseal-0-v"+strconv.Itoa(version) {
+			t.Errorf("unexpected rekey result: %+v", result)
+		}
+		for i, key := range server.Keys() {
+			if stored := string(store.Value(result.Keys[i])); stored != key {
+				t.Errorf("key %d of vault isn't stored as %s: %q", i, result.Keys[i], stored)
+			}
+		}
+		if store.Value("vault-unseal-0") != nil || (version > 1 && store.Value("vault-unseal-0-v1") != nil) {
+			t.Errorf("the old keys haven't been deleted: %v", store.Keys())
+		}
+		metadata, err := v.KeysMetadata()
+		if err != nil || metadata == nil || metadata.Version != version {
+			t.Errorf("the metadata of the keys should have version %d: %+v, %v", version, metadata, err)
+		}
+	}
+
+	// Another helper finds the current version of the keys in their metadata
+	server.Seal()
+	client, _ := server.Client()
+	other, _ := New(store, client, Config{SecretShares: 5, SecretThreshold: 3})
+	if err := other.Unseal(context.Background()); err != nil {
+		t.Fatalf("error unsealing vault with the new keys: %s", err.Error())
+	}
+
+	keys, err := StoredKeys(store, KeyNames{})
+	if err != nil {
+		t.Fatalf("error reading the stored keys: %s", err.Error())
+	}
+	defer WipeKeys(keys)
+	if len(keys) != 7 {
+		t.Errorf("expected the root token, the metadata and 5 keys, got %d keys", len(keys))
+	}
+}
+
+func TestRekeyStoreFailure(t *testing.T) {
+	store := kvtest.New()
+	v, server := newTestVault(t, store)
+	defer server.Close()
+
+	if _, err := v.Init(context.Background()); err != nil {
+		t.Fatalf("error initializing vault: %s", err.Error())
+	}
+	if err := v.Unseal(context.Background()); err != nil {
+		t.Fatalf("error unsealing vault: %s", err.Error())
+	}
+	oldKeys := server.Keys()
+
+	store.FailOn(kvtest.OperationSet, "vault-unseal-3-v1", errors.New("connection refused"))
+	if _, err := v.Rekey(RekeyOptions{}); err == nil || !strings.Contains(err.Error(), "connection refused") {
+		t.Fatalf("expected the error of the key store, got: %v", err)
+	}
+
+	// Vault and the key store keep the old keys
+	if keys := server.Keys(); keys[0] != oldKeys[0] {
+		t.Errorf("vault has switched to the new keys")
+	}
+	if store.Value("vault-unseal-0") == nil || store.Value("vault-unseal-0-v1") != nil {
+		t.Errorf("the old keys should be kept and the new ones deleted: %v", store.Keys())
+	}
+	server.Seal()
+	if err := v.Unseal(context.Background()); err != nil {
+		t.Fatalf("error unsealing vault with the old keys: %s", err.Error())
+	}
+}
+
+func TestMigrateSeal(t *testing.T) {
+	store := kvtest.New()
+	v, server := newTestVault(t, store)
+	defer server.Close()
+
+	if _, err := v.Init(context.Background()); err != nil {
+		t.Fatalf("error initializing vault: %s", err.Error())
+	}
+	if err := v.Unseal(context.Background()); err != nil {
+		t.Fatalf("error unsealing vault: %s", err.Error())
+	}
+	if _, err := v.MigrateSeal(context.Background()); err != nil {
+		t.Fatalf("error migrating an unsealed vault without a migration: %s", err.Error())
+	}
+
+	// Shamir to auto-unseal, the unseal keys become the recovery keys
+	server.MigrateSeal("awskms")
+	if err := v.Unseal(context.Background()); err == nil {
+		t.Fatalf("expected an error unsealing vault without the migrate option")
+	}
+	result, err := v.MigrateSeal(context.Background())
+	if err != nil {
+		t.Fatalf("error migrating the seal to awskms: %s", err.Error())
+	}
+	if server.Sealed() || result.SealType != "awskms" || !result.RecoveryKeys || !result.Moved || len(result.Keys) != 5 || result.Keys[0] != "vault-recovery-0" {
+		t.Errorf("unexpected result of the migration to awskms: %+v", result)
+	}
+	if store.Value("vault-recovery-0") == nil || store.Value("vault-unseal-0") != nil {
+		t.Errorf("the unseal keys haven't been moved to the recovery keys: %v", store.Keys())
+	}
+	if metadata, err := v.KeysMetadata(); err != nil || metadata.SealType != "awskms" || metadata.Checksums["vault-recovery-0"] == "" {
+		t.Errorf("the metadata of the keys hasn't been updated: %+v, %v", metadata, err)
+	}
+
+	// Auto-unseal to Shamir, the recovery keys become the unseal keys
+	server.MigrateSeal("shamir")
+	result, err = v.MigrateSeal(context.Background())
+	if err != nil {
+		t.Fatalf("error migrating the seal to shamir: %s", err.Error())
+	}
+	if result.SealType != "shamir" || result.RecoveryKeys || !result.Moved || result.Keys[0] != "vault-unseal-0" {
+		t.Errorf("unexpected result of the migration to shamir: %+v", result)
+	}
+	if store.Value("vault-unseal-0") == nil || store.Value("vault-recovery-0") != nil {
+		t.Errorf("the recovery keys haven't been moved to the unseal keys: %v", store.Keys())
+	}
+	server.Seal()
+	if err := v.Unseal(context.Background()); err != nil {
+		t.Fatalf("error unsealing vault with the migrated keys: %s", err.Error())
+	}
+
+	server.Seal()
+	if _, err := v.MigrateSeal(context.Background()); err == nil {
+		t.Errorf("expected an error migrating the seal of a sealed vault without a migration")
+	}
+}
+
+func TestRotateRootToken(t *testing.T) {
+	store := kvtest.New()
+	v, server := newTestVault(t, store)
+	defer server.Close()
+
+	if _, err := v.Init(context.Background()); err != nil {
+		t.Fatalf("error initializing vault: %s", err.Error())
+	}
+	if err := v.Unseal(context.Background()); err != nil {
+		t.Fatalf("error unsealing vault: %s", err.Error())
+	}
+	oldRootToken := server.RootToken()
+	server.SetTokenCreated(oldRootToken, time.Now().Add(-48*time.Hour))
+
+	created, err := v.RootTokenCreated()
+	if err != nil || time.Since(created) < 47*time.Hour {
+		t.Fatalf("unexpected creation time of the root token: %s, %v", created, err)
+	}
+
+	if err := v.RotateRootToken(); err != nil {
+		t.Fatalf("error rotating the root token: %s", err.Error())
+	}
+	newRootToken := string(store.Value(RootTokenKey))
+	if newRootToken == oldRootToken || !server.ValidToken(newRootToken) {
+		t.Errorf("a new valid root token should be stored, got %q", newRootToken)
+	}
+	if server.ValidToken(oldRootToken) {
+		t.Errorf("the old root token hasn't been revoked")
+	}
+	if created, err := v.RootTokenCreated(); err != nil || time.Since(created) > time.Hour {
+		t.Errorf("unexpected creation time of the new root token: %s, %v", created, err)
+	}
+}
+
+func TestEphemeralRootToken(t *testing.T) {
+	store := kvtest.New()
+	v, server := newTestVault(t, store)
+	defer server.Close()
+	if _, err := v.Init(context.Background()); err != nil {
+		t.Fatalf("error initializing vault: %s", err.Error())
+	}
+	if err := v.Unseal(context.Background()); err != nil {
+		t.Fatalf("error unsealing vault: %s", err.Error())
+	}
+
+	// A revoked root token is replaced by a short-lived one generated with the stored keys
+	client, err := server.Client()
+	if err != nil {
+		t.Fatalf("error creating the client of the fake vault: %s", err.Error())
+	}
+	client.SetToken(server.RootToken())
+	if err := client.Auth().Token().RevokeSelf(""); err != nil {
+		t.Fatalf("error revoking the root token: %s", err.Error())
+	}
+	config := parseTestConfig(t, `
+policies:
+  - name: dev
+    rules: path "secret/*" { capabilities = ["read"] }
+`)
+	if err := v.Configure(config); err != nil {
+		t.Fatalf("error configuring vault with a revoked root token: %s", err.Error())
+	}
+	generated, revoked := false, false
+	for _, request := range server.Requests() {
+		generated = generated || request.Path == "sys/generate-root/update"
+		revoked = generated && (revoked || request.Path == "auth/token/revoke-self")
+	}
+	if !generated || !revoked {
+		t.Errorf("a short-lived root token should be generated and revoked: %v", server.Requests())
+	}
+
+	// The initial root token isn't stored but revoked with EphemeralRootToken
+	store = kvtest.New()
+	server = vaulttest.NewServer()
+	defer server.Close()
+	client, err = server.Client()
+	if err != nil {
+		t.Fatalf("error creating the client of the fake vault: %s", err.Error())
+	}
+	v, err = New(store, client, Config{SecretShares: 5, SecretThreshold: 3, StoreRootToken: true, EphemeralRootToken: true})
+	if err != nil {
+		t.Fatalf("error creating vault: %s", err.Error())
+	}
+	// Init unseals vault with the new keys to revoke the root token, without waiting for the unsealer
+	if _, err := v.Init(context.Background()); err != nil {
+		t.Fatalf("error initializing vault: %s", err.Error())
+	}
+	if server.Sealed() {
+		t.Errorf("vault hasn't been unsealed by Init")
+	}
+	if _, err := store.Get(RootTokenKey); err == nil {
+		t.Errorf("the root token has been stored")
+	}
+	if server.ValidToken(server.RootToken()) {
+		t.Errorf("the initial root token hasn't been revoked")
+	}
+	if err := v.Configure(config); err != nil {
+		t.Fatalf("error configuring vault: %s", err.Error())
+	}
+	if err := v.RotateRootToken(); err == nil {
+		t.Errorf("rotating the root token is expected to fail without a stored one")
+	}
+}
+
+func TestProvisionerToken(t *testing.T) {
+	store := kvtest.New()
+	server := vaulttest.NewServer()
+	defer server.Close()
+	client, err := server.Client()
+	if err != nil {
+		t.Fatalf("error creating the client of the fake vault: %s", err.Error())
+	}
+	v, err := New(store, client, Config{SecretShares: 5, SecretThreshold: 3, StoreRootToken: true, ProvisionerToken: true})
+	if err != nil {
+		t.Fatalf("error creating vault: %s", err.Error())
+	}
+
+	// Init unseals Vault with the new keys to create the provisioner token
+	result, err := v.Init(context.Background())
+	if err != nil {
+		t.Fatalf("error initializing vault: %s", err.Error())
+	}
+	if result.ProvisionerTokenKey != DefaultProvisionerTokenKeyName {
+		t.Errorf("unexpected provisioner token key of the init result: %q", result.ProvisionerTokenKey)
+	}
+	provisionerToken := string(store.Value(DefaultProvisionerTokenKeyName))
+	if !server.ValidToken(provisionerToken) || provisionerToken == server.RootToken() {
+		t.Fatalf("the provisioner token isn't valid: %q", provisionerToken)
+	}
+	if policy, _ := server.Policy(ProvisionerPolicy); !strings.Contains(policy, `path "sys/*"`) {
+		t.Errorf("unexpected %s policy: %s", ProvisionerPolicy, policy)
+	}
+	if err := v.Unseal(context.Background()); err != nil {
+		t.Fatalf("error unsealing vault: %s", err.Error())
+	}
+	config := parseTestConfig(t, `
+policies:
+  - name: dev
+    rules: path "secret/*" { capabilities = ["read"] }
+`)
+
+	// The stored provisioner token is used from then on
+	requests := len(server.Requests())
+	if err := v.Configure(config); err != nil {
+		t.Fatalf("error configuring vault: %s", err.Error())
+	}
+	for _, request := range server.Requests()[requests:] {
+		if request.Token == server.RootToken() {
+			t.Errorf("the root token has been used by Configure: %s %s", request.Method, request.Path)
+		}
+	}
+
+	// A missing provisioner token is created with the root token
+	store.Delete(DefaultProvisionerTokenKeyName)
+	if err := v.Configure(config); err != nil {
+		t.Fatalf("error configuring vault without a provisioner token: %s", err.Error())
+	}
+	provisionerToken = string(store.Value(DefaultProvisionerTokenKeyName))
+	if !server.ValidToken(provisionerToken) {
+		t.Fatalf("a new provisioner token should be stored, got %q", provisionerToken)
+	}
+
+	// A revoked provisioner token is replaced, although the key of the token exists
+	revoker, err := server.Client()
+	if err != nil {
+		t.Fatalf("error creating the client of the fake vault: %s", err.Error())
+	}
+	revoker.SetToken(provisionerToken)
+	if err := revoker.Auth().Token().RevokeSelf(""); err != nil {
+		t.Fatalf("error revoking the provisioner token: %s", err.Error())
+	}
+	if err := v.Configure(config); err != nil {
+		t.Fatalf("error configuring vault with a revoked provisioner token: %s", err.Error())
+	}
+	if token := string(store.Value(DefaultProvisionerTokenKeyName)); token == provisionerToken || !server.ValidToken(token) {
+		t.Errorf("the revoked provisioner token should be replaced by a valid one, got %q", token)
+	}
+}
+
+func TestInitWrapKeys(t *testing.T) {
+	store := kvtest.New()
+	server := vaulttest.NewServer()
+	defer server.Close()
+	client, err := server.Client()
+	if err != nil {
+		t.Fatalf("error creating the client of the fake vault: %s", err.Error())
+	}
+	if _, err := New(store, client, Config{SecretShares: 5, SecretThreshold: 3, WrapKeysTTL: time.Hour, EphemeralRootToken: true}); err == nil {
+		t.Errorf("expected an error of wrapping the keys with an ephemeral root token")
+	}
+	v, err := New(store, client, Config{SecretShares: 5, SecretThreshold: 3, StoreRootToken: true, WrapKeysTTL: time.Hour})
+	if err != nil {
+		t.Fatalf("error creating vault: %s", err.Error())
+	}
+
+	result, err := v.Init(context.Background())
+	if err != nil {
+		t.Fatalf("error initializing vault: %s", err.Error())
+	}
+	if len(result.WrappedKeys) != 5 || len(result.UnsealKeys) != 0 || result.RootTokenKey != RootTokenKey {
+		t.Fatalf("unexpected init result: %+v", result)
+	}
+	if keys := store.Keys(); !reflect.DeepEqual(keys, []string{RootTokenKey}) {
+		t.Errorf("only the root token should be stored, got %v", keys)
+	}
+	if server.Sealed() {
+		t.Errorf("vault should have been unsealed to wrap the keys")
+	}
+
+	// Every key can be unwrapped once by its holder
+	unwrapper, err := server.Client()
+	if err != nil {
+		t.Fatalf("error creating the client of the fake vault: %s", err.Error())
+	}
+	for i, wrapped := range result.WrappedKeys {
+		if wrapped.Index != i || wrapped.Recovery || wrapped.Expiration.Before(time.Now().Add(59*time.Minute)) {
+			t.Errorf("unexpected wrapped key: %+v", wrapped)
+		}
+		secret, err := unwrapper.Logical().Unwrap(wrapped.Token)
+		if err != nil {
+			t.Fatalf("error unwrapping key %d: %s", i, err.Error())
+		}
+		if secret.Data["key"] != server.Keys()[i] {
+			t.Errorf("unexpected unwrapped key %d: %v", i, secret.Data)
+		}
+	}
+	if _, err := unwrapper.Logical().Unwrap(result.WrappedKeys[0].Token); err == nil {
+		t.Errorf("a wrapping token should be used only once")
+	}
+}
+
+func TestConfigureStrict(t *testing.T) {
+	store := kvtest.New()
+	server := vaulttest.NewServer()
+	defer server.Close()
+	client, err := server.Client()
+	if err != nil {
+		t.Fatalf("error creating the client of the fake vault: %s", err.Error())
+	}
+	v, err := New(store, client, Config{SecretShares: 1, SecretThreshold: 1, StoreRootToken: true, StrictConfig: true})
+	if err != nil {
+		t.Fatalf("error creating vault: %s", err.Error())
+	}
+	if _, err := v.Init(context.Background()); err != nil {
+		t.Fatalf("error initializing vault: %s", err.Error())
+	}
+	if err := v.Unseal(context.Background()); err != nil {
+		t.Fatalf("error unsealing vault: %s", err.Error())
+	}
+
+	config := parseTestConfig(t, testConfig+"    descripton: misspelled\n")
+
+	err = v.Configure(config)
+	errs, ok := err.(ConfigErrors)
+	if !ok || len(errs) != 1 || errs[0].Path != "secrets[0].descripton" {
+		t.Fatalf("expected an unknown field error, got: %v", err)
+	}
+	if _, ok := server.Policy("allow_secrets"); ok {
+		t.Errorf("the configuration has been applied in spite of the errors")
+	}
+}
+
+func TestConfigureFailureIsolation(t *testing.T) {
+	store := kvtest.New()
+	server := vaulttest.NewServer()
+	defer server.Close()
+	client, err := server.Client()
+	if err != nil {
+		t.Fatalf("error creating the client of the fake vault: %s", err.Error())
+	}
+	// The kubernetes auth method can't be configured without the token reviewer JWT
+	v, err := New(store, client, Config{SecretShares: 1, SecretThreshold: 1, StoreRootToken: true, TokenReviewerJWTFile: "/nonexistent"})
+	if err != nil {
+		t.Fatalf("error creating vault: %s", err.Error())
+	}
+	if _, err := v.Init(context.Background()); err != nil {
+		t.Fatalf("error initializing vault: %s", err.Error())
+	}
+	if err := v.Unseal(context.Background()); err != nil {
+		t.Fatalf("error unsealing vault: %s", err.Error())
+	}
+
+	config := parseTestConfig(t, strings.Replace(testConfig, "auth:\n", "auth:\n  - type: kubernetes\n    roles:\n      - name: default\n", 1))
+
+	report, err := v.ConfigureWithReport(config)
+	if _, ok := err.(*ConfigureError); !ok {
+		t.Fatalf("expected a configure error, got: %v", err)
+	}
+	failed := report.Failed()
+	if len(failed) != 1 || failed[0].Kind != ResourceAuthMethod || failed[0].Name != "kubernetes" {
+		t.Fatalf("expected only the kubernetes auth method to fail: %+v", report)
+	}
+	if len(report.Resources) != 5 {
+		t.Errorf("expected 5 resources in the report: %+v", report)
+	}
+	if _, ok := server.Policy("allow_secrets"); !ok {
+		t.Errorf("the policy hasn't been applied after the failure")
+	}
+	if server.Data("db/config/mysql") == nil {
+		t.Errorf("the secret engine hasn't been configured after the failure")
+	}
+}
+
+func TestConfigureHistory(t *testing.T) {
+	store := kvtest.New()
+	server := vaulttest.NewServer()
+	defer server.Close()
+	client, err := server.Client()
+	if err != nil {
+		t.Fatalf("error creating the client of the fake vault: %s", err.Error())
+	}
+
+	historyStore := kvtest.New()
+	history, err := NewConfigHistory(historyStore, []byte("signing key"))
+	if err != nil {
+		t.Fatalf("error creating the history: %s", err.Error())
+	}
+	v, err := New(store, client, Config{SecretShares: 1, SecretThreshold: 1, StoreRootToken: true, History: history, HistoryActor: "test"})
+	if err != nil {
+		t.Fatalf("error creating vault: %s", err.Error())
+	}
+
+	if _, err := v.Init(context.Background()); err != nil {
+		t.Fatalf("error initializing vault: %s", err.Error())
+	}
+	if err := v.Unseal(context.Background()); err != nil {
+		t.Fatalf("error unsealing vault: %s", err.Error())
+	}
+
+	config := parseTestConfig(t, testConfig)
+
+	for i := 0; i < 2; i++ {
+		if err := v.Configure(config); err != nil {
+			t.Fatalf("error configuring vault: %s", err.Error())
+		}
+	}
+
+	records, err := history.Records()
+	if err != nil {
+		t.Fatalf("error reading the history: %s", err.Error())
+	}
+	if len(records) != 2 || records[1].PreviousHash == "" || records[0].ConfigHash != records[1].ConfigHash || records[1].Actor != "test" {
+		t.Fatalf("unexpected history: %+v", records)
+	}
+	if len(records[0].Changes) == 0 {
+		t.Errorf("the first record should have changes: %+v", records[0])
+	}
+
+	// Changing a record breaks its signature
+	tampered := strings.Replace(string(historyStore.Value(ConfigHistoryKeyPrefix+"1")), `"actor":"test"`, `"actor":"someone"`, 1)
+	historyStore.Put(ConfigHistoryKeyPrefix+"1", []byte(tampered))
+
+	records, err = history.Records()
+	if historyErr, ok := err.(*ConfigHistoryError); !ok || historyErr.Sequence != 1 || len(records) != 0 {
+		t.Fatalf("expected the first record to be tampered with, got: %v", err)
+	}
+}
+
+func TestClientPool(t *testing.T) {
+	pool := NewClientPool(func() (*api.Config, error) { return api.DefaultConfig(), nil }, nil)
+
+	clients := make(chan *api.Client, 10)
+	for i := 0; i < cap(clients); i++ {
+		go func(i int) {
+			cl, err := pool.Client(Endpoint{Address: "https://vault-" + strconv.Itoa(i%2) + ":8200", Token: "token-" + strconv.Itoa(i%2)})
+			if err != nil {
+				t.Errorf("error creating client: %s", err.Error())
+			}
+			clients <- cl
+		}(i)
+	}
+
+	distinct := map[*api.Client]bool{}
+	for i := 0; i < cap(clients); i++ {
+		distinct[<-clients] = true
+	}
+	if len(distinct) != 2 || len(pool.Addresses()) != 2 {
+		t.Fatalf("expected a client per address, got %d", len(distinct))
+	}
+
+	first, _ := pool.Client(Endpoint{Address: "https://vault-0:8200"})
+	second, _ := pool.Client(Endpoint{Address: "https://vault-1:8200"})
+	first.SetToken("changed")
+	if first.Address() != "https://vault-0:8200" || second.Address() != "https://vault-1:8200" || second.Token() != "token-1" {
+		t.Errorf("the clients share their settings: %s %s %s", first.Address(), second.Address(), second.Token())
+	}
+
+	pool.Remove("https://vault-0:8200")
+	if addresses := pool.Addresses(); len(addresses) != 1 || addresses[0] != "https://vault-1:8200" {
+		t.Errorf("unexpected addresses after removing a client: %v", addresses)
+	}
+}
+
+func TestConfigureOrder(t *testing.T) {
+	store := kvtest.New()
+	v, server := newTestVault(t, store)
+	defer server.Close()
+
+	if _, err := v.Init(context.Background()); err != nil {
+		t.Fatalf("error initializing vault: %s", err.Error())
+	}
+	if err := v.Unseal(context.Background()); err != nil {
+		t.Fatalf("error unsealing vault: %s", err.Error())
+	}
+
+	config := parseTestConfig(t, `
+auth:
+  - type: github
+    config:
+      organization: banzaicloud
+    map:
+      users:
+        zoe: admin
+        bob: admin
+      teams:
+        dev: dev
+secrets:
+  - type: aws
+    configuration:
+      roles:
+        - name: reader
+      config/root:
+        - name: default
+      config/lease:
+        - name: default
+`)
+
+	// The maps are applied in the same order in every run
+	for i := 0; i < 5; i++ {
+		report, err := v.ConfigureWithReport(config)
+		if err != nil {
+			t.Fatalf("error configuring vault: %s", err.Error())
+		}
+		names := []string{}
+		for _, result := range report.Resources {
+			names = append(names, result.Name)
+		}
+		expected := []string{
+			"github",
+			"auth/github/map/teams/dev",
+			"auth/github/map/users/bob",
+			"auth/github/map/users/zoe",
+			"aws",
+			"aws/config/lease/default",
+			"aws/config/root/default",
+			"aws/roles/reader",
+		}
+		if strings.Join(names, " ") != strings.Join(expected, " ") {
+			t.Fatalf("unexpected order of the resources:\n%v\nexpected:\n%v", names, expected)
+		}
+	}
+}
+
+func TestUnsealRetry(t *testing.T) {
+	store := kvtest.New()
+	v, server := newTestVault(t, store)
+	defer server.Close()
+	v.(*vault).config.Retries = 1
+
+	if _, err := v.Init(context.Background()); err != nil {
+		t.Fatalf("error initializing vault: %s", err.Error())
+	}
+
+	failed := false
+	store.SetHook(func(operation, key string) error {
+		if operation == kvtest.OperationGet && key == "vault-unseal-1" && !failed {
+			failed = true
+			return errors.New("dial tcp 10.0.0.1:443: connect: connection refused")
+		}
+		return nil
+	})
+	if err := v.Unseal(context.Background()); err != nil {
+		t.Fatalf("expected the retryable error to be retried, got: %v", err)
+	}
+
+	store.SetHook(nil)
+	store.FailOn(kvtest.OperationGet, "vault-unseal-0", errors.New("AccessDeniedException: permission denied"))
+	calls := len(store.Calls())
+	err := v.Unseal(context.Background())
+	if _, ok := err.(*FatalError); !ok || IsRetryable(err) {
+		t.Fatalf("expected a fatal error, got: %v", err)
+	}
+	gets := 0
+	for _, call := range store.Calls()[calls:] {
+		if call.Operation == kvtest.OperationGet && call.Key == "vault-unseal-0" {
+			gets++
+		}
+	}
+	if gets != 1 {
+		t.Errorf("the fatal error has been retried, the key has been read %d times", gets)
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	store := kvtest.New()
+	v, server := newTestVault(t, store)
+	defer server.Close()
+	config := v.(*vault).config
+	config.RetryInitialInterval = time.Millisecond
+	config.RetryJitter = 0.5
+	config.RetryOverrides = map[RetryOperation]RetryPolicy{
+		RetryKeyStore: {MaxRetries: 3, InitialInterval: time.Millisecond, MaxInterval: 2 * time.Millisecond},
+	}
+
+	if _, err := v.Init(context.Background()); err != nil {
+		t.Fatalf("error initializing vault: %s", err.Error())
+	}
+
+	// The key store is throttled twice, the default policy wouldn't retry it
+	failures := 0
+	store.SetHook(func(operation, key string) error {
+		if operation == kvtest.OperationGet && key == "vault-unseal-0" && failures < 2 {
+			failures++
+			return errors.New("ThrottlingException: Rate exceeded")
+		}
+		return nil
+	})
+	if err := v.Unseal(context.Background()); err != nil {
+		t.Fatalf("expected the key store to be retried by its own policy, got: %v", err)
+	}
+
+	server.Seal()
+	failures = 0
+	config.RetryOverrides = nil
+	if err := v.Unseal(context.Background()); !IsRetryable(err) || !errors.Is(err, ErrKeyStoreUnavailable) {
+		t.Fatalf("expected the retryable error of the key store without retries, got: %v", err)
+	}
+}
+
+func TestUnsealAll(t *testing.T) {
+	store := kvtest.New()
+	v, server := newTestVault(t, store)
+	defer server.Close()
+	if _, err := v.Init(context.Background()); err != nil {
+		t.Fatalf("error initializing vault: %s", err.Error())
+	}
+	uninitialized := vaulttest.NewServer()
+	defer uninitialized.Close()
+
+	clients := NewClientPool(func() (*api.Config, error) {
+		config := api.DefaultConfig()
+		config.MaxRetries = 0
+		return config, nil
+	}, nil)
+	addresses := []string{server.URL(), uninitialized.URL(), "http://127.0.0.1:1"}
+	statuses, err := UnsealAll(context.Background(), store, clients, Config{SecretShares: 5, SecretThreshold: 3}, addresses)
+	if err != nil {
+		t.Fatalf("error unsealing the nodes: %s", err.Error())
+	}
+	if len(statuses) != 3 {
+		t.Fatalf("expected 3 statuses, got: %+v", statuses)
+	}
+
+	if server.Sealed() {
+		t.Errorf("the initialized node hasn't been unsealed")
+	}
+	if status := statuses[0]; status.Address != server.URL() || status.Error != "" || !status.Initialized || status.Sealed ||
+		status.Threshold != 3 || status.Version != vaulttest.Version || status.HAMode != HAModeDisabled {
+		t.Errorf("unexpected status of the initialized node: %+v", status)
+	}
+	if status := statuses[1]; status.Error != "" || status.Initialized || uninitialized.Initialized() {
+		t.Errorf("the uninitialized node hasn't been skipped: %+v", status)
+	}
+	if status := statuses[2]; status.Error == "" {
+		t.Errorf("expected an error of the unreachable node: %+v", status)
+	}
+}
+
+func TestUnsealContext(t *testing.T) {
+	store := kvtest.New()
+	v, server := newTestVault(t, store)
+	defer server.Close()
+
+	if _, err := v.Init(context.Background()); err != nil {
+		t.Fatalf("error initializing vault: %s", err.Error())
+	}
+
+	// The key store hangs until the test returns
+	release := make(chan struct{})
+	defer close(release)
+	store.SetHook(func(operation, key string) error {
+		if operation == kvtest.OperationGet {
+			<-release
+		}
+		return nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	err := v.Unseal(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the deadline of the context to be exceeded, got: %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("Unseal returned %s after the deadline of the context", elapsed)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if _, err := v.Init(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected Init to return the error of the cancelled context, got: %v", err)
+	}
+	if sealed, err := v.Sealed(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected Sealed to return the error of the cancelled context, got: %t, %v", sealed, err)
+	}
+}
+
+func TestConfigureReadBack(t *testing.T) {
+	store := kvtest.New()
+	server := vaulttest.NewServer()
+	defer server.Close()
+	client, err := server.Client()
+	if err != nil {
+		t.Fatalf("error creating the client of the fake vault: %s", err.Error())
+	}
+	v, err := New(store, client, Config{SecretShares: 1, SecretThreshold: 1, StoreRootToken: true, ReadBack: true})
+	if err != nil {
+		t.Fatalf("error creating vault: %s", err.Error())
+	}
+
+	if _, err := v.Init(context.Background()); err != nil {
+		t.Fatalf("error initializing vault: %s", err.Error())
+	}
+	if err := v.Unseal(context.Background()); err != nil {
+		t.Fatalf("error unsealing vault: %s", err.Error())
+	}
+
+	config := parseTestConfig(t, testConfig)
+
+	report, err := v.ConfigureWithReport(config)
+	if err != nil {
+		t.Fatalf("error configuring vault: %s", err.Error())
+	}
+	if report.ReadBack == nil || report.ReadBack.Checked != 4 || len(report.ReadBack.Mismatches) != 0 {
+		t.Fatalf("unexpected read back: %+v", report.ReadBack)
+	}
+
+	// Vault changes a field silently
+	server.SetData("db/config/mysql", map[string]interface{}{"plugin_name": "mysql-legacy-database-plugin"})
+	clearToken, err := v.(*vault).useRootToken()
+	if err != nil {
+		t.Fatalf("error reading the root token: %s", err.Error())
+	}
+	defer clearToken()
+
+	result, err := v.(*vault).readBack(config)
+	if err != nil {
+		t.Fatalf("error reading back vault configuration: %s", err.Error())
+	}
+	if len(result.Mismatches) != 1 || result.Mismatches[0].Path != "db/config/mysql" || result.Mismatches[0].Fields["plugin_name"].Live != "mysql-legacy-database-plugin" {
+		t.Fatalf("unexpected mismatches: %+v", result.Mismatches)
+	}
+}
+
+func TestConfigureExternalConfigLiteral(t *testing.T) {
+	store := kvtest.New()
+	v, server := newTestVault(t, store)
+	defer server.Close()
+
+	if _, err := v.Init(context.Background()); err != nil {
+		t.Fatalf("error initializing vault: %s", err.Error())
+	}
+	if err := v.Unseal(context.Background()); err != nil {
+		t.Fatalf("error unsealing vault: %s", err.Error())
+	}
+
+	config := &ExternalConfig{
+		Policies: []map[string]string{{"name": "allow_secrets", "rules": `path "secret/*" { capabilities = ["read"] }`}},
+		Secrets:  []map[string]interface{}{{"type": "kv", "path": "secret"}},
+	}
+	if err := v.Configure(config); err != nil {
+		t.Fatalf("error configuring vault: %s", err.Error())
+	}
+	if _, ok := server.Policy("allow_secrets"); !ok {
+		t.Errorf("the policy hasn't been applied")
+	}
+
+	changes, err := v.Diff(config)
+	if err != nil {
+		t.Fatalf("error comparing vault configuration: %s", err.Error())
+	}
+	for _, change := range changes {
+		if change.Action != ConfigChangeDelete {
+			t.Errorf("unexpected change after configure: %+v", change)
+		}
+	}
+}
+
+func TestKeyNames(t *testing.T) {
+	if err := (KeyNames{UnsealKey: "{{.Cluster}}-unseal"}).Validate(); err == nil {
+		t.Errorf("expected the unseal key names without the index to collide")
+	}
+	if err := (KeyNames{RootToken: "{{.Name}}-root"}).Validate(); err == nil {
+		t.Errorf("expected an error for an unknown field of the template")
+	}
+
+	// Two clusters share a key store
+	store := kvtest.New()
+	for _, cluster := range []string{"a", "b"} {
+		server := vaulttest.NewServer()
+		defer server.Close()
+		client, err := server.Client()
+		if err != nil {
+			t.Fatalf("error creating the client of the fake vault: %s", err.Error())
+		}
+		names := KeyNames{Cluster: cluster, UnsealKey: "{{.Cluster}}-unseal-{{.Index}}", RootToken: "{{.Cluster}}-root", Test: "{{.Cluster}}-test", KeysMetadata: "{{.Cluster}}-keys-metadata"}
+		v, err := New(store, client, Config{SecretShares: 5, SecretThreshold: 3, StoreRootToken: true, KeyNames: names})
+		if err != nil {
+			t.Fatalf("error creating vault: %s", err.Error())
+		}
+		if _, err := v.Init(context.Background()); err != nil {
+			t.Fatalf("error initializing vault %s: %s", cluster, err.Error())
+		}
+		if err := v.Unseal(context.Background()); err != nil {
+			t.Fatalf("error unsealing vault %s: %s", cluster, err.Error())
+		}
+		for _, key := range []string{cluster + "-unseal-4", cluster + "-root", cluster + "-keys-metadata"} {
+			if store.Value(key) == nil {
+				t.Errorf("key %s hasn't been stored", key)
+			}
+		}
+	}
+	if store.Value(RootTokenKey) != nil {
+		t.Errorf("the root token has been stored with the default name")
+	}
+
+	// The keys of a cluster are migrated to the default names
+	source := KeyNames{Cluster: "b", UnsealKey: "{{.Cluster}}-unseal-{{.Index}}", RootToken: "{{.Cluster}}-root", KeysMetadata: "{{.Cluster}}-keys-metadata"}
+	destination := kvtest.New()
+	if _, err := MigrateKeys(store, destination, source, KeyNames{}); err != nil {
+		t.Fatalf("error migrating the keys: %s", err.Error())
+	}
+	if string(destination.Value("vault-unseal-0")) != string(store.Value("b-unseal-0")) {
+		t.Errorf("the unseal key hasn't been migrated to its default name")
+	}
+	if _, err := StoredKeys(destination, KeyNames{}); err != nil {
+		t.Errorf("error reading the migrated keys: %s", err.Error())
+	}
+}
+
+// tableKeyNamer names the keys after a table, the names of the unseal keys of another tool
+type tableKeyNamer map[string]string
+
+func (n tableKeyNamer) UnsealKeyName(i int) string   { return n["unseal"] + strconv.Itoa(i) }
+func (n tableKeyNamer) RecoveryKeyName(i int) string { return n["recovery"] + strconv.Itoa(i) }
+func (n tableKeyNamer) RootTokenName() string        { return n["root"] }
+func (n tableKeyNamer) TestKeyName() string          { return n["test"] }
+func (n tableKeyNamer) KeysMetadataName() string     { return n["metadata"] }
+
+func TestKeyNamePrefixAndNamer(t *testing.T) {
+	store := kvtest.New()
+	v, server := newTestVault(t, store)
+	defer server.Close()
+	v.(*vault).config.KeyNames = KeyNames{Prefix: "prod-eu/"}
+
+	if _, err := v.Init(context.Background()); err != nil {
+		t.Fatalf("error initializing vault: %s", err.Error())
+	}
+	for _, key := range []string{"prod-eu/vault-unseal-0", "prod-eu/" + RootTokenKey, "prod-eu/" + KeysMetadataKey} {
+		if store.Value(key) == nil {
+			t.Errorf("key %s hasn't been stored", key)
+		}
+	}
+
+	namer := tableKeyNamer{"unseal": "unseal-", "recovery": "recovery-", "root": "root", "test": "test", "metadata": "metadata"}
+	if err := ValidateKeyNamer(tableKeyNamer{"unseal": "key", "recovery": "key", "root": "root", "test": "test", "metadata": "metadata"}); err == nil {
+		t.Errorf("expected the unseal and recovery keys to collide")
+	}
+	otherServer := vaulttest.NewServer()
+	defer otherServer.Close()
+	client, err := otherServer.Client()
+	if err != nil {
+		t.Fatalf("error creating the client of the fake vault: %s", err.Error())
+	}
+	other := kvtest.New()
+	custom, err := New(other, client, Config{SecretShares: 1, SecretThreshold: 1, KeyNamer: namer})
+	if err != nil {
+		t.Fatalf("error creating vault: %s", err.Error())
+	}
+	if _, err := custom.Init(context.Background()); err != nil {
+		t.Fatalf("error initializing vault: %s", err.Error())
+	}
+	if err := custom.Unseal(context.Background()); err != nil {
+		t.Fatalf("error unsealing vault: %s", err.Error())
+	}
+	if other.Value("unseal-0") == nil || other.Value("metadata") == nil {
+		t.Errorf("the keys haven't been stored with the names of the namer")
+	}
+}
+
+func TestMergeConfigFragments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vault-config")
+	if err != nil {
+		t.Fatalf("error creating the config directory: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"10-policies.yml": "policies:\n  - name: allow_secrets\n    rules: path \"secret/*\" { capabilities = [\"read\"] }\n",
+		"20-auth.yaml":    "auth:\n  - type: userpass\n",
+		"30-team-a.json":  `{"secrets": [{"type": "kv", "path": "team-a"}], "policies": [{"name": "team-a", "rules": "path \"team-a/*\" { capabilities = [\"read\"] }"}]}`,
+		"README.md":       "not a config file",
+		".hidden.yml":     "auth: []",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatalf("error writing %s: %s", name, err.Error())
+		}
+	}
+
+	paths, err := ConfigFiles([]string{dir})
+	if err != nil {
+		t.Fatalf("error listing the config files: %s", err.Error())
+	}
+	if len(paths) != 3 || filepath.Base(paths[0]) != "10-policies.yml" || filepath.Base(paths[2]) != "30-team-a.json" {
+		t.Fatalf("unexpected config files: %v", paths)
+	}
+
+	config, err := FilesConfigSource(dir)()
+	if err != nil {
+		t.Fatalf("error reading the config directory: %s", err.Error())
+	}
+	if len(config.Policies) != 2 || config.Policies[1]["name"] != "team-a" || len(config.Auth) != 1 || len(config.Secrets) != 1 {
+		t.Fatalf("unexpected merged config: %#v", config)
+	}
+
+	// Another team defines the same secret engine
+	_, err = MergeConfigFragments([]ConfigFragment{
+		{Source: "team-a.yml", Settings: map[string]interface{}{"secrets": []interface{}{map[string]interface{}{"type": "kv", "path": "shared/"}}}},
+		{Source: "team-b.yml", Settings: map[string]interface{}{"secrets": []interface{}{map[string]interface{}{"type": "kv", "path": "shared"}}}},
+	})
+	if err == nil || !strings.Contains(err.Error(), "team-b.yml: secrets shared is defined by team-a.yml already") {
+		t.Errorf("expected a conflict of the secret engines, got: %v", err)
+	}
+	_, err = MergeConfigFragments([]ConfigFragment{
+		{Source: "a.yml", Settings: map[string]interface{}{"apiVersion": "v1"}},
+		{Source: "b.yml", Settings: map[string]interface{}{"apiVersion": "v2"}},
+	})
+	if err == nil {
+		t.Errorf("expected a conflict of the api versions")
+	}
+}
+
+func TestExternalConfigMarshal(t *testing.T) {
+	config, err := UnmarshalExternalConfig([]byte(testConfig + "    unknown: 1\n"))
+	if err != nil {
+		t.Fatalf("error unmarshaling the config: %s", err.Error())
+	}
+	if len(config.Policies) != 1 || config.Policies[0]["name"] != "allow_secrets" || len(config.Secrets) != 1 {
+		t.Fatalf("unexpected config: %#v", config)
+	}
+	if err := config.Validate(false); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err, ok := config.Validate(true).(ConfigErrors); !ok || len(err) != 1 {
+		t.Errorf("expected the unknown field in strict mode, got: %v", err)
+	}
+
+	// The unknown fields are kept, and the YAML, JSON and yaml.v2 formats are the same
+	data, err := json.Marshal(config)
+	if err != nil {
+		t.Fatalf("error marshaling the config: %s", err.Error())
+	}
+	var fromJSON ExternalConfig
+	if err := json.Unmarshal(data, &fromJSON); err != nil {
+		t.Fatalf("error unmarshaling the config: %s", err.Error())
+	}
+	var fromYAML ExternalConfig
+	if err := yamlv2.Unmarshal([]byte(testConfig+"    unknown: 1\n"), &fromYAML); err != nil {
+		t.Fatalf("error unmarshaling the config: %s", err.Error())
+	}
+	for _, other := range []*ExternalConfig{&fromJSON, &fromYAML} {
+		if changes := diffConfigObjects(configObjects(config.sections()), configObjects(other.sections())); len(changes) > 0 {
+			t.Errorf("the config has changed: %v", changes)
+		}
+		if err, ok := other.Validate(true).(ConfigErrors); !ok || len(err) != 1 {
+			t.Errorf("the unknown field has been dropped, got: %v", err)
+		}
+	}
+}
+
+func TestNewAPIClient(t *testing.T) {
+	server := vaulttest.NewServer()
+	defer server.Close()
+
+	client, err := NewAPIClient(ClientOptions{Address: server.URL(), Token: "token", Namespace: "team-a", Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("error creating the client: %s", err.Error())
+	}
+	if client.Address() != server.URL() || client.Token() != "token" {
+		t.Errorf("unexpected address or token: %s %s", client.Address(), client.Token())
+	}
+	if _, err := client.Sys().SealStatus(); err != nil {
+		t.Errorf("error sending a request: %s", err.Error())
+	}
+
+	config, err := ClientOptions{AgentAddress: "http://127.0.0.1:8100", Address: server.URL(), Proxy: "http://proxy:3128"}.NewConfig()
+	if err != nil {
+		t.Fatalf("error creating the config: %s", err.Error())
+	}
+	if config.Address != "http://127.0.0.1:8100" {
+		t.Errorf("the requests aren't sent to the agent: %s", config.Address)
+	}
+
+	if _, err := (ClientOptions{CACertPEM: []byte("not a certificate")}).NewConfig(); err == nil {
+		t.Errorf("expected an error for an invalid CA certificate")
+	}
+	if _, err := (ClientOptions{ClientCertPEM: []byte("not a certificate"), ClientKeyPEM: []byte("not a key")}).NewConfig(); err == nil {
+		t.Errorf("expected an error for an invalid client certificate")
+	}
+	if _, err := (ClientOptions{ClientCert: "client.crt", ClientCertPEM: []byte("cert")}).NewConfig(); err == nil {
+		t.Errorf("expected an error for a client certificate given both as a file and as PEM data")
+	}
+}
+
+func TestConfigMigration(t *testing.T) {
+	defer func(migrations []configMigration, deprecated []deprecatedConfigKey) {
+		configMigrations, deprecatedConfigKeys = migrations, deprecated
+	}(configMigrations, deprecatedConfigKeys)
+	configMigrations = []configMigration{{from: "v0", to: ConfigAPIVersion, migrate: func(settings map[string]interface{}, warn func(path, message string)) {
+		settings["secrets"] = settings["mounts"]
+		delete(settings, "mounts")
+		warn("mounts", "renamed to secrets")
+	}}}
+	deprecatedConfigKeys = []deprecatedConfigKey{{section: "secrets", key: "plugin", replacement: "plugin_name"}}
+
+	settings := map[string]interface{}{
+		"apiversion": "v0",
+		"mounts":     []interface{}{map[interface{}]interface{}{"type": "database", "plugin": "mysql-database-plugin"}},
+	}
+	config, err := ParseExternalConfig(settings)
+	if err != nil {
+		t.Fatalf("error parsing the config: %s", err.Error())
+	}
+	if config.APIVersion != ConfigAPIVersion || len(config.Secrets) != 1 || config.Secrets[0]["plugin_name"] != "mysql-database-plugin" {
+		t.Errorf("the config hasn't been migrated: %#v", config)
+	}
+	if _, ok := settings["mounts"]; !ok {
+		t.Errorf("the settings of the caller have been changed")
+	}
+
+	if errs := VerifyConfig(settings); len(errs) != 0 {
+		t.Errorf("unexpected errors: %v", ConfigErrors(errs))
+	}
+	if errs := VerifyConfigStrict(settings); len(errs) != 2 {
+		t.Errorf("expected the deprecations in strict mode, got: %v", ConfigErrors(errs))
+	}
+
+	if _, err := ParseExternalConfig(map[string]interface{}{"apiVersion": "v2"}); err == nil {
+		t.Errorf("expected an error for an unsupported version")
+	}
+	if config, err := ParseExternalConfig(map[string]interface{}{}); err != nil || config.APIVersion != ConfigAPIVersion {
+		t.Errorf("the unversioned config isn't the current version: %v", err)
+	}
+}
+
+func TestExternalConfigHCL(t *testing.T) {
+	const hclConfig = `
+auth = [
+  { type = "userpass", path = "people" },
+]
+
+policies {
+  name = "allow_secrets"
+  rules = "path \"secret/*\" { capabilities = [\"read\"] }"
+}
+
+secrets {
+  type = "database"
+  path = "db"
+  options {
+    max_versions = 3
+  }
+  configuration {
+    config = [
+      { name = "mysql", plugin_name = "mysql-database-plugin" },
+    ]
+  }
+}
+`
+	cfg := viper.New()
+	cfg.SetConfigType("hcl")
+	if err := cfg.ReadConfig(strings.NewReader(hclConfig)); err != nil {
+		t.Fatalf("error reading the config: %s", err.Error())
+	}
+	if errs := VerifyConfigStrict(cfg.AllSettings()); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", ConfigErrors(errs))
+	}
+	config, err := ParseExternalConfig(cfg.AllSettings())
+	if err != nil {
+		t.Fatalf("error parsing the config: %s", err.Error())
+	}
+
+	expected, _ := json.Marshal(parseTestConfig(t, testConfig))
+	actual, _ := json.Marshal(config)
+	if string(actual) != string(expected) {
+		t.Errorf("the HCL config differs from the YAML one:\n%s\n%s", actual, expected)
+	}
+}
+
+func TestOverrideConfigFromEnv(t *testing.T) {
+	cfg := viper.New()
+	cfg.SetConfigType("yaml")
+	if err := cfg.ReadConfig(strings.NewReader(testConfig)); err != nil {
+		t.Fatalf("error reading the config: %s", err.Error())
+	}
+	settings := cfg.AllSettings()
+
+	overridden, err := OverrideConfigFromEnv(settings, []string{
+		"PATH=/bin",
+		"VAULT_CONFIG_SECRETS__DB__OPTIONS__MAX_VERSIONS=5",
+		"VAULT_CONFIG_SECRETS__0__CONFIGURATION__CONFIG__MYSQL__CONNECTION_URL=root@tcp(mysql:3306)/",
+		"VAULT_CONFIG_AUTH__PEOPLE__DESCRIPTION=users",
+		`VAULT_CONFIG_POLICIES__1={"name": "extra", "rules": "path \"a\" { capabilities = [\"read\"] }"}`,
+		"VAULT_CONFIG_AUTH__1__TYPE=github",
+	})
+	if err != nil {
+		t.Fatalf("error overriding the config: %s", err.Error())
+	}
+	config, err := ParseExternalConfig(overridden)
+	if err != nil {
+		t.Fatalf("error parsing the config: %s", err.Error())
+	}
+
+	secret := config.Secrets[0]
+	if options := secret["options"].(map[string]interface{}); options["max_versions"] != "5" {
+		t.Errorf("options haven't been overridden: %v", options)
+	}
+	mysql := secret["configuration"].(map[string]interface{})["config"].([]interface{})[0].(map[string]interface{})
+	if mysql["connection_url"] != "root@tcp(mysql:3306)/" || mysql["plugin_name"] != "mysql-database-plugin" {
+		t.Errorf("configuration hasn't been overridden: %v", mysql)
+	}
+	if len(config.Auth) != 2 || config.Auth[0]["description"] != "users" || config.Auth[1]["type"] != "github" {
+		t.Errorf("auth hasn't been overridden: %v", config.Auth)
+	}
+	if len(config.Policies) != 2 || config.Policies[1]["name"] != "extra" {
+		t.Errorf("the policy hasn't been appended: %v", config.Policies)
+	}
+	if settings["secrets"].([]interface{})[0].(map[interface{}]interface{})["options"].(map[interface{}]interface{})["max_versions"] != 3 {
+		t.Errorf("the settings of the caller have been changed")
+	}
+
+	if _, err := OverrideConfigFromEnv(settings, []string{"VAULT_CONFIG_SECRETS__KV__PATH=kv"}); err == nil {
+		t.Errorf("expected an error for a missing item")
+	}
+}
+
+func TestConfigureCreateOnly(t *testing.T) {
+	store := kvtest.New()
+	v, server := newTestVault(t, store)
+	defer server.Close()
+
+	if _, err := v.Init(context.Background()); err != nil {
+		t.Fatalf("error initializing vault: %s", err.Error())
+	}
+	if err := v.Unseal(context.Background()); err != nil {
+		t.Fatalf("error unsealing vault: %s", err.Error())
+	}
+
+	config := parseTestConfig(t, strings.Replace(strings.Replace(testConfig,
+		"    rules:", "    create_only: true\n    rules:", 1),
+		"        - name: mysql", "        - name: mysql\n          create_only: true", 1))
+	if errs := VerifyConfigStrict(config.sections()); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", ConfigErrors(errs))
+	}
+
+	report, err := v.ConfigureWithReport(config)
+	if err != nil {
+		t.Fatalf("error configuring vault: %s", err.Error())
+	}
+	if summary := report.Summary(); summary != (ConfigureSummary{Created: 4, Duration: report.Duration}) {
+		t.Errorf("unexpected summary: %s", summary)
+	}
+	if _, ok := server.Data("db/config/mysql")[createOnlyField]; ok {
+		t.Errorf("%s has been written to vault", createOnlyField)
+	}
+
+	// The resources are changed manually
+	server.SetData("db/config/mysql", map[string]interface{}{"plugin_name": "mysql-legacy-database-plugin"})
+	client, _ := server.Client()
+	client.SetToken(server.RootToken())
+	if err := client.Sys().PutPolicy("allow_secrets", `path "secret/*" { capabilities = ["list"] }`); err != nil {
+		t.Fatalf("error changing the policy: %s", err.Error())
+	}
+
+	report, err = v.ConfigureWithReport(config)
+	if err != nil {
+		t.Fatalf("error configuring vault: %s", err.Error())
+	}
+	if summary := report.Summary(); summary.Updated != 2 || summary.Skipped != 2 {
+		t.Errorf("unexpected summary: %s", summary)
+	}
+	skipped := map[string]bool{}
+	for _, result := range report.Resources {
+		if result.Action == ActionSkipped {
+			skipped[result.Name] = true
+		}
+	}
+	if len(skipped) != 2 || !skipped["allow_secrets"] || !skipped["db/config/mysql"] {
+		t.Errorf("unexpected skipped resources: %+v", report.Resources)
+	}
+	if rules, _ := server.Policy("allow_secrets"); !strings.Contains(rules, "list") {
+		t.Errorf("the policy has been overwritten: %s", rules)
+	}
+	if data := server.Data("db/config/mysql"); data["plugin_name"] != "mysql-legacy-database-plugin" {
+		t.Errorf("the configuration has been overwritten: %v", data)
+	}
+
+	changes, err := v.Diff(config)
+	if err != nil {
+		t.Fatalf("error comparing vault configuration: %s", err.Error())
+	}
+	for _, change := range changes {
+		if change.Action == ConfigChangeUpdate {
+			t.Errorf("unexpected change of a create only resource: %+v", change)
+		}
+	}
+}
+
+func TestConfigureHooks(t *testing.T) {
+	store := kvtest.New()
+	server := vaulttest.NewServer()
+	defer server.Close()
+	client, err := server.Client()
+	if err != nil {
+		t.Fatalf("error creating the client of the fake vault: %s", err.Error())
+	}
+
+	var events []string
+	reject := ""
+	hook := HookFunc(func(event *HookEvent) error {
+		events = append(events, event.Name())
+		if event.Name() == reject {
+			return errors.New("rejected")
+		}
+		if event.Stage == HookStagePost && event.Phase == HookPhaseConfigure && event.Error == "" && (event.Report == nil || len(event.Report.Resources) != 4) {
+			t.Errorf("unexpected report in %s: %+v", event.Name(), event.Report)
+		}
+		return nil
+	})
+	v, err := New(store, client, Config{SecretShares: 1, SecretThreshold: 1, StoreRootToken: true, Hooks: []Hook{hook}})
+	if err != nil {
+		t.Fatalf("error creating vault: %s", err.Error())
+	}
+
+	if _, err := v.Init(context.Background()); err != nil {
+		t.Fatalf("error initializing vault: %s", err.Error())
+	}
+	if err := v.Unseal(context.Background()); err != nil {
+		t.Fatalf("error unsealing vault: %s", err.Error())
+	}
+	config := parseTestConfig(t, testConfig)
+	if err := v.Configure(config); err != nil {
+		t.Fatalf("error configuring vault: %s", err.Error())
+	}
+	expected := "pre-init post-init pre-unseal post-unseal pre-configure pre-configure-audit post-configure-audit " +
+		"pre-configure-auth post-configure-auth pre-configure-policies post-configure-policies " +
+		"pre-configure-secrets post-configure-secrets post-configure"
+	if strings.Join(events, " ") != expected {
+		t.Errorf("unexpected events: %v", events)
+	}
+
+	// A pre hook aborts the phase
+	events, reject = nil, "pre-configure-policies"
+	server.Seal()
+	if err := v.Unseal(context.Background()); err != nil {
+		t.Fatalf("error unsealing vault: %s", err.Error())
+	}
+	report, err := v.ConfigureWithReport(config)
+	if hookErr, ok := err.(*HookError); !ok || hookErr.Event != reject {
+		t.Fatalf("expected a hook error, got: %v", err)
+	}
+	if len(report.Resources) != 1 {
+		t.Errorf("unexpected resources: %+v", report.Resources)
+	}
+	if events[len(events)-1] != "post-configure" {
+		t.Errorf("the post hooks haven't been called: %v", events)
+	}
+}
+
+// testConfigurator writes the roles of the transform section to transform/role/<name>
+type testConfigurator struct{}
+
+func (testConfigurator) Section() string {
+	return "transform"
+}
+
+func (testConfigurator) Validate(value interface{}) []*ConfigError {
+	var errs []*ConfigError
+	for i, item := range value.([]interface{}) {
+		if item.(map[string]interface{})["name"] == nil {
+			errs = append(errs, &ConfigError{Path: "[" + strconv.Itoa(i) + "].name", Message: "required value"})
+		}
+	}
+	return errs
+}
+
+func (testConfigurator) Configure(client *api.Client, value interface{}, report *ConfigureReport) error {
+	for _, item := range value.([]interface{}) {
+		started := time.Now()
+		role := item.(map[string]interface{})
+		name := role["name"].(string)
+		_, err := client.Logical().Write("transform/role/"+name, map[string]interface{}{"transformations": role["transformations"]})
+		report.Record("transform role", name, false, started, err)
+	}
+	return nil
+}
+
+func TestConfigureCustomSection(t *testing.T) {
+	transform := `
+transform:
+  - name: payments
+    transformations: card-number
+`
+	if errs := VerifyConfig(parseTestConfig(t, testConfig+transform).sections()); len(errs) != 1 || errs[0].Path != "transform" {
+		t.Fatalf("expected an unsupported section, got: %v", ConfigErrors(errs))
+	}
+
+	RegisterConfigurator(testConfigurator{})
+	defer func() {
+		configuratorsLock.Lock()
+		delete(configurators, "transform")
+		configuratorsLock.Unlock()
+	}()
+
+	invalid := parseTestConfig(t, testConfig+transform+"  - transformations: ssn\n")
+	if errs := VerifyConfig(invalid.sections()); len(errs) != 1 || errs[0].Path != "transform[1].name" {
+		t.Fatalf("unexpected errors: %v", ConfigErrors(errs))
+	}
+
+	store := kvtest.New()
+	v, server := newTestVault(t, store)
+	defer server.Close()
+	if _, err := v.Init(context.Background()); err != nil {
+		t.Fatalf("error initializing vault: %s", err.Error())
+	}
+	if err := v.Unseal(context.Background()); err != nil {
+		t.Fatalf("error unsealing vault: %s", err.Error())
+	}
+
+	report, err := v.ConfigureWithReport(parseTestConfig(t, testConfig+transform))
+	if err != nil {
+		t.Fatalf("error configuring vault: %s", err.Error())
+	}
+	if summary := report.Summary(); summary.Created != 5 {
+		t.Errorf("unexpected summary: %s", summary)
+	}
+	if data := server.Data("transform/role/payments"); data["transformations"] != "card-number" {
+		t.Errorf("unexpected transform role: %v", data)
+	}
+}
+
+func TestConfigureApprole(t *testing.T) {
+	store := kvtest.New()
+	v, server := newTestVault(t, store)
+	defer server.Close()
+	if _, err := v.Init(context.Background()); err != nil {
+		t.Fatalf("error initializing vault: %s", err.Error())
+	}
+	if err := v.Unseal(context.Background()); err != nil {
+		t.Fatalf("error unsealing vault: %s", err.Error())
+	}
+
+	config := parseTestConfig(t, `
+auth:
+  - type: approle
+    path: ci
+    roles:
+      - name: deploy
+        role_id: deploy-role
+        secret_id_ttl: 10m
+        token_policies: allow_secrets
+        token_ttl: 1h
+`)
+	if errs := VerifyConfigStrict(config.sections()); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", ConfigErrors(errs))
+	}
+	if err := v.Configure(config); err != nil {
+		t.Fatalf("error configuring vault: %s", err.Error())
+	}
+
+	role := server.Data("auth/ci/role/deploy")
+	if role["token_policies"] != "allow_secrets" || role["secret_id_ttl"] != "10m" {
+		t.Errorf("unexpected role: %v", role)
+	}
+	if _, ok := role["role_id"]; ok {
+		t.Errorf("the role_id has been written to the role: %v", role)
+	}
+	if roleID := server.Data("auth/ci/role/deploy/role-id"); roleID["role_id"] != "deploy-role" {
+		t.Errorf("unexpected role_id: %v", roleID)
+	}
+
+	// The role_id is compared with its own path
+	objects := configObjects(config.desiredSections())
+	if _, ok := objects["auth/ci/role/deploy"].(map[string]interface{})["role_id"]; ok {
+		t.Errorf("the role_id is compared with the role: %v", objects)
+	}
+	if roleID := objects["auth/ci/role/deploy/role-id"]; roleID == nil {
+		t.Errorf("the role_id isn't compared: %v", objects)
+	}
+}
+
+func TestConfigureJwt(t *testing.T) {
+	store := kvtest.New()
+	v, server := newTestVault(t, store)
+	defer server.Close()
+	if _, err := v.Init(context.Background()); err != nil {
+		t.Fatalf("error initializing vault: %s", err.Error())
+	}
+	if err := v.Unseal(context.Background()); err != nil {
+		t.Fatalf("error unsealing vault: %s", err.Error())
+	}
+
+	config := parseTestConfig(t, `
+auth:
+  - type: oidc
+    path: dex
+    config:
+      oidc_discovery_url: https://dex.example.com
+      oidc_client_id: vault
+      oidc_client_secret: dex-secret
+      default_role: developer
+    roles:
+      - name: developer
+        bound_audiences: vault
+        user_claim: email
+        claim_mappings:
+          email: email
+`)
+	if errs := VerifyConfigStrict(config.sections()); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", ConfigErrors(errs))
+	}
+	if err := v.Configure(config); err != nil {
+		t.Fatalf("error configuring vault: %s", err.Error())
+	}
+
+	if auth := server.Auths()["dex/"]; auth == nil || auth.Type != "oidc" {
+		t.Errorf("the oidc auth method isn't enabled at dex: %v", server.Auths())
+	}
+	if jwtConfig := server.Data("auth/dex/config"); jwtConfig["default_role"] != "developer" || jwtConfig["oidc_client_secret"] != "dex-secret" {
+		t.Errorf("unexpected config: %v", jwtConfig)
+	}
+	role := server.Data("auth/dex/role/developer")
+	if role["bound_audiences"] != "vault" || cast.ToStringMap(role["claim_mappings"])["email"] != "email" {
+		t.Errorf("unexpected role: %v", role)
+	}
+
+	errs := VerifyConfig(parseTestConfig(t, `
+auth:
+  - type: jwt
+    roles:
+      - name: developer
+        claim_mappings: email
+`).sections())
+	if len(errs) != 1 || errs[0].Path != "auth[0].roles[0].claim_mappings" {
+		t.Errorf("expected an invalid claim mappings error, got: %v", ConfigErrors(errs))
+	}
+}
+
+func TestConfigureGcpAzure(t *testing.T) {
+	store := kvtest.New()
+	v, server := newTestVault(t, store)
+	defer server.Close()
+	if _, err := v.Init(context.Background()); err != nil {
+		t.Fatalf("error initializing vault: %s", err.Error())
+	}
+	if err := v.Unseal(context.Background()); err != nil {
+		t.Fatalf("error unsealing vault: %s", err.Error())
+	}
+
+	config := parseTestConfig(t, `
+auth:
+  - type: gcp
+    roles:
+      - name: gke
+        type: iam
+        bound_service_accounts: app@project.iam.gserviceaccount.com
+  - type: azure
+    path: aks
+    config:
+      tenant_id: tenant
+      resource: https://management.azure.com/
+    roles:
+      - name: nodes
+        bound_resource_groups: aks-nodes
+`)
+	if errs := VerifyConfigStrict(config.sections()); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", ConfigErrors(errs))
+	}
+	if err := v.Configure(config); err != nil {
+		t.Fatalf("error configuring vault: %s", err.Error())
+	}
+
+	if role := server.Data("auth/gcp/role/gke"); role["bound_service_accounts"] != "app@project.iam.gserviceaccount.com" {
+		t.Errorf("unexpected gcp role: %v", role)
+	}
+	// The default credentials of Vault are used without a config
+	if gcpConfig := server.Data("auth/gcp/config"); gcpConfig != nil {
+		t.Errorf("an empty gcp config has been written: %v", gcpConfig)
+	}
+	if azureConfig := server.Data("auth/aks/config"); azureConfig["tenant_id"] != "tenant" {
+		t.Errorf("unexpected azure config: %v", azureConfig)
+	}
+	if role := server.Data("auth/aks/role/nodes"); role["bound_resource_groups"] != "aks-nodes" {
+		t.Errorf("unexpected azure role: %v", role)
+	}
+}
+
+func TestConfigureOktaRadiusCert(t *testing.T) {
+	store := kvtest.New()
+	v, server := newTestVault(t, store)
+	defer server.Close()
+	if _, err := v.Init(context.Background()); err != nil {
+		t.Fatalf("error initializing vault: %s", err.Error())
+	}
+	if err := v.Unseal(context.Background()); err != nil {
+		t.Fatalf("error unsealing vault: %s", err.Error())
+	}
+
+	config := parseTestConfig(t, `
+policies:
+  - name: dev
+    rules: path "secret/*" { capabilities = ["read"] }
+auth:
+  - type: okta
+    config:
+      org_name: example
+    groups:
+      developers:
+        policies: dev
+    users:
+      jane:
+        groups: developers
+  - type: radius
+    path: corp-radius
+    config:
+      host: radius.example.com
+    users:
+      john:
+        policies: dev
+  - type: cert
+    certs:
+      - name: web
+        certificate: web-ca
+        allowed_common_names: "*.example.com"
+        token_policies: dev
+`)
+	if errs := VerifyConfigStrict(config.sections()); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", ConfigErrors(errs))
+	}
+	if errs := VerifyPolicyReferences(config.sections()); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", ConfigErrors(errs))
+	}
+	if err := v.Configure(config); err != nil {
+		t.Fatalf("error configuring vault: %s", err.Error())
+	}
+
+	if oktaConfig := server.Data("auth/okta/config"); oktaConfig["org_name"] != "example" {
+		t.Errorf("unexpected okta config: %v", oktaConfig)
+	}
+	if group := server.Data("auth/okta/groups/developers"); group["policies"] != "dev" {
+		t.Errorf("unexpected okta group: %v", group)
+	}
+	if user := server.Data("auth/okta/users/jane"); user["groups"] != "developers" {
+		t.Errorf("unexpected okta user: %v", user)
+	}
+	if radiusConfig := server.Data("auth/corp-radius/config"); radiusConfig["host"] != "radius.example.com" {
+		t.Errorf("unexpected radius config: %v", radiusConfig)
+	}
+	if user := server.Data("auth/corp-radius/users/john"); user["policies"] != "dev" {
+		t.Errorf("unexpected radius user: %v", user)
+	}
+	if cert := server.Data("auth/cert/certs/web"); cert["certificate"] != "web-ca" || cert["allowed_common_names"] != "*.example.com" {
+		t.Errorf("unexpected cert role: %v", cert)
+	}
+
+	// The mappings and the certs refer to the policies
+	config = parseTestConfig(t, `
+auth:
+  - type: radius
+    users:
+      john:
+        policies: missing
+  - type: cert
+    certs:
+      - name: web
+        token_policies: missing
+`)
+	if errs := VerifyPolicyReferences(config.sections()); len(errs) != 2 {
+		t.Errorf("unexpected errors: %v", ConfigErrors(errs))
+	}
+	if errs := VerifyConfigStrict(config.sections()); len(errs) != 1 {
+		t.Errorf("a cert without a certificate is expected to be an error: %v", ConfigErrors(errs))
+	}
+}
+
+func TestConfigureKubernetesAuthOutOfCluster(t *testing.T) {
+	if host, ok := os.LookupEnv("KUBERNETES_SERVICE_HOST"); ok {
+		os.Unsetenv("KUBERNETES_SERVICE_HOST")
+		defer os.Setenv("KUBERNETES_SERVICE_HOST", host)
+	}
+
+	store := kvtest.New()
+	v, server := newTestVault(t, store)
+	defer server.Close()
+	if _, err := v.Init(context.Background()); err != nil {
+		t.Fatalf("error initializing vault: %s", err.Error())
+	}
+	if err := v.Unseal(context.Background()); err != nil {
+		t.Fatalf("error unsealing vault: %s", err.Error())
+	}
+
+	config := parseTestConfig(t, `
+auth:
+  - type: kubernetes
+    roles:
+      - name: default
+        bound_service_account_names: default
+        bound_service_account_namespaces: default
+`)
+	if err := v.Configure(config); err == nil || !strings.Contains(err.Error(), "kubernetes_host") {
+		t.Fatalf("expected an error without a kubernetes_host outside of a cluster, got: %v", err)
+	}
+
+	// The fields of the config take precedence over the out-of-cluster settings
+	v.(*vault).config.KubernetesAuth = &KubernetesAuthConfig{Host: "https://192.168.99.100:8443", CACert: "remote-ca", TokenReviewerJWT: "reviewer"}
+	config = parseTestConfig(t, `
+auth:
+  - type: kubernetes
+    config:
+      kubernetes_host: https://k8s.example.com
+    roles:
+      - name: default
+        bound_service_account_names: default
+        bound_service_account_namespaces: default
+`)
+	if errs := VerifyConfigStrict(config.sections()); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", ConfigErrors(errs))
+	}
+	if err := v.Configure(config); err != nil {
+		t.Fatalf("error configuring vault: %s", err.Error())
+	}
+	authConfig := server.Data("auth/kubernetes/config")
+	if authConfig["kubernetes_host"] != "https://k8s.example.com" || authConfig["kubernetes_ca_cert"] != "remote-ca" || authConfig["token_reviewer_jwt"] != "reviewer" {
+		t.Errorf("unexpected kubernetes auth config: %v", authConfig)
+	}
+}
+
+func TestConfigureTokenRoles(t *testing.T) {
+	store := kvtest.New()
+	v, server := newTestVault(t, store)
+	defer server.Close()
+	if _, err := v.Init(context.Background()); err != nil {
+		t.Fatalf("error initializing vault: %s", err.Error())
+	}
+	if err := v.Unseal(context.Background()); err != nil {
+		t.Fatalf("error unsealing vault: %s", err.Error())
+	}
+
+	config := parseTestConfig(t, `
+policies:
+  - name: ci
+    rules: path "secret/*" { capabilities = ["read"] }
+auth:
+  - type: token
+    tune:
+      default_lease_ttl: 1h
+      max_lease_ttl: 720h
+    roles:
+      - name: ci
+        allowed_policies: [ci]
+        orphan: true
+        period: 1h
+        token_bound_cidrs: [10.0.0.0/8]
+`)
+	if errs := VerifyConfigStrict(config.sections()); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", ConfigErrors(errs))
+	}
+	if errs := VerifyPolicyReferences(config.sections()); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", ConfigErrors(errs))
+	}
+	if err := v.Configure(config); err != nil {
+		t.Fatalf("error configuring vault: %s", err.Error())
+	}
+
+	if role := server.Data("auth/token/roles/ci"); role["orphan"] != true || role["period"] != "1h" {
+		t.Errorf("unexpected token role: %v", role)
+	}
+	tuned := false
+	for _, request := range server.Requests() {
+		tuned = tuned || request.Path == "sys/auth/token/tune"
+	}
+	if !tuned {
+		t.Errorf("the token auth method hasn't been tuned")
+	}
+
+	// The token auth method isn't enabled again, and it is compared with its roles only
+	if err := v.Configure(config); err != nil {
+		t.Fatalf("error configuring vault again: %s", err.Error())
+	}
+	changes, err := v.Diff(config)
+	if err != nil {
+		t.Fatalf("error comparing the configuration: %s", err.Error())
+	}
+	for _, change := range changes {
+		if strings.Contains(change.Path, "token") {
+			t.Errorf("unexpected change: %#v", change)
+		}
+	}
+
+	config = parseTestConfig(t, `
+auth:
+  - type: token
+    path: tokens
+`)
+	if errs := VerifyConfigStrict(config.sections()); len(errs) != 1 {
+		t.Errorf("the token auth method is expected to be at token only: %v", ConfigErrors(errs))
+	}
+}
+
+func TestConfigureUserpass(t *testing.T) {
+	store := kvtest.New()
+	v, server := newTestVault(t, store)
+	defer server.Close()
+	if _, err := v.Init(context.Background()); err != nil {
+		t.Fatalf("error initializing vault: %s", err.Error())
+	}
+	if err := v.Unseal(context.Background()); err != nil {
+		t.Fatalf("error unsealing vault: %s", err.Error())
+	}
+	store.Put("admin-password", []byte("s3cr3t"))
+
+	config := parseTestConfig(t, `
+auth:
+  - type: userpass
+    users:
+      - name: admin
+        password_key: admin-password
+        token_policies: allow_secrets
+      - name: oncall
+        password: changeme
+      - name: missing
+        password_key: missing-password
+`)
+	if errs := VerifyConfigStrict(config.sections()); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", ConfigErrors(errs))
+	}
+	report, err := v.ConfigureWithReport(config)
+	if err == nil {
+		t.Fatalf("expected an error of the user with a missing password key")
+	}
+
+	admin := server.Data("auth/userpass/users/admin")
+	if admin["password"] != "s3cr3t" || admin["token_policies"] != "allow_secrets" {
+		t.Errorf("unexpected user: %v", admin)
+	}
+	if _, ok := admin[userPasswordKeyField]; ok {
+		t.Errorf("the password key has been written to the user: %v", admin)
+	}
+	if oncall := server.Data("auth/userpass/users/oncall"); oncall["password"] != "changeme" {
+		t.Errorf("unexpected user: %v", oncall)
+	}
+	if len(report.Failed()) != 1 || server.Data("auth/userpass/users/missing") != nil {
+		t.Errorf("expected the user with the missing password key to fail: %s", report.Summary())
+	}
+
+	// The passwords aren't compared
+	if user := configObjects(config.desiredSections())["auth/userpass/users/oncall"]; len(user.(map[string]interface{})) != 0 {
+		t.Errorf("the password of the user is compared: %v", user)
+	}
+
+	errs := VerifyConfig(parseTestConfig(t, `
+auth:
+  - type: userpass
+    users:
+      - name: nopassword
+`).sections())
+	if len(errs) != 1 || errs[0].Path != "auth[0].users[0].password" {
+		t.Errorf("expected a missing password error, got: %v", ConfigErrors(errs))
+	}
+}
+
+func TestConfigureAuditDevices(t *testing.T) {
+	store := kvtest.New()
+	v, server := newTestVault(t, store)
+	defer server.Close()
+	if _, err := v.Init(context.Background()); err != nil {
+		t.Fatalf("error initializing vault: %s", err.Error())
+	}
+	if err := v.Unseal(context.Background()); err != nil {
+		t.Fatalf("error unsealing vault: %s", err.Error())
+	}
+
+	config := parseTestConfig(t, testConfig+`
+audit:
+  - type: file
+    description: The audit log of the pod.
+    options:
+      file_path: stdout
+  - type: socket
+    path: siem
+    options:
+      address: siem:9090
+      socket_type: tcp
+`)
+	if errs := VerifyConfigStrict(config.sections()); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", ConfigErrors(errs))
+	}
+
+	report, err := v.ConfigureWithReport(config)
+	if err != nil {
+		t.Fatalf("error configuring vault: %s", err.Error())
+	}
+	if summary := report.Summary(); summary.Created != 6 {
+		t.Errorf("unexpected summary: %s", summary)
+	}
+	// The audit devices are enabled first
+	if report.Resources[0].Kind != ResourceAuditDevice || report.Resources[0].Name != "file" {
+		t.Errorf("unexpected first resource: %+v", report.Resources[0])
+	}
+	audits := server.Audits()
+	if audit := audits["siem/"]; audit == nil || audit.Type != "socket" || audit.Options["address"] != "siem:9090" {
+		t.Errorf("unexpected audit device: %+v", audit)
+	}
+
+	// The enabled devices are skipped, and so is the unchanged policy
+	report, err = v.ConfigureWithReport(config)
+	if err != nil {
+		t.Fatalf("error configuring vault again: %s", err.Error())
+	}
+	if summary := report.Summary(); summary.Skipped != 3 {
+		t.Errorf("unexpected summary: %s", summary)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	store := kvtest.New()
+	v, server := newTestVault(t, store)
+	defer server.Close()
+	if _, err := v.Init(context.Background()); err != nil {
+		t.Fatalf("error initializing vault: %s", err.Error())
+	}
+	if err := v.Unseal(context.Background()); err != nil {
+		t.Fatalf("error unsealing vault: %s", err.Error())
+	}
+
+	dir, err := ioutil.TempDir("", "vault-config")
+	if err != nil {
+		t.Fatalf("error creating temporary directory: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "vault-config.yml")
+	if err := ioutil.WriteFile(file, []byte(testConfig), 0644); err != nil {
+		t.Fatalf("error writing config file: %s", err.Error())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	reconciled := make(chan error, 10)
+	done := make(chan error)
+	go func() {
+		done <- v.Watch(ctx, FileConfigSource(file), WatchOptions{
+			File:       file,
+			Reconciled: func(report *ConfigureReport, err error) { reconciled <- err },
+		})
+	}()
+
+	// wait waits until the policy is applied by a reconciliation, a write of the file may be
+	// picked up by several ones
+	wait := func(policy string) {
+		for {
+			select {
+			case err := <-reconciled:
+				if err != nil {
+					t.Logf("error reconciling vault configuration: %s", err.Error())
+				} else if _, ok := server.Policy(policy); ok {
+					return
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("the %s policy hasn't been reconciled", policy)
+			}
+		}
+	}
+	wait("allow_secrets")
+
+	// The changes of the file are picked up
+	changed := strings.Replace(testConfig, "name: allow_secrets", "name: read_secrets", 1)
+	if err := ioutil.WriteFile(file, []byte(changed), 0644); err != nil {
+		t.Fatalf("error writing config file: %s", err.Error())
+	}
+	wait("read_secrets")
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+}
+
+func TestConfigurePurgeUnmanaged(t *testing.T) {
+	store := kvtest.New()
+	v, server := newTestVault(t, store)
+	defer server.Close()
+	if _, err := v.Init(context.Background()); err != nil {
+		t.Fatalf("error initializing vault: %s", err.Error())
+	}
+	if err := v.Unseal(context.Background()); err != nil {
+		t.Fatalf("error unsealing vault: %s", err.Error())
+	}
+	config := parseTestConfig(t, testConfig)
+	if err := v.Configure(config); err != nil {
+		t.Fatalf("error configuring vault: %s", err.Error())
+	}
+
+	// The resources created by hand
+	client, err := server.Client()
+	if err != nil {
+		t.Fatalf("error creating the client of the fake vault: %s", err.Error())
+	}
+	client.SetToken(server.RootToken())
+	for _, name := range []string{"unmanaged", "protected"} {
+		if err := client.Sys().PutPolicy(name, `path "secret/*" { capabilities = ["read"] }`); err != nil {
+			t.Fatalf("error creating policy: %s", err.Error())
+		}
+	}
+	if err := client.Sys().EnableAuthWithOptions("github", &api.EnableAuthOptions{Type: "github"}); err != nil {
+		t.Fatalf("error enabling auth method: %s", err.Error())
+	}
+	if err := client.Sys().Mount("old", &api.MountInput{Type: "kv"}); err != nil {
+		t.Fatalf("error mounting secret engine: %s", err.Error())
+	}
+
+	v.(*vault).config.PurgeUnmanaged = true
+	v.(*vault).config.PurgeProtected = []string{"sys/policy/protected"}
+	report, err := v.ConfigureWithReport(config)
+	if err != nil {
+		t.Fatalf("error configuring vault: %s", err.Error())
+	}
+	if summary := report.Summary(); summary.Deleted != 3 {
+		t.Errorf("unexpected summary: %s", summary)
+	}
+
+	if _, ok := server.Policy("unmanaged"); ok {
+		t.Errorf("the unmanaged policy hasn't been deleted")
+	}
+	for _, name := range []string{"protected", "allow_secrets", "default"} {
+		if _, ok := server.Policy(name); !ok {
+			t.Errorf("the %s policy has been deleted", name)
+		}
+	}
+	auths := server.Auths()
+	if _, ok := auths["github/"]; ok {
+		t.Errorf("the unmanaged auth method hasn't been disabled")
+	}
+	if _, ok := auths["token/"]; !ok {
+		t.Errorf("the token auth method has been disabled")
+	}
+	mounts := server.Mounts()
+	if _, ok := mounts["old/"]; ok {
+		t.Errorf("the unmanaged secret engine hasn't been unmounted")
+	}
+	for _, path := range []string{"db/", "sys/", "cubbyhole/"} {
+		if _, ok := mounts[path]; !ok {
+			t.Errorf("the %s secret engine has been unmounted", path)
+		}
+	}
+}
+
+func TestPlan(t *testing.T) {
+	store := kvtest.New()
+	v, server := newTestVault(t, store)
+	defer server.Close()
+	if _, err := v.Init(context.Background()); err != nil {
+		t.Fatalf("error initializing vault: %s", err.Error())
+	}
+	if err := v.Unseal(context.Background()); err != nil {
+		t.Fatalf("error unsealing vault: %s", err.Error())
+	}
+	config := parseTestConfig(t, testConfig)
+
+	plan, err := v.Plan(config)
+	if err != nil {
+		t.Fatalf("error planning the configuration: %s", err.Error())
+	}
+	if !containsChange(plan, ConfigChangeCreate, "sys/policy/allow_secrets") {
+		t.Errorf("the plan should create the policy, got %v", plan)
+	}
+	if _, ok := server.Policy("allow_secrets"); ok {
+		t.Errorf("the plan has written the policy")
+	}
+
+	if err := v.Configure(config); err != nil {
+		t.Fatalf("error configuring vault: %s", err.Error())
+	}
+	client, err := server.Client()
+	if err != nil {
+		t.Fatalf("error creating the client of the fake vault: %s", err.Error())
+	}
+	client.SetToken(server.RootToken())
+	if err := client.Sys().PutPolicy("unmanaged", `path "secret/*" { capabilities = ["read"] }`); err != nil {
+		t.Fatalf("error creating policy: %s", err.Error())
+	}
+
+	// The objects existing only in Vault are kept without PurgeUnmanaged
+	if plan, err = v.Plan(config); err != nil {
+		t.Fatalf("error planning the configuration: %s", err.Error())
+	}
+	if containsChange(plan, ConfigChangeDelete, "sys/policy/unmanaged") {
+		t.Errorf("the plan shouldn't delete the unmanaged policy, got %v", plan)
+	}
+
+	v.(*vault).config.PurgeUnmanaged = true
+	if plan, err = v.Plan(config); err != nil {
+		t.Fatalf("error planning the configuration: %s", err.Error())
+	}
+	if !containsChange(plan, ConfigChangeDelete, "sys/policy/unmanaged") {
+		t.Errorf("the plan should delete the unmanaged policy, got %v", plan)
+	}
+	if containsChange(plan, ConfigChangeDelete, "sys/policy/default") {
+		t.Errorf("the plan shouldn't delete the built-in policy, got %v", plan)
+	}
+	if _, ok := server.Policy("unmanaged"); !ok {
+		t.Errorf("the plan has deleted the policy")
+	}
+}
+
+func containsChange(changes []ConfigChange, action, path string) bool {
+	for _, change := range changes {
+		if change.Action == action && change.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+func TestConfigurePoliciesUnchanged(t *testing.T) {
+	store := kvtest.New()
+	v, server := newTestVault(t, store)
+	defer server.Close()
+	if _, err := v.Init(context.Background()); err != nil {
+		t.Fatalf("error initializing vault: %s", err.Error())
+	}
+	if err := v.Unseal(context.Background()); err != nil {
+		t.Fatalf("error unsealing vault: %s", err.Error())
+	}
+	policy := func(rules string) *ExternalConfig {
+		return &ExternalConfig{Policies: []map[string]string{{"name": "allow_secrets", "rules": rules}}}
+	}
+	policyAction := func(report *ConfigureReport) string {
+		for _, result := range report.Resources {
+			if result.Kind == ResourcePolicy {
+				return result.Action
+			}
+		}
+		return ""
+	}
+
+	report, err := v.ConfigureWithReport(policy(`path "secret/*" { capabilities = ["read", "list"] }`))
+	if err != nil {
+		t.Fatalf("error configuring vault: %s", err.Error())
+	}
+	if action := policyAction(report); action != ActionCreated {
+		t.Errorf("the policy should have been created, got %s", action)
+	}
+
+	// Only the formatting and the order of the capabilities differ
+	report, err = v.ConfigureWithReport(policy("path \"secret/*\" {\n  capabilities = [\"list\", \"read\"]\n}\n"))
+	if err != nil {
+		t.Fatalf("error configuring vault: %s", err.Error())
+	}
+	if action := policyAction(report); action != ActionSkipped {
+		t.Errorf("the unchanged policy should have been skipped, got %s", action)
+	}
+
+	report, err = v.ConfigureWithReport(policy(`path "secret/*" { capabilities = ["read"] }`))
+	if err != nil {
+		t.Fatalf("error configuring vault: %s", err.Error())
+	}
+	if action := policyAction(report); action != ActionUpdated {
+		t.Errorf("the changed policy should have been updated, got %s", action)
+	}
+	if rules, _ := server.Policy("allow_secrets"); strings.Contains(rules, "list") {
+		t.Errorf("the policy hasn't been updated: %s", rules)
+	}
+}
+
+func TestConfigureSecretEngineStateAndMigration(t *testing.T) {
+	store := kvtest.New()
+	v, server := newTestVault(t, store)
+	defer server.Close()
+	if _, err := v.Init(context.Background()); err != nil {
+		t.Fatalf("error initializing vault: %s", err.Error())
+	}
+	if err := v.Unseal(context.Background()); err != nil {
+		t.Fatalf("error unsealing vault: %s", err.Error())
+	}
+	client, err := server.Client()
+	if err != nil {
+		t.Fatalf("error creating the client of the fake vault: %s", err.Error())
+	}
+	client.SetToken(server.RootToken())
+	for _, path := range []string{"old", "gone"} {
+		if err := client.Sys().Mount(path, &api.MountInput{Type: "kv"}); err != nil {
+			t.Fatalf("error mounting secret engine: %s", err.Error())
+		}
+	}
+
+	config := parseTestConfig(t, `
+secrets:
+  - type: kv
+    path: new
+    migrate_from: old
+  - type: kv
+    path: gone
+    state: absent
+`)
+	if errs := VerifyConfig(config.sections()); len(errs) != 0 {
+		t.Fatalf("unexpected configuration errors: %v", errs)
+	}
+
+	plan, err := v.Plan(config)
+	if err != nil {
+		t.Fatalf("error planning the configuration: %s", err.Error())
+	}
+	if !containsChange(plan, ConfigChangeDelete, "sys/mounts/gone") {
+		t.Errorf("the plan should unmount the absent secret engine, got %v", plan)
+	}
+
+	report, err := v.ConfigureWithReport(config)
+	if err != nil {
+		t.Fatalf("error configuring vault: %s", err.Error())
+	}
+	if summary := report.Summary(); summary.Deleted != 1 || summary.Updated != 1 || summary.Failed != 0 {
+		t.Errorf("unexpected summary: %s", summary)
+	}
+	mounts := server.Mounts()
+	if _, ok := mounts["new/"]; !ok {
+		t.Errorf("the secret engine hasn't been remounted")
+	}
+	for _, path := range []string{"old/", "gone/"} {
+		if _, ok := mounts[path]; ok {
+			t.Errorf("the %s secret engine is still mounted", path)
+		}
+	}
+
+	// Nothing changes once migrated and unmounted
+	if report, err = v.ConfigureWithReport(config); err != nil {
+		t.Fatalf("error configuring vault again: %s", err.Error())
+	}
+	if summary := report.Summary(); summary.Deleted != 0 || summary.Updated != 1 || summary.Failed != 0 {
+		t.Errorf("unexpected summary: %s", summary)
+	}
+
+	invalid := parseTestConfig(t, `
+secrets:
+  - type: kv
+    state: removed
+`)
+	if errs := VerifyConfig(invalid.sections()); len(errs) != 1 || errs[0].Path != "secrets[0].state" {
+		t.Errorf("expected an error of the state, got %v", errs)
+	}
+}
+
+func TestConfigureTrackConfigState(t *testing.T) {
+	store := kvtest.New()
+	server := vaulttest.NewServer()
+	defer server.Close()
+	client, err := server.Client()
+	if err != nil {
+		t.Fatalf("error creating the client of the fake vault: %s", err.Error())
+	}
+	v, err := New(store, client, Config{SecretShares: 5, SecretThreshold: 3, StoreRootToken: true, TrackConfigState: true})
+	if err != nil {
+		t.Fatalf("error creating vault: %s", err.Error())
+	}
+	if _, err := v.Init(context.Background()); err != nil {
+		t.Fatalf("error initializing vault: %s", err.Error())
+	}
+	if err := v.Unseal(context.Background()); err != nil {
+		t.Fatalf("error unsealing vault: %s", err.Error())
+	}
+
+	actions := func(config *ExternalConfig) map[string]string {
+		report, err := v.ConfigureWithReport(config)
+		if err != nil {
+			t.Fatalf("error configuring vault: %s", err.Error())
+		}
+		actions := map[string]string{}
+		for _, result := range report.Resources {
+			if result.Kind == ResourceSecretEngineConfig {
+				actions[result.Name] = result.Action
+			}
+		}
+		return actions
+	}
+
+	config := parseTestConfig(t, `
+secrets:
+  - type: database
+    path: db
+    configuration:
+      config:
+        - name: mysql
+          plugin_name: mysql-database-plugin
+        - name: postgres
+          plugin_name: postgresql-database-plugin
+      roles:
+        - name: reader
+          db_name: mysql
+`)
+	if got := actions(config); got["db/config/mysql"] != ActionCreated || got["db/roles/reader"] != ActionCreated {
+		t.Fatalf("unexpected actions of the first run: %v", got)
+	}
+	if store.Value(ConfigStateKey) == nil {
+		t.Fatalf("the state of the configuration hasn't been stored")
+	}
+	server.SetData("db/roles/manual", map[string]interface{}{"db_name": "mysql"})
+
+	// The unchanged entries aren't written again, unless they have drifted
+	server.SetData("db/config/postgres", map[string]interface{}{"plugin_name": "changed-by-hand"})
+	got := actions(config)
+	if got["db/config/mysql"] != ActionSkipped || got["db/roles/reader"] != ActionSkipped || got["db/config/postgres"] != ActionUpdated {
+		t.Errorf("unexpected actions of the second run: %v", got)
+	}
+	if postgres := server.Data("db/config/postgres"); postgres["plugin_name"] != "postgresql-database-plugin" {
+		t.Errorf("the drift hasn't been corrected: %v", postgres)
+	}
+
+	// The removed entries are deleted, the ones created by hand are left alone
+	config = parseTestConfig(t, `
+secrets:
+  - type: database
+    path: db
+    configuration:
+      config:
+        - name: mysql
+          plugin_name: mysql-legacy-database-plugin
+`)
+	got = actions(config)
+	if got["db/config/mysql"] != ActionUpdated || got["db/config/postgres"] != ActionDeleted || got["db/roles/reader"] != ActionDeleted || len(got) != 3 {
+		t.Errorf("unexpected actions of the third run: %v", got)
+	}
+	if server.Data("db/config/postgres") != nil || server.Data("db/roles/reader") != nil {
+		t.Errorf("the removed entries haven't been deleted")
+	}
+	if server.Data("db/roles/manual") == nil {
+		t.Errorf("the entry created by hand has been deleted")
+	}
+}
+
+func TestConfigureDatabaseRotateRoot(t *testing.T) {
+	store := kvtest.New()
+	v, server := newTestVault(t, store)
+	defer server.Close()
+	if _, err := v.Init(context.Background()); err != nil {
+		t.Fatalf("error initializing vault: %s", err.Error())
+	}
+	if err := v.Unseal(context.Background()); err != nil {
+		t.Fatalf("error unsealing vault: %s", err.Error())
+	}
+	database := func(password string) string {
+		return `
+secrets:
+  - type: database
+    configuration:
+      config:
+        - name: mysql
+          plugin_name: mysql-database-plugin
+          connection_url: "{{username}}:{{password}}@tcp(mysql:3306)/"
+          username: root
+          password: ` + password + `
+          rotate_root: true
+      roles:
+        - name: app
+          db_name: mysql
+          creation_statements: "CREATE USER '{{name}}'@'%' IDENTIFIED BY '{{password}}';"
+`
+	}
+	config := parseTestConfig(t, database("bootstrap"))
+	if errs := VerifyConfig(config.sections()); len(errs) != 0 {
+		t.Fatalf("unexpected configuration errors: %v", errs)
+	}
+
+	if err := v.Configure(config); err != nil {
+		t.Fatalf("error configuring vault: %s", err.Error())
+	}
+	connection := server.Data("database/config/mysql")
+	if connection == nil || connection["username"] != "root" {
+		t.Fatalf("the connection hasn't been written: %v", connection)
+	}
+	if _, ok := connection[rotateRootField]; ok {
+		t.Errorf("the %s field has been written to vault", rotateRootField)
+	}
+	if connection["password"] == "bootstrap" {
+		t.Errorf("the root password hasn't been rotated")
+	}
+	if server.Data("database/roles/app") == nil {
+		t.Errorf("the role hasn't been written")
+	}
+
+	// The existing connection isn't overwritten with the stale password
+	rotated := connection["password"]
+	report, err := v.ConfigureWithReport(parseTestConfig(t, database("changed")))
+	if err != nil {
+		t.Fatalf("error configuring vault again: %s", err.Error())
+	}
+	if password := server.Data("database/config/mysql")["password"]; password != rotated {
+		t.Errorf("the rotated password has been overwritten with %v", password)
+	}
+	if summary := report.Summary(); summary.Skipped != 1 || summary.Failed != 0 {
+		t.Errorf("unexpected summary: %s", summary)
+	}
+
+	invalid := parseTestConfig(t, `
+secrets:
+  - type: database
+    configuration:
+      roles:
+        - name: app
+          rotate_root: true
+`)
+	if errs := VerifyConfig(invalid.sections()); len(errs) != 2 {
+		t.Errorf("expected errors of rotate_root and db_name, got %v", errs)
+	}
+}
+
+func TestConfigurePKI(t *testing.T) {
+	store := kvtest.New()
+	v, server := newTestVault(t, store)
+	defer server.Close()
+	if _, err := v.Init(context.Background()); err != nil {
+		t.Fatalf("error initializing vault: %s", err.Error())
+	}
+	if err := v.Unseal(context.Background()); err != nil {
+		t.Fatalf("error unsealing vault: %s", err.Error())
+	}
+	config := parseTestConfig(t, `
+secrets:
+  - type: pki
+    pki:
+      ca:
+        common_name: Example Root CA
+        ttl: 87600h
+  - type: pki
+    path: pki_int
+    pki:
+      ca:
+        type: intermediate
+        signed_by: pki
+        common_name: Example Intermediate CA
+      urls:
+        issuing_certificates: https://vault:8200/v1/pki_int/ca
+        crl_distribution_points: https://vault:8200/v1/pki_int/crl
+      roles:
+        - name: example-dot-com
+          allowed_domains: example.com
+          allow_subdomains: true
+`)
+	if errs := VerifyConfig(config.sections()); len(errs) != 0 {
+		t.Fatalf("unexpected configuration errors: %v", errs)
+	}
+
+	if err := v.Configure(config); err != nil {
+		t.Fatalf("error configuring vault: %s", err.Error())
+	}
+	if ca := server.Data("pki/cert/ca"); ca == nil || ca["certificate"] != "root:Example Root CA" {
+		t.Errorf("the root CA hasn't been generated: %v", ca)
+	}
+	if ca := server.Data("pki_int/cert/ca"); ca == nil || ca["certificate"] != "csr:Example Intermediate CA signed by root:Example Root CA" {
+		t.Errorf("the intermediate CA hasn't been signed by the root CA: %v", ca)
+	}
+	if urls := server.Data("pki_int/config/urls"); urls == nil || urls["issuing_certificates"] != "https://vault:8200/v1/pki_int/ca" {
+		t.Errorf("the URLs haven't been written: %v", urls)
+	}
+	if role := server.Data("pki_int/roles/example-dot-com"); role == nil || role["allowed_domains"] != "example.com" {
+		t.Errorf("the role hasn't been written: %v", role)
+	}
+
+	plan, err := v.Plan(config)
+	if err != nil {
+		t.Fatalf("error planning the configuration: %s", err.Error())
+	}
+	for _, change := range plan {
+		if strings.HasPrefix(change.Path, "pki") {
+			t.Errorf("unexpected change of the configured pki secret engines: %v", change)
+		}
+	}
+
+	// The existing CAs are kept
+	server.SetData("pki/cert/ca", map[string]interface{}{"certificate": "existing"})
+	report, err := v.ConfigureWithReport(config)
+	if err != nil {
+		t.Fatalf("error configuring vault again: %s", err.Error())
+	}
+	if summary := report.Summary(); summary.Skipped != 2 || summary.Failed != 0 {
+		t.Errorf("unexpected summary: %s", summary)
+	}
+	if ca := server.Data("pki/cert/ca"); ca["certificate"] != "existing" {
+		t.Errorf("the existing root CA has been replaced: %v", ca)
+	}
+
+	invalid := parseTestConfig(t, `
+secrets:
+  - type: pki
+    pki:
+      ca:
+        type: intermediate
+        common_name: Example Intermediate CA
+`)
+	if errs := VerifyConfig(invalid.sections()); len(errs) != 1 || errs[0].Path != "secrets[0].pki.ca.signed_by" {
+		t.Errorf("expected an error of signed_by, got %v", errs)
+	}
+}
+
+func TestConfigureSSH(t *testing.T) {
+	store := kvtest.New()
+	v, server := newTestVault(t, store)
+	defer server.Close()
+	if _, err := v.Init(context.Background()); err != nil {
+		t.Fatalf("error initializing vault: %s", err.Error())
+	}
+	if err := v.Unseal(context.Background()); err != nil {
+		t.Fatalf("error unsealing vault: %s", err.Error())
+	}
+	config := parseTestConfig(t, `
+secrets:
+  - type: ssh
+    path: ssh-client-signer
+    ssh:
+      ca:
+        key_type: ed25519
+      roles:
+        - name: ops
+          key_type: ca
+          allow_user_certificates: true
+          allowed_users: ops,deploy
+          default_user: ops
+          ttl: 30m
+  - type: ssh
+    path: ssh-host-signer
+    ssh:
+      ca:
+        private_key: private
+        public_key: ssh-ed25519 imported
+  - type: ssh
+    ssh:
+      roles:
+        - name: otp
+          key_type: otp
+          default_user: ubuntu
+          cidr_list: 10.0.0.0/8
+`)
+	if errs := VerifyConfig(config.sections()); len(errs) != 0 {
+		t.Fatalf("unexpected configuration errors: %v", errs)
+	}
+
+	report, err := v.ConfigureWithReport(config)
+	if err != nil {
+		t.Fatalf("error configuring vault: %s", err.Error())
+	}
+	ca := server.Data("ssh-client-signer/config/ca")
+	if ca == nil || ca["public_key"] == "" {
+		t.Fatalf("the CA signing key hasn't been generated: %v", ca)
+	}
+	if publicKey := report.SSHCAPublicKeys["ssh-client-signer"]; publicKey != ca["public_key"] {
+		t.Errorf("unexpected public key of the generated CA in the report: %v", report.SSHCAPublicKeys)
+	}
+	if publicKey := report.SSHCAPublicKeys["ssh-host-signer"]; publicKey != "ssh-ed25519 imported" {
+		t.Errorf("unexpected public key of the imported CA in the report: %v", report.SSHCAPublicKeys)
+	}
+	if role := server.Data("ssh-client-signer/roles/ops"); role == nil || role["allowed_users"] != "ops,deploy" {
+		t.Errorf("the signing role hasn't been written: %v", role)
+	}
+	if role := server.Data("ssh/roles/otp"); role == nil || role["cidr_list"] != "10.0.0.0/8" {
+		t.Errorf("the OTP role hasn't been written: %v", role)
+	}
+
+	plan, err := v.Plan(config)
+	if err != nil {
+		t.Fatalf("error planning the configuration: %s", err.Error())
+	}
+	for _, change := range plan {
+		if strings.HasPrefix(change.Path, "ssh") {
+			t.Errorf("unexpected change of the configured ssh secret engines: %v", change)
+		}
+	}
+
+	// The existing CA signing keys are kept, and their public keys are reported
+	report, err = v.ConfigureWithReport(config)
+	if err != nil {
+		t.Fatalf("error configuring vault again: %s", err.Error())
+	}
+	if summary := report.Summary(); summary.Skipped != 2 || summary.Failed != 0 {
+		t.Errorf("unexpected summary: %s", summary)
+	}
+	if publicKey := server.Data("ssh-client-signer/config/ca")["public_key"]; publicKey != ca["public_key"] || report.SSHCAPublicKeys["ssh-client-signer"] != publicKey {
+		t.Errorf("the existing CA signing key has been replaced: %v", report.SSHCAPublicKeys)
+	}
+
+	invalid := parseTestConfig(t, `
+secrets:
+  - type: ssh
+    ssh:
+      ca:
+        public_key: ssh-ed25519 imported
+      roles:
+        - name: dynamic
+          key_type: dynamic
+  - type: kv
+    ssh:
+      roles: []
+`)
+	errs := VerifyConfig(invalid.sections())
+	paths := []string{}
+	for _, err := range errs {
+		paths = append(paths, err.Path)
+	}
+	sort.Strings(paths)
+	if expected := []string{"secrets[0].ssh.ca.private_key", "secrets[0].ssh.roles[0].key_type", "secrets[1].ssh"}; !reflect.DeepEqual(paths, expected) {
+		t.Errorf("expected errors of %v, got %v", expected, errs)
+	}
+}
+
+func TestErrorKinds(t *testing.T) {
+	store := kvtest.New()
+	v, server := newTestVault(t, store)
+	defer server.Close()
+	if _, err := v.Init(context.Background()); err != nil {
+		t.Fatalf("error initializing vault: %s", err.Error())
+	}
+
+	// The keys of the cluster are in the key store already
+	other, otherServer := newTestVault(t, store)
+	defer otherServer.Close()
+	if _, err := other.Init(context.Background()); !errors.Is(err, ErrKeyStoreExists) {
+		t.Errorf("expected an ErrKeyStoreExists error, got %v", err)
+	}
+
+	store.FailOn(kvtest.OperationGet, "vault-unseal-0", errors.New("AccessDeniedException: permission denied"))
+	err := v.Unseal(context.Background())
+	if !errors.Is(err, ErrKeyStoreUnavailable) || !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("expected an ErrKeyStoreUnavailable and ErrPermissionDenied error, got %v", err)
+	}
+	if errors.Is(err, ErrUnsealFailed) {
+		t.Errorf("the key store error isn't an unseal failure: %v", err)
+	}
+	var fatal *FatalError
+	if !errors.As(err, &fatal) {
+		t.Errorf("expected the error to be classified, got %v", err)
+	}
+
+	store.FailOn(kvtest.OperationGet, "vault-unseal-0", nil)
+	if err := store.Delete("vault-unseal-0"); err != nil {
+		t.Fatalf("error deleting the key: %s", err.Error())
+	}
+	if err := v.Unseal(context.Background()); !errors.Is(err, ErrUnsealFailed) {
+		t.Errorf("expected an ErrUnsealFailed error, got %v", err)
+	}
+}
+
+func TestConfigureStartupSecrets(t *testing.T) {
+	store := kvtest.New()
+	v, server := newTestVault(t, store)
+	defer server.Close()
+	if _, err := v.Init(context.Background()); err != nil {
+		t.Fatalf("error initializing vault: %s", err.Error())
+	}
+	if err := v.Unseal(context.Background()); err != nil {
+		t.Fatalf("error unsealing vault: %s", err.Error())
+	}
+
+	config := parseTestConfig(t, `
+secrets:
+  - type: kv
+    path: kv
+    version: 2
+  - type: kv
+    path: secret
+startupSecrets:
+  - path: kv/app/db
+    data:
+      password: initial-password
+  - path: secret/app
+    data:
+      token: initial-token
+  - path: secret/bootstrap
+    cas: false
+    data:
+      token: initial-token
+`)
+	if errs := VerifyConfigStrict(config.sections()); len(errs) != 0 {
+		t.Fatalf("unexpected configuration errors: %v", errs)
+	}
+
+	report, err := v.ConfigureWithReport(config)
+	if err != nil {
+		t.Fatalf("error configuring vault: %s", err.Error())
+	}
+	if summary := report.Summary(); summary.Created != 5 || summary.Failed != 0 {
+		t.Errorf("unexpected summary: %s", summary)
+	}
+	if version := server.Mounts()["kv/"].Options["version"]; version != "2" {
+		t.Errorf("the kv secret engine isn't of version 2: %q", version)
+	}
+	if data := server.Data("kv/data/app/db"); data == nil || data["data"].(map[string]interface{})["password"] != "initial-password" {
+		t.Errorf("the secret hasn't been written to the data path: %v", data)
+	}
+
+	// The secrets changed since then aren't clobbered, unless cas is false
+	server.SetData("secret/app", map[string]interface{}{"token": "rotated-token"})
+	server.SetData("secret/bootstrap", map[string]interface{}{"token": "rotated-token"})
+	if report, err = v.ConfigureWithReport(config); err != nil {
+		t.Fatalf("error configuring vault again: %s", err.Error())
+	}
+	skipped := 0
+	for _, result := range report.Resources {
+		if result.Kind == ResourceStartupSecret && result.Action == ActionSkipped {
+			skipped++
+		}
+	}
+	if skipped != 2 {
+		t.Errorf("expected 2 skipped startup secrets, got %v", report.Resources)
+	}
+	if token := server.Data("secret/app")["token"]; token != "rotated-token" {
+		t.Errorf("the existing secret has been clobbered: %v", token)
+	}
+	if token := server.Data("secret/bootstrap")["token"]; token != "initial-token" {
+		t.Errorf("the secret without cas hasn't been overwritten: %v", token)
+	}
+
+	invalid := parseTestConfig(t, `
+secrets:
+  - type: pki
+    version: 2
+startupSecrets:
+  - path: secret/app
+`)
+	if errs := VerifyConfig(invalid.sections()); len(errs) != 2 || errs[0].Path != "secrets[0].version" || errs[1].Path != "startupSecrets[0].data" {
+		t.Errorf("expected errors of the version and the data, got %v", errs)
+	}
+}
+
+func TestConfigureSys(t *testing.T) {
+	store := kvtest.New()
+	v, server := newTestVault(t, store)
+	defer server.Close()
+	if _, err := v.Init(context.Background()); err != nil {
+		t.Fatalf("error initializing vault: %s", err.Error())
+	}
+	if err := v.Unseal(context.Background()); err != nil {
+		t.Fatalf("error unsealing vault: %s", err.Error())
+	}
+
+	config := parseTestConfig(t, `
+secrets:
+  - type: kv
+    path: secret
+sys:
+  audited_headers:
+    - name: X-Forwarded-For
+      hmac: true
+  cors:
+    allowed_origins:
+      - https://example.com
+  ui_headers:
+    X-Custom-Header: value
+  tune:
+    secret:
+      default_lease_ttl: 1h
+      max_lease_ttl: 24h
+`)
+	if errs := VerifyConfigStrict(config.sections()); len(errs) != 0 {
+		t.Fatalf("unexpected configuration errors: %v", errs)
+	}
+
+	report, err := v.ConfigureWithReport(config)
+	if err != nil {
+		t.Fatalf("error configuring vault: %s", err.Error())
+	}
+	if summary := report.Summary(); summary.Failed != 0 {
+		t.Errorf("unexpected summary: %s", summary)
+	}
+	if hmac := server.Data("sys/config/auditing/request-headers/X-Forwarded-For")["hmac"]; hmac != true {
+		t.Errorf("the header isn't audited: %v", hmac)
+	}
+	if origins := server.Data("sys/config/cors")["allowed_origins"]; !reflect.DeepEqual(origins, []interface{}{"https://example.com"}) {
+		t.Errorf("unexpected CORS origins: %v", origins)
+	}
+	if values := server.Data("sys/config/ui/headers/x-custom-header")["values"]; !reflect.DeepEqual(values, []interface{}{"value"}) {
+		t.Errorf("unexpected UI header: %v", values)
+	}
+	tuned := false
+	for _, request := range server.Requests() {
+		tuned = tuned || request.Path == "sys/mounts/secret/tune"
+	}
+	if !tuned {
+		t.Errorf("the secret engine hasn't been tuned")
+	}
+
+	invalid := parseTestConfig(t, `
+sys:
+  audited_headers:
+    - hmac: true
+  cors: true
+`)
+	if errs := VerifyConfig(invalid.sections()); len(errs) != 2 || errs[0].Path != "sys.audited_headers[0].name" || errs[1].Path != "sys.cors" {
+		t.Errorf("expected errors of the header name and cors, got %v", errs)
+	}
+}
+
+func TestExportTune(t *testing.T) {
+	store := kvtest.New()
+	v, server := newTestVault(t, store)
+	defer server.Close()
+	if _, err := v.Init(context.Background()); err != nil {
+		t.Fatalf("error initializing vault: %s", err.Error())
+	}
+	if err := v.Unseal(context.Background()); err != nil {
+		t.Fatalf("error unsealing vault: %s", err.Error())
+	}
+
+	config := parseTestConfig(t, `
+policies:
+  - name: dev
+    rules: path "secret/*" { capabilities = ["read"] }
+auth:
+  - type: approle
+    roles:
+      - name: ci
+        policies: dev
+secrets:
+  - type: kv
+    path: secret
+  - type: kv
+    path: untuned
+sys:
+  tune:
+    secret:
+      default_lease_ttl: 1h
+      max_lease_ttl: 90m
+    auth/approle:
+      max_lease_ttl: 24h
+`)
+	if err := v.Configure(config); err != nil {
+		t.Fatalf("error configuring vault: %s", err.Error())
+	}
+
+	exported, err := v.Export()
+	if err != nil {
+		t.Fatalf("error exporting the configuration: %s", err.Error())
+	}
+	tune := cast.ToStringMap(cast.ToStringMap(exported["sys"])[sysTuneField])
+	if secret := cast.ToStringMap(tune["secret"]); secret["default_lease_ttl"] != "1h" || secret["max_lease_ttl"] != "90m" {
+		t.Errorf("unexpected tuning of the secret engine: %v", tune)
+	}
+	if approle := cast.ToStringMap(tune["auth/approle"]); approle["max_lease_ttl"] != "24h" || approle["default_lease_ttl"] != nil {
+		t.Errorf("unexpected tuning of the auth method: %v", tune)
+	}
+	if _, ok := tune["untuned"]; ok || len(tune) != 2 {
+		t.Errorf("only the tuned mounts should be exported: %v", tune)
+	}
+
+	// The export is a valid configuration, so it can be applied and diffed afterwards
+	if errs := VerifyConfigStrict(exported); len(errs) != 0 {
+		t.Errorf("the exported configuration is invalid: %v", ConfigErrors(errs))
+	}
+}
+
+func TestConfigureConcurrently(t *testing.T) {
+	store := kvtest.New()
+	server := vaulttest.NewServer()
+	defer server.Close()
+	client, err := server.Client()
+	if err != nil {
+		t.Fatalf("error creating the client of the fake vault: %s", err.Error())
+	}
+	v, err := New(store, client, Config{SecretShares: 1, SecretThreshold: 1, StoreRootToken: true, ConfigureConcurrency: 8, RateLimit: 1000, RateBurst: 10})
+	if err != nil {
+		t.Fatalf("error creating vault: %s", err.Error())
+	}
+	if _, err := v.Init(context.Background()); err != nil {
+		t.Fatalf("error initializing vault: %s", err.Error())
+	}
+	if err := v.Unseal(context.Background()); err != nil {
+		t.Fatalf("error unsealing vault: %s", err.Error())
+	}
+
+	yaml := "auth:\n  - type: approle\n    roles:\n"
+	expected := []string{"approle"}
+	for i := 0; i < 30; i++ {
+		yaml += fmt.Sprintf("      - name: role-%02d\n        policies: policy-%02d\n", i, i)
+		expected = append(expected, fmt.Sprintf("auth/approle/role/role-%02d", i))
+	}
+	yaml += "policies:\n"
+	for i := 0; i < 30; i++ {
+		yaml += fmt.Sprintf("  - name: policy-%02d\n    rules: path \"secret/%02d\" { capabilities = [\"read\"] }\n", i, i)
+		expected = append(expected, fmt.Sprintf("policy-%02d", i))
+	}
+	config := parseTestConfig(t, yaml)
+
+	// The resources are reported in the order of the configuration, as if they were applied one by one
+	report, err := v.ConfigureWithReport(config)
+	if err != nil {
+		t.Fatalf("error configuring vault: %s", err.Error())
+	}
+	names := []string{}
+	for _, result := range report.Resources {
+		names = append(names, result.Name)
+	}
+	if strings.Join(names, " ") != strings.Join(expected, " ") {
+		t.Fatalf("unexpected order of the resources:\n%v\nexpected:\n%v", names, expected)
+	}
+	for i := 0; i < 30; i++ {
+		if _, ok := server.Policy(fmt.Sprintf("policy-%02d", i)); !ok {
+			t.Errorf("policy-%02d hasn't been written", i)
+		}
+		if server.Data(fmt.Sprintf("auth/approle/role/role-%02d", i)) == nil {
+			t.Errorf("role-%02d hasn't been written", i)
+		}
+	}
+}
+
+func TestVerifyPolicyReferences(t *testing.T) {
+	config := parseTestConfig(t, `
+policies:
+  - name: allow_secrets
+    rules: path "secret/*" { capabilities = ["read"] }
+auth:
+  - type: kubernetes
+    roles:
+      - name: default
+        policies: allow_secrets,default
+      - name: reader
+        token_policies: [allow_secrets, allow_pki]
+  - type: github
+    map:
+      teams:
+        dev: allow_secrets, dev
+  - type: ldap
+    groups:
+      admins:
+        policies: root
+`)
+	errs := VerifyPolicyReferences(config.sections())
+	if len(errs) != 2 || errs[0].Path != "auth[0].roles[1].token_policies" || errs[1].Path != "auth[1].map.teams.dev" {
+		t.Fatalf("expected the undefined allow_pki and dev policies, got: %v", ConfigErrors(errs))
+	}
+	if errs := VerifyConfig(config.sections()); len(errs) != 0 {
+		t.Errorf("the policy references are checked by VerifyConfig: %v", ConfigErrors(errs))
+	}
+}
+
+func TestConfigureNamespaces(t *testing.T) {
+	store := kvtest.New()
+	v, server := newTestVault(t, store)
+	defer server.Close()
+	if _, err := v.Init(context.Background()); err != nil {
+		t.Fatalf("error initializing vault: %s", err.Error())
+	}
+	if err := v.Unseal(context.Background()); err != nil {
+		t.Fatalf("error unsealing vault: %s", err.Error())
+	}
+
+	config := parseTestConfig(t, `
+namespace: team-a
+policies:
+  - name: reader
+    rules: path "secret/*" { capabilities = ["read"] }
+  - name: admin
+    namespace: team-a/dev
+    rules: path "*" { capabilities = ["sudo"] }
+secrets:
+  - type: kv
+    path: secret
+`)
+	if errs := VerifyConfigStrict(config.sections()); len(errs) > 0 {
+		t.Fatalf("unexpected errors of the config: %v", ConfigErrors(errs))
+	}
+	report, err := v.ConfigureWithReport(config)
+	if err != nil {
+		t.Fatalf("error configuring vault: %s", err.Error())
+	}
+
+	namespaces := map[string]string{}
+	for _, result := range report.Resources {
+		if result.Kind == ResourceNamespace {
+			namespaces[result.Name] = result.Action
+		} else if result.Kind == ResourcePolicy && result.Name == "admin" && result.Namespace != "team-a/dev" {
+			t.Errorf("the admin policy has been recorded in namespace '%s'", result.Namespace)
+		}
+	}
+	if namespaces["team-a"] != ActionCreated || namespaces["team-a/dev"] != ActionCreated {
+		t.Errorf("the namespaces haven't been created: %v", namespaces)
+	}
+
+	sent := map[string]string{}
+	for _, request := range server.Requests() {
+		if request.Method == http.MethodPut || request.Method == http.MethodPost {
+			sent[request.Path] = request.Namespace
+		}
+	}
+	for path, namespace := range map[string]string{
+		"sys/namespaces/team-a": "",
+		"sys/namespaces/dev":    "team-a",
+		"sys/policy/reader":     "team-a",
+		"sys/policy/admin":      "team-a/dev",
+		"sys/mounts/secret":     "team-a",
+	} {
+		if got, ok := sent[path]; !ok || got != namespace {
+			t.Errorf("%s should have been written in namespace '%s', got '%s' (sent: %v)", path, namespace, got, ok)
+		}
+	}
+
+	// The existing namespaces are skipped
+	report, err = v.ConfigureWithReport(config)
+	if err != nil {
+		t.Fatalf("error configuring vault again: %s", err.Error())
+	}
+	for _, result := range report.Resources {
+		if result.Kind == ResourceNamespace && result.Action != ActionSkipped {
+			t.Errorf("namespace %s should have been skipped, got %s", result.Name, result.Action)
+		}
+	}
+
+	if _, err := v.Diff(config); err == nil {
+		t.Errorf("expected an error comparing a configuration with namespaces")
+	}
+}
+
+func TestRaftConfig(t *testing.T) {
+	config := parseTestConfig(t, `
+raft:
+  join:
+    leader_ca_cert: ca
+    retry: true
+  snapshots:
+    period: 1h
+    location: 's3://vault-backups/vault-{{ .Time.Format "20060102-150405" }}.snap'
+`)
+	raft, err := config.Raft()
+	if err != nil {
+		t.Fatalf("error parsing the raft section: %s", err.Error())
+	}
+	if raft.Join == nil || raft.Join.LeaderCACert != "ca" || !raft.Join.Retry {
+		t.Errorf("unexpected join options: %+v", raft.Join)
+	}
+	location, err := raft.Snapshots.SnapshotLocation(time.Date(2019, 3, 1, 12, 30, 0, 0, time.UTC))
+	if err != nil || location != "s3://vault-backups/vault-20190301-123000.snap" {
+		t.Errorf("unexpected snapshot location: %s, %v", location, err)
+	}
+	if errs := VerifyConfig(config.sections()); len(errs) != 0 {
+		t.Errorf("unexpected errors: %v", ConfigErrors(errs))
+	}
+
+	invalid := parseTestConfig(t, `
+raft:
+  snapshots:
+    period: hourly
+    location: /backups/vault.snap
+`)
+	if _, err := invalid.Raft(); err == nil {
+		t.Errorf("expected an error for the invalid period")
+	}
+	if errs := VerifyConfig(invalid.sections()); len(errs) != 1 || errs[0].Path != "raft.snapshots.period" {
+		t.Errorf("expected an error for the invalid period, got: %v", ConfigErrors(errs))
+	}
+
+	// An initialized node is a member of a cluster already
+	store := kvtest.New()
+	v, server := newTestVault(t, store)
+	defer server.Close()
+	if _, err := v.Init(context.Background()); err != nil {
+		t.Fatalf("error initializing vault: %s", err.Error())
+	}
+	joined, err := v.(RaftJoiner).RaftJoin(context.Background(), []string{"https://vault-1:8200"}, *raft.Join)
+	if err != nil || joined {
+		t.Errorf("the initialized node has been joined: %v, %v", joined, err)
+	}
+}
+
+func TestShareKeyStores(t *testing.T) {
+	store := kvtest.New()
+	shareStores := []*kvtest.Store{kvtest.New(), kvtest.New(), kvtest.New()}
+	server := vaulttest.NewServer()
+	defer server.Close()
+	client, err := server.Client()
+	if err != nil {
+		t.Fatalf("error creating the client of the fake vault: %s", err.Error())
+	}
+
+	config := Config{SecretShares: 5, SecretThreshold: 3, StoreRootToken: true}
+	for _, shareStore := range shareStores[:2] {
+		config.ShareKeyStores = append(config.ShareKeyStores, shareStore)
+	}
+	if _, err := New(store, client, config); err == nil {
+		t.Fatalf("expected an error for 3 of the shares in the same key store")
+	}
+
+	config.ShareKeyStores = append(config.ShareKeyStores, shareStores[2])
+	v, err := New(store, client, config)
+	if err != nil {
+		t.Fatalf("error creating vault: %s", err.Error())
+	}
+	if _, err := v.Init(context.Background()); err != nil {
+		t.Fatalf("error initializing vault: %s", err.Error())
+	}
+	for i := 0; i < 5; i++ {
+		key := fmt.Sprintf("vault-unseal-%d", i)
+		if shareStores[i%3].Value(key) == nil || store.Value(key) != nil {
+			t.Errorf("%s hasn't been stored in share key store %d only", key, i%3)
+		}
+	}
+	if store.Value(RootTokenKey) == nil || store.Value(KeysMetadataKey) == nil {
+		t.Errorf("the root token and the metadata should be stored in the key store: %v", store.Keys())
+	}
+
+	if err := v.Unseal(context.Background()); err != nil {
+		t.Fatalf("error unsealing vault: %s", err.Error())
+	}
+	if server.Sealed() {
+		t.Fatalf("vault is still sealed")
+	}
+}
+
+func TestConfigureIdentity(t *testing.T) {
+	store := kvtest.New()
+	v, server := newTestVault(t, store)
+	defer server.Close()
+	if _, err := v.Init(context.Background()); err != nil {
+		t.Fatalf("error initializing vault: %s", err.Error())
+	}
+	if err := v.Unseal(context.Background()); err != nil {
+		t.Fatalf("error unsealing vault: %s", err.Error())
+	}
+
+	config := parseTestConfig(t, `
+policies:
+  - name: dev
+    rules: path "secret/*" { capabilities = ["read"] }
+auth:
+  - type: userpass
+  - type: approle
+    roles:
+      - name: ci
+        policies: dev
+identity:
+  entities:
+    - name: jane
+      policies: [dev]
+      metadata:
+        team: platform
+      aliases:
+        - name: jane
+          mount: userpass
+        - name: jane-ci
+          mount: approle
+  groups:
+    - name: developers
+      policies: dev
+      member_entities: [jane]
+    - name: admins
+      type: external
+      policies: [dev]
+      alias:
+        name: vault-admins
+        mount: userpass
+`)
+	if errs := VerifyConfigStrict(config.sections()); len(errs) > 0 {
+		t.Fatalf("unexpected errors of the config: %v", ConfigErrors(errs))
+	}
+	if errs := VerifyPolicyReferences(config.sections()); len(errs) > 0 {
+		t.Fatalf("unexpected errors of the policy references: %v", ConfigErrors(errs))
+	}
+
+	// The aliases are only created once
+	var report *ConfigureReport
+	for i := 0; i < 2; i++ {
+		var err error
+		if report, err = v.ConfigureWithReport(config); err != nil {
+			t.Fatalf("error configuring vault: %s", err.Error())
+		}
+	}
+	for _, result := range report.Resources {
+		if result.Kind == ResourceIdentityAlias && result.Action != ActionSkipped {
+			t.Errorf("the alias %s has been written again: %s", result.Name, result.Action)
+		}
+	}
+
+	jane := server.Data("identity/entity/name/jane")
+	if aliases := jane["aliases"].([]interface{}); len(aliases) != 2 {
+		t.Errorf("expected the 2 aliases of the entity, got: %v", aliases)
+	}
+	developers := server.Data("identity/group/name/developers")
+	if members := developers["member_entity_ids"].([]interface{}); len(members) != 1 || members[0] != jane["id"] {
+		t.Errorf("expected the entity in the group, got: %v", members)
+	}
+	admins := server.Data("identity/group/name/admins")
+	if alias := admins["alias"].(map[string]interface{}); alias["name"] != "vault-admins" || admins["member_entity_ids"] != nil {
+		t.Errorf("unexpected external group: %v", admins)
+	}
+
+	invalid := parseTestConfig(t, `
+identity:
+  groups:
+    - name: admins
+      type: external
+      member_entities: [jane]
+      alias:
+        name: vault-admins
+`)
+	if errs := VerifyConfig(invalid.sections()); len(errs) != 2 {
+		t.Errorf("expected the errors of the members and the mount of the external group, got: %v", ConfigErrors(errs))
+	}
+}
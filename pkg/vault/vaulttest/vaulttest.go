@@ -0,0 +1,1175 @@
+package vaulttest
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// Version is the Vault version reported by a Server
+const Version = "0.10.2"
+
+// Request is a request received by a Server, without its body
+type Request struct {
+	Method string
+	// Path is without the /v1/ prefix, e.g. sys/unseal
+	Path string
+	// Namespace is the X-Vault-Namespace header of the request, the Server doesn't separate the
+	// state of the namespaces
+	Namespace string
+	// Token is the X-Vault-Token header of the request
+	Token string
+}
+
+// Server is an in-process fake of the subset of the Vault HTTP API used by the vault package:
+// initialization, unsealing, sealing, seal migration, rekeying, root token generation, auth methods, secret engines, audit devices, policies, orphan tokens, response wrapping,
+// the encrypt and decrypt endpoints of the mounted transit engines, and generic writes and reads of any
+// other path (with the check-and-set option of the KV version 2 engines). It keeps its state in
+// memory, checks the tokens of the requests, and refuses the requests with 503 while it is sealed
+// like Vault does, so the Init, Unseal and Configure paths can be tested without running Vault.
+type Server struct {
+	server *httptest.Server
+
+	mu           sync.Mutex
+	sealType     string
+	recoverySeal bool
+	// migration is the type of the seal the Server is migrating to, empty if it isn't migrating
+	migration    string
+	initialized  bool
+	sealed       bool
+	shares       int
+	threshold    int
+	keys         []string
+	provided     map[string]bool
+	rekey        *rekeyState
+	generateRoot *generateRootState
+	rootToken    string
+	tokens       map[string]time.Time
+	auths        map[string]*api.AuthMount
+	mounts       map[string]*api.MountOutput
+	audits       map[string]*api.Audit
+	policies     map[string]string
+	data         map[string]map[string]interface{}
+	wrapped      map[string]*wrappedResponse
+	requests     []Request
+}
+
+// wrappedResponse is the data response-wrapped in a wrapping token until it expires
+type wrappedResponse struct {
+	data    map[string]interface{}
+	expires time.Time
+}
+
+// NewServer starts an uninitialized and sealed Server with a Shamir seal, it has to be closed with Close
+func NewServer() *Server {
+	s := &Server{
+		sealType: "shamir",
+		sealed:   true,
+		provided: map[string]bool{},
+		tokens:   map[string]time.Time{},
+		wrapped:  map[string]*wrappedResponse{},
+		auths: map[string]*api.AuthMount{
+			"token/": {Type: "token", Description: "token based credentials"},
+		},
+		mounts: map[string]*api.MountOutput{
+			"sys/":       {Type: "system", Description: "system endpoints used for control, policy and debugging"},
+			"cubbyhole/": {Type: "cubbyhole", Description: "per-token private secret storage"},
+		},
+		audits:   map[string]*api.Audit{},
+		policies: map[string]string{"root": "", "default": ""},
+		data:     map[string]map[string]interface{}{},
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// UseRecoverySeal makes the Server behave like Vault with an auto-unseal seal of the given type
+// (e.g. pkcs11): Init creates recovery keys instead of unseal keys, and it is unsealed by Init.
+// It has to be called before Init.
+func (s *Server) UseRecoverySeal(sealType string) {
+	s.mu.Lock()
+	s.sealType = sealType
+	s.recoverySeal = true
+	s.mu.Unlock()
+}
+
+// MigrateSeal makes the Server behave like Vault restarted with a new seal of the given type
+// ("shamir" or an auto-unseal seal) in seal migration mode: it is sealed, and it has to be unsealed
+// with the current unseal or recovery keys and the migrate option, which switches it to the new seal.
+// The keys of the old seal become the keys of the new one.
+func (s *Server) MigrateSeal(sealType string) {
+	s.mu.Lock()
+	s.migration = sealType
+	s.sealed = true
+	s.provided = map[string]bool{}
+	s.mu.Unlock()
+}
+
+// Close shuts the Server down
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// URL returns the address of the Server
+func (s *Server) URL() string {
+	return s.server.URL
+}
+
+// Client returns a new client of the Server without a token
+func (s *Server) Client() (*api.Client, error) {
+	config := api.DefaultConfig()
+	config.Address = s.server.URL
+	config.MaxRetries = 0
+	client, err := api.NewClient(config)
+	if err != nil {
+		return nil, err
+	}
+	client.ClearToken()
+	return client, nil
+}
+
+// Initialized returns if the Server has been initialized
+func (s *Server) Initialized() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.initialized
+}
+
+// Sealed returns if the Server is sealed
+func (s *Server) Sealed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sealed
+}
+
+// Seal seals the Server, e.g. to simulate the restart of Vault
+func (s *Server) Seal() {
+	s.mu.Lock()
+	s.seal()
+	s.mu.Unlock()
+}
+
+// Keys returns the hex encoded unseal keys (or recovery keys) created by Init
+func (s *Server) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.keys...)
+}
+
+// RootToken returns the root token created by Init
+func (s *Server) RootToken() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rootToken
+}
+
+// ValidToken returns if the token is valid, e.g. if a token set up by Init hasn't been revoked
+func (s *Server) ValidToken(token string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.tokens[token]
+	return ok
+}
+
+// SetTokenCreated changes the creation time of a valid token, e.g. to make the root token old
+func (s *Server) SetTokenCreated(token string, created time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.tokens[token]; ok {
+		s.tokens[token] = created
+	}
+}
+
+// Auths returns the enabled auth methods by their paths (with a trailing slash)
+func (s *Server) Auths() map[string]*api.AuthMount {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	auths := map[string]*api.AuthMount{}
+	for path, auth := range s.auths {
+		copied := *auth
+		auths[path] = &copied
+	}
+	return auths
+}
+
+// Mounts returns the mounted secret engines by their paths (with a trailing slash)
+func (s *Server) Mounts() map[string]*api.MountOutput {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mounts := map[string]*api.MountOutput{}
+	for path, mount := range s.mounts {
+		copied := *mount
+		mounts[path] = &copied
+	}
+	return mounts
+}
+
+// Audits returns the enabled audit devices by their paths (with a trailing slash)
+func (s *Server) Audits() map[string]*api.Audit {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	audits := map[string]*api.Audit{}
+	for path, audit := range s.audits {
+		copied := *audit
+		audits[path] = &copied
+	}
+	return audits
+}
+
+// Policy returns the rules of the named policy, and false if it doesn't exist
+func (s *Server) Policy(name string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rules, ok := s.policies[name]
+	return rules, ok
+}
+
+// Data returns the data written to the path (without the /v1/ prefix), nil if nothing has been written
+func (s *Server) Data(path string) map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data[path]
+}
+
+// SetData replaces the data of the path (without the /v1/ prefix), e.g. to simulate the normalization
+// of the written fields by Vault
+func (s *Server) SetData(path string, data map[string]interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data[path] = data
+}
+
+// Paths returns the paths written with generic writes in alphabetical order
+func (s *Server) Paths() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	paths := []string{}
+	for path := range s.data {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Requests returns the requests received by the Server in their order
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request{}, s.requests...)
+}
+
+func (s *Server) seal() {
+	s.sealed = true
+	s.provided = map[string]bool{}
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/v1/")
+	s.requests = append(s.requests, Request{Method: r.Method, Path: path, Namespace: r.Header.Get("X-Vault-Namespace"), Token: r.Header.Get("X-Vault-Token")})
+
+	var body map[string]interface{}
+	if r.Body != nil {
+		// An empty body is decoded as nil
+		json.NewDecoder(r.Body).Decode(&body)
+	}
+
+	switch path {
+	case "sys/init":
+		s.handleInit(w, r, body)
+		return
+	case "sys/seal-status":
+		respond(w, http.StatusOK, s.sealStatus())
+		return
+	case "sys/leader":
+		respond(w, http.StatusOK, &api.LeaderResponse{HAEnabled: false})
+		return
+	case "sys/unseal":
+		s.handleUnseal(w, r, body)
+		return
+	}
+
+	if !s.initialized {
+		respondError(w, http.StatusBadRequest, "Vault is not initialized")
+		return
+	}
+	if s.sealed {
+		respondError(w, http.StatusServiceUnavailable, "Vault is sealed")
+		return
+	}
+	// The rekey and root token generation endpoints are authorized by the keys instead of a token
+	if strings.HasPrefix(path, "sys/rekey/") || strings.HasPrefix(path, "sys/rekey-recovery-key/") {
+		s.handleRekey(w, r, body, path[strings.LastIndex(path, "/")+1:])
+		return
+	}
+	if strings.HasPrefix(path, "sys/generate-root/") {
+		s.handleGenerateRoot(w, r, body, strings.TrimPrefix(path, "sys/generate-root/"))
+		return
+	}
+
+	// The wrapping tokens are authorized by themselves
+	if path == "sys/wrapping/unwrap" {
+		s.handleUnwrap(w, r, body)
+		return
+	}
+
+	token := r.Header.Get("X-Vault-Token")
+	if _, ok := s.tokens[token]; !ok {
+		respondError(w, http.StatusForbidden, "permission denied")
+		return
+	}
+
+	switch {
+	case path == "sys/seal":
+		s.seal()
+		w.WriteHeader(http.StatusNoContent)
+
+	case path == "sys/wrapping/wrap":
+		s.handleWrap(w, r, body)
+
+	case path == "sys/auth" && r.Method == http.MethodGet:
+		respond(w, http.StatusOK, s.auths)
+
+	case strings.HasPrefix(path, "sys/auth/"):
+		s.handleMount(w, r, body, s.enableAuth, strings.TrimPrefix(path, "sys/auth/"))
+
+	case path == "sys/mounts" && r.Method == http.MethodGet:
+		respond(w, http.StatusOK, s.mounts)
+
+	case strings.HasPrefix(path, "sys/mounts/"):
+		s.handleMount(w, r, body, s.mount, strings.TrimPrefix(path, "sys/mounts/"))
+
+	case path == "sys/remount":
+		from, to := strings.Trim(stringField(body, "from"), "/")+"/", strings.Trim(stringField(body, "to"), "/")+"/"
+		mount, ok := s.mounts[from]
+		if !ok {
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("no secret engine mounted at %s", from))
+			return
+		}
+		if _, ok := s.mounts[to]; ok {
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("path is already in use at %s", to))
+			return
+		}
+		delete(s.mounts, from)
+		s.mounts[to] = mount
+		w.WriteHeader(http.StatusNoContent)
+
+	case path == "sys/audit" && r.Method == http.MethodGet:
+		audits := map[string]interface{}{}
+		for path, audit := range s.audits {
+			audits[path] = map[string]interface{}{
+				"path":        audit.Path,
+				"type":        audit.Type,
+				"description": audit.Description,
+				"options":     audit.Options,
+				"local":       audit.Local,
+			}
+		}
+		respond(w, http.StatusOK, audits)
+
+	case strings.HasPrefix(path, "sys/audit/"):
+		s.handleAudit(w, r, body, strings.TrimPrefix(path, "sys/audit/"))
+
+	case path == "sys/policy" && r.Method == http.MethodGet:
+		names := []string{"default", "root"}
+		for name := range s.policies {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		respond(w, http.StatusOK, map[string]interface{}{"policies": names, "keys": names})
+
+	case strings.HasPrefix(path, "sys/policy/"):
+		s.handlePolicy(w, r, body, strings.TrimPrefix(path, "sys/policy/"))
+
+	case path == "auth/token/create-orphan":
+		s.handleCreateOrphan(w, body)
+
+	case path == "auth/token/lookup-self":
+		respond(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{
+			"id":            token,
+			"creation_time": s.tokens[token].Unix(),
+		}})
+
+	case path == "auth/token/renew-self":
+		respond(w, http.StatusOK, map[string]interface{}{"auth": map[string]interface{}{"client_token": token, "renewable": true}})
+
+	case path == "auth/token/revoke-self":
+		delete(s.tokens, token)
+		w.WriteHeader(http.StatusNoContent)
+
+	case path == "auth/token/revoke-orphan":
+		delete(s.tokens, fmt.Sprint(body["token"]))
+		w.WriteHeader(http.StatusNoContent)
+
+	case strings.HasPrefix(path, "identity/") && r.Method != http.MethodGet:
+		s.handleIdentity(w, body, path)
+
+	case s.transitPath(path):
+		s.handleTransit(w, body, path)
+
+	case s.sshCAPath(path) && r.Method != http.MethodDelete:
+		s.handleSSHCA(w, r, body, path)
+
+	case s.pkiPath(path) != "" && r.Method != http.MethodGet:
+		s.handlePKI(w, body, path)
+
+	case strings.Contains(path, "/rotate-root/") && r.Method != http.MethodGet:
+		// The password of the connection of a database secret engine is replaced by a random one
+		connection := strings.Replace(path, "/rotate-root/", "/config/", 1)
+		data, ok := s.data[connection]
+		if !ok {
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("no connection at %s", connection))
+			return
+		}
+		data["password"] = randomToken()
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		s.handleData(w, r, body, path)
+	}
+}
+
+// transitPath returns true if the path is <mount>/encrypt/<key> or <mount>/decrypt/<key> of a mounted
+// transit engine
+func (s *Server) transitPath(path string) bool {
+	parts := strings.Split(path, "/")
+	if len(parts) < 3 || (parts[len(parts)-2] != "encrypt" && parts[len(parts)-2] != "decrypt") {
+		return false
+	}
+	mount, ok := s.mounts[strings.Join(parts[:len(parts)-2], "/")+"/"]
+	return ok && mount.Type == "transit"
+}
+
+// pkiOperations are the operations of the pki engine setting up its CA, which are simulated
+var pkiOperations = []string{"root/generate/internal", "intermediate/generate/internal", "root/sign-intermediate", "intermediate/set-signed", "config/ca"}
+
+// pkiPath returns the operation if the path is an operation setting up the CA of a mounted pki engine
+func (s *Server) pkiPath(path string) string {
+	for _, operation := range pkiOperations {
+		if mount := strings.TrimSuffix(path, "/"+operation); mount != path {
+			if m, ok := s.mounts[mount+"/"]; ok && m.Type == "pki" {
+				return operation
+			}
+		}
+	}
+	return ""
+}
+
+// handlePKI sets the CA certificate of a pki engine (read at cert/ca) to a fake one holding the common
+// name, the CSRs and the signed certificates are fakes holding the common names as well
+func (s *Server) handlePKI(w http.ResponseWriter, body map[string]interface{}, path string) {
+	operation := s.pkiPath(path)
+	mount := strings.TrimSuffix(path, "/"+operation)
+	setCA := func(certificate string) {
+		s.data[mount+"/cert/ca"] = map[string]interface{}{"certificate": certificate}
+	}
+	switch operation {
+	case "root/generate/internal":
+		setCA("root:" + stringField(body, "common_name"))
+		respond(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"certificate": "root:" + stringField(body, "common_name")}})
+	case "intermediate/generate/internal":
+		respond(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"csr": "csr:" + stringField(body, "common_name")}})
+	case "root/sign-intermediate":
+		ca, ok := s.data[mount+"/cert/ca"]
+		if !ok {
+			respondError(w, http.StatusBadRequest, "no CA to sign with")
+			return
+		}
+		certificate := fmt.Sprintf("%s signed by %s", stringField(body, "csr"), ca["certificate"])
+		respond(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"certificate": certificate}})
+	case "intermediate/set-signed":
+		setCA(stringField(body, "certificate"))
+		w.WriteHeader(http.StatusNoContent)
+	case "config/ca":
+		setCA(stringField(body, "pem_bundle"))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleWrap wraps the body in a wrapping token of the TTL of the X-Vault-Wrap-TTL header
+func (s *Server) handleWrap(w http.ResponseWriter, r *http.Request, body map[string]interface{}) {
+	ttl, err := time.ParseDuration(r.Header.Get("X-Vault-Wrap-TTL"))
+	if err != nil || ttl <= 0 {
+		respondError(w, http.StatusBadRequest, "invalid wrap TTL")
+		return
+	}
+	created := time.Now()
+	token := randomToken()
+	s.wrapped[token] = &wrappedResponse{data: body, expires: created.Add(ttl)}
+	respond(w, http.StatusOK, map[string]interface{}{"wrap_info": map[string]interface{}{
+		"token":         token,
+		"accessor":      randomToken(),
+		"ttl":           int(ttl.Seconds()),
+		"creation_time": created.Format(time.RFC3339Nano),
+		"creation_path": "sys/wrapping/wrap",
+	}})
+}
+
+// handleUnwrap returns the data wrapped in the token of the request (or of the body) once
+func (s *Server) handleUnwrap(w http.ResponseWriter, r *http.Request, body map[string]interface{}) {
+	token := stringField(body, "token")
+	if token == "" {
+		token = r.Header.Get("X-Vault-Token")
+	}
+	wrapped, ok := s.wrapped[token]
+	delete(s.wrapped, token)
+	if !ok || time.Now().After(wrapped.expires) {
+		respondError(w, http.StatusBadRequest, "wrapping token is not valid or does not exist")
+		return
+	}
+	respond(w, http.StatusOK, map[string]interface{}{"data": wrapped.data})
+}
+
+// sshCAPath returns true if the path is the config/ca of a mounted ssh engine
+func (s *Server) sshCAPath(path string) bool {
+	mount := strings.TrimSuffix(path, "/config/ca")
+	m, ok := s.mounts[mount+"/"]
+	return mount != path && ok && m.Type == "ssh"
+}
+
+// handleSSHCA sets the CA signing key of an ssh engine once, a generated one is a fake public key,
+// it is read like in Vault, which responds with an error if it hasn't been set up
+func (s *Server) handleSSHCA(w http.ResponseWriter, r *http.Request, body map[string]interface{}, path string) {
+	ca, ok := s.data[path]
+	if r.Method == http.MethodGet {
+		if !ok {
+			respondError(w, http.StatusBadRequest, "keys haven't been configured yet")
+			return
+		}
+		respond(w, http.StatusOK, map[string]interface{}{"data": ca})
+		return
+	}
+	if ok {
+		respondError(w, http.StatusBadRequest, "keys are already configured")
+		return
+	}
+	publicKey := stringField(body, "public_key")
+	if generate, _ := body["generate_signing_key"].(bool); generate {
+		publicKey = "ssh-rsa " + randomToken()
+	}
+	if publicKey == "" {
+		respondError(w, http.StatusBadRequest, "missing public_key")
+		return
+	}
+	s.data[path] = map[string]interface{}{"public_key": publicKey}
+	respond(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"public_key": publicKey}})
+}
+
+// handleTransit "encrypts" the plaintext into vault:v1:<key>:<plaintext>, it only checks that the
+// ciphertext is decrypted with the same key
+func (s *Server) handleTransit(w http.ResponseWriter, body map[string]interface{}, path string) {
+	parts := strings.Split(path, "/")
+	operation, key := parts[len(parts)-2], parts[len(parts)-1]
+	prefix := "vault:v1:" + key + ":"
+	if operation == "encrypt" {
+		respond(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{
+			"ciphertext": prefix + stringField(body, "plaintext"),
+		}})
+		return
+	}
+	ciphertext := stringField(body, "ciphertext")
+	if !strings.HasPrefix(ciphertext, prefix) {
+		respondError(w, http.StatusBadRequest, "invalid ciphertext")
+		return
+	}
+	respond(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{
+		"plaintext": strings.TrimPrefix(ciphertext, prefix),
+	}})
+}
+
+// sealStatusResponse is the seal status with the migration field, which the api package doesn't have
+type sealStatusResponse struct {
+	*api.SealStatusResponse
+	Migration bool `json:"migration,omitempty"`
+}
+
+func (s *Server) sealStatus() *sealStatusResponse {
+	status := &api.SealStatusResponse{
+		Type:         s.sealType,
+		Sealed:       s.sealed,
+		T:            s.threshold,
+		N:            s.shares,
+		Progress:     len(s.provided),
+		Version:      Version,
+		RecoverySeal: s.recoverySeal,
+	}
+	if !s.sealed {
+		status.ClusterName = "vaulttest"
+		status.ClusterID = "00000000-0000-0000-0000-000000000000"
+	}
+	return &sealStatusResponse{SealStatusResponse: status, Migration: s.migration != ""}
+}
+
+func (s *Server) handleInit(w http.ResponseWriter, r *http.Request, body map[string]interface{}) {
+	if r.Method == http.MethodGet {
+		respond(w, http.StatusOK, map[string]interface{}{"initialized": s.initialized})
+		return
+	}
+	if s.initialized {
+		respondError(w, http.StatusBadRequest, "Vault is already initialized")
+		return
+	}
+
+	sharesField, thresholdField := "secret_shares", "secret_threshold"
+	if s.recoverySeal {
+		sharesField, thresholdField = "recovery_shares", "recovery_threshold"
+	}
+	shares, threshold := intField(body, sharesField), intField(body, thresholdField)
+	if shares < 1 || threshold < 1 || threshold > shares {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("invalid %s %d and %s %d", sharesField, shares, thresholdField, threshold))
+		return
+	}
+
+	s.initialized = true
+	s.shares, s.threshold = shares, threshold
+	s.rootToken = randomToken()
+	s.tokens[s.rootToken] = time.Now()
+
+	response := &api.InitResponse{RootToken: s.rootToken}
+	keys, keysB64 := newKeys(shares)
+	s.keys = keys
+	if s.recoverySeal {
+		response.RecoveryKeys, response.RecoveryKeysB64 = keys, keysB64
+	} else {
+		response.Keys, response.KeysB64 = keys, keysB64
+	}
+	if s.recoverySeal {
+		s.sealed = false
+	}
+
+	respond(w, http.StatusOK, response)
+}
+
+func (s *Server) handleUnseal(w http.ResponseWriter, r *http.Request, body map[string]interface{}) {
+	if !s.initialized {
+		respondError(w, http.StatusBadRequest, "Vault is not initialized")
+		return
+	}
+	if !s.sealed {
+		respond(w, http.StatusOK, s.sealStatus())
+		return
+	}
+	if reset, _ := body["reset"].(bool); reset {
+		s.provided = map[string]bool{}
+		respond(w, http.StatusOK, s.sealStatus())
+		return
+	}
+
+	if migrate, _ := body["migrate"].(bool); s.migration != "" && !migrate {
+		respondError(w, http.StatusBadRequest, "'migrate' parameter must be set true in JSON body when in seal migration mode")
+		return
+	}
+
+	key, _ := body["key"].(string)
+	if !containsKey(s.keys, key) {
+		s.provided = map[string]bool{}
+		respondError(w, http.StatusBadRequest, "invalid key")
+		return
+	}
+
+	s.provided[key] = true
+	if len(s.provided) >= s.threshold {
+		s.sealed = false
+		s.provided = map[string]bool{}
+		if s.migration != "" {
+			s.sealType = s.migration
+			s.recoverySeal = s.migration != "shamir"
+			s.migration = ""
+		}
+	}
+	respond(w, http.StatusOK, s.sealStatus())
+}
+
+// rekeyState is the state of a rekey in progress
+type rekeyState struct {
+	nonce     string
+	shares    int
+	threshold int
+	verify    bool
+	provided  map[string]bool
+	// newKeys are waiting for the verification, if it is required
+	newKeys           []string
+	verificationNonce string
+}
+
+// handleRekey handles the init, update and verify operations of a rekey (of the unseal keys or the
+// recovery keys alike), the new keys replace the current ones after the optional verification
+func (s *Server) handleRekey(w http.ResponseWriter, r *http.Request, body map[string]interface{}, operation string) {
+	if r.Method == http.MethodDelete {
+		s.rekey = nil
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	key := stringField(body, "key")
+	switch operation {
+	case "init":
+		shares, threshold := intField(body, "secret_shares"), intField(body, "secret_threshold")
+		if shares < 1 || threshold < 1 || threshold > shares {
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("invalid secret_shares %d and secret_threshold %d", shares, threshold))
+			return
+		}
+		verify, _ := body["require_verification"].(bool)
+		s.rekey = &rekeyState{nonce: randomToken(), shares: shares, threshold: threshold, verify: verify, provided: map[string]bool{}}
+		respond(w, http.StatusOK, &api.RekeyStatusResponse{
+			Nonce:                s.rekey.nonce,
+			Started:              true,
+			T:                    threshold,
+			N:                    shares,
+			Required:             s.threshold,
+			VerificationRequired: verify,
+		})
+
+	case "update":
+		if s.rekey == nil || s.rekey.newKeys != nil || stringField(body, "nonce") != s.rekey.nonce {
+			respondError(w, http.StatusBadRequest, "no rekey in progress")
+			return
+		}
+		if !containsKey(s.keys, key) {
+			respondError(w, http.StatusBadRequest, "invalid key")
+			return
+		}
+		s.rekey.provided[key] = true
+		if len(s.rekey.provided) < s.threshold {
+			respond(w, http.StatusOK, &api.RekeyUpdateResponse{Nonce: s.rekey.nonce})
+			return
+		}
+
+		keys, keysB64 := newKeys(s.rekey.shares)
+		response := &api.RekeyUpdateResponse{Nonce: s.rekey.nonce, Complete: true, Keys: keys, KeysB64: keysB64}
+		if s.rekey.verify {
+			s.rekey.newKeys, s.rekey.verificationNonce = keys, randomToken()
+			s.rekey.provided = map[string]bool{}
+			response.VerificationRequired, response.VerificationNonce = true, s.rekey.verificationNonce
+		} else {
+			s.keys, s.shares, s.threshold = keys, s.rekey.shares, s.rekey.threshold
+			s.rekey = nil
+		}
+		respond(w, http.StatusOK, response)
+
+	case "verify":
+		if s.rekey == nil || s.rekey.newKeys == nil || stringField(body, "nonce") != s.rekey.verificationNonce {
+			respondError(w, http.StatusBadRequest, "no rekey verification in progress")
+			return
+		}
+		if !containsKey(s.rekey.newKeys, key) {
+			respondError(w, http.StatusBadRequest, "invalid key")
+			return
+		}
+		s.rekey.provided[key] = true
+		nonce := s.rekey.verificationNonce
+		if len(s.rekey.provided) < s.rekey.threshold {
+			respond(w, http.StatusOK, &api.RekeyVerificationUpdateResponse{Nonce: nonce})
+			return
+		}
+		s.keys, s.shares, s.threshold = s.rekey.newKeys, s.rekey.shares, s.rekey.threshold
+		s.rekey = nil
+		respond(w, http.StatusOK, &api.RekeyVerificationUpdateResponse{Nonce: nonce, Complete: true})
+
+	default:
+		respondError(w, http.StatusNotFound, "")
+	}
+}
+
+// generateRootState is the state of a root token generation in progress
+type generateRootState struct {
+	nonce    string
+	otp      []byte
+	provided map[string]bool
+}
+
+// handleGenerateRoot handles the attempt and update operations of a root token generation with a
+// one time password, the new root token is a UUID encoded as the XOR of its bytes and the password
+func (s *Server) handleGenerateRoot(w http.ResponseWriter, r *http.Request, body map[string]interface{}, operation string) {
+	if r.Method == http.MethodDelete {
+		s.generateRoot = nil
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	switch operation {
+	case "attempt":
+		otp, err := base64.StdEncoding.DecodeString(stringField(body, "otp"))
+		if err != nil || len(otp) != 16 {
+			respondError(w, http.StatusBadRequest, "invalid otp")
+			return
+		}
+		s.generateRoot = &generateRootState{nonce: randomToken(), otp: otp, provided: map[string]bool{}}
+		respond(w, http.StatusOK, &api.GenerateRootStatusResponse{Nonce: s.generateRoot.nonce, Started: true, Required: s.threshold})
+
+	case "update":
+		if s.generateRoot == nil || stringField(body, "nonce") != s.generateRoot.nonce {
+			respondError(w, http.StatusBadRequest, "no root generation in progress")
+			return
+		}
+		key := stringField(body, "key")
+		if !containsKey(s.keys, key) {
+			respondError(w, http.StatusBadRequest, "invalid key")
+			return
+		}
+		s.generateRoot.provided[key] = true
+		response := &api.GenerateRootStatusResponse{
+			Nonce:    s.generateRoot.nonce,
+			Started:  true,
+			Progress: len(s.generateRoot.provided),
+			Required: s.threshold,
+		}
+		if len(s.generateRoot.provided) >= s.threshold {
+			tokenBytes := make([]byte, 16)
+			rand.Read(tokenBytes)
+			token := fmt.Sprintf("%x-%x-%x-%x-%x", tokenBytes[0:4], tokenBytes[4:6], tokenBytes[6:8], tokenBytes[8:10], tokenBytes[10:16])
+			s.tokens[token] = time.Now()
+			for i := range tokenBytes {
+				tokenBytes[i] ^= s.generateRoot.otp[i]
+			}
+			response.Complete = true
+			response.EncodedRootToken = base64.StdEncoding.EncodeToString(tokenBytes)
+			s.generateRoot = nil
+		}
+		respond(w, http.StatusOK, response)
+
+	default:
+		respondError(w, http.StatusNotFound, "")
+	}
+}
+
+func (s *Server) handleMount(w http.ResponseWriter, r *http.Request, body map[string]interface{}, mount func(path string, body map[string]interface{}) error, path string) {
+	path = strings.TrimSuffix(path, "/")
+	if r.Method == http.MethodDelete {
+		delete(s.auths, path+"/")
+		delete(s.mounts, path+"/")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if tuned := strings.TrimSuffix(path, "/tune"); tuned != path {
+		if auth, ok := s.auths[tuned+"/"]; ok {
+			// Only the lease TTLs of the tuning of the auth methods are kept
+			tuneTTL(body, "default_lease_ttl", &auth.Config.DefaultLeaseTTL)
+			tuneTTL(body, "max_lease_ttl", &auth.Config.MaxLeaseTTL)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		existing, ok := s.mounts[tuned+"/"]
+		if !ok {
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("no mount at %s", tuned))
+			return
+		}
+		if options, ok := body["options"].(map[string]interface{}); ok {
+			existing.Options = stringMap(options)
+		}
+		tuneTTL(body, "default_lease_ttl", &existing.Config.DefaultLeaseTTL)
+		tuneTTL(body, "max_lease_ttl", &existing.Config.MaxLeaseTTL)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err := mount(path, body); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// tuneTTL sets ttl to the field of a tuning in seconds, given as a number of seconds or a duration
+func tuneTTL(body map[string]interface{}, field string, ttl *int) {
+	switch value := body[field].(type) {
+	case float64:
+		*ttl = int(value)
+	case string:
+		if duration, err := time.ParseDuration(value); err == nil {
+			*ttl = int(duration.Seconds())
+		}
+	}
+}
+
+func (s *Server) enableAuth(path string, body map[string]interface{}) error {
+	if _, ok := s.auths[path+"/"]; ok {
+		return fmt.Errorf("path is already in use at %s/", path)
+	}
+	s.auths[path+"/"] = &api.AuthMount{
+		Type:        fmt.Sprint(body["type"]),
+		Description: stringField(body, "description"),
+		Accessor:    fmt.Sprintf("auth_%s_%s", body["type"], randomToken()[:8]),
+	}
+	return nil
+}
+
+func (s *Server) mount(path string, body map[string]interface{}) error {
+	if _, ok := s.mounts[path+"/"]; ok {
+		return fmt.Errorf("path is already in use at %s/", path)
+	}
+	mount := &api.MountOutput{
+		Type:        fmt.Sprint(body["type"]),
+		Description: stringField(body, "description"),
+		Accessor:    fmt.Sprintf("%s_%s", body["type"], randomToken()[:8]),
+	}
+	if options, ok := body["options"].(map[string]interface{}); ok {
+		mount.Options = stringMap(options)
+	}
+	s.mounts[path+"/"] = mount
+	return nil
+}
+
+func (s *Server) handleAudit(w http.ResponseWriter, r *http.Request, body map[string]interface{}, path string) {
+	path = strings.TrimSuffix(path, "/")
+	if r.Method == http.MethodDelete {
+		delete(s.audits, path+"/")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if _, ok := s.audits[path+"/"]; ok {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("path already in use at %s/", path))
+		return
+	}
+	audit := &api.Audit{
+		Path:        path + "/",
+		Type:        fmt.Sprint(body["type"]),
+		Description: stringField(body, "description"),
+	}
+	if options, ok := body["options"].(map[string]interface{}); ok {
+		audit.Options = stringMap(options)
+	}
+	audit.Local, _ = body["local"].(bool)
+	s.audits[path+"/"] = audit
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handlePolicy(w http.ResponseWriter, r *http.Request, body map[string]interface{}, name string) {
+	switch r.Method {
+	case http.MethodGet:
+		rules, ok := s.policies[name]
+		if !ok {
+			respondError(w, http.StatusNotFound, "")
+			return
+		}
+		respond(w, http.StatusOK, map[string]interface{}{"name": name, "rules": rules})
+	case http.MethodDelete:
+		delete(s.policies, name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s.policies[name] = stringField(body, "rules")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *Server) handleCreateOrphan(w http.ResponseWriter, body map[string]interface{}) {
+	token := stringField(body, "id")
+	if token == "" {
+		token = randomToken()
+	}
+	if _, ok := s.tokens[token]; ok {
+		respondError(w, http.StatusBadRequest, "cannot create a token with a duplicate ID")
+		return
+	}
+	s.tokens[token] = time.Now()
+	respond(w, http.StatusOK, map[string]interface{}{
+		"auth": map[string]interface{}{
+			"client_token": token,
+			"policies":     body["policies"],
+			"renewable":    false,
+		},
+	})
+}
+
+func (s *Server) handleData(w http.ResponseWriter, r *http.Request, body map[string]interface{}, path string) {
+	switch r.Method {
+	case http.MethodGet:
+		if r.URL.Query().Get("list") == "true" {
+			s.handleList(w, path)
+			return
+		}
+		data, ok := s.data[path]
+		if !ok {
+			respondError(w, http.StatusNotFound, "")
+			return
+		}
+		respond(w, http.StatusOK, map[string]interface{}{"data": data})
+	case http.MethodDelete:
+		delete(s.data, path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		if s.kv2DataPath(path) {
+			s.handleKV2Write(w, body, path)
+			return
+		}
+		s.data[path] = body
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleIdentity writes the entities and groups by their names with generated IDs, and their aliases,
+// which are listed in the aliases of the entity or set as the alias of the group they belong to
+func (s *Server) handleIdentity(w http.ResponseWriter, body map[string]interface{}, path string) {
+	switch {
+	case strings.HasPrefix(path, "identity/entity/name/") || strings.HasPrefix(path, "identity/group/name/"):
+		existing, ok := s.data[path]
+		if !ok {
+			existing = map[string]interface{}{
+				"id":   randomToken(),
+				"name": path[strings.LastIndex(path, "/")+1:],
+			}
+			if strings.HasPrefix(path, "identity/entity/") {
+				existing["aliases"] = []interface{}{}
+			}
+			s.data[path] = existing
+		}
+		for key, value := range body {
+			existing[key] = value
+		}
+		respond(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"id": existing["id"], "name": existing["name"]}})
+
+	case path == "identity/entity-alias" || path == "identity/group-alias" || strings.HasPrefix(path, "identity/group-alias/id/"):
+		canonicalID := stringField(body, "canonical_id")
+		for owner, data := range s.data {
+			if data["id"] != canonicalID || !strings.HasPrefix(owner, "identity/") {
+				continue
+			}
+			alias := map[string]interface{}{
+				"id":             path[strings.LastIndex(path, "/")+1:],
+				"name":           stringField(body, "name"),
+				"mount_accessor": stringField(body, "mount_accessor"),
+				"canonical_id":   canonicalID,
+			}
+			if !strings.HasPrefix(path, "identity/group-alias/id/") {
+				alias["id"] = randomToken()
+			}
+			if strings.HasPrefix(owner, "identity/entity/") {
+				data["aliases"] = append(data["aliases"].([]interface{}), alias)
+			} else {
+				data["alias"] = alias
+			}
+			respond(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"id": alias["id"], "canonical_id": canonicalID}})
+			return
+		}
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("no entity or group with id %s", canonicalID))
+
+	default:
+		respondError(w, http.StatusNotFound, "unsupported path")
+	}
+}
+
+// kv2DataPath returns true if the path is <mount>/data/<secret> of a mounted KV version 2 engine
+func (s *Server) kv2DataPath(path string) bool {
+	for mountPath, mount := range s.mounts {
+		if mount.Type == "kv" && mount.Options["version"] == "2" && strings.HasPrefix(path, mountPath+"data/") {
+			return true
+		}
+	}
+	return false
+}
+
+// handleKV2Write stores the data of a KV version 2 secret with its version in the metadata, and
+// refuses it if its check-and-set option doesn't match the current version like Vault does
+func (s *Server) handleKV2Write(w http.ResponseWriter, body map[string]interface{}, path string) {
+	current := 0
+	if existing, ok := s.data[path]; ok {
+		metadata, _ := existing["metadata"].(map[string]interface{})
+		current = intField(metadata, "version")
+	}
+	if options, ok := body["options"].(map[string]interface{}); ok && options["cas"] != nil && intField(options, "cas") != current {
+		respondError(w, http.StatusBadRequest, "check-and-set parameter did not match the current version")
+		return
+	}
+	s.data[path] = map[string]interface{}{
+		"data":     body["data"],
+		"metadata": map[string]interface{}{"version": float64(current + 1)},
+	}
+	respond(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"version": current + 1}})
+}
+
+// handleList responds the keys of the data written under the path, the keys of the deeper paths end
+// with a slash
+func (s *Server) handleList(w http.ResponseWriter, path string) {
+	prefix := strings.TrimSuffix(path, "/") + "/"
+	seen := map[string]bool{}
+	keys := []interface{}{}
+	for dataPath := range s.data {
+		if !strings.HasPrefix(dataPath, prefix) {
+			continue
+		}
+		key := strings.TrimPrefix(dataPath, prefix)
+		if i := strings.Index(key, "/"); i >= 0 {
+			key = key[:i+1]
+		}
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		respondError(w, http.StatusNotFound, "")
+		return
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].(string) < keys[j].(string) })
+	respond(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"keys": keys}})
+}
+
+func respond(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func respondError(w http.ResponseWriter, status int, message string) {
+	errors := []string{}
+	if message != "" {
+		errors = append(errors, message)
+	}
+	respond(w, status, map[string]interface{}{"errors": errors})
+}
+
+func randomToken() string {
+	token := make([]byte, 16)
+	rand.Read(token)
+	return hex.EncodeToString(token)
+}
+
+func intField(body map[string]interface{}, field string) int {
+	value, _ := body[field].(float64)
+	return int(value)
+}
+
+func stringField(body map[string]interface{}, field string) string {
+	value, _ := body[field].(string)
+	return value
+}
+
+func stringMap(m map[string]interface{}) map[string]string {
+	result := map[string]string{}
+	for key, value := range m {
+		result[key] = fmt.Sprint(value)
+	}
+	return result
+}
+
+// newKeys returns new random keys hex and base64 encoded
+func newKeys(count int) ([]string, []string) {
+	keys, keysB64 := []string{}, []string{}
+	for i := 0; i < count; i++ {
+		key := make([]byte, 32)
+		rand.Read(key)
+		keys = append(keys, hex.EncodeToString(key))
+		keysB64 = append(keysB64, base64.StdEncoding.EncodeToString(key))
+	}
+	return keys, keysB64
+}
+
+func containsKey(keys []string, key string) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,848 @@
+package vault
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/hcl"
+	"github.com/hashicorp/hcl/hcl/ast"
+	"github.com/spf13/cast"
+)
+
+// ConfigSections is the set of top-level sections understood in the external configuration, the
+// sections of the registered Configurators are understood too
+var ConfigSections = map[string]bool{
+	"policies": true,
+	"auth":     true,
+	"secrets":  true,
+	"audit":    true,
+
+	"startupSecrets": true,
+	"sys":            true,
+	"identity":       true,
+	namespaceField:   true,
+	raftSection:      true,
+}
+
+// policyCapabilities is the set of capabilities a path can be granted in a policy
+var policyCapabilities = map[string]bool{
+	"create": true,
+	"read":   true,
+	"update": true,
+	"delete": true,
+	"list":   true,
+	"sudo":   true,
+	"deny":   true,
+}
+
+// ConfigError is a problem found in the external configuration
+type ConfigError struct {
+	// Path is the location of the problem, e.g. policies[0].rules
+	Path string
+	// Value is the offending value, nil if it is missing
+	Value   interface{}
+	Message string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// ConfigErrors is returned by Configure in strict mode if the external configuration has problems
+type ConfigErrors []*ConfigError
+
+func (e ConfigErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return fmt.Sprintf("invalid configuration: %s", strings.Join(messages, ", "))
+}
+
+// knownFields are the fields of the items of the sections (and of the auth methods by their
+// types) which are applied by Configure, the others are ignored unless the config is strict
+var knownFields = map[string][]string{
+	"policies":        {"name", "rules", createOnlyField, namespaceField},
+	"auth":            {"type", "path", namespaceField},
+	"auth/kubernetes": {"config", "roles"},
+	"auth/github":     {"config", "map"},
+	"auth/aws":        {"config", "roles"},
+	"auth/ldap":       {"config", "groups", "users"},
+	"auth/approle":    {"roles"},
+	"auth/userpass":   {"users"},
+	"auth/jwt":        {"config", "roles"},
+	"auth/oidc":       {"config", "roles"},
+	"auth/gcp":        {"config", "roles"},
+	"auth/azure":      {"config", "roles"},
+	"auth/okta":       {"config", "groups", "users"},
+	"auth/radius":     {"config", "users"},
+	"auth/cert":       {"config", "certs"},
+	"auth/token":      {authTuneField, "roles"},
+	"secrets":         {"type", "path", "description", "plugin_name", "options", "configuration", secretEngineStateField, migrateFromField, pkiField, sshField, kvVersionField, namespaceField},
+	"audit":           {"type", "path", "description", "options", "local"},
+	"startupSecrets":  {"path", "data", casField, namespaceField},
+	"identity/entity": {"name", "policies", "metadata", "disabled", identityAliasesField},
+	"identity/group":  {"name", "type", "policies", "metadata", identityMemberEntitiesField, identityMemberGroupsField, identityAliasField},
+}
+
+// jwtRoleClaimFields are the fields of the JWT/OIDC roles which are objects of the claims
+var jwtRoleClaimFields = map[string]bool{"bound_claims": true, "claim_mappings": true}
+
+// VerifyConfig checks the external configuration without contacting Vault: the structure of
+// the sections, the syntax of the policies and the types of the auth method role payloads
+func VerifyConfig(config map[string]interface{}) []*ConfigError {
+	return verifyConfig(config, false)
+}
+
+// VerifyConfigStrict checks the external configuration like VerifyConfig, and reports the fields
+// which would be ignored by Configure as well (e.g. misspelled ones)
+func VerifyConfigStrict(config map[string]interface{}) []*ConfigError {
+	return verifyConfig(config, true)
+}
+
+func verifyConfig(config map[string]interface{}, strict bool) []*ConfigError {
+	var errs []*ConfigError
+	report := func(path string, value interface{}, format string, args ...interface{}) {
+		errs = append(errs, &ConfigError{Path: path, Value: value, Message: fmt.Sprintf(format, args...)})
+	}
+
+	config, warnings, err := MigrateConfig(config)
+	if err != nil {
+		report(configAPIVersionKey, nil, "%s", err.Error())
+		return errs
+	}
+	if strict {
+		errs = append(errs, warnings...)
+	}
+
+	sections := []string{}
+	for section := range config {
+		if section != configAPIVersionKey {
+			sections = append(sections, section)
+		}
+	}
+	sort.Strings(sections)
+	for _, section := range sections {
+		if configurator := configuratorFor(section); configurator != nil {
+			errs = append(errs, validateConfigurator(configurator, section, config[section])...)
+		} else if !ConfigSections[section] {
+			report(section, config[section], "unsupported section")
+		}
+	}
+
+	// items returns the list in the given section as maps, reporting invalid items
+	items := func(path string, value interface{}) []map[string]interface{} {
+		list, ok := value.([]interface{})
+		if !ok {
+			report(path, value, "must be a list")
+			return nil
+		}
+		result := make([]map[string]interface{}, len(list))
+		for i, item := range list {
+			itemMap, err := cast.ToStringMapE(item)
+			if err != nil {
+				report(fmt.Sprintf("%s[%d]", path, i), item, "must be an object")
+				continue
+			}
+			result[i] = itemMap
+		}
+		return result
+	}
+
+	// requiredString reports a missing or non-string field of an item
+	requiredString := func(path string, item map[string]interface{}, name string) string {
+		value, ok := item[name]
+		if !ok {
+			report(path+"."+name, nil, "required value")
+			return ""
+		}
+		s, ok := value.(string)
+		if !ok || s == "" {
+			report(path+"."+name, value, "must be a non-empty string")
+		}
+		return s
+	}
+
+	// unknownFields reports the fields of an item which aren't known in strict mode
+	unknownFields := func(path string, item map[string]interface{}, known ...string) {
+		if !strict {
+			return
+		}
+		names := []string{}
+		for name := range item {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			found := false
+			for _, knownName := range known {
+				if name == knownName {
+					found = true
+				}
+			}
+			if !found {
+				report(path+"."+name, item[name], "unknown field, it isn't applied to vault")
+			}
+		}
+	}
+
+	// optionalBool reports a non-boolean field of an item
+	optionalBool := func(path string, item map[string]interface{}, name string) {
+		if value, ok := item[name]; ok {
+			if _, err := cast.ToBoolE(value); err != nil {
+				report(path+"."+name, value, "must be a boolean")
+			}
+		}
+	}
+
+	// optionalString reports a non-string field of an item
+	optionalString := func(path string, item map[string]interface{}, name string) {
+		if _, ok := item[name]; ok {
+			requiredString(path, item, name)
+		}
+	}
+
+	// optionalMap reports a non-object field of an item
+	optionalMap := func(path string, item map[string]interface{}, name string) map[string]interface{} {
+		value, ok := item[name]
+		if !ok {
+			return nil
+		}
+		m, err := cast.ToStringMapE(value)
+		if err != nil {
+			report(path+"."+name, value, "must be an object")
+		}
+		return m
+	}
+
+	if value, ok := config[namespaceField]; ok {
+		if namespace, ok := value.(string); !ok || strings.Trim(namespace, "/") == "" {
+			report(namespaceField, value, "must be a non-empty string")
+		}
+	}
+
+	if policies, ok := config["policies"]; ok {
+		for i, policy := range items("policies", policies) {
+			if policy == nil {
+				continue
+			}
+			path := fmt.Sprintf("policies[%d]", i)
+			unknownFields(path, policy, knownFields["policies"]...)
+			requiredString(path, policy, "name")
+			optionalBool(path, policy, createOnlyField)
+			optionalString(path, policy, namespaceField)
+			if rules := requiredString(path, policy, "rules"); rules != "" {
+				for _, err := range verifyPolicyRules(rules) {
+					report(path+".rules", rules, "%s", err)
+				}
+			}
+		}
+	}
+
+	if auths, ok := config["auth"]; ok {
+		for i, auth := range items("auth", auths) {
+			if auth == nil {
+				continue
+			}
+			path := fmt.Sprintf("auth[%d]", i)
+			authType := requiredString(path, auth, "type")
+			unknownFields(path, auth, append(knownFields["auth"], knownFields["auth/"+authType]...)...)
+			optionalString(path, auth, "path")
+			optionalString(path, auth, namespaceField)
+			optionalMap(path, auth, "config")
+
+			switch authType {
+			case "kubernetes", "aws", "approle":
+				roles, ok := auth["roles"]
+				if !ok {
+					report(path+".roles", nil, "required value")
+					break
+				}
+				for j, role := range items(path+".roles", roles) {
+					if role == nil {
+						continue
+					}
+					rolePath := fmt.Sprintf("%s.roles[%d]", path, j)
+					requiredString(rolePath, role, "name")
+					optionalBool(rolePath, role, createOnlyField)
+					if _, ok := role["role_id"]; ok && authType == "approle" {
+						requiredString(rolePath, role, "role_id")
+					}
+					verifyPayload(rolePath, role, report)
+				}
+			case "token":
+				if authPath, ok := auth["path"]; ok && cast.ToString(authPath) != "token" {
+					report(path+".path", authPath, "the token auth method is always enabled at token")
+				}
+				if tune := optionalMap(path, auth, authTuneField); tune != nil {
+					verifyPayload(path+"."+authTuneField, tune, report)
+				}
+				roles, ok := auth["roles"]
+				if !ok {
+					break
+				}
+				for j, role := range items(path+".roles", roles) {
+					if role == nil {
+						continue
+					}
+					rolePath := fmt.Sprintf("%s.roles[%d]", path, j)
+					requiredString(rolePath, role, "name")
+					optionalBool(rolePath, role, createOnlyField)
+					verifyPayload(rolePath, role, report)
+				}
+			case "jwt", "oidc", "gcp", "azure":
+				roles, ok := auth["roles"]
+				if !ok {
+					// The roles may be created outside of the configuration
+					break
+				}
+				for j, role := range items(path+".roles", roles) {
+					if role == nil {
+						continue
+					}
+					rolePath := fmt.Sprintf("%s.roles[%d]", path, j)
+					requiredString(rolePath, role, "name")
+					optionalBool(rolePath, role, createOnlyField)
+					// The claims are objects of the claims, the other fields are scalars
+					payload := map[string]interface{}{}
+					for name, value := range role {
+						if jwtRoleClaimFields[name] && (authType == "jwt" || authType == "oidc") {
+							if claims := optionalMap(rolePath, role, name); claims != nil {
+								verifyPayload(rolePath+"."+name, claims, report)
+							}
+							continue
+						}
+						payload[name] = value
+					}
+					verifyPayload(rolePath, payload, report)
+				}
+			case "userpass":
+				users, ok := auth["users"]
+				if !ok {
+					// The users may be managed outside of the configuration
+					break
+				}
+				for j, user := range items(path+".users", users) {
+					if user == nil {
+						continue
+					}
+					userPath := fmt.Sprintf("%s.users[%d]", path, j)
+					requiredString(userPath, user, "name")
+					optionalBool(userPath, user, createOnlyField)
+					_, hasPassword := user["password"]
+					_, hasPasswordKey := user[userPasswordKeyField]
+					switch {
+					case hasPassword && hasPasswordKey:
+						report(userPath, nil, "only one of password and %s can be set", userPasswordKeyField)
+					case hasPassword:
+						requiredString(userPath, user, "password")
+					case hasPasswordKey:
+						requiredString(userPath, user, userPasswordKeyField)
+					default:
+						report(userPath+".password", nil, "required value (or %s)", userPasswordKeyField)
+					}
+					verifyPayload(userPath, user, report)
+				}
+			case "github":
+				for mappingType, mapping := range optionalMap(path, auth, "map") {
+					if _, err := cast.ToStringMapStringE(mapping); err != nil {
+						report(path+".map."+mappingType, mapping, "must be an object of policies")
+					}
+				}
+			case "cert":
+				certs, ok := auth["certs"]
+				if !ok {
+					// The certificates may be trusted outside of the configuration
+					break
+				}
+				for j, cert := range items(path+".certs", certs) {
+					if cert == nil {
+						continue
+					}
+					certPath := fmt.Sprintf("%s.certs[%d]", path, j)
+					requiredString(certPath, cert, "name")
+					requiredString(certPath, cert, "certificate")
+					optionalBool(certPath, cert, createOnlyField)
+					verifyPayload(certPath, cert, report)
+				}
+			case "ldap", "okta", "radius":
+				for _, mappingType := range authMappingTypes[authType] {
+					for name, mapping := range optionalMap(path, auth, mappingType) {
+						mappingPath := path + "." + mappingType + "." + name
+						mappingMap, err := cast.ToStringMapE(mapping)
+						if err != nil {
+							report(mappingPath, mapping, "must be an object")
+							continue
+						}
+						verifyPayload(mappingPath, mappingMap, report)
+					}
+				}
+			}
+		}
+	}
+
+	if secrets, ok := config["secrets"]; ok {
+		for i, secret := range items("secrets", secrets) {
+			if secret == nil {
+				continue
+			}
+			path := fmt.Sprintf("secrets[%d]", i)
+			unknownFields(path, secret, knownFields["secrets"]...)
+			requiredString(path, secret, "type")
+			for _, name := range []string{"path", "description", "plugin_name", migrateFromField, namespaceField} {
+				optionalString(path, secret, name)
+			}
+			if _, ok := secret[secretEngineStateField]; ok {
+				if state := requiredString(path, secret, secretEngineStateField); state != "" && state != "present" && state != secretEngineAbsent {
+					report(path+"."+secretEngineStateField, state, "must be present or absent")
+				}
+			}
+			if options := optionalMap(path, secret, "options"); options != nil {
+				verifyPayload(path+".options", options, report)
+			}
+			if version, ok := secret[kvVersionField]; ok {
+				if secret["type"] != "kv" {
+					report(path+"."+kvVersionField, version, "can be set only on a kv secret engine")
+				} else if version := cast.ToString(version); version != "1" && version != "2" {
+					report(path+"."+kvVersionField, version, "must be 1 or 2")
+				}
+			}
+			if pki := optionalMap(path, secret, pkiField); pki != nil {
+				pkiPath := path + "." + pkiField
+				if secret["type"] != "pki" {
+					report(pkiPath, pki, "can be set only on a pki secret engine")
+				}
+				unknownFields(pkiPath, pki, "ca", "urls", "roles")
+				if ca := optionalMap(pkiPath, pki, "ca"); ca != nil {
+					caPath := pkiPath + ".ca"
+					caType := pkiCARoot
+					if _, ok := ca["type"]; ok {
+						caType = requiredString(caPath, ca, "type")
+					}
+					switch {
+					case caType != pkiCARoot && caType != pkiCAIntermediate:
+						report(caPath+".type", caType, "must be root or intermediate")
+					case ca["pem_bundle"] != nil:
+						requiredString(caPath, ca, "pem_bundle")
+					case caType == pkiCAIntermediate:
+						requiredString(caPath, ca, "signed_by")
+						requiredString(caPath, ca, "common_name")
+					default:
+						requiredString(caPath, ca, "common_name")
+					}
+					verifyPayload(caPath, ca, report)
+				}
+				if urls := optionalMap(pkiPath, pki, "urls"); urls != nil {
+					verifyPayload(pkiPath+".urls", urls, report)
+				}
+				if roles, ok := pki["roles"]; ok {
+					for j, role := range items(pkiPath+".roles", roles) {
+						if role == nil {
+							continue
+						}
+						rolePath := fmt.Sprintf("%s.roles[%d]", pkiPath, j)
+						requiredString(rolePath, role, "name")
+						optionalBool(rolePath, role, createOnlyField)
+						verifyPayload(rolePath, role, report)
+					}
+				}
+			}
+			if ssh := optionalMap(path, secret, sshField); ssh != nil {
+				sshPath := path + "." + sshField
+				if secret["type"] != "ssh" {
+					report(sshPath, ssh, "can be set only on an ssh secret engine")
+				}
+				unknownFields(sshPath, ssh, "ca", "roles")
+				if ca := optionalMap(sshPath, ssh, "ca"); ca != nil {
+					caPath := sshPath + ".ca"
+					if ca["private_key"] != nil || ca["public_key"] != nil {
+						requiredString(caPath, ca, "private_key")
+						requiredString(caPath, ca, "public_key")
+					}
+					verifyPayload(caPath, ca, report)
+				}
+				if roles, ok := ssh["roles"]; ok {
+					for j, role := range items(sshPath+".roles", roles) {
+						if role == nil {
+							continue
+						}
+						rolePath := fmt.Sprintf("%s.roles[%d]", sshPath, j)
+						requiredString(rolePath, role, "name")
+						if keyType := requiredString(rolePath, role, "key_type"); keyType != "" && keyType != sshRoleCA && keyType != sshRoleOTP {
+							report(rolePath+".key_type", keyType, "must be ca or otp")
+						} else if keyType == sshRoleOTP {
+							requiredString(rolePath, role, "default_user")
+						}
+						optionalBool(rolePath, role, createOnlyField)
+						verifyPayload(rolePath, role, report)
+					}
+				}
+			}
+			database := secret["type"] == "database"
+			for configOption, configData := range optionalMap(path, secret, "configuration") {
+				configPath := path + ".configuration." + configOption
+				for j, item := range items(configPath, configData) {
+					if item == nil {
+						continue
+					}
+					itemPath := fmt.Sprintf("%s[%d]", configPath, j)
+					requiredString(itemPath, item, "name")
+					optionalBool(itemPath, item, createOnlyField)
+					if _, ok := item[rotateRootField]; ok && (!database || configOption != "config") {
+						report(itemPath+"."+rotateRootField, item[rotateRootField], "can be set only on the connections (config) of a database secret engine")
+					} else {
+						optionalBool(itemPath, item, rotateRootField)
+					}
+					// The connections and the roles of the database secret engines
+					switch {
+					case database && configOption == "config":
+						requiredString(itemPath, item, "plugin_name")
+					case database && (configOption == "roles" || configOption == "static-roles"):
+						requiredString(itemPath, item, "db_name")
+					}
+					verifyPayload(itemPath, item, report)
+				}
+			}
+		}
+	}
+
+	if audits, ok := config["audit"]; ok {
+		for i, audit := range items("audit", audits) {
+			if audit == nil {
+				continue
+			}
+			path := fmt.Sprintf("audit[%d]", i)
+			unknownFields(path, audit, knownFields["audit"]...)
+			requiredString(path, audit, "type")
+			for _, name := range []string{"path", "description"} {
+				if _, ok := audit[name]; ok {
+					requiredString(path, audit, name)
+				}
+			}
+			optionalBool(path, audit, "local")
+			if options := optionalMap(path, audit, "options"); options != nil {
+				verifyPayload(path+".options", options, report)
+			}
+		}
+	}
+
+	if value, ok := config["sys"]; ok {
+		if sys, err := cast.ToStringMapE(value); err != nil {
+			report("sys", value, "must be an object")
+		} else {
+			unknownFields("sys", sys, sysFields...)
+			if headers, ok := sys[sysAuditedHeadersField]; ok {
+				for i, header := range items("sys."+sysAuditedHeadersField, headers) {
+					if header == nil {
+						continue
+					}
+					path := fmt.Sprintf("sys.%s[%d]", sysAuditedHeadersField, i)
+					unknownFields(path, header, "name", "hmac")
+					requiredString(path, header, "name")
+					optionalBool(path, header, "hmac")
+				}
+			}
+			if cors := optionalMap("sys", sys, sysCORSField); cors != nil {
+				optionalBool("sys."+sysCORSField, cors, "enabled")
+				verifyPayload("sys."+sysCORSField, cors, report)
+			}
+			if uiHeaders := optionalMap("sys", sys, sysUIHeadersField); uiHeaders != nil {
+				verifyPayload("sys."+sysUIHeadersField, uiHeaders, report)
+			}
+			tune := optionalMap("sys", sys, sysTuneField)
+			for _, mount := range sortedKeys(tune) {
+				if settings := optionalMap("sys."+sysTuneField, tune, mount); settings != nil {
+					verifyPayload("sys."+sysTuneField+"."+mount, settings, report)
+				}
+			}
+			if controlGroup := optionalMap("sys", sys, sysControlGroupField); controlGroup != nil {
+				verifyPayload("sys."+sysControlGroupField, controlGroup, report)
+			}
+		}
+	}
+
+	if value, ok := config["identity"]; ok {
+		if identity, err := cast.ToStringMapE(value); err != nil {
+			report("identity", value, "must be an object")
+		} else {
+			unknownFields("identity", identity, identityFields...)
+			// verifyAlias checks an alias of an entity or a group
+			verifyAlias := func(path string, alias map[string]interface{}) {
+				unknownFields(path, alias, "name", "mount")
+				requiredString(path, alias, "name")
+				requiredString(path, alias, "mount")
+			}
+			// verifyFields checks the fields of an entity or a group written to Vault
+			verifyFields := func(path string, item map[string]interface{}, kind string) {
+				unknownFields(path, item, knownFields["identity/"+kind]...)
+				requiredString(path, item, "name")
+				optionalBool(path, item, "disabled")
+				if metadata := optionalMap(path, item, "metadata"); metadata != nil {
+					verifyPayload(path+".metadata", metadata, report)
+				}
+				fields := map[string]interface{}{}
+				for _, field := range []string{"policies", identityMemberEntitiesField, identityMemberGroupsField} {
+					if value, ok := item[field]; ok {
+						fields[field] = value
+					}
+				}
+				verifyPayload(path, fields, report)
+			}
+
+			if entities, ok := identity[identityEntitiesField]; ok {
+				for i, entity := range items("identity."+identityEntitiesField, entities) {
+					if entity == nil {
+						continue
+					}
+					path := fmt.Sprintf("identity.%s[%d]", identityEntitiesField, i)
+					verifyFields(path, entity, "entity")
+					if aliases, ok := entity[identityAliasesField]; ok {
+						for j, alias := range items(path+"."+identityAliasesField, aliases) {
+							if alias != nil {
+								verifyAlias(fmt.Sprintf("%s.%s[%d]", path, identityAliasesField, j), alias)
+							}
+						}
+					}
+				}
+			}
+			if groups, ok := identity[identityGroupsField]; ok {
+				for i, group := range items("identity."+identityGroupsField, groups) {
+					if group == nil {
+						continue
+					}
+					path := fmt.Sprintf("identity.%s[%d]", identityGroupsField, i)
+					verifyFields(path, group, "group")
+					groupType := "internal"
+					if _, ok := group["type"]; ok {
+						groupType = requiredString(path, group, "type")
+					}
+					switch groupType {
+					case "internal":
+						if _, ok := group[identityAliasField]; ok {
+							report(path+"."+identityAliasField, group[identityAliasField], "only an external group can have an alias")
+						}
+					case "external":
+						for _, field := range []string{identityMemberEntitiesField, identityMemberGroupsField} {
+							if _, ok := group[field]; ok {
+								report(path+"."+field, group[field], "the members of an external group are managed by its auth method")
+							}
+						}
+					default:
+						report(path+".type", group["type"], "must be internal or external")
+					}
+					if alias := optionalMap(path, group, identityAliasField); alias != nil {
+						verifyAlias(path+"."+identityAliasField, alias)
+					}
+				}
+			}
+		}
+	}
+
+	if value, ok := config[raftSection]; ok {
+		if raft, err := cast.ToStringMapE(value); err != nil {
+			report(raftSection, value, "must be an object")
+		} else {
+			unknownFields(raftSection, raft, "join", "snapshots")
+			if join := optionalMap(raftSection, raft, "join"); join != nil {
+				unknownFields(raftSection+".join", join, "leader_ca_cert", "leader_client_cert", "leader_client_key", "retry")
+				optionalBool(raftSection+".join", join, "retry")
+			}
+			if snapshots := optionalMap(raftSection, raft, "snapshots"); snapshots != nil {
+				path := raftSection + ".snapshots"
+				unknownFields(path, snapshots, "period", "location")
+				options := RaftSnapshotOptions{Period: requiredString(path, snapshots, "period"), Location: requiredString(path, snapshots, "location")}
+				if _, err := options.PeriodDuration(); err != nil && options.Period != "" {
+					report(path+".period", options.Period, "%s", err.Error())
+				}
+				if _, err := options.SnapshotLocation(time.Time{}); err != nil && options.Location != "" {
+					report(path+".location", options.Location, "%s", err.Error())
+				}
+			}
+		}
+	}
+
+	if startupSecrets, ok := config["startupSecrets"]; ok {
+		for i, secret := range items("startupSecrets", startupSecrets) {
+			if secret == nil {
+				continue
+			}
+			path := fmt.Sprintf("startupSecrets[%d]", i)
+			unknownFields(path, secret, knownFields["startupSecrets"]...)
+			requiredString(path, secret, "path")
+			optionalBool(path, secret, casField)
+			optionalString(path, secret, namespaceField)
+			if optionalMap(path, secret, "data") == nil {
+				report(path+".data", nil, "is required")
+			}
+		}
+	}
+
+	return errs
+}
+
+// policyFields are the fields of the auth roles, users and LDAP mappings listing their policies, the
+// token roles list the policies their tokens may have
+var policyFields = []string{"policies", "token_policies", "allowed_policies"}
+
+// VerifyPolicyReferences reports the policies referenced by the auth roles, users and mappings, and
+// by the identity entities and groups of the external configuration which aren't defined in its
+// policies section (or built into Vault), in the order of the auth methods. The policies are looked
+// up in the namespace of the auth method. The policies may be managed outside of the configuration,
+// so it isn't a part of VerifyConfig.
+func VerifyPolicyReferences(config map[string]interface{}) []*ConfigError {
+	var errs []*ConfigError
+	report := func(path string, value interface{}, format string, args ...interface{}) {
+		errs = append(errs, &ConfigError{Path: path, Value: value, Message: fmt.Sprintf(format, args...)})
+	}
+	config, _, err := MigrateConfig(config)
+	if err != nil {
+		return nil
+	}
+
+	// The policies of the namespaces, the built-in ones are in every namespace
+	namespaces := &ExternalConfig{Namespace: cast.ToString(config[namespaceField])}
+	defined := map[string]map[string]bool{}
+	for _, policy := range cast.ToSlice(config["policies"]) {
+		policy := cast.ToStringMap(policy)
+		namespace := namespaces.namespaceOf(policy[namespaceField])
+		if defined[namespace] == nil {
+			defined[namespace] = map[string]bool{}
+		}
+		defined[namespace][cast.ToString(policy["name"])] = true
+	}
+	namespace := ""
+
+	// check reports the undefined policies of a comma separated list or a list of policies
+	check := func(path string, value interface{}) {
+		policies := cast.ToStringSlice(value)
+		if s, ok := value.(string); ok {
+			policies = strings.Split(s, ",")
+		}
+		for _, policy := range policies {
+			if policy = strings.TrimSpace(policy); policy != "" && !builtinPolicies[policy] && !defined[namespace][policy] {
+				report(path, value, "policy %s isn't defined in the policies", policy)
+			}
+		}
+	}
+	checkFields := func(path string, item map[string]interface{}) {
+		for _, field := range policyFields {
+			if value, ok := item[field]; ok {
+				check(path+"."+field, value)
+			}
+		}
+	}
+
+	for i, auth := range cast.ToSlice(config["auth"]) {
+		auth := cast.ToStringMap(auth)
+		path := fmt.Sprintf("auth[%d]", i)
+		namespace = namespaces.namespaceOf(auth[namespaceField])
+		for _, list := range []string{"roles", "users", "certs"} {
+			for j, item := range cast.ToSlice(auth[list]) {
+				checkFields(fmt.Sprintf("%s.%s[%d]", path, list, j), cast.ToStringMap(item))
+			}
+		}
+		switch auth["type"] {
+		case "github":
+			mappings := cast.ToStringMap(auth["map"])
+			for _, mappingType := range sortedKeys(mappings) {
+				mapping := cast.ToStringMap(mappings[mappingType])
+				for _, name := range sortedKeys(mapping) {
+					check(path+".map."+mappingType+"."+name, mapping[name])
+				}
+			}
+		case "ldap", "okta", "radius":
+			for _, mappingType := range authMappingTypes[cast.ToString(auth["type"])] {
+				mappings := cast.ToStringMap(auth[mappingType])
+				for _, name := range sortedKeys(mappings) {
+					checkFields(path+"."+mappingType+"."+name, cast.ToStringMap(mappings[name]))
+				}
+			}
+		}
+	}
+
+	// The entities and groups are in the namespace of the configuration
+	namespace = namespaces.namespaceOf(nil)
+	identity := cast.ToStringMap(config["identity"])
+	for _, field := range identityFields {
+		for i, item := range cast.ToSlice(identity[field]) {
+			if policies, ok := cast.ToStringMap(item)["policies"]; ok {
+				check(fmt.Sprintf("identity.%s[%d].policies", field, i), policies)
+			}
+		}
+	}
+	return errs
+}
+
+// verifyPayload reports the fields of a payload written to Vault which are not scalars or lists of scalars
+func verifyPayload(path string, payload map[string]interface{}, report func(string, interface{}, string, ...interface{})) {
+	isScalar := func(value interface{}) bool {
+		switch value.(type) {
+		case string, bool, int, int64, float64, json.Number, nil:
+			return true
+		}
+		return false
+	}
+
+	for name, value := range payload {
+		if isScalar(value) {
+			continue
+		}
+		if list, ok := value.([]interface{}); ok {
+			for _, item := range list {
+				if !isScalar(item) {
+					report(path+"."+name, value, "must be a scalar or a list of scalars")
+					break
+				}
+			}
+			continue
+		}
+		report(path+"."+name, value, "must be a scalar or a list of scalars")
+	}
+}
+
+// verifyPolicyRules parses the HCL rules of a policy and checks the granted capabilities
+func verifyPolicyRules(rules string) []string {
+	file, err := hcl.ParseString(rules)
+	if err != nil {
+		return []string{fmt.Sprintf("invalid policy: %s", err.Error())}
+	}
+	list, ok := file.Node.(*ast.ObjectList)
+	if !ok {
+		return []string{"invalid policy: must be a list of path rules"}
+	}
+
+	var errs []string
+	for _, item := range list.Items {
+		key := item.Keys[0].Token.Value()
+		if key != "path" {
+			errs = append(errs, fmt.Sprintf("invalid policy: unsupported key %v", key))
+			continue
+		}
+		if len(item.Keys) != 2 {
+			errs = append(errs, "invalid policy: path rules must have exactly one path")
+			continue
+		}
+		pathName := item.Keys[1].Token.Value()
+
+		var pathRules struct {
+			Policy       string   `hcl:"policy"`
+			Capabilities []string `hcl:"capabilities"`
+		}
+		if err := hcl.DecodeObject(&pathRules, item.Val); err != nil {
+			errs = append(errs, fmt.Sprintf("invalid policy for path %v: %s", pathName, err.Error()))
+			continue
+		}
+		if pathRules.Policy == "" && len(pathRules.Capabilities) == 0 {
+			errs = append(errs, fmt.Sprintf("invalid policy for path %v: no capabilities granted", pathName))
+		}
+		for _, capability := range pathRules.Capabilities {
+			if !policyCapabilities[capability] {
+				errs = append(errs, fmt.Sprintf("invalid policy for path %v: unknown capability %s", pathName, capability))
+			}
+		}
+	}
+	return errs
+}
@@ -0,0 +1,205 @@
+package vault
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/banzaicloud/bank-vaults/pkg/logging"
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+)
+
+// ConfigSource returns the current external configuration, it is called by Reconcile every time, so
+// the changes of the configuration (e.g. of a file) are picked up
+type ConfigSource func() (*ExternalConfig, error)
+
+// FileConfigSource reads the YAML/JSON/HCL configuration file (by its extension) with the
+// VAULT_CONFIG_* overrides of the environment, see OverrideConfigFromEnv. The file may be a
+// directory of configuration files too, see FilesConfigSource.
+func FileConfigSource(file string) ConfigSource {
+	return FilesConfigSource(file)
+}
+
+// FilesConfigSource reads the configuration split into several YAML/JSON/HCL files and directories
+// of them (see ConfigFiles), merged by MergeConfigFragments, with the VAULT_CONFIG_* overrides of
+// the environment
+func FilesConfigSource(paths ...string) ConfigSource {
+	return func() (*ExternalConfig, error) {
+		files, err := ConfigFiles(paths)
+		if err != nil {
+			return nil, err
+		}
+		var fragments []ConfigFragment
+		for _, file := range files {
+			cfg := viper.New()
+			cfg.SetConfigFile(file)
+			if err := cfg.ReadInConfig(); err != nil {
+				return nil, fmt.Errorf("error reading vault config file: %s", err.Error())
+			}
+			fragments = append(fragments, ConfigFragment{Source: file, Settings: cfg.AllSettings()})
+		}
+		settings, err := MergeConfigFragments(fragments)
+		if err != nil {
+			return nil, fmt.Errorf("error merging vault config files: %s", err.Error())
+		}
+		settings, err = OverrideConfigFromEnv(settings, os.Environ())
+		if err != nil {
+			return nil, err
+		}
+		return ParseExternalConfig(settings)
+	}
+}
+
+// ConfigChangePeriodic is the name of the events of the periodic reconciliations of NotifyConfigChanges
+const ConfigChangePeriodic = "Periodic"
+
+// NotifyConfigChanges sends an event whenever the configuration file is written or replaced, and
+// every period besides (never if 0), until the context is done. The directory of the file is
+// watched, so the renames of atomic saves and the updates of a mounted Kubernetes ConfigMap (which
+// replace its ..data symlink) are picked up. The file may be a directory of configuration files,
+// every configuration file in it is watched then.
+func NotifyConfigChanges(ctx context.Context, file string, period time.Duration) (<-chan fsnotify.Event, error) {
+	return NotifyConfigFilesChanges(ctx, []string{file}, period)
+}
+
+// NotifyConfigFilesChanges is NotifyConfigChanges of several configuration files and directories
+func NotifyConfigFilesChanges(ctx context.Context, paths []string, period time.Duration) (<-chan fsnotify.Event, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	configFiles := map[string]bool{}
+	configDirs := map[string]bool{}
+	for _, path := range paths {
+		path = filepath.Clean(path)
+		watched := filepath.Dir(path)
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			configDirs[path] = true
+			watched = path
+		} else {
+			configFiles[path] = true
+		}
+		if err := watcher.Add(watched); err != nil {
+			watcher.Close()
+			return nil, fmt.Errorf("error watching %s: %s", watched, err.Error())
+		}
+	}
+	relevant := func(name string) bool {
+		name = filepath.Clean(name)
+		if configFiles[name] || filepath.Base(name) == "..data" {
+			return true
+		}
+		return configDirs[filepath.Dir(name)] && configFileExtensions[filepath.Ext(name)]
+	}
+
+	var tick <-chan time.Time
+	if period > 0 {
+		ticker := time.NewTicker(period)
+		tick = ticker.C
+		go func() {
+			<-ctx.Done()
+			ticker.Stop()
+		}()
+	}
+
+	changes := make(chan fsnotify.Event, 1)
+	send := func(event fsnotify.Event) {
+		select {
+		case changes <- event:
+		case <-ctx.Done():
+		}
+	}
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event := <-watcher.Events:
+				// Only the config files or the ConfigMap directory (if in Kubernetes) are relevant,
+				// the files removed from a directory of config files too
+				if relevant(event.Name) {
+					if event.Op&(fsnotify.Write|fsnotify.Create) != 0 || (!configFiles[filepath.Clean(event.Name)] && event.Op&fsnotify.Remove != 0) {
+						send(event)
+					}
+				}
+			case err := <-watcher.Errors:
+				logging.Default().Errorf("error watching %s: %s", strings.Join(paths, ", "), err.Error())
+			case <-tick:
+				send(fsnotify.Event{Name: ConfigChangePeriodic, Op: fsnotify.Write})
+			}
+		}
+	}()
+	return changes, nil
+}
+
+// WatchOptions are the settings of Watch
+type WatchOptions struct {
+	// File is the configuration file (or directory of them) watched for changes, only the Period
+	// triggers the reconciliations if empty
+	File string
+	// Period is how often the configuration is reapplied besides the changes of the file, e.g. to
+	// revert the changes made by hand, never if 0
+	Period time.Duration
+	// Reconciled is called with the outcome of every reconciliation, optional
+	Reconciled func(report *ConfigureReport, err error)
+}
+
+// Reconcile reads the configuration from the source and applies it with ConfigureWithReport
+func (v *vault) Reconcile(source ConfigSource) (*ConfigureReport, error) {
+	config, err := source()
+	if err != nil {
+		return nil, err
+	}
+	return v.ConfigureWithReport(config)
+}
+
+// Watch reconciles the configuration of the source once, then whenever the file of the options
+// changes and every period, until the context is done. The failed reconciliations are logged (and
+// passed to the Reconciled callback), and retried on the next change or period.
+func (v *vault) Watch(ctx context.Context, source ConfigSource, options WatchOptions) error {
+	var changes <-chan fsnotify.Event
+	var tick <-chan time.Time
+	if options.File != "" {
+		var err error
+		if changes, err = NotifyConfigChanges(ctx, options.File, options.Period); err != nil {
+			return err
+		}
+	} else if options.Period > 0 {
+		ticker := time.NewTicker(options.Period)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	reconcile := func(reason string) {
+		if ConfigurePaused() {
+			v.logger().Infof("configuration is paused, skipping the reconciliation (%s)", reason)
+			return
+		}
+		v.logger().Infof("reconciling vault configuration (%s)...", reason)
+		report, err := v.Reconcile(source)
+		if err != nil {
+			v.logger().Errorf("error reconciling vault configuration: %s", err.Error())
+		}
+		if options.Reconciled != nil {
+			options.Reconciled(report, err)
+		}
+	}
+
+	reconcile("initial")
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event := <-changes:
+			reconcile(event.String())
+		case <-tick:
+			reconcile(ConfigChangePeriodic)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package vault
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/banzaicloud/bank-vaults/pkg/logging"
+	"github.com/hashicorp/vault/api"
+)
+
+// WrappedKey is an unseal or recovery key of Init handed off in a response-wrapping token instead of
+// being stored, the key is in the cubbyhole of the token until it is unwrapped or the token expires
+type WrappedKey struct {
+	// Index is the index of the key among the keys of the initialization
+	Index int `json:"index"`
+	// Recovery is true if it is a recovery key (of an auto-unseal seal)
+	Recovery bool `json:"recovery"`
+	// Token is the single-use wrapping token, `vault unwrap <token>` returns the key in its key field
+	Token string `json:"token"`
+	// Accessor is the accessor of the wrapping token, to look it up or revoke it without using it
+	Accessor string `json:"accessor"`
+	// Expiration is when the wrapping token expires, the key is lost if it hasn't been unwrapped by then
+	Expiration time.Time `json:"expiration"`
+}
+
+// handOffKeys response-wraps the keys of the initialization with the initial root token instead of
+// storing them. Vault has to be unsealed to wrap them: it is unsealed with the unseal keys right
+// away, or waited for to unseal itself with an auto-unseal seal.
+func (v *vault) handOffKeys(ctx context.Context, resp *api.InitResponse, recovery bool) ([]WrappedKey, error) {
+	keys := resp.Keys
+	if recovery {
+		keys = resp.RecoveryKeys
+	}
+	if err := v.unsealInitialized(ctx, resp, recovery, "wrap the keys"); err != nil {
+		return nil, err
+	}
+
+	v.cl.SetToken(resp.RootToken)
+	defer v.cl.SetToken("")
+
+	wrapped := make([]WrappedKey, 0, len(keys))
+	for i, key := range keys {
+		var wrapInfo *api.SecretWrapInfo
+		err := v.retry(fmt.Sprintf("wrapping key %d", i), func() (err error) {
+			wrapInfo, err = v.wrapKey(key)
+			return err
+		})
+		if err != nil {
+			// The keys wrapped so far are lost with the error, their tokens expire eventually
+			return nil, fmt.Errorf("error wrapping key %d, vault has to be initialized again: %s", i, err.Error())
+		}
+		logging.RegisterSecret(wrapInfo.Token)
+
+		wrapped = append(wrapped, WrappedKey{
+			Index:      i,
+			Recovery:   recovery,
+			Token:      wrapInfo.Token,
+			Accessor:   wrapInfo.Accessor,
+			Expiration: wrapInfo.CreationTime.Add(time.Duration(wrapInfo.TTL) * time.Second),
+		})
+		v.logger().WithField("accessor", wrapInfo.Accessor).Infof("key %d wrapped for %s", i, v.config.WrapKeysTTL)
+	}
+	return wrapped, nil
+}
+
+// unsealInitialized unseals Vault with the unseal keys of the initialization right away, instead of
+// waiting for the unsealer, which may run in the same goroutine after Init. With an auto-unseal seal
+// Vault unseals itself, it is waited for.
+func (v *vault) unsealInitialized(ctx context.Context, resp *api.InitResponse, recovery bool, what string) error {
+	if recovery {
+		v.logger().Infof("waiting for vault to be unsealed to %s", what)
+		return v.waitUnsealed(ctx, what)
+	}
+	v.logger().Infof("unsealing vault to %s", what)
+	return v.unsealWithKeys(resp.Keys)
+}
+
+// unsealWithKeys unseals Vault with the keys of the initialization, which may not have been stored
+func (v *vault) unsealWithKeys(keys []string) error {
+	for _, key := range keys {
+		var resp *api.SealStatusResponse
+		err := v.retry("unseal request", func() (err error) {
+			resp, err = v.cl.Sys().Unseal(key)
+			return err
+		})
+		if err != nil {
+			return newError(ErrUnsealFailed, err, "fail to send unseal request to vault")
+		}
+		if !resp.Sealed {
+			return nil
+		}
+	}
+	return newError(ErrUnsealFailed, nil, "failed to unseal vault with the keys of the initialization")
+}
+
+// wrapKey wraps the key in a response-wrapping token of WrapKeysTTL (sys/wrapping/wrap)
+func (v *vault) wrapKey(key string) (*api.SecretWrapInfo, error) {
+	req := v.cl.NewRequest("POST", "/v1/sys/wrapping/wrap")
+	req.WrapTTL = strconv.Itoa(int(v.config.WrapKeysTTL.Seconds())) + "s"
+	if err := req.SetJSONBody(map[string]interface{}{"key": key}); err != nil {
+		return nil, err
+	}
+	resp, err := v.cl.RawRequest(req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := api.ParseSecret(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.WrapInfo == nil || secret.WrapInfo.Token == "" {
+		return nil, fmt.Errorf("no wrapping token in the response")
+	}
+	return secret.WrapInfo, nil
+}